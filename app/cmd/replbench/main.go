@@ -0,0 +1,223 @@
+// Command replbench measures what batching the replication stream trades:
+// master throughput against replication lag. For each repl-batch-usec value
+// it sets the master's CONFIG, runs a SET workload from several clients
+// while a probe times how long single writes take to show up on the
+// replica, and prints one line per value.
+//
+//	replbench -master localhost:6379 -replica localhost:6380 -batch 0,100,1000
+//
+// writes/cmd is how many replica writes each propagated command cost, from
+// INFO replication; 1.00 means no batching took place.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/pkg/client"
+)
+
+func dial(addr string) (*client.Client, error) {
+	c, err := client.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	c.SetTimeout(30 * time.Second)
+	return c, nil
+}
+
+// feedCounters reads repl_feed_commands and repl_feed_writes from INFO
+func feedCounters(c *client.Client) (commands, writes int64, err error) {
+	reply, err := c.Do("INFO", "replication")
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, line := range strings.Split(reply.Str, "\r\n") {
+		name, value, _ := strings.Cut(line, ":")
+		switch name {
+		case "repl_feed_commands":
+			commands, _ = strconv.ParseInt(value, 10, 64)
+		case "repl_feed_writes":
+			writes, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+	return commands, writes, nil
+}
+
+// load sends requests SETs over one connection, pipeline at a time
+func load(addr string, id, requests, pipeline int) error {
+	c, err := dial(addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	p := c.Pipeline()
+	for sent := 0; sent < requests; {
+		n := min(pipeline, requests-sent)
+		for i := 0; i < n; i++ {
+			p.Do("SET", fmt.Sprintf("replbench:%d:%d", id, (sent+i)%1000), "x")
+		}
+		if _, err := p.Exec(); err != nil {
+			return err
+		}
+		sent += n
+	}
+	return nil
+}
+
+// probe writes a counter to the master until stop is closed and times how
+// long each value takes to be readable on the replica
+func probe(master, replica *client.Client, stop <-chan struct{}) ([]time.Duration, error) {
+	var lags []time.Duration
+	for n := 0; ; n++ {
+		select {
+		case <-stop:
+			return lags, nil
+		default:
+		}
+
+		value := strconv.Itoa(n)
+		start := time.Now()
+		if _, err := master.Do("SET", "replbench:probe", value); err != nil {
+			return lags, err
+		}
+		for {
+			reply, err := replica.Do("GET", "replbench:probe")
+			if err != nil {
+				return lags, err
+			}
+			if reply.Str == value {
+				break
+			}
+			if time.Since(start) > 10*time.Second {
+				return lags, fmt.Errorf("replica did not receive probe %d within 10s", n)
+			}
+		}
+		lags = append(lags, time.Since(start))
+	}
+}
+
+// percentile returns the p-th percentile of sorted durations
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(float64(len(sorted)-1)*p)]
+}
+
+// run measures one batch delay
+func run(masterAddr string, master, replica *client.Client, batch, clients, requests, pipeline int) error {
+	if _, err := master.Do("CONFIG", "SET", "repl-batch-usec", strconv.Itoa(batch)); err != nil {
+		return err
+	}
+	commandsBefore, writesBefore, err := feedCounters(master)
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	var lags []time.Duration
+	var probeErr error
+	probed := make(chan struct{})
+	go func() {
+		lags, probeErr = probe(master, replica, stop)
+		close(probed)
+	}()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	errs := make(chan error, clients)
+	for id := 0; id < clients; id++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			if err := load(masterAddr, id, requests/clients, pipeline); err != nil {
+				errs <- err
+			}
+		}(id)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	close(stop)
+	<-probed
+	close(errs)
+	if err := <-errs; err != nil {
+		return err
+	}
+	if probeErr != nil {
+		return probeErr
+	}
+
+	commandsAfter, writesAfter, err := feedCounters(master)
+	if err != nil {
+		return err
+	}
+	writesPerCommand := 0.0
+	if commands := commandsAfter - commandsBefore; commands > 0 {
+		writesPerCommand = float64(writesAfter-writesBefore) / float64(commands)
+	}
+
+	sort.Slice(lags, func(i, j int) bool { return lags[i] < lags[j] })
+	fmt.Printf("%10d %12.0f %10.2f %10v %10v %10v\n",
+		batch,
+		float64(requests/clients*clients)/elapsed.Seconds(),
+		writesPerCommand,
+		percentile(lags, 0.5).Round(time.Microsecond),
+		percentile(lags, 0.99).Round(time.Microsecond),
+		percentile(lags, 1).Round(time.Microsecond))
+	return nil
+}
+
+func main() {
+	masterAddr := flag.String("master", "localhost:6379", "Address of the master")
+	replicaAddr := flag.String("replica", "localhost:6380", "Address of a replica of the master")
+	batches := flag.String("batch", "0,50,200,1000", "Comma-separated repl-batch-usec values to measure")
+	clients := flag.Int("clients", 16, "Concurrent client connections")
+	requests := flag.Int("requests", 200000, "SETs per measurement, split across the clients")
+	pipeline := flag.Int("pipeline", 1, "Commands each client sends before reading replies")
+	flag.Parse()
+
+	master, err := dial(*masterAddr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replbench: %s: %v\n", *masterAddr, err)
+		os.Exit(2)
+	}
+	replica, err := dial(*replicaAddr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replbench: %s: %v\n", *replicaAddr, err)
+		os.Exit(2)
+	}
+
+	reply, err := master.Do("CONFIG", "GET", "repl-batch-usec")
+	if err != nil || len(reply.Strings()) != 2 {
+		fmt.Fprintf(os.Stderr, "replbench: %s does not support repl-batch-usec: %v\n", *masterAddr, err)
+		os.Exit(2)
+	}
+
+	fmt.Printf("%10s %12s %10s %10s %10s %10s\n", "batch-usec", "ops/sec", "writes/cmd", "lag-p50", "lag-p99", "lag-max")
+	code := 0
+	for _, field := range strings.Split(*batches, ",") {
+		batch, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || batch < 0 {
+			fmt.Fprintf(os.Stderr, "replbench: invalid batch %q\n", field)
+			code = 2
+			break
+		}
+		if err := run(*masterAddr, master, replica, batch, *clients, *requests, *pipeline); err != nil {
+			fmt.Fprintf(os.Stderr, "replbench: %v\n", err)
+			code = 1
+			break
+		}
+	}
+
+	// Put the master's setting back
+	master.Do("CONFIG", "SET", "repl-batch-usec", reply.Strings()[1])
+	os.Exit(code)
+}
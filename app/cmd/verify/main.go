@@ -0,0 +1,182 @@
+// Command verify checks that replicas hold the same dataset as their master.
+// It compares the DEBUG DIGEST of every server, so all of them must run with
+// --enable-debug-command, and when a replica still differs once the timeout
+// has passed it lists the keys whose DEBUG DIGEST-VALUE differs.
+//
+//	verify -master localhost:6379 -replicas localhost:6380,localhost:6381 -timeout 5s
+//
+// It exits with 0 when every replica matches, 1 when one diverged and 2 when
+// a server could not be queried, so tests can run it after a workload.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/pkg/client"
+)
+
+// maxKeyDiffs caps how many differing keys are listed per replica
+const maxKeyDiffs = 20
+
+// server is a connection to one server being verified
+type server struct {
+	addr string
+	*client.Client
+}
+
+func dial(addr string) (*server, error) {
+	c, err := client.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	c.SetTimeout(30 * time.Second)
+	return &server{addr: addr, Client: c}, nil
+}
+
+func (s *server) digest() (string, error) {
+	reply, err := s.Do("DEBUG", "DIGEST")
+	return reply.Str, err
+}
+
+// keyDigests returns the DEBUG DIGEST-VALUE of every key on the server
+func (s *server) keyDigests() (map[string]string, error) {
+	reply, err := s.Do("KEYS", "*")
+	if err != nil {
+		return nil, err
+	}
+	keys := reply.Strings()
+	digests := make(map[string]string, len(keys))
+	if len(keys) == 0 {
+		return digests, nil
+	}
+
+	reply, err = s.Do(append([]string{"DEBUG", "DIGEST-VALUE"}, keys...)...)
+	if err != nil {
+		return nil, err
+	}
+	for i, d := range reply.Strings() {
+		digests[keys[i]] = d
+	}
+	return digests, nil
+}
+
+// diffKeys lists the keys whose value digests differ between master and
+// replica, including keys only one of them has
+func diffKeys(master, replica map[string]string) []string {
+	var diff []string
+	for key, d := range master {
+		if replica[key] != d {
+			diff = append(diff, key)
+		}
+	}
+	for key := range replica {
+		if _, ok := master[key]; !ok {
+			diff = append(diff, key)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+// describe renders a value digest for the report
+func describe(digests map[string]string, key string) string {
+	if d, ok := digests[key]; ok {
+		return d
+	}
+	return "(missing)"
+}
+
+func main() {
+	masterAddr := flag.String("master", "localhost:6379", "Address of the master")
+	replicaAddrs := flag.String("replicas", "", "Comma-separated addresses of the replicas to check")
+	timeout := flag.Duration("timeout", 5*time.Second, "How long replicas may take to catch up before they count as diverged")
+	flag.Parse()
+
+	if *replicaAddrs == "" {
+		fmt.Fprintln(os.Stderr, "verify: -replicas is required")
+		os.Exit(2)
+	}
+	if err := run(*masterAddr, strings.Split(*replicaAddrs, ","), *timeout); err != nil {
+		fmt.Fprintln(os.Stderr, "verify:", err)
+		if errors.Is(err, errDiverged) {
+			os.Exit(1)
+		}
+		os.Exit(2)
+	}
+}
+
+var errDiverged = errors.New("replicas diverged from the master")
+
+func run(masterAddr string, replicaAddrs []string, timeout time.Duration) error {
+	master, err := dial(masterAddr)
+	if err != nil {
+		return err
+	}
+	replicas := make([]*server, len(replicaAddrs))
+	for i, addr := range replicaAddrs {
+		if replicas[i], err = dial(strings.TrimSpace(addr)); err != nil {
+			return err
+		}
+	}
+
+	// Replication is asynchronous, so give replicas until the deadline to
+	// reach the master's digest. The master is re-read too in case it is
+	// still being written to.
+	deadline := time.Now().Add(timeout)
+	var want string
+	var diverged []*server
+	for {
+		if want, err = master.digest(); err != nil {
+			return err
+		}
+		diverged = diverged[:0]
+		for _, replica := range replicas {
+			got, err := replica.digest()
+			if err != nil {
+				return err
+			}
+			if got != want {
+				diverged = append(diverged, replica)
+			}
+		}
+		if len(diverged) == 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if len(diverged) == 0 {
+		fmt.Printf("OK: %d replica(s) match %s (digest %s)\n", len(replicas), master.addr, want)
+		return nil
+	}
+
+	masterKeys, err := master.keyDigests()
+	if err != nil {
+		return err
+	}
+	for _, replica := range diverged {
+		replicaKeys, err := replica.keyDigests()
+		if err != nil {
+			return err
+		}
+		diff := diffKeys(masterKeys, replicaKeys)
+		fmt.Printf("DIVERGED: %s differs from %s in %d key(s)\n", replica.addr, master.addr, len(diff))
+		if len(diff) == 0 {
+			fmt.Println("  values match; a key differs only in whether it has a TTL")
+		}
+		for i, key := range diff {
+			if i == maxKeyDiffs {
+				fmt.Printf("  ... and %d more\n", len(diff)-maxKeyDiffs)
+				break
+			}
+			fmt.Printf("  %q: master %s, replica %s\n", key, describe(masterKeys, key), describe(replicaKeys, key))
+		}
+	}
+	return errDiverged
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"sync"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/commands"
+	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+	"github.com/r0ld3x/redis-clone-go/app/internal/netpoll"
+	"github.com/r0ld3x/redis-clone-go/app/internal/server"
+)
+
+// readerPool recycles read buffers between bursts of input in event loop
+// mode, so an idle connection holds neither a goroutine nor a buffer
+var readerPool = sync.Pool{
+	New: func() any { return bufio.NewReader(nil) },
+}
+
+// serveEventLoop accepts connections on l and serves them from poller
+// (--event-loop) instead of one goroutine per connection. It never returns.
+func serveEventLoop(srv *server.Server, l net.Listener, registry *commands.Registry, poller *netpoll.Poller) {
+	logger := logging.NewLogger("EVENTLOOP")
+	logger.Info("Serving clients from the experimental event loop")
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			logger.Error("Accept error: %v", err)
+			continue
+		}
+		logger.Info("New connection established from: %s", conn.RemoteAddr())
+
+		s := newSession(srv, conn)
+		_, err = poller.Watch(conn, func(w *netpoll.Watch) { s.serveReady(srv, registry, w) })
+		if err != nil {
+			logger.Error("Cannot watch %s: %v", conn.RemoteAddr(), err)
+			s.close(srv)
+		}
+	}
+}
+
+// serveReady runs once the connection has input: it executes every command
+// that has arrived, then hands the connection back to the poller. A command
+// split across packets is waited for here, like in goroutine mode.
+func (s *session) serveReady(srv *server.Server, registry *commands.Registry, watch *netpoll.Watch) {
+	s.reader = readerPool.Get().(*bufio.Reader)
+	s.reader.Reset(s.conn)
+
+	for {
+		if !s.serveCommand(srv, registry) {
+			s.releaseReader()
+			watch.Stop()
+			s.close(srv)
+			return
+		}
+		if s.reader.Buffered() == 0 && !s.client.HasPendingInput() {
+			break
+		}
+	}
+
+	s.releaseReader()
+	if err := watch.Rearm(); err != nil {
+		s.logger.Error("Cannot re-arm %s: %v", s.conn.RemoteAddr(), err)
+		watch.Stop()
+		s.close(srv)
+	}
+}
+
+func (s *session) releaseReader() {
+	s.reader.Reset(nil)
+	readerPool.Put(s.reader)
+	s.reader = nil
+}
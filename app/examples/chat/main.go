@@ -0,0 +1,112 @@
+// Command chat is a chat room on pub/sub: every user subscribes to the room
+// and publishes lines to it. It exits 1 unless each user received every
+// line, and the lines of each sender in the order they were sent.
+//
+//	go run ./examples/chat -addr localhost:6379 -users 5 -lines 100
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/pkg/client"
+)
+
+// listen receives lines until it has all of them, checking that each
+// sender's arrive in order
+func listen(sub *client.Subscription, user string, users, lines int) error {
+	next := make(map[string]int, users) // Next line expected from each sender
+	for received := 0; received < users*lines; received++ {
+		msg, err := sub.Receive()
+		if err != nil {
+			return err
+		}
+		if msg.Kind != "message" {
+			return fmt.Errorf("%s: unexpected %s on %s", user, msg.Kind, msg.Channel)
+		}
+		sender, line, _ := strings.Cut(msg.Payload, ": ")
+		if line != strconv.Itoa(next[sender]) {
+			return fmt.Errorf("%s: got %q from %s, expected line %d", user, line, sender, next[sender])
+		}
+		next[sender]++
+	}
+	return nil
+}
+
+func run(addr string, users, lines int) error {
+	room := fmt.Sprintf("examples:chat:%d", time.Now().UnixNano())
+
+	// Everyone joins before anyone talks, so no line is missed
+	subs := make([]*client.Subscription, users)
+	for i := range subs {
+		c, err := client.Dial(addr)
+		if err != nil {
+			return err
+		}
+		c.SetTimeout(10 * time.Second)
+		if subs[i], err = c.Subscribe(room); err != nil {
+			return err
+		}
+		defer subs[i].Close()
+	}
+
+	start := time.Now()
+	errs := make([]error, 2*users)
+	var wg sync.WaitGroup
+	for i := 0; i < users; i++ {
+		user := "user-" + strconv.Itoa(i)
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = listen(subs[i], user, users, lines)
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			c, err := client.Dial(addr)
+			if err != nil {
+				errs[users+i] = err
+				return
+			}
+			defer c.Close()
+			for n := 0; n < lines; n++ {
+				reply, err := c.Do("PUBLISH", room, user+": "+strconv.Itoa(n))
+				if err != nil {
+					errs[users+i] = err
+					return
+				}
+				if int(reply.Int) != users {
+					errs[users+i] = fmt.Errorf("%s: PUBLISH reached %d of %d users", user, reply.Int, users)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("%d users each received %d lines in %v\n", users, users*lines, elapsed.Round(time.Millisecond))
+	return nil
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:6379", "Address of the server")
+	users := flag.Int("users", 5, "Users in the room")
+	lines := flag.Int("lines", 100, "Lines each user sends")
+	flag.Parse()
+
+	if err := run(*addr, *users, *lines); err != nil {
+		fmt.Fprintf(os.Stderr, "chat: %v\n", err)
+		os.Exit(1)
+	}
+}
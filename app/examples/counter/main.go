@@ -0,0 +1,121 @@
+// Command counter increments a shared counter from several clients, each
+// round a MULTI/EXEC transaction that also bumps the client's own counter.
+// It exits 1 unless every transaction ran both increments and the shared
+// counter ends at the sum of the clients' counters.
+//
+//	go run ./examples/counter -addr localhost:6379 -clients 8 -rounds 500
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/pkg/client"
+)
+
+// increment runs rounds transactions incrementing total and own
+func increment(addr, total, own string, rounds int) error {
+	c, err := client.Dial(addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	// The whole transaction goes in one write; MULTI and each queued
+	// command answer before EXEC returns the results
+	p := c.Pipeline()
+	for n := 1; n <= rounds; n++ {
+		p.Do("MULTI")
+		p.Do("INCR", total)
+		p.Do("INCR", own)
+		p.Do("EXEC")
+		replies, err := p.Exec()
+		if err != nil {
+			return err
+		}
+		for _, reply := range replies {
+			if err := reply.Err(); err != nil {
+				return err
+			}
+		}
+		results := replies[3].Array
+		if len(results) != 2 {
+			return fmt.Errorf("EXEC returned %s", replies[3])
+		}
+		if results[1].Int != int64(n) {
+			return fmt.Errorf("%s is %d after %d rounds", own, results[1].Int, n)
+		}
+	}
+	return nil
+}
+
+func run(addr string, clients, rounds int) error {
+	c, err := client.Dial(addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	prefix := fmt.Sprintf("examples:counter:%d", time.Now().UnixNano())
+	total := prefix + ":total"
+	keys := []string{"DEL", total}
+	for i := 0; i < clients; i++ {
+		keys = append(keys, prefix+":"+strconv.Itoa(i))
+	}
+	defer c.Do(keys...)
+
+	start := time.Now()
+	errs := make([]error, clients)
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = increment(addr, total, keys[2+i], rounds)
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("client %d: %w", i, err)
+		}
+	}
+
+	sum := 0
+	for _, key := range keys[2:] {
+		value, err := c.Get(key)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		n, _ := strconv.Atoi(value)
+		sum += n
+	}
+	value, err := c.Get(total)
+	if err != nil {
+		return fmt.Errorf("%s: %w", total, err)
+	}
+	if value != strconv.Itoa(sum) || sum != clients*rounds {
+		return fmt.Errorf("counter is %s, clients counted %d, expected %d", value, sum, clients*rounds)
+	}
+
+	fmt.Printf("%d transactions from %d clients in %v, counter at %s\n", clients*rounds, clients, elapsed.Round(time.Millisecond), value)
+	return nil
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:6379", "Address of the server")
+	clients := flag.Int("clients", 8, "Concurrent clients")
+	rounds := flag.Int("rounds", 500, "Transactions each client runs")
+	flag.Parse()
+
+	if err := run(*addr, *clients, *rounds); err != nil {
+		fmt.Fprintf(os.Stderr, "counter: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,157 @@
+// Command workerqueue is a job queue on a stream with a consumer group: a
+// producer XADDs jobs, workers share them with XREADGROUP and XACK each one
+// once done. It exits 1 unless every job was handled exactly once and
+// nothing is left pending.
+//
+//	go run ./examples/workerqueue -addr localhost:6379 -jobs 1000 -workers 4
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/pkg/client"
+)
+
+const group = "workers"
+
+// work reads jobs as consumer name until the producer is done and the group
+// has nothing more to deliver, acknowledging each and recording it in done
+func work(addr, key, name string, produced <-chan struct{}, done *sync.Map) (int, error) {
+	c, err := client.Dial(addr)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+
+	handled := 0
+	for {
+		// An empty read only means the queue is drained when the producer
+		// had finished before it started
+		finished := false
+		select {
+		case <-produced:
+			finished = true
+		default:
+		}
+
+		reply, err := c.Do("XREADGROUP", "GROUP", group, name, "COUNT", "10", "BLOCK", "200", "STREAMS", key, ">")
+		if err != nil {
+			return handled, err
+		}
+		if reply.Null || len(reply.Array) == 0 || len(reply.Array[0].Array) < 2 || len(reply.Array[0].Array[1].Array) == 0 {
+			if finished {
+				return handled, nil
+			}
+			continue
+		}
+
+		ids := []string{"XACK", key, group}
+		for _, entry := range reply.Array[0].Array[1].Array {
+			id, fields := entry.Array[0].Str, entry.Array[1].Strings()
+			if len(fields) != 2 || fields[0] != "job" {
+				return handled, fmt.Errorf("%s: unexpected entry %s %v", name, id, fields)
+			}
+			if previous, loaded := done.LoadOrStore(fields[1], name); loaded {
+				return handled, fmt.Errorf("job %s handled by both %s and %s", fields[1], previous, name)
+			}
+			ids = append(ids, id)
+			handled++
+		}
+		acked, err := c.Do(ids...)
+		if err != nil {
+			return handled, err
+		}
+		if int(acked.Int) != len(ids)-3 {
+			return handled, fmt.Errorf("%s: XACK acknowledged %d of %d entries", name, acked.Int, len(ids)-3)
+		}
+	}
+}
+
+func run(addr string, jobs, workers int) error {
+	c, err := client.Dial(addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	key := fmt.Sprintf("examples:jobs:%d", time.Now().UnixNano())
+	defer c.Do("DEL", key)
+	if _, err := c.Do("XGROUP", "CREATE", key, group, "$", "MKSTREAM"); err != nil {
+		return err
+	}
+
+	var done sync.Map
+	produced := make(chan struct{})
+	counts := make([]int, workers)
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			counts[i], errs[i] = work(addr, key, "worker-"+strconv.Itoa(i), produced, &done)
+		}(i)
+	}
+
+	start := time.Now()
+	p := c.Pipeline()
+	for n := 0; n < jobs; n++ {
+		p.Do("XADD", key, "*", "job", strconv.Itoa(n))
+		if p.Len() == 100 || n == jobs-1 {
+			replies, err := p.Exec()
+			if err != nil {
+				close(produced)
+				wg.Wait()
+				return err
+			}
+			for _, reply := range replies {
+				if err := reply.Err(); err != nil {
+					close(produced)
+					wg.Wait()
+					return err
+				}
+			}
+		}
+	}
+	close(produced)
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("worker-%d: %w", i, err)
+		}
+	}
+	for n := 0; n < jobs; n++ {
+		if _, ok := done.Load(strconv.Itoa(n)); !ok {
+			return fmt.Errorf("job %d was never handled", n)
+		}
+	}
+	pending, err := c.Do("XPENDING", key, group)
+	if err != nil {
+		return err
+	}
+	if len(pending.Array) == 0 || pending.Array[0].Int != 0 {
+		return fmt.Errorf("entries left pending: %s", pending)
+	}
+
+	fmt.Printf("%d jobs handled by %d workers in %v %v\n", jobs, workers, elapsed.Round(time.Millisecond), counts)
+	return nil
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:6379", "Address of the server")
+	jobs := flag.Int("jobs", 1000, "Jobs to produce")
+	workers := flag.Int("workers", 4, "Consumers in the group")
+	flag.Parse()
+
+	if err := run(*addr, *jobs, *workers); err != nil {
+		fmt.Fprintf(os.Stderr, "workerqueue: %v\n", err)
+		os.Exit(1)
+	}
+}
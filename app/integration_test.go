@@ -0,0 +1,437 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/pkg/client"
+)
+
+// serverBinary is the server built for the integration tests, which run it
+// as separate processes since the keyspace is global to a process, and
+// verifyBinary is cmd/verify
+var serverBinary, verifyBinary string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "redis-clone-it")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	serverBinary = filepath.Join(dir, "server")
+	if out, err := exec.Command("go", "build", "-o", serverBinary, ".").CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "building the server: %v\n%s", err, out)
+		os.Exit(1)
+	}
+	verifyBinary = filepath.Join(dir, "verify")
+	if out, err := exec.Command("go", "build", "-o", verifyBinary, "./cmd/verify").CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "building verify: %v\n%s", err, out)
+		os.Exit(1)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// startServer runs the server with args on a free port until the test ends
+// and returns its port
+func startServer(t *testing.T, args ...string) string {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := fmt.Sprint(l.Addr().(*net.TCPAddr).Port)
+	l.Close()
+
+	startServerOn(t, port, args...)
+	return port
+}
+
+// startServerOn runs the server with args on port until the test ends, such
+// as again on the port of one that stopped
+func startServerOn(t *testing.T, port string, args ...string) {
+	t.Helper()
+	cmd := exec.Command(serverBinary, append([]string{"--port", port, "--loglevel", "none"}, args...)...)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if conn, err := net.Dial("tcp", "127.0.0.1:"+port); err == nil {
+			conn.Close()
+			return
+		}
+	}
+	t.Fatalf("server on port %s did not come up", port)
+}
+
+// waitDown waits until the server on port stopped accepting connections
+func waitDown(t *testing.T, port string) {
+	t.Helper()
+	for deadline := time.Now().Add(15 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		conn, err := net.Dial("tcp", "127.0.0.1:"+port)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}
+	t.Fatalf("server on port %s did not stop", port)
+}
+
+func dial(t *testing.T, port string) *client.Client {
+	t.Helper()
+	c, err := client.Dial("127.0.0.1:" + port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetTimeout(5 * time.Second)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// do runs a command and returns its reply as redis-cli renders it
+func do(t *testing.T, c *client.Client, args ...string) string {
+	t.Helper()
+	reply, err := c.Do(args...)
+	if err != nil && reply.Type != '-' {
+		t.Fatalf("%v: %v", args, err)
+	}
+	return reply.String()
+}
+
+// eventually polls a command until it replies want
+func eventually(t *testing.T, c *client.Client, want string, args ...string) {
+	t.Helper()
+	got := ""
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if got = do(t, c, args...); got == want {
+			return
+		}
+	}
+	t.Fatalf("%v = %s, want %s", args, got, want)
+}
+
+// populate writes a key of each type the RDB format carries
+func populate(t *testing.T, c *client.Client) {
+	t.Helper()
+	for _, command := range [][]string{
+		{"SET", "string", "value"},
+		{"SET", "volatile", "value", "PX", "600000"},
+		{"RPUSH", "list", "a", "b", "c"},
+		{"SADD", "set", "x", "y"},
+		{"HSET", "hash", "field", "value"},
+		{"ZADD", "zset", "1", "one", "2.5", "two"},
+	} {
+		if reply := do(t, c, command...); strings.HasPrefix(reply, "(error)") {
+			t.Fatalf("%v: %s", command, reply)
+		}
+	}
+}
+
+// checkPopulated verifies the keys populate wrote
+func checkPopulated(t *testing.T, c *client.Client) {
+	t.Helper()
+	for _, check := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"GET", "string"}, `"value"`},
+		{[]string{"GET", "volatile"}, `"value"`},
+		{[]string{"LRANGE", "list", "0", "-1"}, `["a", "b", "c"]`},
+		{[]string{"HGET", "hash", "field"}, `"value"`},
+		{[]string{"ZRANGE", "zset", "0", "-1", "WITHSCORES"}, `["one", "1", "two", "2.5"]`},
+	} {
+		if got := do(t, c, check.args...); got != check.want {
+			t.Errorf("%v = %s, want %s", check.args, got, check.want)
+		}
+	}
+
+	reply, _ := c.Do("SMEMBERS", "set")
+	members := reply.Strings()
+	slices.Sort(members)
+	if !slices.Equal(members, []string{"x", "y"}) {
+		t.Errorf("SMEMBERS set = %v, want [x y]", members)
+	}
+	if reply, _ := c.Do("PTTL", "volatile"); reply.Int <= 0 || reply.Int > 600000 {
+		t.Errorf("PTTL volatile = %s, want a TTL of up to 600000", reply)
+	}
+}
+
+// populateStream writes a stream with a consumer group and a pending entry,
+// whose last ID is past its entries, and a hash with a field TTL
+func populateStream(t *testing.T, c *client.Client) {
+	t.Helper()
+	for _, command := range [][]string{
+		{"XADD", "stream", "1-1", "a", "1"},
+		{"XADD", "stream", "2-5", "b", "2"},
+		{"XADD", "stream", "3-0", "c", "3"},
+		{"XDEL", "stream", "3-0"},
+		{"XGROUP", "CREATE", "stream", "readers", "0"},
+		{"XREADGROUP", "GROUP", "readers", "alice", "COUNT", "1", "STREAMS", "stream", ">"},
+		{"HSET", "fields", "volatile", "1", "kept", "2"},
+		{"HEXPIRE", "fields", "1000", "FIELDS", "1", "volatile"},
+	} {
+		if reply := do(t, c, command...); strings.HasPrefix(reply, "(error)") {
+			t.Fatalf("%v: %s", command, reply)
+		}
+	}
+}
+
+// checkStream verifies the keys populateStream wrote
+func checkStream(t *testing.T, c *client.Client) {
+	t.Helper()
+	for _, check := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"TYPE", "stream"}, `"stream"`},
+		{[]string{"XRANGE", "stream", "-", "+"}, `[["1-1", ["a", "1"]], ["2-5", ["b", "2"]]]`},
+		{[]string{"XPENDING", "stream", "readers"}, `[(integer) 1, "1-1", "1-1", [["alice", "1"]]]`},
+		{[]string{"HTTL", "fields", "FIELDS", "1", "kept"}, `[(integer) -1]`},
+	} {
+		if got := do(t, c, check.args...); got != check.want {
+			t.Errorf("%v = %s, want %s", check.args, got, check.want)
+		}
+	}
+	if info := do(t, c, "XINFO", "STREAM", "stream"); !strings.Contains(info, `"last-generated-id", "3-0"`) {
+		t.Errorf("XINFO STREAM stream = %s, want last-generated-id 3-0", info)
+	}
+	if reply, _ := c.Do("HTTL", "fields", "FIELDS", "1", "volatile"); len(reply.Array) != 1 || reply.Array[0].Int < 900 || reply.Array[0].Int > 1000 {
+		t.Errorf("HTTL fields FIELDS 1 volatile = %s, want about 1000", reply)
+	}
+}
+
+// fullSyncs returns how many full resyncs the replica loaded
+func fullSyncs(t *testing.T, replica *client.Client) string {
+	t.Helper()
+	for _, line := range strings.Split(do(t, replica, "INFO", "replication"), `\r\n`) {
+		if count, ok := strings.CutPrefix(line, "replica_full_sync_flushes:"); ok {
+			return count
+		}
+	}
+	t.Fatal("INFO replication has no replica_full_sync_flushes")
+	return ""
+}
+
+func TestReplicaReconnectKeepsData(t *testing.T) {
+	masterPort := startServer(t, "--enable-debug-command")
+	master := dial(t, masterPort)
+	populate(t, master)
+	populateStream(t, master)
+
+	replicaPort := startServer(t, "--replicaof", "127.0.0.1 "+masterPort)
+	replica := dial(t, replicaPort)
+
+	// The data written before the replica attached comes in the RDB
+	eventually(t, replica, `"value"`, "GET", "string")
+	checkPopulated(t, replica)
+	checkStream(t, replica)
+
+	// Drop the link: the replica continues from the backlog, keeping its data
+	if got := do(t, master, "CLIENT", "KILL", "TYPE", "replica"); got != "(integer) 1" {
+		t.Fatalf("CLIENT KILL TYPE replica = %s, want (integer) 1", got)
+	}
+	do(t, master, "SET", "after", "reconnect")
+	eventually(t, replica, `"reconnect"`, "GET", "after")
+	checkPopulated(t, replica)
+	if got := fullSyncs(t, replica); got != "1" {
+		t.Fatalf("the replica full resynced %s times, want once", got)
+	}
+
+	// With a new replication ID the replica full resyncs, and the snapshot
+	// replaces its data with the same data
+	do(t, master, "DEBUG", "CHANGE-REPL-ID")
+	do(t, master, "CLIENT", "KILL", "TYPE", "replica")
+	do(t, master, "SET", "after", "full resync")
+	eventually(t, replica, `"full resync"`, "GET", "after")
+	checkPopulated(t, replica)
+	if got := fullSyncs(t, replica); got != "2" {
+		t.Fatalf("the replica full resynced %s times, want twice", got)
+	}
+}
+
+func TestRestartResumesReplication(t *testing.T) {
+	persistence := []string{"--dir", t.TempDir(), "--dbfilename", "dump.rdb"}
+	masterPort := startServer(t, persistence...)
+	master := dial(t, masterPort)
+	populate(t, master)
+	populateStream(t, master)
+
+	replica := dial(t, startServer(t, "--replicaof", "127.0.0.1 "+masterPort))
+	eventually(t, replica, `"value"`, "GET", "string")
+	do(t, master, "SET", "before", "shutdown")
+	eventually(t, replica, `"shutdown"`, "GET", "before")
+
+	// SHUTDOWN waits for the replica to acknowledge the final offset, then
+	// saves the data along with that offset
+	if reply, err := master.Do("SHUTDOWN"); err == nil {
+		t.Fatalf("SHUTDOWN replied %s", reply)
+	}
+	waitDown(t, masterPort)
+
+	// The restarted master loads the data, and the replica continues the
+	// stream without another full resync
+	startServerOn(t, masterPort, persistence...)
+	master = dial(t, masterPort)
+	checkPopulated(t, master)
+	checkStream(t, master)
+	if got := do(t, master, "GET", "before"); got != `"shutdown"` {
+		t.Fatalf("GET before = %s after the restart, want \"shutdown\"", got)
+	}
+
+	do(t, master, "SET", "after", "restart")
+	eventually(t, replica, `"restart"`, "GET", "after")
+	checkPopulated(t, replica)
+	if got := fullSyncs(t, replica); got != "1" {
+		t.Fatalf("the replica full resynced %s times, want once", got)
+	}
+}
+
+func TestSnapshotEndsWithConnection(t *testing.T) {
+	port := startServer(t)
+	c := dial(t, port)
+	reader := dial(t, port)
+
+	do(t, c, "SET", "k", "old")
+	if got := do(t, reader, "SNAPSHOT", "BEGIN"); got != `"OK"` {
+		t.Fatalf("SNAPSHOT BEGIN = %s", got)
+	}
+	do(t, c, "SET", "k", "new")
+	if got := do(t, reader, "GET", "k"); got != `"old"` {
+		t.Fatalf("GET k in the snapshot = %s, want \"old\"", got)
+	}
+	if info := do(t, c, "INFO", "persistence"); !strings.Contains(info, `snapshots_in_progress:1\r\n`) {
+		t.Fatalf("INFO persistence during the snapshot = %s", info)
+	}
+
+	// Closing the connection releases its view
+	reader.Close()
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		info := do(t, c, "INFO", "persistence")
+		if strings.Contains(info, `snapshots_in_progress:0\r\n`) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("INFO persistence after the snapshot's client left = %s", info)
+		}
+	}
+}
+
+func TestStorePropagationKeepsReplicasInSync(t *testing.T) {
+	for _, mode := range []string{"verbatim", "effects"} {
+		t.Run(mode, func(t *testing.T) {
+			masterPort := startServer(t, "--store-propagation", mode, "--enable-debug-command")
+			master := dial(t, masterPort)
+			replica := dial(t, startServer(t, "--replicaof", "127.0.0.1 "+masterPort, "--enable-debug-command"))
+			// The replica is online before the writes, so they reach it
+			// as propagated commands rather than in the RDB
+			do(t, master, "SET", "link", "up")
+			eventually(t, replica, `"up"`, "GET", "link")
+
+			for _, command := range [][]string{
+				{"SADD", "s1", "a", "b", "c"},
+				{"SADD", "s2", "b", "c", "d"},
+				{"ZADD", "z1", "1", "a", "2", "b", "3", "c"},
+				{"ZADD", "z2", "1.5", "b", "4", "c", "5", "d"},
+				{"SET", "overwritten", "x"},
+				{"SINTERSTORE", "sinter", "s1", "s2"},
+				{"SUNIONSTORE", "sunion", "s1", "s2"},
+				{"SDIFFSTORE", "sdiff", "s1", "s2"},
+				{"SINTERSTORE", "overwritten", "s1", "missing"},
+				{"ZUNIONSTORE", "zunion", "2", "z1", "z2", "WEIGHTS", "2", "0.5"},
+				{"ZINTERSTORE", "zinter", "2", "z1", "z2", "AGGREGATE", "MAX"},
+				{"ZDIFFSTORE", "zdiff", "2", "z1", "z2"},
+				{"ZRANGESTORE", "zrange", "z2", "(1.5", "+inf", "BYSCORE"},
+				// Each source replaced by a result stored from it
+				{"SUNIONSTORE", "s1", "s1", "s2"},
+				{"ZINTERSTORE", "z1", "2", "z1", "z2", "AGGREGATE", "SUM"},
+				{"SET", "done", "1"},
+			} {
+				if reply := do(t, master, command...); strings.HasPrefix(reply, "(error)") {
+					t.Fatalf("%v: %s", command, reply)
+				}
+			}
+			eventually(t, replica, `"1"`, "GET", "done")
+
+			for _, key := range []string{"s1", "z1", "sinter", "sunion", "sdiff", "overwritten", "zunion", "zinter", "zdiff", "zrange"} {
+				if got, want := do(t, replica, "DEBUG", "DIGEST-VALUE", key), do(t, master, "DEBUG", "DIGEST-VALUE", key); got != want {
+					t.Errorf("%s has digest %s on the replica, %s on the master", key, got, want)
+				}
+			}
+			if got, want := do(t, replica, "DEBUG", "DIGEST"), do(t, master, "DEBUG", "DIGEST"); got != want {
+				t.Errorf("the replica's dataset has digest %s, the master's %s", got, want)
+			}
+		})
+	}
+}
+
+// verify runs cmd/verify against the servers on ports and returns its exit
+// code and what it printed
+func verify(t *testing.T, masterPort string, replicaPorts ...string) (int, string) {
+	t.Helper()
+	replicas := make([]string, len(replicaPorts))
+	for i, port := range replicaPorts {
+		replicas[i] = "127.0.0.1:" + port
+	}
+	out, err := exec.Command(verifyBinary, "-master", "127.0.0.1:"+masterPort, "-replicas", strings.Join(replicas, ","), "-timeout", "200ms").Output()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return exit.ExitCode(), string(out)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return 0, string(out)
+}
+
+// TestVerifyReportsDivergedKeys runs verify against servers written the same
+// way, then after one of them diverged in a field and a key of its own. The
+// servers are not replicas, so nothing brings them back in line.
+func TestVerifyReportsDivergedKeys(t *testing.T) {
+	ports := make([]string, 3)
+	for i := range ports {
+		ports[i] = startServer(t, "--enable-debug-command")
+		c := dial(t, ports[i])
+		populate(t, c)
+		populateStream(t, c)
+	}
+
+	if code, out := verify(t, ports[0], ports[1], ports[2]); code != 0 || !strings.HasPrefix(out, "OK: 2 replica(s) match") {
+		t.Fatalf("verify of identical datasets exited with %d:\n%s", code, out)
+	}
+
+	diverged := dial(t, ports[2])
+	do(t, diverged, "HSET", "hash", "field", "other")
+	do(t, diverged, "SET", "extra", "1")
+	code, out := verify(t, ports[0], ports[1], ports[2])
+	if code != 1 {
+		t.Fatalf("verify of a diverged dataset exited with %d, want 1:\n%s", code, out)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if want := "DIVERGED: 127.0.0.1:" + ports[2] + " differs from 127.0.0.1:" + ports[0] + " in 2 key(s)"; len(lines) != 3 || lines[0] != want {
+		t.Fatalf("verify reported:\n%s\nwant one replica with 2 keys: %s", out, want)
+	}
+	if !strings.HasPrefix(lines[1], `  "extra": master (missing), replica `) {
+		t.Errorf("verify reported %q for the key only the replica has", lines[1])
+	}
+	if !strings.HasPrefix(lines[2], `  "hash": master `) || strings.Contains(lines[2], "(missing)") {
+		t.Errorf("verify reported %q for the field that differs", lines[2])
+	}
+}
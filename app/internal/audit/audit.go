@@ -0,0 +1,99 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+)
+
+// DefaultUser is recorded for every entry until per-connection users exist
+const DefaultUser = "default"
+
+// Entry is one JSON line of the audit log
+type Entry struct {
+	Time    string   `json:"time"`
+	User    string   `json:"user"`
+	Client  string   `json:"client"`
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+}
+
+// Log appends audit entries as JSON lines to a file. It can be switched on
+// and off at runtime; the file is only held open while enabled.
+type Log struct {
+	path    string
+	file    *os.File
+	encoder *json.Encoder
+	logger  *logging.Logger
+	mutex   sync.Mutex
+	enabled atomic.Bool // file != nil, read without the lock by every command
+}
+
+// NewLog creates an audit log writing to path, initially disabled
+func NewLog(path string) *Log {
+	return &Log{
+		path:   path,
+		logger: logging.NewLogger("AUDIT"),
+	}
+}
+
+// Enabled reports whether entries are currently being written
+func (l *Log) Enabled() bool {
+	return l.enabled.Load()
+}
+
+// SetEnabled opens or closes the audit file
+func (l *Log) SetEnabled(enabled bool) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if enabled == (l.file != nil) {
+		return nil
+	}
+
+	if !enabled {
+		// Entries must be on disk once the log is off, as a shutdown turns
+		// it off right before exiting
+		err := errors.Join(l.file.Sync(), l.file.Close())
+		l.file, l.encoder = nil, nil
+		l.enabled.Store(false)
+		l.logger.Info("Audit log disabled")
+		return err
+	}
+
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	l.file = file
+	l.encoder = json.NewEncoder(file)
+	l.enabled.Store(true)
+	l.logger.Info("Audit log enabled, writing to %s", l.path)
+	return nil
+}
+
+// Record writes an entry if the log is enabled
+func (l *Log) Record(user, client, command string, args []string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return
+	}
+
+	entry := Entry{
+		Time:    time.Now().UTC().Format(time.RFC3339Nano),
+		User:    user,
+		Client:  client,
+		Command: command,
+		Args:    args,
+	}
+	if err := l.encoder.Encode(entry); err != nil {
+		l.logger.Error("Failed to write audit entry: %v", err)
+	}
+}
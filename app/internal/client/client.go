@@ -0,0 +1,297 @@
+// Package client tracks connected clients and their per-connection
+// statistics for CLIENT INFO / CLIENT LIST.
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Client is a connected client and its counters
+type Client struct {
+	ID        int64
+	Conn      net.Conn
+	CreatedAt time.Time
+
+	netIn    atomic.Int64 // Bytes read from the client
+	netOut   atomic.Int64 // Bytes written to the client
+	commands atomic.Int64 // Commands processed
+	killed   atomic.Bool  // Set by CLIENT KILL
+
+	// ctx is cancelled once the client is gone, so blocking commands stop
+	// waiting for it
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	name          string
+	lastActive    time.Time
+	lastCommand   string
+	pipelineDepth int // Position of the current command within its pipelined batch
+	queryBuffer   int // Bytes already received but not yet parsed
+	mutex         sync.Mutex
+}
+
+// Stats is a point-in-time copy of a client's counters
+type Stats struct {
+	Name          string
+	Age           time.Duration
+	Idle          time.Duration
+	LastCommand   string
+	Commands      int64
+	NetIn         int64
+	NetOut        int64
+	PipelineDepth int
+	QueryBuffer   int
+}
+
+// conn counts the bytes flowing through a client connection
+type conn struct {
+	net.Conn
+	client *Client
+
+	// Input read by WatchDisconnect while a command was blocked, and the
+	// error that ended it, handed out by Read before the socket is read
+	// again. Only the goroutine serving the connection touches them.
+	ahead    []byte
+	aheadErr error
+
+	// capture takes the writes while CaptureReplies is on
+	capture atomic.Pointer[capture]
+}
+
+// capture collects what is written to a connection in place of the socket
+type capture struct {
+	buf   []byte
+	mutex sync.Mutex
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	if len(c.ahead) > 0 {
+		n := copy(b, c.ahead)
+		c.ahead = c.ahead[n:]
+		return n, nil
+	}
+	if c.aheadErr != nil {
+		return 0, c.aheadErr
+	}
+	n, err := c.Conn.Read(b)
+	c.client.netIn.Add(int64(n))
+	return n, err
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	if cp := c.capture.Load(); cp != nil {
+		cp.mutex.Lock()
+		cp.buf = append(cp.buf, b...)
+		cp.mutex.Unlock()
+		return len(b), nil
+	}
+	n, err := c.Conn.Write(b)
+	c.client.netOut.Add(int64(n))
+	return n, err
+}
+
+// RecordCommand notes that cmd is about to run. buffered is how many bytes
+// of further input are already waiting; a non-zero value means the client
+// pipelined more commands behind this one.
+func (c *Client) RecordCommand(cmd string, buffered int) {
+	c.commands.Add(1)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.queryBuffer > 0 {
+		c.pipelineDepth++
+	} else {
+		c.pipelineDepth = 1
+	}
+	c.queryBuffer = buffered
+	c.lastCommand = cmd
+	c.lastActive = time.Now()
+}
+
+// SetName sets the name reported by CLIENT GETNAME / CLIENT LIST
+func (c *Client) SetName(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.name = name
+}
+
+// Name returns the client name, or "" when none was set
+func (c *Client) Name() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.name
+}
+
+// Kill disconnects the client for CLIENT KILL. The socket is shut down
+// rather than closed so whatever is reading it, a goroutine or the event
+// loop, sees EOF and runs the usual connection cleanup.
+func (c *Client) Kill() {
+	c.killed.Store(true)
+	c.cancel()
+
+	raw := c.Conn
+	if wrapped, ok := raw.(*conn); ok {
+		raw = wrapped.Conn
+	}
+	if tcp, ok := raw.(*net.TCPConn); ok {
+		tcp.CloseRead()
+		tcp.CloseWrite()
+		return
+	}
+	raw.Close()
+}
+
+// Killed reports whether the client was disconnected by CLIENT KILL
+func (c *Client) Killed() bool {
+	return c.killed.Load()
+}
+
+// WatchDisconnect returns the client's context and reads the connection in
+// the background until stop is called, so a client hanging up while a
+// command blocks cancels the context right away. Input arriving meanwhile,
+// such as a pipelined command, is kept and served once the command is done.
+func (c *Client) WatchDisconnect() (context.Context, func()) {
+	wrapped := c.Conn.(*conn)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		buf := make([]byte, 4096)
+		for {
+			n, err := wrapped.Conn.Read(buf)
+			wrapped.ahead = append(wrapped.ahead, buf[:n]...)
+			c.netIn.Add(int64(n))
+			if err != nil {
+				if !errors.Is(err, os.ErrDeadlineExceeded) {
+					wrapped.aheadErr = err
+					c.cancel()
+				}
+				return
+			}
+		}
+	}()
+
+	stop := func() {
+		// Interrupt the pending Read and take over the connection again.
+		wrapped.Conn.SetReadDeadline(time.Now())
+		<-done
+		wrapped.Conn.SetReadDeadline(time.Time{})
+	}
+	return c.ctx, stop
+}
+
+// CaptureReplies makes what is written to the client's connection go to a
+// buffer instead of the socket until the returned function is called,
+// which returns what was written. EXEC collects the replies of the queued
+// commands with it. A capturing client can't block: blocking commands give
+// up at once, as if their timeout had passed.
+func (c *Client) CaptureReplies() func() []byte {
+	wrapped := c.Conn.(*conn)
+	cp := &capture{}
+	wrapped.capture.Store(cp)
+	return func() []byte {
+		wrapped.capture.Store(nil)
+		cp.mutex.Lock()
+		defer cp.mutex.Unlock()
+		return cp.buf
+	}
+}
+
+// Capturing reports whether the client's replies are being captured
+func (c *Client) Capturing() bool {
+	return c.Conn.(*conn).capture.Load() != nil
+}
+
+// HasPendingInput reports whether input, or the end of the connection, was
+// read ahead by WatchDisconnect and not served yet. The socket itself no
+// longer signals it, so the event loop must keep serving the client.
+func (c *Client) HasPendingInput() bool {
+	wrapped := c.Conn.(*conn)
+	return len(wrapped.ahead) > 0 || wrapped.aheadErr != nil
+}
+
+// Stats returns a snapshot of the client's counters
+func (c *Client) Stats() Stats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	return Stats{
+		Name:          c.name,
+		Age:           now.Sub(c.CreatedAt),
+		Idle:          now.Sub(c.lastActive),
+		LastCommand:   c.lastCommand,
+		Commands:      c.commands.Load(),
+		NetIn:         c.netIn.Load(),
+		NetOut:        c.netOut.Load(),
+		PipelineDepth: c.pipelineDepth,
+		QueryBuffer:   c.queryBuffer,
+	}
+}
+
+// Registry holds every connected client
+type Registry struct {
+	clients map[net.Conn]*Client
+	nextID  int64
+	mutex   sync.RWMutex
+}
+
+// NewRegistry creates an empty client registry
+func NewRegistry() *Registry {
+	return &Registry{clients: make(map[net.Conn]*Client)}
+}
+
+// Register starts tracking a new connection. The returned connection counts
+// the bytes read and written and must be used in place of raw from then on.
+func (r *Registry) Register(raw net.Conn) (*Client, net.Conn) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.nextID++
+	now := time.Now()
+	c := &Client{ID: r.nextID, CreatedAt: now, lastActive: now}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	wrapped := &conn{Conn: raw, client: c}
+	c.Conn = wrapped
+	r.clients[wrapped] = c
+	return c, wrapped
+}
+
+// Unregister stops tracking a connection and cancels its context
+func (r *Registry) Unregister(conn net.Conn) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if c, ok := r.clients[conn]; ok {
+		c.cancel()
+		delete(r.clients, conn)
+	}
+}
+
+// Get returns the client owning conn
+func (r *Registry) Get(conn net.Conn) (*Client, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	c, ok := r.clients[conn]
+	return c, ok
+}
+
+// List returns all clients ordered by ID
+func (r *Registry) List() []*Client {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	list := make([]*Client, 0, len(r.clients))
+	for _, c := range r.clients {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
@@ -0,0 +1,184 @@
+package commands
+
+// commandArity holds the number of arguments of each command, its name
+// included, as Redis counts them: a negative arity -n means at least n.
+// Where a handler is looser than Redis (GET ignores extra arguments, XADD
+// checks its field/value pairs itself) the arity is the handler's, so a
+// command is refused at queue time exactly when running it would fail on
+// its number of arguments. Commands with subcommands only need the
+// subcommand; their dispatcher checks the rest.
+var commandArity = map[Command]int{
+	CommandCommand:  -1,
+	EchoCommand:     -2,
+	PingCommand:     -1,
+	QuitCommand:     -1,
+	ResetCommand:    1,
+	ConfigCommand:   -2,
+	KeysCommand:     -2,
+	ScanCommand:     -2,
+	InfoCommand:     -1,
+	ReplconfCommand: -2,
+	PsyncCommand:    3,
+	WaitCommand:     3,
+	ShutdownCommand: -1,
+	MultiCommand:    1,
+	ExecCommand:     1,
+	DiscardCommand:  1,
+	WatchCommand:    -2,
+	UnwatchCommand:  1,
+	ClusterCommand:  -2,
+	DebugCommand:    -2,
+	ClientCommand:   -2,
+	SlowlogCommand:  -2,
+	MonitorCommand:  1,
+	MemoryCommand:   -2,
+
+	GetCommand:      -2,
+	SetCommand:      -3,
+	IncrCommand:     -2,
+	AppendCommand:   3,
+	SetRangeCommand: 4,
+	SetBitCommand:   4,
+	GetBitCommand:   3,
+	BitCountCommand: -2,
+	TypeCommand:     -2,
+	TouchCommand:    -2,
+	DelCommand:      -2,
+	ObjectCommand:   -2,
+
+	ExpireCommand:    -3,
+	PExpireCommand:   -3,
+	ExpireAtCommand:  -3,
+	PExpireAtCommand: -3,
+	TTLCommand:       2,
+	PTTLCommand:      2,
+	PersistCommand:   2,
+
+	RPushCommand:     -3,
+	LPushCommand:     -3,
+	LRangeCommand:    4,
+	LLenCommand:      2,
+	LPopCommand:      -2,
+	RPopCommand:      -2,
+	LIndexCommand:    3,
+	LSetCommand:      4,
+	LInsertCommand:   5,
+	LRemCommand:      4,
+	LTrimCommand:     4,
+	LMoveCommand:     5,
+	BLMoveCommand:    6,
+	RPopLPushCommand: 3,
+	LMPopCommand:     -4,
+	BLMPopCommand:    -5,
+	BLPopCommand:     -3,
+	BRPopCommand:     -3,
+
+	HSetCommand:         -4,
+	HGetCommand:         3,
+	HMGetCommand:        -3,
+	HDelCommand:         -3,
+	HGetAllCommand:      2,
+	HExistsCommand:      3,
+	HLenCommand:         2,
+	HKeysCommand:        2,
+	HValsCommand:        2,
+	HIncrByCommand:      4,
+	HScanCommand:        -3,
+	HIncrByFloatCommand: 4,
+	HRandFieldCommand:   -2,
+	HExpireCommand:      -6,
+	HPExpireCommand:     -6,
+	HExpireAtCommand:    -6,
+	HPExpireAtCommand:   -6,
+	HTTLCommand:         -5,
+	HPTTLCommand:        -5,
+	HPersistCommand:     -5,
+
+	SAddCommand:        -3,
+	SRemCommand:        -3,
+	SMembersCommand:    2,
+	SIsMemberCommand:   3,
+	SCardCommand:       2,
+	SInterCommand:      -2,
+	SUnionCommand:      -2,
+	SDiffCommand:       -2,
+	SPopCommand:        -2,
+	SScanCommand:       -3,
+	SInterStoreCommand: -3,
+	SUnionStoreCommand: -3,
+	SDiffStoreCommand:  -3,
+	SRandMemberCommand: -2,
+	SMIsMemberCommand:  -3,
+	SInterCardCommand:  -3,
+
+	ZAddCommand:             -4,
+	ZScoreCommand:           3,
+	ZCardCommand:            2,
+	ZRemCommand:             -3,
+	ZRangeCommand:           -4,
+	ZRevRangeCommand:        -4,
+	ZRangeByScoreCommand:    -4,
+	ZRevRangeByScoreCommand: -4,
+	ZRangeByLexCommand:      -4,
+	ZRevRangeByLexCommand:   -4,
+	ZIncrByCommand:          4,
+	ZRankCommand:            -3,
+	ZRevRankCommand:         -3,
+	ZCountCommand:           4,
+	ZLexCountCommand:        4,
+	ZPopMinCommand:          -2,
+	ZPopMaxCommand:          -2,
+	BZPopMinCommand:         -3,
+	BZPopMaxCommand:         -3,
+	ZRangeStoreCommand:      -5,
+	ZUnionCommand:           -3,
+	ZInterCommand:           -3,
+	ZDiffCommand:            -3,
+	ZUnionStoreCommand:      -4,
+	ZInterStoreCommand:      -4,
+	ZDiffStoreCommand:       -4,
+	ZMScoreCommand:          -3,
+	ZRandMemberCommand:      -2,
+	ZScanCommand:            -3,
+	ZRemRangeByRankCommand:  4,
+	ZRemRangeByScoreCommand: 4,
+	ZRemRangeByLexCommand:   4,
+
+	XAddCommand:       -4,
+	XRangeCommand:     -4,
+	XRevRangeCommand:  -4,
+	XReadCommand:      -4,
+	XDelCommand:       -3,
+	XGroupCommand:     -2,
+	XReadGroupCommand: -7,
+	XAckCommand:       -4,
+	XPendingCommand:   -3,
+	XClaimCommand:     -6,
+	XAutoClaimCommand: -6,
+	XInfoCommand:      -2,
+
+	SubscribeCommand:        -2,
+	SubscribeHistoryCommand: -2,
+	UnsubscribeCommand:      -1,
+	PSubscribeCommand:       -2,
+	PUnsubscribeCommand:     -1,
+	SSubscribeCommand:       -2,
+	SUnsubscribeCommand:     -1,
+	PublishCommand:          3,
+	SPublishCommand:         3,
+	PubsubCommand:           -2,
+
+	RateLimitCommand: 4,
+	SnapshotCommand:  -2,
+}
+
+// arityError returns the error for running cmd with args when that is the
+// wrong number of arguments for it, or ""
+func arityError(cmd Command, args []string) string {
+	arity, known := commandArity[cmd]
+	n := len(args) + 1
+	if !known || arity >= 0 && n == arity || arity < 0 && n >= -arity {
+		return ""
+	}
+	return "ERR wrong number of arguments for '" + string(cmd) + "' command"
+}
@@ -8,7 +8,8 @@ import (
 	"github.com/r0ld3x/redis-clone-go/app/internal/server"
 )
 
-// PingHandler handles PING commands
+// PingHandler handles PING [message]. A connection in subscribe mode gets
+// the reply as a push-like array, "pong" and the message or "", as in Redis.
 type PingHandler struct {
 	logger *logging.Logger
 }
@@ -19,8 +20,70 @@ func (h *PingHandler) Handle(srv *server.Server, clientConn net.Conn, args []str
 	}
 
 	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
-	h.logger.Network("OUT", "Sending PONG response")
-	protocol.WriteSimpleString(clientConn, "PONG")
+
+	if len(args) > 1 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'PING' command")
+		return nil
+	}
+
+	switch {
+	case srv.PubSub.SubscriptionCount(clientConn) > 0:
+		message := ""
+		if len(args) == 1 {
+			message = args[0]
+		}
+		protocol.WriteArray(clientConn, []string{"pong", message})
+	case len(args) == 1:
+		protocol.WriteBulkString(clientConn, args[0])
+	default:
+		h.logger.Network("OUT", "Sending PONG response")
+		protocol.WriteSimpleString(clientConn, "PONG")
+	}
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// QuitHandler handles QUIT. It only replies; the connection loop closes the
+// connection once it ran.
+type QuitHandler struct {
+	logger *logging.Logger
+}
+
+func (h *QuitHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("QUIT")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+	protocol.WriteSimpleString(clientConn, "OK")
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// ResetHandler handles RESET, which returns the connection to its initial
+// state: the transaction is discarded, every subscription dropped, MONITOR
+// turned off and the snapshot ended. The client's name is kept.
+type ResetHandler struct {
+	logger *logging.Logger
+}
+
+func (h *ResetHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("RESET")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 0 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'RESET' command")
+		return nil
+	}
+
+	srv.TransactionMgr.CleanupConnection(clientConn)
+	srv.PubSub.RemoveConnection(clientConn)
+	srv.Monitors.Remove(clientConn)
+	srv.EndView(clientConn)
+	protocol.WriteSimpleString(clientConn, "RESET")
 	h.logger.Success("Command completed successfully")
 	return nil
 }
@@ -0,0 +1,94 @@
+package commands
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/server"
+)
+
+// waitResult is how a blocked command's wait ended
+type waitResult int
+
+const (
+	waitReady   waitResult = iota // A key it waits on may be ready
+	waitExpired                   // The command's timeout passed
+	waitGone                      // The client disconnected
+)
+
+// blockedClient ties a blocking command to its client's connection, so a
+// client that disconnects while the command waits stops it instead of
+// leaving it waiting forever and popping elements nobody receives.
+// Watching only starts the first time the command actually has to wait.
+type blockedClient struct {
+	srv  *server.Server
+	conn net.Conn
+	ctx  context.Context
+	stop func()
+
+	// nonBlocking is set for commands run by EXEC, which like in Redis
+	// never block: the wait ends at once as if the timeout had passed
+	nonBlocking bool
+}
+
+func newBlockedClient(srv *server.Server, conn net.Conn) *blockedClient {
+	b := &blockedClient{srv: srv, conn: conn}
+	if c, ok := srv.Clients.Get(conn); ok {
+		b.nonBlocking = c.Capturing()
+	}
+	return b
+}
+
+// Done returns a channel closed once the client disconnects. Connections
+// that are not clients, like the master's on a replica, never close it.
+func (b *blockedClient) Done() <-chan struct{} {
+	if b.ctx == nil {
+		b.ctx, b.stop = context.Background(), func() {}
+		if c, ok := b.srv.Clients.Get(b.conn); ok {
+			b.ctx, b.stop = c.WatchDisconnect()
+		}
+	}
+	return b.ctx.Done()
+}
+
+// Stop stops watching the connection; it must be called before the command
+// returns so its client can be served again
+func (b *blockedClient) Stop() {
+	if b.stop != nil {
+		b.stop()
+	}
+}
+
+// beginBlockedWrite takes the write path (see server.BeginWriteFrom) for
+// one attempt of a command that may block to make its write: Execute runs
+// such commands without it, which would stall every write while they wait.
+// Run without blocking, the command holds it from Execute already, and
+// this does nothing.
+func beginBlockedWrite(srv *server.Server, conn net.Conn, blocking bool) (end func()) {
+	if !blocking {
+		return func() {}
+	}
+	return srv.BeginWriteFrom(conn)
+}
+
+// wait blocks until ready fires, expired fires (nil waits forever) or the
+// client disconnects, and returns waitExpired at once for a command that
+// may not block. A wake-up that races a disconnect counts as the
+// disconnect, so nothing is taken on behalf of a client that is gone.
+func (b *blockedClient) wait(ready <-chan struct{}, expired <-chan time.Time) waitResult {
+	if b.nonBlocking {
+		return waitExpired
+	}
+	select {
+	case <-ready:
+		if b.ctx.Err() != nil {
+			return waitGone
+		}
+		return waitReady
+	case <-expired:
+		return waitExpired
+	case <-b.Done():
+		return waitGone
+	}
+}
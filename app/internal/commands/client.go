@@ -0,0 +1,267 @@
+package commands
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/client"
+	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
+	"github.com/r0ld3x/redis-clone-go/app/internal/server"
+)
+
+// ClientHandler handles CLIENT commands
+type ClientHandler struct {
+	logger      *logging.Logger
+	subcommands subcommands
+}
+
+func (h *ClientHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("CLIENT")
+		h.subcommands = subcommands{command: "CLIENT", table: map[string]subcommand{
+			"ID":      {arity: 1, run: h.id},
+			"INFO":    {arity: 1, run: h.info},
+			"LIST":    {arity: -1, usage: "[TYPE normal|master|replica|pubsub]", run: h.list},
+			"SETNAME": {arity: 2, usage: "<name>", run: h.setName},
+			"GETNAME": {arity: 1, run: h.getName},
+			"KILL":    {arity: -2, usage: "<ip:port> | <filter> <value> [filter value ...]", run: h.kill},
+		}}
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if h.subcommands.dispatch(srv, clientConn, args) {
+		h.logger.Success("Command completed successfully")
+	}
+	return nil
+}
+
+// self returns the calling connection's client, replying with an error when
+// it isn't registered
+func (h *ClientHandler) self(srv *server.Server, clientConn net.Conn) (*client.Client, bool) {
+	c, ok := srv.Clients.Get(clientConn)
+	if !ok {
+		protocol.WriteError(clientConn, "ERR CLIENT is not available on this connection")
+	}
+	return c, ok
+}
+
+func (h *ClientHandler) id(srv *server.Server, clientConn net.Conn, args []string) {
+	if c, ok := h.self(srv, clientConn); ok {
+		protocol.WriteInteger(clientConn, int(c.ID))
+	}
+}
+
+func (h *ClientHandler) info(srv *server.Server, clientConn net.Conn, args []string) {
+	if c, ok := h.self(srv, clientConn); ok {
+		protocol.WriteBulkString(clientConn, formatClientInfo(srv, c)+"\n")
+	}
+}
+
+// list implements CLIENT LIST, optionally only the clients of one TYPE
+func (h *ClientHandler) list(srv *server.Server, clientConn net.Conn, args []string) {
+	kind := ""
+	if len(args) > 0 {
+		if len(args) != 2 || strings.ToUpper(args[0]) != "TYPE" {
+			protocol.WriteError(clientConn, "ERR syntax error")
+			return
+		}
+		var ok bool
+		if kind, ok = parseClientType(args[1]); !ok {
+			protocol.WriteError(clientConn, "ERR Unknown client type '"+args[1]+"'")
+			return
+		}
+	}
+
+	var lines strings.Builder
+	for _, other := range srv.Clients.List() {
+		if kind != "" && clientType(srv, other) != kind {
+			continue
+		}
+		lines.WriteString(formatClientInfo(srv, other))
+		lines.WriteString("\n")
+	}
+	protocol.WriteBulkString(clientConn, lines.String())
+}
+
+func (h *ClientHandler) setName(srv *server.Server, clientConn net.Conn, args []string) {
+	c, ok := h.self(srv, clientConn)
+	if !ok {
+		return
+	}
+	if strings.ContainsAny(args[0], " \n") {
+		protocol.WriteError(clientConn, "ERR Client names cannot contain spaces, newlines or special characters.")
+		return
+	}
+	c.SetName(args[0])
+	protocol.WriteSimpleString(clientConn, "OK")
+}
+
+func (h *ClientHandler) getName(srv *server.Server, clientConn net.Conn, args []string) {
+	c, ok := h.self(srv, clientConn)
+	if !ok {
+		return
+	}
+	name := c.Name()
+	if name == "" {
+		clientConn.Write([]byte("$-1\r\n"))
+		return
+	}
+	protocol.WriteBulkString(clientConn, name)
+}
+
+// kill implements CLIENT KILL, both the old "CLIENT KILL addr" form and the
+// filter form (ID, ADDR, LADDR, TYPE, SKIPME)
+func (h *ClientHandler) kill(srv *server.Server, clientConn net.Conn, args []string) {
+	self, ok := h.self(srv, clientConn)
+	if !ok {
+		return
+	}
+	if len(args) == 1 {
+		for _, other := range srv.Clients.List() {
+			if other.Conn.RemoteAddr().String() == args[0] {
+				protocol.WriteSimpleString(clientConn, "OK")
+				killClient(srv, other)
+				return
+			}
+		}
+		protocol.WriteError(clientConn, "ERR No such client")
+		return
+	}
+	if len(args)%2 != 0 {
+		protocol.WriteError(clientConn, "ERR syntax error")
+		return
+	}
+
+	var filters []func(*client.Client) bool
+	skipMe := true
+	for i := 0; i < len(args); i += 2 {
+		value := args[i+1]
+		switch strings.ToUpper(args[i]) {
+		case "ID":
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || id <= 0 {
+				protocol.WriteError(clientConn, "ERR client-id should be greater than 0")
+				return
+			}
+			filters = append(filters, func(c *client.Client) bool { return c.ID == id })
+		case "ADDR":
+			filters = append(filters, func(c *client.Client) bool { return c.Conn.RemoteAddr().String() == value })
+		case "LADDR":
+			filters = append(filters, func(c *client.Client) bool { return c.Conn.LocalAddr().String() == value })
+		case "TYPE":
+			kind, ok := parseClientType(value)
+			if !ok {
+				protocol.WriteError(clientConn, "ERR Unknown client type '"+value+"'")
+				return
+			}
+			filters = append(filters, func(c *client.Client) bool { return clientType(srv, c) == kind })
+		case "SKIPME":
+			switch strings.ToLower(value) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				protocol.WriteError(clientConn, "ERR syntax error")
+				return
+			}
+		default:
+			protocol.WriteError(clientConn, "ERR syntax error")
+			return
+		}
+	}
+
+	killed, killSelf := 0, false
+outer:
+	for _, other := range srv.Clients.List() {
+		for _, match := range filters {
+			if !match(other) {
+				continue outer
+			}
+		}
+		if other == self {
+			if !skipMe {
+				killed++
+				killSelf = true
+			}
+			continue
+		}
+		killClient(srv, other)
+		killed++
+	}
+
+	h.logger.Info("CLIENT KILL from %s disconnected %d clients", clientConn.RemoteAddr(), killed)
+	protocol.WriteInteger(clientConn, killed)
+	// Our own connection goes last so the reply above still reaches it
+	if killSelf {
+		killClient(srv, self)
+	}
+}
+
+// killClient disconnects c. A replica is dropped from replication right
+// away, not when its connection handler notices the disconnect, so no
+// further writes are propagated to it and a pending WAIT stops counting it.
+func killClient(srv *server.Server, c *client.Client) {
+	srv.RemoveReplica(c.Conn, "killed by CLIENT KILL")
+	c.Kill()
+}
+
+// parseClientType returns the type named by a TYPE filter, with slave as
+// an alias of replica, and false for an unknown one
+func parseClientType(value string) (string, bool) {
+	kind := strings.ToLower(value)
+	if kind == "slave" {
+		kind = "replica"
+	}
+	switch kind {
+	case "normal", "replica", "pubsub", "master":
+		return kind, true
+	}
+	return "", false
+}
+
+// clientType classifies c for the TYPE filters of CLIENT LIST and KILL. Our
+// link to the master is not a client connection, so no client is ever of
+// type master.
+func clientType(srv *server.Server, c *client.Client) string {
+	switch {
+	case srv.IsReplica(c.Conn):
+		return "replica"
+	case srv.PubSub.SubscriptionCount(c.Conn) > 0:
+		return "pubsub"
+	}
+	return "normal"
+}
+
+// formatClientInfo renders one client in the CLIENT INFO / CLIENT LIST
+// "field=value" format
+func formatClientInfo(srv *server.Server, c *client.Client) string {
+	stats := c.Stats()
+
+	flags := "N"
+	multi := -1
+	if srv.TransactionMgr.IsInTransaction(c.Conn) {
+		flags = "x"
+		multi = len(srv.TransactionMgr.GetQueuedCommands(c.Conn))
+	}
+	psub := srv.PubSub.PatternCount(c.Conn)
+	ssub := srv.PubSub.ShardCount(c.Conn)
+	sub := srv.PubSub.SubscriptionCount(c.Conn) - psub - ssub
+	if sub+psub+ssub > 0 {
+		flags = "P"
+	}
+	replState := ""
+	if state, ok := srv.ReplicaState(c.Conn); ok {
+		flags = "S"
+		replState = state.String()
+	}
+
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=0 sub=%d psub=%d ssub=%d multi=%d repl-state=%s qbuf=%d pipeline=%d tot-cmds=%d tot-net-in=%d tot-net-out=%d cmd=%s",
+		c.ID, c.Conn.RemoteAddr(), c.Conn.LocalAddr(), stats.Name,
+		int(stats.Age.Seconds()), int(stats.Idle.Seconds()), flags, sub, psub, ssub, multi, replState,
+		stats.QueryBuffer, stats.PipelineDepth, stats.Commands, stats.NetIn, stats.NetOut, stats.LastCommand)
+}
@@ -0,0 +1,200 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
+	"github.com/r0ld3x/redis-clone-go/app/internal/server"
+	"github.com/r0ld3x/redis-clone-go/app/pkg/cluster"
+)
+
+// ClusterHandler handles CLUSTER commands
+type ClusterHandler struct {
+	logger      *logging.Logger
+	subcommands subcommands
+}
+
+func (h *ClusterHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("CLUSTER")
+		h.subcommands = subcommands{command: "CLUSTER", table: map[string]subcommand{
+			"KEYSLOT": {arity: 2, usage: "<key>", run: h.keySlot},
+			"MYID": {arity: 1, run: h.withTopology(func(clientConn net.Conn, topology *cluster.Topology, _ []string) {
+				protocol.WriteBulkString(clientConn, topology.Myself().ID)
+			})},
+			"INFO": {arity: 1, run: h.withTopology(func(clientConn net.Conn, topology *cluster.Topology, _ []string) {
+				protocol.WriteBulkString(clientConn, clusterInfo(topology))
+			})},
+			"NODES": {arity: 1, run: h.withTopology(func(clientConn net.Conn, topology *cluster.Topology, _ []string) {
+				protocol.WriteBulkString(clientConn, clusterNodes(topology))
+			})},
+			"SLOTS":   {arity: 1, run: h.withTopology(h.writeSlots)},
+			"SETSLOT": {arity: -3, usage: "<slot> NODE <node-id>", run: h.withTopology(h.setSlot)},
+		}}
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if h.subcommands.dispatch(srv, clientConn, args) {
+		h.logger.Success("Command completed successfully")
+	}
+	return nil
+}
+
+// keySlot handles CLUSTER KEYSLOT, the one subcommand that works outside
+// cluster mode
+func (h *ClusterHandler) keySlot(srv *server.Server, clientConn net.Conn, args []string) {
+	slot := cluster.KeySlot(args[0])
+	h.logger.Debug("Key %s hashes to slot %d", args[0], slot)
+	protocol.WriteInteger(clientConn, slot)
+}
+
+// withTopology adapts a subcommand that needs a topology, refusing it when
+// the server is not in cluster mode
+func (h *ClusterHandler) withTopology(run func(clientConn net.Conn, topology *cluster.Topology, args []string)) func(*server.Server, net.Conn, []string) {
+	return func(srv *server.Server, clientConn net.Conn, args []string) {
+		if srv.Cluster == nil {
+			h.logger.Error("CLUSTER needs cluster mode for this subcommand")
+			protocol.WriteError(clientConn, "ERR This instance has cluster support disabled")
+			return
+		}
+		run(clientConn, srv.Cluster, args)
+	}
+}
+
+// setSlot handles CLUSTER SETSLOT <slot> NODE <node-id>. The migration
+// states (IMPORTING, MIGRATING, STABLE) need key migration, which a static
+// topology doesn't do.
+func (h *ClusterHandler) setSlot(clientConn net.Conn, topology *cluster.Topology, args []string) {
+	slot, err := strconv.Atoi(args[0])
+	if err != nil || slot < 0 || slot >= cluster.SlotCount {
+		protocol.WriteError(clientConn, "ERR Invalid or out of range slot")
+		return
+	}
+	mode := strings.ToUpper(args[1])
+	if mode != "NODE" {
+		protocol.WriteError(clientConn, fmt.Sprintf("ERR CLUSTER SETSLOT %s is not supported with a static cluster config", mode))
+		return
+	}
+	if len(args) != 3 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'CLUSTER|SETSLOT' command")
+		return
+	}
+
+	err = topology.SetSlot(slot, args[2])
+	if errors.Is(err, cluster.ErrUnknownNode) {
+		protocol.WriteError(clientConn, "ERR I don't know about node "+args[2])
+		return
+	}
+	if err != nil {
+		// The slot was reassigned; only persisting it failed
+		h.logger.Error("Failed to save cluster config: %v", err)
+		protocol.WriteError(clientConn, "ERR Slot assigned but the cluster config could not be saved: "+err.Error())
+		return
+	}
+
+	h.logger.Info("Slot %d assigned to node %s", slot, args[2])
+	protocol.WriteSimpleString(clientConn, "OK")
+}
+
+// clusterInfo renders CLUSTER INFO. With no gossip every node listed is
+// assumed up, so the state is ok once every slot is served.
+func clusterInfo(topology *cluster.Topology) string {
+	_, assigned := topology.SlotCounts()
+	state := "ok"
+	if assigned < cluster.SlotCount {
+		state = "fail"
+	}
+	nodes := topology.Nodes()
+	masters := 0
+	for _, node := range nodes {
+		if len(node.Slots) > 0 {
+			masters++
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "cluster_state:%s\r\n", state)
+	fmt.Fprintf(&b, "cluster_slots_assigned:%d\r\n", assigned)
+	fmt.Fprintf(&b, "cluster_slots_ok:%d\r\n", assigned)
+	b.WriteString("cluster_slots_pfail:0\r\n")
+	b.WriteString("cluster_slots_fail:0\r\n")
+	fmt.Fprintf(&b, "cluster_known_nodes:%d\r\n", len(nodes))
+	fmt.Fprintf(&b, "cluster_size:%d\r\n", masters)
+	return b.String()
+}
+
+// clusterNodes renders CLUSTER NODES in Redis' format, one line per node:
+//
+//	<id> <ip:port@cport> <flags> <master> <ping-sent> <pong-recv> <epoch> <link-state> <slot> ...
+func clusterNodes(topology *cluster.Topology) string {
+	var b strings.Builder
+	for _, node := range topology.Nodes() {
+		flags := "master"
+		if node.Myself {
+			flags = "myself,master"
+		}
+		fmt.Fprintf(&b, "%s %s@0 %s - 0 0 0 connected", node.ID, node.Addr, flags)
+		for _, r := range node.Slots {
+			b.WriteString(" " + r.String())
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// writeSlots writes CLUSTER SLOTS: for each slot range, its first and last
+// slot and the [host, port, id] of the node serving it
+func (h *ClusterHandler) writeSlots(clientConn net.Conn, topology *cluster.Topology, _ []string) {
+	var ranges []string
+	for _, node := range topology.Nodes() {
+		host, portStr, _ := net.SplitHostPort(node.Addr)
+		port, _ := strconv.Atoi(portStr)
+		for _, r := range node.Slots {
+			ranges = append(ranges, "*3\r\n"+
+				protocol.FormatInteger(r.First)+
+				protocol.FormatInteger(r.Last)+
+				"*3\r\n"+
+				protocol.FormatBulkString(host)+
+				protocol.FormatInteger(port)+
+				protocol.FormatBulkString(node.ID))
+		}
+	}
+	protocol.WriteArray2(clientConn, ranges)
+}
+
+// ClusterRedirect checks that this node serves the keys of a command about
+// to run in cluster mode. It returns the error to reply with instead of
+// running it: CROSSSLOT when the keys span slots, CLUSTERDOWN when their
+// slot is unassigned and MOVED when another node serves it. It returns ""
+// when the command may run here, always so outside cluster mode.
+func ClusterRedirect(srv *server.Server, cmd Command, args []string) string {
+	if srv.Cluster == nil {
+		return ""
+	}
+	keys := commandKeys(cmd, args)
+	if len(keys) == 0 {
+		return ""
+	}
+
+	slot := cluster.KeySlot(keys[0])
+	for _, key := range keys[1:] {
+		if cluster.KeySlot(key) != slot {
+			return "CROSSSLOT Keys in request don't hash to the same slot"
+		}
+	}
+
+	owner, ok := srv.Cluster.Owner(slot)
+	if !ok {
+		return "CLUSTERDOWN Hash slot not served"
+	}
+	if owner.ID != srv.Cluster.Myself().ID {
+		return fmt.Sprintf("MOVED %d %s", slot, owner.Addr)
+	}
+	return ""
+}
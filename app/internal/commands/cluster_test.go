@@ -0,0 +1,88 @@
+package commands
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/r0ld3x/redis-clone-go/app/pkg/cluster"
+)
+
+// Slots of the hash tags the cluster tests use: {a} is served here, {b} by
+// the other node and {c} by no one
+var slotA, slotB, slotC = cluster.KeySlot("a"), cluster.KeySlot("b"), cluster.KeySlot("c")
+
+func newClusterHarness(t *testing.T) *harness {
+	t.Helper()
+	h := newHarness(t)
+	h.inCluster(fmt.Sprintf("self localhost:6379 myself %d\nother localhost:6380 - %d\n", slotA, slotB))
+	return h
+}
+
+func TestClusterKeySlot(t *testing.T) {
+	h := newHarness(t)
+	h.expect("(integer) 12182", "CLUSTER", "KEYSLOT", "foo")
+	h.expect("(integer) 3443", "CLUSTER", "KEYSLOT", "{user1000}.following")
+	h.expect("(error) ERR This instance has cluster support disabled", "CLUSTER", "INFO")
+	h.expect("(error) ERR This instance has cluster support disabled", "CLUSTER", "SETSLOT", "0", "NODE", "self")
+}
+
+func TestClusterRedirects(t *testing.T) {
+	h := newClusterHarness(t)
+	h.expect(`"OK"`, "SET", "{a}x", "1")
+	h.expect(`"1"`, "GET", "{a}x")
+	h.do("SADD", "{a}s", "m")
+	h.expect("(integer) 1", "SUNIONSTORE", "{a}t", "{a}s", "{a}missing")
+	h.expect("(error) MOVED "+strconv.Itoa(slotB)+" localhost:6380", "GET", "{b}x")
+	h.expect("(error) CROSSSLOT Keys in request don't hash to the same slot", "SUNIONSTORE", "{a}t", "{a}s", "{b}s")
+	h.expect("(error) CROSSSLOT Keys in request don't hash to the same slot", "DEL", "{a}x", "{c}x")
+	h.expect("(error) CLUSTERDOWN Hash slot not served", "GET", "{c}x")
+	// Keyless commands run anywhere
+	h.expect(`"PONG"`, "PING")
+
+	// Inside MULTI the redirect is the reply instead of QUEUED
+	if refused := h.registry.QueueError(h.srv, "INCR", []string{"{a}n"}); refused != "" {
+		t.Errorf("queueing INCR {a}n is refused with %s", refused)
+	}
+	if refused, want := h.registry.QueueError(h.srv, "INCR", []string{"{b}n"}), "MOVED "+strconv.Itoa(slotB)+" localhost:6380"; refused != want {
+		t.Errorf("queueing INCR {b}n is refused with %q, want %q", refused, want)
+	}
+}
+
+func TestClusterInfoAndNodes(t *testing.T) {
+	h := newClusterHarness(t)
+	h.expect(`"self"`, "CLUSTER", "MYID")
+
+	info := h.do("CLUSTER", "INFO").Str
+	for _, line := range []string{"cluster_state:fail", "cluster_slots_assigned:2", "cluster_known_nodes:2", "cluster_size:2"} {
+		if !strings.Contains(info, line+"\r\n") {
+			t.Errorf("CLUSTER INFO has no %s:\n%s", line, info)
+		}
+	}
+
+	want := fmt.Sprintf("self localhost:6379@0 myself,master - 0 0 0 connected %d\nother localhost:6380@0 master - 0 0 0 connected %d\n", slotA, slotB)
+	if got := h.do("CLUSTER", "NODES").Str; got != want {
+		t.Errorf("CLUSTER NODES =\n%s\nwant\n%s", got, want)
+	}
+	h.expect(fmt.Sprintf(`[[(integer) %d, (integer) %d, ["localhost", (integer) 6379, "self"]], [(integer) %d, (integer) %d, ["localhost", (integer) 6380, "other"]]]`,
+		slotA, slotA, slotB, slotB), "CLUSTER", "SLOTS")
+}
+
+func TestClusterSetSlot(t *testing.T) {
+	h := newClusterHarness(t)
+	b, c := strconv.Itoa(slotB), strconv.Itoa(slotC)
+
+	h.expect(`"OK"`, "CLUSTER", "SETSLOT", b, "NODE", "self")
+	h.expect("(nil)", "GET", "{b}x")
+	h.expect(`"OK"`, "CLUSTER", "SETSLOT", c, "NODE", "other")
+	h.expect("(error) MOVED "+c+" localhost:6380", "GET", "{c}x")
+	if info := h.do("CLUSTER", "INFO").Str; !strings.Contains(info, "cluster_slots_assigned:3\r\n") || !strings.Contains(info, "cluster_size:2\r\n") {
+		t.Errorf("CLUSTER INFO after assigning a third slot:\n%s", info)
+	}
+
+	h.expect("(error) ERR I don't know about node nobody", "CLUSTER", "SETSLOT", c, "NODE", "nobody")
+	h.expect("(error) ERR Invalid or out of range slot", "CLUSTER", "SETSLOT", "16384", "NODE", "self")
+	h.expect("(error) ERR CLUSTER SETSLOT MIGRATING is not supported with a static cluster config", "CLUSTER", "SETSLOT", c, "MIGRATING", "self")
+	h.expect("(error) MOVED "+c+" localhost:6380", "GET", "{c}x")
+}
@@ -0,0 +1,81 @@
+package commands
+
+import "testing"
+
+func TestListPushRange(t *testing.T) {
+	h := newHarness(t)
+	h.expect("(integer) 3", "RPUSH", "l", "a", "b", "c")
+	h.expect("(integer) 4", "LPUSH", "l", "z")
+	h.expect(`["z", "a", "b", "c"]`, "LRANGE", "l", "0", "-1")
+	h.expect("(integer) 4", "LLEN", "l")
+	h.expect(`"c"`, "RPOP", "l")
+	h.expect(`["b", "a"]`, "RPOP", "l", "2")
+}
+
+func TestHash(t *testing.T) {
+	h := newHarness(t)
+	h.expect("(integer) 2", "HSET", "h", "a", "1", "b", "2")
+	h.expect(`"1"`, "HGET", "h", "a")
+	h.expect("(integer) 5", "HINCRBY", "h", "a", "4")
+	h.expect("(integer) 1", "HDEL", "h", "b", "c")
+	h.expect("(integer) 1", "HLEN", "h")
+	h.expect("(integer) 1", "HDEL", "h", "a")
+	h.expect(`"none"`, "TYPE", "h")
+}
+
+func TestSet(t *testing.T) {
+	h := newHarness(t)
+	h.expect("(integer) 2", "SADD", "s", "a", "b", "a")
+	h.expect("(integer) 1", "SISMEMBER", "s", "a")
+	h.expect("(integer) 2", "SCARD", "s")
+	h.do("SADD", "t", "b", "c")
+	h.expect(`["b"]`, "SINTER", "s", "t")
+	h.expect("(integer) 2", "SREM", "s", "a", "b")
+	h.expect(`"none"`, "TYPE", "s")
+}
+
+func TestSortedSet(t *testing.T) {
+	h := newHarness(t)
+	h.expect("(integer) 3", "ZADD", "z", "1", "a", "3", "c", "2", "b")
+	h.expect(`["a", "b", "c"]`, "ZRANGE", "z", "0", "-1")
+	h.expect(`"2"`, "ZSCORE", "z", "b")
+	h.expect("(integer) 0", "ZADD", "z", "GT", "1", "c")
+	h.expect(`"3"`, "ZSCORE", "z", "c")
+	h.expect("(integer) 1", "ZRANK", "z", "b")
+	h.expect(`["c", "3"]`, "ZPOPMAX", "z")
+}
+
+func TestWrongType(t *testing.T) {
+	h := newHarness(t)
+	h.do("SET", "k", "v")
+	for _, args := range [][]string{
+		{"RPUSH", "k", "x"},
+		{"HSET", "k", "f", "v"},
+		{"SADD", "k", "m"},
+		{"ZADD", "k", "1", "m"},
+	} {
+		if reply := h.do(args...); reply.Str != "WRONGTYPE Operation against a key holding the wrong kind of value" {
+			t.Errorf("%v = %s, want WRONGTYPE", args, reply)
+		}
+	}
+}
+
+func TestPopCount(t *testing.T) {
+	for _, pop := range []string{"LPOP", "RPOP"} {
+		t.Run(pop, func(t *testing.T) {
+			h := newHarness(t)
+			h.do("RPUSH", "l", "a", "b", "c")
+			h.expect("[]", pop, "l", "0")
+			h.expect("(integer) 3", "LLEN", "l")
+			if reply := h.do(pop, "l", "-1"); !reply.IsError() {
+				t.Fatalf("%s l -1 = %s, want an error", pop, reply)
+			}
+			h.expect("(nil)", pop, "missing", "1")
+			h.expect("(nil)", pop, "missing")
+			if got := h.do(pop, "l", "5"); len(got.Array) != 3 {
+				t.Fatalf("%s l 5 = %s, want all 3 elements", pop, got)
+			}
+			h.expect(`"none"`, "TYPE", "l")
+		})
+	}
+}
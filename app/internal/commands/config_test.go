@@ -0,0 +1,109 @@
+package commands
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/audit"
+)
+
+func TestConfigParams(t *testing.T) {
+	// A value to set and one to refuse for each runtime parameter; the
+	// others must be refused as immutable. Every registered parameter needs
+	// an entry, so one added without being tested here fails.
+	settable := map[string]struct {
+		value, want, invalid string
+	}{
+		"audit-log":                   {"yes", "yes", "maybe"},
+		"slowlog-log-slower-than":     {"500", "500", "fast"},
+		"slowlog-max-len":             {"7", "7", "-1"},
+		"store-propagation":           {"EFFECTS", "effects", "both"},
+		"replica-lazy-flush":          {"yes", "yes", "maybe"},
+		"repl-log-sample":             {"3", "3", "-1"},
+		"repl-batch-usec":             {"250", "250", "soon"},
+		"loglevel":                    {"error", "error", "loud"},
+		"log-format":                  {"redis", "redis", "json"},
+		"unknown-command-suggestions": {"yes", "yes", "maybe"},
+		"multi-allow-wait":            {"yes", "yes", "maybe"},
+		"lock-profiling":              {"yes", "yes", "maybe"},
+		"activedefrag":                {"yes", "yes", "maybe"},
+		"shutdown-timeout":            {"3", "3", "-2"},
+		"proto-max-bulk-len":          {"2097152", "2097152", "1024"},
+		"notify-keyspace-events":      {"Kx", "xK", "Kq"},
+	}
+	immutable := map[string]bool{
+		"dir": true, "dbfilename": true, "port": true, "replicaof": true, "masteruser": true,
+		"audit-logfile": true, "enable-debug-command": true, "stream-max-entry-fields": true,
+		"stream-max-entry-size": true, "stream-max-memory": true, "repl-backlog-size": true,
+		"logfile": true, "cluster-config-file": true, "event-loop": true, "pubsub-history-len": true,
+	}
+
+	for name := range settable {
+		if _, found := lookupConfigParam(name); !found {
+			t.Errorf("%s is not a registered parameter", name)
+		}
+	}
+	for name := range immutable {
+		if _, found := lookupConfigParam(name); !found {
+			t.Errorf("%s is not a registered parameter", name)
+		}
+	}
+
+	h := newHarness(t)
+	h.srv.Audit = audit.NewLog(filepath.Join(t.TempDir(), "audit.log"))
+	for _, p := range configParams {
+		t.Run(p.name, func(t *testing.T) {
+			h.t = t
+			set, runtime := settable[p.name]
+			if !runtime && !immutable[p.name] {
+				t.Fatalf("%s is not covered by this test", p.name)
+			}
+			if runtime != (p.set != nil) {
+				t.Fatalf("%s: settable at runtime is %t, want %t", p.name, p.set != nil, runtime)
+			}
+
+			// The value shows the same in CONFIG GET, INFO and the startup log
+			check := func(want string) {
+				t.Helper()
+				if got := h.do("CONFIG", "GET", p.name).Strings(); len(got) != 2 || got[0] != p.name || got[1] != want {
+					t.Errorf("CONFIG GET %s = %v, want [%s %s]", p.name, got, p.name, want)
+				}
+				field := strings.ReplaceAll(p.name, "-", "_") + ":" + want + "\r\n"
+				if info := h.do("INFO", "config").Str; !strings.Contains(info, field) {
+					t.Errorf("INFO config has no %q", field)
+				}
+				for _, v := range ConfigValues(h.srv) {
+					if v.Name == p.name && v.Value != want {
+						t.Errorf("the startup log shows %s = %s, want %s", p.name, v.Value, want)
+					}
+				}
+			}
+			before := p.get(h.srv)
+			check(before)
+
+			if reply := h.do("CONFIG", "REWRITE"); reply.Str != "ERR The server is running without a config file" {
+				t.Errorf("CONFIG REWRITE = %s", reply)
+			}
+
+			if !runtime {
+				h.expect("(error) ERR CONFIG SET failed (possibly related to argument '"+p.name+"') - can't set immutable config",
+					"CONFIG", "SET", p.name, before)
+				return
+			}
+			if set.want == before {
+				t.Fatalf("%s is already %s, so setting it shows nothing", p.name, before)
+			}
+			h.expect(`"OK"`, "CONFIG", "SET", strings.ToUpper(p.name), set.value)
+			check(set.want)
+			if reply := h.do("CONFIG", "SET", p.name, set.invalid); !strings.HasPrefix(reply.Str, "ERR CONFIG SET failed (possibly related to argument '"+p.name+"')") {
+				t.Errorf("CONFIG SET %s %s = %s, want it refused", p.name, set.invalid, reply)
+			}
+			check(set.want)
+
+			// Parameters may be process wide, so put them back
+			h.expect(`"OK"`, "CONFIG", "SET", p.name, before)
+			check(before)
+		})
+	}
+}
@@ -0,0 +1,287 @@
+package commands
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/config"
+	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
+	"github.com/r0ld3x/redis-clone-go/app/internal/server"
+
+	"github.com/r0ld3x/redis-clone-go/app/pkg/database"
+)
+
+// configParam is a configuration parameter as CONFIG GET and SET see it.
+// configParams is the one list of them: CONFIG GET, CONFIG SET, the config
+// INFO section and the startup log all read it, so a parameter added here
+// shows up everywhere and one left out shows up nowhere.
+type configParam struct {
+	name string
+	get  func(srv *server.Server) string
+	// set applies value and returns why it was refused, "" on success; nil
+	// for parameters only settable at startup
+	set func(srv *server.Server, clientConn net.Conn, value string) string
+}
+
+// configParams lists every parameter in the order of the command line flags.
+// masterauth is left out so the password doesn't end up in replies or logs.
+var configParams = []configParam{
+	{name: "dir", get: func(srv *server.Server) string { return srv.Config.Directory }},
+	{name: "dbfilename", get: func(srv *server.Server) string { return srv.Config.DBFileName }},
+	{name: "port", get: func(srv *server.Server) string { return srv.Config.Port }},
+	{name: "replicaof", get: replicaOf},
+	{name: "masteruser", get: func(srv *server.Server) string { return srv.Config.MasterUser }},
+	{name: "audit-log", get: func(srv *server.Server) string { return formatYesNo(srv.Audit.Enabled()) }, set: setAuditLog},
+	{name: "audit-logfile", get: func(srv *server.Server) string { return srv.Config.AuditLogFile }},
+	{name: "enable-debug-command", get: func(srv *server.Server) string { return formatYesNo(srv.Config.EnableDebugCommand) }},
+	{name: "stream-max-entry-fields", get: func(srv *server.Server) string { return strconv.Itoa(srv.Config.StreamMaxEntryFields) }},
+	{name: "stream-max-entry-size", get: func(srv *server.Server) string { return strconv.Itoa(srv.Config.StreamMaxEntrySize) }},
+	{name: "stream-max-memory", get: func(srv *server.Server) string { return strconv.Itoa(srv.Config.StreamMaxMemory) }},
+	{
+		name: "slowlog-log-slower-than",
+		get: func(srv *server.Server) string {
+			return strconv.FormatInt(srv.SlowLog.Threshold().Microseconds(), 10)
+		},
+		set: func(srv *server.Server, _ net.Conn, value string) string {
+			micros, err := strconv.Atoi(value)
+			if err != nil {
+				return "argument couldn't be parsed into an integer"
+			}
+			srv.SlowLog.SetThreshold(time.Duration(micros) * time.Microsecond)
+			srv.Config.SlowlogLogSlowerThan = micros
+			return ""
+		},
+	},
+	{
+		name: "slowlog-max-len",
+		get:  func(srv *server.Server) string { return strconv.Itoa(srv.SlowLog.MaxLen()) },
+		set: func(srv *server.Server, _ net.Conn, value string) string {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return "argument must be a non-negative integer"
+			}
+			srv.SlowLog.SetMaxLen(n)
+			srv.Config.SlowlogMaxLen = n
+			return ""
+		},
+	},
+	{
+		name: "store-propagation",
+		get:  func(srv *server.Server) string { return srv.Config.StorePropagation },
+		set: func(srv *server.Server, _ net.Conn, value string) string {
+			mode := strings.ToLower(value)
+			if !config.ValidStorePropagation(mode) {
+				return "argument must be 'verbatim' or 'effects'"
+			}
+			srv.Config.StorePropagation = mode
+			return ""
+		},
+	},
+	boolParam("replica-lazy-flush", func(c *config.Config) *bool { return &c.ReplicaLazyFlush }),
+	{
+		name: "repl-log-sample",
+		get:  func(srv *server.Server) string { return strconv.Itoa(srv.ReplLog.Every()) },
+		set: func(srv *server.Server, _ net.Conn, value string) string {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return "argument must be a non-negative integer"
+			}
+			srv.ReplLog.SetEvery(n)
+			srv.Config.ReplLogSample = n
+			return ""
+		},
+	},
+	{
+		name: "repl-batch-usec",
+		get:  func(srv *server.Server) string { return strconv.Itoa(srv.Config.ReplBatchMicros) },
+		set: func(srv *server.Server, _ net.Conn, value string) string {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return "argument must be a non-negative integer"
+			}
+			srv.SetReplBatchDelay(time.Duration(n) * time.Microsecond)
+			srv.Config.ReplBatchMicros = n
+			return ""
+		},
+	},
+	{name: "repl-backlog-size", get: func(srv *server.Server) string { return strconv.Itoa(srv.Config.ReplBacklogSize) }},
+	{
+		name: "loglevel",
+		get:  func(srv *server.Server) string { return logging.CurrentLevel().String() },
+		set: func(srv *server.Server, _ net.Conn, value string) string {
+			level, ok := logging.ParseLevel(value)
+			if !ok {
+				return "argument must be one of debug, info, error, none"
+			}
+			logging.SetLevel(level)
+			srv.Config.LogLevel = level.String()
+			return ""
+		},
+	},
+	{name: "logfile", get: func(srv *server.Server) string { return srv.Config.LogFile }},
+	{
+		name: "log-format",
+		get:  func(srv *server.Server) string { return logging.CurrentFormat().String() },
+		set: func(srv *server.Server, _ net.Conn, value string) string {
+			format, ok := logging.ParseFormat(value)
+			if !ok {
+				return "argument must be 'default' or 'redis'"
+			}
+			logging.SetFormat(format)
+			srv.Config.LogFormat = format.String()
+			return ""
+		},
+	},
+	boolParam("unknown-command-suggestions", func(c *config.Config) *bool { return &c.UnknownCommandSuggestions }),
+	boolParam("multi-allow-wait", func(c *config.Config) *bool { return &c.MultiAllowWait }),
+	{
+		name: "lock-profiling",
+		get:  func(srv *server.Server) string { return formatYesNo(database.LockProfiling()) },
+		set: func(srv *server.Server, _ net.Conn, value string) string {
+			enabled, ok := parseYesNo(value)
+			if !ok {
+				return "argument must be 'yes' or 'no'"
+			}
+			database.SetLockProfiling(enabled)
+			srv.Config.LockProfiling = enabled
+			return ""
+		},
+	},
+	{
+		name: "activedefrag",
+		get:  func(srv *server.Server) string { return formatYesNo(database.ActiveDefrag()) },
+		set: func(srv *server.Server, _ net.Conn, value string) string {
+			enabled, ok := parseYesNo(value)
+			if !ok {
+				return "argument must be 'yes' or 'no'"
+			}
+			database.SetActiveDefrag(enabled)
+			srv.Config.ActiveDefrag = enabled
+			return ""
+		},
+	},
+	{
+		name: "shutdown-timeout",
+		get:  func(srv *server.Server) string { return strconv.Itoa(srv.Config.ShutdownTimeout) },
+		set: func(srv *server.Server, _ net.Conn, value string) string {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return "argument must be a non-negative integer"
+			}
+			srv.Config.ShutdownTimeout = n
+			return ""
+		},
+	},
+	{name: "cluster-config-file", get: func(srv *server.Server) string { return srv.Config.ClusterConfigFile }},
+	{name: "event-loop", get: func(srv *server.Server) string { return formatYesNo(srv.Config.EventLoop) }},
+	{name: "pubsub-history-len", get: func(srv *server.Server) string { return strconv.Itoa(srv.Config.PubSubHistoryLen) }},
+	{
+		name: "proto-max-bulk-len",
+		get:  func(srv *server.Server) string { return strconv.Itoa(protocol.MaxBulkLen()) },
+		set: func(srv *server.Server, _ net.Conn, value string) string {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < protocol.MinMaxBulkLen {
+				return "argument must be at least 1048576"
+			}
+			protocol.SetMaxBulkLen(n)
+			database.SetMaxStringSize(n)
+			srv.Config.ProtoMaxBulkLen = n
+			return ""
+		},
+	},
+	{
+		name: "notify-keyspace-events",
+		get:  func(srv *server.Server) string { return formatKeyspaceEvents(keyspaceEvents.Load()) },
+		set: func(srv *server.Server, _ net.Conn, value string) string {
+			if !SetKeyspaceEvents(value) {
+				return "argument must only hold the characters KEA g$lshzxetmnd"
+			}
+			srv.Config.NotifyKeyspaceEvents = value
+			return ""
+		},
+	},
+}
+
+// boolParam is a yes/no parameter kept in the Config field that field points
+// to, with nothing else to update when it changes
+func boolParam(name string, field func(c *config.Config) *bool) configParam {
+	return configParam{
+		name: name,
+		get:  func(srv *server.Server) string { return formatYesNo(*field(srv.Config)) },
+		set: func(srv *server.Server, _ net.Conn, value string) string {
+			enabled, ok := parseYesNo(value)
+			if !ok {
+				return "argument must be 'yes' or 'no'"
+			}
+			*field(srv.Config) = enabled
+			return ""
+		},
+	}
+}
+
+// replicaOf renders the master this server replicates as "host port", empty
+// on a master
+func replicaOf(srv *server.Server) string {
+	if srv.Config.MasterAddress == "" {
+		return ""
+	}
+	host, port, _ := net.SplitHostPort(srv.Config.MasterAddress)
+	return host + " " + port
+}
+
+// setAuditLog opens or closes the audit log
+func setAuditLog(srv *server.Server, clientConn net.Conn, value string) string {
+	enabled, ok := parseYesNo(value)
+	if !ok {
+		return "argument must be 'yes' or 'no'"
+	}
+	if !enabled {
+		// Record the switch-off itself; the dispatcher can no longer do it
+		// once the log is closed.
+		AuditCommand(srv, clientConn, "CONFIG", []string{"SET", "audit-log", value})
+	}
+	if err := srv.Audit.SetEnabled(enabled); err != nil {
+		return err.Error()
+	}
+	srv.Config.AuditLog = enabled
+	return ""
+}
+
+// lookupConfigParam finds a parameter by name, ignoring case
+func lookupConfigParam(name string) (configParam, bool) {
+	name = strings.ToLower(name)
+	for _, p := range configParams {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return configParam{}, false
+}
+
+// ConfigValue is a parameter with its current value
+type ConfigValue struct {
+	Name, Value string
+}
+
+// ConfigValues returns every parameter with its current value, for the
+// startup log
+func ConfigValues(srv *server.Server) []ConfigValue {
+	values := make([]ConfigValue, len(configParams))
+	for i, p := range configParams {
+		values[i] = ConfigValue{Name: p.name, Value: p.get(srv)}
+	}
+	return values
+}
+
+// configInfo renders every parameter for INFO, with the underscores INFO
+// fields use in place of dashes
+func configInfo(srv *server.Server) string {
+	var b strings.Builder
+	for _, p := range configParams {
+		b.WriteString(strings.ReplaceAll(p.name, "-", "_") + ":" + p.get(srv) + "\r\n")
+	}
+	return b.String()
+}
@@ -2,15 +2,16 @@ package commands
 
 import (
 	"net"
-	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
 	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
 	"github.com/r0ld3x/redis-clone-go/app/internal/server"
 
 	"github.com/r0ld3x/redis-clone-go/app/pkg/database"
+	"github.com/r0ld3x/redis-clone-go/app/pkg/glob"
 )
 
 // GetHandler handles GET commands
@@ -23,7 +24,11 @@ func (h *GetHandler) Handle(srv *server.Server, clientConn net.Conn, args []stri
 		h.logger = logging.NewLogger("GET")
 	}
 
-	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+	// Suppressed log calls still cost GET an allocation per argument
+	traced := logging.Enabled(logging.LevelInfo)
+	if traced {
+		h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+	}
 
 	if len(args) < 1 {
 		h.logger.Error("Wrong number of arguments: %d", len(args))
@@ -32,19 +37,25 @@ func (h *GetHandler) Handle(srv *server.Server, clientConn net.Conn, args []stri
 	}
 
 	key := args[0]
-	h.logger.Debug("Looking up key: %s", key)
+	if traced {
+		h.logger.Debug("Looking up key: %s", key)
+	}
 
 	val, success := database.GetKey(key)
 	if !success {
-		h.logger.Info("Key not found: %s", key)
-		h.logger.Network("OUT", "Sending null response")
+		if traced {
+			h.logger.Info("Key not found: %s", key)
+			h.logger.Network("OUT", "Sending null response")
+		}
 		clientConn.Write([]byte("$-1\r\n"))
 		return nil
 	}
 
-	h.logger.Info("Key found: %s = %s", key, val)
-	h.logger.Network("OUT", "Sending value: %s", val)
-	protocol.WriteSimpleString(clientConn, val)
+	if traced {
+		h.logger.Info("Key found: %s = %s", key, val)
+		h.logger.Network("OUT", "Sending value: %s", val)
+	}
+	protocol.WriteBulkString(clientConn, val)
 	h.logger.Success("Command completed successfully")
 	return nil
 }
@@ -59,7 +70,11 @@ func (h *SetHandler) Handle(srv *server.Server, clientConn net.Conn, args []stri
 		h.logger = logging.NewLogger("SET")
 	}
 
-	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+	// Suppressed log calls still cost SET an allocation per argument
+	traced := logging.Enabled(logging.LevelInfo)
+	if traced {
+		h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+	}
 
 	if len(args) < 2 {
 		h.logger.Error("Wrong number of arguments: %d", len(args))
@@ -67,30 +82,32 @@ func (h *SetHandler) Handle(srv *server.Server, clientConn net.Conn, args []stri
 		return nil
 	}
 
-	if !srv.IsMaster() {
-		h.logger.Error("Attempted write on replica from %s", clientConn.RemoteAddr())
-		protocol.WriteError(clientConn, "READONLY You can't write against a read only replica.")
-		return nil
-	}
-
 	key, val := args[0], args[1]
-	ms := -1
-	if len(args) == 4 && strings.ToUpper(args[2]) == "PX" {
-		ms, _ = strconv.Atoi(args[3])
+	var at time.Time
+	if len(args) > 2 {
+		var errMsg string
+		if at, errMsg = parseSetExpiry(args[2:]); errMsg != "" {
+			protocol.WriteError(clientConn, errMsg)
+			return nil
+		}
 	}
 
-	h.logger.Debug("Storing key=%s value=%s TTL(ms)=%d", key, val, ms)
-	database.SetKey(key, val, ms)
-	h.logger.Info("Key stored successfully: %s = %s", key, val)
-
-	command := []string{"SET", key, val}
-	if ms > -1 {
-		command = append(command, "PX", strconv.Itoa(ms))
+	if at.IsZero() {
+		database.SetKey(key, val, -1)
+		srv.ReplicateCommand(clientConn, []string{"SET", key, val})
+	} else {
+		// Replicated like EXPIRE
+		database.SetKeyAt(key, val, at)
+		if at.After(time.Now()) || !srv.IsMaster() {
+			srv.ReplicateCommand(clientConn, []string{"SET", key, val, "PXAT", strconv.FormatInt(at.UnixMilli(), 10)})
+		} else if database.DeleteIfExpired(key) {
+			srv.ReplicateCommand(clientConn, []string{"DEL", key})
+		}
+	}
+	if traced {
+		h.logger.Info("Key stored successfully: %s = %s", key, val)
+		h.logger.Network("OUT", "Sending OK response to client")
 	}
-
-	srv.ReplicateCommand(command)
-
-	h.logger.Network("OUT", "Sending OK response to client")
 	protocol.WriteSimpleString(clientConn, "OK")
 	h.logger.Success("Command completed successfully")
 	return nil
@@ -134,11 +151,213 @@ func (h *IncrHandler) Handle(srv *server.Server, clientConn net.Conn, args []str
 		return nil
 	}
 
+	srv.ReplicateCommand(clientConn, append([]string{"INCR"}, args...))
 	protocol.WriteInteger(clientConn, receivedInt)
 	h.logger.Success("Command completed successfully")
 	return nil
 }
 
+// AppendHandler handles APPEND commands
+type AppendHandler struct {
+	logger *logging.Logger
+}
+
+func (h *AppendHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("APPEND")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'APPEND' command")
+		return nil
+	}
+
+	length, err := database.StringAppend(args[0], args[1])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	srv.ReplicateCommand(clientConn, append([]string{"APPEND"}, args...))
+
+	protocol.WriteInteger(clientConn, length)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// SetRangeHandler handles SETRANGE commands
+type SetRangeHandler struct {
+	logger *logging.Logger
+}
+
+func (h *SetRangeHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("SETRANGE")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 3 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'SETRANGE' command")
+		return nil
+	}
+
+	offset, err := strconv.Atoi(args[1])
+	if err != nil {
+		protocol.WriteError(clientConn, "ERR value is not an integer or out of range")
+		return nil
+	}
+	if offset < 0 {
+		protocol.WriteError(clientConn, "ERR offset is out of range")
+		return nil
+	}
+
+	length, err := database.StringSetRange(args[0], offset, args[2])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	if args[2] != "" {
+		srv.ReplicateCommand(clientConn, append([]string{"SETRANGE"}, args...))
+	}
+
+	protocol.WriteInteger(clientConn, length)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// SetBitHandler handles SETBIT key offset value
+type SetBitHandler struct {
+	logger *logging.Logger
+}
+
+func (h *SetBitHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("SETBIT")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 3 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'SETBIT' command")
+		return nil
+	}
+
+	offset, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		protocol.WriteError(clientConn, database.ErrBitOffset.Error())
+		return nil
+	}
+	if args[2] != "0" && args[2] != "1" {
+		protocol.WriteError(clientConn, database.ErrBitValue.Error())
+		return nil
+	}
+
+	old, err := database.SetBit(args[0], offset, args[2] == "1")
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	srv.ReplicateCommand(clientConn, append([]string{"SETBIT"}, args...))
+
+	protocol.WriteInteger(clientConn, old)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// GetBitHandler handles GETBIT key offset
+type GetBitHandler struct {
+	logger *logging.Logger
+}
+
+func (h *GetBitHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("GETBIT")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'GETBIT' command")
+		return nil
+	}
+
+	offset, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		protocol.WriteError(clientConn, database.ErrBitOffset.Error())
+		return nil
+	}
+
+	bit, err := database.GetBit(args[0], offset)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	protocol.WriteInteger(clientConn, bit)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// BitCountHandler handles BITCOUNT key [start end [BYTE|BIT]]
+type BitCountHandler struct {
+	logger *logging.Logger
+}
+
+func (h *BitCountHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("BITCOUNT")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 1 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'BITCOUNT' command")
+		return nil
+	}
+
+	var start, end int64
+	bit := false
+	whole := len(args) == 1
+	if !whole {
+		if len(args) != 3 && len(args) != 4 {
+			protocol.WriteError(clientConn, "ERR syntax error")
+			return nil
+		}
+		var errStart, errEnd error
+		start, errStart = strconv.ParseInt(args[1], 10, 64)
+		end, errEnd = strconv.ParseInt(args[2], 10, 64)
+		if errStart != nil || errEnd != nil {
+			protocol.WriteError(clientConn, "ERR value is not an integer or out of range")
+			return nil
+		}
+		if len(args) == 4 {
+			switch strings.ToUpper(args[3]) {
+			case "BYTE":
+			case "BIT":
+				bit = true
+			default:
+				protocol.WriteError(clientConn, "ERR syntax error")
+				return nil
+			}
+		}
+	}
+
+	count, err := database.BitCount(args[0], start, end, bit, whole)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	protocol.WriteInteger(clientConn, count)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
 // KeysHandler handles KEYS commands
 type KeysHandler struct {
 	logger *logging.Logger
@@ -170,23 +389,42 @@ func (h *KeysHandler) Handle(srv *server.Server, clientConn net.Conn, args []str
 }
 
 func (h *KeysHandler) getKeysMatchingPattern(pattern string) []string {
-	var results []string
-	database.DB.Range(func(key, value interface{}) bool {
-		strKey, ok := key.(string)
-		if !ok {
-			return true
+	results := []string{}
+	for _, key := range database.Keys() {
+		if glob.Match(pattern, key) {
+			results = append(results, key)
 		}
-
-		match, err := path.Match(pattern, strKey)
-		if err != nil || !match {
-			return true
-		}
-		results = append(results, strKey)
-		return true
-	})
+	}
 	return results
 }
 
+// parseSetExpiry parses the EX, PX, EXAT or PXAT option of SET into the
+// time the key expires, or returns the error to reply with
+func parseSetExpiry(opts []string) (time.Time, string) {
+	if len(opts) != 2 {
+		return time.Time{}, "ERR syntax error"
+	}
+	n, err := strconv.ParseInt(opts[1], 10, 64)
+	if err != nil {
+		return time.Time{}, "ERR value is not an integer or out of range"
+	}
+	if n <= 0 {
+		return time.Time{}, "ERR invalid expire time in 'set' command"
+	}
+
+	switch strings.ToUpper(opts[0]) {
+	case "EX":
+		return time.Now().Add(time.Duration(n) * time.Second), ""
+	case "PX":
+		return time.Now().Add(time.Duration(n) * time.Millisecond), ""
+	case "EXAT":
+		return time.Unix(n, 0), ""
+	case "PXAT":
+		return time.UnixMilli(n), ""
+	}
+	return time.Time{}, "ERR syntax error"
+}
+
 // TypeHandler handles TYPE commands
 type TypeHandler struct {
 	logger *logging.Logger
@@ -202,13 +440,96 @@ func (h *TypeHandler) Handle(srv *server.Server, clientConn net.Conn, args []str
 		return nil
 	}
 
-	key := args[0]
-	response, found := database.GetType(key)
-	if !found {
-		protocol.WriteSimpleString(clientConn, "none")
+	protocol.WriteSimpleString(clientConn, database.KeyType(args[0]))
+	return nil
+}
+
+// TouchHandler handles TOUCH commands
+type TouchHandler struct {
+	logger *logging.Logger
+}
+
+func (h *TouchHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("TOUCH")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 1 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'TOUCH' command")
+		return nil
+	}
+
+	touched := 0
+	for _, key := range args {
+		if database.Touch(key) {
+			touched++
+		}
+	}
+
+	h.logger.Debug("Touched %d of %d keys", touched, len(args))
+	protocol.WriteInteger(clientConn, touched)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// DelHandler handles DEL commands
+type DelHandler struct {
+	logger *logging.Logger
+}
+
+func (h *DelHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("DEL")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 1 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'DEL' command")
 		return nil
 	}
 
-	protocol.WriteSimpleString(clientConn, response)
+	deleted := database.DeleteKeys(args...)
+	if deleted > 0 {
+		srv.ReplicateCommand(clientConn, append([]string{"DEL"}, args...))
+	}
+
+	h.logger.Debug("Deleted %d of %d keys", deleted, len(args))
+	protocol.WriteInteger(clientConn, deleted)
+	h.logger.Success("Command completed successfully")
 	return nil
 }
+
+// ObjectHandler handles OBJECT commands
+type ObjectHandler struct {
+	logger      *logging.Logger
+	subcommands subcommands
+}
+
+func (h *ObjectHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("OBJECT")
+		h.subcommands = subcommands{command: "OBJECT", table: map[string]subcommand{
+			"IDLETIME": {arity: 2, usage: "<key>", run: objectIdleTime},
+		}}
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if h.subcommands.dispatch(srv, clientConn, args) {
+		h.logger.Success("Command completed successfully")
+	}
+	return nil
+}
+
+// objectIdleTime handles OBJECT IDLETIME key, nil for a missing key
+func objectIdleTime(srv *server.Server, clientConn net.Conn, args []string) {
+	idle, found := database.IdleTime(args[0])
+	if !found {
+		clientConn.Write([]byte("$-1\r\n"))
+		return
+	}
+	protocol.WriteInteger(clientConn, int(idle.Seconds()))
+}
@@ -0,0 +1,117 @@
+package commands
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
+	"github.com/r0ld3x/redis-clone-go/app/internal/server"
+	"github.com/r0ld3x/redis-clone-go/app/pkg/database"
+)
+
+// DebugHandler handles DEBUG commands. Most subcommands deliberately break
+// server invariants so tests can reach failure paths, so it is refused
+// unless the server was started with --enable-debug-command. SEGFAULT and
+// PANIC, which crash the process, and the fault injection subcommands
+// additionally need a -tags debug build.
+type DebugHandler struct {
+	logger      *logging.Logger
+	subcommands subcommands
+}
+
+func (h *DebugHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("DEBUG")
+		h.subcommands = subcommands{command: "DEBUG", table: map[string]subcommand{
+			"CHANGE-REPL-ID": {arity: 1, run: func(srv *server.Server, clientConn net.Conn, _ []string) {
+				// Replicas reconnecting with the old ID must fall back to FULLRESYNC.
+				srv.ChangeReplicationID()
+				protocol.WriteSimpleString(clientConn, "OK")
+			}},
+			"SET-REPL-OFFSET": {arity: 2, usage: "<offset>", run: debugSetReplOffset},
+			"DIGEST": {arity: 1, run: func(_ *server.Server, clientConn net.Conn, _ []string) {
+				protocol.WriteBulkString(clientConn, database.Digest())
+			}},
+			"DIGEST-VALUE": {arity: -1, usage: "[key ...]", run: debugDigestValue},
+			"LOCKSTATS":    {arity: -1, usage: "[count|RESET]", run: h.lockStats},
+		}}
+		maps.Copy(h.subcommands.table, faultSubcommands())
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if !srv.Config.EnableDebugCommand {
+		protocol.WriteError(clientConn, "ERR DEBUG command not allowed. Restart the server with --enable-debug-command to enable it.")
+		return nil
+	}
+
+	// SEGFAULT and PANIC exist only in debug builds, outside the table
+	if len(args) > 0 && crashSubcommand(h.logger, args[0]) {
+		return nil
+	}
+	if h.subcommands.dispatch(srv, clientConn, args) {
+		h.logger.Success("Command completed successfully")
+	}
+	return nil
+}
+
+// debugSetReplOffset handles DEBUG SET-REPL-OFFSET offset
+func debugSetReplOffset(srv *server.Server, clientConn net.Conn, args []string) {
+	offset, err := strconv.Atoi(args[0])
+	if err != nil || offset < 0 {
+		protocol.WriteError(clientConn, "ERR value is not an integer or out of range")
+		return
+	}
+	srv.SetReplicationOffset(offset)
+	protocol.WriteSimpleString(clientConn, "OK")
+}
+
+// debugDigestValue handles DEBUG DIGEST-VALUE key...
+func debugDigestValue(_ *server.Server, clientConn net.Conn, args []string) {
+	digests := make([]string, len(args))
+	for i, key := range args {
+		digests[i] = database.DigestValue(key)
+	}
+	protocol.WriteArray(clientConn, digests)
+}
+
+// lockStats handles DEBUG LOCKSTATS [count], the keys that waited longest
+// for their locks as [key, type, acquisitions, contended, wait-usec,
+// max-wait-usec] (20 by default), and DEBUG LOCKSTATS RESET
+func (h *DebugHandler) lockStats(_ *server.Server, clientConn net.Conn, args []string) {
+	if len(args) > 1 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'DEBUG|LOCKSTATS' command")
+		return
+	}
+	n := 20
+	if len(args) == 1 {
+		if strings.ToUpper(args[0]) == "RESET" {
+			database.ResetLockStats()
+			protocol.WriteSimpleString(clientConn, "OK")
+			return
+		}
+		var err error
+		if n, err = strconv.Atoi(args[0]); err != nil || n < 0 {
+			protocol.WriteError(clientConn, "ERR value is not an integer or out of range")
+			return
+		}
+	}
+
+	keys := database.CollectLockStats(n)
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(keys))
+	for _, k := range keys {
+		b.WriteString("*6\r\n")
+		b.WriteString(protocol.FormatBulkString(k.Key))
+		b.WriteString(protocol.FormatBulkString(k.Type))
+		b.WriteString(protocol.FormatInteger(int(k.Acquisitions)))
+		b.WriteString(protocol.FormatInteger(int(k.Contended)))
+		b.WriteString(protocol.FormatInteger(int(k.Wait.Microseconds())))
+		b.WriteString(protocol.FormatInteger(int(k.MaxWait.Microseconds())))
+	}
+	clientConn.Write([]byte(b.String()))
+}
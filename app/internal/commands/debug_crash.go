@@ -0,0 +1,37 @@
+//go:build debug && unix
+
+package commands
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+)
+
+// crashSubcommand kills the server for DEBUG SEGFAULT and DEBUG PANIC, so
+// integration tests can exercise supervision and recovery after a crash.
+// It is only compiled into binaries built with -tags debug and returns
+// false for any other subcommand.
+func crashSubcommand(logger *logging.Logger, sub string) bool {
+	switch strings.ToUpper(sub) {
+	case "SEGFAULT":
+		logger.Error("DEBUG SEGFAULT: crashing the server on request")
+		// A real SIGSEGV, so the process dies the way it would on a bad
+		// memory access instead of with a recoverable nil-pointer panic.
+		syscall.Kill(os.Getpid(), syscall.SIGSEGV)
+	case "PANIC":
+		logger.Error("DEBUG PANIC: crashing the server on request")
+		// Panic on a goroutine of its own so nothing up the handler's stack
+		// can recover it.
+		go panic("DEBUG PANIC")
+	default:
+		return false
+	}
+
+	// The signal or panic is delivered asynchronously; never reply.
+	time.Sleep(time.Minute)
+	return true
+}
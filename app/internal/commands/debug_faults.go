@@ -0,0 +1,45 @@
+//go:build debug
+
+package commands
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
+	"github.com/r0ld3x/redis-clone-go/app/internal/server"
+)
+
+// faultSubcommands are the DEBUG subcommands injecting failures, so tests
+// can make the replication robustness paths happen on cue. They are only
+// compiled into binaries built with -tags debug.
+func faultSubcommands() map[string]subcommand {
+	return map[string]subcommand{
+		"SLEEP-AFTER-FORK-SECONDS": {arity: 2, usage: "<seconds>", run: debugSleepAfterFork},
+		"DROP-REPLICA-AFTER":       {arity: 2, usage: "<bytes>", run: debugDropReplicaAfter},
+	}
+}
+
+// debugSleepAfterFork handles DEBUG SLEEP-AFTER-FORK-SECONDS seconds. There
+// is no fork here; full resyncs wait that long before sending the snapshot.
+func debugSleepAfterFork(srv *server.Server, clientConn net.Conn, args []string) {
+	seconds, err := strconv.ParseFloat(args[0], 64)
+	if err != nil || seconds < 0 {
+		protocol.WriteError(clientConn, "ERR value is not a valid float")
+		return
+	}
+	srv.Faults.SetSyncDelay(time.Duration(seconds * float64(time.Second)))
+	protocol.WriteSimpleString(clientConn, "OK")
+}
+
+// debugDropReplicaAfter handles DEBUG DROP-REPLICA-AFTER bytes
+func debugDropReplicaAfter(srv *server.Server, clientConn net.Conn, args []string) {
+	n, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || n < 0 {
+		protocol.WriteError(clientConn, "ERR value is not an integer or out of range")
+		return
+	}
+	srv.Faults.DropReplicaAfter(n)
+	protocol.WriteSimpleString(clientConn, "OK")
+}
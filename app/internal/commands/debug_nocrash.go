@@ -0,0 +1,11 @@
+//go:build !debug || !unix
+
+package commands
+
+import "github.com/r0ld3x/redis-clone-go/app/internal/logging"
+
+// crashSubcommand is a no-op outside -tags debug builds on Unix, so DEBUG
+// SEGFAULT and DEBUG PANIC are unknown subcommands in a regular server.
+func crashSubcommand(logger *logging.Logger, sub string) bool {
+	return false
+}
@@ -0,0 +1,9 @@
+//go:build !debug
+
+package commands
+
+// faultSubcommands is empty outside -tags debug builds, so the fault
+// injection subcommands of DEBUG are unknown in a regular server.
+func faultSubcommands() map[string]subcommand {
+	return nil
+}
@@ -0,0 +1,240 @@
+package commands
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
+	"github.com/r0ld3x/redis-clone-go/app/internal/server"
+)
+
+// Origin tells the dispatcher who issued a command
+type Origin int
+
+const (
+	OriginClient Origin = iota // Counted everywhere, including slowlog and audit log
+	OriginMaster               // Propagated by our master; not in the slowlog or audit log
+)
+
+// ExecContext is a context a command can run in other than directly on
+// behalf of a client
+type ExecContext uint8
+
+const (
+	// ContextTransaction commands are queued by MULTI and run by EXEC
+	ContextTransaction ExecContext = 1 << iota
+	// ContextSubscribed commands come from a connection in subscribe mode,
+	// which only runs the commands in subscribedCommands
+	ContextSubscribed
+)
+
+// deniedContexts holds the contexts each command may not run in. WAIT is
+// allowed in transactions with multi-allow-wait (see ContextError).
+var deniedContexts = map[Command]ExecContext{
+	SubscribeCommand:        ContextTransaction,
+	SubscribeHistoryCommand: ContextTransaction,
+	UnsubscribeCommand:      ContextTransaction,
+	PSubscribeCommand:       ContextTransaction,
+	PUnsubscribeCommand:     ContextTransaction,
+	SSubscribeCommand:       ContextTransaction,
+	SUnsubscribeCommand:     ContextTransaction,
+	MonitorCommand:          ContextTransaction,
+	PsyncCommand:            ContextTransaction,
+	WaitCommand:             ContextTransaction,
+	ShutdownCommand:         ContextTransaction,
+	SnapshotCommand:         ContextTransaction,
+}
+
+// subscribedCommands are the only commands a connection in subscribe mode
+// may run
+var subscribedCommands = map[Command]bool{
+	SubscribeCommand:        true,
+	SubscribeHistoryCommand: true,
+	UnsubscribeCommand:      true,
+	PSubscribeCommand:       true,
+	PUnsubscribeCommand:     true,
+	SSubscribeCommand:       true,
+	SUnsubscribeCommand:     true,
+	PingCommand:             true,
+	QuitCommand:             true,
+	ResetCommand:            true,
+}
+
+// ChangesSubscribeMode reports whether cmd can put a connection in or out
+// of subscribe mode, so the connection loop knows when to check again
+func ChangesSubscribeMode(cmd Command) bool {
+	return subscribedCommands[cmd] && cmd != PingCommand && cmd != QuitCommand
+}
+
+// ContextError returns the error for running cmd in ctx, or "" when it is
+// allowed there
+func ContextError(srv *server.Server, cmd Command, ctx ExecContext) string {
+	if ctx == ContextSubscribed {
+		if subscribedCommands[cmd] {
+			return ""
+		}
+		return fmt.Sprintf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context",
+			strings.ToLower(string(cmd)))
+	}
+
+	denied := deniedContexts[cmd]
+	if cmd == WaitCommand && srv.Config.MultiAllowWait {
+		denied &^= ContextTransaction
+	}
+	if denied&ctx == 0 {
+		return ""
+	}
+	return "ERR Command not allowed inside a transaction"
+}
+
+// QueueError checks a command a client sends inside MULTI before it is
+// queued. It returns the error to reply with instead of QUEUED, in which
+// case the transaction must be marked dirty, or "" to queue it.
+func (r *Registry) QueueError(srv *server.Server, name string, args []string) string {
+	cmd := Command(strings.ToUpper(name))
+	if _, exists := r.Get(cmd); !exists {
+		return r.UnknownCommandError(name, args, srv.Config.UnknownCommandSuggestions)
+	}
+	if err := arityError(cmd, args); err != "" {
+		return err
+	}
+	if err := ContextError(srv, cmd, ContextTransaction); err != "" {
+		return err
+	}
+	if err := readOnlyError(srv, cmd); err != "" {
+		return err
+	}
+	return ClusterRedirect(srv, cmd, args)
+}
+
+// SubscribedError checks a command a client in subscribe mode sends. It
+// returns the error to reply with instead of running it, or "" to run it.
+func (r *Registry) SubscribedError(srv *server.Server, name string, args []string) string {
+	cmd := Command(strings.ToUpper(name))
+	if _, exists := r.Get(cmd); !exists {
+		return r.UnknownCommandError(name, args, srv.Config.UnknownCommandSuggestions)
+	}
+	return ContextError(srv, cmd, ContextSubscribed)
+}
+
+// readOnlyError returns the error for a client running cmd, or "" when it
+// may. A replica only takes writes from its master.
+func readOnlyError(srv *server.Server, cmd Command) string {
+	if srv.IsMaster() || !IsWriteCommand(cmd) {
+		return ""
+	}
+	return "READONLY You can't write against a read only replica."
+}
+
+// Execute runs cmd through its handler and does the per-command
+// bookkeeping for origin. It returns false when no handler exists.
+func (r *Registry) Execute(srv *server.Server, conn net.Conn, origin Origin, cmd string, args []string) (bool, error) {
+	handler, exists := r.Get(Command(cmd))
+	if !exists {
+		return false, nil
+	}
+
+	// Keys served by another cluster node are redirected before running,
+	// and writes refused on a replica. Our master only propagates keys we
+	// hold and its own writes, so only clients are checked.
+	if origin == OriginClient {
+		if refused := readOnlyError(srv, Command(cmd)); refused != "" {
+			protocol.WriteError(conn, refused)
+			return true, nil
+		}
+		if redirect := ClusterRedirect(srv, Command(cmd), args); redirect != "" {
+			protocol.WriteError(conn, redirect)
+			return true, nil
+		}
+		// Between SNAPSHOT BEGIN and END reads come from the client's view
+		if view, open := srv.View(conn); open {
+			return true, executeInView(srv, conn, view, handler, cmd, args)
+		}
+	}
+
+	// A write and its replication must not straddle a full resync's
+	// snapshot. Blocking commands would stall every write while they wait.
+	if IsWriteCommand(Command(cmd)) && !mayBlock(Command(cmd), args) {
+		srv.BeginWrite()
+		defer srv.EndWrite()
+	}
+
+	expireKeys(srv, Command(cmd), args)
+	TouchWatchedKeys(srv, Command(cmd), args)
+	err := run(srv, conn, origin, handler, cmd, args)
+	TouchWatchedKeys(srv, Command(cmd), args)
+	return true, err
+}
+
+// run runs cmd through handler and accounts for it. EXEC runs its queued
+// commands with it, having checked them when they were queued and touched
+// the watched keys they write.
+func run(srv *server.Server, conn net.Conn, origin Origin, handler Handler, cmd string, args []string) error {
+	start := time.Now()
+	err := handler.Handle(srv, conn, args)
+	Account(srv, conn, origin, cmd, args, time.Since(start))
+	return err
+}
+
+// TouchWatchedKeys fails the transactions watching the keys cmd writes
+func TouchWatchedKeys(srv *server.Server, cmd Command, args []string) {
+	if !srv.TransactionMgr.Watching() || !IsWriteCommand(cmd) {
+		return
+	}
+	srv.TransactionMgr.TouchKeys(commandKeys(cmd, args))
+}
+
+// Account does the bookkeeping for a command of origin that ran for
+// elapsed. Execute calls it; it is only needed directly for commands that
+// are applied without going through their handler.
+func Account(srv *server.Server, conn net.Conn, origin Origin, cmd string, args []string, elapsed time.Duration) {
+	srv.Stats.Record(lowerName(cmd), elapsed)
+
+	if srv.Monitors.Active() && !IsAdminCommand(Command(cmd)) {
+		srv.Monitors.Broadcast(conn.RemoteAddr().String(), append([]string{lowerName(cmd)}, args...))
+	}
+
+	if origin == OriginClient {
+		// Time spent waiting is left out of the slowlog, like in Redis
+		if srv.SlowLog.IsSlow(elapsed) && !mayBlock(Command(cmd), args) {
+			name := ""
+			if c, ok := srv.Clients.Get(conn); ok {
+				name = c.Name()
+			}
+			srv.SlowLog.Add(elapsed, append([]string{cmd}, args...), conn.RemoteAddr().String(), name)
+		}
+		AuditCommand(srv, conn, cmd, args)
+	}
+}
+
+// lowerNames caches the lowercase names of the commands Account sees,
+// which are the registered ones, to spare every command an allocation
+var lowerNames sync.Map
+
+func lowerName(cmd string) string {
+	if lower, ok := lowerNames.Load(cmd); ok {
+		return lower.(string)
+	}
+	lower := strings.ToLower(cmd)
+	lowerNames.Store(cmd, lower)
+	return lower
+}
+
+// mayBlock reports whether a command may spend its run time waiting, for
+// data or for replicas
+func mayBlock(cmd Command, args []string) bool {
+	switch cmd {
+	case BLPopCommand, BRPopCommand, BLMoveCommand, BLMPopCommand, BZPopMinCommand, BZPopMaxCommand, WaitCommand:
+		return true
+	case XReadCommand, XReadGroupCommand:
+		for _, arg := range args {
+			if strings.ToUpper(arg) == "BLOCK" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package commands
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
+	"github.com/r0ld3x/redis-clone-go/app/internal/server"
+
+	"github.com/r0ld3x/redis-clone-go/app/pkg/database"
+)
+
+// Only a master deletes expired keys, replicating a DEL for each; replicas
+// hide them until it arrives.
+
+// activeExpireCycleTime is the longest an active expiry cycle runs before
+// the loop starts another, like Redis' slow cycle: a quarter of a cron tick
+const activeExpireCycleTime = server.CronInterval / 4
+
+// ActiveExpire deletes keys as their TTL elapses. It runs for ever, in its
+// own goroutine, and returns at once on a replica.
+func ActiveExpire(srv *server.Server) {
+	if !srv.IsMaster() {
+		return
+	}
+	database.ExpireLoop(activeExpireCycleTime, func(key string) bool {
+		srv.BeginWrite()
+		defer srv.EndWrite()
+		return expireKey(srv, key)
+	})
+}
+
+// expireKeys deletes the keys cmd names whose TTL elapsed, on a master
+func expireKeys(srv *server.Server, cmd Command, args []string) {
+	if !srv.IsMaster() {
+		return
+	}
+	for _, key := range commandKeys(cmd, args) {
+		expireKey(srv, key)
+	}
+}
+
+// expireKey deletes key if its TTL elapsed, replicating a DEL, and reports
+// whether it did
+func expireKey(srv *server.Server, key string) bool {
+	if !database.Expired(key) {
+		return false
+	}
+	deleted := false
+	srv.ReplicateAtomically(nil, func() []string {
+		if deleted = database.DeleteIfExpired(key); !deleted {
+			return nil
+		}
+		return []string{"DEL", key}
+	})
+	if deleted {
+		srv.TransactionMgr.TouchKeys([]string{key})
+		notifyKeyspaceEvent(srv, eventsExpired, "expired", key)
+	}
+	return deleted
+}
+
+// ExpireHandler handles EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT commands
+type ExpireHandler struct {
+	name     string        // Command name used in error messages
+	unit     time.Duration // time.Second or time.Millisecond
+	absolute bool          // Whether the time argument is a Unix timestamp
+	logger   *logging.Logger
+}
+
+func (h *ExpireHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger(h.name)
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for '"+h.name+"' command")
+		return nil
+	}
+
+	key := args[0]
+	amount, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		protocol.WriteError(clientConn, "ERR value is not an integer or out of range")
+		return nil
+	}
+
+	cond := ""
+	if len(args) > 2 {
+		cond = strings.ToUpper(args[2])
+		switch cond {
+		case "NX", "XX", "GT", "LT":
+		default:
+			protocol.WriteError(clientConn, "ERR Unsupported option "+args[2])
+			return nil
+		}
+		if len(args) > 3 {
+			protocol.WriteError(clientConn, "ERR syntax error")
+			return nil
+		}
+	}
+
+	d := time.Duration(amount) * h.unit
+	var at time.Time
+	if h.absolute {
+		at = time.Unix(0, int64(d))
+	} else {
+		at = time.Now().Add(d)
+	}
+	if d/h.unit != time.Duration(amount) || !at.Equal(time.Unix(0, at.UnixNano())) {
+		protocol.WriteError(clientConn, "ERR invalid expire time in '"+strings.ToLower(h.name)+"' command")
+		return nil
+	}
+
+	if !database.Expire(key, at, cond) {
+		protocol.WriteInteger(clientConn, 0)
+		h.logger.Success("Command completed successfully")
+		return nil
+	}
+
+	// Replicas get an absolute expiry; a past one deletes the key at once
+	if at.After(time.Now()) || !srv.IsMaster() {
+		srv.ReplicateCommand(clientConn, []string{"PEXPIREAT", key, strconv.FormatInt(at.UnixMilli(), 10)})
+	} else if database.DeleteIfExpired(key) {
+		srv.ReplicateCommand(clientConn, []string{"DEL", key})
+	}
+
+	protocol.WriteInteger(clientConn, 1)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// TTLHandler handles TTL and PTTL commands
+type TTLHandler struct {
+	name   string        // Command name used in error messages
+	unit   time.Duration // Resolution of the reply
+	logger *logging.Logger
+}
+
+func (h *TTLHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger(h.name)
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 1 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for '"+h.name+"' command")
+		return nil
+	}
+
+	ttl := database.KeyTTL(args[0])
+	if ttl >= 0 && h.unit == time.Second {
+		// Round to the nearest second like HTTL does.
+		ttl = (ttl + 500) / 1000
+	}
+
+	protocol.WriteInteger(clientConn, int(ttl))
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// PersistHandler handles PERSIST commands
+type PersistHandler struct {
+	logger *logging.Logger
+}
+
+func (h *PersistHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("PERSIST")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 1 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'PERSIST' command")
+		return nil
+	}
+
+	if !database.Persist(args[0]) {
+		protocol.WriteInteger(clientConn, 0)
+		h.logger.Success("Command completed successfully")
+		return nil
+	}
+
+	srv.ReplicateCommand(clientConn, []string{"PERSIST", args[0]})
+	protocol.WriteInteger(clientConn, 1)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
@@ -0,0 +1,106 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/config"
+	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
+	"github.com/r0ld3x/redis-clone-go/app/internal/server"
+	"github.com/r0ld3x/redis-clone-go/app/internal/testutil"
+	"github.com/r0ld3x/redis-clone-go/app/pkg/cluster"
+	"github.com/r0ld3x/redis-clone-go/app/pkg/database"
+)
+
+func TestMain(m *testing.M) {
+	logging.SetLevel(logging.LevelNone)
+	os.Exit(m.Run())
+}
+
+// testConfig returns the configuration of a master started without flags
+func testConfig() *config.Config {
+	return &config.Config{
+		HostName:             "localhost",
+		Port:                 "6379",
+		Role:                 "master",
+		AuditLogFile:         os.DevNull,
+		SlowlogLogSlowerThan: 10000,
+		SlowlogMaxLen:        128,
+		StorePropagation:     config.PropagateVerbatim,
+		LogLevel:             "error",
+		LogFormat:            "default",
+		ShutdownTimeout:      10,
+		ProtoMaxBulkLen:      protocol.DefaultMaxBulkLen,
+		ReplBacklogSize:      1024 * 1024,
+	}
+}
+
+// harness runs commands against a fresh keyspace through the registry, the
+// way the connection loop does, over an in-memory connection
+type harness struct {
+	t        testing.TB
+	srv      *server.Server
+	registry *Registry
+	conn     *testutil.Conn // Server end, passed to handlers
+	client   *testutil.Client
+}
+
+func newHarness(t testing.TB) *harness {
+	t.Helper()
+	database.Flush()
+	srv := server.NewServer(testConfig())
+	registry := NewRegistry()
+	registry.RegisterAllHandlers()
+	serverEnd, clientEnd := testutil.Pipe()
+	t.Cleanup(func() { serverEnd.Close() })
+	return &harness{t: t, srv: srv, registry: registry, conn: serverEnd, client: testutil.NewClient(clientEnd)}
+}
+
+// connect returns a harness for another connection to the same server
+func (h *harness) connect() *harness {
+	serverEnd, clientEnd := testutil.Pipe()
+	h.t.Cleanup(func() { serverEnd.Close() })
+	return &harness{t: h.t, srv: h.srv, registry: h.registry, conn: serverEnd, client: testutil.NewClient(clientEnd)}
+}
+
+// inCluster puts the server in cluster mode with the layout of a cluster
+// config file, see cluster.Topology
+func (h *harness) inCluster(layout string) {
+	h.t.Helper()
+	path := filepath.Join(h.t.TempDir(), "nodes.conf")
+	if err := os.WriteFile(path, []byte(layout), 0o644); err != nil {
+		h.t.Fatal(err)
+	}
+	topology, err := cluster.Load(path, "localhost:6379")
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	h.srv.Cluster = topology
+}
+
+// do runs args as a client command and returns its reply
+func (h *harness) do(args ...string) testutil.Reply {
+	h.t.Helper()
+	exists, err := h.registry.Execute(h.srv, h.conn, OriginClient, strings.ToUpper(args[0]), args[1:])
+	if !exists || err != nil {
+		h.t.Fatalf("%v: exists %t, err %v", args, exists, err)
+	}
+	reply, err := h.client.ReadReply(time.Second)
+	if err != nil {
+		h.t.Fatalf("%v: reading reply: %v", args, err)
+	}
+	return reply
+}
+
+// expect runs args and fails the test unless the reply renders as want, see
+// testutil.Reply.String
+func (h *harness) expect(want string, args ...string) {
+	h.t.Helper()
+	if got := h.do(args...).String(); got != want {
+		h.t.Fatalf("%v = %s, want %s", args, got, want)
+	}
+}
@@ -0,0 +1,690 @@
+package commands
+
+import (
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
+	"github.com/r0ld3x/redis-clone-go/app/internal/server"
+	"github.com/r0ld3x/redis-clone-go/app/pkg/database"
+)
+
+// HSetHandler handles HSET commands
+type HSetHandler struct {
+	logger *logging.Logger
+}
+
+func (h *HSetHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("HSET")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 3 || len(args)%2 != 1 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'HSET' command")
+		return nil
+	}
+
+	key := args[0]
+	expireHashFields(srv, clientConn, key)
+	added, err := database.HashSet(key, args[1:])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	srv.ReplicateCommand(clientConn, append([]string{"HSET"}, args...))
+
+	h.logger.Debug("Added %d new fields to %s", added, key)
+	protocol.WriteInteger(clientConn, added)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// HGetHandler handles HGET commands
+type HGetHandler struct {
+	logger *logging.Logger
+}
+
+func (h *HGetHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("HGET")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'HGET' command")
+		return nil
+	}
+
+	expireHashFields(srv, clientConn, args[0])
+	val, found, err := database.HashGet(args[0], args[1])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+	if !found {
+		clientConn.Write([]byte("$-1\r\n"))
+		return nil
+	}
+
+	protocol.WriteBulkString(clientConn, val)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// HMGetHandler handles HMGET commands
+type HMGetHandler struct {
+	logger *logging.Logger
+}
+
+func (h *HMGetHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("HMGET")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'HMGET' command")
+		return nil
+	}
+
+	expireHashFields(srv, clientConn, args[0])
+	values, found, err := database.HashMultiGet(args[0], args[1:])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	elements := make([]string, len(values))
+	for i, val := range values {
+		if !found[i] {
+			elements[i] = "$-1\r\n"
+			continue
+		}
+		elements[i] = protocol.FormatBulkString(val)
+	}
+
+	protocol.WriteArray2(clientConn, elements)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// HDelHandler handles HDEL commands
+type HDelHandler struct {
+	logger *logging.Logger
+}
+
+func (h *HDelHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("HDEL")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'HDEL' command")
+		return nil
+	}
+
+	expireHashFields(srv, clientConn, args[0])
+	removed, err := database.HashDelete(args[0], args[1:])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	if removed > 0 {
+		srv.ReplicateCommand(clientConn, append([]string{"HDEL"}, args...))
+	}
+
+	protocol.WriteInteger(clientConn, removed)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// HGetAllHandler handles HGETALL commands
+type HGetAllHandler struct {
+	logger *logging.Logger
+}
+
+func (h *HGetAllHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("HGETALL")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 1 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'HGETALL' command")
+		return nil
+	}
+
+	expireHashFields(srv, clientConn, args[0])
+	data, err := database.HashGetAll(args[0])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	protocol.WriteArray(clientConn, data)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// HExistsHandler handles HEXISTS commands
+type HExistsHandler struct {
+	logger *logging.Logger
+}
+
+func (h *HExistsHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("HEXISTS")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'HEXISTS' command")
+		return nil
+	}
+
+	expireHashFields(srv, clientConn, args[0])
+	_, found, err := database.HashGet(args[0], args[1])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	if found {
+		protocol.WriteInteger(clientConn, 1)
+	} else {
+		protocol.WriteInteger(clientConn, 0)
+	}
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// HLenHandler handles HLEN commands
+type HLenHandler struct {
+	logger *logging.Logger
+}
+
+func (h *HLenHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("HLEN")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 1 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'HLEN' command")
+		return nil
+	}
+
+	expireHashFields(srv, clientConn, args[0])
+	length, err := database.HashLen(args[0])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	protocol.WriteInteger(clientConn, length)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// HKeysHandler handles HKEYS commands
+type HKeysHandler struct {
+	logger *logging.Logger
+}
+
+func (h *HKeysHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("HKEYS")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 1 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'HKEYS' command")
+		return nil
+	}
+
+	expireHashFields(srv, clientConn, args[0])
+	fields, err := database.HashKeys(args[0])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	protocol.WriteArray(clientConn, fields)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// HValsHandler handles HVALS commands
+type HValsHandler struct {
+	logger *logging.Logger
+}
+
+func (h *HValsHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("HVALS")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 1 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'HVALS' command")
+		return nil
+	}
+
+	expireHashFields(srv, clientConn, args[0])
+	values, err := database.HashValues(args[0])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	protocol.WriteArray(clientConn, values)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// HIncrByHandler handles HINCRBY commands
+type HIncrByHandler struct {
+	logger *logging.Logger
+}
+
+func (h *HIncrByHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("HINCRBY")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 3 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'HINCRBY' command")
+		return nil
+	}
+
+	by, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		protocol.WriteError(clientConn, "ERR value is not an integer or out of range")
+		return nil
+	}
+
+	expireHashFields(srv, clientConn, args[0])
+	result, err := database.HashIncrBy(args[0], args[1], by)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	srv.ReplicateCommand(clientConn, append([]string{"HINCRBY"}, args...))
+
+	clientConn.Write([]byte(":" + strconv.FormatInt(result, 10) + "\r\n"))
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// HIncrByFloatHandler handles HINCRBYFLOAT commands
+type HIncrByFloatHandler struct {
+	logger *logging.Logger
+}
+
+func (h *HIncrByFloatHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("HINCRBYFLOAT")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 3 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'HINCRBYFLOAT' command")
+		return nil
+	}
+
+	by, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || math.IsNaN(by) || math.IsInf(by, 0) {
+		protocol.WriteError(clientConn, "ERR value is not a valid float")
+		return nil
+	}
+
+	expireHashFields(srv, clientConn, args[0])
+	result, err := database.HashIncrByFloat(args[0], args[1], by)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	// Replicate the resulting value rather than the increment so replicas
+	// cannot drift because of float formatting differences.
+	srv.ReplicateCommand(clientConn, []string{"HSET", args[0], args[1], result})
+
+	protocol.WriteBulkString(clientConn, result)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// HRandFieldHandler handles HRANDFIELD commands
+type HRandFieldHandler struct {
+	logger *logging.Logger
+}
+
+func (h *HRandFieldHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("HRANDFIELD")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 1 || len(args) > 3 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'HRANDFIELD' command")
+		return nil
+	}
+
+	expireHashFields(srv, clientConn, args[0])
+
+	// Without a count a single field is returned as a bulk string.
+	if len(args) == 1 {
+		fields, _, err := database.HashRandomFields(args[0], 1)
+		if err != nil {
+			protocol.WriteError(clientConn, err.Error())
+			return nil
+		}
+		if len(fields) == 0 {
+			clientConn.Write([]byte("$-1\r\n"))
+			return nil
+		}
+		protocol.WriteBulkString(clientConn, fields[0])
+		return nil
+	}
+
+	count, err := strconv.Atoi(args[1])
+	if err != nil {
+		protocol.WriteError(clientConn, "ERR value is not an integer or out of range")
+		return nil
+	}
+	withValues := false
+	if len(args) == 3 {
+		if strings.ToUpper(args[2]) != "WITHVALUES" {
+			protocol.WriteError(clientConn, "ERR syntax error")
+			return nil
+		}
+		withValues = true
+	}
+
+	fields, values, err := database.HashRandomFields(args[0], count)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	if !withValues {
+		protocol.WriteArray(clientConn, fields)
+		return nil
+	}
+	reply := make([]string, 0, len(fields)*2)
+	for i, field := range fields {
+		reply = append(reply, field, values[i])
+	}
+	protocol.WriteArray(clientConn, reply)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// HScanHandler handles HSCAN commands
+type HScanHandler struct {
+	logger *logging.Logger
+}
+
+func (h *HScanHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("HSCAN")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'HSCAN' command")
+		return nil
+	}
+
+	opts, err := parseScanOptions(args[1:], false)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	expireHashFields(srv, clientConn, args[0])
+	pairs, next, err := database.HashScan(args[0], opts.cursor, opts.count)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	reply := pairs[:0]
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if opts.matches(pairs[i]) {
+			reply = append(reply, pairs[i], pairs[i+1])
+		}
+	}
+
+	writeScanReply(clientConn, next, reply)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// expireHashFields deletes hash fields whose TTL elapsed and propagates them
+// to replicas as HDEL
+func expireHashFields(srv *server.Server, clientConn net.Conn, key string) {
+	if !srv.IsMaster() {
+		return
+	}
+	if expired := database.HashDeleteExpired(key); len(expired) > 0 {
+		srv.ReplicateCommand(clientConn, append([]string{"HDEL", key}, expired...))
+	}
+}
+
+// parseFieldsArg parses the trailing "FIELDS numfields field [field ...]"
+// block shared by the hash field TTL commands
+func parseFieldsArg(args []string) ([]string, error) {
+	if len(args) < 2 || strings.ToUpper(args[0]) != "FIELDS" {
+		return nil, errors.New("ERR Mandatory argument FIELDS is missing or not at the right position")
+	}
+	numFields, err := strconv.Atoi(args[1])
+	if err != nil || numFields <= 0 {
+		return nil, errors.New("ERR Parameter `numFields` should be greater than 0")
+	}
+	if numFields != len(args)-2 {
+		return nil, errors.New("ERR The `numfields` parameter must match the number of arguments")
+	}
+	return args[2:], nil
+}
+
+// writeIntegerArray writes an array of RESP integers
+func writeIntegerArray(clientConn net.Conn, values []int) {
+	elements := make([]string, len(values))
+	for i, val := range values {
+		elements[i] = protocol.FormatInteger(val)
+	}
+	protocol.WriteArray2(clientConn, elements)
+}
+
+// HExpireHandler handles HEXPIRE, HPEXPIRE, HEXPIREAT and HPEXPIREAT commands
+type HExpireHandler struct {
+	name     string        // Command name used in error messages
+	unit     time.Duration // time.Second or time.Millisecond
+	absolute bool          // Whether the time argument is a Unix timestamp
+	logger   *logging.Logger
+}
+
+func (h *HExpireHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger(h.name)
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 5 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for '"+h.name+"' command")
+		return nil
+	}
+
+	key := args[0]
+	amount, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || amount < 0 {
+		protocol.WriteError(clientConn, "ERR value is not an integer or out of range")
+		return nil
+	}
+
+	rest := args[2:]
+	cond := ""
+	switch strings.ToUpper(rest[0]) {
+	case "NX", "XX", "GT", "LT":
+		cond = strings.ToUpper(rest[0])
+		rest = rest[1:]
+	}
+
+	fields, err := parseFieldsArg(rest)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	var at time.Time
+	if h.absolute {
+		at = time.UnixMilli(amount * int64(h.unit/time.Millisecond))
+	} else {
+		at = time.Now().Add(time.Duration(amount) * h.unit)
+	}
+
+	expireHashFields(srv, clientConn, key)
+	results, err := database.HashExpireFields(key, at, cond, fields)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	// Propagate as absolute expirations and explicit deletions so replicas
+	// end up with exactly the same fields regardless of their clocks.
+	var expiring, deleted []string
+	for i, result := range results {
+		switch result {
+		case database.FieldExpireSet:
+			expiring = append(expiring, fields[i])
+		case database.FieldExpireDelete:
+			deleted = append(deleted, fields[i])
+		}
+	}
+	if len(expiring) > 0 {
+		command := []string{"HPEXPIREAT", key, strconv.FormatInt(at.UnixMilli(), 10), "FIELDS", strconv.Itoa(len(expiring))}
+		srv.ReplicateCommand(clientConn, append(command, expiring...))
+	}
+	if len(deleted) > 0 {
+		srv.ReplicateCommand(clientConn, append([]string{"HDEL", key}, deleted...))
+	}
+
+	writeIntegerArray(clientConn, results)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// HTTLHandler handles HTTL and HPTTL commands
+type HTTLHandler struct {
+	name   string        // Command name used in error messages
+	unit   time.Duration // Resolution of the reply
+	logger *logging.Logger
+}
+
+func (h *HTTLHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger(h.name)
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 4 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for '"+h.name+"' command")
+		return nil
+	}
+
+	fields, err := parseFieldsArg(args[1:])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	expireHashFields(srv, clientConn, args[0])
+	ttls, err := database.HashFieldTTLs(args[0], fields)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	results := make([]int, len(ttls))
+	for i, ttl := range ttls {
+		if ttl < 0 || h.unit == time.Millisecond {
+			results[i] = int(ttl)
+			continue
+		}
+		// Round to the nearest second like TTL does.
+		results[i] = int((ttl + 500) / 1000)
+	}
+
+	writeIntegerArray(clientConn, results)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// HPersistHandler handles HPERSIST commands
+type HPersistHandler struct {
+	logger *logging.Logger
+}
+
+func (h *HPersistHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("HPERSIST")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 4 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'HPERSIST' command")
+		return nil
+	}
+
+	fields, err := parseFieldsArg(args[1:])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	expireHashFields(srv, clientConn, args[0])
+	results, err := database.HashPersistFields(args[0], fields)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	for _, result := range results {
+		if result == database.FieldExpireSet {
+			srv.ReplicateCommand(clientConn, append([]string{"HPERSIST"}, args...))
+			break
+		}
+	}
+
+	writeIntegerArray(clientConn, results)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHashFieldTTL(t *testing.T) {
+	h := newHarness(t)
+	h.do("HSET", "h", "a", "1", "b", "2")
+	h.expect("[(integer) 1, (integer) -2]", "HEXPIRE", "h", "100", "FIELDS", "2", "a", "missing")
+	h.expect("[(integer) 100, (integer) -1, (integer) -2]", "HTTL", "h", "FIELDS", "3", "a", "b", "missing")
+	h.expect("[(integer) -2]", "HTTL", "missing", "FIELDS", "1", "a")
+	if ttl := h.do("HPTTL", "h", "FIELDS", "1", "a").Array[0].Int; ttl <= 99000 || ttl > 100000 {
+		t.Fatalf("HPTTL of a field expiring in 100s = %d", ttl)
+	}
+	at := time.Now().Add(time.Hour).Unix()
+	h.expect("[(integer) 1]", "HEXPIREAT", "h", strconv.FormatInt(at, 10), "FIELDS", "1", "b")
+	if ttl := h.do("HTTL", "h", "FIELDS", "1", "b").Array[0].Int; ttl <= 3590 || ttl > 3600 {
+		t.Fatalf("HTTL of a field expiring in an hour = %d", ttl)
+	}
+
+	h.expect("[(integer) 1, (integer) -2]", "HPERSIST", "h", "FIELDS", "2", "a", "missing")
+	h.expect("[(integer) -1]", "HPERSIST", "h", "FIELDS", "1", "a")
+	h.expect("[(integer) -1]", "HTTL", "h", "FIELDS", "1", "a")
+	h.expect(`"1"`, "HGET", "h", "a")
+}
+
+func TestHashFieldTTLConditions(t *testing.T) {
+	h := newHarness(t)
+	h.do("HSET", "h", "a", "1", "b", "2")
+	h.do("HEXPIRE", "h", "100", "FIELDS", "1", "a")
+	// a expires in 100s, b never
+	h.expect("[(integer) 0, (integer) 1]", "HEXPIRE", "h", "200", "NX", "FIELDS", "2", "a", "b")
+	h.expect("[(integer) 0, (integer) 0]", "HEXPIRE", "h", "50", "GT", "FIELDS", "2", "a", "b")
+	h.expect("[(integer) 1, (integer) 1]", "HEXPIRE", "h", "50", "LT", "FIELDS", "2", "a", "b")
+	h.do("HPERSIST", "h", "FIELDS", "1", "b")
+	h.expect("[(integer) 1, (integer) 0]", "HEXPIRE", "h", "300", "XX", "FIELDS", "2", "a", "b")
+	h.expect("[(integer) 300, (integer) -1]", "HTTL", "h", "FIELDS", "2", "a", "b")
+
+	for _, args := range [][]string{
+		{"HEXPIRE", "h", "10", "FIELDS", "3", "a"},
+		{"HEXPIRE", "h", "10", "FIELDS", "0", "a"},
+		{"HEXPIRE", "h", "10", "FIELD", "1", "a"},
+		{"HEXPIRE", "h", "-1", "FIELDS", "1", "a"},
+		{"HTTL", "h", "FIELDS", "2", "a"},
+	} {
+		if reply := h.do(args...); !reply.IsError() {
+			t.Errorf("%v = %s, want an error", args, reply)
+		}
+	}
+}
+
+// An elapsed TTL removes the field, and the key with its last field
+func TestHashFieldExpires(t *testing.T) {
+	h := newHarness(t)
+	h.do("HSET", "h", "a", "1", "b", "2", "c", "3")
+	h.expect("[(integer) 2]", "HEXPIRE", "h", "0", "FIELDS", "1", "c")
+	h.expect("(nil)", "HGET", "h", "c")
+	h.expect("[(integer) 1]", "HPEXPIRE", "h", "10", "FIELDS", "1", "a")
+	time.Sleep(20 * time.Millisecond)
+
+	h.expect("(nil)", "HGET", "h", "a")
+	h.expect("(integer) 1", "HLEN", "h")
+	h.expect(`["b", "2"]`, "HGETALL", "h")
+	h.expect("(integer) 0", "HEXISTS", "h", "a")
+	h.expect("[(integer) -2]", "HTTL", "h", "FIELDS", "1", "a")
+
+	h.expect("[(integer) 2]", "HEXPIRE", "h", "0", "FIELDS", "1", "b")
+	h.expect(`"none"`, "TYPE", "h")
+}
+
+// Field TTLs reach replicas as absolute deadlines, and fields that expire
+// or are deleted by a TTL in the past as HDEL
+func TestHashFieldTTLReplication(t *testing.T) {
+	h := newHarness(t)
+	replica := h.attachReplica()
+	h.do("HSET", "h", "a", "1", "b", "2", "c", "3")
+	propagated(t, replica)
+
+	before := time.Now().Add(100 * time.Second).UnixMilli()
+	h.do("HEXPIRE", "h", "100", "FIELDS", "2", "a", "missing")
+	got := propagated(t, replica)
+	if len(got) != 6 || got[0] != "HPEXPIREAT" || got[3] != "FIELDS" || got[4] != "1" || got[5] != "a" {
+		t.Fatalf("HEXPIRE propagated %v, want HPEXPIREAT h <ms> FIELDS 1 a", got)
+	}
+	if at, _ := strconv.ParseInt(got[2], 10, 64); at < before || at > before+1000 {
+		t.Errorf("HEXPIRE 100 propagated the deadline %s, want about %d", got[2], before)
+	}
+
+	h.do("HEXPIRE", "h", "0", "FIELDS", "1", "b")
+	if got, want := propagated(t, replica), []string{"HDEL", "h", "b"}; !slices.Equal(got, want) {
+		t.Errorf("HEXPIRE 0 propagated %v, want %v", got, want)
+	}
+
+	h.do("HPEXPIRE", "h", "10", "FIELDS", "1", "c")
+	propagated(t, replica)
+	time.Sleep(20 * time.Millisecond)
+	h.do("HGET", "h", "c")
+	if got, want := propagated(t, replica), []string{"HDEL", "h", "c"}; !slices.Equal(got, want) {
+		t.Errorf("reading an expired field propagated %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,319 @@
+package commands
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
+	"github.com/r0ld3x/redis-clone-go/app/internal/server"
+	"github.com/r0ld3x/redis-clone-go/app/pkg/database"
+)
+
+// infoCacheTTL is how stale a cached INFO section may get before the server
+// cron renders it again
+const infoCacheTTL = time.Second
+
+// infoSection describes one section of the INFO reply
+type infoSection struct {
+	name      string
+	render    func(srv *server.Server) string // Section body without the header
+	inDefault bool                            // Included by INFO / INFO default
+	cached    bool                            // Rendered by the server cron rather than per request
+}
+
+// infoSections lists every section in reply order. Cheap sections are cached
+// by the server cron; commandstats and latencystats are only computed when
+// asked for, and are left out of the default set. config lists every CONFIG
+// parameter and is rendered per request so CONFIG SET shows up at once.
+var infoSections = []infoSection{
+	{name: "server", render: serverInfo, inDefault: true, cached: true},
+	{name: "clients", render: clientsInfo, inDefault: true, cached: true},
+	{name: "memory", render: memoryInfo, inDefault: true},
+	{name: "persistence", render: persistenceInfo, inDefault: true},
+	{name: "replication", render: replicationInfo, inDefault: true},
+	{name: "keyspace", render: keyspaceInfo, inDefault: true, cached: true},
+	{name: "expiry", render: expiryInfo, cached: true},
+	{name: "config", render: configInfo},
+	{name: "lockstats", render: lockStatsInfo},
+	{name: "commandstats", render: commandStatsInfo},
+	{name: "latencystats", render: latencyStatsInfo},
+}
+
+// infoCache holds the last rendering of every cached section
+var infoCache = struct {
+	sections  map[string]string
+	refreshed time.Time
+	mutex     sync.RWMutex
+}{sections: make(map[string]string)}
+
+// RefreshInfoCache renders the cached INFO sections once they are older than
+// infoCacheTTL. It is meant to be run as a server cron job.
+func RefreshInfoCache(srv *server.Server) {
+	infoCache.mutex.RLock()
+	fresh := time.Since(infoCache.refreshed) < infoCacheTTL
+	infoCache.mutex.RUnlock()
+	if fresh {
+		return
+	}
+
+	sections := make(map[string]string)
+	for _, section := range infoSections {
+		if section.cached {
+			sections[section.name] = section.render(srv)
+		}
+	}
+
+	infoCache.mutex.Lock()
+	infoCache.sections = sections
+	infoCache.refreshed = time.Now()
+	infoCache.mutex.Unlock()
+}
+
+// body returns the section's text, from the cache when possible
+func (s infoSection) body(srv *server.Server) string {
+	if s.cached {
+		infoCache.mutex.RLock()
+		body, ok := infoCache.sections[s.name]
+		infoCache.mutex.RUnlock()
+		if ok {
+			return body
+		}
+	}
+	return s.render(srv)
+}
+
+// selectInfoSections resolves INFO arguments to the sections to include.
+// "all" and "everything" select every section (there are no module sections
+// to tell them apart), "default" the default set, anything else a section by
+// name. Unknown names are ignored.
+func selectInfoSections(args []string) map[string]bool {
+	if len(args) == 0 {
+		args = []string{"default"}
+	}
+
+	selected := make(map[string]bool)
+	for _, arg := range args {
+		switch name := strings.ToLower(arg); name {
+		case "all", "everything":
+			for _, section := range infoSections {
+				selected[section.name] = true
+			}
+		case "default":
+			for _, section := range infoSections {
+				if section.inDefault {
+					selected[section.name] = true
+				}
+			}
+		default:
+			selected[name] = true
+		}
+	}
+	return selected
+}
+
+// InfoHandler handles INFO commands
+type InfoHandler struct {
+	logger *logging.Logger
+}
+
+func (h *InfoHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("INFO")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	selected := selectInfoSections(args)
+
+	var parts []string
+	for _, section := range infoSections {
+		if selected[section.name] {
+			header := "# " + strings.ToUpper(section.name[:1]) + section.name[1:] + "\r\n"
+			parts = append(parts, header+section.body(srv))
+		}
+	}
+	info := strings.Join(parts, "\r\n")
+
+	h.logger.Debug("Generated info response: %s", strings.ReplaceAll(info, "\r\n", "\\r\\n"))
+	h.logger.Network("OUT", "Sending bulk string response")
+	protocol.WriteBulkString(clientConn, info)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+func serverInfo(srv *server.Server) string {
+	uptime := time.Since(srv.StartedAt)
+
+	info := "redis_mode:standalone\r\n"
+	info += fmt.Sprintf("os:%s %s\r\n", runtime.GOOS, runtime.GOARCH)
+	info += fmt.Sprintf("go_version:%s\r\n", runtime.Version())
+	info += fmt.Sprintf("process_id:%d\r\n", os.Getpid())
+	info += fmt.Sprintf("tcp_port:%s\r\n", srv.Config.Port)
+	info += fmt.Sprintf("io_mode:%s\r\n", ioMode(srv))
+	info += fmt.Sprintf("goroutines:%d\r\n", runtime.NumGoroutine())
+	info += fmt.Sprintf("uptime_in_seconds:%d\r\n", int64(uptime.Seconds()))
+	info += fmt.Sprintf("uptime_in_days:%d\r\n", int64(uptime.Hours()/24))
+	info += fmt.Sprintf("hz:%d\r\n", time.Second/server.CronInterval)
+	return info
+}
+
+// ioMode names how client connections are served, see --event-loop
+func ioMode(srv *server.Server) string {
+	if srv.Config.EventLoop {
+		return "event-loop"
+	}
+	return "goroutine"
+}
+
+func clientsInfo(srv *server.Server) string {
+	return fmt.Sprintf("connected_clients:%d\r\n", len(srv.Clients.List()))
+}
+
+// memoryInfo reports the Go heap and what compaction reclaimed. It is
+// rendered per request so MEMORY PURGE shows up at once.
+func memoryInfo(srv *server.Server) string {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats := database.CollectDefragStats()
+	running := 0
+	if stats.Running {
+		running = 1
+	}
+
+	info := fmt.Sprintf("used_memory:%d\r\n", mem.HeapAlloc)
+	info += fmt.Sprintf("used_memory_heap_sys:%d\r\n", mem.HeapSys)
+	info += fmt.Sprintf("used_memory_heap_released:%d\r\n", mem.HeapReleased)
+	info += fmt.Sprintf("active_defrag_running:%d\r\n", running)
+	info += fmt.Sprintf("active_defrag_passes:%d\r\n", stats.Passes)
+	info += fmt.Sprintf("active_defrag_hits:%d\r\n", stats.Hits)
+	info += fmt.Sprintf("active_defrag_misses:%d\r\n", stats.Misses)
+	info += fmt.Sprintf("active_defrag_reclaimed_bytes:%d\r\n", stats.Reclaimed)
+	return info
+}
+
+// persistenceInfo reports running snapshots and the copy-on-write overhead
+// of the writes made while they read the dataset
+func persistenceInfo(srv *server.Server) string {
+	stats := database.CollectSnapshotStats()
+	info := fmt.Sprintf("snapshots_in_progress:%d\r\n", stats.Running)
+	info += fmt.Sprintf("snapshots_completed:%d\r\n", stats.Completed)
+	info += fmt.Sprintf("current_cow_copies:%d\r\n", stats.CurrentCopies)
+	info += fmt.Sprintf("current_cow_size:%d\r\n", stats.CurrentBytes)
+	info += fmt.Sprintf("last_cow_copies:%d\r\n", stats.LastCopies)
+	info += fmt.Sprintf("last_cow_size:%d\r\n", stats.LastBytes)
+	return info
+}
+
+// replicationInfo is rendered on every request since replication offsets
+// move with each propagated command
+func replicationInfo(srv *server.Server) string {
+	info := fmt.Sprintf("role:%s\r\n", srv.Config.Role)
+
+	if srv.Config.Role == "slave" {
+		info += fmt.Sprintf("master_host:%s\r\n", srv.Config.HostName)
+		info += fmt.Sprintf("master_port:%s\r\n", srv.Config.Port)
+
+		flushes, lazy, took := srv.FullSyncFlush.Snapshot()
+		info += fmt.Sprintf("replica_full_sync_flushes:%d\r\n", flushes)
+		mode := "sync"
+		if lazy {
+			mode = "lazy"
+		}
+		info += fmt.Sprintf("replica_last_flush_mode:%s\r\n", mode)
+		info += fmt.Sprintf("replica_last_flush_usec:%d\r\n", took.Microseconds())
+	}
+	info += fmt.Sprintf("master_replid:%s\r\n", srv.ReplicationID)
+	info += fmt.Sprintf("master_repl_offset:%d\r\n", srv.ReplicationOffset)
+	if srv.Config.Role == "master" {
+		replicas := srv.ReplicaInfos()
+		info += fmt.Sprintf("connected_slaves:%d\r\n", len(replicas))
+		for i, replica := range replicas {
+			ip, _, _ := net.SplitHostPort(replica.Conn.RemoteAddr().String())
+			port := replica.Port
+			if port == "" {
+				port = "0"
+			}
+			info += fmt.Sprintf("slave%d:ip=%s,port=%s,state=%s,offset=%d\r\n", i, ip, port, replica.State, replica.Offset)
+		}
+		commands, writes := srv.ReplFeed.Snapshot()
+		info += fmt.Sprintf("repl_batch_usec:%d\r\n", srv.ReplBatchDelay().Microseconds())
+		info += fmt.Sprintf("repl_feed_commands:%d\r\n", commands)
+		info += fmt.Sprintf("repl_feed_writes:%d\r\n", writes)
+	}
+	return info
+}
+
+func keyspaceInfo(srv *server.Server) string {
+	stats := database.CollectExpiryStats()
+	if stats.Keys == 0 {
+		return ""
+	}
+	return fmt.Sprintf("db0:keys=%d,expires=%d,avg_ttl=%d\r\n",
+		stats.Keys, stats.Volatile, stats.AvgTTL.Milliseconds())
+}
+
+// expiryInfo renders the TTL histogram of volatile keys together with a
+// forecast of how many keys are due to expire soon
+func expiryInfo(srv *server.Server) string {
+	stats := database.CollectExpiryStats()
+
+	info := fmt.Sprintf("keys:%d\r\n", stats.Keys)
+	info += fmt.Sprintf("volatile_keys:%d\r\n", stats.Volatile)
+	info += fmt.Sprintf("avg_ttl:%d\r\n", stats.AvgTTL.Milliseconds())
+	for _, bucket := range stats.Buckets {
+		info += fmt.Sprintf("ttl_bucket_%s:%d\r\n", bucket.Label, bucket.Count)
+	}
+	info += fmt.Sprintf("expiring_within_1s:%d\r\n", stats.ExpiringWithin(time.Second))
+	info += fmt.Sprintf("expiring_within_1m:%d\r\n", stats.ExpiringWithin(time.Minute))
+	info += fmt.Sprintf("expiring_within_1h:%d\r\n", stats.ExpiringWithin(time.Hour))
+
+	active := database.CollectActiveExpireStats()
+	info += fmt.Sprintf("expired_keys:%d\r\n", active.ExpiredKeys)
+	info += fmt.Sprintf("expire_cycles:%d\r\n", active.Cycles)
+	info += fmt.Sprintf("expire_cycles_time_capped:%d\r\n", active.CyclesTimedOut)
+	return info
+}
+
+func commandStatsInfo(srv *server.Server) string {
+	info := ""
+	for _, stat := range srv.Stats.Stats() {
+		info += fmt.Sprintf("cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f\r\n",
+			stat.Name, stat.Calls, stat.Total.Microseconds(),
+			float64(stat.PerCall().Nanoseconds())/1000)
+	}
+	return info
+}
+
+// lockStatsInfo reports the lock waits of every key together, see
+// lock-profiling and DEBUG LOCKSTATS
+func lockStatsInfo(srv *server.Server) string {
+	totals := database.LockTotals()
+	info := fmt.Sprintf("lock_profiling:%s\r\n", formatYesNo(database.LockProfiling()))
+	info += fmt.Sprintf("lock_acquisitions:%d\r\n", totals.Acquisitions)
+	info += fmt.Sprintf("lock_contended:%d\r\n", totals.Contended)
+	info += fmt.Sprintf("lock_wait_usec:%d\r\n", totals.Wait.Microseconds())
+	info += fmt.Sprintf("lock_max_wait_usec:%d\r\n", totals.MaxWait.Microseconds())
+	return info
+}
+
+// latencyStatsInfo sorts every command's latency samples, which is why it is
+// never cached or part of the default sections
+func latencyStatsInfo(srv *server.Server) string {
+	info := ""
+	for _, latency := range srv.Stats.Latencies() {
+		info += fmt.Sprintf("latency_percentiles_usec_%s:p50=%.3f,p99=%.3f,p99.9=%.3f\r\n",
+			latency.Name, usec(latency.P50), usec(latency.P99), usec(latency.P999))
+	}
+	return info
+}
+
+// usec converts d to fractional microseconds
+func usec(d time.Duration) float64 {
+	return float64(d.Nanoseconds()) / 1000
+}
@@ -2,43 +2,233 @@ package commands
 
 import (
 	"net"
+	"time"
 
+	"github.com/r0ld3x/redis-clone-go/app/internal/audit"
 	"github.com/r0ld3x/redis-clone-go/app/internal/server"
+	"github.com/r0ld3x/redis-clone-go/app/pkg/database"
 )
 
 // Command represents a Redis command type
 type Command string
 
 const (
-	CommandCommand  Command = "COMMAND"
-	EchoCommand     Command = "ECHO"
-	PingCommand     Command = "PING"
-	GetCommand      Command = "GET"
-	SetCommand      Command = "SET"
-	ConfigCommand   Command = "CONFIG"
-	KeysCommand     Command = "KEYS"
-	InfoCommand     Command = "INFO"
-	ReplconfCommand Command = "REPLCONF"
-	PsyncCommand    Command = "PSYNC"
-	WaitCommand     Command = "WAIT"
-	IncrCommand     Command = "INCR"
-	MultiCommand    Command = "MULTI"
-	ExecCommand     Command = "EXEC"
-	DiscardCommand  Command = "DISCARD"
-	TypeCommand     Command = "TYPE"
-	XAddCommand     Command = "XADD"
-	XRangeCommand   Command = "XRANGE"
-	XReadCommand    Command = "XREAD"
-	RPushCommand    Command = "RPUSH"
-	LRangeCommand   Command = "LRANGE"
-	LPushCommand    Command = "LPUSH"
-	LLenCommand     Command = "LLEN"
-	LPopCommand     Command = "LPOP"
-	BLPopCommand    Command = "BLPOP"
+	CommandCommand   Command = "COMMAND"
+	EchoCommand      Command = "ECHO"
+	PingCommand      Command = "PING"
+	QuitCommand      Command = "QUIT"
+	ResetCommand     Command = "RESET"
+	GetCommand       Command = "GET"
+	SetCommand       Command = "SET"
+	ConfigCommand    Command = "CONFIG"
+	KeysCommand      Command = "KEYS"
+	ScanCommand      Command = "SCAN"
+	InfoCommand      Command = "INFO"
+	ReplconfCommand  Command = "REPLCONF"
+	PsyncCommand     Command = "PSYNC"
+	WaitCommand      Command = "WAIT"
+	ShutdownCommand  Command = "SHUTDOWN"
+	IncrCommand      Command = "INCR"
+	AppendCommand    Command = "APPEND"
+	SetRangeCommand  Command = "SETRANGE"
+	SetBitCommand    Command = "SETBIT"
+	GetBitCommand    Command = "GETBIT"
+	BitCountCommand  Command = "BITCOUNT"
+	MultiCommand     Command = "MULTI"
+	ExecCommand      Command = "EXEC"
+	DiscardCommand   Command = "DISCARD"
+	WatchCommand     Command = "WATCH"
+	UnwatchCommand   Command = "UNWATCH"
+	TypeCommand      Command = "TYPE"
+	XAddCommand      Command = "XADD"
+	XRangeCommand    Command = "XRANGE"
+	XRevRangeCommand Command = "XREVRANGE"
+	XReadCommand     Command = "XREAD"
+	XDelCommand      Command = "XDEL"
+	RPushCommand     Command = "RPUSH"
+	LRangeCommand    Command = "LRANGE"
+	LPushCommand     Command = "LPUSH"
+	LLenCommand      Command = "LLEN"
+	LPopCommand      Command = "LPOP"
+	RPopCommand      Command = "RPOP"
+	LIndexCommand    Command = "LINDEX"
+	LSetCommand      Command = "LSET"
+	LInsertCommand   Command = "LINSERT"
+	LRemCommand      Command = "LREM"
+	LTrimCommand     Command = "LTRIM"
+	LMoveCommand     Command = "LMOVE"
+	BLMoveCommand    Command = "BLMOVE"
+	RPopLPushCommand Command = "RPOPLPUSH"
+	LMPopCommand     Command = "LMPOP"
+	BLMPopCommand    Command = "BLMPOP"
+	BLPopCommand     Command = "BLPOP"
+	BRPopCommand     Command = "BRPOP"
+	ClusterCommand   Command = "CLUSTER"
+	TouchCommand     Command = "TOUCH"
+	DelCommand       Command = "DEL"
+	ExpireCommand    Command = "EXPIRE"
+	PExpireCommand   Command = "PEXPIRE"
+	ExpireAtCommand  Command = "EXPIREAT"
+	PExpireAtCommand Command = "PEXPIREAT"
+	TTLCommand       Command = "TTL"
+	PTTLCommand      Command = "PTTL"
+	PersistCommand   Command = "PERSIST"
+	ObjectCommand    Command = "OBJECT"
+	DebugCommand     Command = "DEBUG"
+	ClientCommand    Command = "CLIENT"
+	HSetCommand      Command = "HSET"
+	HGetCommand      Command = "HGET"
+	HMGetCommand     Command = "HMGET"
+	HDelCommand      Command = "HDEL"
+	HGetAllCommand   Command = "HGETALL"
+	HExistsCommand   Command = "HEXISTS"
+	HLenCommand      Command = "HLEN"
+	HKeysCommand     Command = "HKEYS"
+	HValsCommand     Command = "HVALS"
+	HIncrByCommand   Command = "HINCRBY"
+	HScanCommand     Command = "HSCAN"
+
+	HIncrByFloatCommand Command = "HINCRBYFLOAT"
+	HRandFieldCommand   Command = "HRANDFIELD"
+	HExpireCommand      Command = "HEXPIRE"
+	HPExpireCommand     Command = "HPEXPIRE"
+	HExpireAtCommand    Command = "HEXPIREAT"
+	HPExpireAtCommand   Command = "HPEXPIREAT"
+	HTTLCommand         Command = "HTTL"
+	HPTTLCommand        Command = "HPTTL"
+	HPersistCommand     Command = "HPERSIST"
+
+	// Set commands
+	SAddCommand      Command = "SADD"
+	SRemCommand      Command = "SREM"
+	SMembersCommand  Command = "SMEMBERS"
+	SIsMemberCommand Command = "SISMEMBER"
+	SCardCommand     Command = "SCARD"
+	SInterCommand    Command = "SINTER"
+	SUnionCommand    Command = "SUNION"
+	SDiffCommand     Command = "SDIFF"
+	SPopCommand      Command = "SPOP"
+	SScanCommand     Command = "SSCAN"
+
+	SInterStoreCommand Command = "SINTERSTORE"
+	SUnionStoreCommand Command = "SUNIONSTORE"
+	SDiffStoreCommand  Command = "SDIFFSTORE"
+	SRandMemberCommand Command = "SRANDMEMBER"
+	SMIsMemberCommand  Command = "SMISMEMBER"
+	SInterCardCommand  Command = "SINTERCARD"
+
+	// Sorted set commands
+	ZAddCommand   Command = "ZADD"
+	ZScoreCommand Command = "ZSCORE"
+	ZCardCommand  Command = "ZCARD"
+	ZRemCommand   Command = "ZREM"
+	ZRangeCommand Command = "ZRANGE"
+
+	ZRevRangeCommand        Command = "ZREVRANGE"
+	ZRangeByScoreCommand    Command = "ZRANGEBYSCORE"
+	ZRevRangeByScoreCommand Command = "ZREVRANGEBYSCORE"
+	ZRangeByLexCommand      Command = "ZRANGEBYLEX"
+	ZRevRangeByLexCommand   Command = "ZREVRANGEBYLEX"
+	ZIncrByCommand          Command = "ZINCRBY"
+	ZRankCommand            Command = "ZRANK"
+	ZRevRankCommand         Command = "ZREVRANK"
+	ZCountCommand           Command = "ZCOUNT"
+	ZLexCountCommand        Command = "ZLEXCOUNT"
+	ZPopMinCommand          Command = "ZPOPMIN"
+	ZPopMaxCommand          Command = "ZPOPMAX"
+	BZPopMinCommand         Command = "BZPOPMIN"
+	BZPopMaxCommand         Command = "BZPOPMAX"
+	ZRangeStoreCommand      Command = "ZRANGESTORE"
+	ZUnionCommand           Command = "ZUNION"
+	ZInterCommand           Command = "ZINTER"
+	ZDiffCommand            Command = "ZDIFF"
+	ZUnionStoreCommand      Command = "ZUNIONSTORE"
+	ZInterStoreCommand      Command = "ZINTERSTORE"
+	ZDiffStoreCommand       Command = "ZDIFFSTORE"
+	ZMScoreCommand          Command = "ZMSCORE"
+	ZRandMemberCommand      Command = "ZRANDMEMBER"
+	ZScanCommand            Command = "ZSCAN"
+	ZRemRangeByRankCommand  Command = "ZREMRANGEBYRANK"
+	ZRemRangeByScoreCommand Command = "ZREMRANGEBYSCORE"
+	ZRemRangeByLexCommand   Command = "ZREMRANGEBYLEX"
+
+	// Diagnostics commands
+	SlowlogCommand Command = "SLOWLOG"
+	MonitorCommand Command = "MONITOR"
+	MemoryCommand  Command = "MEMORY"
+
+	// Pub/Sub commands
+	SubscribeCommand        Command = "SUBSCRIBE"
+	SubscribeHistoryCommand Command = "SUBSCRIBEHISTORY"
+	UnsubscribeCommand      Command = "UNSUBSCRIBE"
+	PSubscribeCommand       Command = "PSUBSCRIBE"
+	PUnsubscribeCommand     Command = "PUNSUBSCRIBE"
+	PublishCommand          Command = "PUBLISH"
+	PubsubCommand           Command = "PUBSUB"
+	SSubscribeCommand       Command = "SSUBSCRIBE"
+	SUnsubscribeCommand     Command = "SUNSUBSCRIBE"
+	SPublishCommand         Command = "SPUBLISH"
+
+	// Stream consumer group commands
+	XGroupCommand     Command = "XGROUP"
+	XReadGroupCommand Command = "XREADGROUP"
+	XAckCommand       Command = "XACK"
+	XPendingCommand   Command = "XPENDING"
+	XClaimCommand     Command = "XCLAIM"
+	XAutoClaimCommand Command = "XAUTOCLAIM"
+	XInfoCommand      Command = "XINFO"
+
+	// Extensions of this server, not in Redis
+	RateLimitCommand Command = "RATELIMIT"
+	SnapshotCommand  Command = "SNAPSHOT"
 )
 
 // WriteCommands defines commands that modify data
-var WriteCommands = []Command{SetCommand, IncrCommand, XAddCommand}
+var WriteCommands = []Command{
+	SetCommand, IncrCommand, AppendCommand, SetRangeCommand, SetBitCommand, DelCommand, XAddCommand, XDelCommand,
+	ExpireCommand, PExpireCommand, ExpireAtCommand, PExpireAtCommand, PersistCommand,
+	XGroupCommand, XReadGroupCommand, XAckCommand, XClaimCommand, XAutoClaimCommand, RateLimitCommand,
+	RPushCommand, LPushCommand, LPopCommand, RPopCommand, BLPopCommand, BRPopCommand,
+	LSetCommand, LInsertCommand, LRemCommand, LTrimCommand, LMoveCommand, BLMoveCommand, RPopLPushCommand,
+	LMPopCommand, BLMPopCommand,
+	HSetCommand, HDelCommand, HIncrByCommand, HIncrByFloatCommand,
+	HExpireCommand, HPExpireCommand, HExpireAtCommand, HPExpireAtCommand, HPersistCommand,
+	SAddCommand, SRemCommand, SPopCommand, SInterStoreCommand, SUnionStoreCommand, SDiffStoreCommand,
+	ZAddCommand, ZRemCommand, ZIncrByCommand, ZPopMinCommand, ZPopMaxCommand, BZPopMinCommand, BZPopMaxCommand,
+	ZRangeStoreCommand, ZUnionStoreCommand, ZInterStoreCommand, ZDiffStoreCommand,
+	ZRemRangeByRankCommand, ZRemRangeByScoreCommand, ZRemRangeByLexCommand,
+}
+
+// AdminCommands defines commands that inspect or change server state
+var AdminCommands = []Command{ConfigCommand, ReplconfCommand, PsyncCommand, ClusterCommand, DebugCommand, SlowlogCommand, MonitorCommand}
+
+// IsWriteCommand reports whether cmd modifies data
+func IsWriteCommand(cmd Command) bool {
+	for _, c := range WriteCommands {
+		if c == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAdminCommand reports whether cmd is an administrative command
+func IsAdminCommand(cmd Command) bool {
+	for _, c := range AdminCommands {
+		if c == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// AuditCommand records an executed admin or write command in the audit log
+func AuditCommand(srv *server.Server, clientConn net.Conn, cmd string, args []string) {
+	if !srv.Audit.Enabled() || (!IsWriteCommand(Command(cmd)) && !IsAdminCommand(Command(cmd))) {
+		return
+	}
+	srv.Audit.Record(audit.DefaultUser, clientConn.RemoteAddr().String(), cmd, args)
+}
 
 // Handler defines the interface for command handlers
 type Handler interface {
@@ -72,27 +262,155 @@ func (r *Registry) Get(cmd Command) (Handler, bool) {
 func (r *Registry) RegisterAllHandlers() {
 	r.Register(PingCommand, &PingHandler{})
 	r.Register(EchoCommand, &EchoHandler{})
+	r.Register(QuitCommand, &QuitHandler{})
+	r.Register(ResetCommand, &ResetHandler{})
 	r.Register(GetCommand, &GetHandler{})
 	r.Register(SetCommand, &SetHandler{})
 	r.Register(KeysCommand, &KeysHandler{})
+	r.Register(ScanCommand, &ScanHandler{})
 	r.Register(ConfigCommand, &ConfigHandler{})
 	r.Register(InfoCommand, &InfoHandler{})
 	r.Register(ReplconfCommand, &ReplconfHandler{})
 	r.Register(PsyncCommand, &PsyncHandler{})
 	r.Register(WaitCommand, &WaitHandler{})
+	r.Register(ShutdownCommand, &ShutdownHandler{})
 	r.Register(CommandCommand, &CommandHandler{})
 	r.Register(IncrCommand, &IncrHandler{})
+	r.Register(AppendCommand, &AppendHandler{})
+	r.Register(SetRangeCommand, &SetRangeHandler{})
+	r.Register(SetBitCommand, &SetBitHandler{})
+	r.Register(GetBitCommand, &GetBitHandler{})
+	r.Register(BitCountCommand, &BitCountHandler{})
 	r.Register(MultiCommand, &MultiHandler{})
-	r.Register(ExecCommand, &ExecHandler{})
+	r.Register(ExecCommand, &ExecHandler{registry: r})
 	r.Register(DiscardCommand, &DiscardHandler{})
+	r.Register(WatchCommand, &WatchHandler{})
+	r.Register(UnwatchCommand, &UnwatchHandler{})
 	r.Register(TypeCommand, &TypeHandler{})
 	r.Register(XAddCommand, &XAddHandler{})
 	r.Register(XRangeCommand, &XRangeHandler{})
+	r.Register(XRevRangeCommand, &XRevRangeHandler{})
 	r.Register(XReadCommand, &XReadHandler{})
+	r.Register(XDelCommand, &XDelHandler{})
+	r.Register(XGroupCommand, &XGroupHandler{})
+	r.Register(XReadGroupCommand, &XReadGroupHandler{})
+	r.Register(XAckCommand, &XAckHandler{})
+	r.Register(XPendingCommand, &XPendingHandler{})
+	r.Register(XClaimCommand, &XClaimHandler{})
+	r.Register(XAutoClaimCommand, &XAutoClaimHandler{})
+	r.Register(XInfoCommand, &XInfoHandler{})
+	r.Register(RateLimitCommand, &RateLimitHandler{})
+	r.Register(SnapshotCommand, &SnapshotHandler{})
 	r.Register(RPushCommand, &RPushHandler{})
 	r.Register(LRangeCommand, &LRangeHandler{})
 	r.Register(LPushCommand, &LPushHandler{})
 	r.Register(LLenCommand, &LLenHandler{})
 	r.Register(LPopCommand, &LPopHandler{})
-	r.Register(BLPopCommand, &BLPopHandler{})
+	r.Register(RPopCommand, &RPopHandler{})
+	r.Register(LIndexCommand, &LIndexHandler{})
+	r.Register(LSetCommand, &LSetHandler{})
+	r.Register(LInsertCommand, &LInsertHandler{})
+	r.Register(LRemCommand, &LRemHandler{})
+	r.Register(LTrimCommand, &LTrimHandler{})
+	r.Register(LMoveCommand, &LMoveHandler{name: "LMOVE"})
+	r.Register(BLMoveCommand, &LMoveHandler{name: "BLMOVE", blocking: true})
+	r.Register(RPopLPushCommand, &RPopLPushHandler{})
+	r.Register(LMPopCommand, &LMPopHandler{name: "LMPOP"})
+	r.Register(BLMPopCommand, &LMPopHandler{name: "BLMPOP", blocking: true})
+	r.Register(BLPopCommand, &BPopHandler{name: "BLPOP"})
+	r.Register(BRPopCommand, &BPopHandler{name: "BRPOP", tail: true})
+	r.Register(ClusterCommand, &ClusterHandler{})
+	r.Register(TouchCommand, &TouchHandler{})
+	r.Register(DelCommand, &DelHandler{})
+	r.Register(ExpireCommand, &ExpireHandler{name: "EXPIRE", unit: time.Second})
+	r.Register(PExpireCommand, &ExpireHandler{name: "PEXPIRE", unit: time.Millisecond})
+	r.Register(ExpireAtCommand, &ExpireHandler{name: "EXPIREAT", unit: time.Second, absolute: true})
+	r.Register(PExpireAtCommand, &ExpireHandler{name: "PEXPIREAT", unit: time.Millisecond, absolute: true})
+	r.Register(TTLCommand, &TTLHandler{name: "TTL", unit: time.Second})
+	r.Register(PTTLCommand, &TTLHandler{name: "PTTL", unit: time.Millisecond})
+	r.Register(PersistCommand, &PersistHandler{})
+	r.Register(ObjectCommand, &ObjectHandler{})
+	r.Register(DebugCommand, &DebugHandler{})
+	r.Register(ClientCommand, &ClientHandler{})
+	r.Register(SlowlogCommand, &SlowlogHandler{})
+	r.Register(MemoryCommand, &MemoryHandler{})
+	r.Register(MonitorCommand, &MonitorHandler{})
+	r.Register(HSetCommand, &HSetHandler{})
+	r.Register(HGetCommand, &HGetHandler{})
+	r.Register(HMGetCommand, &HMGetHandler{})
+	r.Register(HDelCommand, &HDelHandler{})
+	r.Register(HGetAllCommand, &HGetAllHandler{})
+	r.Register(HExistsCommand, &HExistsHandler{})
+	r.Register(HLenCommand, &HLenHandler{})
+	r.Register(HKeysCommand, &HKeysHandler{})
+	r.Register(HValsCommand, &HValsHandler{})
+	r.Register(HIncrByCommand, &HIncrByHandler{})
+	r.Register(HIncrByFloatCommand, &HIncrByFloatHandler{})
+	r.Register(HRandFieldCommand, &HRandFieldHandler{})
+	r.Register(HScanCommand, &HScanHandler{})
+	r.Register(HExpireCommand, &HExpireHandler{name: "HEXPIRE", unit: time.Second})
+	r.Register(HPExpireCommand, &HExpireHandler{name: "HPEXPIRE", unit: time.Millisecond})
+	r.Register(HExpireAtCommand, &HExpireHandler{name: "HEXPIREAT", unit: time.Second, absolute: true})
+	r.Register(HPExpireAtCommand, &HExpireHandler{name: "HPEXPIREAT", unit: time.Millisecond, absolute: true})
+	r.Register(HTTLCommand, &HTTLHandler{name: "HTTL", unit: time.Second})
+	r.Register(HPTTLCommand, &HTTLHandler{name: "HPTTL", unit: time.Millisecond})
+	r.Register(HPersistCommand, &HPersistHandler{})
+	r.Register(SAddCommand, &SAddHandler{})
+	r.Register(SRemCommand, &SRemHandler{})
+	r.Register(SMembersCommand, &SMembersHandler{})
+	r.Register(SIsMemberCommand, &SIsMemberHandler{})
+	r.Register(SCardCommand, &SCardHandler{})
+	r.Register(SMIsMemberCommand, &SMIsMemberHandler{})
+	r.Register(SRandMemberCommand, &SRandMemberHandler{})
+	r.Register(SPopCommand, &SPopHandler{})
+	r.Register(SInterCardCommand, &SInterCardHandler{})
+	r.Register(SScanCommand, &SScanHandler{})
+	r.Register(SInterCommand, &SetOpHandler{name: "SINTER", op: database.SetOpInter})
+	r.Register(SUnionCommand, &SetOpHandler{name: "SUNION", op: database.SetOpUnion})
+	r.Register(SDiffCommand, &SetOpHandler{name: "SDIFF", op: database.SetOpDiff})
+	r.Register(SInterStoreCommand, &SetOpHandler{name: "SINTERSTORE", op: database.SetOpInter, store: true})
+	r.Register(SUnionStoreCommand, &SetOpHandler{name: "SUNIONSTORE", op: database.SetOpUnion, store: true})
+	r.Register(SDiffStoreCommand, &SetOpHandler{name: "SDIFFSTORE", op: database.SetOpDiff, store: true})
+	r.Register(ZAddCommand, &ZAddHandler{})
+	r.Register(ZScoreCommand, &ZScoreHandler{})
+	r.Register(ZCardCommand, &ZCardHandler{})
+	r.Register(ZRemCommand, &ZRemHandler{})
+	r.Register(ZRangeCommand, &ZRangeHandler{name: "ZRANGE", kind: zrangeByRank, generic: true})
+	r.Register(ZRevRangeCommand, &ZRangeHandler{name: "ZREVRANGE", kind: zrangeByRank, rev: true})
+	r.Register(ZRangeByScoreCommand, &ZRangeHandler{name: "ZRANGEBYSCORE", kind: zrangeByScore})
+	r.Register(ZRevRangeByScoreCommand, &ZRangeHandler{name: "ZREVRANGEBYSCORE", kind: zrangeByScore, rev: true})
+	r.Register(ZRangeByLexCommand, &ZRangeHandler{name: "ZRANGEBYLEX", kind: zrangeByLex})
+	r.Register(ZRevRangeByLexCommand, &ZRangeHandler{name: "ZREVRANGEBYLEX", kind: zrangeByLex, rev: true})
+	r.Register(ZIncrByCommand, &ZIncrByHandler{})
+	r.Register(ZRankCommand, &ZRankHandler{name: "ZRANK"})
+	r.Register(ZRevRankCommand, &ZRankHandler{name: "ZREVRANK", rev: true})
+	r.Register(ZCountCommand, &ZCountHandler{})
+	r.Register(ZLexCountCommand, &ZLexCountHandler{})
+	r.Register(ZPopMinCommand, &ZPopHandler{name: "ZPOPMIN"})
+	r.Register(ZPopMaxCommand, &ZPopHandler{name: "ZPOPMAX", max: true})
+	r.Register(BZPopMinCommand, &BZPopHandler{name: "BZPOPMIN"})
+	r.Register(BZPopMaxCommand, &BZPopHandler{name: "BZPOPMAX", max: true})
+	r.Register(ZRangeStoreCommand, &ZRangeHandler{name: "ZRANGESTORE", kind: zrangeByRank, generic: true, store: true})
+	r.Register(ZUnionCommand, &ZSetOpHandler{name: "ZUNION", op: database.SetOpUnion})
+	r.Register(ZInterCommand, &ZSetOpHandler{name: "ZINTER", op: database.SetOpInter})
+	r.Register(ZDiffCommand, &ZSetOpHandler{name: "ZDIFF", op: database.SetOpDiff})
+	r.Register(ZUnionStoreCommand, &ZSetOpHandler{name: "ZUNIONSTORE", op: database.SetOpUnion, store: true})
+	r.Register(ZInterStoreCommand, &ZSetOpHandler{name: "ZINTERSTORE", op: database.SetOpInter, store: true})
+	r.Register(ZDiffStoreCommand, &ZSetOpHandler{name: "ZDIFFSTORE", op: database.SetOpDiff, store: true})
+	r.Register(ZMScoreCommand, &ZMScoreHandler{})
+	r.Register(ZRandMemberCommand, &ZRandMemberHandler{})
+	r.Register(ZScanCommand, &ZScanHandler{})
+	r.Register(ZRemRangeByRankCommand, &ZRemRangeHandler{name: "ZREMRANGEBYRANK", kind: zrangeByRank})
+	r.Register(ZRemRangeByScoreCommand, &ZRemRangeHandler{name: "ZREMRANGEBYSCORE", kind: zrangeByScore})
+	r.Register(ZRemRangeByLexCommand, &ZRemRangeHandler{name: "ZREMRANGEBYLEX", kind: zrangeByLex})
+	r.Register(SubscribeCommand, &SubscribeHandler{name: "SUBSCRIBE"})
+	r.Register(SubscribeHistoryCommand, &SubscribeHandler{name: "SUBSCRIBEHISTORY", replay: true})
+	r.Register(UnsubscribeCommand, &UnsubscribeHandler{})
+	r.Register(PSubscribeCommand, &PSubscribeHandler{})
+	r.Register(PUnsubscribeCommand, &PUnsubscribeHandler{})
+	r.Register(PublishCommand, &PublishHandler{})
+	r.Register(PubsubCommand, &PubsubHandler{})
+	r.Register(SSubscribeCommand, &SSubscribeHandler{})
+	r.Register(SUnsubscribeCommand, &SUnsubscribeHandler{})
+	r.Register(SPublishCommand, &SPublishHandler{})
 }
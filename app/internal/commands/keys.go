@@ -0,0 +1,90 @@
+package commands
+
+import (
+	"strconv"
+	"strings"
+)
+
+// commandKeys returns the keys a command operates on, for cluster slot
+// routing. Commands without keys, and ones whose arguments are too malformed
+// to find them, return nil; their handler reports the error.
+func commandKeys(cmd Command, args []string) []string {
+	switch cmd {
+	case CommandCommand, EchoCommand, PingCommand, QuitCommand, ResetCommand, ConfigCommand, KeysCommand, ScanCommand, InfoCommand,
+		ReplconfCommand, PsyncCommand, WaitCommand, ShutdownCommand, MultiCommand, ExecCommand, DiscardCommand, UnwatchCommand,
+		ClusterCommand, DebugCommand, ClientCommand, SlowlogCommand, MonitorCommand, MemoryCommand, SnapshotCommand,
+		SubscribeCommand, SubscribeHistoryCommand, UnsubscribeCommand, PSubscribeCommand, PUnsubscribeCommand, PublishCommand, PubsubCommand:
+		return nil
+
+	case DelCommand, TouchCommand, WatchCommand, SSubscribeCommand, SUnsubscribeCommand,
+		SInterCommand, SUnionCommand, SDiffCommand,
+		SInterStoreCommand, SUnionStoreCommand, SDiffStoreCommand:
+		return args
+
+	case BLPopCommand, BRPopCommand, BZPopMinCommand, BZPopMaxCommand:
+		// The last argument is the timeout
+		if len(args) < 2 {
+			return nil
+		}
+		return args[:len(args)-1]
+
+	case LMoveCommand, BLMoveCommand, RPopLPushCommand, ZRangeStoreCommand:
+		// Source and destination
+		if len(args) < 2 {
+			return nil
+		}
+		return args[:2]
+
+	case LMPopCommand, SInterCardCommand, ZUnionCommand, ZInterCommand, ZDiffCommand:
+		return numKeys(args, 0)
+
+	case BLMPopCommand:
+		// BLMPOP timeout numkeys key...
+		return numKeys(args, 1)
+
+	case ZUnionStoreCommand, ZInterStoreCommand, ZDiffStoreCommand:
+		// Z*STORE destination numkeys key...
+		if len(args) < 1 {
+			return nil
+		}
+		keys := numKeys(args, 1)
+		if keys == nil {
+			return nil
+		}
+		return append([]string{args[0]}, keys...)
+
+	case XReadCommand, XReadGroupCommand:
+		// XREAD[GROUP] [GROUP g c] [COUNT n] [BLOCK ms] [NOACK] STREAMS key... id...
+		for i, arg := range args {
+			if strings.ToUpper(arg) == "STREAMS" {
+				streams := args[i+1:]
+				return streams[:len(streams)/2]
+			}
+		}
+		return nil
+
+	case ObjectCommand, XGroupCommand, XInfoCommand:
+		// OBJECT/XGROUP/XINFO subcommand key
+		if len(args) < 2 {
+			return nil
+		}
+		return args[1:2]
+	}
+
+	if len(args) < 1 {
+		return nil
+	}
+	return args[:1]
+}
+
+// numKeys returns the keys counted by the numkeys argument at args[at]
+func numKeys(args []string, at int) []string {
+	if len(args) <= at {
+		return nil
+	}
+	n, err := strconv.Atoi(args[at])
+	if err != nil || n <= 0 || n > len(args)-at-1 {
+		return nil
+	}
+	return args[at+1 : at+1+n]
+}
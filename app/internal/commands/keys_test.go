@@ -0,0 +1,42 @@
+package commands
+
+import "testing"
+
+func TestDel(t *testing.T) {
+	h := newHarness(t)
+	h.do("SET", "a", "1")
+	h.do("RPUSH", "b", "x")
+	h.expect("(integer) 2", "TOUCH", "a", "b", "c")
+	h.expect("(integer) 2", "DEL", "a", "b", "c")
+	h.expect("(integer) 0", "TOUCH", "a", "b")
+}
+
+func TestExpireTTLPersist(t *testing.T) {
+	h := newHarness(t)
+	h.expect("(integer) -2", "TTL", "k")
+	h.do("HSET", "k", "f", "v")
+	h.expect("(integer) -1", "TTL", "k")
+	h.expect("(integer) 1", "EXPIRE", "k", "100")
+	if ttl := h.do("TTL", "k").Int; ttl < 99 || ttl > 100 {
+		t.Fatalf("TTL after EXPIRE 100 = %d", ttl)
+	}
+	h.expect("(integer) 0", "EXPIRE", "k", "50", "GT")
+	h.expect("(integer) 1", "PERSIST", "k")
+	h.expect("(integer) -1", "TTL", "k")
+}
+
+func TestExpireInThePastDeletes(t *testing.T) {
+	h := newHarness(t)
+	h.do("SET", "k", "v")
+	h.expect("(integer) 1", "PEXPIREAT", "k", "1")
+	h.expect(`"none"`, "TYPE", "k")
+}
+
+func TestKeysPattern(t *testing.T) {
+	h := newHarness(t)
+	for _, key := range []string{"user:1", "user:2", "order:1"} {
+		h.do("SET", key, "x")
+	}
+	h.expect(`["user:1", "user:2"]`, "KEYS", "user:*")
+	h.expect(`["order:1"]`, "KEYS", "[^u]*:?")
+}
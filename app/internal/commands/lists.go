@@ -3,6 +3,7 @@ package commands
 import (
 	"net"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
@@ -26,23 +27,15 @@ func (h *RPushHandler) Handle(srv *server.Server, clientConn net.Conn, args []st
 		return nil
 	}
 
-	key := args[0]
-	values := args[1:]
-
-	totalLength := 0
-	for _, item := range values {
-		len, err := database.RPushAdd(key, item)
-		if err != nil {
-			protocol.WriteError(clientConn, err.Error())
-			return nil
-		}
-		totalLength = len
+	length, err := database.RPushAdd(args[0], args[1:]...)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
 	}
 
-	// command := append([]string{"RPUSH", key}, values...)
-	// srv.ReplicateCommand(command)
+	srv.ReplicateCommand(clientConn, append([]string{"RPUSH"}, args...))
 
-	protocol.WriteInteger(clientConn, totalLength)
+	protocol.WriteInteger(clientConn, length)
 	return nil
 }
 
@@ -61,23 +54,15 @@ func (h *LPushHandler) Handle(srv *server.Server, clientConn net.Conn, args []st
 		return nil
 	}
 
-	key := args[0]
-	values := args[1:]
-
-	totalLength := 0
-	for _, item := range values {
-		len, err := database.LPush(key, item)
-		if err != nil {
-			protocol.WriteError(clientConn, err.Error())
-			return nil
-		}
-		totalLength = len
+	length, err := database.LPush(args[0], args[1:]...)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
 	}
 
-	// command := append([]string{"RPUSH", key}, values...)
-	// srv.ReplicateCommand(command)
+	srv.ReplicateCommand(clientConn, append([]string{"LPUSH"}, args...))
 
-	protocol.WriteInteger(clientConn, totalLength)
+	protocol.WriteInteger(clientConn, length)
 	return nil
 }
 
@@ -101,8 +86,6 @@ func (h *LLenHandler) Handle(srv *server.Server, clientConn net.Conn, args []str
 		protocol.WriteError(clientConn, err.Error())
 		return nil
 	}
-	// command := append([]string{"RPUSH", strconv.Itoa(start), strconv.Itoa(end)})
-	// srv.ReplicateCommand(command)
 
 	protocol.WriteInteger(clientConn, data)
 	return nil
@@ -138,13 +121,12 @@ func (h *LRangeHandler) Handle(srv *server.Server, clientConn net.Conn, args []s
 		protocol.WriteError(clientConn, err.Error())
 		return nil
 	}
-	// command := append([]string{"RPUSH", strconv.Itoa(start), strconv.Itoa(end)})
-	// srv.ReplicateCommand(command)
 
 	protocol.WriteArray(clientConn, data)
 	return nil
 }
 
+// LPopHandler handles LPOP commands
 type LPopHandler struct {
 	logger *logging.Logger
 }
@@ -153,107 +135,633 @@ func (h *LPopHandler) Handle(srv *server.Server, clientConn net.Conn, args []str
 	if h.logger == nil {
 		h.logger = logging.NewLogger("LPOP")
 	}
+	return handlePop(srv, clientConn, h.logger, "LPOP", false, args)
+}
+
+// BPopHandler handles BLPOP and BRPOP key [key ...] timeout. Keys are tried
+// in order and the client is woken through database.WaitForKeys when one of
+// them is pushed to, rather than by polling.
+type BPopHandler struct {
+	name   string // Command name used in errors and logs
+	tail   bool   // Whether elements are popped from the tail (BRPOP)
+	logger *logging.Logger
+}
+
+func (h *BPopHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger(h.name)
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for '"+h.name+"' command")
+		return nil
+	}
 
-	if len(args) < 1 {
-		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'LPOP' command")
+	keys := args[:len(args)-1]
+	timeout, err := parseBlockTimeout(args[len(args)-1])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
 		return nil
 	}
 
-	key := args[0]
-	var n = 0
-	if len(args) >= 2 {
-		number, err := strconv.Atoi(args[1])
-		if err != nil {
-			protocol.WriteError(clientConn, "ERR wrong number of arguments for 'LPOP' command")
+	// Register before the first attempt so a push in between still wakes us.
+	ready, cancel := database.WaitForKeys(keys)
+	defer cancel()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	blocked := newBlockedClient(srv, clientConn)
+	defer blocked.Stop()
+
+	for {
+		for _, key := range keys {
+			end := beginBlockedWrite(srv, clientConn, true)
+			element, found, err := database.PopIfList(key, h.tail)
+			if found {
+				// Replicas apply the pop without blocking.
+				pop := "LPOP"
+				if h.tail {
+					pop = "RPOP"
+				}
+				srv.ReplicateCommand(clientConn, []string{pop, key})
+			}
+			end()
+			if err != nil {
+				protocol.WriteError(clientConn, err.Error())
+				return nil
+			}
+			if !found {
+				continue
+			}
+
+			protocol.WriteArray(clientConn, []string{key, element})
+			h.logger.Success("Command completed successfully")
 			return nil
 		}
-		n = number
-		data, err := database.RemoveNFromArray(key, n)
-		if err != nil {
-			protocol.WriteError(clientConn, err.Error())
+
+		switch blocked.wait(ready, expired) {
+		case waitExpired:
+			h.logger.Debug("Timed out waiting on %v", keys)
+			clientConn.Write([]byte("*-1\r\n"))
+			return nil
+		case waitGone:
+			h.logger.Debug("Client disconnected while waiting on %v", keys)
 			return nil
 		}
-		protocol.WriteArray(clientConn, data)
+	}
+}
+
+// RPopHandler handles RPOP commands
+type RPopHandler struct {
+	logger *logging.Logger
+}
+
+func (h *RPopHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("RPOP")
+	}
+	return handlePop(srv, clientConn, h.logger, "RPOP", true, args)
+}
+
+// handlePop runs LPOP, or RPOP when tail is set, with an optional count.
+// Without a count it replies with one element; with one, with an array of
+// up to count elements, or a null array when the key is missing.
+func handlePop(srv *server.Server, clientConn net.Conn, logger *logging.Logger, name string, tail bool, args []string) error {
+	logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 1 || len(args) > 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for '"+name+"' command")
 		return nil
 	}
-	data, err := database.RemoveNFromArray(key, 0)
+
+	count := 1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			protocol.WriteError(clientConn, "ERR value is out of range, must be positive")
+			return nil
+		}
+		count = n
+	}
+
+	pop := database.LPop
+	if tail {
+		pop = database.RPop
+	}
+	popped, found, err := pop(args[0], count)
 	if err != nil {
 		protocol.WriteError(clientConn, err.Error())
 		return nil
 	}
 
-	// command := append([]string{"RPUSH", strconv.Itoa(start), strconv.Itoa(end)})
-	// srv.ReplicateCommand(command)
+	if len(popped) > 0 {
+		srv.ReplicateCommand(clientConn, append([]string{name}, args...))
+	}
+
+	switch {
+	case len(args) == 2 && !found:
+		clientConn.Write([]byte("*-1\r\n"))
+	case len(args) == 2:
+		protocol.WriteArray(clientConn, popped)
+	case len(popped) == 0:
+		clientConn.Write([]byte("$-1\r\n"))
+	default:
+		protocol.WriteBulkString(clientConn, popped[0])
+	}
+	logger.Success("Command completed successfully")
+	return nil
+}
+
+// LIndexHandler handles LINDEX commands
+type LIndexHandler struct {
+	logger *logging.Logger
+}
+
+func (h *LIndexHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("LINDEX")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'LINDEX' command")
+		return nil
+	}
+
+	index, err := strconv.Atoi(args[1])
+	if err != nil {
+		protocol.WriteError(clientConn, "ERR value is not an integer or out of range")
+		return nil
+	}
+
+	element, ok, err := database.LIndex(args[0], index)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
 
-	if len(data) == 0 {
+	if ok {
+		protocol.WriteBulkString(clientConn, element)
+	} else {
 		clientConn.Write([]byte("$-1\r\n"))
+	}
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// LSetHandler handles LSET commands
+type LSetHandler struct {
+	logger *logging.Logger
+}
+
+func (h *LSetHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("LSET")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 3 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'LSET' command")
 		return nil
 	}
 
-	str := ""
-	for _, v := range data {
-		str += v
+	index, err := strconv.Atoi(args[1])
+	if err != nil {
+		protocol.WriteError(clientConn, "ERR value is not an integer or out of range")
+		return nil
 	}
-	protocol.WriteBulkString(clientConn, str)
+
+	if err := database.LSet(args[0], index, args[2]); err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	srv.ReplicateCommand(clientConn, append([]string{"LSET"}, args...))
+	protocol.WriteSimpleString(clientConn, "OK")
+	h.logger.Success("Command completed successfully")
 	return nil
 }
 
-type BLPopHandler struct {
+// LInsertHandler handles LINSERT key BEFORE|AFTER pivot element
+type LInsertHandler struct {
 	logger *logging.Logger
 }
 
-func (h *BLPopHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+func (h *LInsertHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
 	if h.logger == nil {
-		h.logger = logging.NewLogger("BLPOP")
+		h.logger = logging.NewLogger("LINSERT")
 	}
 
-	if len(args) < 2 {
-		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'BLPOP' command")
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 4 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'LINSERT' command")
 		return nil
 	}
 
-	key := args[0]
-	timeoutSeconds, err := strconv.ParseFloat(args[1], 32)
+	var before bool
+	switch strings.ToUpper(args[1]) {
+	case "BEFORE":
+		before = true
+	case "AFTER":
+		before = false
+	default:
+		protocol.WriteError(clientConn, "ERR syntax error")
+		return nil
+	}
+
+	length, err := database.LInsert(args[0], before, args[2], args[3])
 	if err != nil {
-		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'BLPOP' command")
+		protocol.WriteError(clientConn, err.Error())
 		return nil
 	}
-	timeout := time.Duration(timeoutSeconds * float64(time.Second))
-	req := database.BlPopRequest{
-		ListName:   key,
-		ResultChan: make(chan []string, 1),
-		Timeout:    timeout,
+
+	if length > 0 {
+		srv.ReplicateCommand(clientConn, append([]string{"LINSERT"}, args...))
 	}
-	go func() {
-		ticker := time.NewTicker(100 * time.Millisecond)
-		defer ticker.Stop()
-		startTime := time.Now()
-		for {
-			val, found := database.DB.Load(req.ListName)
-			if found {
-				if slice, ok := val.([]string); ok && len(slice) > 0 {
-					element := slice[0:1] // get only the first element as per LPOP
-					newSlice := slice[1:]
-					database.DB.Store(req.ListName, newSlice)
-					req.ResultChan <- element
-					return
-				}
-			}
+	protocol.WriteInteger(clientConn, length)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
 
-			if timeout != 0 && time.Since(startTime) > req.Timeout {
-				req.ResultChan <- []string{}
-				return
-			}
-			<-ticker.C
+// LRemHandler handles LREM commands
+type LRemHandler struct {
+	logger *logging.Logger
+}
+
+func (h *LRemHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("LREM")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 3 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'LREM' command")
+		return nil
+	}
+
+	count, err := strconv.Atoi(args[1])
+	if err != nil {
+		protocol.WriteError(clientConn, "ERR value is not an integer or out of range")
+		return nil
+	}
+
+	removed, err := database.LRem(args[0], count, args[2])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	if removed > 0 {
+		srv.ReplicateCommand(clientConn, append([]string{"LREM"}, args...))
+	}
+	protocol.WriteInteger(clientConn, removed)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// LTrimHandler handles LTRIM commands
+type LTrimHandler struct {
+	logger *logging.Logger
+}
+
+func (h *LTrimHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("LTRIM")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 3 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'LTRIM' command")
+		return nil
+	}
+
+	start, err := strconv.Atoi(args[1])
+	if err != nil {
+		protocol.WriteError(clientConn, "ERR value is not an integer or out of range")
+		return nil
+	}
+	stop, err := strconv.Atoi(args[2])
+	if err != nil {
+		protocol.WriteError(clientConn, "ERR value is not an integer or out of range")
+		return nil
+	}
+
+	if err := database.LTrim(args[0], start, stop); err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	srv.ReplicateCommand(clientConn, append([]string{"LTRIM"}, args...))
+	protocol.WriteSimpleString(clientConn, "OK")
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// parseListEnd parses the LEFT/RIGHT argument of LMOVE; tail is true for RIGHT
+func parseListEnd(s string) (tail bool, ok bool) {
+	switch strings.ToUpper(s) {
+	case "LEFT":
+		return false, true
+	case "RIGHT":
+		return true, true
+	default:
+		return false, false
+	}
+}
+
+func formatListEnd(tail bool) string {
+	if tail {
+		return "RIGHT"
+	}
+	return "LEFT"
+}
+
+// moveElement runs one LMOVE and replicates it. Every variant is replicated
+// as a plain LMOVE so replicas never block.
+func moveElement(srv *server.Server, clientConn net.Conn, src, dst string, fromTail, toTail bool) (string, bool, error) {
+	element, found, err := database.LMove(src, dst, fromTail, toTail)
+	if err == nil && found {
+		srv.ReplicateCommand(clientConn, []string{"LMOVE", src, dst, formatListEnd(fromTail), formatListEnd(toTail)})
+	}
+	return element, found, err
+}
+
+// LMoveHandler handles LMOVE source destination LEFT|RIGHT LEFT|RIGHT and,
+// when blocking, BLMOVE with a trailing timeout. BLMOVE waits through
+// database.WaitForKeys for source to be pushed to.
+type LMoveHandler struct {
+	name     string // Command name used in errors and logs
+	blocking bool   // Whether the last argument is a timeout (BLMOVE)
+	logger   *logging.Logger
+}
+
+func (h *LMoveHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger(h.name)
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	want := 4
+	if h.blocking {
+		want = 5
+	}
+	if len(args) != want {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for '"+h.name+"' command")
+		return nil
+	}
+
+	src, dst := args[0], args[1]
+	fromTail, ok1 := parseListEnd(args[2])
+	toTail, ok2 := parseListEnd(args[3])
+	if !ok1 || !ok2 {
+		protocol.WriteError(clientConn, "ERR syntax error")
+		return nil
+	}
+
+	var timeout time.Duration
+	if h.blocking {
+		var err error
+		if timeout, err = parseBlockTimeout(args[4]); err != nil {
+			protocol.WriteError(clientConn, err.Error())
+			return nil
 		}
-	}()
+	}
 
-	result := <-req.ResultChan
-	if len(result) == 0 {
-		clientConn.Write([]byte("$-1\r\n"))
+	var (
+		ready   <-chan struct{}
+		expired <-chan time.Time
+	)
+	if h.blocking {
+		// Register before the first attempt so a push in between still wakes us.
+		var cancel func()
+		ready, cancel = database.WaitForKeys([]string{src})
+		defer cancel()
+
+		if timeout > 0 {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			expired = timer.C
+		}
+	}
+
+	blocked := newBlockedClient(srv, clientConn)
+	defer blocked.Stop()
+
+	for {
+		end := beginBlockedWrite(srv, clientConn, h.blocking)
+		element, found, err := moveElement(srv, clientConn, src, dst, fromTail, toTail)
+		end()
+		if err != nil {
+			protocol.WriteError(clientConn, err.Error())
+			return nil
+		}
+		if found {
+			protocol.WriteBulkString(clientConn, element)
+			h.logger.Success("Command completed successfully")
+			return nil
+		}
+		if !h.blocking {
+			clientConn.Write([]byte("$-1\r\n"))
+			return nil
+		}
+
+		switch blocked.wait(ready, expired) {
+		case waitExpired:
+			h.logger.Debug("Timed out waiting on %s", src)
+			clientConn.Write([]byte("*-1\r\n"))
+			return nil
+		case waitGone:
+			h.logger.Debug("Client disconnected while waiting on %s", src)
+			return nil
+		}
+	}
+}
+
+// RPopLPushHandler handles RPOPLPUSH, the LMOVE source destination RIGHT LEFT
+// of older clients
+type RPopLPushHandler struct {
+	logger *logging.Logger
+}
+
+func (h *RPopLPushHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("RPOPLPUSH")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'RPOPLPUSH' command")
 		return nil
 	}
-	combined := append([]string{req.ListName}, result...)
-	protocol.WriteArray(clientConn, combined)
+
+	element, found, err := moveElement(srv, clientConn, args[0], args[1], true, false)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	if found {
+		protocol.WriteBulkString(clientConn, element)
+	} else {
+		clientConn.Write([]byte("$-1\r\n"))
+	}
+	h.logger.Success("Command completed successfully")
 	return nil
 }
+
+// LMPopHandler handles LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count]
+// and, when blocking, BLMPOP with a leading timeout. Up to count elements
+// are popped from the first non-empty list, which BLMPOP waits for through
+// database.WaitForKeys.
+type LMPopHandler struct {
+	name     string // Command name used in errors and logs
+	blocking bool   // Whether the first argument is a timeout (BLMPOP)
+	logger   *logging.Logger
+}
+
+func (h *LMPopHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger(h.name)
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	var timeout time.Duration
+	if h.blocking {
+		if len(args) < 1 {
+			protocol.WriteError(clientConn, "ERR wrong number of arguments for '"+h.name+"' command")
+			return nil
+		}
+		var err error
+		if timeout, err = parseBlockTimeout(args[0]); err != nil {
+			protocol.WriteError(clientConn, err.Error())
+			return nil
+		}
+		args = args[1:]
+	}
+
+	if len(args) < 3 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for '"+h.name+"' command")
+		return nil
+	}
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil || numKeys <= 0 {
+		protocol.WriteError(clientConn, "ERR numkeys should be greater than 0")
+		return nil
+	}
+	if len(args) < numKeys+2 {
+		protocol.WriteError(clientConn, "ERR syntax error")
+		return nil
+	}
+	keys := args[1 : numKeys+1]
+	tail, ok := parseListEnd(args[numKeys+1])
+	if !ok {
+		protocol.WriteError(clientConn, "ERR syntax error")
+		return nil
+	}
+
+	count := 1
+	switch rest := args[numKeys+2:]; {
+	case len(rest) == 0:
+	case len(rest) == 2 && strings.ToUpper(rest[0]) == "COUNT":
+		n, err := strconv.Atoi(rest[1])
+		if err != nil || n <= 0 {
+			protocol.WriteError(clientConn, "ERR count should be greater than 0")
+			return nil
+		}
+		count = n
+	default:
+		protocol.WriteError(clientConn, "ERR syntax error")
+		return nil
+	}
+
+	var (
+		ready   <-chan struct{}
+		expired <-chan time.Time
+	)
+	if h.blocking {
+		// Register before the first attempt so a push in between still wakes us.
+		var cancel func()
+		ready, cancel = database.WaitForKeys(keys)
+		defer cancel()
+
+		if timeout > 0 {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			expired = timer.C
+		}
+	}
+
+	blocked := newBlockedClient(srv, clientConn)
+	defer blocked.Stop()
+
+	for {
+		for _, key := range keys {
+			end := beginBlockedWrite(srv, clientConn, h.blocking)
+			popped, err := popElements(key, count, tail)
+			if len(popped) > 0 {
+				// Replicas apply the pop to the key we picked, without blocking.
+				pop := "LPOP"
+				if tail {
+					pop = "RPOP"
+				}
+				srv.ReplicateCommand(clientConn, []string{pop, key, strconv.Itoa(len(popped))})
+			}
+			end()
+			if err != nil {
+				protocol.WriteError(clientConn, err.Error())
+				return nil
+			}
+			if len(popped) == 0 {
+				continue
+			}
+
+			protocol.WriteArray2(clientConn, []string{
+				protocol.FormatBulkString(key),
+				protocol.FormatArray(popped),
+			})
+			h.logger.Success("Command completed successfully")
+			return nil
+		}
+
+		if !h.blocking {
+			clientConn.Write([]byte("*-1\r\n"))
+			return nil
+		}
+
+		switch blocked.wait(ready, expired) {
+		case waitExpired:
+			h.logger.Debug("Timed out waiting on %v", keys)
+			clientConn.Write([]byte("*-1\r\n"))
+			return nil
+		case waitGone:
+			h.logger.Debug("Client disconnected while waiting on %v", keys)
+			return nil
+		}
+	}
+}
+
+// popElements pops up to count elements from the head, or the tail when
+// tail is set, of the list at key. It returns nothing for a missing key.
+func popElements(key string, count int, tail bool) ([]string, error) {
+	pop := database.LPop
+	if tail {
+		pop = database.RPop
+	}
+	popped, _, err := pop(key, count)
+	return popped, err
+}
@@ -0,0 +1,42 @@
+package commands
+
+import (
+	"net"
+	"runtime/debug"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
+	"github.com/r0ld3x/redis-clone-go/app/internal/server"
+	"github.com/r0ld3x/redis-clone-go/app/pkg/database"
+)
+
+// MemoryHandler handles MEMORY PURGE
+type MemoryHandler struct {
+	logger      *logging.Logger
+	subcommands subcommands
+}
+
+func (h *MemoryHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("MEMORY")
+		h.subcommands = subcommands{command: "MEMORY", table: map[string]subcommand{
+			"PURGE": {arity: 1, run: h.purge},
+		}}
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if h.subcommands.dispatch(srv, clientConn, args) {
+		h.logger.Success("Command completed successfully")
+	}
+	return nil
+}
+
+// purge runs a compaction pass now, whether or not activedefrag is on, and
+// hands the memory it freed back to the operating system
+func (h *MemoryHandler) purge(srv *server.Server, clientConn net.Conn, _ []string) {
+	result := database.Defrag()
+	debug.FreeOSMemory()
+	h.logger.Info("Compacted %d of %d values, about %d bytes reclaimed", result.Compacted, result.Scanned, result.Reclaimed)
+	protocol.WriteSimpleString(clientConn, "OK")
+}
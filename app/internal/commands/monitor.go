@@ -0,0 +1,35 @@
+package commands
+
+import (
+	"net"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
+	"github.com/r0ld3x/redis-clone-go/app/internal/server"
+)
+
+// MonitorHandler handles MONITOR. From then on the connection receives a
+// line for every command the server executes; see Origin for which ones.
+type MonitorHandler struct {
+	logger *logging.Logger
+}
+
+func (h *MonitorHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("MONITOR")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 0 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'MONITOR' command")
+		return nil
+	}
+
+	// Reply before joining the feed so +OK is the first line the monitor sees
+	protocol.WriteSimpleString(clientConn, "OK")
+	srv.Monitors.Add(clientConn)
+
+	h.logger.Success("Command completed successfully")
+	return nil
+}
@@ -0,0 +1,113 @@
+package commands
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/server"
+)
+
+// Keyspace events are published to Pub/Sub as Redis does: on
+// __keyspace@0__:<key> with the event as the message when K is set in
+// notify-keyspace-events, and on __keyevent@0__:<event> with the key as the
+// message when E is. The classes select which events are published. Every
+// class of Redis is accepted, but only expired events (x) are produced yet.
+const (
+	keyspaceChannels = 1 << iota // K
+	keyeventChannels             // E
+	eventsGeneric                // g
+	eventsString                 // $
+	eventsList                   // l
+	eventsSet                    // s
+	eventsHash                   // h
+	eventsZSet                   // z
+	eventsExpired                // x
+	eventsEvicted                // e
+	eventsStream                 // t
+	eventsKeyMiss                // m
+	eventsNew                    // n
+	eventsModule                 // d
+)
+
+// eventsAll is what A stands for: every class but m, n and d, which must be
+// asked for by name
+const eventsAll = eventsGeneric | eventsString | eventsList | eventsSet | eventsHash |
+	eventsZSet | eventsExpired | eventsEvicted | eventsStream
+
+// keyspaceEventFlags maps each notify-keyspace-events character to its
+// flag, in the order they are rendered
+var keyspaceEventFlags = []struct {
+	char byte
+	flag int32
+}{
+	{'g', eventsGeneric}, {'$', eventsString}, {'l', eventsList}, {'s', eventsSet},
+	{'h', eventsHash}, {'z', eventsZSet}, {'x', eventsExpired}, {'e', eventsEvicted},
+	{'t', eventsStream}, {'m', eventsKeyMiss}, {'n', eventsNew}, {'d', eventsModule},
+	{'K', keyspaceChannels}, {'E', keyeventChannels},
+}
+
+// keyspaceEvents holds the notify-keyspace-events flags, read on every
+// event by whichever goroutine produces it
+var keyspaceEvents atomic.Int32
+
+// parseKeyspaceEvents parses a notify-keyspace-events value and returns
+// false when it holds a character that isn't a class or channel
+func parseKeyspaceEvents(value string) (int32, bool) {
+	var flags int32
+next:
+	for i := 0; i < len(value); i++ {
+		if value[i] == 'A' {
+			flags |= eventsAll
+			continue
+		}
+		for _, f := range keyspaceEventFlags {
+			if f.char == value[i] {
+				flags |= f.flag
+				continue next
+			}
+		}
+		return 0, false
+	}
+	return flags, true
+}
+
+// formatKeyspaceEvents renders flags the way CONFIG GET shows them, with A
+// in place of the classes it stands for
+func formatKeyspaceEvents(flags int32) string {
+	var b strings.Builder
+	if flags&eventsAll == eventsAll {
+		b.WriteByte('A')
+		flags &^= eventsAll
+	}
+	for _, f := range keyspaceEventFlags {
+		if flags&f.flag != 0 {
+			b.WriteByte(f.char)
+		}
+	}
+	return b.String()
+}
+
+// SetKeyspaceEvents applies a notify-keyspace-events value and returns false
+// when it is not a valid one
+func SetKeyspaceEvents(value string) bool {
+	flags, ok := parseKeyspaceEvents(value)
+	if ok {
+		keyspaceEvents.Store(flags)
+	}
+	return ok
+}
+
+// notifyKeyspaceEvent publishes event, of class, for key on the channels
+// notify-keyspace-events enables
+func notifyKeyspaceEvent(srv *server.Server, class int32, event, key string) {
+	flags := keyspaceEvents.Load()
+	if flags&class == 0 {
+		return
+	}
+	if flags&keyspaceChannels != 0 {
+		srv.PubSub.Publish("__keyspace@0__:"+key, event)
+	}
+	if flags&keyeventChannels != 0 {
+		srv.PubSub.Publish("__keyevent@0__:"+event, key)
+	}
+}
@@ -0,0 +1,298 @@
+package commands
+
+import (
+	"net"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
+	"github.com/r0ld3x/redis-clone-go/app/internal/server"
+)
+
+// SubscribeHandler handles SUBSCRIBE and SUBSCRIBEHISTORY commands.
+//
+// SUBSCRIBEHISTORY is a clone-specific extension: it subscribes like
+// SUBSCRIBE, then replays the messages each channel retained (see
+// --pubsub-history-len) right after its confirmation. It is a command of its
+// own rather than an option of SUBSCRIBE, whose arguments are all channels.
+type SubscribeHandler struct {
+	name   string // Command name used in logs and error messages
+	replay bool   // Whether retained messages are replayed
+	logger *logging.Logger
+}
+
+func (h *SubscribeHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger(h.name)
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 1 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for '"+h.name+"' command")
+		return nil
+	}
+
+	for _, channel := range args {
+		srv.PubSub.Subscribe(clientConn, channel, h.replay)
+	}
+
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// UnsubscribeHandler handles UNSUBSCRIBE commands
+type UnsubscribeHandler struct {
+	logger *logging.Logger
+}
+
+func (h *UnsubscribeHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("UNSUBSCRIBE")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) == 0 {
+		srv.PubSub.UnsubscribeAll(clientConn)
+		return nil
+	}
+
+	for _, channel := range args {
+		srv.PubSub.Unsubscribe(clientConn, channel)
+	}
+
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// PSubscribeHandler handles PSUBSCRIBE pattern [pattern ...], subscribing to
+// every channel matching each glob pattern
+type PSubscribeHandler struct {
+	logger *logging.Logger
+}
+
+func (h *PSubscribeHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("PSUBSCRIBE")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 1 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'PSUBSCRIBE' command")
+		return nil
+	}
+
+	for _, pattern := range args {
+		srv.PubSub.PSubscribe(clientConn, pattern)
+	}
+
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// PUnsubscribeHandler handles PUNSUBSCRIBE [pattern ...]
+type PUnsubscribeHandler struct {
+	logger *logging.Logger
+}
+
+func (h *PUnsubscribeHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("PUNSUBSCRIBE")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) == 0 {
+		srv.PubSub.PUnsubscribeAll(clientConn)
+		return nil
+	}
+
+	for _, pattern := range args {
+		srv.PubSub.PUnsubscribe(clientConn, pattern)
+	}
+
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// SSubscribeHandler handles SSUBSCRIBE shardchannel [shardchannel ...].
+// Shard channels are keys for cluster routing, so in cluster mode they must
+// share a slot served by this node.
+type SSubscribeHandler struct {
+	logger *logging.Logger
+}
+
+func (h *SSubscribeHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("SSUBSCRIBE")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 1 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'SSUBSCRIBE' command")
+		return nil
+	}
+
+	for _, channel := range args {
+		srv.PubSub.SSubscribe(clientConn, channel)
+	}
+
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// SUnsubscribeHandler handles SUNSUBSCRIBE [shardchannel ...]
+type SUnsubscribeHandler struct {
+	logger *logging.Logger
+}
+
+func (h *SUnsubscribeHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("SUNSUBSCRIBE")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) == 0 {
+		srv.PubSub.SUnsubscribeAll(clientConn)
+		return nil
+	}
+
+	for _, channel := range args {
+		srv.PubSub.SUnsubscribe(clientConn, channel)
+	}
+
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// PubsubHandler handles PUBSUB CHANNELS [pattern] / NUMSUB [channel ...] /
+// NUMPAT / SHARDCHANNELS [pattern] / SHARDNUMSUB [shardchannel ...], which
+// inspect the broker
+type PubsubHandler struct {
+	logger      *logging.Logger
+	subcommands subcommands
+}
+
+func (h *PubsubHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("PUBSUB")
+		h.subcommands = subcommands{command: "PUBSUB", table: map[string]subcommand{
+			"CHANNELS": {arity: -1, usage: "[pattern]", run: pubsubChannels},
+			"NUMSUB":   {arity: -1, usage: "[channel ...]", run: pubsubNumSub},
+			"NUMPAT": {arity: 1, run: func(srv *server.Server, clientConn net.Conn, _ []string) {
+				protocol.WriteInteger(clientConn, srv.PubSub.NumPat())
+			}},
+			"SHARDCHANNELS": {arity: -1, usage: "[pattern]", run: pubsubShardChannels},
+			"SHARDNUMSUB":   {arity: -1, usage: "[shardchannel ...]", run: pubsubShardNumSub},
+		}}
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if h.subcommands.dispatch(srv, clientConn, args) {
+		h.logger.Success("Command completed successfully")
+	}
+	return nil
+}
+
+// pubsubChannels handles PUBSUB CHANNELS [pattern], the active channels
+func pubsubChannels(srv *server.Server, clientConn net.Conn, args []string) {
+	if len(args) > 1 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'PUBSUB|CHANNELS' command")
+		return
+	}
+	pattern := ""
+	if len(args) == 1 {
+		pattern = args[0]
+	}
+	protocol.WriteArray(clientConn, srv.PubSub.Channels(pattern))
+}
+
+// pubsubShardChannels handles PUBSUB SHARDCHANNELS [pattern], the active
+// shard channels
+func pubsubShardChannels(srv *server.Server, clientConn net.Conn, args []string) {
+	if len(args) > 1 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'PUBSUB|SHARDCHANNELS' command")
+		return
+	}
+	pattern := ""
+	if len(args) == 1 {
+		pattern = args[0]
+	}
+	protocol.WriteArray(clientConn, srv.PubSub.ShardChannels(pattern))
+}
+
+// pubsubNumSub handles PUBSUB NUMSUB [channel ...], each channel followed by
+// its number of subscribers
+func pubsubNumSub(srv *server.Server, clientConn net.Conn, args []string) {
+	writeNumSub(clientConn, args, srv.PubSub.NumSub(args))
+}
+
+// pubsubShardNumSub handles PUBSUB SHARDNUMSUB [shardchannel ...], like
+// NUMSUB for shard channels
+func pubsubShardNumSub(srv *server.Server, clientConn net.Conn, args []string) {
+	writeNumSub(clientConn, args, srv.PubSub.ShardNumSub(args))
+}
+
+// writeNumSub replies with each channel followed by its count
+func writeNumSub(clientConn net.Conn, args []string, counts []int) {
+	elements := make([]string, 0, 2*len(args))
+	for i, channel := range args {
+		elements = append(elements, protocol.FormatBulkString(channel), protocol.FormatInteger(counts[i]))
+	}
+	protocol.WriteArray2(clientConn, elements)
+}
+
+// PublishHandler handles PUBLISH commands
+type PublishHandler struct {
+	logger *logging.Logger
+}
+
+func (h *PublishHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("PUBLISH")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'PUBLISH' command")
+		return nil
+	}
+
+	receivers := srv.PubSub.Publish(args[0], args[1])
+	h.logger.Debug("Delivered message on %s to %d subscribers", args[0], receivers)
+
+	protocol.WriteInteger(clientConn, receivers)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// SPublishHandler handles SPUBLISH shardchannel message, which only reaches
+// SSUBSCRIBE subscribers of the channel
+type SPublishHandler struct {
+	logger *logging.Logger
+}
+
+func (h *SPublishHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("SPUBLISH")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'SPUBLISH' command")
+		return nil
+	}
+
+	receivers := srv.PubSub.SPublish(args[0], args[1])
+	h.logger.Debug("Delivered message on shard channel %s to %d subscribers", args[0], receivers)
+
+	protocol.WriteInteger(clientConn, receivers)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
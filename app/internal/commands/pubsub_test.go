@@ -0,0 +1,58 @@
+package commands
+
+import (
+	"testing"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/pubsub"
+)
+
+// expectFrames fails the test unless the next frames the client gets, such
+// as subscribe confirmations and messages, render as want
+func (h *harness) expectFrames(want ...string) {
+	h.t.Helper()
+	for _, frame := range want {
+		reply, err := h.client.ReadReply(time.Second)
+		if err != nil {
+			h.t.Fatalf("reading %s: %v", frame, err)
+		}
+		if got := reply.String(); got != frame {
+			h.t.Fatalf("got %s, want %s", got, frame)
+		}
+	}
+}
+
+func TestSubscribeHistory(t *testing.T) {
+	h := newHarness(t)
+	h.srv.PubSub = pubsub.NewBroker(2)
+	publisher := h.connect()
+	for _, message := range []string{"one", "two", "three"} {
+		publisher.expect("(integer) 0", "PUBLISH", "news", message)
+	}
+
+	// SUBSCRIBE replays nothing
+	h.expect(`["subscribe", "news", (integer) 1]`, "SUBSCRIBE", "news")
+	h.do("UNSUBSCRIBE", "news")
+
+	h.expect(`["subscribe", "news", (integer) 1]`, "SUBSCRIBEHISTORY", "news", "quiet")
+	h.expectFrames(
+		`["message", "news", "two"]`,
+		`["message", "news", "three"]`,
+		`["subscribe", "quiet", (integer) 2]`,
+	)
+	publisher.expect("(integer) 1", "PUBLISH", "news", "four")
+	h.expectFrames(`["message", "news", "four"]`)
+}
+
+// Every argument of SUBSCRIBE is a channel, WITHHISTORY included
+func TestSubscribeChannelNamedWithHistory(t *testing.T) {
+	h := newHarness(t)
+	h.srv.PubSub = pubsub.NewBroker(2)
+	publisher := h.connect()
+	publisher.do("PUBLISH", "news", "old")
+
+	h.expect(`["subscribe", "WITHHISTORY", (integer) 1]`, "SUBSCRIBE", "WITHHISTORY", "news")
+	h.expectFrames(`["subscribe", "news", (integer) 2]`)
+	publisher.expect("(integer) 1", "PUBLISH", "WITHHISTORY", "hello")
+	h.expectFrames(`["message", "WITHHISTORY", "hello"]`)
+}
@@ -0,0 +1,70 @@
+package commands
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
+	"github.com/r0ld3x/redis-clone-go/app/internal/server"
+
+	"github.com/r0ld3x/redis-clone-go/app/pkg/database"
+)
+
+// RateLimitHandler handles RATELIMIT key tokens interval-ms, a token bucket
+// that is an extension of this server, not a Redis command. It replies with
+// [allowed (1 or 0), remaining tokens, retry after (ms, 0 when allowed)].
+type RateLimitHandler struct {
+	logger *logging.Logger
+}
+
+func (h *RateLimitHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("RATELIMIT")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 3 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'RATELIMIT' command")
+		return nil
+	}
+
+	tokens, err1 := strconv.Atoi(args[1])
+	interval, err2 := strconv.ParseInt(args[2], 10, 64)
+	if err1 != nil || err2 != nil {
+		protocol.WriteError(clientConn, "ERR value is not an integer or out of range")
+		return nil
+	}
+	if tokens <= 0 || interval <= 0 {
+		protocol.WriteError(clientConn, "ERR tokens and interval must be positive")
+		return nil
+	}
+
+	// The refill depends on the clock, so replicas get the resulting bucket
+	// rather than the command, expiring at an absolute time like any SET.
+	// It is replicated before any later write to the key, or a replica could
+	// end up with an older bucket. A refused call changes nothing.
+	var result database.RateLimit
+	var err error
+	srv.ReplicateAtomically(clientConn, func() []string {
+		result, err = database.RateLimitTake(args[0], tokens, time.Duration(interval)*time.Millisecond)
+		if err != nil || !result.Allowed {
+			return nil
+		}
+		return []string{"SET", args[0], result.State, "PXAT", strconv.FormatInt(result.ExpiresAt.UnixMilli(), 10)}
+	})
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	protocol.WriteArray2(clientConn, []string{
+		protocol.FormatInteger(boolToInt(result.Allowed)),
+		protocol.FormatInteger(result.Remaining),
+		protocol.FormatInteger(int(result.RetryAfter.Milliseconds())),
+	})
+	h.logger.Success("Command completed successfully")
+	return nil
+}
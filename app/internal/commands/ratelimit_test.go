@@ -0,0 +1,99 @@
+package commands
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	h := newHarness(t)
+	replica := h.attachReplica()
+
+	before := time.Now().Add(time.Minute).UnixMilli()
+	h.expect("[(integer) 1, (integer) 1, (integer) 0]", "RATELIMIT", "k", "2", "60000")
+	got := propagated(t, replica)
+	if len(got) != 5 || got[0] != "SET" || got[1] != "k" || !strings.HasPrefix(got[2], "1.000:") || got[3] != "PXAT" {
+		t.Fatalf("RATELIMIT propagated %v, want SET k 1.000:<ms> PXAT <ms>", got)
+	}
+	if at, _ := strconv.ParseInt(got[4], 10, 64); at < before || at > before+1000 {
+		t.Errorf("RATELIMIT with a 60000 ms interval propagated the deadline %s, want about %d", got[4], before)
+	}
+	if state := h.do("GET", "k").Str; state != got[2] {
+		t.Errorf("RATELIMIT stored %q and propagated %q", state, got[2])
+	}
+
+	h.expect("[(integer) 1, (integer) 0, (integer) 0]", "RATELIMIT", "k", "2", "60000")
+	propagated(t, replica)
+	if reply := h.do("RATELIMIT", "k", "2", "60000"); len(reply.Array) != 3 || reply.Array[0].Int != 0 || reply.Array[2].Int <= 0 {
+		t.Errorf("RATELIMIT on an empty bucket = %s, want refused with a retry time", reply)
+	}
+
+	// A refused call propagates nothing, so the next write comes first
+	h.do("SET", "other", "v")
+	if got := strings.Join(propagated(t, replica), " "); got != "SET other v" {
+		t.Errorf("after a refused RATELIMIT, propagated %q, want SET other v", got)
+	}
+
+	h.do("SET", "s", "v")
+	h.expect("(error) ERR key does not hold a rate limiter", "RATELIMIT", "s", "2", "60000")
+	h.expect("(error) ERR tokens and interval must be positive", "RATELIMIT", "k", "0", "60000")
+}
+
+// Concurrent calls on one bucket replicate their buckets in the order they
+// took their tokens, so the replica ends up with the master's bucket
+func TestRateLimitReplicatesInOrder(t *testing.T) {
+	h := newHarness(t)
+	replica := h.attachReplica()
+
+	// The interval is long enough that no token refills during the test
+	const clients, calls = 4, 100
+	var wg sync.WaitGroup
+	for range clients {
+		c := h.connect()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range calls {
+				if _, err := c.registry.Execute(c.srv, c.conn, OriginClient, "RATELIMIT", []string{"k", "1000", "1000000000000"}); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := c.client.ReadReply(time.Second); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var state string
+	for i := range clients * calls {
+		state = propagated(t, replica)[2]
+		if want := strconv.Itoa(999-i) + ".000:"; !strings.HasPrefix(state, want) {
+			t.Fatalf("bucket %d propagated is %s, want %s<ms>", i, state, want)
+		}
+	}
+	if stored := h.do("GET", "k").Str; stored != state {
+		t.Errorf("the master holds %s, the last bucket propagated is %s", stored, state)
+	}
+}
+
+func TestRateLimitInTransaction(t *testing.T) {
+	h := newHarness(t)
+	replica := h.attachReplica()
+	conn, client := h.watchClient()
+
+	reply := h.exec(conn, client, []string{"SET", "a", "1"}, []string{"RATELIMIT", "k", "2", "60000"}, []string{"SET", "b", "2"})
+	if reply.String() != `["OK", [(integer) 1, (integer) 1, (integer) 0], "OK"]` {
+		t.Fatalf("EXEC = %s", reply)
+	}
+	for _, want := range []string{"MULTI", "SET a 1", "SET k", "SET b 2", "EXEC"} {
+		if got := strings.Join(propagated(t, replica), " "); !strings.HasPrefix(got, want) {
+			t.Fatalf("the transaction propagated %q, want %q", got, want)
+		}
+	}
+}
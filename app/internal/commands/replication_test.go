@@ -0,0 +1,255 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
+	"github.com/r0ld3x/redis-clone-go/app/internal/testutil"
+)
+
+// attachReplica registers an in-memory replica that is online at once and
+// returns the client end, which reads the replication stream
+func (h *harness) attachReplica() *testutil.Client {
+	serverEnd, clientEnd := testutil.Pipe()
+	h.t.Cleanup(func() { serverEnd.Close() })
+	h.srv.AddReplica(serverEnd)
+	return testutil.NewClient(clientEnd)
+}
+
+// propagated reads the next command of the replication stream
+func propagated(t *testing.T, replica *testutil.Client) []string {
+	t.Helper()
+	reply, err := replica.ReadReply(time.Second)
+	if err != nil {
+		t.Fatalf("reading the replication stream: %v", err)
+	}
+	return reply.Strings()
+}
+
+func TestReplicaRefusesClientWrites(t *testing.T) {
+	h := newHarness(t)
+	h.srv.Config.Role = "slave"
+	for _, args := range [][]string{
+		{"SET", "k", "v"},
+		{"DEL", "k"},
+		{"EXPIRE", "k", "10"},
+		{"HSET", "h", "f", "v"},
+		{"RPUSH", "l", "x"},
+		{"ZADD", "z", "1", "m"},
+	} {
+		if reply := h.do(args...); reply.Str != "READONLY You can't write against a read only replica." {
+			t.Errorf("%v on a replica = %s, want READONLY", args, reply)
+		}
+	}
+	h.expect("(nil)", "GET", "k")
+
+	// Writes propagated by the master still apply
+	if _, err := h.registry.Execute(h.srv, h.conn, OriginMaster, "HSET", []string{"h", "f", "v"}); err != nil {
+		t.Fatal(err)
+	}
+	h.client.ReadReply(time.Second)
+	h.expect(`"v"`, "HGET", "h", "f")
+}
+
+func TestReplicaRefusesQueuedWrites(t *testing.T) {
+	h := newHarness(t)
+	h.srv.Config.Role = "slave"
+	if got := h.registry.QueueError(h.srv, "rpush", []string{"l", "x"}); got == "" {
+		t.Fatal("RPUSH was queued in MULTI on a replica")
+	}
+	if got := h.registry.QueueError(h.srv, "get", []string{"k"}); got != "" {
+		t.Fatalf("GET refused in MULTI on a replica: %s", got)
+	}
+}
+
+func TestSetExpiryReplicatedAsPXAT(t *testing.T) {
+	h := newHarness(t)
+	replica := h.attachReplica()
+
+	for _, opts := range [][]string{{"PX", "60000"}, {"EX", "60"}, {"PXAT", strconv.FormatInt(time.Now().Add(time.Minute).UnixMilli(), 10)}} {
+		before := time.Now().Add(time.Minute).UnixMilli()
+		h.expect(`"OK"`, append([]string{"SET", "k", "v"}, opts...)...)
+		after := time.Now().Add(time.Minute).UnixMilli()
+
+		got := propagated(t, replica)
+		if len(got) != 5 || got[0] != "SET" || got[3] != "PXAT" {
+			t.Fatalf("SET k v %v propagated as %v, want SET k v PXAT <ms>", opts, got)
+		}
+		if at, _ := strconv.ParseInt(got[4], 10, 64); at < before-1000 || at > after {
+			t.Fatalf("SET k v %v propagated PXAT %d, want about %d", opts, at, before)
+		}
+	}
+
+	h.expect(`"OK"`, "SET", "k", "v")
+	if got := propagated(t, replica); len(got) != 3 {
+		t.Fatalf("SET without expiry propagated as %v", got)
+	}
+}
+
+func TestSetExpiryInThePastDeletes(t *testing.T) {
+	h := newHarness(t)
+	replica := h.attachReplica()
+	h.expect(`"OK"`, "SET", "k", "v", "PXAT", "1")
+	h.expect("(nil)", "GET", "k")
+	if got := propagated(t, replica); len(got) != 2 || got[0] != "DEL" {
+		t.Fatalf("SET with an elapsed PXAT propagated as %v, want DEL k", got)
+	}
+}
+
+func TestStorePropagationModes(t *testing.T) {
+	h := newHarness(t)
+	replica := h.attachReplica()
+	h.do("SADD", "a", "x", "y")
+	h.do("SADD", "b", "y", "z")
+	h.do("ZADD", "z", "1", "m", "2.5", "n")
+	for i := 0; i < 3; i++ {
+		propagated(t, replica)
+	}
+
+	// Verbatim: replicas run the command themselves
+	h.expect("(integer) 1", "SINTERSTORE", "dst", "a", "b")
+	if got := strings.Join(propagated(t, replica), " "); got != "SINTERSTORE dst a b" {
+		t.Fatalf("SINTERSTORE propagated as %s in verbatim mode", got)
+	}
+
+	// Effects: replicas get the result, after deleting what was there
+	h.expect(`"OK"`, "CONFIG", "SET", "store-propagation", "effects")
+	for _, c := range []struct {
+		command []string
+		want    []string // Propagated after DEL dst
+	}{
+		{[]string{"SINTERSTORE", "dst", "a", "b"}, []string{"SADD dst y"}},
+		{[]string{"ZRANGESTORE", "dst", "z", "0", "-1", "REV"}, []string{"ZADD dst 2.5 n 1 m"}},
+		{[]string{"ZUNIONSTORE", "dst", "1", "z", "WEIGHTS", "2"}, []string{"ZADD dst 2 m 5 n"}},
+		{[]string{"SDIFFSTORE", "dst", "a", "a"}, nil},
+	} {
+		h.do(c.command...)
+		if got := strings.Join(propagated(t, replica), " "); got != "DEL dst" {
+			t.Fatalf("%v propagated %s first in effects mode, want DEL dst", c.command, got)
+		}
+		for _, want := range c.want {
+			if got := strings.Join(propagated(t, replica), " "); got != want {
+				t.Fatalf("%v propagated %s in effects mode, want %s", c.command, got, want)
+			}
+		}
+	}
+	h.expect(`"OK"`, "SET", "next", "1")
+	if got := strings.Join(propagated(t, replica), " "); got != "SET next 1" {
+		t.Fatalf("an empty result propagated %s after DEL dst, want nothing", got)
+	}
+}
+
+// psync runs PSYNC as a new replica and returns the first line of the reply
+// and the client end, which reads what follows
+func (h *harness) psync(replID string, offset int) (string, *testutil.Client) {
+	h.t.Helper()
+	serverEnd, clientEnd := testutil.Pipe()
+	h.t.Cleanup(func() { serverEnd.Close() })
+	if _, err := h.registry.Execute(h.srv, serverEnd, OriginClient, "PSYNC", []string{replID, strconv.Itoa(offset)}); err != nil {
+		h.t.Fatal(err)
+	}
+	replica := testutil.NewClient(clientEnd)
+	reply, err := replica.ReadReply(time.Second)
+	if err != nil {
+		h.t.Fatalf("reading the PSYNC reply: %v", err)
+	}
+	return reply.Str, replica
+}
+
+func TestPartialResyncFromBacklog(t *testing.T) {
+	h := newHarness(t)
+	h.srv.Config.ReplBacklogSize = 16 * 1024
+	if line, _ := h.psync("?", -1); !strings.HasPrefix(line, "FULLRESYNC ") {
+		t.Fatalf("PSYNC ? -1 = %s, want FULLRESYNC", line)
+	}
+	replID, start := h.srv.ReplicationID, h.srv.ReplicationOffset
+
+	h.expect(`"OK"`, "SET", "a", "1")
+	h.expect(`"OK"`, "SET", "b", "2")
+	second := start + len(protocol.EncodeArray([]string{"SET", "a", "1"}))
+
+	// Resuming replays the stream from the offset asked for
+	line, replica := h.psync(replID, second)
+	if line != "CONTINUE "+replID {
+		t.Fatalf("PSYNC from inside the backlog = %s, want CONTINUE", line)
+	}
+	if got := propagated(t, replica); strings.Join(got, " ") != "SET b 2" {
+		t.Fatalf("replayed %v, want SET b 2", got)
+	}
+	h.expect(`"OK"`, "SET", "c", "3")
+	if got := propagated(t, replica); strings.Join(got, " ") != "SET c 3" {
+		t.Fatalf("streamed %v after the replay, want SET c 3", got)
+	}
+
+	for _, psync := range []struct {
+		replID string
+		offset int
+	}{
+		{"0123456789012345678901234567890123456789", second}, // another history
+		{replID, h.srv.ReplicationOffset + 1},                // ahead of the master
+	} {
+		if line, _ := h.psync(psync.replID, psync.offset); !strings.HasPrefix(line, "FULLRESYNC ") {
+			t.Errorf("PSYNC %s %d = %s, want FULLRESYNC", psync.replID, psync.offset, line)
+		}
+	}
+
+	// Once the backlog wrapped, the start of the stream is gone
+	h.expect(`"OK"`, "SET", "big", strings.Repeat("x", 20*1024))
+	if line, _ := h.psync(replID, second); !strings.HasPrefix(line, "FULLRESYNC ") {
+		t.Fatalf("PSYNC from before the backlog = %s, want FULLRESYNC", line)
+	}
+}
+
+// benchmarkPropagation runs SETs on a master with three replicas, whose
+// connections drop the stream, logging to a file at level with the
+// propagation of one command in every sample logged
+func benchmarkPropagation(b *testing.B, level logging.Level, sample int) {
+	h := newHarness(b)
+	for i := 0; i < 3; i++ {
+		serverEnd, _ := testutil.Pipe()
+		b.Cleanup(func() { serverEnd.Close() })
+		h.srv.AddReplica(discardConn{serverEnd})
+	}
+	h.srv.ReplLog.SetEvery(sample)
+
+	logFile, err := os.Create(filepath.Join(b.TempDir(), "redis.log"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer logFile.Close()
+	logging.SetOutput(logFile)
+	defer logging.SetOutput(os.Stdout)
+	logging.SetLevel(level)
+	defer logging.SetLevel(logging.LevelNone)
+
+	conn := discardConn{h.conn}
+	args := []string{"key", "value"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := h.registry.Execute(h.srv, conn, OriginClient, "SET", args); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// The defaults, loglevel error and repl-log-sample 0
+func BenchmarkPropagation(b *testing.B) {
+	benchmarkPropagation(b, logging.LevelError, 0)
+}
+
+// At loglevel info, with the propagation of one command in 100 logged
+// against every one, as it used to be
+func BenchmarkPropagationSampled(b *testing.B) {
+	benchmarkPropagation(b, logging.LevelInfo, 100)
+}
+
+func BenchmarkPropagationLogged(b *testing.B) {
+	benchmarkPropagation(b, logging.LevelInfo, 1)
+}
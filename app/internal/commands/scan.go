@@ -0,0 +1,117 @@
+package commands
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
+	"github.com/r0ld3x/redis-clone-go/app/internal/server"
+	"github.com/r0ld3x/redis-clone-go/app/pkg/database"
+	"github.com/r0ld3x/redis-clone-go/app/pkg/glob"
+)
+
+// defaultScanCount is the number of items visited per call when COUNT is not given
+const defaultScanCount = 10
+
+// scanOptions holds the optional arguments shared by the SCAN family
+type scanOptions struct {
+	cursor   uint64
+	pattern  string
+	count    int
+	typeName string
+}
+
+// parseScanOptions parses "cursor [MATCH pattern] [COUNT count] [TYPE type]".
+// TYPE is only accepted when allowType is set (plain SCAN).
+func parseScanOptions(args []string, allowType bool) (scanOptions, error) {
+	opts := scanOptions{count: defaultScanCount}
+
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return opts, errors.New("ERR invalid cursor")
+	}
+	opts.cursor = cursor
+
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return opts, errors.New("ERR syntax error")
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			opts.pattern = args[i+1]
+		case "COUNT":
+			count, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return opts, errors.New("ERR value is not an integer or out of range")
+			}
+			if count < 1 {
+				return opts, errors.New("ERR syntax error")
+			}
+			opts.count = count
+		case "TYPE":
+			if !allowType {
+				return opts, errors.New("ERR syntax error")
+			}
+			opts.typeName = strings.ToLower(args[i+1])
+		default:
+			return opts, errors.New("ERR syntax error")
+		}
+	}
+
+	return opts, nil
+}
+
+// matches reports whether item matches opts.pattern. Like Redis, the filter
+// runs after the page is selected, so a page may come back short or empty
+// while the cursor is still non-zero.
+func (opts scanOptions) matches(item string) bool {
+	return opts.pattern == "" || glob.Match(opts.pattern, item)
+}
+
+// writeScanReply writes the two-element [cursor, items] SCAN reply
+func writeScanReply(clientConn net.Conn, next uint64, items []string) {
+	protocol.WriteArray2(clientConn, []string{
+		protocol.FormatBulkString(strconv.FormatUint(next, 10)),
+		protocol.FormatArray(items),
+	})
+}
+
+// ScanHandler handles SCAN commands
+type ScanHandler struct {
+	logger *logging.Logger
+}
+
+func (h *ScanHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("SCAN")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 1 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'SCAN' command")
+		return nil
+	}
+
+	opts, err := parseScanOptions(args, true)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	page, next := database.ScanKeys(opts.cursor, opts.count)
+	keys := page[:0]
+	for _, key := range page {
+		if opts.matches(key) && (opts.typeName == "" || database.KeyType(key) == opts.typeName) {
+			keys = append(keys, key)
+		}
+	}
+
+	h.logger.Debug("Returning %d keys, next cursor %d", len(keys), next)
+	writeScanReply(clientConn, next, keys)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
@@ -0,0 +1,85 @@
+package commands
+
+import (
+	"strconv"
+	"testing"
+)
+
+// scanAll iterates a SCAN-family command to the end with COUNT 10, calling
+// between after each page, and returns how many times each item came back
+func (h *harness) scanAll(command []string, step int, between func(page int)) map[string]int {
+	h.t.Helper()
+	seen := map[string]int{}
+	cursor := "0"
+	for page := 0; ; page++ {
+		args := append(append([]string{}, command...), cursor, "COUNT", "10")
+		reply := h.do(args...)
+		if len(reply.Array) != 2 {
+			h.t.Fatalf("%v = %s, want [cursor, items]", args, reply)
+		}
+		items := reply.Array[1].Strings()
+		for i := 0; i < len(items); i += step {
+			seen[items[i]]++
+		}
+		if cursor = reply.Array[0].Str; cursor == "0" {
+			return seen
+		}
+		if page > 1000 {
+			h.t.Fatalf("%v did not finish", command)
+		}
+		between(page)
+	}
+}
+
+func TestScanWhileDeleting(t *testing.T) {
+	for _, scan := range []struct {
+		name    string
+		command []string
+		step    int                     // Items per element of the reply
+		add     func(h *harness, i int) // Creates element i
+		remove  func(h *harness, i int) // Deletes element i
+	}{
+		{"SCAN", []string{"SCAN"}, 1,
+			func(h *harness, i int) { h.do("SET", strconv.Itoa(i), "x") },
+			func(h *harness, i int) { h.do("DEL", strconv.Itoa(i)) }},
+		{"HSCAN", []string{"HSCAN", "h"}, 2,
+			func(h *harness, i int) { h.do("HSET", "h", strconv.Itoa(i), "x") },
+			func(h *harness, i int) { h.do("HDEL", "h", strconv.Itoa(i)) }},
+		{"SSCAN", []string{"SSCAN", "s"}, 1,
+			func(h *harness, i int) { h.do("SADD", "s", strconv.Itoa(i)) },
+			func(h *harness, i int) { h.do("SREM", "s", strconv.Itoa(i)) }},
+		{"ZSCAN", []string{"ZSCAN", "z"}, 2,
+			func(h *harness, i int) { h.do("ZADD", "z", "1", strconv.Itoa(i)) },
+			func(h *harness, i int) { h.do("ZREM", "z", strconv.Itoa(i)) }},
+	} {
+		t.Run(scan.name, func(t *testing.T) {
+			h := newHarness(t)
+			const n = 300
+			for i := 0; i < n; i++ {
+				scan.add(h, i)
+			}
+
+			// Between pages, delete the odd elements one by one, from either
+			// end, so the deletions land before and after the cursor alike
+			deleted := map[string]bool{}
+			seen := h.scanAll(scan.command, scan.step, func(page int) {
+				for _, i := range []int{2*page + 1, n - 1 - 2*page} {
+					if i >= 0 && i < n && !deleted[strconv.Itoa(i)] {
+						scan.remove(h, i)
+						deleted[strconv.Itoa(i)] = true
+					}
+				}
+			})
+
+			for i := 0; i < n; i++ {
+				item := strconv.Itoa(i)
+				if !deleted[item] && seen[item] != 1 {
+					t.Errorf("%s returned %s, there for the whole scan, %d times", scan.name, item, seen[item])
+				}
+				if seen[item] > 1 {
+					t.Errorf("%s returned %s %d times", scan.name, item, seen[item])
+				}
+			}
+		})
+	}
+}
@@ -1,82 +1,154 @@
 package commands
 
 import (
-	"fmt"
 	"net"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/r0ld3x/redis-clone-go/app/internal/config"
 	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
 	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
 
 	"github.com/r0ld3x/redis-clone-go/app/internal/server"
+	"github.com/r0ld3x/redis-clone-go/app/pkg/database"
+	"github.com/r0ld3x/redis-clone-go/app/pkg/glob"
 )
 
 // ConfigHandler handles CONFIG commands
 type ConfigHandler struct {
-	logger *logging.Logger
+	logger      *logging.Logger
+	subcommands subcommands
 }
 
 func (h *ConfigHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
 	if h.logger == nil {
 		h.logger = logging.NewLogger("CONFIG")
+		h.subcommands = subcommands{command: "CONFIG", table: map[string]subcommand{
+			"GET":     {arity: -2, usage: "<pattern> [pattern ...]", run: h.get},
+			"SET":     {arity: 3, usage: "<parameter> <value>", run: h.set},
+			"REWRITE": {arity: 1, run: h.rewrite},
+		}}
 	}
 
 	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
 
-	if len(args) < 2 {
-		h.logger.Error("Wrong number of arguments: %d", len(args))
-		protocol.WriteError(clientConn, "wrong number of arguments for 'CONFIG'")
-		return nil
+	if h.subcommands.dispatch(srv, clientConn, args) {
+		h.logger.Success("Command completed successfully")
 	}
+	return nil
+}
 
-	cmd, name := strings.ToUpper(args[0]), strings.ToUpper(args[1])
-	h.logger.Debug("Processing subcommand: %s %s", cmd, name)
-
-	if cmd == "GET" {
-		switch name {
-		case "DIR":
-			h.logger.Info("Returning directory: %s", srv.Config.Directory)
-			protocol.WriteArray(clientConn, []string{"dir", srv.Config.Directory})
-		case "DBFILENAME":
-			h.logger.Info("Returning DB filename: %s", srv.Config.DBFileName)
-			protocol.WriteArray(clientConn, []string{"dbfilename", srv.Config.DBFileName})
-		default:
-			h.logger.Error("Unsupported parameter: %s", name)
-			protocol.WriteError(clientConn, "unsupported CONFIG parameter")
+// get handles CONFIG GET. Each pattern is matched against every parameter;
+// a parameter matched by several patterns is returned once, as in Redis.
+func (h *ConfigHandler) get(srv *server.Server, clientConn net.Conn, patterns []string) {
+	var reply []string
+	for _, p := range configParams {
+		for _, pattern := range patterns {
+			if glob.Match(strings.ToLower(pattern), p.name) {
+				reply = append(reply, p.name, p.get(srv))
+				break
+			}
 		}
 	}
-	h.logger.Success("Command completed successfully")
-	return nil
+	protocol.WriteArray(clientConn, reply)
+}
+
+// set handles CONFIG SET parameter value
+func (h *ConfigHandler) set(srv *server.Server, clientConn net.Conn, args []string) {
+	name, value := args[0], args[1]
+	p, found := lookupConfigParam(name)
+	if !found {
+		h.logger.Error("Unsupported parameter: %s", name)
+		protocol.WriteError(clientConn, "ERR Unknown option or number of arguments for CONFIG SET - '"+strings.ToLower(name)+"'")
+		return
+	}
+	if p.set == nil {
+		protocol.WriteError(clientConn, "ERR CONFIG SET failed (possibly related to argument '"+p.name+"') - can't set immutable config")
+		return
+	}
+	if reason := p.set(srv, clientConn, value); reason != "" {
+		h.logger.Error("Failed to set %s: %s", p.name, reason)
+		protocol.WriteError(clientConn, "ERR CONFIG SET failed (possibly related to argument '"+p.name+"') - "+reason)
+		return
+	}
+	h.logger.Info("Config %s set to %s", p.name, value)
+	protocol.WriteSimpleString(clientConn, "OK")
 }
 
-// InfoHandler handles INFO commands
-type InfoHandler struct {
+// rewrite handles CONFIG REWRITE. The configuration only comes from the
+// command line, so there is no file to rewrite, as in Redis started
+// without one.
+func (h *ConfigHandler) rewrite(srv *server.Server, clientConn net.Conn, args []string) {
+	protocol.WriteError(clientConn, "ERR The server is running without a config file")
+}
+
+// parseYesNo parses a Redis boolean config value
+func parseYesNo(value string) (bool, bool) {
+	switch strings.ToLower(value) {
+	case "yes":
+		return true, true
+	case "no":
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+// formatYesNo renders a boolean as a Redis config value
+func formatYesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
+
+// ShutdownHandler handles SHUTDOWN [NOSAVE|SAVE] [NOW] [FORCE]. A master
+// waits up to shutdown-timeout for its replicas to acknowledge the final
+// offset unless NOW is given. The RDB file is saved when a dbfilename is
+// configured or SAVE is given, and not with NOSAVE; if that fails the
+// server keeps running, unless FORCE is given.
+type ShutdownHandler struct {
 	logger *logging.Logger
 }
 
-func (h *InfoHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+func (h *ShutdownHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
 	if h.logger == nil {
-		h.logger = logging.NewLogger("INFO")
+		h.logger = logging.NewLogger("SHUTDOWN")
 	}
 
 	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
 
-	info := "# Replication\n"
-	info += fmt.Sprintf("role:%s\r\n", srv.Config.Role)
-
-	if srv.Config.Role == "slave" {
-		info += fmt.Sprintf("master_host:%s\r\n", srv.Config.HostName)
-		info += fmt.Sprintf("master_port:%s\r\n", srv.Config.Port)
+	var save, noSave, now, force bool
+	for _, arg := range args {
+		switch strings.ToUpper(arg) {
+		case "SAVE":
+			save = true
+		case "NOSAVE":
+			noSave = true
+		case "NOW":
+			now = true
+		case "FORCE":
+			force = true
+		default:
+			protocol.WriteError(clientConn, "ERR syntax error")
+			return nil
+		}
+	}
+	if save && noSave {
+		protocol.WriteError(clientConn, "ERR syntax error")
+		return nil
 	}
-	info += fmt.Sprintf("master_replid:%s\r\n", srv.ReplicationID)
-	info += fmt.Sprintf("master_repl_offset:%d\r\n", srv.ReplicationOffset)
 
-	h.logger.Debug("Generated info response: %s", strings.ReplaceAll(info, "\r\n", "\\r\\n"))
-	h.logger.Network("OUT", "Sending bulk string response")
-	protocol.WriteBulkString(clientConn, info)
-	h.logger.Success("Command completed successfully")
+	wait := time.Duration(srv.Config.ShutdownTimeout) * time.Second
+	if now {
+		wait = 0
+	}
+	// Like Redis, a successful SHUTDOWN never replies: the connection just
+	// closes when the process exits
+	if err := srv.Shutdown(wait, save || !noSave && srv.Config.DBFileName != "", force); err != nil {
+		protocol.WriteError(clientConn, "ERR Errors trying to SHUTDOWN. Check logs.")
+	}
 	return nil
 }
 
@@ -105,6 +177,9 @@ func (h *ReplconfHandler) Handle(srv *server.Server, clientConn net.Conn, args [
 	switch subcommand {
 	case "LISTENING-PORT":
 		h.logger.Info("Handling LISTENING-PORT from %s", clientConn.RemoteAddr())
+		if len(args) >= 2 {
+			srv.SetReplicaListeningPort(clientConn, args[1])
+		}
 		h.logger.Network("OUT", "Sending OK response for LISTENING-PORT")
 		protocol.WriteSimpleString(clientConn, "OK")
 		h.logger.Success("LISTENING-PORT handled successfully")
@@ -181,23 +256,18 @@ func (h *PsyncHandler) Handle(srv *server.Server, clientConn net.Conn, args []st
 	offset := args[1]
 	h.logger.Debug("Replication ID: %s, Offset: %s", replID, offset)
 
-	if replID == "?" && offset == "-1" {
+	offsetNum, err := strconv.Atoi(offset)
+	if replID != "?" && err == nil && srv.ContinueReplication(clientConn, replID, offsetNum) {
+		h.logger.Success("Partial resync with replID=%s offset=%s", replID, offset)
+	} else {
+		// Either the replica has no history, or its replication ID has
+		// diverged from ours (see DEBUG CHANGE-REPL-ID), or the backlog no
+		// longer reaches back to its offset
 		h.logger.Info("Performing FULLRESYNC for %s", clientConn.RemoteAddr())
 
-		srv.AddReplica(clientConn)
-
 		if err := srv.SendFullResync(clientConn); err != nil {
 			return err
 		}
-	} else {
-		// Partial resync
-		h.logger.Info("Attempting partial resync with replID=%s offset=%s", replID, offset)
-		h.logger.Network("OUT", "Sending CONTINUE response")
-		protocol.WriteSimpleString(clientConn, "CONTINUE")
-
-		// Ensure replica is in the list if not already
-		srv.AddReplica(clientConn)
-		h.logger.Success("Partial resync setup completed")
 	}
 
 	h.logger.Info("==================== PSYNC COMMAND END ====================")
@@ -242,84 +312,102 @@ func (h *WaitHandler) Handle(srv *server.Server, clientConn net.Conn, args []str
 	// Read current master replication offset
 	srv.Mutex.RLock()
 	masterOffset := srv.ReplicationOffset
-	replicaCount := len(srv.ReplicaConn)
 	srv.Mutex.RUnlock()
+	replicas := srv.Replicas()
 
 	h.logger.Info("Need %d acks within %d ms. Master offset=%d", count, timeout, masterOffset)
-	h.logger.Info("Connected replicas: %d", replicaCount)
+	h.logger.Info("Connected replicas: %d", len(replicas))
 
-	if replicaCount == 0 {
+	if len(replicas) == 0 {
 		h.logger.Info("No replicas — returning 0 immediately")
 		protocol.WriteInteger(clientConn, 0)
 		return nil
 	}
 
-	for _, conn := range srv.ReplicaConn {
+	// The GETACK must follow every command counted in masterOffset
+	srv.FlushReplicas()
+	for _, conn := range replicas {
 		h.logger.Debug("Sending REPLCONF GETACK * to %v", conn.RemoteAddr())
-		conn.Write([]byte(protocol.EncodeArray([]string{"REPLCONF", "GETACK", "*"})))
-	}
-
-	// acks := 0
-	// srv.Mutex.RLock()
-	// for _, conn := range srv.ReplicaConn {
-	// 	ro := srv.ReplicaOffsets[conn]
-	// 	h.logger.Debug("Replica %v offset=%d (master=%d)", conn.RemoteAddr(), ro, masterOffset)
-	// 	if ro >= masterOffset {
-	// 		acks++
-	// 	}
-	// }
-	// srv.Mutex.RUnlock()
-	acks := 0
-	for _, conn := range srv.ReplicaConn {
-		fmt.Println("s.replicaOffsets[conn] ", srv.ReplicaOffsets[conn])
-		if srv.ReplicaOffsets[conn] <= 0 {
-			acks++
+		srv.WriteGetAck(conn)
+	}
+
+	// acked holds the replicas counted so far. Only those still connected
+	// count towards the reply, so a replica removed while we wait (CLIENT
+	// KILL, write error, disconnect) is taken back out of the total.
+	acked := make(map[net.Conn]bool)
+	for _, conn := range replicas {
+		if srv.GetReplicaOffset(conn) <= 0 {
+			acked[conn] = true
 		}
 	}
+	countAcks := func() int {
+		n := 0
+		for conn := range acked {
+			if srv.IsReplica(conn) {
+				n++
+			}
+		}
+		return n
+	}
+	acks := countAcks()
 
 	h.logger.Info("Initial ACKs: %d", acks)
 
 	timer := time.After(time.Duration(timeout) * time.Millisecond)
 
+	blocked := newBlockedClient(srv, clientConn)
+	defer blocked.Stop()
+
 outer:
 	for acks < count {
 		select {
-		case <-srv.AckReceived:
-			acks++
-			h.logger.Info("New ACK received — total=%d / %d", acks, count)
+		case conn := <-srv.AckReceived:
+			if srv.IsReplica(conn) {
+				acked[conn] = true
+			}
+			acks = countAcks()
+			h.logger.Info("Replica %s acked or was removed — total=%d / %d", conn.RemoteAddr(), acks, count)
 		case <-timer:
 			h.logger.Info("WAIT timeout — total=%d / %d", acks, count)
 			break outer
+		case <-blocked.Done():
+			h.logger.Info("Client disconnected during WAIT")
+			return nil
 		}
 	}
-
-	// 	deadline := time.After(time.Duration(timeout) * time.Millisecond)
-
-	// outer:
-	// 	for acks < count {
-	// 		select {
-	// 		case <-srv.AckReceived:
-	// 			srv.Mutex.RLock()
-	// 			newAcks := 0
-	// 			for _, conn := range srv.ReplicaConn {
-	// 				ro := srv.ReplicaOffsets[conn]
-	// 				if ro >= masterOffset {
-	// 					newAcks++
-	// 				}
-	// 			}
-	// 			acks = newAcks
-	// 			srv.Mutex.RUnlock()
-
-	// 			h.logger.Info("New ACK received — total=%d / %d", acks, count)
-
-	// 		case <-deadline:
-	// 			h.logger.Info("WAIT timeout — total=%d / %d", acks, count)
-	// 			break outer
-	// 		}
-	// 	}
+	acks = countAcks()
 
 	h.logger.Info("Returning %d acks", acks)
 	h.logger.Info("========== WAIT COMMAND END ==========")
 	protocol.WriteInteger(clientConn, acks)
 	return nil
 }
+
+// replicateStore propagates a *STORE command that wrote dst. In the
+// verbatim store-propagation mode the command itself is sent; in effects
+// mode replicas get DEL dst followed by add, which recreates the stored
+// result (add is nil when the result was empty).
+func replicateStore(srv *server.Server, clientConn net.Conn, command []string, dst string, add []string) {
+	if srv.Config.StorePropagation != config.PropagateEffects {
+		srv.ReplicateCommand(clientConn, command)
+		return
+	}
+	srv.ReplicateCommand(clientConn, []string{"DEL", dst})
+	if len(add) > 0 {
+		srv.ReplicateCommand(clientConn, add)
+	}
+}
+
+// zaddCommand builds the ZADD that recreates members at key, or nil if
+// there are none
+func zaddCommand(key string, members []database.ZMember) []string {
+	if len(members) == 0 {
+		return nil
+	}
+	command := make([]string, 0, 2+2*len(members))
+	command = append(command, "ZADD", key)
+	for _, m := range members {
+		command = append(command, database.FormatScore(m.Score), m.Member)
+	}
+	return command
+}
@@ -0,0 +1,445 @@
+package commands
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
+	"github.com/r0ld3x/redis-clone-go/app/internal/server"
+	"github.com/r0ld3x/redis-clone-go/app/pkg/database"
+)
+
+// SAddHandler handles SADD commands
+type SAddHandler struct {
+	logger *logging.Logger
+}
+
+func (h *SAddHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("SADD")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'SADD' command")
+		return nil
+	}
+
+	added, err := database.SetAdd(args[0], args[1:])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	if added > 0 {
+		srv.ReplicateCommand(clientConn, append([]string{"SADD"}, args...))
+	}
+
+	h.logger.Debug("Added %d new members to %s", added, args[0])
+	protocol.WriteInteger(clientConn, added)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// SRemHandler handles SREM commands
+type SRemHandler struct {
+	logger *logging.Logger
+}
+
+func (h *SRemHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("SREM")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'SREM' command")
+		return nil
+	}
+
+	removed, err := database.SetRemove(args[0], args[1:])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	if removed > 0 {
+		srv.ReplicateCommand(clientConn, append([]string{"SREM"}, args...))
+	}
+
+	protocol.WriteInteger(clientConn, removed)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// SMembersHandler handles SMEMBERS commands
+type SMembersHandler struct {
+	logger *logging.Logger
+}
+
+func (h *SMembersHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("SMEMBERS")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 1 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'SMEMBERS' command")
+		return nil
+	}
+
+	members, err := database.SetMembers(args[0])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	protocol.WriteArray(clientConn, members)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// SIsMemberHandler handles SISMEMBER commands
+type SIsMemberHandler struct {
+	logger *logging.Logger
+}
+
+func (h *SIsMemberHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("SISMEMBER")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'SISMEMBER' command")
+		return nil
+	}
+
+	found, err := database.SetIsMember(args[0], args[1])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	if found {
+		protocol.WriteInteger(clientConn, 1)
+	} else {
+		protocol.WriteInteger(clientConn, 0)
+	}
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// SCardHandler handles SCARD commands
+type SCardHandler struct {
+	logger *logging.Logger
+}
+
+func (h *SCardHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("SCARD")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 1 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'SCARD' command")
+		return nil
+	}
+
+	count, err := database.SetCard(args[0])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	protocol.WriteInteger(clientConn, count)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// SetOpHandler handles SINTER, SUNION, SDIFF and their STORE variants. The
+// STORE forms write the result to args[0] and are the only ones replicated.
+type SetOpHandler struct {
+	name   string
+	op     database.SetOp
+	store  bool
+	logger *logging.Logger
+}
+
+func (h *SetOpHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger(h.name)
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	minArgs := 1
+	if h.store {
+		minArgs = 2
+	}
+	if len(args) < minArgs {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for '"+h.name+"' command")
+		return nil
+	}
+
+	keys := args
+	if h.store {
+		keys = args[1:]
+	}
+
+	members, err := database.SetCombine(h.op, keys)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	if !h.store {
+		protocol.WriteArray(clientConn, members)
+		h.logger.Success("Command completed successfully")
+		return nil
+	}
+
+	count := database.SetStore(args[0], members)
+	var add []string
+	if len(members) > 0 {
+		add = append([]string{"SADD", args[0]}, members...)
+	}
+	replicateStore(srv, clientConn, append([]string{h.name}, args...), args[0], add)
+
+	h.logger.Debug("Stored %d members in %s", count, args[0])
+	protocol.WriteInteger(clientConn, count)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// SMIsMemberHandler handles SMISMEMBER commands
+type SMIsMemberHandler struct {
+	logger *logging.Logger
+}
+
+func (h *SMIsMemberHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("SMISMEMBER")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'SMISMEMBER' command")
+		return nil
+	}
+
+	found, err := database.SetMultiIsMember(args[0], args[1:])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	results := make([]int, len(found))
+	for i, ok := range found {
+		if ok {
+			results[i] = 1
+		}
+	}
+	writeIntegerArray(clientConn, results)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// SRandMemberHandler handles SRANDMEMBER commands
+type SRandMemberHandler struct {
+	logger *logging.Logger
+}
+
+func (h *SRandMemberHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("SRANDMEMBER")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 1 || len(args) > 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'SRANDMEMBER' command")
+		return nil
+	}
+
+	// Without a count a single member is returned as a bulk string.
+	if len(args) == 1 {
+		members, err := database.SetRandomMembers(args[0], 1)
+		if err != nil {
+			protocol.WriteError(clientConn, err.Error())
+			return nil
+		}
+		if len(members) == 0 {
+			clientConn.Write([]byte("$-1\r\n"))
+			return nil
+		}
+		protocol.WriteBulkString(clientConn, members[0])
+		return nil
+	}
+
+	count, err := strconv.Atoi(args[1])
+	if err != nil {
+		protocol.WriteError(clientConn, "ERR value is not an integer or out of range")
+		return nil
+	}
+
+	members, err := database.SetRandomMembers(args[0], count)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	protocol.WriteArray(clientConn, members)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// SPopHandler handles SPOP commands. The popped members are replicated as an
+// SREM so replicas remove exactly the same members.
+type SPopHandler struct {
+	logger *logging.Logger
+}
+
+func (h *SPopHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("SPOP")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 1 || len(args) > 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'SPOP' command")
+		return nil
+	}
+
+	count := 1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			protocol.WriteError(clientConn, "ERR value is out of range, must be positive")
+			return nil
+		}
+		count = n
+	}
+
+	popped, err := database.SetPop(args[0], count)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	if len(popped) > 0 {
+		srv.ReplicateCommand(clientConn, append([]string{"SREM", args[0]}, popped...))
+	}
+
+	if len(args) == 2 {
+		protocol.WriteArray(clientConn, popped)
+	} else if len(popped) == 0 {
+		clientConn.Write([]byte("$-1\r\n"))
+	} else {
+		protocol.WriteBulkString(clientConn, popped[0])
+	}
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// SInterCardHandler handles SINTERCARD commands
+type SInterCardHandler struct {
+	logger *logging.Logger
+}
+
+func (h *SInterCardHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("SINTERCARD")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'SINTERCARD' command")
+		return nil
+	}
+
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil || numKeys <= 0 {
+		protocol.WriteError(clientConn, "ERR numkeys should be greater than 0")
+		return nil
+	}
+	if numKeys > len(args)-1 {
+		protocol.WriteError(clientConn, "ERR Number of keys can't be greater than number of args")
+		return nil
+	}
+	keys := args[1 : 1+numKeys]
+
+	limit := 0
+	rest := args[1+numKeys:]
+	if len(rest) > 0 {
+		if len(rest) != 2 || strings.ToUpper(rest[0]) != "LIMIT" {
+			protocol.WriteError(clientConn, "ERR syntax error")
+			return nil
+		}
+		limit, err = strconv.Atoi(rest[1])
+		if err != nil || limit < 0 {
+			protocol.WriteError(clientConn, "ERR LIMIT can't be negative")
+			return nil
+		}
+	}
+
+	count, err := database.SetInterCard(keys, limit)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	protocol.WriteInteger(clientConn, count)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// SScanHandler handles SSCAN commands
+type SScanHandler struct {
+	logger *logging.Logger
+}
+
+func (h *SScanHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("SSCAN")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'SSCAN' command")
+		return nil
+	}
+
+	opts, err := parseScanOptions(args[1:], false)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	members, next, err := database.SetScan(args[0], opts.cursor, opts.count)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	page := members[:0]
+	for _, member := range members {
+		if opts.matches(member) {
+			page = append(page, member)
+		}
+	}
+	writeScanReply(clientConn, next, page)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
@@ -0,0 +1,72 @@
+package commands
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
+	"github.com/r0ld3x/redis-clone-go/app/internal/server"
+)
+
+// defaultSlowlogCount is how many entries SLOWLOG GET returns without a count
+const defaultSlowlogCount = 10
+
+// SlowlogHandler handles SLOWLOG GET [count] / LEN / RESET
+type SlowlogHandler struct {
+	logger      *logging.Logger
+	subcommands subcommands
+}
+
+func (h *SlowlogHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("SLOWLOG")
+		h.subcommands = subcommands{command: "SLOWLOG", table: map[string]subcommand{
+			"GET": {arity: -1, usage: "[count]", run: slowlogGet},
+			"LEN": {arity: 1, run: func(srv *server.Server, clientConn net.Conn, _ []string) {
+				protocol.WriteInteger(clientConn, srv.SlowLog.Len())
+			}},
+			"RESET": {arity: 1, run: func(srv *server.Server, clientConn net.Conn, _ []string) {
+				srv.SlowLog.Reset()
+				protocol.WriteSimpleString(clientConn, "OK")
+			}},
+		}}
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if h.subcommands.dispatch(srv, clientConn, args) {
+		h.logger.Success("Command completed successfully")
+	}
+	return nil
+}
+
+// slowlogGet handles SLOWLOG GET [count], count -1 for every entry
+func slowlogGet(srv *server.Server, clientConn net.Conn, args []string) {
+	if len(args) > 1 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'SLOWLOG|GET' command")
+		return
+	}
+	count := defaultSlowlogCount
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < -1 {
+			protocol.WriteError(clientConn, "ERR count should be greater than or equal to -1")
+			return
+		}
+		count = n
+	}
+
+	entries := srv.SlowLog.Get(count)
+	elements := make([]string, len(entries))
+	for i, entry := range entries {
+		elements[i] = "*6\r\n" +
+			protocol.FormatInteger(int(entry.ID)) +
+			protocol.FormatInteger(int(entry.Time.Unix())) +
+			protocol.FormatInteger(int(entry.Duration.Microseconds())) +
+			protocol.FormatArray(entry.Args) +
+			protocol.FormatBulkString(entry.Addr) +
+			protocol.FormatBulkString(entry.Name)
+	}
+	protocol.WriteArray2(clientConn, elements)
+}
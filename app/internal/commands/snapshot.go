@@ -0,0 +1,249 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
+	"github.com/r0ld3x/redis-clone-go/app/internal/server"
+	"github.com/r0ld3x/redis-clone-go/app/pkg/database"
+	"github.com/r0ld3x/redis-clone-go/app/pkg/glob"
+)
+
+// SnapshotHandler handles SNAPSHOT BEGIN and SNAPSHOT END, an extension of
+// this server, not a Redis command. BEGIN gives the connection a view of
+// the keyspace as it is at that moment, and until END its reads are served
+// from the view, so several of them see the same data however the keyspace
+// changes meanwhile. Writes are refused in between, see viewReads.
+type SnapshotHandler struct {
+	logger *logging.Logger
+	subs   subcommands
+}
+
+func (h *SnapshotHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("SNAPSHOT")
+		h.subs = subcommands{command: "SNAPSHOT", table: map[string]subcommand{
+			"BEGIN": {arity: 1, run: h.begin},
+			"END":   {arity: 1, run: h.end},
+		}}
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+	h.subs.dispatch(srv, clientConn, args)
+	return nil
+}
+
+func (h *SnapshotHandler) begin(srv *server.Server, clientConn net.Conn, args []string) {
+	if !srv.BeginView(clientConn) {
+		protocol.WriteError(clientConn, "ERR SNAPSHOT BEGIN calls can not be nested")
+		return
+	}
+	protocol.WriteSimpleString(clientConn, "OK")
+}
+
+func (h *SnapshotHandler) end(srv *server.Server, clientConn net.Conn, args []string) {
+	if !srv.EndView(clientConn) {
+		protocol.WriteError(clientConn, "ERR SNAPSHOT END without SNAPSHOT BEGIN")
+		return
+	}
+	protocol.WriteSimpleString(clientConn, "OK")
+}
+
+// viewRead serves a read command from the view of a connection in a
+// snapshot. Its number of arguments has been checked.
+type viewRead func(view *database.View, clientConn net.Conn, args []string)
+
+// viewReads are the reads a connection in a snapshot may run, served from
+// its view with the replies of their handlers. Apart from them it may only
+// run the commands in viewCommands; anything else, writes included, is
+// refused until SNAPSHOT END.
+var viewReads = map[Command]viewRead{
+	KeysCommand: func(view *database.View, clientConn net.Conn, args []string) {
+		keys := []string{}
+		for _, key := range view.Keys() {
+			if glob.Match(args[0], key) {
+				keys = append(keys, key)
+			}
+		}
+		protocol.WriteArray(clientConn, keys)
+	},
+	TypeCommand: func(view *database.View, clientConn net.Conn, args []string) {
+		protocol.WriteSimpleString(clientConn, view.Type(args[0]))
+	},
+	GetCommand: func(view *database.View, clientConn net.Conn, args []string) {
+		val, found := view.Get(args[0])
+		writeOptionalBulk(clientConn, val, found, nil)
+	},
+
+	LLenCommand: func(view *database.View, clientConn net.Conn, args []string) {
+		n, err := view.ListLen(args[0])
+		writeCount(clientConn, n, err)
+	},
+	LRangeCommand: func(view *database.View, clientConn net.Conn, args []string) {
+		start, err1 := strconv.Atoi(args[1])
+		end, err2 := strconv.Atoi(args[2])
+		if err1 != nil || err2 != nil {
+			protocol.WriteError(clientConn, "ERR value is not an integer or out of range")
+			return
+		}
+		elements, err := view.ListRange(args[0], start, end)
+		writeList(clientConn, elements, err)
+	},
+	LIndexCommand: func(view *database.View, clientConn net.Conn, args []string) {
+		index, err := strconv.Atoi(args[1])
+		if err != nil {
+			protocol.WriteError(clientConn, "ERR value is not an integer or out of range")
+			return
+		}
+		element, found, err := view.ListIndex(args[0], index)
+		writeOptionalBulk(clientConn, element, found, err)
+	},
+
+	HGetCommand: func(view *database.View, clientConn net.Conn, args []string) {
+		val, found, err := view.HashGet(args[0], args[1])
+		writeOptionalBulk(clientConn, val, found, err)
+	},
+	HMGetCommand: func(view *database.View, clientConn net.Conn, args []string) {
+		values, found, err := view.HashMultiGet(args[0], args[1:])
+		if err != nil {
+			protocol.WriteError(clientConn, err.Error())
+			return
+		}
+		elements := make([]string, len(values))
+		for i, val := range values {
+			if !found[i] {
+				elements[i] = "$-1\r\n"
+				continue
+			}
+			elements[i] = protocol.FormatBulkString(val)
+		}
+		protocol.WriteArray2(clientConn, elements)
+	},
+	HGetAllCommand: func(view *database.View, clientConn net.Conn, args []string) {
+		pairs, err := view.HashGetAll(args[0])
+		writeList(clientConn, pairs, err)
+	},
+	HLenCommand: func(view *database.View, clientConn net.Conn, args []string) {
+		n, err := view.HashLen(args[0])
+		writeCount(clientConn, n, err)
+	},
+
+	SMembersCommand: func(view *database.View, clientConn net.Conn, args []string) {
+		members, err := view.SetMembers(args[0])
+		writeList(clientConn, members, err)
+	},
+	SIsMemberCommand: func(view *database.View, clientConn net.Conn, args []string) {
+		member, err := view.SetIsMember(args[0], args[1])
+		n := 0
+		if member {
+			n = 1
+		}
+		writeCount(clientConn, n, err)
+	},
+	SCardCommand: func(view *database.View, clientConn net.Conn, args []string) {
+		n, err := view.SetCard(args[0])
+		writeCount(clientConn, n, err)
+	},
+
+	ZCardCommand: func(view *database.View, clientConn net.Conn, args []string) {
+		n, err := view.ZSetCard(args[0])
+		writeCount(clientConn, n, err)
+	},
+	ZScoreCommand: func(view *database.View, clientConn net.Conn, args []string) {
+		score, found, err := view.ZSetScore(args[0], args[1])
+		writeOptionalBulk(clientConn, database.FormatScore(score), found, err)
+	},
+	ZRangeCommand: func(view *database.View, clientConn net.Conn, args []string) {
+		q, err := parseZRangeQuery(args, zrangeByRank, false, true)
+		if err == nil && q.kind != zrangeByRank {
+			err = errors.New("ERR BYSCORE and BYLEX are not supported inside a snapshot")
+		}
+		if err != nil {
+			protocol.WriteError(clientConn, err.Error())
+			return
+		}
+		start, err1 := strconv.Atoi(q.min)
+		stop, err2 := strconv.Atoi(q.max)
+		if err1 != nil || err2 != nil {
+			protocol.WriteError(clientConn, "ERR value is not an integer or out of range")
+			return
+		}
+		members, err := view.ZSetRangeByRank(q.key, start, stop, q.rev)
+		if err != nil {
+			protocol.WriteError(clientConn, err.Error())
+			return
+		}
+		writeZMembers(clientConn, members, q.withScores)
+	},
+}
+
+// viewCommands are the other commands a connection in a snapshot may run,
+// through their usual handlers
+var viewCommands = map[Command]bool{
+	SnapshotCommand: true,
+	PingCommand:     true,
+	EchoCommand:     true,
+	QuitCommand:     true,
+	ResetCommand:    true,
+}
+
+// executeInView runs cmd for a client whose reads are served from view:
+// from the view when it is one of viewReads, through handler when it is
+// one of viewCommands, and not at all otherwise
+func executeInView(srv *server.Server, conn net.Conn, view *database.View, handler Handler, cmd string, args []string) error {
+	if viewCommands[Command(cmd)] {
+		return run(srv, conn, OriginClient, handler, cmd, args)
+	}
+	read, ok := viewReads[Command(cmd)]
+	if !ok {
+		protocol.WriteError(conn, fmt.Sprintf("ERR Can't execute '%s' inside a snapshot: only the reads it serves are allowed until SNAPSHOT END",
+			strings.ToLower(cmd)))
+		return nil
+	}
+	if err := arityError(Command(cmd), args); err != "" {
+		protocol.WriteError(conn, err)
+		return nil
+	}
+
+	start := time.Now()
+	read(view, conn, args)
+	Account(srv, conn, OriginClient, cmd, args, time.Since(start))
+	return nil
+}
+
+// writeOptionalBulk replies with val, a null bulk string when it was not
+// found, or err
+func writeOptionalBulk(clientConn net.Conn, val string, found bool, err error) {
+	switch {
+	case err != nil:
+		protocol.WriteError(clientConn, err.Error())
+	case found:
+		protocol.WriteBulkString(clientConn, val)
+	default:
+		clientConn.Write([]byte("$-1\r\n"))
+	}
+}
+
+// writeCount replies with n, or err
+func writeCount(clientConn net.Conn, n int, err error) {
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return
+	}
+	protocol.WriteInteger(clientConn, n)
+}
+
+// writeList replies with elements, or err
+func writeList(clientConn net.Conn, elements []string, err error) {
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return
+	}
+	protocol.WriteArray(clientConn, elements)
+}
@@ -0,0 +1,109 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/r0ld3x/redis-clone-go/app/pkg/cluster"
+	"github.com/r0ld3x/redis-clone-go/app/pkg/database"
+)
+
+func TestSnapshotReadsArePointInTime(t *testing.T) {
+	h := newHarness(t)
+	h.do("SET", "s", "old")
+	h.do("RPUSH", "l", "a", "b")
+	h.do("HSET", "h", "f", "1")
+	h.do("SADD", "set", "m")
+	h.do("ZADD", "z", "1", "a", "2", "b")
+	h.do("SET", "gone", "v")
+
+	h.expect(`"OK"`, "SNAPSHOT", "BEGIN")
+	if stats := database.CollectSnapshotStats(); stats.Running != 1 {
+		t.Fatalf("%d snapshots running during SNAPSHOT BEGIN, want 1", stats.Running)
+	}
+
+	// Another client changes every key
+	other := h.connect()
+	other.expect(`"OK"`, "SET", "s", "new")
+	other.do("RPUSH", "l", "c")
+	other.do("LPOP", "l")
+	other.do("HSET", "h", "f", "2", "g", "3")
+	other.do("SREM", "set", "m")
+	other.do("ZADD", "z", "0", "c")
+	other.do("DEL", "gone")
+	other.do("SET", "created", "v")
+
+	h.expect(`"old"`, "GET", "s")
+	h.expect(`["a", "b"]`, "LRANGE", "l", "0", "-1")
+	h.expect(`(integer) 2`, "LLEN", "l")
+	h.expect(`"b"`, "LINDEX", "l", "-1")
+	h.expect(`["f", "1"]`, "HGETALL", "h")
+	h.expect(`["1", (nil)]`, "HMGET", "h", "f", "g")
+	h.expect(`(integer) 1`, "HLEN", "h")
+	h.expect(`["m"]`, "SMEMBERS", "set")
+	h.expect(`(integer) 1`, "SISMEMBER", "set", "m")
+	h.expect(`["b", "2", "a", "1"]`, "ZRANGE", "z", "0", "-1", "REV", "WITHSCORES")
+	h.expect(`(nil)`, "ZSCORE", "z", "c")
+	h.expect(`"string"`, "TYPE", "gone")
+	h.expect(`["gone", "h", "l", "s", "set", "z"]`, "KEYS", "*")
+	h.expect(`(error) WRONGTYPE Operation against a key holding the wrong kind of value`, "LLEN", "s")
+
+	// The other client sees its writes
+	other.expect(`"new"`, "GET", "s")
+	other.expect(`["b", "c"]`, "LRANGE", "l", "0", "-1")
+
+	h.expect(`"OK"`, "SNAPSHOT", "END")
+	h.expect(`"new"`, "GET", "s")
+	h.expect(`["c", "a", "b"]`, "ZRANGE", "z", "0", "-1")
+	h.expect(`(integer) 0`, "SCARD", "set")
+	h.expect(`"none"`, "TYPE", "gone")
+	if stats := database.CollectSnapshotStats(); stats.Running != 0 {
+		t.Fatalf("%d snapshots running after SNAPSHOT END, want 0", stats.Running)
+	}
+}
+
+func TestSnapshotRefusesOtherCommands(t *testing.T) {
+	h := newHarness(t)
+	h.expect(`(error) ERR SNAPSHOT END without SNAPSHOT BEGIN`, "SNAPSHOT", "END")
+	h.expect(`"OK"`, "SNAPSHOT", "BEGIN")
+	h.expect(`(error) ERR SNAPSHOT BEGIN calls can not be nested`, "SNAPSHOT", "BEGIN")
+
+	for _, args := range [][]string{
+		{"SET", "k", "v"},
+		{"DEL", "k"},
+		{"MULTI"},
+		{"SUBSCRIBE", "c"},
+		{"ZRANGEBYSCORE", "z", "0", "1"},
+	} {
+		want := "ERR Can't execute '" + strings.ToLower(args[0]) + "' inside a snapshot"
+		if reply := h.do(args...); !strings.HasPrefix(reply.Str, want) {
+			t.Errorf("%v inside a snapshot = %s, want %s...", args, reply, want)
+		}
+	}
+	h.expect(`(error) ERR BYSCORE and BYLEX are not supported inside a snapshot`, "ZRANGE", "z", "0", "1", "BYSCORE")
+	h.expect(`(error) ERR wrong number of arguments for 'HGET' command`, "HGET", "h")
+	h.expect(`"PONG"`, "PING")
+
+	// RESET ends the snapshot
+	h.expect(`"RESET"`, "RESET")
+	h.expect(`"OK"`, "SET", "k", "v")
+	h.expect(`"v"`, "GET", "k")
+	if stats := database.CollectSnapshotStats(); stats.Running != 0 {
+		t.Fatalf("%d snapshots running after RESET, want 0", stats.Running)
+	}
+}
+
+func TestSnapshotInClusterMode(t *testing.T) {
+	h := newHarness(t)
+	// Another node serves the slots "BEGIN" and "END" would hash to if they
+	// were keys, which they are not
+	h.inCluster(fmt.Sprintf("self localhost:6379 myself %d\nother localhost:6380 - %d %d\n",
+		cluster.KeySlot("k"), cluster.KeySlot("BEGIN"), cluster.KeySlot("END")))
+	h.do("SET", "k", "v")
+
+	h.expect(`"OK"`, "SNAPSHOT", "BEGIN")
+	h.expect(`"v"`, "GET", "k")
+	h.expect(fmt.Sprintf("(error) MOVED %d localhost:6380", cluster.KeySlot("BEGIN")), "GET", "BEGIN")
+	h.expect(`"OK"`, "SNAPSHOT", "END")
+}
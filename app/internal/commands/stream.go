@@ -30,24 +30,89 @@ func (h *XAddHandler) Handle(srv *server.Server, clientConn net.Conn, args []str
 	}
 
 	key := args[0]
-	id := args[1]
+	rest := args[1:]
+	mkstream := true
+	// NOMKSTREAM is the only option; no ID can be mistaken for it
+	for len(rest) > 0 && strings.EqualFold(rest[0], "NOMKSTREAM") {
+		mkstream = false
+		rest = rest[1:]
+	}
+	if len(rest) < 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'XADD'")
+		return nil
+	}
+	id := rest[0]
 	if id == "0-0" {
 		protocol.WriteError(clientConn, "ERR The ID specified in XADD must be greater than 0-0")
 		return nil
 	}
-	fields := args[2:]
+	fields := rest[1:]
 
-	entryID, err := database.StreamAdd(key, id, fields)
+	entryID, err := database.StreamAdd(key, id, fields, database.StreamLimits{
+		MaxEntryFields: srv.Config.StreamMaxEntryFields,
+		MaxEntrySize:   srv.Config.StreamMaxEntrySize,
+		MaxStreamBytes: srv.Config.StreamMaxMemory,
+	}, mkstream)
 	if err != nil {
 		protocol.WriteError(clientConn, err.Error())
 		return nil
 	}
+	if entryID == "" {
+		// NOMKSTREAM on a missing key
+		clientConn.Write([]byte("$-1\r\n"))
+		return nil
+	}
+
+	// Replicas must store the ID we assigned, not generate their own.
+	srv.ReplicateCommand(clientConn, append([]string{"XADD", key, entryID}, fields...))
 
 	protocol.WriteBulkString(clientConn, entryID)
 	return nil
 }
 
-// XRangeHandler handles XRANGE commands
+// XDelHandler handles XDEL key id [id ...]
+type XDelHandler struct {
+	logger *logging.Logger
+}
+
+func (h *XDelHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("XDEL")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'XDEL' command")
+		return nil
+	}
+
+	ids := make([]string, len(args)-1)
+	for i, id := range args[1:] {
+		normalized, err := database.NormalizeStreamID(id)
+		if err != nil {
+			protocol.WriteError(clientConn, err.Error())
+			return nil
+		}
+		ids[i] = normalized
+	}
+
+	deleted, err := database.StreamDelete(args[0], ids)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	if deleted > 0 {
+		srv.ReplicateCommand(clientConn, append([]string{"XDEL"}, args...))
+	}
+
+	protocol.WriteInteger(clientConn, deleted)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// XRangeHandler handles XRANGE key start end [COUNT n]
 type XRangeHandler struct {
 	logger *logging.Logger
 }
@@ -62,47 +127,80 @@ func (h *XRangeHandler) Handle(srv *server.Server, clientConn net.Conn, args []s
 		return nil
 	}
 
-	key := args[0]
-	start := args[1]
-	end := args[2]
-
-	entries, err := database.StreamRange(key, start, end)
-	if err != nil {
-		protocol.WriteError(clientConn, err.Error())
+	entries, errMsg := streamRange(args[0], args[1], args[2], args[3:], false)
+	if errMsg != "" {
+		protocol.WriteError(clientConn, errMsg)
 		return nil
 	}
 
-	respData := h.formatStreamEntries(entries)
-	clientConn.Write([]byte(respData))
-	fmt.Printf("respData: %q\n", respData)
+	clientConn.Write([]byte(formatStreamEntries(entries)))
 	return nil
 }
 
-func (h *XRangeHandler) formatStreamEntries(entries []database.StreamEntry) string {
-	// Start with array header
-	response := fmt.Sprintf("*%d\r\n", len(entries))
+// XRevRangeHandler handles XREVRANGE key end start [COUNT n], XRANGE from
+// the newest entry backwards
+type XRevRangeHandler struct {
+	logger *logging.Logger
+}
 
-	for _, entry := range entries {
-		// Each entry is an array with 2 elements: [ID, [field1, value1, field2, value2, ...]]
-		response += "*2\r\n"
-
-		// 1. Entry ID as bulk string
-		response += fmt.Sprintf("$%d\r\n%s\r\n", len(entry.ID), entry.ID)
-
-		// 2. Fields array
-		fieldCount := len(entry.Fields) * 2 // Each field has name and value
-		response += fmt.Sprintf("*%d\r\n", fieldCount)
-
-		// Add each field name and value as bulk strings
-		for fieldName, fieldValue := range entry.Fields {
-			// Field name
-			response += fmt.Sprintf("$%d\r\n%s\r\n", len(fieldName), fieldName)
-			// Field value
-			response += fmt.Sprintf("$%d\r\n%s\r\n", len(fieldValue), fieldValue)
+func (h *XRevRangeHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("XREVRANGE")
+	}
+
+	if len(args) < 3 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'XREVRANGE'")
+		return nil
+	}
+
+	entries, errMsg := streamRange(args[0], args[2], args[1], args[3:], true)
+	if errMsg != "" {
+		protocol.WriteError(clientConn, errMsg)
+		return nil
+	}
+
+	clientConn.Write([]byte(formatStreamEntries(entries)))
+	return nil
+}
+
+// streamRange parses the bounds and options of XRANGE or XREVRANGE and
+// reads the range, returning the error to reply with when they are invalid
+func streamRange(key, start, end string, options []string, reverse bool) ([]database.StreamEntry, string) {
+	count := -1
+	if len(options) > 0 {
+		if len(options) != 2 || strings.ToUpper(options[0]) != "COUNT" {
+			return nil, "ERR syntax error"
 		}
+		n, err := strconv.Atoi(options[1])
+		if err != nil {
+			return nil, "ERR value is not an integer or out of range"
+		}
+		count = max(n, 0)
+	}
+
+	startBound, err1 := database.ParseStreamBound(start, false)
+	endBound, err2 := database.ParseStreamBound(end, true)
+	if err1 != nil || err2 != nil {
+		return nil, database.ErrInvalidStreamID.Error()
 	}
 
-	return response
+	entries, err := database.StreamRange(key, startBound, endBound, count, reverse)
+	if err != nil {
+		return nil, err.Error()
+	}
+	return entries, ""
+}
+
+// formatStreamEntries encodes entries as [[id, [field, value, ...]], ...];
+// entries XREADGROUP re-reads after they were deleted, which have no
+// fields, are [id, nil]
+func formatStreamEntries(entries []database.StreamEntry) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(entries))
+	for _, entry := range entries {
+		b.WriteString(formatEntry(entry))
+	}
+	return b.String()
 }
 
 // XReadHandler handles XREAD commands
@@ -159,7 +257,14 @@ func (h *XReadHandler) Handle(srv *server.Server, clientConn net.Conn, args []st
 	for i, id := range startIDs {
 		if id == "$" {
 			startIDs[i] = database.GetStreamLastID(streamKeys[i])
+			continue
+		}
+		normalized, err := database.NormalizeStreamID(id)
+		if err != nil {
+			protocol.WriteError(clientConn, err.Error())
+			return nil
 		}
+		startIDs[i] = normalized
 	}
 
 	// Perform the read operation
@@ -185,38 +290,40 @@ func (h *XReadHandler) performRead(srv *server.Server, clientConn net.Conn, stre
 	h.writeXreadResponse(clientConn, results, streamKeys)
 }
 
+// performBlockingRead waits for entries past startIDs, woken through
+// database.WatchKeys when one of the streams is added to rather than by
+// polling. A blockTimeout of 0 waits forever.
 func (h *XReadHandler) performBlockingRead(srv *server.Server, clientConn net.Conn, streamKeys []string, startIDs []string, blockTimeout int64) {
-	startTime := time.Now()
+	// Register before the first read so an XADD in between still wakes us.
+	ready, cancel := database.WatchKeys(streamKeys)
+	defer cancel()
+
+	var expired <-chan time.Time
+	if blockTimeout > 0 {
+		timer := time.NewTimer(time.Duration(blockTimeout) * time.Millisecond)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	blocked := newBlockedClient(srv, clientConn)
+	defer blocked.Stop()
 
 	for {
-		// Try to read
 		results, err := database.StreamReadMultiple(streamKeys, startIDs)
 		if err != nil {
 			protocol.WriteError(clientConn, err.Error())
 			return
 		}
-
-		// If we have results, return them
 		if len(results) > 0 {
 			h.writeXreadResponse(clientConn, results, streamKeys)
 			return
 		}
 
-		// Check timeout
-		if blockTimeout > 0 {
-			elapsed := time.Since(startTime).Milliseconds()
-			if elapsed >= blockTimeout {
-				// Timeout reached, return empty result
-				clientConn.Write([]byte("$-1\r\n")) // null response
-				return
-			}
-		}
-
-		// Sleep briefly before checking again
-		time.Sleep(10 * time.Millisecond)
-
-		// Check if connection is still alive
-		if srv.IsConnectionClosed(clientConn) {
+		switch blocked.wait(ready, expired) {
+		case waitExpired:
+			clientConn.Write([]byte("$-1\r\n")) // null response
+			return
+		case waitGone:
 			return
 		}
 	}
@@ -248,36 +355,9 @@ func (h *XReadHandler) writeXreadResponse(clientConn net.Conn, results map[strin
 			response += fmt.Sprintf("$%d\r\n%s\r\n", len(key), key)
 
 			// Entries array
-			response += h.formatStreamEntries(entries)
+			response += formatStreamEntries(entries)
 		}
 	}
 
 	clientConn.Write([]byte(response))
 }
-
-func (h *XReadHandler) formatStreamEntries(entries []database.StreamEntry) string {
-	// Start with array header
-	response := fmt.Sprintf("*%d\r\n", len(entries))
-
-	for _, entry := range entries {
-		// Each entry is an array with 2 elements: [ID, [field1, value1, field2, value2, ...]]
-		response += "*2\r\n"
-
-		// 1. Entry ID as bulk string
-		response += fmt.Sprintf("$%d\r\n%s\r\n", len(entry.ID), entry.ID)
-
-		// 2. Fields array
-		fieldCount := len(entry.Fields) * 2 // Each field has name and value
-		response += fmt.Sprintf("*%d\r\n", fieldCount)
-
-		// Add each field name and value as bulk strings
-		for fieldName, fieldValue := range entry.Fields {
-			// Field name
-			response += fmt.Sprintf("$%d\r\n%s\r\n", len(fieldName), fieldName)
-			// Field value
-			response += fmt.Sprintf("$%d\r\n%s\r\n", len(fieldValue), fieldValue)
-		}
-	}
-
-	return response
-}
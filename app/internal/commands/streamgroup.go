@@ -0,0 +1,871 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
+	"github.com/r0ld3x/redis-clone-go/app/internal/server"
+
+	"github.com/r0ld3x/redis-clone-go/app/pkg/database"
+)
+
+// XGroupHandler handles XGROUP CREATE/DESTROY/CREATECONSUMER/DELCONSUMER/SETID
+type XGroupHandler struct {
+	logger      *logging.Logger
+	subcommands subcommands
+}
+
+func (h *XGroupHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("XGROUP")
+		h.subcommands = subcommands{command: "XGROUP", table: map[string]subcommand{
+			"CREATE":         {arity: -4, usage: "<key> <group> <id|$> [MKSTREAM]", run: xgroupCreate},
+			"SETID":          {arity: 4, usage: "<key> <group> <id|$>", run: xgroupSetID},
+			"DESTROY":        {arity: 3, usage: "<key> <group>", run: xgroupDestroy},
+			"CREATECONSUMER": {arity: 4, usage: "<key> <group> <consumer>", run: xgroupCreateConsumer},
+			"DELCONSUMER":    {arity: 4, usage: "<key> <group> <consumer>", run: xgroupDelConsumer},
+		}}
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if h.subcommands.dispatch(srv, clientConn, args) {
+		h.logger.Success("Command completed successfully")
+	}
+	return nil
+}
+
+// xgroupCreate handles XGROUP CREATE key group id|$ [MKSTREAM]
+func xgroupCreate(srv *server.Server, clientConn net.Conn, args []string) {
+	key, group := args[0], args[1]
+	mkstream := false
+	if len(args) > 3 {
+		if len(args) > 4 || strings.ToUpper(args[3]) != "MKSTREAM" {
+			protocol.WriteError(clientConn, "ERR syntax error")
+			return
+		}
+		mkstream = true
+	}
+	id, err := groupStartID(args[2])
+	if err == nil {
+		id, err = database.GroupCreate(key, group, id, mkstream)
+	}
+	if err != nil {
+		writeGroupError(clientConn, err, key, group)
+		return
+	}
+
+	command := []string{"XGROUP", "CREATE", key, group, id}
+	if mkstream {
+		command = append(command, "MKSTREAM")
+	}
+	srv.ReplicateCommand(clientConn, command)
+	protocol.WriteSimpleString(clientConn, "OK")
+}
+
+// xgroupSetID handles XGROUP SETID key group id|$
+func xgroupSetID(srv *server.Server, clientConn net.Conn, args []string) {
+	key, group := args[0], args[1]
+	id, err := groupStartID(args[2])
+	if err == nil {
+		id, err = database.GroupSetID(key, group, id)
+	}
+	if err != nil {
+		writeGroupError(clientConn, err, key, group)
+		return
+	}
+
+	// Replicas must start from the ID "$" meant here
+	srv.ReplicateCommand(clientConn, []string{"XGROUP", "SETID", key, group, id})
+	protocol.WriteSimpleString(clientConn, "OK")
+}
+
+// groupStartID normalizes the ID a group starts after, leaving "$" to be
+// resolved against the stream
+func groupStartID(id string) (string, error) {
+	if id == "$" {
+		return id, nil
+	}
+	return database.NormalizeStreamID(id)
+}
+
+// xgroupDestroy handles XGROUP DESTROY key group
+func xgroupDestroy(srv *server.Server, clientConn net.Conn, args []string) {
+	destroyed, err := database.GroupDestroy(args[0], args[1])
+	if err != nil {
+		writeGroupError(clientConn, err, args[0], args[1])
+		return
+	}
+	if destroyed {
+		srv.ReplicateCommand(clientConn, append([]string{"XGROUP", "DESTROY"}, args...))
+	}
+	protocol.WriteInteger(clientConn, boolToInt(destroyed))
+}
+
+// xgroupCreateConsumer handles XGROUP CREATECONSUMER key group consumer
+func xgroupCreateConsumer(srv *server.Server, clientConn net.Conn, args []string) {
+	created, err := database.GroupCreateConsumer(args[0], args[1], args[2])
+	if err != nil {
+		writeGroupError(clientConn, err, args[0], args[1])
+		return
+	}
+	if created {
+		srv.ReplicateCommand(clientConn, append([]string{"XGROUP", "CREATECONSUMER"}, args...))
+	}
+	protocol.WriteInteger(clientConn, boolToInt(created))
+}
+
+// xgroupDelConsumer handles XGROUP DELCONSUMER key group consumer
+func xgroupDelConsumer(srv *server.Server, clientConn net.Conn, args []string) {
+	pending, err := database.GroupDelConsumer(args[0], args[1], args[2])
+	if err != nil {
+		writeGroupError(clientConn, err, args[0], args[1])
+		return
+	}
+	srv.ReplicateCommand(clientConn, append([]string{"XGROUP", "DELCONSUMER"}, args...))
+	protocol.WriteInteger(clientConn, pending)
+}
+
+// writeGroupError replies with err, in the NOGROUP form XGROUP and XINFO
+// use when the group is missing
+func writeGroupError(clientConn net.Conn, err error, key, group string) {
+	if errors.Is(err, database.ErrNoGroup) {
+		protocol.WriteError(clientConn, fmt.Sprintf("NOGROUP No such consumer group '%s' for key name '%s'", group, key))
+		return
+	}
+	protocol.WriteError(clientConn, err.Error())
+}
+
+// boolToInt returns 1 for true and 0 for false, for integer replies
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// XReadGroupHandler handles
+// XREADGROUP GROUP group consumer [COUNT n] [BLOCK ms] [NOACK] STREAMS key... id...
+type XReadGroupHandler struct {
+	logger *logging.Logger
+}
+
+// xreadGroupRequest is a parsed XREADGROUP
+type xreadGroupRequest struct {
+	group, consumer string
+	count           int
+	block           int64 // -1 when not blocking, 0 to wait forever
+	noack           bool
+	keys, ids       []string // ids are ">" or normalized
+}
+
+func (h *XReadGroupHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("XREADGROUP")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	req, errMsg := parseXReadGroup(args)
+	if errMsg != "" {
+		protocol.WriteError(clientConn, errMsg)
+		return nil
+	}
+
+	// Only new entries can be waited for: history is answered at once
+	waitable := true
+	for _, id := range req.ids {
+		if id != ">" {
+			waitable = false
+		}
+	}
+
+	var ready <-chan struct{}
+	var expired <-chan time.Time
+	var blocked *blockedClient
+	if req.block >= 0 && waitable {
+		// Register before the first read so an XADD in between still wakes us
+		var cancel func()
+		ready, cancel = database.WatchKeys(req.keys)
+		defer cancel()
+		if req.block > 0 {
+			timer := time.NewTimer(time.Duration(req.block) * time.Millisecond)
+			defer timer.Stop()
+			expired = timer.C
+		}
+		blocked = newBlockedClient(srv, clientConn)
+		defer blocked.Stop()
+	}
+
+	for {
+		end := beginBlockedWrite(srv, clientConn, req.block >= 0)
+		reply, served, err := h.read(srv, clientConn, req)
+		end()
+		if err != nil {
+			protocol.WriteError(clientConn, err.Error())
+			return nil
+		}
+		if served > 0 {
+			clientConn.Write([]byte(fmt.Sprintf("*%d\r\n", served) + reply))
+			break
+		}
+		if blocked == nil {
+			clientConn.Write([]byte("$-1\r\n")) // null response
+			break
+		}
+
+		switch blocked.wait(ready, expired) {
+		case waitExpired:
+			clientConn.Write([]byte("$-1\r\n")) // null response
+			h.logger.Success("Command completed successfully")
+			return nil
+		case waitGone:
+			return nil
+		}
+	}
+
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// read serves every stream of req once. It returns the encoded [key,
+// entries] pairs and how many there are: streams with new entries, and
+// every stream read by history, even when nothing is pending there.
+func (h *XReadGroupHandler) read(srv *server.Server, clientConn net.Conn, req xreadGroupRequest) (string, int, error) {
+	var b strings.Builder
+	served := 0
+	for i, key := range req.keys {
+		var entries []database.StreamEntry
+		var err error
+		if req.ids[i] == ">" {
+			var created bool
+			entries, created, err = database.GroupReadNew(key, req.group, req.consumer, req.count, req.noack)
+			if err == nil {
+				replicateGroupRead(srv, clientConn, req, key, len(entries), created)
+			}
+		} else {
+			entries, err = database.GroupReadPending(key, req.group, req.consumer, req.ids[i], req.count)
+		}
+		if errors.Is(err, database.ErrNoGroup) {
+			return "", 0, fmt.Errorf("NOGROUP No such key '%s' or consumer group '%s' in XREADGROUP with GROUP option", key, req.group)
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		if req.ids[i] == ">" && len(entries) == 0 {
+			continue
+		}
+
+		b.WriteString("*2\r\n")
+		b.WriteString(protocol.FormatBulkString(key))
+		b.WriteString(formatStreamEntries(entries))
+		served++
+	}
+	return b.String(), served, nil
+}
+
+// replicateGroupRead propagates what a ">" read changed. Replicas hold the
+// same stream and group, so the same read delivers them the same entries.
+func replicateGroupRead(srv *server.Server, clientConn net.Conn, req xreadGroupRequest, key string, delivered int, created bool) {
+	if delivered == 0 {
+		if created {
+			srv.ReplicateCommand(clientConn, []string{"XGROUP", "CREATECONSUMER", key, req.group, req.consumer})
+		}
+		return
+	}
+	command := []string{"XREADGROUP", "GROUP", req.group, req.consumer, "COUNT", strconv.Itoa(delivered)}
+	if req.noack {
+		command = append(command, "NOACK")
+	}
+	srv.ReplicateCommand(clientConn, append(command, "STREAMS", key, ">"))
+}
+
+// formatEntry encodes one entry as [id, [field, value, ...]], or [id, nil]
+// when it has no fields
+func formatEntry(entry database.StreamEntry) string {
+	var b strings.Builder
+	b.WriteString("*2\r\n")
+	b.WriteString(protocol.FormatBulkString(entry.ID))
+	if entry.Fields == nil {
+		b.WriteString("*-1\r\n")
+		return b.String()
+	}
+	fmt.Fprintf(&b, "*%d\r\n", len(entry.Fields))
+	for _, f := range entry.Fields {
+		b.WriteString(protocol.FormatBulkString(f))
+	}
+	return b.String()
+}
+
+// parseXReadGroup parses the arguments of XREADGROUP, returning the error
+// to reply with when they are invalid
+func parseXReadGroup(args []string) (xreadGroupRequest, string) {
+	req := xreadGroupRequest{block: -1}
+	if len(args) < 6 || strings.ToUpper(args[0]) != "GROUP" {
+		if len(args) < 6 {
+			return req, "ERR wrong number of arguments for 'XREADGROUP' command"
+		}
+		return req, "ERR syntax error"
+	}
+	req.group, req.consumer = args[1], args[2]
+
+	i := 3
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		if option == "STREAMS" {
+			break
+		}
+		switch option {
+		case "COUNT", "BLOCK":
+			if i+1 >= len(args) {
+				return req, "ERR syntax error"
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				if option == "BLOCK" {
+					return req, "ERR timeout is not an integer or out of range"
+				}
+				return req, "ERR value is not an integer or out of range"
+			}
+			if option == "COUNT" {
+				req.count = int(max(n, 0))
+			} else {
+				if n < 0 {
+					return req, "ERR timeout is negative"
+				}
+				req.block = n
+			}
+			i++
+		case "NOACK":
+			req.noack = true
+		default:
+			return req, "ERR syntax error"
+		}
+	}
+	if i == len(args) {
+		return req, "ERR syntax error"
+	}
+
+	streams := args[i+1:]
+	if len(streams) == 0 || len(streams)%2 != 0 {
+		return req, "ERR Unbalanced 'xreadgroup' list of streams: for each stream key an ID or '>' must be specified."
+	}
+	req.keys, req.ids = streams[:len(streams)/2], make([]string, len(streams)/2)
+	for j, id := range streams[len(streams)/2:] {
+		switch id {
+		case ">":
+			req.ids[j] = id
+		case "$":
+			return req, "ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set."
+		default:
+			normalized, err := database.NormalizeStreamID(id)
+			if err != nil {
+				return req, err.Error()
+			}
+			req.ids[j] = normalized
+		}
+	}
+	return req, ""
+}
+
+// XAckHandler handles XACK key group id...
+type XAckHandler struct {
+	logger *logging.Logger
+}
+
+func (h *XAckHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("XACK")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 3 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'XACK' command")
+		return nil
+	}
+
+	ids := make([]string, len(args)-2)
+	for i, id := range args[2:] {
+		normalized, err := database.NormalizeStreamID(id)
+		if err != nil {
+			protocol.WriteError(clientConn, err.Error())
+			return nil
+		}
+		ids[i] = normalized
+	}
+
+	acked, err := database.GroupAck(args[0], args[1], ids)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+	if acked > 0 {
+		srv.ReplicateCommand(clientConn, append([]string{"XACK"}, args...))
+	}
+
+	protocol.WriteInteger(clientConn, acked)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// XPendingHandler handles XPENDING key group, a summary of the group's
+// pending entries, and XPENDING key group [IDLE ms] start end count
+// [consumer], the entries themselves
+type XPendingHandler struct {
+	logger *logging.Logger
+}
+
+func (h *XPendingHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("XPENDING")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'XPENDING' command")
+		return nil
+	}
+	key, group := args[0], args[1]
+	noGroup := fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s'", key, group)
+
+	if len(args) == 2 {
+		summary, err := database.GroupPendingSummary(key, group)
+		if errors.Is(err, database.ErrNoGroup) {
+			protocol.WriteError(clientConn, noGroup)
+			return nil
+		}
+		if err != nil {
+			protocol.WriteError(clientConn, err.Error())
+			return nil
+		}
+		h.writeSummary(clientConn, summary)
+		h.logger.Success("Command completed successfully")
+		return nil
+	}
+
+	rest := args[2:]
+	var minIdle time.Duration
+	if strings.ToUpper(rest[0]) == "IDLE" {
+		if len(rest) < 2 {
+			protocol.WriteError(clientConn, "ERR syntax error")
+			return nil
+		}
+		ms, err := strconv.ParseInt(rest[1], 10, 64)
+		if err != nil {
+			protocol.WriteError(clientConn, "ERR value is not an integer or out of range")
+			return nil
+		}
+		minIdle = time.Duration(max(ms, 0)) * time.Millisecond
+		rest = rest[2:]
+	}
+	if len(rest) != 3 && len(rest) != 4 {
+		protocol.WriteError(clientConn, "ERR syntax error")
+		return nil
+	}
+
+	start, err1 := database.ParseStreamBound(rest[0], false)
+	end, err2 := database.ParseStreamBound(rest[1], true)
+	if err1 != nil || err2 != nil {
+		protocol.WriteError(clientConn, database.ErrInvalidStreamID.Error())
+		return nil
+	}
+	count, err := strconv.Atoi(rest[2])
+	if err != nil {
+		protocol.WriteError(clientConn, "ERR value is not an integer or out of range")
+		return nil
+	}
+	consumer := ""
+	if len(rest) == 4 {
+		consumer = rest[3]
+	}
+
+	var pending []database.PendingInfo
+	if count > 0 {
+		pending, err = database.GroupPendingRange(key, group, start, end, count, consumer, minIdle)
+		if errors.Is(err, database.ErrNoGroup) {
+			protocol.WriteError(clientConn, noGroup)
+			return nil
+		}
+		if err != nil {
+			protocol.WriteError(clientConn, err.Error())
+			return nil
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(pending))
+	for _, p := range pending {
+		b.WriteString("*4\r\n")
+		b.WriteString(protocol.FormatBulkString(p.ID))
+		b.WriteString(protocol.FormatBulkString(p.Consumer))
+		b.WriteString(protocol.FormatInteger(int(p.Idle.Milliseconds())))
+		b.WriteString(protocol.FormatInteger(p.DeliveryCount))
+	}
+	clientConn.Write([]byte(b.String()))
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// writeSummary replies [count, smallest ID, greatest ID, [[consumer,
+// count]...]], with nils in place of the rest when nothing is pending
+func (h *XPendingHandler) writeSummary(clientConn net.Conn, summary database.PendingSummary) {
+	if summary.Count == 0 {
+		clientConn.Write([]byte("*4\r\n:0\r\n$-1\r\n$-1\r\n*-1\r\n"))
+		return
+	}
+
+	consumers := make([]string, 0, len(summary.ConsumerCounts))
+	for name := range summary.ConsumerCounts {
+		consumers = append(consumers, name)
+	}
+	sort.Strings(consumers)
+
+	var b strings.Builder
+	b.WriteString("*4\r\n")
+	b.WriteString(protocol.FormatInteger(summary.Count))
+	b.WriteString(protocol.FormatBulkString(summary.First))
+	b.WriteString(protocol.FormatBulkString(summary.Last))
+	fmt.Fprintf(&b, "*%d\r\n", len(consumers))
+	for _, name := range consumers {
+		b.WriteString("*2\r\n")
+		b.WriteString(protocol.FormatBulkString(name))
+		// Redis gives the count as a bulk string here
+		b.WriteString(protocol.FormatBulkString(strconv.Itoa(summary.ConsumerCounts[name])))
+	}
+	clientConn.Write([]byte(b.String()))
+}
+
+// XClaimHandler handles
+// XCLAIM key group consumer min-idle-time id... [IDLE ms] [TIME unix-ms]
+// [RETRYCOUNT n] [FORCE] [JUSTID] [LASTID id]
+type XClaimHandler struct {
+	logger *logging.Logger
+}
+
+func (h *XClaimHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("XCLAIM")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 5 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'XCLAIM' command")
+		return nil
+	}
+	key, group, consumer := args[0], args[1], args[2]
+
+	minIdle, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		protocol.WriteError(clientConn, "ERR Invalid min-idle-time argument for XCLAIM")
+		return nil
+	}
+	opts := database.ClaimOptions{MinIdle: time.Duration(max(minIdle, 0)) * time.Millisecond, RetryCount: -1}
+
+	// IDs run up to the first argument that isn't one
+	var ids []string
+	i := 4
+	for ; i < len(args); i++ {
+		id, err := database.NormalizeStreamID(args[i])
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		protocol.WriteError(clientConn, database.ErrInvalidStreamID.Error())
+		return nil
+	}
+
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		switch option {
+		case "FORCE":
+			opts.Force = true
+			continue
+		case "JUSTID":
+			opts.JustID = true
+			continue
+		case "IDLE", "TIME", "RETRYCOUNT", "LASTID":
+		default:
+			protocol.WriteError(clientConn, fmt.Sprintf("ERR Unrecognized XCLAIM option '%s'", args[i]))
+			return nil
+		}
+		if i+1 >= len(args) {
+			protocol.WriteError(clientConn, "ERR syntax error")
+			return nil
+		}
+		i++
+		if option == "LASTID" {
+			if opts.LastID, err = database.NormalizeStreamID(args[i]); err != nil {
+				protocol.WriteError(clientConn, err.Error())
+				return nil
+			}
+			continue
+		}
+		n, err := strconv.ParseInt(args[i], 10, 64)
+		if err != nil || (option == "RETRYCOUNT" && (n < 0 || n > math.MaxInt32)) {
+			protocol.WriteError(clientConn, fmt.Sprintf("ERR Invalid %s option argument for XCLAIM", option))
+			return nil
+		}
+		switch option {
+		case "IDLE":
+			opts.DeliveryTime = time.Now().Add(-time.Duration(max(n, 0)) * time.Millisecond)
+		case "TIME":
+			opts.DeliveryTime = time.UnixMilli(n)
+		case "RETRYCOUNT":
+			opts.RetryCount = int(n)
+		}
+	}
+
+	result, err := database.GroupClaim(key, group, consumer, ids, opts)
+	if errors.Is(err, database.ErrNoGroup) {
+		protocol.WriteError(clientConn, fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s'", key, group))
+		return nil
+	}
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+	replicateClaim(srv, clientConn, key, group, consumer, result)
+
+	clientConn.Write([]byte(formatClaimed(result.Claimed, opts.JustID)))
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// XAutoClaimHandler handles
+// XAUTOCLAIM key group consumer min-idle-time start [COUNT n] [JUSTID]
+type XAutoClaimHandler struct {
+	logger *logging.Logger
+}
+
+func (h *XAutoClaimHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("XAUTOCLAIM")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 5 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'XAUTOCLAIM' command")
+		return nil
+	}
+	key, group, consumer := args[0], args[1], args[2]
+
+	minIdle, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		protocol.WriteError(clientConn, "ERR Invalid min-idle-time argument for XAUTOCLAIM")
+		return nil
+	}
+	start := "0-0"
+	if args[4] != "-" {
+		if start, err = database.NormalizeStreamID(args[4]); err != nil {
+			protocol.WriteError(clientConn, err.Error())
+			return nil
+		}
+	}
+
+	count, justID := 100, false
+	for i := 5; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "COUNT":
+			if i+1 >= len(args) {
+				protocol.WriteError(clientConn, "ERR syntax error")
+				return nil
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil {
+				protocol.WriteError(clientConn, "ERR value is not an integer or out of range")
+				return nil
+			}
+			// Redis caps COUNT so that ten times it can't overflow
+			if n < 1 || n > math.MaxInt64/10 {
+				protocol.WriteError(clientConn, "ERR COUNT must be > 0")
+				return nil
+			}
+			count = n
+		case "JUSTID":
+			justID = true
+		default:
+			protocol.WriteError(clientConn, "ERR syntax error")
+			return nil
+		}
+	}
+
+	result, next, err := database.GroupAutoClaim(key, group, consumer,
+		time.Duration(max(minIdle, 0))*time.Millisecond, start, count, justID)
+	if errors.Is(err, database.ErrNoGroup) {
+		protocol.WriteError(clientConn, fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s'", key, group))
+		return nil
+	}
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+	replicateClaim(srv, clientConn, key, group, consumer, result)
+
+	var b strings.Builder
+	b.WriteString("*3\r\n")
+	b.WriteString(protocol.FormatBulkString(next))
+	b.WriteString(formatClaimed(result.Claimed, justID))
+	fmt.Fprintf(&b, "*%d\r\n", len(result.Deleted))
+	for _, id := range result.Deleted {
+		b.WriteString(protocol.FormatBulkString(id))
+	}
+	clientConn.Write([]byte(b.String()))
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// replicateClaim propagates what a claim changed. Idle times depend on the
+// clock, so each claimed entry goes as an XCLAIM that forces the resulting
+// delivery time and count on the replica.
+func replicateClaim(srv *server.Server, clientConn net.Conn, key, group, consumer string, result database.ClaimResult) {
+	for _, claimed := range result.Claimed {
+		srv.ReplicateCommand(clientConn, []string{
+			"XCLAIM", key, group, consumer, "0", claimed.Entry.ID,
+			"TIME", strconv.FormatInt(claimed.DeliveryTime.UnixMilli(), 10),
+			"RETRYCOUNT", strconv.Itoa(claimed.DeliveryCount),
+			"FORCE", "JUSTID",
+		})
+	}
+	if len(result.Deleted) > 0 {
+		srv.ReplicateCommand(clientConn, append([]string{"XACK", key, group}, result.Deleted...))
+	}
+	if result.LastID != "" {
+		srv.ReplicateCommand(clientConn, []string{"XGROUP", "SETID", key, group, result.LastID})
+	}
+}
+
+// formatClaimed encodes claimed entries as XRANGE does, or just their IDs
+func formatClaimed(claimed []database.ClaimedEntry, justID bool) string {
+	if !justID {
+		entries := make([]database.StreamEntry, len(claimed))
+		for i, c := range claimed {
+			entries[i] = c.Entry
+		}
+		return formatStreamEntries(entries)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(claimed))
+	for _, c := range claimed {
+		b.WriteString(protocol.FormatBulkString(c.Entry.ID))
+	}
+	return b.String()
+}
+
+// XInfoHandler handles XINFO STREAM key, XINFO GROUPS key and
+// XINFO CONSUMERS key group
+type XInfoHandler struct {
+	logger      *logging.Logger
+	subcommands subcommands
+}
+
+func (h *XInfoHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("XINFO")
+		h.subcommands = subcommands{command: "XINFO", table: map[string]subcommand{
+			"STREAM":    {arity: 2, usage: "<key>", run: xinfoStream},
+			"GROUPS":    {arity: 2, usage: "<key>", run: xinfoGroups},
+			"CONSUMERS": {arity: 3, usage: "<key> <group>", run: xinfoConsumers},
+		}}
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if h.subcommands.dispatch(srv, clientConn, args) {
+		h.logger.Success("Command completed successfully")
+	}
+	return nil
+}
+
+// xinfoStream handles XINFO STREAM key
+func xinfoStream(srv *server.Server, clientConn net.Conn, args []string) {
+	info, err := database.InspectStream(args[0])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("*10\r\n")
+	b.WriteString(protocol.FormatBulkString("length"))
+	b.WriteString(protocol.FormatInteger(info.Length))
+	b.WriteString(protocol.FormatBulkString("last-generated-id"))
+	b.WriteString(protocol.FormatBulkString(info.LastID))
+	b.WriteString(protocol.FormatBulkString("groups"))
+	b.WriteString(protocol.FormatInteger(info.Groups))
+	b.WriteString(protocol.FormatBulkString("first-entry"))
+	b.WriteString(formatInfoEntry(info.First))
+	b.WriteString(protocol.FormatBulkString("last-entry"))
+	b.WriteString(formatInfoEntry(info.Last))
+	clientConn.Write([]byte(b.String()))
+}
+
+// xinfoGroups handles XINFO GROUPS key
+func xinfoGroups(srv *server.Server, clientConn net.Conn, args []string) {
+	groups, err := database.InspectGroups(args[0])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(groups))
+	for _, g := range groups {
+		b.WriteString("*10\r\n")
+		b.WriteString(protocol.FormatBulkString("name"))
+		b.WriteString(protocol.FormatBulkString(g.Name))
+		b.WriteString(protocol.FormatBulkString("consumers"))
+		b.WriteString(protocol.FormatInteger(g.Consumers))
+		b.WriteString(protocol.FormatBulkString("pending"))
+		b.WriteString(protocol.FormatInteger(g.Pending))
+		b.WriteString(protocol.FormatBulkString("last-delivered-id"))
+		b.WriteString(protocol.FormatBulkString(g.LastDeliveredID))
+		b.WriteString(protocol.FormatBulkString("lag"))
+		b.WriteString(protocol.FormatInteger(g.Lag))
+	}
+	clientConn.Write([]byte(b.String()))
+}
+
+// xinfoConsumers handles XINFO CONSUMERS key group
+func xinfoConsumers(srv *server.Server, clientConn net.Conn, args []string) {
+	consumers, err := database.InspectConsumers(args[0], args[1])
+	if err != nil {
+		writeGroupError(clientConn, err, args[0], args[1])
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(consumers))
+	for _, c := range consumers {
+		b.WriteString("*6\r\n")
+		b.WriteString(protocol.FormatBulkString("name"))
+		b.WriteString(protocol.FormatBulkString(c.Name))
+		b.WriteString(protocol.FormatBulkString("pending"))
+		b.WriteString(protocol.FormatInteger(c.Pending))
+		b.WriteString(protocol.FormatBulkString("idle"))
+		b.WriteString(protocol.FormatInteger(int(c.Idle.Milliseconds())))
+	}
+	clientConn.Write([]byte(b.String()))
+}
+
+// formatInfoEntry encodes an entry as XRANGE does, nil for none
+func formatInfoEntry(entry *database.StreamEntry) string {
+	if entry == nil {
+		return "$-1\r\n"
+	}
+	return formatEntry(*entry)
+}
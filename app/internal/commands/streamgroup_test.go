@@ -0,0 +1,141 @@
+package commands
+
+import (
+	"slices"
+	"testing"
+)
+
+// newGroup returns a harness with stream s holding entries 1-1, 2-1 and
+// 3-1 and a group g that has delivered none of them
+func newGroup(t *testing.T) *harness {
+	t.Helper()
+	h := newHarness(t)
+	h.expect(`"OK"`, "XGROUP", "CREATE", "s", "g", "$", "MKSTREAM")
+	h.do("XADD", "s", "1-1", "a", "1")
+	h.do("XADD", "s", "2-1", "b", "2")
+	h.do("XADD", "s", "3-1", "c", "3")
+	return h
+}
+
+func TestConsumerGroupCreate(t *testing.T) {
+	h := newHarness(t)
+	if reply := h.do("XGROUP", "CREATE", "s", "g", "$"); !reply.IsError() {
+		t.Fatalf("XGROUP CREATE of a missing stream = %s, want an error", reply)
+	}
+	h.expect(`"OK"`, "XGROUP", "CREATE", "s", "g", "$", "MKSTREAM")
+	h.expect(`"stream"`, "TYPE", "s")
+	h.expect("(error) BUSYGROUP Consumer Group name already exists", "XGROUP", "CREATE", "s", "g", "0")
+	h.expect("(error) NOGROUP No such key 's' or consumer group 'other' in XREADGROUP with GROUP option",
+		"XREADGROUP", "GROUP", "other", "alice", "STREAMS", "s", ">")
+}
+
+// Each new entry goes to one consumer, and stays pending for it until
+// acknowledged
+func TestConsumerGroupDelivery(t *testing.T) {
+	h := newGroup(t)
+	h.expect(`[["s", [["1-1", ["a", "1"]]]]]`, "XREADGROUP", "GROUP", "g", "alice", "COUNT", "1", "STREAMS", "s", ">")
+	h.expect(`[["s", [["2-1", ["b", "2"]], ["3-1", ["c", "3"]]]]]`, "XREADGROUP", "GROUP", "g", "bob", "STREAMS", "s", ">")
+	h.expect("(nil)", "XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", ">")
+	h.expect(`[(integer) 3, "1-1", "3-1", [["alice", "1"], ["bob", "2"]]]`, "XPENDING", "s", "g")
+
+	// Reading from an ID reads the consumer's own pending entries, and
+	// changes nothing
+	h.expect(`[["s", [["1-1", ["a", "1"]]]]]`, "XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "0")
+	h.expect(`[["s", [["2-1", ["b", "2"]], ["3-1", ["c", "3"]]]]]`, "XREADGROUP", "GROUP", "g", "bob", "STREAMS", "s", "0")
+
+	h.expect("(integer) 1", "XACK", "s", "g", "1-1")
+	h.expect("(integer) 0", "XACK", "s", "g", "1-1")
+	h.expect(`[["s", []]]`, "XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "0")
+	h.expect(`[(integer) 2, "2-1", "3-1", [["bob", "2"]]]`, "XPENDING", "s", "g")
+
+	pending := h.do("XPENDING", "s", "g", "-", "+", "10", "bob")
+	if len(pending.Array) != 2 || pending.Array[0].Array[0].Str != "2-1" || pending.Array[1].Array[3].Int != 1 {
+		t.Fatalf("XPENDING s g - + 10 bob = %s, want 2-1 and 3-1 delivered once", pending)
+	}
+}
+
+func TestConsumerGroupNoAck(t *testing.T) {
+	h := newGroup(t)
+	h.expect(`[["s", [["1-1", ["a", "1"]], ["2-1", ["b", "2"]], ["3-1", ["c", "3"]]]]]`,
+		"XREADGROUP", "GROUP", "g", "alice", "NOACK", "STREAMS", "s", ">")
+	h.expect(`[(integer) 0, (nil), (nil), (nil)]`, "XPENDING", "s", "g")
+	h.expect("(nil)", "XREADGROUP", "GROUP", "g", "bob", "STREAMS", "s", ">")
+}
+
+func TestConsumerGroupClaim(t *testing.T) {
+	h := newGroup(t)
+	h.do("XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", ">")
+	h.expect(`[["2-1", ["b", "2"]]]`, "XCLAIM", "s", "g", "bob", "0", "2-1")
+	h.expect(`[(integer) 3, "1-1", "3-1", [["alice", "2"], ["bob", "1"]]]`, "XPENDING", "s", "g")
+	// Not pending, so not claimed
+	h.expect("[]", "XCLAIM", "s", "g", "bob", "0", "9-9")
+}
+
+func TestConsumerGroupConsumers(t *testing.T) {
+	h := newGroup(t)
+	h.expect("(integer) 1", "XGROUP", "CREATECONSUMER", "s", "g", "carol")
+	h.expect("(integer) 0", "XGROUP", "CREATECONSUMER", "s", "g", "carol")
+	h.do("XREADGROUP", "GROUP", "g", "alice", "COUNT", "2", "STREAMS", "s", ">")
+
+	var names []string
+	for _, consumer := range h.do("XINFO", "CONSUMERS", "s", "g").Array {
+		names = append(names, consumer.Array[1].Str)
+	}
+	if !slices.Equal(names, []string{"alice", "carol"}) {
+		t.Fatalf("XINFO CONSUMERS s g names %v, want [alice carol]", names)
+	}
+
+	// Deleting a consumer drops its pending entries
+	h.expect("(integer) 2", "XGROUP", "DELCONSUMER", "s", "g", "alice")
+	h.expect(`[(integer) 0, (nil), (nil), (nil)]`, "XPENDING", "s", "g")
+	h.expect("(integer) 0", "XGROUP", "DELCONSUMER", "s", "g", "carol")
+}
+
+func TestConsumerGroupSetIDAndDestroy(t *testing.T) {
+	h := newGroup(t)
+	h.do("XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", ">")
+	h.expect(`"OK"`, "XGROUP", "SETID", "s", "g", "1-1")
+	h.expect(`[["s", [["2-1", ["b", "2"]], ["3-1", ["c", "3"]]]]]`, "XREADGROUP", "GROUP", "g", "bob", "STREAMS", "s", ">")
+
+	h.expect("(integer) 1", "XGROUP", "DESTROY", "s", "g")
+	h.expect("(integer) 0", "XGROUP", "DESTROY", "s", "g")
+	h.expect("[]", "XINFO", "GROUPS", "s")
+	h.expect(`"stream"`, "TYPE", "s")
+}
+
+// Replicas get group changes as commands that lead to the same state: a
+// ">" read with COUNT set to what was delivered
+func TestConsumerGroupReplication(t *testing.T) {
+	h := newHarness(t)
+	replica := h.attachReplica()
+	for _, step := range []struct {
+		args, want []string
+	}{
+		{[]string{"XGROUP", "CREATE", "s", "g", "$", "MKSTREAM"}, []string{"XGROUP", "CREATE", "s", "g", "0-0", "MKSTREAM"}},
+		{[]string{"XADD", "s", "1-1", "a", "1"}, nil},
+		{[]string{"XADD", "s", "2-1", "b", "2"}, nil},
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", ">"}, []string{"XREADGROUP", "GROUP", "g", "alice", "COUNT", "2", "STREAMS", "s", ">"}},
+		{[]string{"XACK", "s", "g", "1-1"}, nil},
+		{[]string{"XGROUP", "DELCONSUMER", "s", "g", "alice"}, nil},
+	} {
+		h.do(step.args...)
+		want := step.want
+		if want == nil {
+			want = step.args
+		}
+		if got := propagated(t, replica); !slices.Equal(got, want) {
+			t.Fatalf("%v propagated %v, want %v", step.args, got, want)
+		}
+	}
+
+	// A read that delivers nothing only creates its consumer
+	h.expect("(nil)", "XREADGROUP", "GROUP", "g", "bob", "STREAMS", "s", ">")
+	if got, want := propagated(t, replica), []string{"XGROUP", "CREATECONSUMER", "s", "g", "bob"}; !slices.Equal(got, want) {
+		t.Fatalf("an empty XREADGROUP propagated %v, want %v", got, want)
+	}
+	h.expect("(nil)", "XREADGROUP", "GROUP", "g", "bob", "STREAMS", "s", ">")
+	h.do("SET", "marker", "1")
+	if got := propagated(t, replica); got[0] != "SET" {
+		t.Fatalf("a second empty XREADGROUP propagated %v", got)
+	}
+}
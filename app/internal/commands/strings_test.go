@@ -0,0 +1,103 @@
+package commands
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+	"github.com/r0ld3x/redis-clone-go/app/internal/testutil"
+)
+
+func TestSetGet(t *testing.T) {
+	h := newHarness(t)
+	h.expect(`"OK"`, "SET", "k", "v")
+	h.expect(`"v"`, "GET", "k")
+	h.expect("(nil)", "GET", "missing")
+	h.expect(`"OK"`, "SET", "k", "w", "PX", "100000")
+	h.expect(`"w"`, "GET", "k")
+	if ttl := h.do("PTTL", "k").Int; ttl <= 0 || ttl > 100000 {
+		t.Fatalf("PTTL after SET PX 100000 = %d", ttl)
+	}
+}
+
+func TestIncr(t *testing.T) {
+	h := newHarness(t)
+	h.expect("(integer) 1", "INCR", "n")
+	h.do("SET", "n", "41")
+	h.expect("(integer) 42", "INCR", "n")
+	h.do("SET", "s", "abc")
+	if reply := h.do("INCR", "s"); !reply.IsError() {
+		t.Fatalf("INCR on a non-integer = %s, want an error", reply)
+	}
+}
+
+func TestAppendAndStrlen(t *testing.T) {
+	h := newHarness(t)
+	h.expect("(integer) 5", "APPEND", "k", "hello")
+	h.expect("(integer) 11", "APPEND", "k", " world")
+	h.expect(`"hello world"`, "GET", "k")
+	h.expect("(integer) 11", "SETRANGE", "k", "6", "there")
+	h.expect(`"hello there"`, "GET", "k")
+}
+
+func TestTypeOfNumericString(t *testing.T) {
+	h := newHarness(t)
+	h.do("SET", "i", "42")
+	h.do("SET", "f", "1.5")
+	h.expect(`"string"`, "TYPE", "i")
+	h.expect(`"string"`, "TYPE", "f")
+	h.expect(`"none"`, "TYPE", "missing")
+}
+
+// latencyBudget is what a GET or SET may cost in its handler, network
+// excluded
+const latencyBudget = time.Microsecond
+
+// enforceBudget makes a benchmark over its budget fail. Timings depend on
+// the machine, so by default the share of the budget is only reported.
+var enforceBudget = flag.Bool("latency-budget", false, "Fail GET/SET benchmarks that cost more than their latency budget")
+
+// discardConn drops what handlers write, standing in for the network
+type discardConn struct {
+	*testutil.Conn
+}
+
+func (discardConn) Write(b []byte) (int, error) { return len(b), nil }
+
+// benchmark runs args b.N times through the registry at --loglevel error,
+// discarding the replies, and reports the share of budget a call takes as
+// budget%. With -latency-budget, a run long enough to trust fails when it
+// costs more than budget per call.
+func (h *harness) benchmark(b *testing.B, budget time.Duration, args ...string) {
+	logging.SetLevel(logging.LevelError)
+	defer logging.SetLevel(logging.LevelNone)
+
+	cmd, rest := args[0], args[1:]
+	conn := discardConn{h.conn}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := h.registry.Execute(h.srv, conn, OriginClient, cmd, rest); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	perCall := b.Elapsed() / time.Duration(b.N)
+	b.ReportMetric(100*float64(perCall)/float64(budget), "budget%")
+	if *enforceBudget && b.N >= 100000 && perCall > budget {
+		b.Errorf("%s costs %v per call, over its budget of %v", cmd, perCall, budget)
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	h := newHarness(b)
+	h.do("SET", "key", "value")
+	h.benchmark(b, latencyBudget, "GET", "key")
+}
+
+func BenchmarkSet(b *testing.B) {
+	h := newHarness(b)
+	h.benchmark(b, latencyBudget, "SET", "key", "value")
+}
@@ -0,0 +1,79 @@
+package commands
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
+	"github.com/r0ld3x/redis-clone-go/app/internal/server"
+)
+
+// subcommand is one subcommand of a container command such as CONFIG or
+// XINFO
+type subcommand struct {
+	// arity is the number of arguments, the subcommand's name included:
+	// exact when positive, a minimum when negative, as in COMMAND INFO
+	arity int
+	usage string // Arguments after the name, for HELP
+	// run executes the subcommand with the arguments after its name, once
+	// their number has been checked
+	run func(srv *server.Server, clientConn net.Conn, args []string)
+}
+
+// subcommands routes a container command to its subcommands, keyed by
+// upper case name. Every command built on it reports a missing, unknown or
+// wrongly called subcommand the same way, and answers HELP from the table.
+type subcommands struct {
+	command string
+	table   map[string]subcommand
+}
+
+// dispatch runs the subcommand args[0] names. It returns false when it
+// replied with an error instead.
+func (s subcommands) dispatch(srv *server.Server, clientConn net.Conn, args []string) bool {
+	if len(args) < 1 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for '"+s.command+"' command")
+		return false
+	}
+
+	name := strings.ToUpper(args[0])
+	if name == "HELP" && len(args) == 1 {
+		s.writeHelp(clientConn)
+		return true
+	}
+	sub, known := s.table[name]
+	if !known {
+		protocol.WriteError(clientConn, fmt.Sprintf("ERR unknown subcommand '%s'. Try %s HELP.", args[0], s.command))
+		return false
+	}
+	if sub.arity >= 0 && len(args) != sub.arity || sub.arity < 0 && len(args) < -sub.arity {
+		protocol.WriteError(clientConn, fmt.Sprintf("ERR wrong number of arguments for '%s|%s' command", s.command, name))
+		return false
+	}
+
+	sub.run(srv, clientConn, args[1:])
+	return true
+}
+
+// writeHelp replies to HELP with the usage of every subcommand, in name
+// order
+func (s subcommands) writeHelp(clientConn net.Conn) {
+	names := make([]string, 0, len(s.table))
+	for name := range s.table {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := []string{s.command + " <subcommand> [<arg> [value] [opt] ...]. Subcommands are:"}
+	for _, name := range names {
+		lines = append(lines, strings.TrimSpace(name+" "+s.table[name].usage))
+	}
+	lines = append(lines, "HELP")
+	elements := make([]string, len(lines))
+	for i, line := range lines {
+		elements[i] = "+" + line + "\r\n"
+	}
+	protocol.WriteArray2(clientConn, elements)
+}
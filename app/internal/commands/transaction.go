@@ -2,14 +2,14 @@ package commands
 
 import (
 	"fmt"
-	"log"
 	"net"
-	"strconv"
 	"strings"
+	"time"
 
 	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
 	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
 	"github.com/r0ld3x/redis-clone-go/app/internal/server"
+	"github.com/r0ld3x/redis-clone-go/app/internal/transaction"
 
 	"github.com/r0ld3x/redis-clone-go/app/pkg/database"
 )
@@ -27,7 +27,7 @@ func (h *MultiHandler) Handle(srv *server.Server, clientConn net.Conn, args []st
 	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
 
 	if srv.TransactionMgr.IsInTransaction(clientConn) {
-		protocol.WriteError(clientConn, "MULTI calls can not be nested")
+		protocol.WriteError(clientConn, "ERR MULTI calls can not be nested")
 		return nil
 	}
 
@@ -39,7 +39,8 @@ func (h *MultiHandler) Handle(srv *server.Server, clientConn net.Conn, args []st
 
 // ExecHandler handles EXEC commands
 type ExecHandler struct {
-	logger *logging.Logger
+	logger   *logging.Logger
+	registry *Registry
 }
 
 func (h *ExecHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
@@ -52,126 +53,203 @@ func (h *ExecHandler) Handle(srv *server.Server, clientConn net.Conn, args []str
 		return nil
 	}
 
-	queuedCommands := srv.TransactionMgr.GetQueuedCommands(clientConn)
-	results := make([]string, 0)
+	if srv.TransactionMgr.IsDirty(clientConn) {
+		srv.TransactionMgr.DiscardTransaction(clientConn)
+		protocol.WriteError(clientConn, "EXECABORT Transaction discarded because of previous errors.")
+		return nil
+	}
 
+	// No other write runs until the transaction is applied and replicated,
+	// so clients see all of it or none, and replicas get it in the order
+	// it applied. Its commands run through run, not Execute, so they don't
+	// wait for the writes themselves.
+	resume := srv.PauseWrites()
+	defer resume()
+
+	// Expired keys are deleted first, as the commands can't replicate a
+	// DEL from inside the transaction
+	queuedCommands := srv.TransactionMgr.GetQueuedCommands(clientConn)
+	var writes []string
 	for _, queuedCmd := range queuedCommands {
-		fmt.Printf("queuedCmd: %+v\n", queuedCmd)
-		result := h.executeCommand(srv, clientConn, queuedCmd.Command, queuedCmd.Args)
-		log.Printf("%+v\n", result)
-		results = append(results, result)
+		cmd := Command(strings.ToUpper(queuedCmd.Command))
+		expireKeys(srv, cmd, queuedCmd.Args)
+		if IsWriteCommand(cmd) {
+			writes = append(writes, commandKeys(cmd, queuedCmd.Args)...)
+		}
 	}
 
-	protocol.WriteArray2(clientConn, results)
+	var replies []byte
+	ran := srv.TransactionMgr.ExecWatched(clientConn, database.Exists, writes, func() {
+		srv.PropagateTransaction(clientConn, func() {
+			replies = h.execute(srv, clientConn, queuedCommands)
+		})
+	})
 	srv.TransactionMgr.EndTransaction(clientConn)
+	if !ran {
+		// A watched key changed: nothing ran and EXEC answers a null array
+		clientConn.Write([]byte("*-1\r\n"))
+		return nil
+	}
+
+	clientConn.Write(append([]byte(fmt.Sprintf("*%d\r\n", len(queuedCommands))), replies...))
+	h.logger.Success("Command completed successfully")
 	return nil
 }
 
-func (h *ExecHandler) executeCommand(srv *server.Server, clientConn net.Conn, cmd string, args []string) string {
-	switch strings.ToUpper(cmd) {
-	case "SET":
-		return h.executeSetCommand(srv, clientConn, args)
-	case "GET":
-		return h.executeGetCommand(srv, clientConn, args)
-	case "ECHO":
-		return h.executeEchoCommand(srv, clientConn, args)
-	case "PING":
-		return h.executePingCommand(srv, clientConn, args)
-	case "INCR":
-		return h.executeIncrCommand(srv, clientConn, args)
-	default:
-		return protocol.FormatError("ERR unknown command '" + cmd + "'")
+// execute runs the queued commands through their handlers and returns
+// their replies, captured from the client's connection one command at a
+// time, so the EXEC array gets exactly one element per command whatever
+// its handler wrote. A connection that is not a client's is our master's,
+// applying a transaction it propagated: nobody reads the replies, which go
+// straight to it, and the commands are accounted for as the master's.
+func (h *ExecHandler) execute(srv *server.Server, clientConn net.Conn, queuedCommands []transaction.QueuedCommand) []byte {
+	c, isClient := srv.Clients.Get(clientConn)
+	origin := OriginMaster
+	if isClient {
+		origin = OriginClient
 	}
-}
 
-func (h *ExecHandler) executeSetCommand(srv *server.Server, clientConn net.Conn, args []string) string {
-	if len(args) < 2 {
-		return protocol.FormatError("ERR wrong number of arguments for 'SET'")
+	var replies []byte
+	for _, queuedCmd := range queuedCommands {
+		cmd := strings.ToUpper(queuedCmd.Command)
+		if !isClient {
+			h.executeOne(srv, clientConn, origin, cmd, queuedCmd.Args)
+			continue
+		}
+		captured := c.CaptureReplies()
+		h.executeOne(srv, clientConn, origin, cmd, queuedCmd.Args)
+		replies = append(replies, h.reply(cmd, captured())...)
 	}
+	return replies
+}
 
-	key, val := args[0], args[1]
-	ms := -1
-	if len(args) == 4 && strings.ToUpper(args[2]) == "PX" {
-		ms, _ = strconv.Atoi(args[3])
+// executeOne runs one queued command
+func (h *ExecHandler) executeOne(srv *server.Server, clientConn net.Conn, origin Origin, cmd string, args []string) {
+	if Command(cmd) == WaitCommand {
+		start := time.Now()
+		clientConn.Write([]byte(h.executeWaitCommand(srv, clientConn, args)))
+		Account(srv, clientConn, origin, cmd, args, time.Since(start))
+		return
 	}
 
-	database.SetKey(key, val, ms)
-
-	if srv.IsMaster() {
-		command := []string{"SET", key, val}
-		if ms > -1 {
-			command = append(command, "PX", strconv.Itoa(ms))
-		}
-		srv.ReplicateCommand(command)
+	handler, exists := h.registry.Get(Command(cmd))
+	if !exists {
+		protocol.WriteError(clientConn, "ERR unknown command '"+cmd+"'")
+		return
+	}
+	if err := run(srv, clientConn, origin, handler, cmd, args); err != nil {
+		h.logger.Error("Handler error for queued %s: %v", cmd, err)
+		protocol.WriteError(clientConn, "ERR internal server error")
 	}
+}
 
-	return "+OK\r\n"
+// reply returns the first reply in what cmd wrote, which is its element of
+// the EXEC array. A handler that wrote no complete reply gets an error in
+// its place; anything written after the first reply is dropped.
+func (h *ExecHandler) reply(cmd string, written []byte) []byte {
+	n := protocol.ReplyLen(written)
+	if n < 0 {
+		h.logger.Error("Queued %s wrote no complete reply: %q", cmd, written)
+		return []byte(protocol.FormatError("ERR " + cmd + " returned no reply"))
+	}
+	if n < len(written) {
+		h.logger.Error("Queued %s wrote more than one reply, dropping %q", cmd, written[n:])
+	}
+	return written[:n]
 }
 
-func (h *ExecHandler) executeGetCommand(srv *server.Server, clientConn net.Conn, args []string) string {
-	if len(args) < 1 {
-		return protocol.FormatError("ERR wrong number of arguments for 'GET'")
+// executeWaitCommand runs a WAIT queued with multi-allow-wait. A transaction
+// can't block, so like in Redis it returns at once with the number of
+// replicas that have already acknowledged the master's offset.
+func (h *ExecHandler) executeWaitCommand(srv *server.Server, clientConn net.Conn, args []string) string {
+	if srv.IsSlave() {
+		return protocol.FormatError("ERR WAIT cannot be used with replica instances.")
 	}
+	if len(args) != 2 {
+		return protocol.FormatError("ERR wrong number of arguments for 'WAIT'")
+	}
+
+	srv.Mutex.RLock()
+	masterOffset := srv.ReplicationOffset
+	srv.Mutex.RUnlock()
 
-	key := args[0]
-	val, success := database.GetKey(key)
-	if !success {
-		return "$-1\r\n"
+	acked := 0
+	for _, conn := range srv.Replicas() {
+		if srv.GetReplicaOffset(conn) >= masterOffset {
+			acked++
+		}
 	}
+	return protocol.FormatInteger(acked)
+}
 
-	return fmt.Sprintf("$%d\r\n%s\r\n", len(val), val)
+// DiscardHandler handles DISCARD commands
+type DiscardHandler struct {
+	logger *logging.Logger
 }
 
-func (h *ExecHandler) executeIncrCommand(srv *server.Server, clientConn net.Conn, args []string) string {
-	if len(args) < 1 {
-		return protocol.FormatError("ERR wrong number of arguments for 'INCR'")
+func (h *DiscardHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("DISCARD")
 	}
 
-	key := args[0]
-	resp, success := database.Increment(key, 1)
-	if !success {
-		return protocol.FormatError("ERR value is not an integer or out of range")
+	if !srv.TransactionMgr.IsInTransaction(clientConn) {
+		protocol.WriteError(clientConn, "ERR DISCARD without MULTI")
+		return nil
 	}
 
-	receivedInt, err := strconv.Atoi(resp)
-	if err != nil {
-		return protocol.FormatError("ERR value is not an integer or out of range")
-	}
+	srv.TransactionMgr.DiscardTransaction(clientConn)
+	protocol.WriteSimpleString(clientConn, "OK")
+	return nil
+}
 
-	return fmt.Sprintf(":%d\r\n", receivedInt)
+// WatchHandler handles WATCH commands
+type WatchHandler struct {
+	logger *logging.Logger
 }
 
-func (h *ExecHandler) executeEchoCommand(srv *server.Server, clientConn net.Conn, args []string) string {
-	if len(args) < 1 {
-		return protocol.FormatError("ERR wrong number of arguments for 'ECHO'")
+func (h *WatchHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("WATCH")
 	}
 
-	return protocol.FormatBulkString(args[0])
-}
+	if len(args) < 1 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'WATCH' command")
+		return nil
+	}
+	if srv.TransactionMgr.IsInTransaction(clientConn) {
+		protocol.WriteError(clientConn, "ERR WATCH inside MULTI is not allowed")
+		return nil
+	}
 
-func (h *ExecHandler) executePingCommand(srv *server.Server, clientConn net.Conn, args []string) string {
-	if len(args) == 0 {
-		return protocol.FormatSimpleString("PONG")
+	for _, key := range args {
+		srv.TransactionMgr.Watch(clientConn, key, database.Exists(key))
 	}
-	return protocol.FormatBulkString(args[0])
+	protocol.WriteSimpleString(clientConn, "OK")
+	h.logger.Success("Command completed successfully")
+	return nil
 }
 
-// DiscardHandler handles DISCARD commands
-type DiscardHandler struct {
+// UnwatchHandler handles UNWATCH commands
+type UnwatchHandler struct {
 	logger *logging.Logger
 }
 
-func (h *DiscardHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+func (h *UnwatchHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
 	if h.logger == nil {
-		h.logger = logging.NewLogger("DISCARD")
+		h.logger = logging.NewLogger("UNWATCH")
 	}
 
-	if !srv.TransactionMgr.IsInTransaction(clientConn) {
-		protocol.WriteError(clientConn, "ERR DISCARD without MULTI")
+	if len(args) != 0 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'UNWATCH' command")
 		return nil
 	}
 
-	srv.TransactionMgr.DiscardTransaction(clientConn)
+	// Queued in a transaction it runs inside EXEC, which unwatches every
+	// key once done, holding the watches locked meanwhile
+	if !srv.TransactionMgr.IsInTransaction(clientConn) {
+		srv.TransactionMgr.Unwatch(clientConn)
+	}
 	protocol.WriteSimpleString(clientConn, "OK")
+	h.logger.Success("Command completed successfully")
 	return nil
 }
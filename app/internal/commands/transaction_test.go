@@ -0,0 +1,239 @@
+package commands
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/testutil"
+)
+
+// exec runs commands as a transaction of a registered client on conn and
+// returns the EXEC reply
+func (h *harness) exec(conn net.Conn, client *testutil.Client, commands ...[]string) testutil.Reply {
+	h.t.Helper()
+	run := func(cmd string, args ...string) testutil.Reply {
+		if _, err := h.registry.Execute(h.srv, conn, OriginClient, cmd, args); err != nil {
+			h.t.Error(err)
+		}
+		reply, err := client.ReadReply(time.Second)
+		if err != nil {
+			h.t.Errorf("%s: reading reply: %v", cmd, err)
+		}
+		return reply
+	}
+	run("MULTI")
+	for _, command := range commands {
+		h.srv.TransactionMgr.QueueCommand(conn, command[0], command[1:])
+	}
+	return run("EXEC")
+}
+
+func TestExecIsSerializedWithOtherWrites(t *testing.T) {
+	h := newHarness(t)
+	replica := h.attachReplica()
+
+	serverEnd, clientEnd := testutil.Pipe()
+	t.Cleanup(func() { serverEnd.Close() })
+	_, conn := h.srv.Clients.Register(serverEnd)
+	client := testutil.NewClient(clientEnd)
+
+	const rounds, size = 200, 5
+	transaction := make([][]string, size)
+	for i := range transaction {
+		transaction[i] = []string{"INCR", "k"}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			if _, err := h.registry.Execute(h.srv, h.conn, OriginClient, "INCR", []string{"k"}); err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := h.client.ReadReply(time.Second); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	// No INCR of the other client lands between those of a transaction
+	var firsts []int64
+	for i := 0; i < rounds; i++ {
+		reply := h.exec(conn, client, transaction...)
+		if len(reply.Array) != size || reply.Array[size-1].Int != reply.Array[0].Int+size-1 {
+			t.Fatalf("EXEC of %d INCR k = %s, want consecutive values", size, reply)
+		}
+		firsts = append(firsts, reply.Array[0].Int)
+	}
+	wg.Wait()
+
+	// Replicas get the writes in the order they applied: each transaction
+	// as a block, after as many INCRs as preceded it
+	incrs, blocks := 0, 0
+	for incrs < (size+1)*rounds {
+		command := strings.Join(propagated(t, replica), " ")
+		switch command {
+		case "INCR k":
+			incrs++
+		case "MULTI":
+			if blocks == rounds {
+				t.Fatal("propagated more transactions than ran")
+			}
+			if int64(incrs+1) != firsts[blocks] {
+				t.Fatalf("transaction %d propagated after %d INCRs, applied after %d", blocks, incrs, firsts[blocks]-1)
+			}
+			for j := 0; j <= size; j++ {
+				want := "INCR k"
+				if j == size {
+					want = "EXEC"
+				}
+				if got := strings.Join(propagated(t, replica), " "); got != want {
+					t.Fatalf("transaction %d propagated %q, want %q", blocks, got, want)
+				}
+			}
+			incrs += size
+			blocks++
+		default:
+			t.Fatalf("propagated %q", command)
+		}
+	}
+}
+
+// watchClient returns a registered client connection for the WATCH tests,
+// whose EXEC runs the transaction they pass
+func (h *harness) watchClient() (net.Conn, *testutil.Client) {
+	serverEnd, clientEnd := testutil.Pipe()
+	h.t.Cleanup(func() { serverEnd.Close() })
+	_, conn := h.srv.Clients.Register(serverEnd)
+	return conn, testutil.NewClient(clientEnd)
+}
+
+// doOn runs a command on a registered client connection and returns its
+// reply
+func (h *harness) doOn(conn net.Conn, client *testutil.Client, args ...string) testutil.Reply {
+	h.t.Helper()
+	if _, err := h.registry.Execute(h.srv, conn, OriginClient, args[0], args[1:]); err != nil {
+		h.t.Fatal(err)
+	}
+	reply, err := client.ReadReply(time.Second)
+	if err != nil {
+		h.t.Fatalf("%v: reading reply: %v", args, err)
+	}
+	return reply
+}
+
+func TestWatch(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		change  func(h *harness)
+		aborted bool
+	}{
+		{"unchanged", func(h *harness) {}, false},
+		{"another key written", func(h *harness) { h.do("SET", "other", "1") }, false},
+		{"read", func(h *harness) { h.do("GET", "k") }, false},
+		{"written", func(h *harness) { h.do("SET", "k", "2") }, true},
+		{"written with the same value", func(h *harness) { h.do("SET", "k", "1") }, true},
+		{"deleted", func(h *harness) { h.do("DEL", "k") }, true},
+		{"given a TTL", func(h *harness) { h.do("EXPIRE", "k", "100") }, true},
+		{"written by the master", func(h *harness) {
+			if _, err := h.registry.Execute(h.srv, discardConn{h.conn}, OriginMaster, "SET", []string{"k", "2"}); err != nil {
+				t.Fatal(err)
+			}
+		}, true},
+		{"expired", func(h *harness) {
+			h.do("PEXPIRE", "k", "10")
+			time.Sleep(20 * time.Millisecond)
+		}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newHarness(t)
+			h.do("SET", "k", "1")
+			conn, client := h.watchClient()
+			if reply := h.doOn(conn, client, "WATCH", "k"); reply.String() != `"OK"` {
+				t.Fatalf("WATCH k = %s", reply)
+			}
+			tc.change(h)
+
+			reply := h.exec(conn, client, []string{"INCR", "n"})
+			if aborted := reply.String() == "(nil)"; aborted != tc.aborted {
+				t.Fatalf("EXEC with the watched key %s = %s, want aborted %t", tc.name, reply, tc.aborted)
+			}
+			want := `"1"`
+			if tc.aborted {
+				want = "(nil)"
+			}
+			h.expect(want, "GET", "n")
+		})
+	}
+}
+
+func TestWatchMissingKey(t *testing.T) {
+	h := newHarness(t)
+	conn, client := h.watchClient()
+	h.doOn(conn, client, "WATCH", "k")
+	h.do("SET", "k", "1")
+	if reply := h.exec(conn, client, []string{"INCR", "n"}); reply.String() != "(nil)" {
+		t.Fatalf("EXEC after a watched missing key was created = %s, want (nil)", reply)
+	}
+}
+
+// A transaction's writes fail the other transactions watching its keys
+func TestWatchTouchedByTransaction(t *testing.T) {
+	h := newHarness(t)
+	first, firstClient := h.watchClient()
+	second, secondClient := h.watchClient()
+	h.doOn(first, firstClient, "WATCH", "k")
+	h.doOn(second, secondClient, "WATCH", "k")
+
+	if reply := h.exec(first, firstClient, []string{"INCR", "k"}); reply.String() != "[(integer) 1]" {
+		t.Fatalf("first EXEC = %s, want [(integer) 1]", reply)
+	}
+	if reply := h.exec(second, secondClient, []string{"INCR", "k"}); reply.String() != "(nil)" {
+		t.Fatalf("second EXEC = %s, want (nil)", reply)
+	}
+	h.expect(`"1"`, "GET", "k")
+}
+
+// UNWATCH, EXEC and DISCARD each forget the watched keys
+func TestUnwatch(t *testing.T) {
+	h := newHarness(t)
+	conn, client := h.watchClient()
+	h.doOn(conn, client, "WATCH", "k")
+	if reply := h.doOn(conn, client, "UNWATCH"); reply.String() != `"OK"` {
+		t.Fatalf("UNWATCH = %s", reply)
+	}
+	h.do("SET", "k", "1")
+	if reply := h.exec(conn, client, []string{"INCR", "n"}); reply.String() != "[(integer) 1]" {
+		t.Fatalf("EXEC after UNWATCH = %s, want it to run", reply)
+	}
+
+	h.doOn(conn, client, "WATCH", "k")
+	h.do("SET", "k", "2")
+	if reply := h.exec(conn, client, []string{"INCR", "n"}); reply.String() != "(nil)" {
+		t.Fatalf("EXEC = %s, want (nil)", reply)
+	}
+	h.do("SET", "k", "3")
+	if reply := h.exec(conn, client, []string{"INCR", "n"}); reply.String() != "[(integer) 2]" {
+		t.Fatalf("EXEC after an aborted EXEC = %s, want it to run", reply)
+	}
+
+	h.doOn(conn, client, "WATCH", "k")
+	h.doOn(conn, client, "MULTI")
+	if reply := h.doOn(conn, client, "WATCH", "other"); reply.String() != "(error) ERR WATCH inside MULTI is not allowed" {
+		t.Errorf("WATCH inside MULTI = %s, want an error", reply)
+	}
+	h.doOn(conn, client, "DISCARD")
+	h.do("SET", "k", "4")
+	if reply := h.exec(conn, client, []string{"INCR", "n"}); reply.String() != "[(integer) 3]" {
+		t.Fatalf("EXEC after DISCARD = %s, want it to run", reply)
+	}
+	if h.srv.TransactionMgr.Watching() {
+		t.Error("a key is still watched after every watch was forgotten")
+	}
+}
@@ -0,0 +1,95 @@
+package commands
+
+import "strings"
+
+// maxUnknownPreview caps the command name and the argument preview echoed
+// back for an unknown command, like Redis does
+const maxUnknownPreview = 128
+
+// UnknownCommandError builds the error for a command with no handler in the
+// format Redis uses:
+//
+//	ERR unknown command 'gte', with args beginning with: 'key'
+//
+// With suggest set, the closest registered command by edit distance is
+// appended as a "did you mean" hint when there is a plausible one.
+func (r *Registry) UnknownCommandError(name string, args []string, suggest bool) string {
+	var b strings.Builder
+	b.WriteString("ERR unknown command '")
+	b.WriteString(truncate(name, maxUnknownPreview))
+	b.WriteString("', with args beginning with: ")
+
+	preview := 0
+	for _, arg := range args {
+		if preview >= maxUnknownPreview {
+			break
+		}
+		arg = truncate(arg, maxUnknownPreview-preview)
+		b.WriteString("'" + arg + "' ")
+		preview += len(arg) + 3
+	}
+
+	if suggest {
+		if match, ok := r.Suggest(name); ok {
+			b.WriteString("(did you mean '" + match + "'?)")
+		}
+	}
+
+	// The reply is a simple string, which must not contain line breaks
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(b.String())
+}
+
+// Suggest returns the registered command closest to name, in lower case.
+// ok is false when nothing is within two edits, or when the closest match
+// would replace most of a short name, which would hardly be a typo.
+func (r *Registry) Suggest(name string) (string, bool) {
+	name = strings.ToUpper(name)
+	best, bestDistance := "", 3
+	for cmd := range r.handlers {
+		d := editDistance(name, string(cmd))
+		if d < bestDistance || (d == bestDistance && string(cmd) < best) {
+			best, bestDistance = string(cmd), d
+		}
+	}
+	if best == "" || bestDistance >= len(name) {
+		return "", false
+	}
+	return strings.ToLower(best), true
+}
+
+// editDistance is the optimal string alignment distance between a and b:
+// the insertions, deletions, substitutions and adjacent transpositions
+// needed to turn one into the other, so "GTE" is one edit from "GET"
+func editDistance(a, b string) int {
+	// Three rolling rows of the usual dynamic programming table
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+// truncate cuts s to at most n bytes
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
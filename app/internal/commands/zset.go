@@ -0,0 +1,1055 @@
+package commands
+
+import (
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
+	"github.com/r0ld3x/redis-clone-go/app/internal/server"
+	"github.com/r0ld3x/redis-clone-go/app/pkg/database"
+)
+
+var errNotFloat = errors.New("ERR value is not a valid float")
+
+// parseScore parses a sorted set score, accepting "inf" forms but not NaN
+func parseScore(s string) (float64, error) {
+	score, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, errNotFloat
+	}
+	return score, nil
+}
+
+// ZAddHandler handles ZADD commands
+type ZAddHandler struct {
+	logger *logging.Logger
+}
+
+func (h *ZAddHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("ZADD")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 3 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'ZADD' command")
+		return nil
+	}
+
+	opts, pairs, err := parseZAddOptions(args[1:])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	members := make([]database.ZMember, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		score, err := parseScore(pairs[i])
+		if err != nil {
+			protocol.WriteError(clientConn, err.Error())
+			return nil
+		}
+		members = append(members, database.ZMember{Member: pairs[i+1], Score: score})
+	}
+
+	if opts.incr {
+		score, ok, err := database.ZSetIncrBy(args[0], members[0].Member, members[0].Score, opts.flags)
+		if err != nil {
+			protocol.WriteError(clientConn, err.Error())
+			return nil
+		}
+		if !ok {
+			clientConn.Write([]byte("$-1\r\n"))
+			return nil
+		}
+		srv.ReplicateCommand(clientConn, append([]string{"ZADD"}, args...))
+		protocol.WriteBulkString(clientConn, database.FormatScore(score))
+		h.logger.Success("Command completed successfully")
+		return nil
+	}
+
+	added, changed, err := database.ZSetAdd(args[0], members, opts.flags)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	if changed > 0 {
+		srv.ReplicateCommand(clientConn, append([]string{"ZADD"}, args...))
+	}
+
+	h.logger.Debug("Added %d new members to %s", added, args[0])
+	if opts.ch {
+		protocol.WriteInteger(clientConn, changed)
+	} else {
+		protocol.WriteInteger(clientConn, added)
+	}
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// zaddOptions are the flags accepted before ZADD's score/member pairs
+type zaddOptions struct {
+	flags database.ZAddFlags
+	ch    bool // Reply with the number of added or updated members
+	incr  bool // Behave like ZINCRBY
+}
+
+// parseZAddOptions splits the leading ZADD flags from the score/member
+// pairs and validates their combination
+func parseZAddOptions(args []string) (zaddOptions, []string, error) {
+	var opts zaddOptions
+
+	i := 0
+loop:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			opts.flags.NX = true
+		case "XX":
+			opts.flags.XX = true
+		case "GT":
+			opts.flags.GT = true
+		case "LT":
+			opts.flags.LT = true
+		case "CH":
+			opts.ch = true
+		case "INCR":
+			opts.incr = true
+		default:
+			break loop
+		}
+	}
+	pairs := args[i:]
+
+	switch {
+	case len(pairs) == 0 || len(pairs)%2 != 0:
+		return opts, nil, errors.New("ERR syntax error")
+	case opts.flags.NX && opts.flags.XX:
+		return opts, nil, errors.New("ERR XX and NX options at the same time are not compatible")
+	case (opts.flags.GT && opts.flags.LT) || (opts.flags.NX && (opts.flags.GT || opts.flags.LT)):
+		return opts, nil, errors.New("ERR GT, LT, and/or NX options at the same time are not compatible")
+	case opts.incr && len(pairs) != 2:
+		return opts, nil, errors.New("ERR INCR option supports a single increment-element pair")
+	}
+	return opts, pairs, nil
+}
+
+// ZScoreHandler handles ZSCORE commands
+type ZScoreHandler struct {
+	logger *logging.Logger
+}
+
+func (h *ZScoreHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("ZSCORE")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'ZSCORE' command")
+		return nil
+	}
+
+	score, found, err := database.ZSetScore(args[0], args[1])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+	if !found {
+		clientConn.Write([]byte("$-1\r\n"))
+		return nil
+	}
+
+	protocol.WriteBulkString(clientConn, database.FormatScore(score))
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// ZCardHandler handles ZCARD commands
+type ZCardHandler struct {
+	logger *logging.Logger
+}
+
+func (h *ZCardHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("ZCARD")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 1 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'ZCARD' command")
+		return nil
+	}
+
+	count, err := database.ZSetCard(args[0])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	protocol.WriteInteger(clientConn, count)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// ZRemHandler handles ZREM commands
+type ZRemHandler struct {
+	logger *logging.Logger
+}
+
+func (h *ZRemHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("ZREM")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'ZREM' command")
+		return nil
+	}
+
+	removed, err := database.ZSetRemove(args[0], args[1:])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	if removed > 0 {
+		srv.ReplicateCommand(clientConn, append([]string{"ZREM"}, args...))
+	}
+
+	protocol.WriteInteger(clientConn, removed)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// zrangeKind selects how ZRANGE interprets its bounds
+type zrangeKind int
+
+const (
+	zrangeByRank zrangeKind = iota
+	zrangeByScore
+	zrangeByLex
+)
+
+// zrangeQuery is a parsed ZRANGE-family request
+type zrangeQuery struct {
+	key        string
+	kind       zrangeKind
+	rev        bool
+	min, max   string // Bounds in ascending order, already swapped for REV forms
+	offset     int
+	count      int // -1 means no LIMIT
+	withScores bool
+}
+
+// parseScoreBound parses a score bound such as "1.5", "(1.5" or "-inf"
+func parseScoreBound(s string) (float64, bool, error) {
+	exclusive := strings.HasPrefix(s, "(")
+	score, err := strconv.ParseFloat(strings.TrimPrefix(s, "("), 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, false, errors.New("ERR min or max is not a float")
+	}
+	return score, exclusive, nil
+}
+
+// parseLexBound parses a lex bound: "-", "+", "[member" or "(member".
+// It returns the member, whether the bound is exclusive and whether it is
+// infinite.
+func parseLexBound(s string) (string, bool, bool, error) {
+	switch {
+	case s == "-" || s == "+":
+		return "", false, true, nil
+	case strings.HasPrefix(s, "["):
+		return s[1:], false, false, nil
+	case strings.HasPrefix(s, "("):
+		return s[1:], true, false, nil
+	default:
+		return "", false, false, errors.New("ERR min or max not valid string range item")
+	}
+}
+
+// parseScoreRange parses a "min max" pair of score bounds
+func parseScoreRange(minArg, maxArg string) (database.ScoreRange, error) {
+	min, minEx, err := parseScoreBound(minArg)
+	if err != nil {
+		return database.ScoreRange{}, err
+	}
+	max, maxEx, err := parseScoreBound(maxArg)
+	if err != nil {
+		return database.ScoreRange{}, err
+	}
+	return database.ScoreRange{Min: min, Max: max, MinExclusive: minEx, MaxExclusive: maxEx}, nil
+}
+
+// parseLexRange parses a "min max" pair of lex bounds. empty reports ranges
+// that can never match because "+" was given as the minimum or "-" as the
+// maximum.
+func parseLexRange(minArg, maxArg string) (r database.LexRange, empty bool, err error) {
+	min, minEx, minInf, err := parseLexBound(minArg)
+	if err != nil {
+		return r, false, err
+	}
+	max, maxEx, maxInf, err := parseLexBound(maxArg)
+	if err != nil {
+		return r, false, err
+	}
+	r = database.LexRange{
+		Min: min, Max: max,
+		MinExclusive: minEx, MaxExclusive: maxEx,
+		MinInfinite: minInf, MaxInfinite: maxInf,
+	}
+	return r, minArg == "+" || maxArg == "-", nil
+}
+
+// parseZRangeQuery parses "key start stop [options]". kind and rev are the
+// defaults implied by the command name; generic enables ZRANGE's
+// BYSCORE/BYLEX/REV options.
+func parseZRangeQuery(args []string, kind zrangeKind, rev, generic bool) (zrangeQuery, error) {
+	q := zrangeQuery{key: args[0], kind: kind, rev: rev, min: args[1], max: args[2], count: -1}
+	limited := false
+
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "WITHSCORES":
+			q.withScores = true
+		case opt == "LIMIT" && i+2 < len(args):
+			offset, err1 := strconv.Atoi(args[i+1])
+			count, err2 := strconv.Atoi(args[i+2])
+			if err1 != nil || err2 != nil {
+				return q, errors.New("ERR value is not an integer or out of range")
+			}
+			q.offset, q.count = offset, count
+			limited = true
+			i += 2
+		case generic && opt == "BYSCORE":
+			q.kind = zrangeByScore
+		case generic && opt == "BYLEX":
+			q.kind = zrangeByLex
+		case generic && opt == "REV":
+			q.rev = true
+		default:
+			return q, errors.New("ERR syntax error")
+		}
+	}
+
+	if limited && q.kind == zrangeByRank {
+		return q, errors.New("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if q.withScores && q.kind == zrangeByLex {
+		return q, errors.New("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+	if q.offset < 0 {
+		// Redis returns an empty result for a negative offset.
+		q.count = 0
+	}
+	if q.rev && q.kind != zrangeByRank {
+		// REV score/lex ranges are given as "max min".
+		q.min, q.max = q.max, q.min
+	}
+	return q, nil
+}
+
+// run executes the query
+func (q zrangeQuery) run() ([]database.ZMember, error) {
+	switch q.kind {
+	case zrangeByScore:
+		r, err := parseScoreRange(q.min, q.max)
+		if err != nil {
+			return nil, err
+		}
+		return database.ZSetRangeByScore(q.key, r, q.rev, q.offset, q.count)
+	case zrangeByLex:
+		r, empty, err := parseLexRange(q.min, q.max)
+		if err != nil || empty {
+			return []database.ZMember{}, err
+		}
+		return database.ZSetRangeByLex(q.key, r, q.rev, q.offset, q.count)
+	default:
+		start, err1 := strconv.Atoi(q.min)
+		stop, err2 := strconv.Atoi(q.max)
+		if err1 != nil || err2 != nil {
+			return nil, errors.New("ERR value is not an integer or out of range")
+		}
+		return database.ZSetRangeByRank(q.key, start, stop, q.rev)
+	}
+}
+
+// writeZMembers writes members, interleaved with their scores if requested
+func writeZMembers(clientConn net.Conn, members []database.ZMember, withScores bool) {
+	reply := make([]string, 0, len(members)*2)
+	for _, m := range members {
+		reply = append(reply, m.Member)
+		if withScores {
+			reply = append(reply, database.FormatScore(m.Score))
+		}
+	}
+	protocol.WriteArray(clientConn, reply)
+}
+
+// ZRangeHandler handles ZRANGE, ZREVRANGE, ZRANGEBYSCORE, ZREVRANGEBYSCORE,
+// ZRANGEBYLEX, ZREVRANGEBYLEX and ZRANGESTORE commands. ZRANGESTORE writes
+// the result to args[0] and is the only one replicated.
+type ZRangeHandler struct {
+	name    string     // Command name used in errors and logs
+	kind    zrangeKind // Range type implied by the command name
+	rev     bool       // Whether the command name implies REV
+	generic bool       // Whether BYSCORE/BYLEX/REV options are accepted (ZRANGE)
+	store   bool       // Whether the result is stored instead of returned
+	logger  *logging.Logger
+}
+
+func (h *ZRangeHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger(h.name)
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	queryArgs := args
+	if h.store {
+		queryArgs = args[1:]
+	}
+	if len(queryArgs) < 3 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for '"+h.name+"' command")
+		return nil
+	}
+
+	q, err := parseZRangeQuery(queryArgs, h.kind, h.rev, h.generic)
+	if err == nil && h.store && q.withScores {
+		err = errors.New("ERR syntax error")
+	}
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	members, err := q.run()
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	if !h.store {
+		writeZMembers(clientConn, members, q.withScores)
+		h.logger.Success("Command completed successfully")
+		return nil
+	}
+
+	count := database.ZSetStore(args[0], members)
+	replicateStore(srv, clientConn, append([]string{h.name}, args...), args[0], zaddCommand(args[0], members))
+
+	h.logger.Debug("Stored %d members in %s", count, args[0])
+	protocol.WriteInteger(clientConn, count)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// ZIncrByHandler handles ZINCRBY commands
+type ZIncrByHandler struct {
+	logger *logging.Logger
+}
+
+func (h *ZIncrByHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("ZINCRBY")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 3 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'ZINCRBY' command")
+		return nil
+	}
+
+	delta, err := parseScore(args[1])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	score, _, err := database.ZSetIncrBy(args[0], args[2], delta, database.ZAddFlags{})
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	srv.ReplicateCommand(clientConn, append([]string{"ZINCRBY"}, args...))
+
+	protocol.WriteBulkString(clientConn, database.FormatScore(score))
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// ZRankHandler handles ZRANK and ZREVRANK commands
+type ZRankHandler struct {
+	name   string // Command name used in errors and logs
+	rev    bool   // Whether ranks count from the highest score
+	logger *logging.Logger
+}
+
+func (h *ZRankHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger(h.name)
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 2 && len(args) != 3 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for '"+h.name+"' command")
+		return nil
+	}
+	withScore := len(args) == 3
+	if withScore && strings.ToUpper(args[2]) != "WITHSCORE" {
+		protocol.WriteError(clientConn, "ERR syntax error")
+		return nil
+	}
+
+	rank, score, found, err := database.ZSetRank(args[0], args[1], h.rev)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	switch {
+	case !found && withScore:
+		clientConn.Write([]byte("*-1\r\n"))
+	case !found:
+		clientConn.Write([]byte("$-1\r\n"))
+	case withScore:
+		protocol.WriteArray2(clientConn, []string{
+			protocol.FormatInteger(rank),
+			protocol.FormatBulkString(database.FormatScore(score)),
+		})
+	default:
+		protocol.WriteInteger(clientConn, rank)
+	}
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// ZCountHandler handles ZCOUNT commands
+type ZCountHandler struct {
+	logger *logging.Logger
+}
+
+func (h *ZCountHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("ZCOUNT")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 3 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'ZCOUNT' command")
+		return nil
+	}
+
+	r, err := parseScoreRange(args[1], args[2])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	count, err := database.ZSetCountByScore(args[0], r)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	protocol.WriteInteger(clientConn, count)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// ZLexCountHandler handles ZLEXCOUNT commands
+type ZLexCountHandler struct {
+	logger *logging.Logger
+}
+
+func (h *ZLexCountHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("ZLEXCOUNT")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 3 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'ZLEXCOUNT' command")
+		return nil
+	}
+
+	r, empty, err := parseLexRange(args[1], args[2])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	count := 0
+	if !empty {
+		count, err = database.ZSetCountByLex(args[0], r)
+		if err != nil {
+			protocol.WriteError(clientConn, err.Error())
+			return nil
+		}
+	}
+
+	protocol.WriteInteger(clientConn, count)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// writeZPopReply replicates a pop as ZREM and replies with the flat
+// member/score pairs
+func writeZPopReply(srv *server.Server, clientConn net.Conn, key string, popped []database.ZMember) {
+	if len(popped) > 0 {
+		command := []string{"ZREM", key}
+		for _, m := range popped {
+			command = append(command, m.Member)
+		}
+		srv.ReplicateCommand(clientConn, command)
+	}
+	writeZMembers(clientConn, popped, true)
+}
+
+// ZPopHandler handles ZPOPMIN and ZPOPMAX commands
+type ZPopHandler struct {
+	name   string // Command name used in errors and logs
+	max    bool   // Whether the highest scores are popped
+	logger *logging.Logger
+}
+
+func (h *ZPopHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger(h.name)
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 1 || len(args) > 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for '"+h.name+"' command")
+		return nil
+	}
+
+	count := 1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			protocol.WriteError(clientConn, "ERR value is out of range, must be positive")
+			return nil
+		}
+		count = n
+	}
+
+	popped, err := database.ZSetPop(args[0], count, h.max)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	writeZPopReply(srv, clientConn, args[0], popped)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// parseBlockTimeout parses the timeout of a blocking command in seconds,
+// where 0 means block forever
+func parseBlockTimeout(s string) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return 0, errors.New("ERR timeout is not a float or out of range")
+	}
+	if seconds < 0 {
+		return 0, errors.New("ERR timeout is negative")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// BZPopHandler handles BZPOPMIN and BZPOPMAX commands. The client is woken
+// through database.WaitForKeys when a member is added to one of its keys
+// rather than by polling.
+type BZPopHandler struct {
+	name   string // Command name used in errors and logs
+	max    bool   // Whether the highest score is popped
+	logger *logging.Logger
+}
+
+func (h *BZPopHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger(h.name)
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for '"+h.name+"' command")
+		return nil
+	}
+
+	keys := args[:len(args)-1]
+	timeout, err := parseBlockTimeout(args[len(args)-1])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	// Register before the first attempt so an add in between still wakes us.
+	ready, cancel := database.WaitForKeys(keys)
+	defer cancel()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	blocked := newBlockedClient(srv, clientConn)
+	defer blocked.Stop()
+
+	for {
+		for _, key := range keys {
+			end := beginBlockedWrite(srv, clientConn, true)
+			popped, err := database.ZSetPop(key, 1, h.max)
+			if len(popped) > 0 {
+				srv.ReplicateCommand(clientConn, []string{"ZREM", key, popped[0].Member})
+			}
+			end()
+			if err != nil {
+				protocol.WriteError(clientConn, err.Error())
+				return nil
+			}
+			if len(popped) == 0 {
+				continue
+			}
+
+			protocol.WriteArray(clientConn, []string{key, popped[0].Member, database.FormatScore(popped[0].Score)})
+			h.logger.Success("Command completed successfully")
+			return nil
+		}
+
+		switch blocked.wait(ready, expired) {
+		case waitExpired:
+			h.logger.Debug("Timed out waiting on %v", keys)
+			clientConn.Write([]byte("*-1\r\n"))
+			return nil
+		case waitGone:
+			h.logger.Debug("Client disconnected while waiting on %v", keys)
+			return nil
+		}
+	}
+}
+
+// ZSetOpHandler handles ZUNION, ZINTER, ZDIFF and their STORE variants. The
+// STORE forms write the result to args[0] and are the only ones replicated.
+type ZSetOpHandler struct {
+	name   string
+	op     database.SetOp
+	store  bool
+	logger *logging.Logger
+}
+
+// zsetOpQuery is a parsed ZUNION/ZINTER/ZDIFF request
+type zsetOpQuery struct {
+	keys       []string
+	weights    []float64
+	aggregate  database.ZAggregate
+	withScores bool
+}
+
+// parse reads "numkeys key [key ...]" followed by WEIGHTS, AGGREGATE (not
+// for ZDIFF) and WITHSCORES (not for the STORE forms)
+func (h *ZSetOpHandler) parse(args []string) (zsetOpQuery, error) {
+	var q zsetOpQuery
+
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil {
+		return q, errors.New("ERR value is not an integer or out of range")
+	}
+	if numKeys <= 0 {
+		return q, errors.New("ERR at least 1 input key is needed for '" + strings.ToLower(h.name) + "' command")
+	}
+	if numKeys > len(args)-1 {
+		return q, errors.New("ERR syntax error")
+	}
+	q.keys = args[1 : 1+numKeys]
+
+	combines := h.op != database.SetOpDiff
+	for i := 1 + numKeys; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case combines && opt == "WEIGHTS" && i+numKeys < len(args):
+			q.weights = make([]float64, numKeys)
+			for j := range q.weights {
+				weight, err := strconv.ParseFloat(args[i+1+j], 64)
+				if err != nil || math.IsNaN(weight) {
+					return q, errors.New("ERR weight value is not a float")
+				}
+				q.weights[j] = weight
+			}
+			i += numKeys
+		case combines && opt == "AGGREGATE" && i+1 < len(args):
+			switch strings.ToUpper(args[i+1]) {
+			case "SUM":
+				q.aggregate = database.ZAggregateSum
+			case "MIN":
+				q.aggregate = database.ZAggregateMin
+			case "MAX":
+				q.aggregate = database.ZAggregateMax
+			default:
+				return q, errors.New("ERR syntax error")
+			}
+			i++
+		case !h.store && opt == "WITHSCORES":
+			q.withScores = true
+		default:
+			return q, errors.New("ERR syntax error")
+		}
+	}
+	return q, nil
+}
+
+func (h *ZSetOpHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger(h.name)
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	queryArgs := args
+	if h.store {
+		queryArgs = args[1:]
+	}
+	if len(queryArgs) < 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for '"+h.name+"' command")
+		return nil
+	}
+
+	q, err := h.parse(queryArgs)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	members, err := database.ZSetCombine(h.op, q.keys, q.weights, q.aggregate)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	if !h.store {
+		writeZMembers(clientConn, members, q.withScores)
+		h.logger.Success("Command completed successfully")
+		return nil
+	}
+
+	count := database.ZSetStore(args[0], members)
+	replicateStore(srv, clientConn, append([]string{h.name}, args...), args[0], zaddCommand(args[0], members))
+
+	h.logger.Debug("Stored %d members in %s", count, args[0])
+	protocol.WriteInteger(clientConn, count)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// ZMScoreHandler handles ZMSCORE commands
+type ZMScoreHandler struct {
+	logger *logging.Logger
+}
+
+func (h *ZMScoreHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("ZMSCORE")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'ZMSCORE' command")
+		return nil
+	}
+
+	scores, found, err := database.ZSetMultiScore(args[0], args[1:])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	elements := make([]string, len(scores))
+	for i, score := range scores {
+		if found[i] {
+			elements[i] = protocol.FormatBulkString(database.FormatScore(score))
+		} else {
+			elements[i] = "$-1\r\n"
+		}
+	}
+	protocol.WriteArray2(clientConn, elements)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// ZRandMemberHandler handles ZRANDMEMBER commands
+type ZRandMemberHandler struct {
+	logger *logging.Logger
+}
+
+func (h *ZRandMemberHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("ZRANDMEMBER")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 1 || len(args) > 3 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'ZRANDMEMBER' command")
+		return nil
+	}
+
+	// Without a count a single member is returned as a bulk string.
+	if len(args) == 1 {
+		members, err := database.ZSetRandomMembers(args[0], 1)
+		if err != nil {
+			protocol.WriteError(clientConn, err.Error())
+			return nil
+		}
+		if len(members) == 0 {
+			clientConn.Write([]byte("$-1\r\n"))
+			return nil
+		}
+		protocol.WriteBulkString(clientConn, members[0].Member)
+		return nil
+	}
+
+	count, err := strconv.Atoi(args[1])
+	if err != nil {
+		protocol.WriteError(clientConn, "ERR value is not an integer or out of range")
+		return nil
+	}
+	withScores := len(args) == 3
+	if withScores && strings.ToUpper(args[2]) != "WITHSCORES" {
+		protocol.WriteError(clientConn, "ERR syntax error")
+		return nil
+	}
+
+	members, err := database.ZSetRandomMembers(args[0], count)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	writeZMembers(clientConn, members, withScores)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// ZScanHandler handles ZSCAN commands
+type ZScanHandler struct {
+	logger *logging.Logger
+}
+
+func (h *ZScanHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger("ZSCAN")
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) < 2 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for 'ZSCAN' command")
+		return nil
+	}
+
+	opts, err := parseScanOptions(args[1:], false)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	members, next, err := database.ZSetScan(args[0], opts.cursor, opts.count)
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	reply := make([]string, 0, len(members)*2)
+	for _, m := range members {
+		if opts.matches(m.Member) {
+			reply = append(reply, m.Member, database.FormatScore(m.Score))
+		}
+	}
+
+	writeScanReply(clientConn, next, reply)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
+
+// ZRemRangeHandler handles ZREMRANGEBYRANK, ZREMRANGEBYSCORE and
+// ZREMRANGEBYLEX commands
+type ZRemRangeHandler struct {
+	name   string     // Command name used in errors and logs
+	kind   zrangeKind // How the bounds are interpreted
+	logger *logging.Logger
+}
+
+// remove parses the bounds according to the handler's kind and removes the
+// members between them
+func (h *ZRemRangeHandler) remove(key, min, max string) (int, error) {
+	switch h.kind {
+	case zrangeByScore:
+		r, err := parseScoreRange(min, max)
+		if err != nil {
+			return 0, err
+		}
+		return database.ZSetRemoveRangeByScore(key, r)
+	case zrangeByLex:
+		r, empty, err := parseLexRange(min, max)
+		if err != nil || empty {
+			return 0, err
+		}
+		return database.ZSetRemoveRangeByLex(key, r)
+	default:
+		start, err1 := strconv.Atoi(min)
+		stop, err2 := strconv.Atoi(max)
+		if err1 != nil || err2 != nil {
+			return 0, errors.New("ERR value is not an integer or out of range")
+		}
+		return database.ZSetRemoveRangeByRank(key, start, stop)
+	}
+}
+
+func (h *ZRemRangeHandler) Handle(srv *server.Server, clientConn net.Conn, args []string) error {
+	if h.logger == nil {
+		h.logger = logging.NewLogger(h.name)
+	}
+
+	h.logger.Info("Command received from %s with args: %v", clientConn.RemoteAddr(), args)
+
+	if len(args) != 3 {
+		protocol.WriteError(clientConn, "ERR wrong number of arguments for '"+h.name+"' command")
+		return nil
+	}
+
+	removed, err := h.remove(args[0], args[1], args[2])
+	if err != nil {
+		protocol.WriteError(clientConn, err.Error())
+		return nil
+	}
+
+	if removed > 0 {
+		srv.ReplicateCommand(clientConn, append([]string{h.name}, args...))
+	}
+
+	h.logger.Debug("Removed %d members from %s", removed, args[0])
+	protocol.WriteInteger(clientConn, removed)
+	h.logger.Success("Command completed successfully")
+	return nil
+}
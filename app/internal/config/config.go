@@ -3,7 +3,17 @@ package config
 import (
 	"flag"
 	"fmt"
+	"path/filepath"
 	"strings"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
+)
+
+// Propagation modes of *STORE commands, see Config.StorePropagation
+const (
+	PropagateVerbatim = "verbatim" // Replicate the command as received
+	PropagateEffects  = "effects"  // Replicate the stored result
 )
 
 type Config struct {
@@ -13,6 +23,79 @@ type Config struct {
 	Port          string
 	Role          string
 	MasterAddress string
+	MasterUser    string // ACL user for AUTH against the master (optional)
+	MasterAuth    string // Password for AUTH against the master (optional)
+	// PubSubHistoryLen is how many messages each channel retains for
+	// SUBSCRIBEHISTORY (0 disables retention)
+	PubSubHistoryLen int
+	AuditLog         bool   // Whether admin/write commands are written to the audit log
+	AuditLogFile     string // Path of the JSON-lines audit log
+	// EnableDebugCommand allows the DEBUG command, whose hooks can corrupt
+	// replication state and are meant for tests only
+	EnableDebugCommand bool
+	// Per-stream XADD limits; 0 means unlimited
+	StreamMaxEntryFields int // Field/value pairs per entry
+	StreamMaxEntrySize   int // Bytes of field names and values per entry
+	StreamMaxMemory      int // Bytes of field names and values per stream
+	// Commands running at least SlowlogLogSlowerThan microseconds are kept in
+	// the slowlog (negative disables it), up to SlowlogMaxLen entries
+	SlowlogLogSlowerThan int
+	SlowlogMaxLen        int
+	// StorePropagation is how SINTERSTORE, ZUNIONSTORE and the other *STORE
+	// commands reach replicas: PropagateVerbatim re-runs the command there,
+	// PropagateEffects sends the result so replicas converge even if they
+	// would compute it differently
+	StorePropagation string
+	// ReplicaLazyFlush leaves the old dataset to be garbage collected in the
+	// background during a full resync instead of reclaiming it before the
+	// master's RDB is loaded
+	ReplicaLazyFlush bool
+	// ReplLogSample logs the propagation of one in every ReplLogSample
+	// replicated commands, on the master and on replicas (0 disables it)
+	ReplLogSample int
+	// ReplBatchMicros holds propagated commands this long so each replica
+	// gets them in one write (0 writes every command as it comes)
+	ReplBatchMicros int
+	// ReplBacklogSize is how many of the latest bytes of the replication
+	// stream a master keeps for replicas to resume from after a lost link
+	ReplBacklogSize int // Bytes
+	// LogLevel is the minimum severity logged: debug, info, error or none
+	LogLevel string
+	// LogFile is where logs are written, standard output when empty
+	LogFile string
+	// LogFormat is the layout of log lines: default, or redis for the
+	// "pid:role date level message" lines of Redis
+	LogFormat string
+	// UnknownCommandSuggestions adds a "did you mean" hint, the closest
+	// registered command, to unknown command errors
+	UnknownCommandSuggestions bool
+	// MultiAllowWait lets WAIT be queued in a transaction, where EXEC runs
+	// it without blocking; otherwise it is refused at queue time
+	MultiAllowWait bool
+	// LockProfiling times how long commands wait for the locks of stored
+	// values, for DEBUG LOCKSTATS and INFO lockstats
+	LockProfiling bool
+	// ActiveDefrag rebuilds lists, hashes and sets left with much more
+	// capacity than elements in the background, as MEMORY PURGE does
+	ActiveDefrag bool
+	// ShutdownTimeout is how long a master shutting down waits for its
+	// replicas to acknowledge the final replication offset (0 to not wait)
+	ShutdownTimeout int // Seconds
+	// ClusterConfigFile is the static cluster topology file; setting it
+	// enables cluster mode (slot routing with MOVED redirects)
+	ClusterConfigFile string
+	// EventLoop serves clients from an epoll event loop instead of one
+	// goroutine per connection (experimental, Linux only)
+	EventLoop bool
+	// ProtoMaxBulkLen is the longest bulk string a client may send, and the
+	// largest string APPEND, SETRANGE and SETBIT may produce
+	ProtoMaxBulkLen int // Bytes
+	// NotifyKeyspaceEvents selects the keyspace events published to Pub/Sub,
+	// with the characters of Redis' notify-keyspace-events ("" for none)
+	NotifyKeyspaceEvents string
+	// CheckRDB / CheckAOF name a file to validate instead of starting the server
+	CheckRDB string
+	CheckAOF string
 }
 
 func LoadConfig() *Config {
@@ -20,6 +103,36 @@ func LoadConfig() *Config {
 	dbfilename := flag.String("dbfilename", "", "Database file name")
 	port := flag.Int("port", 6379, "Port to run the server on")
 	replicaof := flag.String("replicaof", "", "Master address if this is a replica (format: host port)")
+	masterUser := flag.String("masteruser", "", "User to authenticate as against the master")
+	masterAuth := flag.String("masterauth", "", "Password to authenticate with against the master")
+	auditLog := flag.Bool("audit-log", false, "Write admin and write commands to the audit log")
+	auditLogFile := flag.String("audit-logfile", "audit.log", "Path of the JSON-lines audit log")
+	enableDebug := flag.Bool("enable-debug-command", false, "Allow the DEBUG command (test-only replication hooks)")
+	streamMaxEntryFields := flag.Int("stream-max-entry-fields", 0, "Maximum field/value pairs per stream entry (0 = unlimited)")
+	streamMaxEntrySize := flag.Int("stream-max-entry-size", 0, "Maximum bytes of fields and values per stream entry (0 = unlimited)")
+	streamMaxMemory := flag.Int("stream-max-memory", 0, "Maximum bytes of fields and values held by one stream (0 = unlimited)")
+	checkRDB := flag.String("check-rdb", "", "Validate an RDB file, print a report and exit")
+	checkAOF := flag.String("check-aof", "", "Validate an AOF file, print a report and exit")
+	slowlogLogSlowerThan := flag.Int("slowlog-log-slower-than", 10000, "Log commands taking at least this many microseconds in the slowlog (negative disables)")
+	slowlogMaxLen := flag.Int("slowlog-max-len", 128, "Maximum number of slowlog entries")
+	storePropagation := flag.String("store-propagation", PropagateVerbatim, "How *STORE commands are replicated: verbatim or effects")
+	replicaLazyFlush := flag.Bool("replica-lazy-flush", false, "On full resync, reclaim the old dataset in the background instead of before loading the master's RDB")
+	replLogSample := flag.Int("repl-log-sample", 0, "Log the propagation of one in every N replicated commands (0 = never, 1 = all)")
+	replBatchMicros := flag.Int("repl-batch-usec", 0, "Microseconds propagated commands are held to be written to each replica together (0 = write each one)")
+	replBacklogSize := flag.Int("repl-backlog-size", 1024*1024, "Bytes of the replication stream kept for replicas to resume from after a lost link (at least 16384)")
+	logLevel := flag.String("loglevel", "error", "Minimum severity logged: debug, info, error or none")
+	logFile := flag.String("logfile", "", "File to append logs to (default: standard output)")
+	logFormat := flag.String("log-format", "default", "Layout of log lines: default, or redis for Redis' pid:role date level message lines")
+	unknownCommandSuggestions := flag.Bool("unknown-command-suggestions", true, "Suggest the closest command in unknown command errors")
+	multiAllowWait := flag.Bool("multi-allow-wait", false, "Allow WAIT inside MULTI, where EXEC runs it without blocking")
+	lockProfiling := flag.Bool("lock-profiling", false, "Time waits for the locks of stored values (DEBUG LOCKSTATS, INFO lockstats)")
+	activeDefrag := flag.Bool("activedefrag", false, "Compact lists, hashes and sets with much dead capacity in the background")
+	shutdownTimeout := flag.Int("shutdown-timeout", 10, "Seconds a master shutting down waits for replicas to acknowledge the final offset (0 = don't wait)")
+	clusterConfigFile := flag.String("cluster-config-file", "", "Static cluster topology file; enables cluster mode (created if missing)")
+	eventLoop := flag.Bool("event-loop", false, "Experimental: serve clients from an epoll event loop instead of one goroutine per connection (Linux only)")
+	pubsubHistoryLen := flag.Int("pubsub-history-len", 0, "Messages retained per Pub/Sub channel for SUBSCRIBEHISTORY (0 disables)")
+	protoMaxBulkLen := flag.Int("proto-max-bulk-len", protocol.DefaultMaxBulkLen, "Longest bulk string a client may send, in bytes (at least 1MB)")
+	notifyKeyspaceEvents := flag.String("notify-keyspace-events", "", "Keyspace events published to Pub/Sub, e.g. Ex for expired events on __keyevent@0__:expired (\"\" = none)")
 
 	flag.Parse()
 
@@ -29,6 +142,67 @@ func LoadConfig() *Config {
 		HostName:   "localhost",
 		Port:       fmt.Sprintf("%d", *port),
 		Role:       "master",
+		MasterUser: *masterUser,
+		MasterAuth: *masterAuth,
+
+		PubSubHistoryLen: *pubsubHistoryLen,
+		AuditLog:         *auditLog,
+		AuditLogFile:     *auditLogFile,
+
+		EnableDebugCommand: *enableDebug,
+
+		StreamMaxEntryFields: *streamMaxEntryFields,
+		StreamMaxEntrySize:   *streamMaxEntrySize,
+		StreamMaxMemory:      *streamMaxMemory,
+
+		SlowlogLogSlowerThan: *slowlogLogSlowerThan,
+		SlowlogMaxLen:        *slowlogMaxLen,
+
+		StorePropagation: *storePropagation,
+		ReplicaLazyFlush: *replicaLazyFlush,
+		ReplLogSample:    *replLogSample,
+		ReplBatchMicros:  *replBatchMicros,
+		ReplBacklogSize:  *replBacklogSize,
+		LogLevel:         *logLevel,
+		LogFile:          *logFile,
+		LogFormat:        *logFormat,
+		EventLoop:        *eventLoop,
+
+		UnknownCommandSuggestions: *unknownCommandSuggestions,
+		ClusterConfigFile:         *clusterConfigFile,
+		MultiAllowWait:            *multiAllowWait,
+		ShutdownTimeout:           *shutdownTimeout,
+		LockProfiling:             *lockProfiling,
+		ActiveDefrag:              *activeDefrag,
+		ProtoMaxBulkLen:           *protoMaxBulkLen,
+		NotifyKeyspaceEvents:      *notifyKeyspaceEvents,
+
+		CheckRDB: *checkRDB,
+		CheckAOF: *checkAOF,
+	}
+
+	if !ValidStorePropagation(config.StorePropagation) {
+		panic("Invalid --store-propagation, expected: verbatim or effects")
+	}
+
+	if _, ok := logging.ParseLevel(config.LogLevel); !ok {
+		panic("Invalid --loglevel, expected: debug, info, error or none")
+	}
+
+	if _, ok := logging.ParseFormat(config.LogFormat); !ok {
+		panic("Invalid --log-format, expected: default or redis")
+	}
+
+	if config.ReplBatchMicros < 0 {
+		panic("Invalid --repl-batch-usec, expected a non-negative number of microseconds")
+	}
+
+	if config.ReplBacklogSize < 16*1024 {
+		panic("Invalid --repl-backlog-size, expected at least 16384 bytes")
+	}
+
+	if config.ProtoMaxBulkLen < protocol.MinMaxBulkLen {
+		panic("Invalid --proto-max-bulk-len, expected at least 1048576 bytes")
 	}
 
 	if *replicaof != "" {
@@ -43,6 +217,11 @@ func LoadConfig() *Config {
 	return config
 }
 
+// ValidStorePropagation reports whether mode is a known propagation mode
+func ValidStorePropagation(mode string) bool {
+	return mode == PropagateVerbatim || mode == PropagateEffects
+}
+
 func (c *Config) IsMaster() bool {
 	return c.Role == "master"
 }
@@ -51,6 +230,11 @@ func (c *Config) IsSlave() bool {
 	return c.Role == "slave"
 }
 
+// RDBPath returns where the RDB file is loaded from and saved to
+func (c *Config) RDBPath() string {
+	return filepath.Join(c.Directory, c.DBFileName)
+}
+
 func (c *Config) GetListenAddress() string {
 	return fmt.Sprintf("%s:%s", c.HostName, c.Port)
 }
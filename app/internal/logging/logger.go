@@ -2,9 +2,133 @@ package logging
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
+// Level is the minimum severity that gets printed
+type Level int32
+
+const (
+	LevelDebug Level = iota // Everything, including Debug and Network traffic
+	LevelInfo               // Info, Success and Error
+	LevelError              // Errors only
+	LevelNone               // Nothing
+)
+
+var levelNames = []string{"debug", "info", "error", "none"}
+
+// level is shared by every logger; the zero value keeps the historical
+// behaviour of printing everything
+var level atomic.Int32
+
+// SetLevel changes the level of every logger
+func SetLevel(l Level) {
+	level.Store(int32(l))
+}
+
+// CurrentLevel returns the level set by SetLevel
+func CurrentLevel() Level {
+	return Level(level.Load())
+}
+
+// ParseLevel parses a level name as accepted by --loglevel
+func ParseLevel(name string) (Level, bool) {
+	for i, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return Level(i), true
+		}
+	}
+	return 0, false
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// enabled is checked before formatting anything, so a suppressed line
+// costs one atomic load
+func enabled(l Level) bool {
+	return Level(level.Load()) <= l
+}
+
+// Enabled reports whether lines of level l are printed. A suppressed call
+// still boxes its arguments, an allocation each, so the hot paths check
+// this first.
+func Enabled(l Level) bool {
+	return enabled(l)
+}
+
+// Format is the layout of log lines
+type Format int32
+
+const (
+	FormatDefault Format = iota // "[15:04:05.000] [COMPONENT] message", with a tag for non-info lines
+	FormatRedis                 // "pid:role dd Mon yyyy hh:mm:ss.mmm level message", as Redis writes
+)
+
+var formatNames = []string{"default", "redis"}
+
+var format atomic.Int32
+
+// SetFormat changes the layout of every logger's lines
+func SetFormat(f Format) {
+	format.Store(int32(f))
+}
+
+// CurrentFormat returns the format set by SetFormat
+func CurrentFormat() Format {
+	return Format(format.Load())
+}
+
+// ParseFormat parses a format name as accepted by --log-format
+func ParseFormat(name string) (Format, bool) {
+	for i, n := range formatNames {
+		if strings.EqualFold(name, n) {
+			return Format(i), true
+		}
+	}
+	return 0, false
+}
+
+func (f Format) String() string {
+	return formatNames[f]
+}
+
+// role is the role character of FormatRedis lines: 'M' for a master, 'S'
+// for a replica
+var role atomic.Int32
+
+func init() {
+	role.Store('M')
+}
+
+// SetRole sets the role character of FormatRedis lines
+func SetRole(r byte) {
+	role.Store(int32(r))
+}
+
+var pid = os.Getpid()
+
+// sink wraps the destination so it can be swapped atomically
+type sink struct {
+	w io.Writer
+}
+
+var output atomic.Pointer[sink]
+
+func init() {
+	output.Store(&sink{w: os.Stdout})
+}
+
+// SetOutput sends every logger's lines to w instead of standard output
+func SetOutput(w io.Writer) {
+	output.Store(&sink{w: w})
+}
+
 type Logger struct {
 	component string
 }
@@ -13,38 +137,67 @@ func NewLogger(component string) *Logger {
 	return &Logger{component: component}
 }
 
+// write formats one line and writes it with a single call, so lines from
+// concurrent loggers don't interleave. tag marks the severity in
+// FormatDefault and mark in FormatRedis, whose marks are Redis' own: '.'
+// debug, '*' notice and '#' warning.
+func (l *Logger) write(mark byte, tag, message string, args []interface{}) {
+	now := time.Now()
+	var line string
+	if CurrentFormat() == FormatRedis {
+		line = fmt.Sprintf("%d:%c %s %c [%s] ", pid, role.Load(), now.Format("02 Jan 2006 15:04:05.000"), mark, l.component)
+	} else {
+		line = fmt.Sprintf("[%s] [%s] ", now.Format("15:04:05.000"), l.component)
+		if tag != "" {
+			line += tag + " "
+		}
+	}
+	line += fmt.Sprintf(message, args...) + "\n"
+	output.Load().w.Write([]byte(line))
+}
+
 func (l *Logger) Info(message string, args ...interface{}) {
-	timestamp := time.Now().Format("15:04:05.000")
-	prefix := fmt.Sprintf("[%s] [%s]", timestamp, l.component)
-	fmt.Printf(prefix+" "+message+"\n", args...)
+	if !enabled(LevelInfo) {
+		return
+	}
+	l.write('*', "", message, args)
 }
 
 func (l *Logger) Error(message string, args ...interface{}) {
-	timestamp := time.Now().Format("15:04:05.000")
-	prefix := fmt.Sprintf("[%s] [%s] ❌ ERROR:", timestamp, l.component)
-	fmt.Printf(prefix+" "+message+"\n", args...)
+	if !enabled(LevelError) {
+		return
+	}
+	l.write('#', "❌ ERROR:", message, args)
 }
 
 func (l *Logger) Success(message string, args ...interface{}) {
-	timestamp := time.Now().Format("15:04:05.000")
-	prefix := fmt.Sprintf("[%s] [%s] ✅ SUCCESS:", timestamp, l.component)
-	fmt.Printf(prefix+" "+message+"\n", args...)
+	if !enabled(LevelInfo) {
+		return
+	}
+	l.write('*', "✅ SUCCESS:", message, args)
 }
 
 func (l *Logger) Debug(message string, args ...interface{}) {
-	timestamp := time.Now().Format("15:04:05.000")
-	prefix := fmt.Sprintf("[%s] [%s] 🔍 DEBUG:", timestamp, l.component)
-	fmt.Printf(prefix+" "+message+"\n", args...)
+	if !enabled(LevelDebug) {
+		return
+	}
+	l.write('.', "🔍 DEBUG:", message, args)
 }
 
 func (l *Logger) Network(direction, message string, args ...interface{}) {
-	timestamp := time.Now().Format("15:04:05.000")
-	arrow := "📤 OUT"
+	if !enabled(LevelDebug) {
+		return
+	}
+	if CurrentFormat() == FormatRedis {
+		// Redis lines have no tag, so the direction leads the message
+		l.write('.', "", direction+": "+message, args)
+		return
+	}
+	arrow := "📤 OUT:"
 	if direction == "IN" {
-		arrow = "📥 IN"
+		arrow = "📥 IN:"
 	}
-	prefix := fmt.Sprintf("[%s] [%s] %s:", timestamp, l.component, arrow)
-	fmt.Printf(prefix+" "+message+"\n", args...)
+	l.write('.', arrow, message, args)
 }
 
 // Global logging functions for backward compatibility
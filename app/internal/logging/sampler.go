@@ -0,0 +1,38 @@
+package logging
+
+import "sync/atomic"
+
+// Sampler lets one call in every N through, so a hot path such as command
+// propagation can stay observable without its log output costing more than
+// the work being logged
+type Sampler struct {
+	every atomic.Int64
+	calls atomic.Int64
+}
+
+// NewSampler creates a sampler letting one call in every through
+func NewSampler(every int) *Sampler {
+	s := &Sampler{}
+	s.SetEvery(every)
+	return s
+}
+
+// Sample reports whether this call should be logged. It is always false
+// when the rate is 0 or less and always true when it is 1.
+func (s *Sampler) Sample() bool {
+	every := s.every.Load()
+	if every <= 0 {
+		return false
+	}
+	return (s.calls.Add(1)-1)%every == 0
+}
+
+// Every returns the sampling rate
+func (s *Sampler) Every() int {
+	return int(s.every.Load())
+}
+
+// SetEvery changes the sampling rate
+func (s *Sampler) SetEvery(every int) {
+	s.every.Store(int64(every))
+}
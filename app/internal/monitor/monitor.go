@@ -0,0 +1,106 @@
+// Package monitor streams executed commands to clients that issued MONITOR.
+package monitor
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+)
+
+// Feed holds the connections in monitor mode
+type Feed struct {
+	conns  map[net.Conn]struct{}
+	logger *logging.Logger
+	mutex  sync.RWMutex
+}
+
+// NewFeed creates a feed without monitors
+func NewFeed() *Feed {
+	return &Feed{
+		conns:  make(map[net.Conn]struct{}),
+		logger: logging.NewLogger("MONITOR"),
+	}
+}
+
+// Add puts conn in monitor mode
+func (f *Feed) Add(conn net.Conn) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.conns[conn] = struct{}{}
+}
+
+// Remove takes conn out of monitor mode, e.g. when it disconnects
+func (f *Feed) Remove(conn net.Conn) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.conns, conn)
+}
+
+// Active reports whether any connection is in monitor mode
+func (f *Feed) Active() bool {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return len(f.conns) > 0
+}
+
+// Broadcast writes one executed command to every monitor in the Redis
+// format: +<unix time> [0 <addr>] "cmd" "arg" ...
+func (f *Feed) Broadcast(addr string, args []string) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	if len(f.conns) == 0 {
+		return
+	}
+	frame := []byte(formatLine(time.Now(), addr, args))
+	for conn := range f.conns {
+		if _, err := conn.Write(frame); err != nil {
+			f.logger.Error("Failed to feed monitor %s: %v", conn.RemoteAddr(), err)
+		}
+	}
+}
+
+func formatLine(t time.Time, addr string, args []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "+%d.%06d [0 %s]", t.Unix(), t.Nanosecond()/1000, addr)
+	for _, arg := range args {
+		b.WriteString(" ")
+		b.WriteString(quote(arg))
+	}
+	b.WriteString("\r\n")
+	return b.String()
+}
+
+// quote renders an argument like Redis' sdscatrepr. The result never
+// contains CR or LF, which would break the simple-string frame.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\', '"':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if c < 0x20 || c >= 0x7f {
+				b.WriteString(`\x`)
+				b.WriteString(strconv.FormatUint(uint64(c)|0x100, 16)[1:])
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
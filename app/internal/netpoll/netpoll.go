@@ -0,0 +1,15 @@
+// Package netpoll is the readiness notifier behind the experimental event
+// loop connection mode (--event-loop). Instead of parking one goroutine per
+// connection in Read, connections are watched by a single epoll instance and
+// a goroutine is only started once a connection has input, so idle clients
+// cost no goroutine stack.
+//
+// Watches are one-shot: after onReady fires it is not called again until the
+// watch is re-armed, which lets the caller drain the connection without
+// racing another notification.
+package netpoll
+
+import "errors"
+
+// ErrUnsupported is returned by New on platforms without epoll
+var ErrUnsupported = errors.New("netpoll: the event loop needs Linux epoll")
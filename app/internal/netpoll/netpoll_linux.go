@@ -0,0 +1,140 @@
+//go:build linux
+
+package netpoll
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+)
+
+// watchEvents are the epoll events of an armed watch. EPOLLRDHUP makes a
+// peer closing its side count as readiness so the disconnect is noticed.
+const watchEvents = syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT
+
+// maxEvents is how many ready connections one epoll_wait call returns
+const maxEvents = 256
+
+// Poller watches many connections with one epoll instance
+type Poller struct {
+	epfd    int
+	watches map[int]*Watch // fd -> watch
+	logger  *logging.Logger
+	mutex   sync.Mutex
+}
+
+// Watch is one connection registered with a Poller
+type Watch struct {
+	poller  *Poller
+	fd      int
+	onReady func(*Watch)
+}
+
+// New creates a poller and starts its wait loop
+func New() (*Poller, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	p := &Poller{
+		epfd:    epfd,
+		watches: make(map[int]*Watch),
+		logger:  logging.NewLogger("NETPOLL"),
+	}
+	go p.run()
+	return p, nil
+}
+
+// Watch registers conn and arms it: the next time conn has input or is
+// closed by the peer, onReady runs in a new goroutine with the watch to
+// re-arm or stop. conn must expose its file descriptor, as *net.TCPConn does.
+func (p *Poller) Watch(conn net.Conn, onReady func(*Watch)) (*Watch, error) {
+	fd, err := connFD(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Watch{poller: p, fd: fd, onReady: onReady}
+	p.mutex.Lock()
+	p.watches[fd] = w
+	p.mutex.Unlock()
+
+	event := syscall.EpollEvent{Events: watchEvents, Fd: int32(fd)}
+	if err := syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_ADD, fd, &event); err != nil {
+		p.mutex.Lock()
+		delete(p.watches, fd)
+		p.mutex.Unlock()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Rearm waits for the next input after onReady has drained the connection.
+// Everything onReady did before Rearm happens before the next onReady runs.
+func (w *Watch) Rearm() error {
+	// Holding the lock the wait loop takes before starting onReady orders
+	// this call before the next onReady; the kernel alone is invisible to
+	// the Go memory model.
+	w.poller.mutex.Lock()
+	defer w.poller.mutex.Unlock()
+
+	event := syscall.EpollEvent{Events: watchEvents, Fd: int32(w.fd)}
+	return syscall.EpollCtl(w.poller.epfd, syscall.EPOLL_CTL_MOD, w.fd, &event)
+}
+
+// Stop unregisters the connection. It must be called before the connection
+// is closed, since the kernel may hand its descriptor to a new connection
+// right after.
+func (w *Watch) Stop() {
+	p := w.poller
+	syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_DEL, w.fd, nil)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.watches[w.fd] == w {
+		delete(p.watches, w.fd)
+	}
+}
+
+func (p *Poller) run() {
+	events := make([]syscall.EpollEvent, maxEvents)
+	for {
+		n, err := syscall.EpollWait(p.epfd, events, -1)
+		if err != nil {
+			if errors.Is(err, syscall.EINTR) {
+				continue
+			}
+			p.logger.Error("epoll_wait failed, event loop stopped: %v", err)
+			return
+		}
+
+		p.mutex.Lock()
+		for _, event := range events[:n] {
+			if w, ok := p.watches[int(event.Fd)]; ok {
+				go w.onReady(w)
+			}
+		}
+		p.mutex.Unlock()
+	}
+}
+
+// connFD returns the descriptor behind conn. It stays valid until conn is
+// closed, which callers order after Stop.
+func connFD(conn net.Conn) (int, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return 0, errors.New("netpoll: connection has no file descriptor")
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	fd := -1
+	if err := raw.Control(func(f uintptr) { fd = int(f) }); err != nil {
+		return 0, err
+	}
+	return fd, nil
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package netpoll
+
+import "net"
+
+// Poller is unavailable outside Linux; New always fails
+type Poller struct{}
+
+// Watch is unavailable outside Linux
+type Watch struct{}
+
+func New() (*Poller, error) {
+	return nil, ErrUnsupported
+}
+
+func (p *Poller) Watch(conn net.Conn, onReady func(*Watch)) (*Watch, error) {
+	return nil, ErrUnsupported
+}
+
+func (w *Watch) Rearm() error {
+	return ErrUnsupported
+}
+
+func (w *Watch) Stop() {}
@@ -2,36 +2,93 @@ package protocol
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"net"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"unsafe"
 
 	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
 )
 
 var logger = logging.NewLogger("PROTOCOL")
 
-// ReadArrayArguments reads RESP array arguments from a connection
-func ReadArrayArguments(reader *bufio.Reader) ([]string, bool) {
+// Limits on what a client may send. Like in Redis, a header over them is a
+// protocol error: it is answered and the connection is closed, before any
+// memory is set aside for the payload.
+const (
+	// DefaultMaxBulkLen is the default of proto-max-bulk-len
+	DefaultMaxBulkLen = 512 << 20
+	// MinMaxBulkLen is the smallest proto-max-bulk-len accepted
+	MinMaxBulkLen = 1 << 20
+	// maxMultiBulkLen caps the number of arguments of one command
+	maxMultiBulkLen = 1024 * 1024
+	// bigBulkLen is the size above which bulk strings are read in chunks
+	bigBulkLen = 1 << 20
+)
+
+// maxBulkLen is the limit set with SetMaxBulkLen
+var maxBulkLen atomic.Int64
+
+func init() {
+	maxBulkLen.Store(DefaultMaxBulkLen)
+}
+
+// SetMaxBulkLen sets proto-max-bulk-len, the longest bulk string a client
+// may send
+func SetMaxBulkLen(n int) {
+	maxBulkLen.Store(int64(n))
+}
+
+// MaxBulkLen returns the limit set with SetMaxBulkLen
+func MaxBulkLen() int {
+	return int(maxBulkLen.Load())
+}
+
+// ProtocolError is a malformed or oversized request. The connection it came
+// from is no longer in sync and should be told and closed.
+type ProtocolError string
+
+func (e ProtocolError) Error() string {
+	return "ERR Protocol error: " + string(e)
+}
+
+// ReadArrayArguments reads RESP array arguments from a connection, with bulk
+// strings limited to proto-max-bulk-len
+func ReadArrayArguments(reader *bufio.Reader) ([]string, error) {
+	return readArrayArguments(reader, MaxBulkLen())
+}
+
+// ReadArrayArgumentsUnlimited reads RESP array arguments without limiting
+// the length of bulk strings, for the link to the master, which must not be
+// refused what its own clients were allowed to write
+func ReadArrayArgumentsUnlimited(reader *bufio.Reader) ([]string, error) {
+	return readArrayArguments(reader, 0)
+}
+
+// readArrayArguments reads one command; maxBulk 0 leaves bulk strings
+// unlimited
+func readArrayArguments(reader *bufio.Reader, maxBulk int) ([]string, error) {
 	// Read array header: *<count>\r\n
 	line, err := reader.ReadString('\n')
 	if err != nil {
 		logger.Debug("failed to read array header: %v", err)
-		return nil, false
+		return nil, err
 	}
 	line = strings.TrimSpace(line)
 
 	if !strings.HasPrefix(line, "*") {
 		logger.Debug("Invalid array prefix, expected '*', got: %s", line)
-		return nil, false
+		return nil, ProtocolError("expected '*', got '" + line + "'")
 	}
 
 	count, err := strconv.Atoi(line[1:])
-	if err != nil {
-		logger.Debug("invalid array length: %v", err)
-		return nil, false
+	if err != nil || count < 0 || count > maxMultiBulkLen {
+		logger.Debug("invalid array length: %s", line)
+		return nil, ProtocolError("invalid multibulk length")
 	}
 
 	args := make([]string, count)
@@ -41,19 +98,19 @@ func ReadArrayArguments(reader *bufio.Reader) ([]string, bool) {
 		lengthLine, err := reader.ReadString('\n')
 		if err != nil {
 			logger.Debug("failed to read bulk string length: %v", err)
-			return nil, false
+			return nil, err
 		}
 		lengthLine = strings.TrimSpace(lengthLine)
 
 		if !strings.HasPrefix(lengthLine, "$") {
 			logger.Debug("Invalid bulk string prefix, expected '$', got: %s", lengthLine)
-			return nil, false
+			return nil, ProtocolError("expected '$', got '" + lengthLine + "'")
 		}
 
 		length, err := strconv.Atoi(lengthLine[1:])
-		if err != nil {
-			logger.Debug("invalid bulk string length: %v", err)
-			return nil, false
+		if err != nil || (maxBulk > 0 && length > maxBulk) {
+			logger.Debug("invalid bulk string length: %s", lengthLine)
+			return nil, ProtocolError("invalid bulk length")
 		}
 
 		if length < 0 {
@@ -61,31 +118,96 @@ func ReadArrayArguments(reader *bufio.Reader) ([]string, bool) {
 			continue
 		}
 
-		// Read <length> bytes of content
-		buf := make([]byte, length)
-		if _, err := io.ReadFull(reader, buf); err != nil {
+		if args[i], err = readBulk(reader, length); err != nil {
 			logger.Debug("failed to read bulk string content: %v", err)
-			return nil, false
+			return nil, err
 		}
 
-		args[i] = string(buf)
-
 		// Read trailing \r\n
 		if _, err := reader.Discard(2); err != nil {
 			logger.Debug("failed to discard CRLF: %v", err)
-			return nil, false
+			return nil, err
+		}
+	}
+
+	return args, nil
+}
+
+// readBulk reads the length bytes of a bulk string's payload. Up to
+// bigBulkLen they are read in one go. Longer payloads are read into a rope
+// of bigBulkLen chunks, so memory is only taken for bytes that actually
+// arrived: a header announcing 512MB costs nothing until they are sent.
+// Once complete, the rope is joined into the string's single buffer, which
+// becomes the string without another copy, and the chunks are dropped as
+// they are copied.
+func readBulk(reader *bufio.Reader, length int) (string, error) {
+	if length <= bigBulkLen {
+		buf := make([]byte, length)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return "", err
 		}
+		return string(buf), nil
+	}
+
+	rope := make([][]byte, 0, (length+bigBulkLen-1)/bigBulkLen)
+	for left := length; left > 0; {
+		chunk := make([]byte, min(left, bigBulkLen))
+		if _, err := io.ReadFull(reader, chunk); err != nil {
+			return "", err
+		}
+		rope = append(rope, chunk)
+		left -= len(chunk)
+	}
+
+	data := make([]byte, 0, length)
+	for i, chunk := range rope {
+		data = append(data, chunk...)
+		rope[i] = nil
+	}
+	// data is never written again, so the string can share it
+	return unsafe.String(unsafe.SliceData(data), len(data)), nil
+}
+
+// okReply is shared by every +OK response instead of being formatted per call
+var okReply = []byte("+OK\r\n")
+
+// appendBulkString appends s as a RESP bulk string
+func appendBulkString(dst []byte, s string) []byte {
+	dst = append(dst, '$')
+	dst = strconv.AppendInt(dst, int64(len(s)), 10)
+	dst = append(dst, '\r', '\n')
+	dst = append(dst, s...)
+	return append(dst, '\r', '\n')
+}
+
+// appendArray appends elements as a RESP array of bulk strings
+func appendArray(dst []byte, elements []string) []byte {
+	dst = append(dst, '*')
+	dst = strconv.AppendInt(dst, int64(len(elements)), 10)
+	dst = append(dst, '\r', '\n')
+	for _, element := range elements {
+		dst = appendBulkString(dst, element)
 	}
+	return dst
+}
 
-	return args, true
+// arraySize is the encoded size of elements, so arrays are built with a
+// single allocation
+func arraySize(elements []string) int {
+	n := 16
+	for _, element := range elements {
+		n += len(element) + 16
+	}
+	return n
 }
 
 // WriteInteger writes a RESP integer response
 func WriteInteger(conn net.Conn, value int) error {
-	response := fmt.Sprintf(":%d\r\n", value)
-	logger.Debug("Writing integer response: %s", strings.ReplaceAll(response, "\r\n", "\\r\\n"))
+	response := strconv.AppendInt(append(make([]byte, 0, 24), ':'), int64(value), 10)
+	response = append(response, '\r', '\n')
+	logger.Debug("Writing integer response: :%d\\r\\n", value)
 
-	n, err := conn.Write([]byte(response))
+	n, err := conn.Write(response)
 	if err != nil {
 		logger.Error("Failed to write integer %d: %v", value, err)
 		return err
@@ -105,22 +227,27 @@ func WriteInteger(conn net.Conn, value int) error {
 
 // WriteSimpleString writes a RESP simple string response
 func WriteSimpleString(conn net.Conn, s string) {
-	response := fmt.Sprintf("+%s\r\n", s)
-	_, err := conn.Write([]byte(response))
+	response := okReply
+	if s != "OK" {
+		response = make([]byte, 0, len(s)+3)
+		response = append(response, '+')
+		response = append(response, s...)
+		response = append(response, '\r', '\n')
+	}
+	_, err := conn.Write(response)
 	if err != nil {
 		logger.Error("Failed to write simple string '%s': %v", s, err)
-	} else {
+	} else if logging.Enabled(logging.LevelDebug) {
 		logger.Debug("Wrote simple string: +%s", s)
 	}
 }
 
 // WriteBulkString writes a RESP bulk string response
 func WriteBulkString(conn net.Conn, s string) {
-	response := fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
-	_, err := conn.Write([]byte(response))
+	_, err := conn.Write(appendBulkString(make([]byte, 0, len(s)+16), s))
 	if err != nil {
 		logger.Error("Failed to write bulk string '%s': %v", s, err)
-	} else {
+	} else if logging.Enabled(logging.LevelDebug) {
 		logger.Debug("Wrote bulk string (%d bytes): %s", len(s), s)
 	}
 }
@@ -138,11 +265,7 @@ func WriteError(conn net.Conn, errMsg string) {
 
 // WriteArray writes a RESP array response
 func WriteArray(conn net.Conn, elements []string) {
-	response := fmt.Sprintf("*%d\r\n", len(elements))
-	for _, element := range elements {
-		response += fmt.Sprintf("$%d\r\n%s\r\n", len(element), element)
-	}
-	_, err := conn.Write([]byte(response))
+	_, err := conn.Write(appendArray(make([]byte, 0, arraySize(elements)), elements))
 	if err != nil {
 		logger.Error("Failed to write array %v: %v", elements, err)
 	} else {
@@ -166,11 +289,25 @@ func WriteArray2(conn net.Conn, elements []string) {
 
 // EncodeArray encodes an array of strings into RESP format
 func EncodeArray(elements []string) string {
-	response := fmt.Sprintf("*%d\r\n", len(elements))
+	return string(appendArray(make([]byte, 0, arraySize(elements)), elements))
+}
+
+// EncodedArrayLen returns len(EncodeArray(elements)) without encoding it
+func EncodedArrayLen(elements []string) int {
+	n := 1 + intLen(len(elements)) + 2
 	for _, element := range elements {
-		response += fmt.Sprintf("$%d\r\n%s\r\n", len(element), element)
+		n += 1 + intLen(len(element)) + 2 + len(element) + 2
 	}
-	return response
+	return n
+}
+
+func intLen(n int) int {
+	digits := 1
+	for n >= 10 {
+		n /= 10
+		digits++
+	}
+	return digits
 }
 
 // Format functions for building responses
@@ -187,9 +324,9 @@ func FormatSimpleString(s string) string {
 }
 
 func FormatBulkString(s string) string {
-	response := fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+	response := appendBulkString(make([]byte, 0, len(s)+16), s)
 	logger.Debug("Formatted bulk string (%d bytes): %s", len(s), s)
-	return response
+	return string(response)
 }
 
 func FormatError(errMsg string) string {
@@ -199,15 +336,52 @@ func FormatError(errMsg string) string {
 }
 
 func FormatArray(elements []string) string {
-	response := fmt.Sprintf("*%d\r\n", len(elements))
-	for _, element := range elements {
-		response += fmt.Sprintf("$%d\r\n%s\r\n", len(element), element)
-	}
+	response := appendArray(make([]byte, 0, arraySize(elements)), elements)
 	logger.Debug("Formatted array (%d elements): %v", len(elements), elements)
-	return response
+	return string(response)
 }
 
 // WriteRaw writes raw data to connection
 func WriteRaw(conn net.Conn, data []byte) {
 	conn.Write(data)
 }
+
+var crlf = []byte("\r\n")
+
+// ReplyLen returns the length of the reply data starts with, nested arrays
+// included, or -1 when data does not start with a complete RESP reply
+func ReplyLen(data []byte) int {
+	end := 0
+	for pending := 1; pending > 0; pending-- {
+		line := bytes.Index(data[end:], crlf)
+		if line < 1 {
+			return -1
+		}
+		header := data[end : end+line]
+		end += line + 2
+
+		switch header[0] {
+		case '+', '-', ':':
+		case '$':
+			n, err := strconv.Atoi(string(header[1:]))
+			if err != nil || n < -1 {
+				return -1
+			}
+			if n >= 0 {
+				if n > len(data)-end-2 || !bytes.Equal(data[end+n:end+n+2], crlf) {
+					return -1
+				}
+				end += n + 2
+			}
+		case '*':
+			n, err := strconv.Atoi(string(header[1:]))
+			if err != nil || n < -1 {
+				return -1
+			}
+			pending += max(n, 0)
+		default:
+			return -1
+		}
+	}
+	return end
+}
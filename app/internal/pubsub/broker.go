@@ -0,0 +1,498 @@
+package pubsub
+
+import (
+	"container/list"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
+	"github.com/r0ld3x/redis-clone-go/app/pkg/database"
+	"github.com/r0ld3x/redis-clone-go/app/pkg/glob"
+)
+
+// MaxHistoryChannels is how many channels a broker retains messages for.
+// Past it, the channel published to least recently loses its history, so
+// publishing to ever new channels does not grow memory without bound.
+const MaxHistoryChannels = 1024
+
+// Broker routes published messages to subscribed connections
+type Broker struct {
+	channels      map[string]map[net.Conn]struct{} // channel -> subscribers
+	subscriptions map[net.Conn]map[string]struct{} // subscriber -> channels
+	patterns      map[string]map[net.Conn]struct{} // glob pattern -> subscribers
+	patternSubs   map[net.Conn]map[string]struct{} // subscriber -> patterns
+	shardChannels map[string]map[net.Conn]struct{} // shard channel -> subscribers
+	shardSubs     map[net.Conn]map[string]struct{} // subscriber -> shard channels
+	history       map[string]*list.Element         // channel -> its retained messages in historyOrder
+	historyOrder  *list.List                       // *retained, most recently published first
+	outboxes      map[net.Conn]*outbox             // subscriber -> frames waiting to be written
+	historyLen    int                              // messages retained per channel (0 disables history)
+	maxHistory    int                              // channels retained for, see MaxHistoryChannels
+	logger        *logging.Logger
+	mutex         sync.RWMutex
+}
+
+// NewBroker creates a broker retaining up to historyLen messages per channel
+func NewBroker(historyLen int) *Broker {
+	return &Broker{
+		channels:      make(map[string]map[net.Conn]struct{}),
+		subscriptions: make(map[net.Conn]map[string]struct{}),
+		patterns:      make(map[string]map[net.Conn]struct{}),
+		patternSubs:   make(map[net.Conn]map[string]struct{}),
+		shardChannels: make(map[string]map[net.Conn]struct{}),
+		shardSubs:     make(map[net.Conn]map[string]struct{}),
+		history:       make(map[string]*list.Element),
+		historyOrder:  list.New(),
+		outboxes:      make(map[net.Conn]*outbox),
+		historyLen:    historyLen,
+		maxHistory:    MaxHistoryChannels,
+		logger:        logging.NewLogger("PUBSUB"),
+	}
+}
+
+// Subscribe adds conn to a channel and sends the subscribe confirmation.
+// When replay is set the retained history of the channel follows it, queued
+// under the broker lock so a concurrent PUBLISH cannot come ahead of it.
+func (b *Broker) Subscribe(conn net.Conn, channel string, replay bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	add(b.channels, b.subscriptions, conn, channel)
+	b.sendConfirmation(conn, "subscribe", channel, b.count(conn))
+
+	if !replay {
+		return
+	}
+	if elem, ok := b.history[channel]; ok {
+		entries := elem.Value.(*retained).stream.Tail(b.historyLen)
+		b.logger.Debug("Replaying %d retained messages on %s to %s", len(entries), channel, conn.RemoteAddr())
+		for _, entry := range entries {
+			message, _ := entry.Value("message")
+			b.send(conn, []byte(formatMessage(channel, message)))
+		}
+	}
+}
+
+// Unsubscribe removes conn from a channel and sends the confirmation
+func (b *Broker) Unsubscribe(conn net.Conn, channel string) {
+	b.mutex.Lock()
+	remove(b.channels, b.subscriptions, conn, channel)
+	b.sendConfirmation(conn, "unsubscribe", channel, b.count(conn))
+	idle := b.release(conn)
+	b.mutex.Unlock()
+	idle.stop()
+}
+
+// UnsubscribeAll removes conn from every channel, sending one confirmation
+// per channel, or a single confirmation with a null channel if it had none
+func (b *Broker) UnsubscribeAll(conn net.Conn) {
+	b.mutex.Lock()
+	b.removeAll(b.channels, b.subscriptions, conn, "unsubscribe", b.count)
+	idle := b.release(conn)
+	b.mutex.Unlock()
+	idle.stop()
+}
+
+// PSubscribe adds conn to the channels matching a glob pattern and sends
+// the psubscribe confirmation. Messages arrive as pmessage frames naming
+// the pattern, and once per matching pattern.
+func (b *Broker) PSubscribe(conn net.Conn, pattern string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	add(b.patterns, b.patternSubs, conn, pattern)
+	b.sendConfirmation(conn, "psubscribe", pattern, b.count(conn))
+}
+
+// PUnsubscribe removes a pattern subscription of conn and sends the
+// confirmation
+func (b *Broker) PUnsubscribe(conn net.Conn, pattern string) {
+	b.mutex.Lock()
+	remove(b.patterns, b.patternSubs, conn, pattern)
+	b.sendConfirmation(conn, "punsubscribe", pattern, b.count(conn))
+	idle := b.release(conn)
+	b.mutex.Unlock()
+	idle.stop()
+}
+
+// PUnsubscribeAll removes every pattern subscription of conn, like
+// UnsubscribeAll does for channels
+func (b *Broker) PUnsubscribeAll(conn net.Conn) {
+	b.mutex.Lock()
+	b.removeAll(b.patterns, b.patternSubs, conn, "punsubscribe", b.count)
+	idle := b.release(conn)
+	b.mutex.Unlock()
+	idle.stop()
+}
+
+// SSubscribe adds conn to a shard channel and sends the ssubscribe
+// confirmation. Shard channels are a namespace of their own: PUBLISH and
+// patterns never reach them, only SPUBLISH does, as smessage frames. The
+// confirmation counts shard subscriptions only, as in Redis.
+func (b *Broker) SSubscribe(conn net.Conn, channel string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	add(b.shardChannels, b.shardSubs, conn, channel)
+	b.sendConfirmation(conn, "ssubscribe", channel, b.shardCount(conn))
+}
+
+// SUnsubscribe removes conn from a shard channel and sends the
+// confirmation
+func (b *Broker) SUnsubscribe(conn net.Conn, channel string) {
+	b.mutex.Lock()
+	remove(b.shardChannels, b.shardSubs, conn, channel)
+	b.sendConfirmation(conn, "sunsubscribe", channel, b.shardCount(conn))
+	idle := b.release(conn)
+	b.mutex.Unlock()
+	idle.stop()
+}
+
+// SUnsubscribeAll removes every shard subscription of conn, like
+// UnsubscribeAll does for channels
+func (b *Broker) SUnsubscribeAll(conn net.Conn) {
+	b.mutex.Lock()
+	b.removeAll(b.shardChannels, b.shardSubs, conn, "sunsubscribe", b.shardCount)
+	idle := b.release(conn)
+	b.mutex.Unlock()
+	idle.stop()
+}
+
+// RemoveConnection drops every subscription of a connection, once what was
+// already sent to it is written
+func (b *Broker) RemoveConnection(conn net.Conn) {
+	b.mutex.Lock()
+	b.removeConnection(conn)
+	idle := b.release(conn)
+	b.mutex.Unlock()
+	idle.stop()
+}
+
+// Flush waits until everything sent to conn is written, so that a reply
+// written to it directly comes after
+func (b *Broker) Flush(conn net.Conn) {
+	b.mutex.RLock()
+	ob := b.outboxes[conn]
+	b.mutex.RUnlock()
+	if ob != nil {
+		ob.drain()
+	}
+}
+
+// removeConnection drops every subscription of conn; it must be called
+// with the write lock held
+func (b *Broker) removeConnection(conn net.Conn) {
+	for channel := range b.subscriptions[conn] {
+		remove(b.channels, b.subscriptions, conn, channel)
+	}
+	for pattern := range b.patternSubs[conn] {
+		remove(b.patterns, b.patternSubs, conn, pattern)
+	}
+	for channel := range b.shardSubs[conn] {
+		remove(b.shardChannels, b.shardSubs, conn, channel)
+	}
+}
+
+// SubscriptionCount returns how many channels, patterns and shard channels
+// conn is subscribed to; a connection is in subscribe mode while it is
+// above 0
+func (b *Broker) SubscriptionCount(conn net.Conn) int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return b.count(conn) + b.shardCount(conn)
+}
+
+// PatternCount returns how many of conn's subscriptions are patterns
+func (b *Broker) PatternCount(conn net.Conn) int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return len(b.patternSubs[conn])
+}
+
+// ShardCount returns how many of conn's subscriptions are shard channels
+func (b *Broker) ShardCount(conn net.Conn) int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return b.shardCount(conn)
+}
+
+// Channels returns the channels with at least one subscriber, sorted,
+// limited to those matching a glob pattern unless it is ""
+func (b *Broker) Channels(pattern string) []string {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return activeChannels(b.channels, pattern)
+}
+
+// ShardChannels is Channels for shard channels
+func (b *Broker) ShardChannels(pattern string) []string {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return activeChannels(b.shardChannels, pattern)
+}
+
+// NumSub returns the number of subscribers of each channel, not counting
+// pattern subscriptions
+func (b *Broker) NumSub(channels []string) []int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return subscriberCounts(b.channels, channels)
+}
+
+// ShardNumSub returns the number of subscribers of each shard channel
+func (b *Broker) ShardNumSub(channels []string) []int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return subscriberCounts(b.shardChannels, channels)
+}
+
+// NumPat returns the number of distinct patterns subscribed to
+func (b *Broker) NumPat() int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return len(b.patterns)
+}
+
+// count returns conn's channel and pattern subscriptions, which every
+// confirmation reports; it must be called with the lock held
+func (b *Broker) count(conn net.Conn) int {
+	return len(b.subscriptions[conn]) + len(b.patternSubs[conn])
+}
+
+// shardCount returns conn's shard subscriptions, which the ssubscribe and
+// sunsubscribe confirmations report; it must be called with the lock held
+func (b *Broker) shardCount(conn net.Conn) int {
+	return len(b.shardSubs[conn])
+}
+
+// Publish delivers a message to every subscriber of the channel and of each
+// pattern matching it, retains it when history is enabled, and returns the
+// number of deliveries. Retention and delivery share one critical section so
+// a subscriber joining concurrently sees the message either in its replay or
+// live, never both.
+func (b *Broker) Publish(channel, message string) int {
+	b.mutex.Lock()
+	if b.historyLen > 0 {
+		b.retain(channel, message)
+	}
+
+	var overflowed []net.Conn
+	receivers := b.deliver(b.channels[channel], []byte(formatMessage(channel, message)), &overflowed)
+	for pattern, subscribers := range b.patterns {
+		if glob.Match(pattern, channel) {
+			frame := protocol.EncodeArray([]string{"pmessage", pattern, channel, message})
+			receivers += b.deliver(subscribers, []byte(frame), &overflowed)
+		}
+	}
+	b.mutex.Unlock()
+
+	b.disconnect(overflowed)
+	return receivers
+}
+
+// SPublish delivers a message to every subscriber of the shard channel as
+// an smessage frame and returns the number of deliveries. Shard messages
+// are not retained.
+func (b *Broker) SPublish(channel, message string) int {
+	b.mutex.Lock()
+	var overflowed []net.Conn
+	frame := protocol.EncodeArray([]string{"smessage", channel, message})
+	receivers := b.deliver(b.shardChannels[channel], []byte(frame), &overflowed)
+	b.mutex.Unlock()
+
+	b.disconnect(overflowed)
+	return receivers
+}
+
+// deliver queues frame for each subscriber and returns how many got it.
+// Subscribers whose queue is full are added to overflowed instead. It must
+// be called with the write lock held.
+func (b *Broker) deliver(subscribers map[net.Conn]struct{}, frame []byte, overflowed *[]net.Conn) int {
+	receivers := 0
+	for conn := range subscribers {
+		if !b.send(conn, frame) {
+			*overflowed = append(*overflowed, conn)
+			continue
+		}
+		receivers++
+	}
+	return receivers
+}
+
+// disconnect drops subscribers that stopped reading and closes their
+// connections
+func (b *Broker) disconnect(conns []net.Conn) {
+	for _, conn := range conns {
+		b.mutex.Lock()
+		b.removeConnection(conn)
+		ob := b.outboxes[conn]
+		delete(b.outboxes, conn)
+		b.mutex.Unlock()
+
+		if ob != nil {
+			ob.close()
+		}
+		b.logger.Error("Disconnecting subscriber %s: more than %d bytes of messages waiting", conn.RemoteAddr(), OutputLimit)
+		conn.Close()
+	}
+}
+
+// send queues frame for conn and reports false when its queue is full; it
+// must be called with the write lock held
+func (b *Broker) send(conn net.Conn, frame []byte) bool {
+	ob := b.outboxes[conn]
+	if ob == nil {
+		ob = newOutbox(conn)
+		b.outboxes[conn] = ob
+	}
+	return ob.push(frame)
+}
+
+// release detaches the queue of conn once it has no subscriptions left and
+// returns it, for the caller to stop once the lock is released; it must be
+// called with the write lock held
+func (b *Broker) release(conn net.Conn) *outbox {
+	if b.count(conn)+b.shardCount(conn) > 0 {
+		return nil
+	}
+	ob := b.outboxes[conn]
+	delete(b.outboxes, conn)
+	return ob
+}
+
+// retained is the history of one channel
+type retained struct {
+	channel string
+	stream  *database.Stream
+}
+
+// retain appends a message to the channel's bounded history stream,
+// dropping the history of the channel published to least recently when
+// that makes more than maxHistory; it must be called with the write lock
+// held
+func (b *Broker) retain(channel, message string) {
+	elem, ok := b.history[channel]
+	if ok {
+		b.historyOrder.MoveToFront(elem)
+	} else {
+		if b.historyOrder.Len() >= b.maxHistory {
+			oldest := b.historyOrder.Back()
+			delete(b.history, b.historyOrder.Remove(oldest).(*retained).channel)
+		}
+		elem = b.historyOrder.PushFront(&retained{channel: channel, stream: database.NewStream()})
+		b.history[channel] = elem
+	}
+	stream := elem.Value.(*retained).stream
+	if _, err := stream.Append("*", []string{"message", message}); err != nil {
+		b.logger.Error("Failed to retain message on %s: %v", channel, err)
+		return
+	}
+	stream.TrimToLen(b.historyLen)
+}
+
+// removeAll removes conn from every channel (or pattern) of one kind,
+// sending a confirmation with the count for each, or a single one with a
+// null name if it had none; it must be called with the write lock held
+func (b *Broker) removeAll(byName map[string]map[net.Conn]struct{}, byConn map[net.Conn]map[string]struct{}, conn net.Conn, kind string, count func(net.Conn) int) {
+	names := sortedChannels(byConn[conn])
+	if len(names) == 0 {
+		b.send(conn, rawArray(protocol.FormatBulkString(kind), "$-1\r\n", protocol.FormatInteger(count(conn))))
+		return
+	}
+	for _, name := range names {
+		remove(byName, byConn, conn, name)
+		b.sendConfirmation(conn, kind, name, count(conn))
+	}
+}
+
+// add records conn's subscription to name in both indexes of channels, or
+// of patterns; it must be called with the write lock held
+func add(byName map[string]map[net.Conn]struct{}, byConn map[net.Conn]map[string]struct{}, conn net.Conn, name string) {
+	if byName[name] == nil {
+		byName[name] = make(map[net.Conn]struct{})
+	}
+	byName[name][conn] = struct{}{}
+
+	if byConn[conn] == nil {
+		byConn[conn] = make(map[string]struct{})
+	}
+	byConn[conn][name] = struct{}{}
+}
+
+// remove undoes add; it must be called with the write lock held
+func remove(byName map[string]map[net.Conn]struct{}, byConn map[net.Conn]map[string]struct{}, conn net.Conn, name string) {
+	if subscribers, ok := byName[name]; ok {
+		delete(subscribers, conn)
+		if len(subscribers) == 0 {
+			delete(byName, name)
+		}
+	}
+	if names, ok := byConn[conn]; ok {
+		delete(names, name)
+		if len(names) == 0 {
+			delete(byConn, conn)
+		}
+	}
+}
+
+// activeChannels returns the channels of an index with at least one
+// subscriber, sorted, limited to those matching pattern unless it is ""
+func activeChannels(byName map[string]map[net.Conn]struct{}, pattern string) []string {
+	channels := make([]string, 0, len(byName))
+	for channel := range byName {
+		if pattern == "" || glob.Match(pattern, channel) {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// subscriberCounts returns the number of subscribers of each channel in an
+// index
+func subscriberCounts(byName map[string]map[net.Conn]struct{}, channels []string) []int {
+	counts := make([]int, len(channels))
+	for i, channel := range channels {
+		counts[i] = len(byName[channel])
+	}
+	return counts
+}
+
+// sendConfirmation queues the reply to a (un)subscription; it must be
+// called with the write lock held
+func (b *Broker) sendConfirmation(conn net.Conn, kind, channel string, count int) {
+	b.send(conn, rawArray(protocol.FormatBulkString(kind), protocol.FormatBulkString(channel), protocol.FormatInteger(count)))
+}
+
+// rawArray frames already encoded elements as an array
+func rawArray(elements ...string) []byte {
+	frame := []byte("*" + strconv.Itoa(len(elements)) + "\r\n")
+	for _, element := range elements {
+		frame = append(frame, element...)
+	}
+	return frame
+}
+
+func formatMessage(channel, message string) string {
+	return protocol.EncodeArray([]string{"message", channel, message})
+}
+
+func sortedChannels(set map[string]struct{}) []string {
+	channels := make([]string, 0, len(set))
+	for channel := range set {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	return channels
+}
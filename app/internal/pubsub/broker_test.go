@@ -0,0 +1,123 @@
+package pubsub
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+	"github.com/r0ld3x/redis-clone-go/app/internal/testutil"
+)
+
+func TestMain(m *testing.M) {
+	logging.SetLevel(logging.LevelNone)
+	m.Run()
+}
+
+// within fails t unless fn returns before the timeout
+func within(t *testing.T, what string, fn func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("%s blocked behind a subscriber that does not read", what)
+	}
+}
+
+func TestStalledSubscriberDoesNotBlockBroker(t *testing.T) {
+	b := NewBroker(0)
+
+	// Writes to a net.Pipe block until the other end reads, which it never does
+	stalled, peer := net.Pipe()
+	defer peer.Close()
+	b.Subscribe(stalled, "news", false)
+
+	server, conn := testutil.Pipe()
+	client := testutil.NewClient(conn)
+
+	within(t, "SUBSCRIBE", func() { b.Subscribe(server, "news", false) })
+	within(t, "PUBLISH", func() {
+		if got := b.Publish("news", "hello"); got != 2 {
+			t.Errorf("PUBLISH reached %d subscribers, want 2", got)
+		}
+	})
+	within(t, "UNSUBSCRIBE", func() { b.Unsubscribe(server, "news") })
+
+	for _, want := range []string{
+		`["subscribe", "news", (integer) 1]`,
+		`["message", "news", "hello"]`,
+		`["unsubscribe", "news", (integer) 0]`,
+	} {
+		reply, err := client.ReadReply(time.Second)
+		if err != nil {
+			t.Fatalf("reading %s: %v", want, err)
+		}
+		if got := reply.String(); got != want {
+			t.Fatalf("got %s, want %s", got, want)
+		}
+	}
+}
+
+func TestStalledSubscriberIsDisconnectedOnOverflow(t *testing.T) {
+	b := NewBroker(0)
+	stalled, peer := net.Pipe()
+	defer peer.Close()
+	b.Subscribe(stalled, "news", false)
+
+	message := strings.Repeat("x", 1<<20)
+	within(t, "PUBLISH", func() {
+		for i := 0; i < OutputLimit>>20+2; i++ {
+			b.Publish("news", message)
+		}
+	})
+
+	if got := b.SubscriptionCount(stalled); got != 0 {
+		t.Fatalf("overflowed subscriber still has %d subscriptions", got)
+	}
+	if got := b.NumSub([]string{"news"})[0]; got != 0 {
+		t.Fatalf("NUMSUB news = %d after the overflow, want 0", got)
+	}
+	if _, err := stalled.Write([]byte("+OK\r\n")); err == nil {
+		t.Fatal("overflowed subscriber's connection was not closed")
+	}
+}
+
+// Only the channels published to most recently keep their history
+func TestHistoryChannelsAreCapped(t *testing.T) {
+	b := NewBroker(2)
+	b.maxHistory = 3
+	for _, channel := range []string{"a", "b", "c", "a", "d"} {
+		b.Publish(channel, "old "+channel)
+	}
+	if len(b.history) != 3 || b.historyOrder.Len() != 3 {
+		t.Fatalf("history kept for %d channels, want 3", len(b.history))
+	}
+
+	server, conn := testutil.Pipe()
+	defer server.Close()
+	client := testutil.NewClient(conn)
+	b.Subscribe(server, "b", true)
+	b.Subscribe(server, "a", true)
+	b.Publish("b", "new b")
+	for _, want := range []string{
+		`["subscribe", "b", (integer) 1]`,
+		`["subscribe", "a", (integer) 2]`,
+		`["message", "a", "old a"]`,
+		`["message", "a", "old a"]`,
+		`["message", "b", "new b"]`,
+	} {
+		reply, err := client.ReadReply(time.Second)
+		if err != nil {
+			t.Fatalf("reading %s: %v", want, err)
+		}
+		if got := reply.String(); got != want {
+			t.Fatalf("got %s, want %s", got, want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package pubsub
+
+import (
+	"net"
+	"sync"
+)
+
+// OutputLimit is how many bytes may wait for a subscriber before it is
+// disconnected, like the pubsub client-output-buffer-limit of Redis
+const OutputLimit = 32 << 20
+
+// outbox queues the frames sent to one subscriber and writes them from its
+// own goroutine, so a subscriber that stops reading never blocks the broker
+type outbox struct {
+	conn    net.Conn
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	frames  [][]byte
+	size    int  // bytes queued in frames
+	writing bool // a frame was taken off frames and is being written
+	closed  bool
+}
+
+func newOutbox(conn net.Conn) *outbox {
+	ob := &outbox{conn: conn}
+	ob.cond = sync.NewCond(&ob.mutex)
+	go ob.run()
+	return ob
+}
+
+// push queues frame and reports false, dropping it, when that would take
+// the queue past OutputLimit
+func (ob *outbox) push(frame []byte) bool {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	if ob.closed {
+		return true
+	}
+	if ob.size+len(frame) > OutputLimit {
+		return false
+	}
+	ob.frames = append(ob.frames, frame)
+	ob.size += len(frame)
+	ob.cond.Broadcast()
+	return true
+}
+
+// drain waits until every queued frame is written, or the outbox is closed
+func (ob *outbox) drain() {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	for !ob.closed && (len(ob.frames) > 0 || ob.writing) {
+		ob.cond.Wait()
+	}
+}
+
+// stop drains the outbox and ends its goroutine; a nil outbox is a no-op
+func (ob *outbox) stop() {
+	if ob == nil {
+		return
+	}
+	ob.drain()
+	ob.close()
+}
+
+// close ends the goroutine, discarding whatever is still queued
+func (ob *outbox) close() {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	ob.closed = true
+	ob.frames = nil
+	ob.size = 0
+	ob.cond.Broadcast()
+}
+
+func (ob *outbox) run() {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+
+	for {
+		for !ob.closed && len(ob.frames) == 0 {
+			ob.cond.Wait()
+		}
+		if ob.closed {
+			return
+		}
+		frame := ob.frames[0]
+		ob.frames[0] = nil
+		ob.frames = ob.frames[1:]
+		ob.writing = true
+
+		ob.mutex.Unlock()
+		_, err := ob.conn.Write(frame)
+		ob.mutex.Lock()
+
+		ob.writing = false
+		ob.size -= len(frame)
+		if err != nil {
+			// The session notices the broken connection on its next read
+			ob.closed = true
+			ob.frames = nil
+			ob.size = 0
+		}
+		ob.cond.Broadcast()
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+// backlog keeps the most recent bytes of the replication stream in a ring,
+// so a replica whose link dropped can resume from its offset (PSYNC
+// +CONTINUE) instead of loading a whole snapshot
+type backlog struct {
+	ring  []byte // len(ring) is the capacity
+	head  int    // Index in ring of the oldest byte
+	n     int    // Bytes held
+	start int    // Stream offset of the oldest byte
+}
+
+// newBacklog creates an empty backlog of size bytes whose next byte is at
+// stream offset
+func newBacklog(size, offset int) *backlog {
+	return &backlog{ring: make([]byte, size), start: offset}
+}
+
+// end returns the stream offset just past the newest byte
+func (b *backlog) end() int {
+	return b.start + b.n
+}
+
+// append adds data at the end of the stream, dropping the oldest bytes that
+// no longer fit
+func (b *backlog) append(data []byte) {
+	size := len(b.ring)
+	if len(data) > size {
+		dropped := len(data) - size
+		b.start += b.n + dropped
+		b.head, b.n = 0, 0
+		data = data[dropped:]
+	}
+	if over := b.n + len(data) - size; over > 0 {
+		b.head = (b.head + over) % size
+		b.start += over
+		b.n -= over
+	}
+
+	tail := (b.head + b.n) % size
+	copied := copy(b.ring[tail:], data)
+	copy(b.ring, data[copied:])
+	b.n += len(data)
+}
+
+// since returns the stream from offset on, and false when offset is not
+// within the backlog
+func (b *backlog) since(offset int) ([]byte, bool) {
+	if offset < b.start || offset > b.end() {
+		return nil, false
+	}
+	skip := offset - b.start
+	out := make([]byte, b.n-skip)
+	copied := copy(out, b.ring[(b.head+skip)%len(b.ring):])
+	copy(out[copied:], b.ring)
+	return out, true
+}
@@ -0,0 +1,32 @@
+package server
+
+import "time"
+
+// CronInterval is how often the server cron runs its jobs (Redis' default
+// hz of 10)
+const CronInterval = 100 * time.Millisecond
+
+// AddCronJob registers job to run on every tick of the server cron
+func (s *Server) AddCronJob(job func()) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	s.cronJobs = append(s.cronJobs, job)
+}
+
+// StartCron runs the registered jobs every interval in the background
+func (s *Server) StartCron(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			s.Mutex.RLock()
+			jobs := s.cronJobs
+			s.Mutex.RUnlock()
+
+			for _, job := range jobs {
+				job()
+			}
+		}
+	}()
+}
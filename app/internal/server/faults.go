@@ -0,0 +1,63 @@
+package server
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Faults are failures injected on purpose, through DEBUG in binaries built
+// with -tags debug, so tests can drive the replication robustness paths
+// deterministically. The zero value injects none.
+type Faults struct {
+	syncDelay        atomic.Int64 // Nanoseconds a full resync waits before sending the snapshot
+	replicaDropAfter atomic.Int64 // Replication stream bytes left before a replica is dropped, 0 when off
+}
+
+// errInjectedDrop is the write error of a replica dropped by DropReplicaAfter
+var errInjectedDrop = errors.New("injected fault: replica dropped")
+
+// SetSyncDelay makes each full resync wait d between the FULLRESYNC reply
+// and the snapshot, like Redis' DEBUG SLEEP-AFTER-FORK-SECONDS; zero turns
+// it off. Commands propagated meanwhile are held for the replica.
+func (f *Faults) SetSyncDelay(d time.Duration) {
+	f.syncDelay.Store(int64(d))
+}
+
+// SyncDelay returns the delay set with SetSyncDelay
+func (f *Faults) SyncDelay() time.Duration {
+	return time.Duration(f.syncDelay.Load())
+}
+
+// DropReplicaAfter lets n more bytes of the replication stream through and
+// then drops the replica whose write reaches that count, mid-command when
+// the count ends inside one. It fires once; zero disarms it.
+func (f *Faults) DropReplicaAfter(n int64) {
+	f.replicaDropAfter.Store(n)
+}
+
+// ReplicaDropAfter returns how many bytes are left before the armed drop,
+// 0 when none is armed
+func (f *Faults) ReplicaDropAfter() int64 {
+	return f.replicaDropAfter.Load()
+}
+
+// takeReplicaBytes accounts for a write of n bytes to a replica. It returns
+// how many of them to write and whether to drop the replica afterwards.
+func (f *Faults) takeReplicaBytes(n int) (allowed int, drop bool) {
+	for {
+		left := f.replicaDropAfter.Load()
+		if left <= 0 {
+			return n, false
+		}
+		if int64(n) < left {
+			if f.replicaDropAfter.CompareAndSwap(left, left-int64(n)) {
+				return n, false
+			}
+			continue
+		}
+		if f.replicaDropAfter.CompareAndSwap(left, 0) {
+			return int(left), true
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
+)
+
+// replicaFeed is the replication stream to one replica. With a batch delay
+// the commands propagated within it are written together in one write,
+// trading that much replication lag for fewer syscalls on masters taking
+// many small writes; without one every command is written as it comes.
+type replicaFeed struct {
+	server  *Server
+	conn    net.Conn
+	mutex   sync.Mutex   // Keeps writes to conn in stream order
+	pending []byte       // Commands queued for the next flush
+	armed   bool         // Whether a flush is scheduled for pending
+	getack  bool         // Whether a REPLCONF GETACK is due once the snapshot was sent
+	state   atomic.Int32 // ReplicaState, changed with mutex held; commands stay queued until it is online
+}
+
+// ReplFeedStats counts what the replica feeds wrote, for INFO replication
+type ReplFeedStats struct {
+	commands atomic.Int64 // Commands propagated to a replica
+	writes   atomic.Int64 // Writes to replica connections carrying them
+}
+
+// Snapshot returns the counters
+func (st *ReplFeedStats) Snapshot() (commands, writes int64) {
+	return st.commands.Load(), st.writes.Load()
+}
+
+// send queues data, n encoded commands, for the replica. With a zero delay
+// it is written right away, after anything still queued from before
+// batching was turned off; otherwise the first command queued schedules a
+// flush delay later.
+func (f *replicaFeed) send(data []byte, n int, delay time.Duration) {
+	f.server.ReplFeed.commands.Add(int64(n))
+	f.mutex.Lock()
+	f.pending = append(f.pending, data...)
+	if delay > 0 {
+		if !f.armed {
+			f.armed = true
+			time.AfterFunc(delay, f.flush)
+		}
+		f.mutex.Unlock()
+		return
+	}
+	n, err := f.writeLocked()
+	f.mutex.Unlock()
+	f.server.replicaWritten(f.conn, n, err)
+}
+
+// flush writes whatever is queued
+func (f *replicaFeed) flush() {
+	f.mutex.Lock()
+	n, err := f.writeLocked()
+	f.mutex.Unlock()
+	f.server.replicaWritten(f.conn, n, err)
+}
+
+// writeLocked writes the queued commands in a single write, unless the
+// replica is not online yet. The caller holds f.mutex.
+func (f *replicaFeed) writeLocked() (int, error) {
+	f.armed = false
+	if len(f.pending) == 0 || !f.online() {
+		return 0, nil
+	}
+	allowed, drop := f.server.Faults.takeReplicaBytes(len(f.pending))
+	n, err := f.conn.Write(f.pending[:allowed])
+	f.server.ReplFeed.writes.Add(1)
+	f.pending = f.pending[:0]
+	if drop && err == nil {
+		f.conn.Close()
+		err = errInjectedDrop
+	}
+	return n, err
+}
+
+// online reports whether the replica receives the replication stream
+func (f *replicaFeed) online() bool {
+	return ReplicaState(f.state.Load()) == ReplicaOnline
+}
+
+// endSync puts the replica online, letting the commands held during a full
+// resync through, followed by a GETACK asked for meanwhile
+func (f *replicaFeed) endSync() {
+	f.mutex.Lock()
+	f.transition(ReplicaOnline)
+	n, err := f.writeLocked()
+	if err == nil && f.getack {
+		_, err = f.conn.Write(getAckCommand)
+	}
+	f.getack = false
+	f.mutex.Unlock()
+	f.server.replicaWritten(f.conn, n, err)
+}
+
+// getAckCommand asks a replica for its offset. It is not part of the
+// replication stream, so it isn't counted in the offsets.
+var getAckCommand = []byte(protocol.EncodeArray([]string{"REPLCONF", "GETACK", "*"}))
+
+// WriteGetAck sends REPLCONF GETACK to the replica on conn, after what was
+// written to it so far. A replica in a full resync gets it once the
+// snapshot was sent.
+func (s *Server) WriteGetAck(conn net.Conn) {
+	s.Mutex.RLock()
+	f := s.feeds[conn]
+	s.Mutex.RUnlock()
+	if f == nil {
+		return
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if !f.online() {
+		f.getack = true
+		return
+	}
+	conn.Write(getAckCommand)
+}
+
+// replicaWritten accounts for n bytes written to a replica, or drops it when
+// the write failed. It runs without the feed's lock, since RemoveReplica
+// takes the server's.
+func (s *Server) replicaWritten(conn net.Conn, n int, err error) {
+	if err != nil {
+		s.RemoveReplica(conn, fmt.Sprintf("write failed: %v", err))
+		return
+	}
+	if n == 0 {
+		return
+	}
+	s.Mutex.Lock()
+	if _, exists := s.ReplicaOffsets[conn]; exists {
+		s.ReplicaOffsets[conn] += n
+	}
+	s.Mutex.Unlock()
+}
+
+// SetReplBatchDelay sets how long propagated commands are held to be
+// written to replicas together; zero writes each one as it comes
+func (s *Server) SetReplBatchDelay(d time.Duration) {
+	s.replBatchDelay.Store(int64(d))
+}
+
+// ReplBatchDelay returns the delay set with SetReplBatchDelay
+func (s *Server) ReplBatchDelay() time.Duration {
+	return time.Duration(s.replBatchDelay.Load())
+}
+
+// FlushReplicas writes the commands queued for every replica now. Anything
+// that must reach replicas after the propagated stream, such as a
+// REPLCONF GETACK, calls it first.
+func (s *Server) FlushReplicas() {
+	s.Mutex.RLock()
+	feeds := make([]*replicaFeed, 0, len(s.feeds))
+	for _, feed := range s.feeds {
+		feeds = append(feeds, feed)
+	}
+	s.Mutex.RUnlock()
+
+	for _, feed := range feeds {
+		feed.flush()
+	}
+}
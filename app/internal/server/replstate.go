@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net"
+)
+
+// ReplicaState is where a replica is in its synchronization, as INFO
+// replication reports it. A full resync moves a replica from
+// ReplicaWaitBgsave to ReplicaSendBulk to ReplicaOnline; a partial resync
+// starts it online.
+type ReplicaState int32
+
+const (
+	// ReplicaWaitBgsave replicas got FULLRESYNC and wait for the snapshot to
+	// be produced
+	ReplicaWaitBgsave ReplicaState = iota
+	// ReplicaSendBulk replicas are being sent the snapshot
+	ReplicaSendBulk
+	// ReplicaOnline replicas receive the replication stream
+	ReplicaOnline
+)
+
+func (st ReplicaState) String() string {
+	switch st {
+	case ReplicaWaitBgsave:
+		return "wait_bgsave"
+	case ReplicaSendBulk:
+		return "send_bulk"
+	case ReplicaOnline:
+		return "online"
+	}
+	return "unknown"
+}
+
+// replicaTransitions holds the states each state may move to. A replica
+// already online that asks for another full resync starts over.
+var replicaTransitions = map[ReplicaState][]ReplicaState{
+	ReplicaWaitBgsave: {ReplicaSendBulk, ReplicaOnline},
+	ReplicaSendBulk:   {ReplicaOnline},
+	ReplicaOnline:     {ReplicaWaitBgsave},
+}
+
+// transition moves the feed to state next and reports whether that is a
+// valid move from its current state; an invalid one is logged and ignored.
+// Moving from wait_bgsave straight to online is how a failed full resync
+// gives up. The caller holds f.mutex.
+func (f *replicaFeed) transition(next ReplicaState) bool {
+	current := ReplicaState(f.state.Load())
+	if current == next {
+		return true
+	}
+	for _, allowed := range replicaTransitions[current] {
+		if allowed == next {
+			f.state.Store(int32(next))
+			f.server.Logger.Debug("Replica %s: %s -> %s", f.conn.RemoteAddr(), current, next)
+			return true
+		}
+	}
+	f.server.Logger.Error("Replica %s: invalid state change %s -> %s", f.conn.RemoteAddr(), current, next)
+	return false
+}
+
+// ReplicaInfo describes a connected replica for INFO replication
+type ReplicaInfo struct {
+	Conn   net.Conn
+	Port   string // Port advertised with REPLCONF listening-port, "" if none
+	State  ReplicaState
+	Offset int // Replication stream bytes the replica acknowledged or was sent
+}
+
+// ReplicaInfos returns the connected replicas in the order they connected
+func (s *Server) ReplicaInfos() []ReplicaInfo {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+
+	infos := make([]ReplicaInfo, 0, len(s.ReplicaConn))
+	for _, conn := range s.ReplicaConn {
+		info := ReplicaInfo{Conn: conn, Port: s.ReplicaPorts[conn], Offset: s.ReplicaOffsets[conn]}
+		if feed := s.feeds[conn]; feed != nil {
+			info.State = ReplicaState(feed.state.Load())
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// ReplicaState returns the state of the replica on conn, and false when
+// conn is not a replica
+func (s *Server) ReplicaState(conn net.Conn) (ReplicaState, bool) {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+
+	feed := s.feeds[conn]
+	if feed == nil {
+		return 0, false
+	}
+	return ReplicaState(feed.state.Load()), true
+}
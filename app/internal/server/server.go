@@ -2,22 +2,33 @@ package server
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/rand"
-	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/r0ld3x/redis-clone-go/app/internal/audit"
+	"github.com/r0ld3x/redis-clone-go/app/internal/client"
 	"github.com/r0ld3x/redis-clone-go/app/internal/config"
 	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+	"github.com/r0ld3x/redis-clone-go/app/internal/monitor"
 	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
+	"github.com/r0ld3x/redis-clone-go/app/internal/pubsub"
+	"github.com/r0ld3x/redis-clone-go/app/internal/stats"
 
 	"github.com/r0ld3x/redis-clone-go/app/internal/transaction"
+	"github.com/r0ld3x/redis-clone-go/app/pkg/cluster"
+	"github.com/r0ld3x/redis-clone-go/app/pkg/database"
+	"github.com/r0ld3x/redis-clone-go/app/pkg/rdb"
 )
 
 type Server struct {
@@ -27,23 +38,83 @@ type Server struct {
 	ReplicationOffset int                  // Our own current replication offset (master's position OR replica's applied offset)
 	ReplicationID     string               // Unique replication ID (used for partial resync)
 	ReplicaOffsets    map[net.Conn]int     // For each replica, the latest ACKed replication offset
-	AckReceived       chan net.Conn        // Signal channel for WAIT when a replica sends REPLCONF ACK
+	ReplicaPorts      map[net.Conn]string  // Port each replica advertised with REPLCONF listening-port
+	AckReceived       chan net.Conn        // Signal channel for WAIT when a replica sends REPLCONF ACK or is removed
 	HandshakeComplete bool                 // True if master/replica handshake completed
 	TransactionMgr    *transaction.Manager // Handles MULTI/EXEC command queues
+	PubSub            *pubsub.Broker       // Routes PUBLISH messages to subscribers
+	Audit             *audit.Log           // Security log of admin/write commands
+	Clients           *client.Registry     // Connected clients and their statistics
+	Stats             *stats.Commands      // Per-command calls and latencies for INFO
+	SlowLog           *stats.SlowLog       // Recent slow client commands for SLOWLOG
+	Monitors          *monitor.Feed        // Connections receiving the MONITOR feed
+	Cluster           *cluster.Topology    // Static cluster layout, nil when cluster mode is off
+	StartedAt         time.Time            // When the server started, for INFO server
+	FullSyncFlush     FlushStats           // Dataset flushes done by full resyncs, for INFO replication
 	Logger            *logging.Logger      // Central logging
+	ReplLog           *logging.Sampler     // Picks the propagated commands whose replication is logged
+	ReplFeed          ReplFeedStats        // Commands propagated and the writes that carried them, for INFO replication
+	Faults            Faults               // Failures injected through DEBUG in debug builds
 	Mutex             sync.RWMutex         // Protects shared state
+
+	cronJobs       []func()                  // Jobs run periodically by StartCron
+	masterReplID   string                    // On a replica, the replication ID of the master's stream
+	masterOffset   int                       // On a replica, our offset in it, for PSYNC after a lost link
+	feeds          map[net.Conn]*replicaFeed // Replication stream of each replica
+	replBatchDelay atomic.Int64              // See SetReplBatchDelay
+
+	// writes is read-locked by each write command while it applies and
+	// replicates its write, and write-locked to see none half done (see
+	// BeginWrite and PauseWrites)
+	writes sync.RWMutex
+
+	// stream keeps the replication stream whole: the offset, the backlog
+	// and the writes to the replicas move together under it
+	stream  sync.Mutex
+	backlog *backlog // nil until the first replica attaches
+
+	// propagation is write-locked by ReplicateAtomically, so no command
+	// is replicated between its write and its replication
+	propagation sync.RWMutex
+	held        atomic.Pointer[heldCommands] // See PropagateTransaction
+
+	// views holds the view of each connection between SNAPSHOT BEGIN and
+	// SNAPSHOT END, see BeginView. viewCount mirrors its size, so the
+	// commands of every other connection skip the lock.
+	views     map[net.Conn]*database.View
+	viewCount atomic.Int32
+	viewMutex sync.Mutex
+}
+
+// heldCommands are the commands a transaction replicated so far
+type heldCommands struct {
+	conn     net.Conn
+	commands [][]string
 }
 
 func NewServer(cfg *config.Config) *Server {
-	return &Server{
+	s := &Server{
 		Config:            cfg,
 		ReplicaOffsets:    make(map[net.Conn]int),
+		ReplicaPorts:      make(map[net.Conn]string),
 		ReplicationID:     generateReplID(),
 		ReplicationOffset: 0,
 		AckReceived:       make(chan net.Conn, 100),
 		TransactionMgr:    transaction.NewManager(),
+		PubSub:            pubsub.NewBroker(cfg.PubSubHistoryLen),
+		Audit:             audit.NewLog(cfg.AuditLogFile),
+		Clients:           client.NewRegistry(),
+		Stats:             stats.NewCommands(),
+		SlowLog:           stats.NewSlowLog(time.Duration(cfg.SlowlogLogSlowerThan)*time.Microsecond, cfg.SlowlogMaxLen),
+		Monitors:          monitor.NewFeed(),
+		StartedAt:         time.Now(),
 		Logger:            logging.NewLogger("SERVER"),
+		ReplLog:           logging.NewSampler(cfg.ReplLogSample),
+		feeds:             make(map[net.Conn]*replicaFeed),
+		views:             make(map[net.Conn]*database.View),
 	}
+	s.SetReplBatchDelay(time.Duration(cfg.ReplBatchMicros) * time.Microsecond)
+	return s
 }
 
 func (s *Server) IsMaster() bool {
@@ -54,20 +125,45 @@ func (s *Server) IsSlave() bool {
 	return s.Config.IsSlave()
 }
 
+// AddReplica registers conn as a replica that is online right away, after
+// a partial resync
 func (s *Server) AddReplica(conn net.Conn) {
+	s.addReplica(conn, ReplicaOnline)
+}
+
+// addReplica registers conn as a replica in state and returns its feed. A
+// feed that is not online holds back commands from the moment it can
+// receive any.
+func (s *Server) addReplica(conn net.Conn, state ReplicaState) *replicaFeed {
 	s.Mutex.Lock()
 	defer s.Mutex.Unlock()
 
+	if feed, exists := s.feeds[conn]; exists {
+		s.Logger.Debug("Replica %s is already registered", conn.RemoteAddr())
+		feed.mutex.Lock()
+		feed.transition(state)
+		feed.mutex.Unlock()
+		return feed
+	}
 	s.ReplicaConn = append(s.ReplicaConn, conn)
 	s.ReplicaOffsets[conn] = 0
+	s.feeds[conn] = &replicaFeed{server: s, conn: conn}
+	s.feeds[conn].state.Store(int32(state))
 	s.Logger.Debug("Added replica to connections list. Total replicas: %d", len(s.ReplicaConn))
+	return s.feeds[conn]
 }
 
-func (s *Server) RemoveReplica(conn net.Conn) {
+// RemoveReplica stops replicating to conn, e.g. because it disconnected or
+// was killed with CLIENT KILL. It is a no-op for connections that are not
+// replicas, so it is safe to call for every closed connection.
+func (s *Server) RemoveReplica(conn net.Conn, reason string) {
 	s.Mutex.Lock()
-	defer s.Mutex.Unlock()
-
-	s.Logger.Info("Removing replica connection: %s", conn.RemoteAddr())
+	port := s.ReplicaPorts[conn]
+	delete(s.ReplicaPorts, conn)
+	if _, exists := s.ReplicaOffsets[conn]; !exists {
+		s.Mutex.Unlock()
+		return
+	}
 
 	for i, rconn := range s.ReplicaConn {
 		if rconn == conn {
@@ -77,19 +173,133 @@ func (s *Server) RemoveReplica(conn net.Conn) {
 		}
 	}
 
+	if port == "" {
+		port = "unknown"
+	}
 	delete(s.ReplicaOffsets, conn)
-	s.Logger.Debug("Removed from replica offsets map")
-	s.Logger.Success("Replica removed successfully: %s", conn.RemoteAddr())
+	// A flush still scheduled for the feed fails on the closed connection
+	delete(s.feeds, conn)
+	remaining := len(s.ReplicaConn)
+	s.Mutex.Unlock()
+
+	s.Logger.Info("Replica %s (listening port %s) removed: %s. Remaining replicas: %d",
+		conn.RemoteAddr(), port, reason, remaining)
+
+	// Wake a pending WAIT so it stops counting this replica
+	select {
+	case s.AckReceived <- conn:
+	default:
+	}
 }
 
-func (s *Server) UpdateReplicationOffset(bytes int) {
+// IsReplica reports whether conn is a connected replica
+func (s *Server) IsReplica(conn net.Conn) bool {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+	_, exists := s.ReplicaOffsets[conn]
+	return exists
+}
+
+// Replicas returns a snapshot of the connected replicas
+func (s *Server) Replicas() []net.Conn {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+	return append([]net.Conn(nil), s.ReplicaConn...)
+}
+
+// SetReplicaListeningPort records the port conn advertised with REPLCONF
+// listening-port, used to identify the replica in logs
+func (s *Server) SetReplicaListeningPort(conn net.Conn, port string) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	s.ReplicaPorts[conn] = port
+}
+
+// UpdateReplicationOffset advances our replication offset by bytes and
+// returns the new offset
+func (s *Server) UpdateReplicationOffset(bytes int) int {
 	s.Mutex.Lock()
 	defer s.Mutex.Unlock()
 
-	oldOffset := s.ReplicationOffset
 	s.ReplicationOffset += bytes
-	s.Logger.Debug("Updated master replication offset: %d -> %d (+%d bytes)",
-		oldOffset, s.ReplicationOffset, bytes)
+	return s.ReplicationOffset
+}
+
+// ChangeReplicationID switches to a fresh replication ID, so replicas that
+// reconnect with the old one can no longer partially resync
+func (s *Server) ChangeReplicationID() string {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	s.ReplicationID = generateReplID()
+	s.Logger.Info("Replication ID changed to %s", s.ReplicationID)
+	return s.ReplicationID
+}
+
+// SetReplicationOffset overwrites our replication offset. The backlog is
+// emptied, since it no longer leads up to the offset.
+func (s *Server) SetReplicationOffset(offset int) {
+	s.stream.Lock()
+	defer s.stream.Unlock()
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	s.Logger.Info("Replication offset forced: %d -> %d", s.ReplicationOffset, offset)
+	s.ReplicationOffset = offset
+	if s.backlog != nil {
+		s.backlog = newBacklog(len(s.backlog.ring), offset)
+	}
+}
+
+// ResumeReplication takes on the replication ID and offset an RDB file we
+// saved recorded, and starts the backlog there. Replicas that were in sync
+// when we stopped then continue with what was written since, rather than
+// loading the same data again.
+func (s *Server) ResumeReplication(replID string, offset int) {
+	s.stream.Lock()
+	defer s.stream.Unlock()
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	s.Logger.Info("Resuming replication ID %s at offset %d", replID, offset)
+	s.ReplicationID, s.ReplicationOffset = replID, offset
+	s.backlog = newBacklog(s.Config.ReplBacklogSize, offset)
+}
+
+// ContinueReplication resumes the stream for a replica that last saw replID
+// up to offset: when the backlog still holds everything after offset it
+// answers +CONTINUE, registers conn as an online replica and sends it that
+// part of the stream. It reports false, having done nothing, when the
+// replica needs a full resync instead.
+func (s *Server) ContinueReplication(conn net.Conn, replID string, offset int) bool {
+	s.stream.Lock()
+	defer s.stream.Unlock()
+
+	s.Mutex.RLock()
+	current := replID == s.ReplicationID
+	s.Mutex.RUnlock()
+	if !current || s.backlog == nil {
+		return false
+	}
+	missed, ok := s.backlog.since(offset)
+	if !ok {
+		s.Logger.Info("Offset %d is outside the backlog (%d-%d), full resync needed", offset, s.backlog.start, s.backlog.end())
+		return false
+	}
+
+	protocol.WriteSimpleString(conn, "CONTINUE "+replID)
+	feed := s.addReplica(conn, ReplicaOnline)
+	if len(missed) > 0 {
+		feed.send(missed, 0, 0)
+	}
+	s.Logger.Info("Continuing replication for %s from offset %d (%d bytes from the backlog)", conn.RemoteAddr(), offset, len(missed))
+	return true
+}
+
+// AdvanceMasterOffset moves a replica's offset in its master's stream past
+// n bytes it applied
+func (s *Server) AdvanceMasterOffset(n int) {
+	s.masterOffset += n
 }
 
 func (s *Server) UpdateReplicaOffset(conn net.Conn, offset int) {
@@ -110,37 +320,147 @@ func (s *Server) GetReplicaOffset(conn net.Conn) int {
 	return offset
 }
 
-func (s *Server) ReplicateCommand(command []string) {
+// BeginWrite marks a write command as started; EndWrite must follow once
+// it has replicated its write. Commands that may block must not hold it
+// while they wait.
+func (s *Server) BeginWrite() {
+	s.writes.RLock()
+}
+
+// EndWrite marks the write command started by BeginWrite as done
+func (s *Server) EndWrite() {
+	s.writes.RUnlock()
+}
+
+// PauseWrites waits for the write commands in progress and holds back new
+// ones until resume is called
+func (s *Server) PauseWrites() (resume func()) {
+	s.writes.Lock()
+	return s.writes.Unlock
+}
+
+// BeginWriteFrom is BeginWrite for a write conn makes outside Execute, such
+// as a blocking command's once it wakes up, and returns its EndWrite. In
+// the transaction of conn, whose EXEC paused the writes, it does nothing.
+func (s *Server) BeginWriteFrom(conn net.Conn) (end func()) {
+	if held := s.held.Load(); held != nil && held.conn == conn {
+		return func() {}
+	}
+	s.writes.RLock()
+	return s.writes.RUnlock
+}
+
+// ReplicateCommand sends command to every replica, batched with other
+// commands when SetReplBatchDelay set a delay. conn is the client whose
+// command made the write, nil for the server's own. This runs for every
+// write, so its logging is sampled through ReplLog; errors are always
+// logged.
+func (s *Server) ReplicateCommand(conn net.Conn, command []string) {
 	if !s.IsMaster() {
 		return
 	}
 
-	encoded := protocol.EncodeArray(command)
-	s.UpdateReplicationOffset(len(encoded))
+	// Inside the transaction of conn, the command waits for the others
+	if held := s.held.Load(); held != nil && conn != nil && held.conn == conn {
+		held.commands = append(held.commands, command)
+		return
+	}
 
-	s.Mutex.RLock()
-	replicas := make([]net.Conn, len(s.ReplicaConn))
-	copy(replicas, s.ReplicaConn)
-	s.Mutex.RUnlock()
+	s.propagation.RLock()
+	defer s.propagation.RUnlock()
+	s.propagate([][]string{command})
+}
 
-	s.Logger.Info("Replicating to %d replicas", len(replicas))
+// PropagateTransaction calls run, which executes the transaction of conn,
+// and replicates the commands it replicated as one block: wrapped in MULTI
+// and EXEC when there are several, so replicas apply them together, and
+// written to each replica in one piece. The caller pauses the writes (see
+// PauseWrites) until it returns, so the block is replicated in the order
+// the transaction applied, between the writes before and after it.
+func (s *Server) PropagateTransaction(conn net.Conn, run func()) {
+	held := &heldCommands{conn: conn}
+	s.held.Store(held)
+	run()
+	s.held.Store(nil)
 
-	for _, conn := range replicas {
-		s.Logger.Network("OUT", "Sending command to replica %s", conn.RemoteAddr())
+	if !s.IsMaster() {
+		return
+	}
+	switch len(held.commands) {
+	case 0:
+	case 1:
+		s.propagate(held.commands)
+	default:
+		block := make([][]string, 0, len(held.commands)+2)
+		block = append(block, []string{"MULTI"})
+		block = append(block, held.commands...)
+		s.propagate(append(block, []string{"EXEC"}))
+	}
+}
 
-		bytesWritten, err := conn.Write([]byte(encoded))
-		if err != nil {
-			s.Logger.Error("Replica %s disconnected: %v", conn.RemoteAddr(), err)
-			s.RemoveReplica(conn)
-			continue
+// ReplicateAtomically calls apply, which makes a write and returns the
+// command that replicates it, or nil, and replicates that command before
+// any write that ran after apply. conn is as for ReplicateCommand. On a
+// replica it only calls apply.
+func (s *Server) ReplicateAtomically(conn net.Conn, apply func() []string) {
+	if !s.IsMaster() {
+		apply()
+		return
+	}
+
+	// Inside the transaction of conn the writes are paused already
+	if held := s.held.Load(); held != nil && conn != nil && held.conn == conn {
+		if command := apply(); command != nil {
+			held.commands = append(held.commands, command)
 		}
+		return
+	}
+
+	s.propagation.Lock()
+	defer s.propagation.Unlock()
+	if command := apply(); command != nil {
+		s.propagate([][]string{command})
+	}
+}
+
+// propagate writes commands to every replica and to the backlog, and moves
+// the replication offset past them
+func (s *Server) propagate(commands [][]string) {
+	s.stream.Lock()
+	defer s.stream.Unlock()
 
-		s.Mutex.Lock()
-		oldReplicaOffset := s.ReplicaOffsets[conn]
-		s.ReplicaOffsets[conn] += bytesWritten
-		s.Logger.Debug("Updated replica %s offset: %d -> %d (+%d bytes)",
-			conn.RemoteAddr(), oldReplicaOffset, s.ReplicaOffsets[conn], bytesWritten)
+	s.Mutex.Lock()
+	// Without replicas or a backlog only the offset moves, so skip the
+	// encoding
+	if len(s.ReplicaConn) == 0 && s.backlog == nil {
+		for _, command := range commands {
+			s.ReplicationOffset += protocol.EncodedArrayLen(command)
+		}
 		s.Mutex.Unlock()
+		return
+	}
+	feeds := make([]*replicaFeed, len(s.ReplicaConn))
+	for i, conn := range s.ReplicaConn {
+		feeds[i] = s.feeds[conn]
+	}
+	s.Mutex.Unlock()
+
+	var encoded []byte
+	for _, command := range commands {
+		encoded = append(encoded, protocol.EncodeArray(command)...)
+	}
+	offset := s.UpdateReplicationOffset(len(encoded))
+	if s.backlog != nil {
+		s.backlog.append(encoded)
+	}
+
+	if s.ReplLog.Sample() {
+		s.Logger.Info("Replicating %s to %d replicas, master offset now %d", commands[0][0], len(feeds), offset)
+	}
+
+	delay := s.ReplBatchDelay()
+	for _, feed := range feeds {
+		feed.send(encoded, len(commands), delay)
 	}
 }
 
@@ -158,19 +478,76 @@ func (s *Server) IsConnectionClosed(conn net.Conn) bool {
 	return false
 }
 
+// FlushStats describes the dataset flushes done before loading a full
+// resync
+type FlushStats struct {
+	Count    int64         // Flushes since startup
+	Lazy     bool          // Whether the last flush left reclamation to the background GC
+	Duration time.Duration // How long the last flush blocked the resync
+	mutex    sync.Mutex
+}
+
+// Snapshot returns a copy of the counters
+func (f *FlushStats) Snapshot() (count int64, lazy bool, d time.Duration) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.Count, f.Lazy, f.Duration
+}
+
+// flushForFullSync drops the dataset before the master's RDB is loaded.
+// Unless replica-lazy-flush is set the old data is also garbage collected
+// first, which bounds peak memory at the cost of a longer pause; lazily it
+// is reclaimed by the GC while the new dataset loads.
+func (s *Server) flushForFullSync() {
+	lazy := s.Config.ReplicaLazyFlush
+	start := time.Now()
+
+	database.Flush()
+	if !lazy {
+		runtime.GC()
+	}
+	elapsed := time.Since(start)
+
+	s.FullSyncFlush.mutex.Lock()
+	s.FullSyncFlush.Count++
+	s.FullSyncFlush.Lazy = lazy
+	s.FullSyncFlush.Duration = elapsed
+	s.FullSyncFlush.mutex.Unlock()
+
+	s.Logger.Info("Flushed dataset for full resync in %s (lazy: %t)", elapsed, lazy)
+}
+
 func (s *Server) SendHandshake(reader *bufio.Reader) error {
 	s.Logger.Info("==================== HANDSHAKE START ====================")
 
 	s.Logger.Info("Starting handshake with master %s", s.Config.MasterAddress)
 
-	// Step 1: PING
+	// Step 1: PING. A master that requires AUTH answers with an error
+	// instead of PONG, which is fine as long as we can authenticate next.
 	s.Logger.Network("OUT", "Sending PING to master")
 	protocol.WriteArray(s.MasterConn, []string{"PING"})
-	if err := s.expectSimpleString(reader, "PONG"); err != nil {
+	needsAuth, err := s.expectPong(reader)
+	if err != nil {
 		return err
 	}
 	s.Logger.Success("PING handshake successful")
 
+	// Step 1b: AUTH
+	if s.Config.MasterAuth != "" {
+		authArgs := []string{"AUTH", s.Config.MasterAuth}
+		if s.Config.MasterUser != "" {
+			authArgs = []string{"AUTH", s.Config.MasterUser, s.Config.MasterAuth}
+		}
+		s.Logger.Network("OUT", "Sending AUTH to master")
+		protocol.WriteArray(s.MasterConn, authArgs)
+		if err := s.expectSimpleString(reader, "OK"); err != nil {
+			return fmt.Errorf("master rejected AUTH: %w", err)
+		}
+		s.Logger.Success("AUTH handshake successful")
+	} else if needsAuth {
+		return errors.New("master requires authentication, set --masterauth")
+	}
+
 	// Step 2: REPLCONF listening-port
 	s.Logger.Network("OUT", "Sending REPLCONF listening-port %s", s.Config.Port)
 	protocol.WriteArray(s.MasterConn, []string{"REPLCONF", "listening-port", s.Config.Port})
@@ -187,38 +564,123 @@ func (s *Server) SendHandshake(reader *bufio.Reader) error {
 	}
 	s.Logger.Success("REPLCONF capa handshake successful")
 
-	// Step 4: PSYNC
-	s.Logger.Network("OUT", "Sending PSYNC ? -1")
-	protocol.WriteArray(s.MasterConn, []string{"PSYNC", "?", "-1"})
-	line, _ := reader.ReadString('\n')
+	// Step 4: PSYNC, asking to continue from where a previous link left
+	// off if there was one
+	replID, offset := "?", "-1"
+	if s.masterReplID != "" {
+		replID, offset = s.masterReplID, strconv.Itoa(s.masterOffset)
+	}
+	s.Logger.Network("OUT", "Sending PSYNC %s %s", replID, offset)
+	protocol.WriteArray(s.MasterConn, []string{"PSYNC", replID, offset})
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read PSYNC response: %w", err)
+	}
 	s.Logger.Network("IN", "PSYNC response: %s", strings.TrimSpace(line))
 
-	if strings.HasPrefix(line, "+FULLRESYNC") {
-		parts := strings.Split(strings.TrimSpace(line), " ")
-		if len(parts) >= 3 {
-			s.ReplicationID = parts[1]
-			s.Logger.Debug("Set replication ID: %s", s.ReplicationID)
+	parts := strings.Fields(strings.TrimPrefix(line, "+"))
+	if len(parts) > 0 && parts[0] == "CONTINUE" {
+		if len(parts) >= 2 {
+			s.masterReplID = parts[1]
 		}
+		s.finishHandshake()
+		s.Logger.Success("Partial resync from offset %d accepted", s.masterOffset)
+		return nil
+	}
+	if len(parts) < 3 || parts[0] != "FULLRESYNC" {
+		return fmt.Errorf("unexpected PSYNC response: %s", strings.TrimSpace(line))
 	}
+	masterOffset, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return fmt.Errorf("invalid FULLRESYNC offset: %s", parts[2])
+	}
+	s.ReplicationID = parts[1]
+	s.Logger.Debug("Set replication ID: %s", s.ReplicationID)
 
 	// Read RDB file
-	rdbHeader, _ := reader.ReadString('\n') // $<rdbLen>
-	rdbLenStr := strings.TrimSpace(rdbHeader[1:])
-	rdbLen, _ := strconv.Atoi(rdbLenStr)
+	rdbHeader, err := reader.ReadString('\n') // $<rdbLen>
+	if err != nil {
+		return fmt.Errorf("failed to read RDB header: %w", err)
+	}
+	rdbLen, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(rdbHeader, "$")))
+	if err != nil || !strings.HasPrefix(rdbHeader, "$") {
+		return fmt.Errorf("invalid RDB header: %s", strings.TrimSpace(rdbHeader))
+	}
 	s.Logger.Info("Reading RDB file of %d bytes", rdbLen)
 
-	io.CopyN(io.Discard, reader, int64(rdbLen))
-	s.Logger.Debug("RDB file content skipped")
+	payload := make([]byte, rdbLen)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return fmt.Errorf("failed to read RDB payload: %w", err)
+	}
+
+	// The master's snapshot replaces our dataset entirely; keys we held
+	// before must not survive the resync. They are only dropped once the
+	// snapshot is known to be whole.
+	if report := rdb.CheckBytes(payload); !report.OK() {
+		return fmt.Errorf("invalid RDB payload: %w", report.Err)
+	}
+	s.flushForFullSync()
+	if err := rdb.Load(bytes.NewReader(payload)); err != nil {
+		return fmt.Errorf("failed to load RDB payload: %w", err)
+	}
+	s.Logger.Info("Loaded RDB payload from master")
+	s.masterReplID, s.masterOffset = parts[1], masterOffset
+	s.finishHandshake()
+	return nil
+}
 
+// finishHandshake marks the link to the master as synced. The offset that
+// REPLCONF ACK reports counts from there, like the master's count of the
+// bytes it sent on this link.
+func (s *Server) finishHandshake() {
 	s.HandshakeComplete = true
 	s.Logger.Success("PSYNC handshake successful")
 
-	// Reset replication offset after handshake
 	s.ReplicationOffset = 0
 	s.Logger.Info("Reset replication offset to 0 after handshake")
 
 	s.Logger.Info("==================== HANDSHAKE END ====================")
-	return nil
+}
+
+// expectPong reads the reply to the handshake PING. It reports needsAuth
+// when the master refused the PING for lack of authentication, mirroring the
+// errors Redis itself tolerates at this step.
+func (s *Server) expectPong(reader *bufio.Reader) (bool, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return false, fmt.Errorf("failed to read PING reply: %w", err)
+	}
+	line = strings.TrimSpace(line)
+	s.Logger.Network("IN", "Received: %s", line)
+
+	switch {
+	case line == "+PONG":
+		return false, nil
+	case strings.HasPrefix(line, "-NOAUTH"),
+		strings.HasPrefix(line, "-NOPERM"),
+		strings.HasPrefix(line, "-ERR operation not permitted"):
+		s.Logger.Info("Master requires authentication: %s", line)
+		return true, nil
+	default:
+		return false, fmt.Errorf("unexpected reply to PING: %s", line)
+	}
+}
+
+// ConnectToMaster dials the configured master and performs the replication
+// handshake. On failure the connection is closed so the caller can retry.
+func (s *Server) ConnectToMaster() (*bufio.Reader, error) {
+	conn, err := net.DialTimeout("tcp", s.Config.MasterAddress, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	s.MasterConn = conn
+
+	reader := bufio.NewReader(conn)
+	if err := s.SendHandshake(reader); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return reader, nil
 }
 
 func (s *Server) expectSimpleString(reader *bufio.Reader, expected string) error {
@@ -247,24 +709,44 @@ func (s *Server) expectSimpleString(reader *bufio.Reader, expected string) error
 	return nil
 }
 
+// SendFullResync registers clientConn as a replica and sends it FULLRESYNC
+// and the snapshot, moving it through wait_bgsave while the snapshot is
+// produced and send_bulk while it is written, to online. Commands
+// propagated in the meantime are held and written after the snapshot.
+// Writes are paused while the replica is registered and the snapshot
+// pinned, so the snapshot holds exactly the writes up to the offset
+// announced and the replica gets the ones after it.
 func (s *Server) SendFullResync(clientConn net.Conn) error {
+	resume := s.PauseWrites()
+	s.stream.Lock()
+	feed := s.addReplica(clientConn, ReplicaWaitBgsave)
+	defer feed.endSync()
+	s.Mutex.RLock()
 	fullresyncResp := fmt.Sprintf("FULLRESYNC %s %d", s.ReplicationID, s.ReplicationOffset)
+	if s.backlog == nil {
+		s.backlog = newBacklog(s.Config.ReplBacklogSize, s.ReplicationOffset)
+	}
+	s.Mutex.RUnlock()
+	s.stream.Unlock()
+	dump := rdb.Encode(resume)
+
 	s.Logger.Network("OUT", "Sending FULLRESYNC response: %s", fullresyncResp)
 	protocol.WriteSimpleString(clientConn, fullresyncResp)
 
-	// Send empty RDB file
-	rdb := "UkVESVMwMDEx+glyZWRpcy12ZXIFNy4yLjD6CnJlZGlzLWJpdHPAQPoFY3RpbWXCbQi8ZfoIdXNlZC1tZW3CsMQQAPoIYW9mLWJhc2XAAP/wbjv+wP9aog=="
-	dst := make([]byte, base64.StdEncoding.DecodedLen(len(rdb)))
-	n, err := base64.StdEncoding.Decode(dst, []byte(rdb))
-	if err != nil {
-		s.Logger.Error("Failed to decode base64 RDB: %v", err)
-		return err
+	if d := s.Faults.SyncDelay(); d > 0 {
+		s.Logger.Info("Injected fault: waiting %v before sending the RDB file", d)
+		time.Sleep(d)
 	}
-	dst = dst[:n]
 
-	s.Logger.Network("OUT", "Sending RDB file (%d bytes)", len(dst))
-	clientConn.Write([]byte(fmt.Sprintf("$%v\r\n", len(dst))))
-	clientConn.Write(dst)
+	feed.mutex.Lock()
+	feed.transition(ReplicaSendBulk)
+	feed.mutex.Unlock()
+
+	s.Logger.Network("OUT", "Sending RDB file (%d bytes)", len(dump))
+	clientConn.Write([]byte(fmt.Sprintf("$%v\r\n", len(dump))))
+	if _, err := clientConn.Write(dump); err != nil {
+		return err
+	}
 	s.Logger.Success("FULLRESYNC completed for %s", clientConn.RemoteAddr())
 
 	return nil
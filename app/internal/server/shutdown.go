@@ -0,0 +1,138 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/pkg/rdb"
+)
+
+// Shutdown stops the server. Writes are paused from then on, so the final
+// offset stays final. A master ends the replication stream with a PING and
+// waits up to wait for every replica to acknowledge it, so replicas hold
+// everything it accepted; a zero wait skips that. Then the RDB file is
+// saved if save is set, the audit log is flushed to disk and the process
+// exits. When the save fails the server keeps running and the error is
+// returned, unless force is set.
+func (s *Server) Shutdown(wait time.Duration, save, force bool) error {
+	s.Logger.Info("Shutting down")
+	resume := s.PauseWrites()
+
+	if s.IsMaster() && wait > 0 {
+		if lagging := s.finalSync(wait); lagging > 0 {
+			s.Logger.Error("%d replica(s) did not acknowledge the final offset in %v", lagging, wait)
+		}
+	}
+
+	if save {
+		if err := s.SaveRDB(); err != nil && !force {
+			s.Logger.Error("Failed to save the RDB file, not shutting down: %v", err)
+			resume()
+			return err
+		} else if err != nil {
+			s.Logger.Error("Failed to save the RDB file: %v", err)
+		}
+	}
+
+	if err := s.Audit.SetEnabled(false); err != nil {
+		s.Logger.Error("Failed to flush audit log: %v", err)
+	}
+
+	s.Logger.Success("Server stopped at replication offset %d", s.ReplicationOffset)
+	os.Exit(0)
+	return nil
+}
+
+// SaveRDB writes the dataset to the RDB file. A master records its
+// replication ID and offset in it, so that after a restart it resumes the
+// stream where it stopped (see ResumeReplication). The file is written
+// aside, synced and renamed over the old one, so a crash midway leaves the
+// old one whole. The caller pauses the writes, so the offset matches the
+// data.
+func (s *Server) SaveRDB() error {
+	if s.Config.DBFileName == "" {
+		return errors.New("no dbfilename is configured")
+	}
+
+	var aux [][2]string
+	s.Mutex.RLock()
+	offset := s.ReplicationOffset
+	if s.IsMaster() {
+		aux = [][2]string{{"repl-id", s.ReplicationID}, {"repl-offset", strconv.Itoa(offset)}}
+	}
+	s.Mutex.RUnlock()
+	dump := rdb.Encode(nil, aux...)
+
+	path := s.Config.RDBPath()
+	file, err := os.CreateTemp(filepath.Dir(path), "temp-*.rdb")
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(dump); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := errors.Join(file.Sync(), file.Close()); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	// The rename is only durable once the directory is synced
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return err
+	}
+	s.Logger.Success("Saved %d bytes to %s at replication offset %d", len(dump), path, offset)
+	return nil
+}
+
+// finalSync propagates a PING marking the final offset and asks every
+// replica for its offset until each has acknowledged the marker or timeout
+// passes. It returns how many replicas had not.
+func (s *Server) finalSync(timeout time.Duration) int {
+	replicas := s.Replicas()
+	if len(replicas) == 0 {
+		return 0
+	}
+	s.ReplicateCommand(nil, []string{"PING"})
+	s.FlushReplicas()
+
+	// Each replica's offset counts the bytes sent to it, which is what it
+	// must acknowledge. A replica acks before counting the GETACK itself.
+	targets := make(map[net.Conn]int, len(replicas))
+	for _, conn := range replicas {
+		targets[conn] = s.GetReplicaOffset(conn)
+		s.WriteGetAck(conn)
+	}
+
+	deadline := time.After(timeout)
+	for len(targets) > 0 {
+		select {
+		case conn := <-s.AckReceived:
+			target, waiting := targets[conn]
+			if !waiting {
+				continue
+			}
+			// A removed replica is gone; one that acked enough is done
+			if !s.IsReplica(conn) || s.GetReplicaOffset(conn) >= target {
+				delete(targets, conn)
+			}
+		case <-deadline:
+			return len(targets)
+		}
+	}
+	return 0
+}
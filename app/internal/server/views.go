@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net"
+
+	"github.com/r0ld3x/redis-clone-go/app/pkg/database"
+)
+
+// BeginView gives conn a view of the keyspace, which its reads are served
+// from until EndView. Writes are paused while the view pins the values, so
+// it is the keyspace between two writes. It returns false, taking no view,
+// when conn already has one.
+func (s *Server) BeginView(conn net.Conn) bool {
+	if _, open := s.View(conn); open {
+		return false
+	}
+
+	resume := s.PauseWrites()
+	view := database.NewView()
+	resume()
+
+	s.viewMutex.Lock()
+	defer s.viewMutex.Unlock()
+	s.views[conn] = view
+	s.viewCount.Add(1)
+	return true
+}
+
+// View returns the view of conn, if it has one
+func (s *Server) View(conn net.Conn) (*database.View, bool) {
+	if s.viewCount.Load() == 0 {
+		return nil, false
+	}
+	s.viewMutex.Lock()
+	defer s.viewMutex.Unlock()
+	view, open := s.views[conn]
+	return view, open
+}
+
+// EndView releases the view of conn, e.g. when it disconnects. It returns
+// false when conn had none.
+func (s *Server) EndView(conn net.Conn) bool {
+	s.viewMutex.Lock()
+	view, open := s.views[conn]
+	if open {
+		delete(s.views, conn)
+		s.viewCount.Add(-1)
+	}
+	s.viewMutex.Unlock()
+
+	if open {
+		view.Release()
+	}
+	return open
+}
@@ -0,0 +1,150 @@
+package stats
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limits applied to the arguments kept in a slowlog entry, matching Redis
+const (
+	slowlogMaxArgs   = 32
+	slowlogMaxArgLen = 128
+)
+
+// SlowEntry is one command recorded by the slowlog
+type SlowEntry struct {
+	ID       int64
+	Time     time.Time
+	Duration time.Duration
+	Args     []string // Command name and arguments, possibly truncated
+	Addr     string   // Client address
+	Name     string   // Client name, "" when none was set
+}
+
+// SlowLog keeps the most recent commands that ran for longer than a
+// threshold. A negative threshold disables it and zero logs every command.
+type SlowLog struct {
+	entries []SlowEntry // Newest last
+	nextID  int64
+	mutex   sync.Mutex
+
+	// Read without the lock, as every command checks them
+	threshold atomic.Int64 // time.Duration
+	maxLen    atomic.Int64
+}
+
+func NewSlowLog(threshold time.Duration, maxLen int) *SlowLog {
+	s := &SlowLog{}
+	s.threshold.Store(int64(threshold))
+	s.maxLen.Store(int64(maxLen))
+	return s
+}
+
+// IsSlow reports whether a command that took d would be logged, so callers
+// can skip building its entry otherwise
+func (s *SlowLog) IsSlow(d time.Duration) bool {
+	threshold := time.Duration(s.threshold.Load())
+	return threshold >= 0 && d >= threshold && s.maxLen.Load() > 0
+}
+
+// Add records a command that took d if it is slow enough
+func (s *SlowLog) Add(d time.Duration, args []string, addr, name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.IsSlow(d) {
+		return
+	}
+
+	s.entries = append(s.entries, SlowEntry{
+		ID:       s.nextID,
+		Time:     time.Now(),
+		Duration: d,
+		Args:     truncateArgs(args),
+		Addr:     addr,
+		Name:     name,
+	})
+	s.nextID++
+	s.trim()
+}
+
+// Get returns up to n entries, newest first; a negative n returns them all
+func (s *SlowLog) Get(n int) []SlowEntry {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if n < 0 || n > len(s.entries) {
+		n = len(s.entries)
+	}
+	out := make([]SlowEntry, n)
+	for i := range out {
+		out[i] = s.entries[len(s.entries)-1-i]
+	}
+	return out
+}
+
+// Len returns the number of entries currently kept
+func (s *SlowLog) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.entries)
+}
+
+// Reset drops every entry; IDs keep increasing
+func (s *SlowLog) Reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.entries = nil
+}
+
+// Threshold returns the duration above which commands are logged
+func (s *SlowLog) Threshold() time.Duration {
+	return time.Duration(s.threshold.Load())
+}
+
+// SetThreshold changes the duration above which commands are logged
+func (s *SlowLog) SetThreshold(d time.Duration) {
+	s.threshold.Store(int64(d))
+}
+
+// MaxLen returns how many entries are kept
+func (s *SlowLog) MaxLen() int {
+	return int(s.maxLen.Load())
+}
+
+// SetMaxLen changes how many entries are kept, dropping the oldest ones
+// if the log is now too long
+func (s *SlowLog) SetMaxLen(n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.maxLen.Store(int64(n))
+	s.trim()
+}
+
+// trim must be called with the lock held
+func (s *SlowLog) trim() {
+	if excess := len(s.entries) - s.MaxLen(); excess > 0 {
+		s.entries = append([]SlowEntry(nil), s.entries[excess:]...)
+	}
+}
+
+// truncateArgs copies args, shortening long arguments and long argument
+// lists the way Redis does so a huge command cannot bloat the log
+func truncateArgs(args []string) []string {
+	n := min(len(args), slowlogMaxArgs)
+	out := make([]string, n)
+	for i := range out {
+		if i == slowlogMaxArgs-1 && len(args) > slowlogMaxArgs {
+			out[i] = fmt.Sprintf("... (%d more arguments)", len(args)-slowlogMaxArgs+1)
+			break
+		}
+		arg := args[i]
+		if len(arg) > slowlogMaxArgLen {
+			arg = fmt.Sprintf("%s... (%d more bytes)", arg[:slowlogMaxArgLen], len(arg)-slowlogMaxArgLen)
+		}
+		out[i] = arg
+	}
+	return out
+}
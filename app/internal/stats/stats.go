@@ -0,0 +1,123 @@
+// Package stats records per-command call counts and latencies for INFO
+// commandstats / latencystats.
+package stats
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencySamples is how many recent durations each command keeps for the
+// percentile estimates in INFO latencystats
+const latencySamples = 1024
+
+// command holds the counters of a single command
+type command struct {
+	calls   int64
+	total   time.Duration
+	samples []time.Duration // Ring buffer of the most recent durations
+	next    int
+}
+
+// CommandStat is a point-in-time copy of a command's counters
+type CommandStat struct {
+	Name  string
+	Calls int64
+	Total time.Duration
+}
+
+// PerCall returns the average duration of one call
+func (s CommandStat) PerCall() time.Duration {
+	if s.Calls == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Calls)
+}
+
+// Latency holds latency percentiles of one command
+type Latency struct {
+	Name string
+	P50  time.Duration
+	P99  time.Duration
+	P999 time.Duration
+}
+
+// Commands aggregates statistics for every command the server executes
+type Commands struct {
+	commands map[string]*command
+	mutex    sync.Mutex
+}
+
+func NewCommands() *Commands {
+	return &Commands{commands: make(map[string]*command)}
+}
+
+// Record adds one call of name that took d
+func (c *Commands) Record(name string, d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cmd, ok := c.commands[name]
+	if !ok {
+		cmd = &command{}
+		c.commands[name] = cmd
+	}
+	cmd.calls++
+	cmd.total += d
+	if len(cmd.samples) < latencySamples {
+		cmd.samples = append(cmd.samples, d)
+	} else {
+		cmd.samples[cmd.next] = d
+		cmd.next = (cmd.next + 1) % latencySamples
+	}
+}
+
+// Stats returns the counters of every command, sorted by name
+func (c *Commands) Stats() []CommandStat {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	out := make([]CommandStat, 0, len(c.commands))
+	for name, cmd := range c.commands {
+		out = append(out, CommandStat{Name: name, Calls: cmd.calls, Total: cmd.total})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Latencies returns latency percentiles of every command, sorted by name.
+// The samples are sorted on every call, so this is only meant for on-demand
+// reporting.
+func (c *Commands) Latencies() []Latency {
+	c.mutex.Lock()
+	snapshot := make(map[string][]time.Duration, len(c.commands))
+	for name, cmd := range c.commands {
+		snapshot[name] = append([]time.Duration(nil), cmd.samples...)
+	}
+	c.mutex.Unlock()
+
+	out := make([]Latency, 0, len(snapshot))
+	for name, samples := range snapshot {
+		sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+		out = append(out, Latency{
+			Name: name,
+			P50:  percentile(samples, 50),
+			P99:  percentile(samples, 99),
+			P999: percentile(samples, 99.9),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// percentile returns the p-th percentile of sorted samples using the
+// nearest-rank method
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p/100*float64(len(sorted))+0.5) - 1
+	rank = max(0, min(rank, len(sorted)-1))
+	return sorted[rank]
+}
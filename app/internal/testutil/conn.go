@@ -0,0 +1,153 @@
+// Package testutil provides an in-memory net.Conn pair and RESP reply
+// decoding so command handlers can be driven without opening sockets.
+package testutil
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// nextPort hands out fake port numbers so every Conn has a distinct address
+var nextPort atomic.Int32
+
+// pipe is one direction of a Conn pair. Unlike net.Pipe, writes are buffered
+// and never block, so a handler can write its whole reply before the test
+// reads it.
+type pipe struct {
+	buf    bytes.Buffer
+	closed bool
+	notify chan struct{} // Signalled on every write and on close
+	mutex  sync.Mutex
+}
+
+func newPipe() *pipe {
+	return &pipe{notify: make(chan struct{}, 1)}
+}
+
+func (p *pipe) signal() {
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (p *pipe) write(b []byte) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.closed {
+		return 0, net.ErrClosed
+	}
+	n, _ := p.buf.Write(b)
+	p.signal()
+	return n, nil
+}
+
+// read blocks until data is available, the pipe is closed or deadline passes
+func (p *pipe) read(b []byte, deadline func() time.Time) (int, error) {
+	for {
+		p.mutex.Lock()
+		if p.buf.Len() > 0 {
+			n, _ := p.buf.Read(b)
+			p.mutex.Unlock()
+			return n, nil
+		}
+		if p.closed {
+			p.mutex.Unlock()
+			return 0, io.EOF
+		}
+		p.mutex.Unlock()
+
+		d := deadline()
+		if d.IsZero() {
+			<-p.notify
+			continue
+		}
+		wait := time.Until(d)
+		if wait <= 0 {
+			return 0, os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-p.notify:
+			timer.Stop()
+		case <-timer.C:
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+func (p *pipe) close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.closed = true
+	p.signal()
+}
+
+// Conn is one end of an in-memory connection created by Pipe
+type Conn struct {
+	rx, tx        *pipe
+	local, remote net.Addr
+	readDeadline  time.Time
+	closeOnce     sync.Once
+	mutex         sync.Mutex
+}
+
+// Pipe returns the two ends of an in-memory connection: bytes written to one
+// are read from the other. By convention the first is handed to the server
+// side (a handler) and the second plays the client.
+func Pipe() (*Conn, *Conn) {
+	serverAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 6379}
+	clientAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000 + int(nextPort.Add(1))}
+	a, b := newPipe(), newPipe()
+
+	return &Conn{rx: a, tx: b, local: serverAddr, remote: clientAddr},
+		&Conn{rx: b, tx: a, local: clientAddr, remote: serverAddr}
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	return c.rx.read(b, func() time.Time {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		return c.readDeadline
+	})
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	return c.tx.write(b)
+}
+
+// Close closes both directions; the peer reads EOF once it has drained what
+// was already written
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		c.rx.close()
+		c.tx.close()
+	})
+	return nil
+}
+
+func (c *Conn) LocalAddr() net.Addr  { return c.local }
+func (c *Conn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.readDeadline = t
+	c.rx.signal()
+	return nil
+}
+
+// SetWriteDeadline is a no-op since writes never block
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,49 @@
+package testutil
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/pkg/client"
+)
+
+// Reply is a decoded RESP reply, shared with the public client package
+type Reply = client.Reply
+
+// ReadReply decodes one RESP reply from reader
+func ReadReply(reader *bufio.Reader) (Reply, error) {
+	return client.ReadReply(reader)
+}
+
+// Client wraps the client end of a Pipe with helpers for sending commands
+// and reading replies
+type Client struct {
+	Conn   *Conn
+	reader *bufio.Reader
+}
+
+// NewClient returns a Client reading and writing through conn
+func NewClient(conn *Conn) *Client {
+	return &Client{Conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// Send writes args as a RESP command array
+func (c *Client) Send(args ...string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := c.Conn.Write([]byte(b.String()))
+	return err
+}
+
+// ReadReply reads the next reply, giving up after timeout so a handler that
+// never answers fails the test instead of hanging it
+func (c *Client) ReadReply(timeout time.Duration) (Reply, error) {
+	c.Conn.SetReadDeadline(time.Now().Add(timeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+	return ReadReply(c.reader)
+}
@@ -3,6 +3,7 @@ package transaction
 import (
 	"net"
 	"sync"
+	"sync/atomic"
 )
 
 type QueuedCommand struct {
@@ -13,17 +14,35 @@ type QueuedCommand struct {
 type Transaction struct {
 	Commands      []QueuedCommand
 	InTransaction bool
+	Dirty         bool // A command was refused while queueing, so EXEC aborts
 	mutex         sync.RWMutex
 }
 
+// watchState is what WATCH recorded for a connection
+type watchState struct {
+	keys  map[string]bool // Watched keys, and whether each held a live value when watched
+	dirty bool            // A watched key was written since it was watched
+}
+
 type Manager struct {
 	transactions map[net.Conn]*Transaction
 	mutex        sync.RWMutex
+
+	// Watched keys are tracked from both sides, like in Redis: a write
+	// looks up who watches its keys, and EXEC or a closing connection
+	// looks up what it watched. watchCount lets writes skip the lookup
+	// while nobody watches anything.
+	watchers   map[string]map[net.Conn]struct{}
+	watches    map[net.Conn]*watchState
+	watchCount atomic.Int32 // len(watches)
+	watchMutex sync.Mutex
 }
 
 func NewManager() *Manager {
 	return &Manager{
 		transactions: make(map[net.Conn]*Transaction),
+		watchers:     make(map[string]map[net.Conn]struct{}),
+		watches:      make(map[net.Conn]*watchState),
 	}
 }
 
@@ -59,6 +78,33 @@ func (m *Manager) QueueCommand(conn net.Conn, command string, args []string) {
 	}
 }
 
+// MarkDirty flags conn's transaction as having refused a command. Like in
+// Redis, EXEC then discards the whole transaction instead of running the
+// commands that were queued.
+func (m *Manager) MarkDirty(conn net.Conn) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if transaction, exists := m.transactions[conn]; exists {
+		transaction.mutex.Lock()
+		transaction.Dirty = true
+		transaction.mutex.Unlock()
+	}
+}
+
+// IsDirty reports whether conn's transaction refused a command
+func (m *Manager) IsDirty(conn net.Conn) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if transaction, exists := m.transactions[conn]; exists {
+		transaction.mutex.RLock()
+		defer transaction.mutex.RUnlock()
+		return transaction.Dirty
+	}
+	return false
+}
+
 func (m *Manager) GetQueuedCommands(conn net.Conn) []QueuedCommand {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
@@ -75,23 +121,142 @@ func (m *Manager) GetQueuedCommands(conn net.Conn) []QueuedCommand {
 	return nil
 }
 
+// EndTransaction forgets conn's transaction after EXEC, along with the keys
+// it watched
 func (m *Manager) EndTransaction(conn net.Conn) {
 	m.mutex.Lock()
-	defer m.mutex.Unlock()
-
 	delete(m.transactions, conn)
+	m.mutex.Unlock()
+	m.Unwatch(conn)
 }
 
+// DiscardTransaction drops conn's transaction without running it, along
+// with the keys it watched
 func (m *Manager) DiscardTransaction(conn net.Conn) {
 	m.mutex.Lock()
-	defer m.mutex.Unlock()
-
 	delete(m.transactions, conn)
+	m.mutex.Unlock()
+	m.Unwatch(conn)
 }
 
 func (m *Manager) CleanupConnection(conn net.Conn) {
 	m.mutex.Lock()
-	defer m.mutex.Unlock()
-
 	delete(m.transactions, conn)
+	m.mutex.Unlock()
+	m.Unwatch(conn)
+}
+
+// Watch adds key to the keys conn watches; live tells whether it holds a
+// value now. Watching a key again keeps what was recorded the first time.
+func (m *Manager) Watch(conn net.Conn, key string, live bool) {
+	m.watchMutex.Lock()
+	defer m.watchMutex.Unlock()
+
+	state, exists := m.watches[conn]
+	if !exists {
+		state = &watchState{keys: make(map[string]bool)}
+		m.watches[conn] = state
+		m.watchCount.Add(1)
+	}
+	if _, watched := state.keys[key]; watched {
+		return
+	}
+	state.keys[key] = live
+
+	conns, exists := m.watchers[key]
+	if !exists {
+		conns = make(map[net.Conn]struct{})
+		m.watchers[key] = conns
+	}
+	conns[conn] = struct{}{}
+}
+
+// Unwatch forgets every key conn watches
+func (m *Manager) Unwatch(conn net.Conn) {
+	if m.watchCount.Load() == 0 {
+		return
+	}
+
+	m.watchMutex.Lock()
+	defer m.watchMutex.Unlock()
+
+	state, exists := m.watches[conn]
+	if !exists {
+		return
+	}
+	for key := range state.keys {
+		conns := m.watchers[key]
+		delete(conns, conn)
+		if len(conns) == 0 {
+			delete(m.watchers, key)
+		}
+	}
+	delete(m.watches, conn)
+	m.watchCount.Add(-1)
+}
+
+// Watching reports whether any connection watches a key
+func (m *Manager) Watching() bool {
+	return m.watchCount.Load() > 0
+}
+
+// TouchKeys marks the connections watching any of keys as dirty, so their
+// next EXEC fails. A write touches its keys before it runs, so an EXEC
+// that checked its watched keys holds it off until done, and again after,
+// for keys watched while it ran. It returns at once while no connection
+// watches anything.
+func (m *Manager) TouchKeys(keys []string) {
+	if m.watchCount.Load() == 0 {
+		return
+	}
+
+	m.watchMutex.Lock()
+	defer m.watchMutex.Unlock()
+	m.touchLocked(keys)
+}
+
+func (m *Manager) touchLocked(keys []string) {
+	for _, key := range keys {
+		for conn := range m.watchers[key] {
+			m.watches[conn].dirty = true
+		}
+	}
+}
+
+// ExecWatched calls run, which executes conn's transaction, unless a key
+// conn watches changed since it was watched, in which case it returns
+// false without calling it. A key changed when it was written, or when it
+// held a value then and is gone now, which is how an expired key counts;
+// live tells whether a key holds a value. writes are the keys the
+// transaction writes, touched for the other watchers before run. The
+// caller keeps every other write out until run returns, so none lands
+// between the check and the end of the transaction.
+func (m *Manager) ExecWatched(conn net.Conn, live func(key string) bool, writes []string, run func()) bool {
+	if m.watchCount.Load() > 0 && m.watchedKeyChanged(conn, live) {
+		return false
+	}
+	m.TouchKeys(writes)
+	run()
+	return true
+}
+
+// watchedKeyChanged reports whether a key conn watches changed, see
+// ExecWatched
+func (m *Manager) watchedKeyChanged(conn net.Conn, live func(key string) bool) bool {
+	m.watchMutex.Lock()
+	defer m.watchMutex.Unlock()
+
+	state, exists := m.watches[conn]
+	if !exists {
+		return false
+	}
+	if state.dirty {
+		return true
+	}
+	for key, wasLive := range state.keys {
+		if wasLive && !live(key) {
+			return true
+		}
+	}
+	return false
 }
@@ -2,16 +2,26 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/r0ld3x/redis-clone-go/app/internal/client"
 	"github.com/r0ld3x/redis-clone-go/app/internal/commands"
 	"github.com/r0ld3x/redis-clone-go/app/internal/config"
 	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+	"github.com/r0ld3x/redis-clone-go/app/internal/netpoll"
 	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
 	"github.com/r0ld3x/redis-clone-go/app/internal/server"
+	"github.com/r0ld3x/redis-clone-go/app/pkg/aof"
+	"github.com/r0ld3x/redis-clone-go/app/pkg/cluster"
 	"github.com/r0ld3x/redis-clone-go/app/pkg/database"
 	"github.com/r0ld3x/redis-clone-go/app/pkg/rdb"
 )
@@ -25,41 +35,90 @@ func main() {
 
 	// Load configuration
 	cfg := config.LoadConfig()
-	logger.Info("Server configuration: %+v", cfg)
+	level, _ := logging.ParseLevel(cfg.LogLevel)
+	logging.SetLevel(level)
+	format, _ := logging.ParseFormat(cfg.LogFormat)
+	logging.SetFormat(format)
+	if cfg.IsSlave() {
+		logging.SetRole('S')
+	}
+	if cfg.LogFile != "" {
+		logFile, err := os.OpenFile(cfg.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Fatalf("failed to open log file %s: %v", cfg.LogFile, err)
+		}
+		logging.SetOutput(logFile)
+	}
+
+	if cfg.CheckRDB != "" || cfg.CheckAOF != "" {
+		os.Exit(runChecks(cfg))
+	}
+
+	database.SetLockProfiling(cfg.LockProfiling)
+	database.SetActiveDefrag(cfg.ActiveDefrag)
+	protocol.SetMaxBulkLen(cfg.ProtoMaxBulkLen)
+	database.SetMaxStringSize(cfg.ProtoMaxBulkLen)
+	if !commands.SetKeyspaceEvents(cfg.NotifyKeyspaceEvents) {
+		log.Fatalf("invalid --notify-keyspace-events %q, expected characters of KEA g$lshzxetmnd", cfg.NotifyKeyspaceEvents)
+	}
 
 	// Create server instance
 	srv := server.NewServer(cfg)
 
+	if err := srv.Audit.SetEnabled(cfg.AuditLog); err != nil {
+		logger.Error("Failed to open audit log: %v", err)
+	}
+
+	if cfg.ClusterConfigFile != "" {
+		topology, err := cluster.Load(cfg.ClusterConfigFile, cfg.GetListenAddress())
+		if err != nil {
+			log.Fatalf("failed to load cluster config %s: %v", cfg.ClusterConfigFile, err)
+		}
+		srv.Cluster = topology
+		served, assigned := topology.SlotCounts()
+		logger.Info("Cluster mode enabled, node %s serving %d of %d assigned slots", topology.Myself().ID, served, assigned)
+	}
+
 	// Set up command registry
 	registry := commands.NewRegistry()
 	registry.RegisterAllHandlers()
 
-	// Connect to master if this is a replica
-	if cfg.IsSlave() {
-		logger.Info("Connecting to master at %s", cfg.MasterAddress)
+	// Settle the connection mode before anything can report it
+	var poller *netpoll.Poller
+	if cfg.EventLoop {
 		var err error
-		srv.MasterConn, err = net.Dial("tcp", cfg.MasterAddress)
-		if err != nil {
-			log.Fatalf("couldn't connect to master at %s: %v", cfg.MasterAddress, err)
+		if poller, err = netpoll.New(); err != nil {
+			logger.Error("Event loop unavailable, falling back to one goroutine per connection: %v", err)
+			cfg.EventLoop = false
 		}
-		logger.Success("Connected to master successfully")
-		go func() {
-			reader := bufio.NewReader(srv.MasterConn)
-			if err := srv.SendHandshake(reader); err != nil {
-				log.Fatalf("handshake failed: %v", err)
-			}
-			// srv.Logger.Debug("Sending REPLCONF GETACK * to %v", srv.MasterConn.RemoteAddr())
-			// cmd := []string{"REPLCONF", "ACK", fmt.Sprintf("%d", srv.ReplicationOffset)}
-			// protocol.WriteArray(srv.MasterConn, cmd)
-			handleMasterConnection(srv, reader)
-		}()
+	}
+
+	// The same values CONFIG GET and INFO config report
+	for _, param := range commands.ConfigValues(srv) {
+		logger.Info("Config %s: %q", param.Name, param.Value)
+	}
+
+	// Keep the cheap INFO sections warm so INFO doesn't walk the keyspace
+	srv.AddCronJob(func() { commands.RefreshInfoCache(srv) })
+	srv.AddCronJob(database.UpdateLRUClock)
+	srv.AddCronJob(database.DefragCron)
+	srv.AddCronJob(database.ScanIndexCron)
+	srv.StartCron(server.CronInterval)
+	go commands.ActiveExpire(srv)
+
+	// Connect to master if this is a replica
+	if cfg.IsSlave() {
+		go replicate(srv, registry)
 	}
 
 	if cfg.IsMaster() && cfg.DBFileName != "" {
-		rdbPath := cfg.Directory + "/" + cfg.DBFileName
+		rdbPath := cfg.RDBPath()
 		logger.Info("Loading RDB file: %s", rdbPath)
-		if err := rdb.ParseRDB(rdbPath); err != nil {
+		aux, err := rdb.ParseRDB(rdbPath)
+		if err != nil {
 			logger.Error("Failed to load RDB file: %v", err)
+		} else if offset, err := strconv.Atoi(aux["repl-offset"]); err == nil && aux["repl-id"] != "" {
+			srv.ResumeReplication(aux["repl-id"], offset)
 		}
 	}
 
@@ -73,6 +132,23 @@ func main() {
 
 	logger.Success("[%s] Server listening on %s", cfg.Role, listenAddress)
 
+	// SIGINT and SIGTERM shut down like SHUTDOWN; a second one while
+	// waiting for replicas kills the process at once
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		signal.Stop(signals)
+		logger.Info("Received %v", sig)
+		if err := srv.Shutdown(time.Duration(srv.Config.ShutdownTimeout)*time.Second, cfg.DBFileName != "", false); err != nil {
+			logger.Error("Still running, as the RDB file could not be saved; signal again to stop anyway")
+		}
+	}()
+
+	if poller != nil {
+		serveEventLoop(srv, l, registry, poller)
+	}
+
 	// Accept connections
 	for {
 		conn, err := l.Accept()
@@ -85,76 +161,190 @@ func main() {
 	}
 }
 
-func handleClientConnection(srv *server.Server, conn net.Conn, registry *commands.Registry) {
-	logger := logging.NewLogger("CONNECTION")
-	logger.Info("Starting connection handler for %s", conn.RemoteAddr())
+// session is one client connection being served
+type session struct {
+	conn   net.Conn // Byte-counting wrapper registered with srv.Clients
+	client *client.Client
+	reader *bufio.Reader
+	logger *logging.Logger
+	// subscribed is set while the connection is in subscribe mode, where it
+	// may only run the commands of commands.ContextSubscribed. It is
+	// refreshed after each command that can change it rather than asked of
+	// the broker for every command.
+	subscribed bool
+}
 
-	defer func() {
-		conn.Close()
-		srv.RemoveReplica(conn)
-		srv.TransactionMgr.CleanupConnection(conn)
-	}()
+// newSession registers conn as a client. From here on s.conn counts the
+// bytes it carries for CLIENT INFO and must be used in place of conn.
+func newSession(srv *server.Server, conn net.Conn) *session {
+	c, wrapped := srv.Clients.Register(conn)
+	return &session{conn: wrapped, client: c, logger: logging.NewLogger("CONNECTION")}
+}
 
-	scanner := bufio.NewReader(conn)
+// close releases everything the server tracks for the connection
+func (s *session) close(srv *server.Server) {
+	srv.Clients.Unregister(s.conn)
+	s.conn.Close()
+	reason := "connection closed"
+	if s.client.Killed() {
+		reason = "killed by CLIENT KILL"
+	}
+	srv.RemoveReplica(s.conn, reason)
+	srv.TransactionMgr.CleanupConnection(s.conn)
+	srv.PubSub.RemoveConnection(s.conn)
+	srv.Monitors.Remove(s.conn)
+	srv.EndView(s.conn)
+}
+
+func handleClientConnection(srv *server.Server, conn net.Conn, registry *commands.Registry) {
+	s := newSession(srv, conn)
+	s.logger.Info("Starting connection handler for %s", conn.RemoteAddr())
+	defer s.close(srv)
+
+	s.reader = bufio.NewReader(s.conn)
 
 	for {
-		if srv.IsConnectionClosed(conn) {
-			logger.Info("Connection closed by client: %s", conn.RemoteAddr())
+		// Input read ahead during a blocking command must reach the reader
+		// rather than this probe
+		if !s.client.HasPendingInput() && srv.IsConnectionClosed(s.conn) {
+			s.logger.Info("Connection closed by client: %s", s.conn.RemoteAddr())
 			return
 		}
 
-		logger.Debug("Waiting for command from %s", conn.RemoteAddr())
-		args, ok := protocol.ReadArrayArguments(scanner)
-		if !ok {
-			logger.Info("Connection closed or error reading from: %s", conn.RemoteAddr())
+		s.logger.Debug("Waiting for command from %s", s.conn.RemoteAddr())
+		if !s.serveCommand(srv, registry) {
 			return
 		}
+	}
+}
 
-		logger.Network("IN", "Received command from %s: %v", conn.RemoteAddr(), args)
+// serveCommand reads one command from s.reader and runs it. It returns
+// false once the connection should be closed.
+func (s *session) serveCommand(srv *server.Server, registry *commands.Registry) bool {
+	logger, conn := s.logger, s.conn
 
-		if len(args) < 1 {
-			logger.Error("Empty command received from %s", conn.RemoteAddr())
-			protocol.WriteError(conn, "ERR parsing args")
-			return
+	args, err := protocol.ReadArrayArguments(s.reader)
+	if err != nil {
+		var protoErr protocol.ProtocolError
+		if errors.As(err, &protoErr) {
+			logger.Error("Protocol error from %s: %v", conn.RemoteAddr(), err)
+			protocol.WriteError(conn, protoErr.Error())
+			return false
 		}
+		logger.Info("Connection closed or error reading from: %s", conn.RemoteAddr())
+		return false
+	}
 
-		cmd := strings.ToUpper(args[0])
-		commandArgs := args[1:]
-
-		if srv.TransactionMgr.IsInTransaction(conn) {
-			if cmd == "EXEC" || cmd == "DISCARD" || cmd == "MULTI" {
-				handler, exists := registry.Get(commands.Command(cmd))
-				if exists {
-					handler.Handle(srv, conn, commandArgs)
-				} else {
-					protocol.WriteError(conn, "unknown command '"+cmd+"'")
-				}
-			} else {
-				srv.TransactionMgr.QueueCommand(conn, cmd, commandArgs)
-				protocol.WriteSimpleString(conn, "QUEUED")
+	logger.Network("IN", "Received command from %s: %v", conn.RemoteAddr(), args)
+
+	if len(args) < 1 {
+		logger.Error("Empty command received from %s", conn.RemoteAddr())
+		protocol.WriteError(conn, "ERR parsing args")
+		return false
+	}
+
+	cmd := strings.ToUpper(args[0])
+	commandArgs := args[1:]
+	s.client.RecordCommand(strings.ToLower(cmd), s.reader.Buffered())
+
+	if s.subscribed {
+		// Replies must not overtake the messages already sent to it
+		srv.PubSub.Flush(conn)
+		if refused := registry.SubscribedError(srv, args[0], commandArgs); refused != "" {
+			protocol.WriteError(conn, refused)
+			return true
+		}
+	}
+	if commands.ChangesSubscribeMode(commands.Command(cmd)) {
+		defer func() { s.subscribed = srv.PubSub.SubscriptionCount(conn) > 0 }()
+	}
+
+	// QUIT closes the connection once answered, even inside MULTI
+	if cmd == string(commands.QuitCommand) {
+		registry.Execute(srv, conn, commands.OriginClient, cmd, commandArgs)
+		return false
+	}
+
+	if srv.TransactionMgr.IsInTransaction(conn) {
+		if cmd == "EXEC" || cmd == "DISCARD" || cmd == "MULTI" || cmd == "WATCH" || cmd == "RESET" {
+			if exists, _ := registry.Execute(srv, conn, commands.OriginClient, cmd, commandArgs); !exists {
+				protocol.WriteError(conn, registry.UnknownCommandError(args[0], commandArgs, srv.Config.UnknownCommandSuggestions))
 			}
+		} else if refused := registry.QueueError(srv, args[0], commandArgs); refused != "" {
+			// Like Redis, a command that can't run is refused at queue time
+			// and makes the EXEC abort
+			srv.TransactionMgr.MarkDirty(conn)
+			protocol.WriteError(conn, refused)
 		} else {
-			logger.Debug("Processing command: '%s' with args: %v", cmd, commandArgs)
-
-			handler, exists := registry.Get(commands.Command(cmd))
-			if exists {
-				logger.Debug("Found handler for command: %s", cmd)
-				if err := handler.Handle(srv, conn, commandArgs); err != nil {
-					logger.Error("Handler error for command %s: %v", cmd, err)
-					protocol.WriteError(conn, "ERR internal server error")
-				}
-			} else {
-				logger.Error("No handler found for command: %s", cmd)
-				protocol.WriteError(conn, "unknown command '"+cmd+"'")
-			}
+			srv.TransactionMgr.QueueCommand(conn, cmd, commandArgs)
+			protocol.WriteSimpleString(conn, "QUEUED")
 		}
+		return true
 	}
+
+	logger.Debug("Processing command: '%s' with args: %v", cmd, commandArgs)
+
+	exists, err := registry.Execute(srv, conn, commands.OriginClient, cmd, commandArgs)
+	if err != nil {
+		logger.Error("Handler error for command %s: %v", cmd, err)
+		protocol.WriteError(conn, "ERR internal server error")
+	}
+	if !exists {
+		logger.Error("No handler found for command: %s", cmd)
+		protocol.WriteError(conn, registry.UnknownCommandError(args[0], commandArgs, srv.Config.UnknownCommandSuggestions))
+	}
+	return true
+}
+
+// Reconnect delays used while the master is unreachable or refuses the
+// handshake
+const (
+	minMasterBackoff = time.Second
+	maxMasterBackoff = 30 * time.Second
+)
+
+// replicate keeps a replica attached to its master. Failed connections and
+// handshakes are retried with exponential backoff instead of exiting, and a
+// lost link is re-established the same way.
+func replicate(srv *server.Server, registry *commands.Registry) {
+	logger := logging.NewLogger("REPLICA")
+	backoff := minMasterBackoff
+
+	for {
+		logger.Info("Connecting to master at %s", srv.Config.MasterAddress)
+		reader, err := srv.ConnectToMaster()
+		if err != nil {
+			logger.Error("Couldn't sync with master at %s: %v (retrying in %s)", srv.Config.MasterAddress, err, backoff)
+			time.Sleep(backoff)
+			backoff = min(backoff*2, maxMasterBackoff)
+			continue
+		}
+		logger.Success("Connected to master successfully")
+		backoff = minMasterBackoff
+
+		handleMasterConnection(srv, reader, registry)
+		srv.MasterConn.Close()
+		logger.Info("Connection to master closed, reconnecting")
+	}
+}
+
+// discardConn swallows handler replies so commands propagated by the master
+// can be applied through the registry without answering the master.
+type discardConn struct {
+	net.Conn
 }
 
-func handleMasterConnection(srv *server.Server, reader *bufio.Reader) {
+func (c discardConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func handleMasterConnection(srv *server.Server, reader *bufio.Reader, registry *commands.Registry) {
 	logger := logging.NewLogger("REPLICA")
 	logger.Info("Starting to handle commands from master")
 
+	applyConn := discardConn{Conn: srv.MasterConn}
+	defer srv.TransactionMgr.CleanupConnection(applyConn)
+
 	// scanner := bufio.NewScanner(srv.MasterConn)
 
 	for {
@@ -163,14 +353,12 @@ func handleMasterConnection(srv *server.Server, reader *bufio.Reader) {
 			return
 		}
 
-		args, ok := protocol.ReadArrayArguments(reader)
-		if !ok {
+		args, err := protocol.ReadArrayArgumentsUnlimited(reader)
+		if err != nil {
 			logger.Error("Connection to master lost or error reading")
 			return
 		}
 
-		logger.Network("IN", "Received command from master: %v", args)
-
 		if len(args) == 0 {
 			continue
 		}
@@ -178,36 +366,28 @@ func handleMasterConnection(srv *server.Server, reader *bufio.Reader) {
 		commandBytes := len(protocol.EncodeArray(args))
 		cmd := strings.ToUpper(args[0])
 
+		// Every propagated write passes here, so per-command logging is
+		// sampled like on the master
+		verbose := srv.ReplLog.Sample()
+		if verbose {
+			logger.Network("IN", "Received command from master: %v (+%d bytes, offset %d)", args, commandBytes, srv.ReplicationOffset)
+		}
+
+		// A transaction the master propagated is queued like a client's
+		// and applied at once by its EXEC
+		if srv.TransactionMgr.IsInTransaction(applyConn) && cmd != "EXEC" && cmd != "DISCARD" && cmd != "REPLCONF" {
+			srv.ReplicationOffset += commandBytes
+			srv.AdvanceMasterOffset(commandBytes)
+			srv.TransactionMgr.QueueCommand(applyConn, cmd, args[1:])
+			continue
+		}
+
 		switch cmd {
 		case "PING":
-			// Update offset for PING
-			oldOffset := srv.ReplicationOffset
 			srv.ReplicationOffset += commandBytes
-			logger.Debug("Updated replication offset for PING: %d -> %d (+%d bytes)",
-				oldOffset, srv.ReplicationOffset, commandBytes)
-			logger.Info("Received PING from master, offset now: %d", srv.ReplicationOffset)
-
-		case "SET":
-			// Update offset for SET
-			oldOffset := srv.ReplicationOffset
-			srv.ReplicationOffset += commandBytes
-			logger.Debug("Updated replication offset for SET: %d -> %d (+%d bytes)",
-				oldOffset, srv.ReplicationOffset, commandBytes)
-
-			if len(args) >= 3 {
-				key := args[1]
-				val := args[2]
-				ms := -1
-				if len(args) == 5 && strings.ToUpper(args[3]) == "PX" {
-					ms, _ := fmt.Sscanf(args[4], "%d", &ms)
-					_ = ms // Use the parsed value
-				}
-				database.SetKey(key, val, ms)
-				logger.Info("Applied SET %s=%s (TTL: %d ms), offset now: %d", key, val, ms, srv.ReplicationOffset)
-			}
+			srv.AdvanceMasterOffset(commandBytes)
 
 		case "REPLCONF":
-			fmt.Printf("REPLCONF COMMAND: %+v", commandBytes)
 			if len(args) >= 2 {
 				subcommand := strings.ToUpper(args[1])
 				switch subcommand {
@@ -218,27 +398,49 @@ func handleMasterConnection(srv *server.Server, reader *bufio.Reader) {
 					protocol.WriteArray(srv.MasterConn, []string{"REPLCONF", "ACK", fmt.Sprintf("%d", srv.ReplicationOffset)})
 
 					// Update offset AFTER responding
-					oldOffset := srv.ReplicationOffset
 					srv.ReplicationOffset += commandBytes
-					logger.Debug("Updated replication offset for GETACK: %d -> %d (+%d bytes)",
-						oldOffset, srv.ReplicationOffset, commandBytes)
 				default:
-					// Update offset for other REPLCONF commands
-					oldOffset := srv.ReplicationOffset
 					srv.ReplicationOffset += commandBytes
-					logger.Debug("Updated replication offset for REPLCONF %s: %d -> %d (+%d bytes)",
-						subcommand, oldOffset, srv.ReplicationOffset, commandBytes)
-					logger.Info("Received REPLCONF %s, offset now: %d", subcommand, srv.ReplicationOffset)
+					srv.AdvanceMasterOffset(commandBytes)
 				}
 			}
 
 		default:
-			// Update offset for any other commands
-			oldOffset := srv.ReplicationOffset
 			srv.ReplicationOffset += commandBytes
-			logger.Debug("Updated replication offset for %s: %d -> %d (+%d bytes)",
-				cmd, oldOffset, srv.ReplicationOffset, commandBytes)
-			logger.Info("Received %s, offset now: %d", cmd, srv.ReplicationOffset)
+			srv.AdvanceMasterOffset(commandBytes)
+
+			if _, err := registry.Execute(srv, applyConn, commands.OriginMaster, cmd, args[1:]); err != nil {
+				logger.Error("Failed to apply %s from master: %v", cmd, err)
+			}
+		}
+	}
+}
+
+// runChecks validates the files given by --check-rdb / --check-aof, prints a
+// report for each and returns the process exit code
+func runChecks(cfg *config.Config) int {
+	code := 0
+	if cfg.CheckRDB != "" {
+		report, err := rdb.Check(cfg.CheckRDB)
+		if err != nil {
+			fmt.Printf("Cannot check RDB file %s: %v\n", cfg.CheckRDB, err)
+			return 1
+		}
+		fmt.Print(report)
+		if !report.OK() {
+			code = 1
+		}
+	}
+	if cfg.CheckAOF != "" {
+		report, err := aof.Check(cfg.CheckAOF)
+		if err != nil {
+			fmt.Printf("Cannot check AOF file %s: %v\n", cfg.CheckAOF, err)
+			return 1
+		}
+		fmt.Print(report)
+		if !report.OK() {
+			code = 1
 		}
 	}
+	return code
 }
@@ -0,0 +1,135 @@
+// Package aof validates append-only files: a sequence of commands encoded as
+// RESP arrays of bulk strings.
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Report is the result of checking an AOF
+type Report struct {
+	Commands    int   // Commands that parsed completely
+	ValidOffset int64 // Byte offset just past the last complete command
+	Size        int64 // Total file size
+	Err         error // First format error, if any
+}
+
+// OK reports whether the whole file consists of well-formed commands
+func (r *Report) OK() bool {
+	return r.Err == nil
+}
+
+// String renders the report in the style of redis-check-aof
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "AOF analyzed: size=%d, ok_up_to=%d, diff=%d, commands=%d\n",
+		r.Size, r.ValidOffset, r.Size-r.ValidOffset, r.Commands)
+	if r.Err != nil {
+		fmt.Fprintf(&b, "0x%x: %v\n", r.ValidOffset, r.Err)
+		fmt.Fprintf(&b, "AOF is not valid. Truncating at offset %d would keep %d commands.\n", r.ValidOffset, r.Commands)
+	} else {
+		fmt.Fprintf(&b, "AOF is valid\n")
+	}
+	return b.String()
+}
+
+// countingReader tracks how many bytes have been consumed
+type countingReader struct {
+	r      *bufio.Reader
+	offset int64
+}
+
+func (c *countingReader) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	c.offset += int64(len(line))
+	if err != nil {
+		if err == io.EOF && line != "" {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		return "", errors.New("expected CRLF line terminator")
+	}
+	return line[:len(line)-2], nil
+}
+
+func (c *countingReader) readPrefixed(prefix byte) (int, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return 0, err
+	}
+	if len(line) == 0 || line[0] != prefix {
+		return 0, fmt.Errorf("expected '%c', got '%s'", prefix, line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid length '%s'", line[1:])
+	}
+	return n, nil
+}
+
+// readCommand reads one RESP array of bulk strings
+func (c *countingReader) readCommand() error {
+	argc, err := c.readPrefixed('*')
+	if err != nil {
+		return err
+	}
+	if argc == 0 {
+		return errors.New("empty command")
+	}
+	for i := 0; i < argc; i++ {
+		size, err := c.readPrefixed('$')
+		if err != nil {
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		buf := make([]byte, size+2)
+		n, err := io.ReadFull(c.r, buf)
+		c.offset += int64(n)
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		if string(buf[size:]) != "\r\n" {
+			return errors.New("bulk string is not terminated by CRLF")
+		}
+	}
+	return nil
+}
+
+// Check parses an AOF and reports how much of it is well formed
+func Check(filename string) (*Report, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Size: info.Size()}
+	reader := &countingReader{r: bufio.NewReader(file)}
+	for {
+		err := reader.readCommand()
+		if err == io.EOF {
+			return report, nil
+		}
+		if err != nil {
+			report.Err = err
+			return report, nil
+		}
+		report.Commands++
+		report.ValidOffset = reader.offset
+	}
+}
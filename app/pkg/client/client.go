@@ -0,0 +1,161 @@
+// Package client is a minimal Go client for this server, for example
+// programs and integration tools that would otherwise need a third-party
+// Redis library.
+//
+//	c, err := client.Dial("localhost:6379")
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//	if err := c.Set("greeting", "hello"); err != nil {
+//		return err
+//	}
+//	value, err := c.Get("greeting")
+//
+// A Client is safe for concurrent use; commands from different goroutines
+// are sent one at a time over its single connection.
+package client
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
+)
+
+// ErrNil is returned by the typed helpers when the server replies with nil,
+// such as GET on a missing key
+var ErrNil = errors.New("client: nil reply")
+
+// Client is a connection to the server
+type Client struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration // Per-command deadline, 0 for none
+	mutex   sync.Mutex    // Keeps each command and its reply together
+}
+
+// Dial connects to the server at addr ("host:port")
+func Dial(addr string) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient returns a Client speaking over an established connection
+func NewClient(conn net.Conn) *Client {
+	return &Client{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// SetTimeout bounds how long each command, or pipeline, may take to be sent
+// and answered; zero, the default, waits forever
+func (c *Client) SetTimeout(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.timeout = d
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Do sends a command and returns its reply. An error reply is returned as
+// both the Reply and an Error, so callers that only care about success can
+// check err alone.
+func (c *Client) Do(args ...string) (Reply, error) {
+	replies, err := c.roundTrip([]byte(protocol.EncodeArray(args)), 1)
+	if err != nil {
+		return Reply{}, err
+	}
+	return replies[0], replies[0].Err()
+}
+
+// roundTrip writes the encoded commands in one write and reads n replies
+func (c *Client) roundTrip(commands []byte, n int) ([]Reply, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+		defer c.conn.SetDeadline(time.Time{})
+	}
+	if _, err := c.conn.Write(commands); err != nil {
+		return nil, err
+	}
+	replies := make([]Reply, n)
+	for i := range replies {
+		var err error
+		if replies[i], err = ReadReply(c.reader); err != nil {
+			return nil, err
+		}
+	}
+	return replies, nil
+}
+
+// Get returns the string at key, ErrNil when it is missing
+func (c *Client) Get(key string) (string, error) {
+	reply, err := c.Do("GET", key)
+	if err != nil {
+		return "", err
+	}
+	if reply.Null {
+		return "", ErrNil
+	}
+	return reply.Str, nil
+}
+
+// Set stores value at key. Options are passed through as extra arguments,
+// e.g. c.Set("k", "v", "PX", "1000"); a nil reply returns ErrNil.
+func (c *Client) Set(key, value string, options ...string) error {
+	reply, err := c.Do(append([]string{"SET", key, value}, options...)...)
+	if err != nil {
+		return err
+	}
+	if reply.Null {
+		return ErrNil
+	}
+	return nil
+}
+
+// Pipeline queues commands to be sent together
+type Pipeline struct {
+	client   *Client
+	commands []byte
+	n        int
+}
+
+// Pipeline starts a batch of commands that Exec sends in a single write,
+// reading all the replies afterwards
+func (c *Client) Pipeline() *Pipeline {
+	return &Pipeline{client: c}
+}
+
+// Do queues a command
+func (p *Pipeline) Do(args ...string) {
+	p.commands = append(p.commands, protocol.EncodeArray(args)...)
+	p.n++
+}
+
+// Len returns how many commands are queued
+func (p *Pipeline) Len() int {
+	return p.n
+}
+
+// Exec sends the queued commands and returns their replies in order. Error
+// replies are left in the replies for the caller to check with Err; the
+// error is for failures to talk to the server. The pipeline is empty
+// afterwards and can be reused.
+func (p *Pipeline) Exec() ([]Reply, error) {
+	if p.n == 0 {
+		return nil, nil
+	}
+	replies, err := p.client.roundTrip(p.commands, p.n)
+	p.commands, p.n = p.commands[:0], 0
+	return replies, err
+}
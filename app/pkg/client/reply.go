@@ -0,0 +1,116 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Reply is a decoded RESP reply
+type Reply struct {
+	Type  byte    // One of '+', '-', ':', '$' or '*'
+	Str   string  // Simple string, error or bulk string payload
+	Int   int64   // Integer payload
+	Array []Reply // Array elements
+	Null  bool    // Null bulk string or null array
+}
+
+// Error is an error reply from the server, such as "ERR syntax error" or
+// "WRONGTYPE Operation against a key holding the wrong kind of value"
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// IsError reports whether the reply is a RESP error
+func (r Reply) IsError() bool {
+	return r.Type == '-'
+}
+
+// Err returns the reply as an Error when it is one, nil otherwise
+func (r Reply) Err() error {
+	if r.IsError() {
+		return Error(r.Str)
+	}
+	return nil
+}
+
+// Strings returns the payloads of an array of strings; nil elements become ""
+func (r Reply) Strings() []string {
+	out := make([]string, len(r.Array))
+	for i, element := range r.Array {
+		out[i] = element.Str
+	}
+	return out
+}
+
+// String renders the reply roughly the way redis-cli does
+func (r Reply) String() string {
+	switch {
+	case r.Null:
+		return "(nil)"
+	case r.Type == '-':
+		return "(error) " + r.Str
+	case r.Type == ':':
+		return "(integer) " + strconv.FormatInt(r.Int, 10)
+	case r.Type == '*':
+		parts := make([]string, len(r.Array))
+		for i, element := range r.Array {
+			parts[i] = element.String()
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	default:
+		return strconv.Quote(r.Str)
+	}
+}
+
+// ReadReply decodes one RESP reply from reader. An error reply is returned
+// as a Reply, not as an error; the error is for I/O and protocol failures.
+func ReadReply(reader *bufio.Reader) (Reply, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return Reply{}, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return Reply{}, fmt.Errorf("empty RESP line")
+	}
+
+	reply := Reply{Type: line[0]}
+	payload := line[1:]
+
+	switch reply.Type {
+	case '+', '-':
+		reply.Str = payload
+	case ':':
+		reply.Int, err = strconv.ParseInt(payload, 10, 64)
+	case '$':
+		var n int
+		if n, err = strconv.Atoi(payload); err != nil || n < 0 {
+			reply.Null = n < 0
+			break
+		}
+		data := make([]byte, n+2)
+		if _, err = io.ReadFull(reader, data); err == nil {
+			reply.Str = string(data[:n])
+		}
+	case '*':
+		var n int
+		if n, err = strconv.Atoi(payload); err != nil || n < 0 {
+			reply.Null = n < 0
+			break
+		}
+		reply.Array = make([]Reply, n)
+		for i := range reply.Array {
+			if reply.Array[i], err = ReadReply(reader); err != nil {
+				break
+			}
+		}
+	default:
+		err = fmt.Errorf("unknown RESP type %q", reply.Type)
+	}
+	return reply, err
+}
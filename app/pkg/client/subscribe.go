@@ -0,0 +1,169 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/protocol"
+)
+
+// Message is a push received by a subscription
+type Message struct {
+	Kind    string // "message", "pmessage", "smessage", or the command for confirmations ("subscribe", "punsubscribe", ...)
+	Pattern string // Pattern that matched, for "pmessage"
+	Channel string // Channel, or the pattern for pattern confirmations
+	Payload string // Message payload; for confirmations the subscription count
+}
+
+// Subscription is a connection in subscribe mode, receiving the messages
+// published to its channels
+type Subscription struct {
+	client  *Client
+	pending []Message // Messages read while waiting for confirmations
+}
+
+// Subscribe puts the client in subscribe mode on channels and returns once
+// the server confirmed each of them. The client is dedicated to the
+// subscription afterwards: use the Subscription to receive, and a separate
+// Client for other commands.
+func (c *Client) Subscribe(channels ...string) (*Subscription, error) {
+	return c.subscribe("SUBSCRIBE", channels)
+}
+
+// PSubscribe is Subscribe for the channels matching glob patterns. Their
+// messages arrive with Kind "pmessage" and the Pattern set.
+func (c *Client) PSubscribe(patterns ...string) (*Subscription, error) {
+	return c.subscribe("PSUBSCRIBE", patterns)
+}
+
+// SSubscribe is Subscribe for shard channels, which only SPUBLISH reaches.
+// Their messages arrive with Kind "smessage".
+func (c *Client) SSubscribe(channels ...string) (*Subscription, error) {
+	return c.subscribe("SSUBSCRIBE", channels)
+}
+
+// subscribe sends command, SUBSCRIBE, PSUBSCRIBE or SSUBSCRIBE, and waits
+// for the confirmation of each name
+func (c *Client) subscribe(command string, names []string) (*Subscription, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("client: %s needs at least one name", command)
+	}
+	s := &Subscription{client: c}
+	if err := s.write(command, names); err != nil {
+		return nil, err
+	}
+	kind := strings.ToLower(command)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.timeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+		defer c.conn.SetReadDeadline(time.Time{})
+	}
+	for confirmed := 0; confirmed < len(names); {
+		msg, err := s.read()
+		if err != nil {
+			return nil, err
+		}
+		if msg.Kind == kind {
+			confirmed++
+			continue
+		}
+		// A message on a channel confirmed earlier
+		s.pending = append(s.pending, msg)
+	}
+	return s, nil
+}
+
+// Subscribe adds channels to the subscription. Their confirmations arrive
+// through Receive.
+func (s *Subscription) Subscribe(channels ...string) error {
+	return s.write("SUBSCRIBE", channels)
+}
+
+// Unsubscribe leaves channels, or every channel when none are given. Their
+// confirmations arrive through Receive.
+func (s *Subscription) Unsubscribe(channels ...string) error {
+	return s.write("UNSUBSCRIBE", channels)
+}
+
+// PSubscribe adds patterns to the subscription, like Subscribe
+func (s *Subscription) PSubscribe(patterns ...string) error {
+	return s.write("PSUBSCRIBE", patterns)
+}
+
+// PUnsubscribe leaves patterns, or every pattern when none are given, like
+// Unsubscribe
+func (s *Subscription) PUnsubscribe(patterns ...string) error {
+	return s.write("PUNSUBSCRIBE", patterns)
+}
+
+// SSubscribe adds shard channels to the subscription, like Subscribe
+func (s *Subscription) SSubscribe(channels ...string) error {
+	return s.write("SSUBSCRIBE", channels)
+}
+
+// SUnsubscribe leaves shard channels, or every shard channel when none are
+// given, like Unsubscribe
+func (s *Subscription) SUnsubscribe(channels ...string) error {
+	return s.write("SUNSUBSCRIBE", channels)
+}
+
+// write sends command with channels. It doesn't take the client's lock,
+// which a blocked Receive holds.
+func (s *Subscription) write(command string, channels []string) error {
+	_, err := s.client.conn.Write([]byte(protocol.EncodeArray(append([]string{command}, channels...))))
+	return err
+}
+
+// Receive waits for the next message, for at most the client's timeout
+// when one is set. Confirmations of Subscribe and Unsubscribe calls on the
+// Subscription are returned too, with their Kind set.
+func (s *Subscription) Receive() (Message, error) {
+	s.client.mutex.Lock()
+	defer s.client.mutex.Unlock()
+	if len(s.pending) > 0 {
+		msg := s.pending[0]
+		s.pending = s.pending[1:]
+		return msg, nil
+	}
+	if s.client.timeout > 0 {
+		s.client.conn.SetReadDeadline(time.Now().Add(s.client.timeout))
+		defer s.client.conn.SetReadDeadline(time.Time{})
+	}
+	return s.read()
+}
+
+// read decodes one push. The caller holds the client's lock.
+func (s *Subscription) read() (Message, error) {
+	reply, err := ReadReply(s.client.reader)
+	if err != nil {
+		return Message{}, err
+	}
+	if err := reply.Err(); err != nil {
+		return Message{}, err
+	}
+	fields := reply.Array
+	if reply.Type != '*' || len(fields) < 3 {
+		return Message{}, fmt.Errorf("client: unexpected push %s", reply)
+	}
+	msg := Message{Kind: fields[0].Str}
+	if msg.Kind == "pmessage" {
+		if len(fields) != 4 {
+			return Message{}, fmt.Errorf("client: unexpected push %s", reply)
+		}
+		msg.Pattern, fields = fields[1].Str, fields[1:]
+	}
+	msg.Channel, msg.Payload = fields[1].Str, fields[2].Str
+	if fields[2].Type == ':' {
+		msg.Payload = strconv.FormatInt(fields[2].Int, 10)
+	}
+	return msg, nil
+}
+
+// Close ends the subscription by closing the connection
+func (s *Subscription) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,45 @@
+package cluster
+
+import "strings"
+
+// SlotCount is the number of hash slots in a Redis Cluster keyspace
+const SlotCount = 16384
+
+// HashTag returns the part of the key that is hashed to pick its slot. When
+// the key contains a non-empty "{...}" section, only the content of the first
+// such section is hashed, so "{user}.profile" and "{user}.settings" land in
+// the same slot. Otherwise the whole key is returned.
+func HashTag(key string) string {
+	start := strings.IndexByte(key, '{')
+	if start == -1 {
+		return key
+	}
+
+	end := strings.IndexByte(key[start+1:], '}')
+	if end <= 0 {
+		// No closing brace, or "{}" with nothing inside: hash the whole key.
+		return key
+	}
+	return key[start+1 : start+1+end]
+}
+
+// KeySlot returns the hash slot of a key, honouring hash tags
+func KeySlot(key string) int {
+	return int(crc16([]byte(HashTag(key))) % SlotCount)
+}
+
+// crc16 implements the CRC16-CCITT (XMODEM) variant used by Redis Cluster
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
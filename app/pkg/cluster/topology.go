@@ -0,0 +1,279 @@
+package cluster
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Topology is a static cluster layout: the known nodes and which of them
+// serves each slot. There is no gossip; it is read from a file at startup,
+// changed with CLUSTER SETSLOT and written back to that file, so a set of
+// servers can be wired into a cluster by giving each the same layout.
+//
+// The file has one node per line, blank lines and "#" comments aside:
+//
+//	<node-id> <host:port> <myself|-> [slot or first-last ...]
+//
+// Exactly one node is marked myself: the server reading the file.
+type Topology struct {
+	path   string
+	nodes  []*Node // In file order
+	owners [SlotCount]*Node
+	myself *Node
+	mutex  sync.RWMutex
+}
+
+// Node is one server of the cluster
+type Node struct {
+	ID   string
+	Addr string // host:port clients are redirected to
+}
+
+// SlotRange is a run of consecutive slots, both ends included
+type SlotRange struct {
+	First, Last int
+}
+
+// NodeInfo is a node together with the slots it serves
+type NodeInfo struct {
+	Node
+	Myself bool
+	Slots  []SlotRange
+}
+
+// ErrUnknownNode is returned when assigning a slot to a node not in the file
+var ErrUnknownNode = errors.New("unknown node")
+
+// Load reads the topology at path. When the file does not exist a cluster of
+// just this server, at addr and serving no slots, is created and saved there.
+func Load(path, addr string) (*Topology, error) {
+	t := &Topology{path: path}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		id, err := newNodeID()
+		if err != nil {
+			return nil, err
+		}
+		t.myself = &Node{ID: id, Addr: addr}
+		t.nodes = []*Node{t.myself}
+		return t, t.save()
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if err := t.parseLine(scanner.Text()); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if t.myself == nil {
+		return nil, fmt.Errorf("%s: no node is marked myself", path)
+	}
+	return t, nil
+}
+
+func (t *Topology) parseLine(line string) error {
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	if len(fields) < 3 {
+		return errors.New("expected <node-id> <host:port> <myself|-> [slots ...]")
+	}
+
+	node := &Node{ID: fields[0], Addr: fields[1]}
+	for _, known := range t.nodes {
+		if known.ID == node.ID {
+			return fmt.Errorf("node %s is listed twice", node.ID)
+		}
+	}
+	switch fields[2] {
+	case "myself":
+		if t.myself != nil {
+			return errors.New("more than one node is marked myself")
+		}
+		t.myself = node
+	case "-":
+	default:
+		return fmt.Errorf("unknown flag %q, expected myself or -", fields[2])
+	}
+	t.nodes = append(t.nodes, node)
+
+	for _, field := range fields[3:] {
+		r, err := parseSlotRange(field)
+		if err != nil {
+			return err
+		}
+		for slot := r.First; slot <= r.Last; slot++ {
+			if owner := t.owners[slot]; owner != nil {
+				return fmt.Errorf("slot %d is served by both %s and %s", slot, owner.ID, node.ID)
+			}
+			t.owners[slot] = node
+		}
+	}
+	return nil
+}
+
+// parseSlotRange parses "slot" or "first-last"
+func parseSlotRange(s string) (SlotRange, error) {
+	first, last, isRange := strings.Cut(s, "-")
+	if !isRange {
+		last = first
+	}
+	a, errA := strconv.Atoi(first)
+	b, errB := strconv.Atoi(last)
+	if errA != nil || errB != nil || a < 0 || b >= SlotCount || a > b {
+		return SlotRange{}, fmt.Errorf("invalid slot range %q", s)
+	}
+	return SlotRange{First: a, Last: b}, nil
+}
+
+// newNodeID returns a random 40 hex character node ID, like Redis'
+func newNodeID() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// save writes the topology back to its file; the caller must hold the lock
+// (or be the only user). The file is replaced atomically so a crash never
+// leaves half a layout behind.
+func (t *Topology) save() error {
+	var b strings.Builder
+	b.WriteString("# Static cluster topology: <node-id> <host:port> <myself|-> [slots ...]\n")
+	for _, info := range t.nodeInfos() {
+		flag := "-"
+		if info.Myself {
+			flag = "myself"
+		}
+		fmt.Fprintf(&b, "%s %s %s", info.ID, info.Addr, flag)
+		for _, r := range info.Slots {
+			b.WriteString(" " + r.String())
+		}
+		b.WriteString("\n")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(t.path), filepath.Base(t.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), t.path)
+}
+
+// String renders the range as in the topology file and CLUSTER NODES
+func (r SlotRange) String() string {
+	if r.First == r.Last {
+		return strconv.Itoa(r.First)
+	}
+	return fmt.Sprintf("%d-%d", r.First, r.Last)
+}
+
+// Myself returns this server's node
+func (t *Topology) Myself() Node {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return *t.myself
+}
+
+// Owner returns the node serving slot; ok is false while it is unassigned
+func (t *Topology) Owner(slot int) (Node, bool) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	owner := t.owners[slot]
+	if owner == nil {
+		return Node{}, false
+	}
+	return *owner, true
+}
+
+// SlotCounts returns how many slots this server serves and how many have
+// any node serving them
+func (t *Topology) SlotCounts() (served, assigned int) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	for _, owner := range t.owners {
+		if owner != nil {
+			assigned++
+			if owner == t.myself {
+				served++
+			}
+		}
+	}
+	return served, assigned
+}
+
+// Nodes returns every node with the slots it serves, in file order
+func (t *Topology) Nodes() []NodeInfo {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.nodeInfos()
+}
+
+func (t *Topology) nodeInfos() []NodeInfo {
+	infos := make([]NodeInfo, len(t.nodes))
+	index := make(map[*Node]int, len(t.nodes))
+	for i, node := range t.nodes {
+		infos[i] = NodeInfo{Node: *node, Myself: node == t.myself}
+		index[node] = i
+	}
+	for slot := 0; slot < SlotCount; slot++ {
+		owner := t.owners[slot]
+		if owner == nil {
+			continue
+		}
+		info := &infos[index[owner]]
+		if n := len(info.Slots); n > 0 && info.Slots[n-1].Last == slot-1 {
+			info.Slots[n-1].Last = slot
+		} else {
+			info.Slots = append(info.Slots, SlotRange{First: slot, Last: slot})
+		}
+	}
+	return infos
+}
+
+// SetSlot makes the node with nodeID serve slot and rewrites the topology
+// file. The change is kept in memory even if the file cannot be written.
+func (t *Topology) SetSlot(slot int, nodeID string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, node := range t.nodes {
+		if node.ID == nodeID {
+			t.owners[slot] = node
+			return t.save()
+		}
+	}
+	return ErrUnknownNode
+}
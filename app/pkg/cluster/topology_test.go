@@ -0,0 +1,161 @@
+package cluster
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeLayout writes layout to a topology file in a fresh directory and
+// returns its path
+func writeLayout(t *testing.T, layout string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "nodes.conf")
+	if err := os.WriteFile(path, []byte(layout), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestKeySlot(t *testing.T) {
+	for _, tc := range []struct {
+		key  string
+		slot int
+	}{
+		// Slots Redis gives these keys
+		{"", 0},
+		{"foo", 12182},
+		{"123456789", 12739},
+		{"{user1000}.following", 3443},
+		{"{user1000}.followers", 3443},
+		{"user1000", 3443},
+	} {
+		if got := KeySlot(tc.key); got != tc.slot {
+			t.Errorf("KeySlot(%q) = %d, want %d", tc.key, got, tc.slot)
+		}
+	}
+	// An empty or unclosed tag hashes the whole key
+	if HashTag("foo{}{bar}") != "foo{}{bar}" || HashTag("{foo") != "{foo" {
+		t.Errorf("HashTag = %q, %q, want the whole keys", HashTag("foo{}{bar}"), HashTag("{foo"))
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := writeLayout(t, `# Three masters
+a 127.0.0.1:7000 myself 0-5460
+b 127.0.0.1:7001 - 5461-10922
+
+c 127.0.0.1:7002 - 10923-16382 16383   # Two ranges, merged when listed
+`)
+	topology, err := Load(path, "ignored:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if myself := topology.Myself(); myself.ID != "a" || myself.Addr != "127.0.0.1:7000" {
+		t.Errorf("Myself() = %+v, want node a", myself)
+	}
+	for slot, want := range map[int]string{0: "a", 5460: "a", 5461: "b", 10922: "b", 10923: "c", 16383: "c"} {
+		if owner, ok := topology.Owner(slot); !ok || owner.ID != want {
+			t.Errorf("Owner(%d) = %+v, %t, want node %s", slot, owner, ok, want)
+		}
+	}
+	if served, assigned := topology.SlotCounts(); served != 5461 || assigned != SlotCount {
+		t.Errorf("SlotCounts() = %d, %d, want 5461, %d", served, assigned, SlotCount)
+	}
+
+	var ranges []string
+	for _, node := range topology.Nodes() {
+		for _, r := range node.Slots {
+			ranges = append(ranges, node.ID+":"+r.String())
+		}
+	}
+	if want := []string{"a:0-5460", "b:5461-10922", "c:10923-16383"}; !slices.Equal(ranges, want) {
+		t.Errorf("Nodes() serve %v, want %v", ranges, want)
+	}
+}
+
+func TestLoadRejectsBadLayouts(t *testing.T) {
+	for _, tc := range []struct {
+		layout, err string
+	}{
+		{"a 127.0.0.1:7000 - 0-10\n", "no node is marked myself"},
+		{"a 127.0.0.1:7000 myself\nb 127.0.0.1:7001 myself\n", "more than one node is marked myself"},
+		{"a 127.0.0.1:7000 myself\na 127.0.0.1:7001 -\n", "node a is listed twice"},
+		{"a 127.0.0.1:7000 myself 0-10\nb 127.0.0.1:7001 - 10\n", "slot 10 is served by both a and b"},
+		{"a 127.0.0.1:7000 master\n", `unknown flag "master"`},
+		{"a 127.0.0.1:7000 myself 16384\n", `invalid slot range "16384"`},
+		{"a 127.0.0.1:7000 myself 10-5\n", `invalid slot range "10-5"`},
+		{"a 127.0.0.1:7000\n", "expected <node-id>"},
+	} {
+		_, err := Load(writeLayout(t, tc.layout), "ignored:1")
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("loading %q: error %v, want one containing %q", tc.layout, err, tc.err)
+		}
+	}
+}
+
+// A missing file starts a cluster of just this server, saved for the next
+// start
+func TestLoadCreatesMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nodes.conf")
+	topology, err := Load(path, "127.0.0.1:7000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	myself := topology.Myself()
+	if len(myself.ID) != 40 || myself.Addr != "127.0.0.1:7000" {
+		t.Errorf("Myself() = %+v, want a 40 character ID at 127.0.0.1:7000", myself)
+	}
+	if served, assigned := topology.SlotCounts(); served != 0 || assigned != 0 {
+		t.Errorf("SlotCounts() = %d, %d, want no slots", served, assigned)
+	}
+
+	reloaded, err := Load(path, "other:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Myself() != myself {
+		t.Errorf("reloaded Myself() = %+v, want %+v", reloaded.Myself(), myself)
+	}
+}
+
+func TestSetSlotRewritesFile(t *testing.T) {
+	path := writeLayout(t, "a 127.0.0.1:7000 myself 0-99\nb 127.0.0.1:7001 - 100-199\n")
+	topology, err := Load(path, "ignored:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := topology.SetSlot(100, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := topology.SetSlot(500, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := topology.SetSlot(0, "z"); err != ErrUnknownNode {
+		t.Errorf("SetSlot to an unknown node = %v, want ErrUnknownNode", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "a 127.0.0.1:7000 myself 0-100\nb 127.0.0.1:7001 - 101-199 500\n"
+	if _, got, _ := strings.Cut(string(data), "\n"); got != want {
+		t.Errorf("the rewritten file holds\n%s\nwant, after its comment,\n%s", data, want)
+	}
+
+	reloaded, err := Load(path, "ignored:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for slot, want := range map[int]string{100: "a", 101: "b", 500: "b"} {
+		if owner, _ := reloaded.Owner(slot); owner.ID != want {
+			t.Errorf("after reloading, Owner(%d) = %s, want %s", slot, owner.ID, want)
+		}
+	}
+	if _, ok := reloaded.Owner(300); ok {
+		t.Error("after reloading, slot 300 is served, want unassigned")
+	}
+}
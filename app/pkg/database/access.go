@@ -0,0 +1,91 @@
+package database
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Access times are kept the way Redis keeps them in an object's lru field:
+// a 24-bit clock in seconds that wraps around about every 194 days. The
+// clock is advanced by the server cron rather than read from the system on
+// every access, so recording an access is a single atomic store.
+const (
+	LRUClockResolution = time.Second // How much time one tick of the clock stands for
+	LRUClockMax        = 1<<24 - 1   // The clock wraps to 0 after this
+)
+
+// lruClock is the current LRU clock, advanced by UpdateLRUClock
+var lruClock atomic.Uint32
+
+func init() {
+	UpdateLRUClock()
+}
+
+// UpdateLRUClock sets the LRU clock from the system time. The server cron
+// calls it on every tick; ticks much shorter than LRUClockResolution keep
+// the clock accurate to about one resolution.
+func UpdateLRUClock() {
+	lruClock.Store(lruClockAt(time.Now()))
+}
+
+// lruClockAt returns the value of the LRU clock at t
+func lruClockAt(t time.Time) uint32 {
+	return uint32(t.UnixMilli()/LRUClockResolution.Milliseconds()) & LRUClockMax
+}
+
+// lruIdleTime returns the time between an access stamped last and the
+// clock reading now. A now below last means the clock wrapped in between;
+// an access older than a whole wrap can't be told apart from a recent one,
+// as in Redis.
+func lruIdleTime(now, last uint32) time.Duration {
+	return time.Duration((now-last)&LRUClockMax) * LRUClockResolution
+}
+
+// valueLRU returns where a stored value keeps its last access: in the value
+// itself, like the lru field of a Redis object, so recording an access is
+// a store to memory the command already holds rather than a map write
+func valueLRU(val interface{}) *atomic.Uint32 {
+	switch v := val.(type) {
+	case *KeyValue:
+		return &v.lru
+	case *List:
+		return &v.lru
+	case *Hash:
+		return &v.lru
+	case *Set:
+		return &v.lru
+	case *ZSet:
+		return &v.lru
+	case StreamData:
+		return &v.Stream.lru
+	default:
+		return nil
+	}
+}
+
+// touch marks a stored value as accessed now. Every read and write of a key
+// calls it, new values before they are stored.
+func touch(val interface{}) {
+	if lru := valueLRU(val); lru != nil {
+		lru.Store(lruClock.Load())
+	}
+}
+
+// Touch marks a live key as accessed and reports whether it exists
+func Touch(key string) bool {
+	val, found := lookup(key)
+	if found {
+		touch(val)
+	}
+	return found
+}
+
+// IdleTime returns how long ago a live key was last accessed, to the
+// resolution of the LRU clock
+func IdleTime(key string) (time.Duration, bool) {
+	val, found := lookup(key)
+	if !found {
+		return 0, false
+	}
+	return lruIdleTime(lruClock.Load(), valueLRU(val).Load()), true
+}
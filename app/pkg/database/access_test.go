@@ -0,0 +1,53 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUIdleTime(t *testing.T) {
+	for _, c := range []struct {
+		now, last uint32
+		want      time.Duration
+	}{
+		{100, 100, 0},
+		{100, 40, 60 * time.Second},
+		{LRUClockMax, 0, LRUClockMax * time.Second},
+		// The clock wrapped between the access and now
+		{0, LRUClockMax, time.Second},
+		{10, LRUClockMax - 5, 16 * time.Second},
+		{LRUClockMax - 1, LRUClockMax, LRUClockMax * time.Second},
+	} {
+		if got := lruIdleTime(c.now, c.last); got != c.want {
+			t.Errorf("lruIdleTime(%d, %d) = %v, want %v", c.now, c.last, got, c.want)
+		}
+	}
+}
+
+func TestIdleTimeAcrossClockWrap(t *testing.T) {
+	Flush()
+	t.Cleanup(UpdateLRUClock)
+
+	lruClock.Store(LRUClockMax - 5)
+	SetKey("string", "v", -1)
+	RPushAdd("list", "a")
+	HashSet("hash", []string{"f", "v"})
+	SetAdd("set", []string{"m"})
+	ZSetAdd("zset", []ZMember{{Member: "m", Score: 1}}, ZAddFlags{})
+
+	lruClock.Store(10)
+	for _, key := range []string{"string", "list", "hash", "set", "zset"} {
+		if idle, found := IdleTime(key); !found || idle != 16*time.Second {
+			t.Errorf("IdleTime(%s) = %v, %t; want 16s across the wrap", key, idle, found)
+		}
+	}
+
+	// A read stamps the value itself
+	GetKey("string")
+	if idle, _ := IdleTime("string"); idle != 0 {
+		t.Errorf("IdleTime(string) = %v after GET, want 0", idle)
+	}
+	if idle, _ := IdleTime("list"); idle != 16*time.Second {
+		t.Errorf("IdleTime(list) = %v after a GET of another key, want 16s", idle)
+	}
+}
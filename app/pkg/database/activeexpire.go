@@ -0,0 +1,135 @@
+package database
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Active expiry deletes keys whose TTL elapsed once their deadline in the
+// TTL index passes. The caller does the deletion, with DeleteIfExpired.
+
+// activeExpireKeysPerRound is how many due keys a cycle deletes between
+// looks at the clock
+const activeExpireKeysPerRound = 20
+
+// expireIdle is how long ExpireLoop sleeps while no key has a TTL; any
+// key given one wakes it earlier
+const expireIdle = time.Hour
+
+var (
+	activeExpireMutex sync.Mutex // Held by the running cycle
+
+	expiredKeys          atomic.Int64 // Keys deleted by DeleteIfExpired
+	expireCycles         atomic.Int64
+	expireCyclesTimedOut atomic.Int64 // Cycles stopped by their time limit
+)
+
+// ExpireLoop runs active expiry for ever: a cycle of at most limit each
+// time the soonest deadline passes. expire is called with each key that is
+// due and reports whether it deleted it.
+func ExpireLoop(limit time.Duration, expire func(key string) bool) {
+	timer := time.NewTimer(expireIdle)
+	for {
+		wait := expireIdle
+		if next, ok := nextExpiry(); ok {
+			wait = time.Until(next)
+		}
+		if wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-timer.C:
+			case <-expiries.wake:
+				timer.Stop()
+			}
+			continue
+		}
+		ActiveExpireCycle(limit, expire)
+	}
+}
+
+// ActiveExpireCycle calls expire, for at most limit, with the keys whose
+// deadline passed, and returns how many it deleted
+func ActiveExpireCycle(limit time.Duration, expire func(key string) bool) int {
+	activeExpireMutex.Lock()
+	defer activeExpireMutex.Unlock()
+
+	start := time.Now()
+	now := start.UnixNano()
+	deleted := 0
+	for checked := 1; ; checked++ {
+		key, due := dueKey(now)
+		if !due {
+			break
+		}
+		if expire(key) {
+			deleted++
+		} else {
+			// Written since it was indexed: index what is there now
+			indexExpiry(key)
+		}
+		if checked%activeExpireKeysPerRound == 0 && time.Since(start) >= limit {
+			expireCyclesTimedOut.Add(1)
+			break
+		}
+	}
+
+	expireCycles.Add(1)
+	return deleted
+}
+
+// Expired reports whether key holds a value whose TTL elapsed
+func Expired(key string) bool {
+	if indexedExpiries.Load() == 0 {
+		return false
+	}
+	val, found := DB.Load(key)
+	return found && isExpired(val)
+}
+
+// DeleteIfExpired deletes key when its TTL elapsed and reports whether it
+// did. It costs an atomic load while no key has a TTL.
+func DeleteIfExpired(key string) bool {
+	if indexedExpiries.Load() == 0 {
+		return false
+	}
+	val, found := DB.Load(key)
+	if !found || !isExpired(val) || !deleteExpired(key, val) {
+		return false
+	}
+	unindexExpiry(key)
+	expiredKeys.Add(1)
+	return true
+}
+
+// deleteExpired deletes the expired value old at key, retiring it, and
+// reports false when the key no longer holds it
+func deleteExpired(key string, old interface{}) bool {
+	if m := valueMutex(old); m != nil {
+		m.Lock()
+		defer m.Unlock()
+		if m.retired || !DB.CompareAndDelete(key, old) {
+			return false
+		}
+		m.retired = true
+	} else if !DB.CompareAndDelete(key, old) {
+		return false
+	}
+	return true
+}
+
+// ActiveExpireStats reports what active expiry did so far
+type ActiveExpireStats struct {
+	ExpiredKeys    int64
+	Cycles         int64
+	CyclesTimedOut int64 // Cycles stopped by their time limit
+}
+
+// CollectActiveExpireStats returns the active expiry counters
+func CollectActiveExpireStats() ActiveExpireStats {
+	return ActiveExpireStats{
+		ExpiredKeys:    expiredKeys.Load(),
+		Cycles:         expireCycles.Load(),
+		CyclesTimedOut: expireCyclesTimedOut.Load(),
+	}
+}
@@ -0,0 +1,152 @@
+package database
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"unsafe"
+)
+
+// Bitmaps are plain strings addressed bit by bit, most significant bit of
+// each byte first, like in Redis. SETBIT writes through the same path as
+// SETRANGE, so setting a bit past the end grows the string in place.
+
+// ErrBitOffset is returned for a SETBIT/GETBIT offset that is not a valid
+// bit position in a string of at most MaxStringSize() bytes
+var ErrBitOffset = errors.New("ERR bit offset is not an integer or out of range")
+
+// ErrBitValue is returned for a SETBIT value other than 0 or 1
+var ErrBitValue = errors.New("ERR bit is not an integer or out of range")
+
+// loadString returns the string at key, "" when missing or expired
+func loadString(key string) (string, error) {
+	val, found := lookup(key)
+	if !found {
+		return "", nil
+	}
+	kv, ok := val.(*KeyValue)
+	if !ok {
+		return "", ErrWrongType
+	}
+	touch(kv)
+	return kv.Val, nil
+}
+
+// SetBit sets or clears the bit at offset of the string at key, padding it
+// with zero bytes as needed, and returns the bit's previous value
+func SetBit(key string, offset int64, on bool) (int, error) {
+	if offset < 0 || offset >= int64(MaxStringSize())*8 {
+		return 0, ErrBitOffset
+	}
+	index, mask := int(offset>>3), byte(0x80)>>(offset&7)
+
+	old := 0
+	_, err := updateString(key, func(kv *KeyValue) error {
+		var current byte
+		if index < len(kv.Val) {
+			current = kv.Val[index]
+		}
+		if current&mask != 0 {
+			old = 1
+		}
+
+		updated := current &^ mask
+		if on {
+			updated |= mask
+		}
+		if index >= len(kv.Val) {
+			kv.extend(index-len(kv.Val), string([]byte{updated}))
+		} else if updated != current {
+			kv.overwrite(index, string([]byte{updated}))
+		}
+		return nil
+	})
+	return old, err
+}
+
+// GetBit returns the bit at offset of the string at key; bits past the end
+// of the string, or of a missing key, are 0
+func GetBit(key string, offset int64) (int, error) {
+	if offset < 0 || offset >= int64(MaxStringSize())*8 {
+		return 0, ErrBitOffset
+	}
+	s, err := loadString(key)
+	if err != nil {
+		return 0, err
+	}
+	index := offset >> 3
+	if index >= int64(len(s)) {
+		return 0, nil
+	}
+	return int(s[index]>>(7-offset&7)) & 1, nil
+}
+
+// BitCount returns the number of set bits in the string at key between
+// start and end inclusive. They are byte indexes, or bit indexes when bit is
+// set, and negative ones count from the end; whole selects the entire
+// string and ignores them.
+func BitCount(key string, start, end int64, bit, whole bool) (int, error) {
+	s, err := loadString(key)
+	if err != nil || s == "" {
+		return 0, err
+	}
+	if whole {
+		return countBits(s), nil
+	}
+
+	size := int64(len(s))
+	if bit {
+		size *= 8
+	}
+	if start < 0 {
+		start = max(start+size, 0)
+	}
+	if end < 0 {
+		end = max(end+size, 0)
+	}
+	end = min(end, size-1)
+	if start > end {
+		return 0, nil
+	}
+
+	if !bit {
+		return countBits(s[start : end+1]), nil
+	}
+
+	// Mask off the bits before start in its byte and after end in its
+	// byte, and count the bytes in between whole.
+	first, last := start>>3, end>>3
+	headMask := byte(0xff) >> (start & 7)
+	tailMask := byte(0xff) << (7 - end&7)
+	if first == last {
+		return bits.OnesCount8(s[first] & headMask & tailMask), nil
+	}
+	return bits.OnesCount8(s[first]&headMask) +
+		countBits(s[first+1:last]) +
+		bits.OnesCount8(s[last]&tailMask), nil
+}
+
+// countBits returns the number of set bits in s. It counts eight bytes at a
+// time with a single popcount each, which on multi-megabyte bitmaps is
+// several times faster than counting byte by byte.
+func countBits(s string) int {
+	b := unsafe.Slice(unsafe.StringData(s), len(s))
+
+	// Four independent sums let the popcounts overlap in the pipeline
+	var n0, n1, n2, n3 int
+	for len(b) >= 32 {
+		n0 += bits.OnesCount64(binary.LittleEndian.Uint64(b))
+		n1 += bits.OnesCount64(binary.LittleEndian.Uint64(b[8:]))
+		n2 += bits.OnesCount64(binary.LittleEndian.Uint64(b[16:]))
+		n3 += bits.OnesCount64(binary.LittleEndian.Uint64(b[24:]))
+		b = b[32:]
+	}
+	for len(b) >= 8 {
+		n0 += bits.OnesCount64(binary.LittleEndian.Uint64(b))
+		b = b[8:]
+	}
+	for _, c := range b {
+		n0 += bits.OnesCount8(c)
+	}
+	return n0 + n1 + n2 + n3
+}
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"math/bits"
+	"math/rand/v2"
+	"testing"
+)
+
+// countBitsByByte is the per-byte count countBits is checked and measured
+// against
+func countBitsByByte(s string) int {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		n += bits.OnesCount8(s[i])
+	}
+	return n
+}
+
+// randomBitmap returns n random bytes
+func randomBitmap(n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(rand.Uint32())
+	}
+	return string(b)
+}
+
+func TestCountBits(t *testing.T) {
+	// Lengths around the 32 and 8 byte strides
+	for _, n := range []int{0, 1, 7, 8, 9, 31, 32, 33, 40, 71, 1000} {
+		s := randomBitmap(n)
+		if got, want := countBits(s), countBitsByByte(s); got != want {
+			t.Errorf("countBits of %d bytes = %d, want %d", n, got, want)
+		}
+	}
+}
+
+func TestBitCountBitRange(t *testing.T) {
+	Flush()
+	s := randomBitmap(100)
+	SetKey("bitmap", s, -1)
+
+	// Against the bits counted one at a time
+	for _, r := range [][2]int64{{0, 0}, {3, 5}, {7, 8}, {5, 794}, {0, 799}, {-9, -1}, {17, 17}} {
+		start, end := r[0], r[1]
+		if start < 0 {
+			start, end = start+800, end+800
+		}
+		want := 0
+		for i := start; i <= end; i++ {
+			want += int(s[i>>3]>>(7-i&7)) & 1
+		}
+		if got, _ := BitCount("bitmap", r[0], r[1], true, false); got != want {
+			t.Errorf("BITCOUNT bitmap %d %d BIT = %d, want %d", r[0], r[1], got, want)
+		}
+	}
+}
+
+// The benchmarks count the bits of a 4MB bitmap eight bytes at a time,
+// against byte by byte
+const benchmarkBitmapSize = 4 << 20
+
+func BenchmarkBitCount(b *testing.B) {
+	Flush()
+	SetKey("bitmap", randomBitmap(benchmarkBitmapSize), -1)
+	b.SetBytes(benchmarkBitmapSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		BitCount("bitmap", 0, 0, false, true)
+	}
+}
+
+func BenchmarkBitCountByByte(b *testing.B) {
+	s := randomBitmap(benchmarkBitmapSize)
+	b.SetBytes(benchmarkBitmapSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		countBitsByByte(s)
+	}
+}
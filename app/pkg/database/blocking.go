@@ -0,0 +1,108 @@
+package database
+
+import "sync"
+
+// waiter is one blocked client. consume is set for commands that take what
+// they are woken for (BLPOP, BLMOVE, BZPOPMIN, ...) and clear for ones that
+// only read it (XREAD BLOCK).
+type waiter struct {
+	ch       chan struct{}
+	keys     []string
+	consume  bool
+	signaled map[string]bool // Keys a wake-up was handed to this waiter for
+}
+
+// waiters is the hub blocked clients wait in instead of polling. Each key
+// keeps its waiters in arrival order; writers that make a key ready call
+// SignalKeyReady, which wakes every reader but only the longest-waiting
+// consumer, so consumers are served first come, first served.
+var waiters = struct {
+	keys  map[string][]*waiter
+	mutex sync.Mutex
+}{keys: make(map[string][]*waiter)}
+
+// WaitForKeys registers a consumer blocked on keys. The returned channel
+// receives a value when it is this client's turn to try the keys; cancel
+// must be called once the caller stops waiting, whether it got something,
+// gave up or timed out. Register before checking the keys so a write that
+// lands in between is not missed.
+func WaitForKeys(keys []string) (<-chan struct{}, func()) {
+	return register(keys, true)
+}
+
+// WatchKeys registers a reader blocked on keys. Unlike WaitForKeys every
+// watcher is woken by each signal, since reading leaves the data for the
+// others.
+func WatchKeys(keys []string) (<-chan struct{}, func()) {
+	return register(keys, false)
+}
+
+func register(keys []string, consume bool) (<-chan struct{}, func()) {
+	w := &waiter{ch: make(chan struct{}, 1), keys: keys, consume: consume}
+
+	waiters.mutex.Lock()
+	for _, key := range keys {
+		waiters.keys[key] = append(waiters.keys[key], w)
+	}
+	waiters.mutex.Unlock()
+
+	return w.ch, func() { unregister(w) }
+}
+
+// unregister removes w from the hub. A consumer may leave with a wake-up it
+// did not use: it timed out at the same time, took from another of its keys,
+// or took one element while more were pushed. That turn is passed on to the
+// next consumer so nobody is left blocked on a ready key.
+func unregister(w *waiter) {
+	waiters.mutex.Lock()
+	defer waiters.mutex.Unlock()
+
+	for _, key := range w.keys {
+		queue := waiters.keys[key]
+		for i, other := range queue {
+			if other == w {
+				queue = append(queue[:i:i], queue[i+1:]...)
+				break
+			}
+		}
+		if len(queue) == 0 {
+			delete(waiters.keys, key)
+		} else {
+			waiters.keys[key] = queue
+		}
+	}
+
+	for key := range w.signaled {
+		signalLocked(key)
+	}
+}
+
+// SignalKeyReady wakes the clients blocked on key: every watcher and the
+// consumer that has waited longest. Woken clients that find nothing, because
+// a non-blocking command got there first, simply go back to waiting.
+func SignalKeyReady(key string) {
+	waiters.mutex.Lock()
+	defer waiters.mutex.Unlock()
+	signalLocked(key)
+}
+
+func signalLocked(key string) {
+	woke := false
+	for _, w := range waiters.keys[key] {
+		if w.consume {
+			if woke {
+				continue
+			}
+			woke = true
+			if w.signaled == nil {
+				w.signaled = make(map[string]bool)
+			}
+			w.signaled[key] = true
+		}
+		select {
+		case w.ch <- struct{}{}:
+		default:
+			// Already has a wake-up pending; it will see this write too.
+		}
+	}
+}
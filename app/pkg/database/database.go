@@ -1,37 +1,54 @@
+// Package database is the keyspace: DB holds every key and its value, and
+// the functions of this package are the operations commands run on them.
+// Values are *KeyValue strings, *List, *Hash, *Set, *ZSet and StreamData.
+// Everything but strings is locked per value, and a write to a value pinned
+// by a Snapshot copies it first (see snapshot.go).
 package database
 
 import (
-	"fmt"
+	"errors"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var DB sync.Map
 
+// ErrWrongType is returned when a command targets a key holding another type
+var ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
 func Start() {
 	sync.OnceFunc(func() {
 		DB = sync.Map{}
 	})
 }
 
+// KeyValue is a string value. Only lru changes once it is stored; a write
+// stores a new KeyValue instead.
 type KeyValue struct {
 	Val string
 	Px  int
 	T   time.Time
+	buf *stringBuf    // Growable backing store of Val for large strings, see strings.go
+	lru atomic.Uint32 // Last access, see access.go
 }
 
 type StreamEntry struct {
 	ID     string
-	Fields map[string]string
+	Fields []string // Field/value pairs in the order XADD gave them
 	Time   time.Time
+	key    streamID // ID parsed once, for binary searches over Entries
 }
 
 type Stream struct {
-	Entries    []StreamEntry
+	Entries    []StreamEntry // In ID order, so ranges are found by binary search
 	LastID     string
 	LastSeqNum int64
-	mutex      sync.RWMutex
+	Bytes      int                       // Total length of all field names and values, for StreamLimits
+	Groups     map[string]*ConsumerGroup // Consumer groups by name, nil until one is created
+	mutex      keyMutex
+	lru        atomic.Uint32 // Last access of the key, see access.go
 }
 
 type StreamData struct {
@@ -41,101 +58,100 @@ type StreamData struct {
 }
 
 func SetKey(key, val string, px int) {
-	data := KeyValue{
-		Val: val,
-		T:   time.Now(),
-		Px:  px,
+	data := &KeyValue{Val: val, Px: px}
+	if px != -1 {
+		// T only counts for a TTL, and reading the clock is a good part
+		// of what a SET costs
+		data.T = time.Now()
 	}
-
+	touch(data)
 	DB.Store(key, data)
-	fmt.Printf("key: %+v\n", key)
+	if px != -1 {
+		indexExpiry(key)
+	}
+}
 
+// SetKeyAt stores val at key, expiring at at
+func SetKeyAt(key, val string, at time.Time) {
+	px, t := ttlFields(at.UnixNano())
+	data := &KeyValue{Val: val, Px: px, T: t}
+	touch(data)
+	DB.Store(key, data)
+	indexExpiry(key)
 }
 
 func GetKey(key string) (string, bool) {
-	val, found := DB.Load(key)
+	val, found := lookup(key)
 	if !found {
 		return "", false
 	}
-	data, ok := val.(KeyValue)
+	data, ok := val.(*KeyValue)
 	if !ok {
 		return "", false
 	}
-	if data.Px != -1 &&
-		time.Now().After(data.T.Add(time.Millisecond*time.Duration(data.Px))) {
-		return "", false
-	}
+	touch(data)
 	return data.Val, true
 
 }
 
-func GetType(key string) (string, bool) {
-	val, found := DB.Load(key)
-	fmt.Printf("key: %s, val: %+v, found: %t\n", key, val, found)
-	if !found {
-		return "", false
-	}
-	switch v := val.(type) {
-	case KeyValue:
-		if v.Px != -1 && time.Now().After(v.T.Add(time.Millisecond*time.Duration(v.Px))) {
-			return "", false
-		}
-		if _, err := strconv.Atoi(v.Val); err == nil {
-			return "integer", true
-		}
-
-		if _, err := strconv.ParseFloat(v.Val, 64); err == nil {
-			return "float", true
-		}
+func DeleteKey(key string) {
+	DB.Delete(key)
+	unindexExpiry(key)
+}
 
-		return "string", true
-	case StreamData:
-		if v.Px != -1 && time.Now().After(v.T.Add(time.Millisecond*time.Duration(v.Px))) {
-			return "", false
+// DeleteKeys removes keys and returns how many of them existed. Logically
+// expired keys are removed as well but not counted.
+func DeleteKeys(keys ...string) int {
+	deleted := 0
+	for _, key := range keys {
+		val, found := DB.LoadAndDelete(key)
+		unindexExpiry(key)
+		if found && !isExpired(val) {
+			deleted++
 		}
-		return "stream", true
-	default:
-		return "", false
 	}
-
+	return deleted
 }
 
-func DeleteKey(key string) {
-	DB.Delete(key)
+// Flush drops every key. It only detaches the data; the memory is reclaimed
+// by the garbage collector.
+func Flush() {
+	DB.Clear()
+	clearExpiries()
 }
 
+// Increment adds by to the integer at key, creating it when missing and
+// keeping its TTL otherwise. The new value only goes in if the key still
+// holds what was read, so concurrent increments are never lost.
 func Increment(key string, by int) (string, bool) {
-	val, found := DB.Load(key)
-	if !found {
-		data := KeyValue{
-			Val: strconv.Itoa(by),
-			Px:  -1,
-			T:   time.Now(),
+	for {
+		val, found := DB.Load(key)
+		data := &KeyValue{Px: -1, T: time.Now()}
+		current := 0
+		if found {
+			old, ok := val.(*KeyValue)
+			if !ok {
+				return "", false
+			}
+			n, err := strconv.Atoi(old.Val)
+			if err != nil {
+				return "", false
+			}
+			current, data.Px, data.T = n, old.Px, old.T
 		}
-		DB.Store(key, data)
-		return data.Val, true
-	}
-	data, ok := val.(KeyValue)
-	if !ok {
-		return "", false
-	}
-	if data.Px != -1 && time.Now().After(data.T.Add(time.Duration(data.Px)*time.Millisecond)) {
-		data := KeyValue{
-			Val: strconv.Itoa(by),
-			Px:  -1,
-			T:   time.Now(),
+		data.Val = strconv.Itoa(current + by)
+		touch(data)
+
+		if found {
+			if !DB.CompareAndSwap(key, val, data) {
+				continue
+			}
+		} else if _, loaded := DB.LoadOrStore(key, data); loaded {
+			continue
+		}
+		if data.Px != -1 {
+			indexExpiry(key)
 		}
-		DB.Store(key, data)
 		return data.Val, true
 	}
-	currentInt, err := strconv.Atoi(data.Val)
-	if err != nil {
-		return "", false
-	}
-	newVal := currentInt + by
-	data.Val = strconv.Itoa(newVal)
-	data.T = time.Now()
-	DB.Store(key, data)
-	return data.Val, true
-
 }
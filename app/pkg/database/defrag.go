@@ -0,0 +1,212 @@
+package database
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Compaction rebuilds container values that shrank a lot since they were
+// largest. A list ring only halves once it is a quarter full, and Go maps
+// never give back the buckets of deleted entries, so a hash or set that
+// held a million fields keeps their memory after most are deleted. A pass
+// walks the keyspace and rebuilds such values at their current size; what
+// they held beyond it is reclaimed by the garbage collector.
+//
+// A pass never waits for a value: one that is locked, or pinned by a
+// snapshot (compacting it would mean copying it), is left for the next
+// pass. The reclaimed bytes are estimates, like the COW sizes.
+
+const (
+	// defragCycle is how often active defrag starts a pass
+	defragCycle = time.Second
+	// defragMinSlots is the least dead capacity, in elements, worth a rebuild
+	defragMinSlots = 64
+)
+
+var (
+	activeDefrag   atomic.Bool
+	defragMutex    sync.Mutex // Held by the running pass
+	defragRunning  atomic.Bool
+	defragLastRun  atomic.Int64 // Unix nanoseconds the last active pass started
+	defragPasses   atomic.Int64
+	defragHits     atomic.Int64 // Values rebuilt
+	defragMisses   atomic.Int64 // Values skipped because they were locked or pinned
+	defragReclaims atomic.Int64 // Estimated bytes reclaimed
+)
+
+// SetActiveDefrag turns the background compaction on or off
+func SetActiveDefrag(enabled bool) {
+	activeDefrag.Store(enabled)
+}
+
+// ActiveDefrag reports whether background compaction is on
+func ActiveDefrag() bool {
+	return activeDefrag.Load()
+}
+
+// DefragCron starts a compaction pass in the background every defragCycle
+// while active defrag is on and no pass is running. It is meant to be run
+// as a server cron job.
+func DefragCron() {
+	if !activeDefrag.Load() || defragRunning.Load() {
+		return
+	}
+	now := time.Now().UnixNano()
+	if now-defragLastRun.Load() < int64(defragCycle) {
+		return
+	}
+	defragLastRun.Store(now)
+	go Defrag()
+}
+
+// DefragResult is what one compaction pass did
+type DefragResult struct {
+	Scanned   int   // Keys looked at
+	Compacted int   // Values rebuilt
+	Reclaimed int64 // Estimated bytes reclaimed
+}
+
+// Defrag runs a compaction pass over the whole keyspace, after the pass in
+// progress if there is one
+func Defrag() DefragResult {
+	defragMutex.Lock()
+	defer defragMutex.Unlock()
+	defragRunning.Store(true)
+	defer defragRunning.Store(false)
+
+	var result DefragResult
+	DB.Range(func(_, val interface{}) bool {
+		result.Scanned++
+		reclaimed, busy := compactValue(val)
+		switch {
+		case busy:
+			defragMisses.Add(1)
+		case reclaimed > 0:
+			result.Compacted++
+			result.Reclaimed += reclaimed
+		}
+		return true
+	})
+
+	defragPasses.Add(1)
+	defragHits.Add(int64(result.Compacted))
+	defragReclaims.Add(result.Reclaimed)
+	return result
+}
+
+// compactValue rebuilds val if it carries enough dead capacity and returns
+// the bytes reclaimed, or busy when it could not be locked at once
+func compactValue(val interface{}) (reclaimed int64, busy bool) {
+	var m *keyMutex
+	var compact func() int64
+	switch v := val.(type) {
+	case *List:
+		m, compact = &v.mutex, v.compact
+	case *Hash:
+		m, compact = &v.mutex, v.compact
+	case *Set:
+		m, compact = &v.mutex, v.compact
+	default:
+		return 0, false
+	}
+
+	if !m.TryLock() {
+		return 0, true
+	}
+	defer m.Unlock()
+	if m.retired || m.pins.Load() > 0 {
+		return 0, true
+	}
+	return compact(), false
+}
+
+// The compactions below run with the value's write lock held.
+
+// compact moves the list into the smallest ring that holds it
+func (l *List) compact() int64 {
+	size := minListCapacity
+	for size < l.n {
+		size *= 2
+	}
+	if len(l.ring) < 2*size || len(l.ring)-size < defragMinSlots {
+		return 0
+	}
+	dead := len(l.ring) - size
+	l.resize(size)
+	return int64(dead) * stringHeaderSize
+}
+
+// notePeak records the number of fields before some are deleted, since the
+// map keeps room for that many
+func (hash *Hash) notePeak() {
+	hash.peak = max(hash.peak, len(hash.Fields))
+}
+
+// compact rebuilds the maps of a hash that has at most half the fields it
+// once had
+func (hash *Hash) compact() int64 {
+	live := len(hash.Fields)
+	dead := hash.peak - live
+	if dead < live || dead < defragMinSlots {
+		return 0
+	}
+	fields := make(map[string]string, live)
+	for field, value := range hash.Fields {
+		fields[field] = value
+	}
+	hash.Fields = fields
+	if len(hash.Expires) == 0 {
+		hash.Expires = nil
+	} else {
+		expires := make(map[string]time.Time, len(hash.Expires))
+		for field, at := range hash.Expires {
+			expires[field] = at
+		}
+		hash.Expires = expires
+	}
+	hash.peak = live
+	return int64(dead) * mapEntrySize
+}
+
+// notePeak records the number of members before some are deleted
+func (set *Set) notePeak() {
+	set.peak = max(set.peak, len(set.Members))
+}
+
+// compact rebuilds the map of a set that has at most half the members it
+// once had
+func (set *Set) compact() int64 {
+	live := len(set.Members)
+	dead := set.peak - live
+	if dead < live || dead < defragMinSlots {
+		return 0
+	}
+	members := make(map[string]struct{}, live)
+	for member := range set.Members {
+		members[member] = struct{}{}
+	}
+	set.Members = members
+	set.peak = live
+	return int64(dead) * stringHeaderSize
+}
+
+// DefragStats reports the compaction passes run so far
+type DefragStats struct {
+	Running   bool
+	Passes    int64
+	Hits      int64 // Values rebuilt
+	Misses    int64 // Values skipped because they were locked or pinned
+	Reclaimed int64 // Estimated bytes reclaimed
+}
+
+// CollectDefragStats returns the compaction counters
+func CollectDefragStats() DefragStats {
+	return DefragStats{
+		Running:   defragRunning.Load(),
+		Passes:    defragPasses.Load(),
+		Hits:      defragHits.Load(),
+		Misses:    defragMisses.Load(),
+		Reclaimed: defragReclaims.Load(),
+	}
+}
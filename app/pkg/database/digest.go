@@ -0,0 +1,202 @@
+package database
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Digests summarize the dataset so a master and its replicas can be checked
+// for divergence (DEBUG DIGEST, DEBUG DIGEST-VALUE and the verify tool).
+// Like in Redis they are SHA-1 based: ordered data (strings, lists, sorted sets,
+// stream entries) is hashed in order, while the elements of hashes and sets,
+// which have no order, are hashed one by one and XORed together. Keys are
+// combined the same way, so the whole-dataset digest does not depend on
+// iteration order.
+//
+// Only whether a key has a TTL is part of its digest, not the deadline: a
+// replica computes deadlines from when it applied the write, so they differ
+// from the master's by the replication delay.
+
+// digest is a SHA-1 sum; the zero value is the digest of nothing
+type digest [sha1.Size]byte
+
+func (d *digest) xor(other digest) {
+	for i := range d {
+		d[i] ^= other[i]
+	}
+}
+
+func (d digest) String() string {
+	return hex.EncodeToString(d[:])
+}
+
+// digestWriter feeds length-prefixed strings to a SHA-1, so ("ab", "c") and
+// ("a", "bc") hash differently
+type digestWriter struct {
+	h hash.Hash
+}
+
+func newDigestWriter() digestWriter {
+	return digestWriter{h: sha1.New()}
+}
+
+func (w digestWriter) add(s string) {
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(s)))
+	w.h.Write(n[:])
+	w.h.Write([]byte(s))
+}
+
+func (w digestWriter) addFloat(f float64) {
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], math.Float64bits(f))
+	w.h.Write(n[:])
+}
+
+func (w digestWriter) addDigest(d digest) {
+	w.h.Write(d[:])
+}
+
+func (w digestWriter) sum() digest {
+	var d digest
+	w.h.Sum(d[:0])
+	return d
+}
+
+// digestOf hashes parts as one ordered sequence
+func digestOf(parts ...string) digest {
+	w := newDigestWriter()
+	for _, part := range parts {
+		w.add(part)
+	}
+	return w.sum()
+}
+
+// valueDigest returns the digest of a stored value, without its key. ok is
+// false for a logically expired value.
+func valueDigest(val interface{}) (digest, bool) {
+	if isExpired(val) {
+		return digest{}, false
+	}
+
+	w := newDigestWriter()
+	w.add(TypeName(val))
+	switch v := val.(type) {
+	case *KeyValue:
+		w.add(v.Val)
+	case *List:
+		v.mutex.RLock()
+		for i := 0; i < v.n; i++ {
+			w.add(v.At(i))
+		}
+		v.mutex.RUnlock()
+	case *Hash:
+		var fields digest
+		now := time.Now()
+		v.mutex.RLock()
+		for field, value := range v.Fields {
+			if !v.fieldExpired(field, now) {
+				fields.xor(digestOf(field, value))
+			}
+		}
+		v.mutex.RUnlock()
+		w.addDigest(fields)
+	case *Set:
+		var members digest
+		v.mutex.RLock()
+		for member := range v.Members {
+			members.xor(digestOf(member))
+		}
+		v.mutex.RUnlock()
+		w.addDigest(members)
+	case *ZSet:
+		v.mutex.RLock()
+		for _, m := range v.rangeByRank(0, -1, false) {
+			w.add(m.Member)
+			w.addFloat(m.Score)
+		}
+		v.mutex.RUnlock()
+	case StreamData:
+		v.Stream.mutex.RLock()
+		w.add(v.Stream.LastID)
+		for _, entry := range v.Stream.Entries {
+			w.add(entry.ID)
+			// Field order is part of an entry, unlike a hash's
+			for _, f := range entry.Fields {
+				w.add(f)
+			}
+		}
+		w.addDigest(groupsDigest(v.Stream.Groups))
+		v.Stream.mutex.RUnlock()
+	}
+	return w.sum(), true
+}
+
+// groupsDigest hashes the consumer groups of a stream: each group's name,
+// last delivered ID and consumers, and who each pending entry was delivered
+// to how many times. Delivery and seen times are left out, like deadlines.
+func groupsDigest(groups map[string]*ConsumerGroup) digest {
+	var all digest
+	for name, g := range groups {
+		var members digest
+		for consumer := range g.Consumers {
+			members.xor(digestOf(consumer))
+		}
+		var pending digest
+		for id, p := range g.Pending {
+			pending.xor(digestOf(id, p.Consumer.Name, strconv.Itoa(p.DeliveryCount)))
+		}
+		w := newDigestWriter()
+		w.add(name)
+		w.add(g.LastDeliveredID)
+		w.addDigest(members)
+		w.addDigest(pending)
+		all.xor(w.sum())
+	}
+	return all
+}
+
+// DigestValue returns the hex digest of the value at key, or 40 zeros when
+// the key does not exist, like DEBUG DIGEST-VALUE
+func DigestValue(key string) string {
+	val, found := DB.Load(key)
+	if !found {
+		return digest{}.String()
+	}
+	d, _ := valueDigest(val)
+	return d.String()
+}
+
+// keyDigest returns the digest of key, its value and whether it has a TTL
+func keyDigest(key string, val interface{}) (digest, bool) {
+	value, ok := valueDigest(val)
+	if !ok {
+		return digest{}, false
+	}
+	w := newDigestWriter()
+	w.add(key)
+	w.addDigest(value)
+	if _, volatile := deadline(val); volatile {
+		w.add("volatile")
+	}
+	return w.sum(), true
+}
+
+// Digest returns the hex digest of the whole dataset, or 40 zeros when it
+// is empty, like DEBUG DIGEST. It reads a Snapshot, so writes carry on
+// while a large dataset is hashed and do not change the values it sees.
+func Digest() string {
+	var all digest
+	Snapshot(func(key string, val interface{}) bool {
+		if d, ok := keyDigest(key, val); ok {
+			all.xor(d)
+		}
+		return true
+	})
+	return all.String()
+}
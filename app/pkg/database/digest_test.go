@@ -0,0 +1,114 @@
+package database
+
+import (
+	"testing"
+)
+
+// digestKeys are the keys populateDigest writes, one of each type
+var digestKeys = []string{"string", "list", "hash", "set", "zset", "stream"}
+
+// populateDigest writes a value of each type, with the one named by differ
+// changed in a single field or element. reverse writes the unordered
+// elements the other way round, which must not change any digest.
+func populateDigest(t *testing.T, differ string, reverse bool) {
+	t.Helper()
+	Flush()
+	pick := func(key, same, other string) string {
+		if key == differ {
+			return other
+		}
+		return same
+	}
+	pairs := func(p ...string) []string {
+		if reverse {
+			for i, j := 0, len(p)-2; i < j; i, j = i+2, j-2 {
+				p[i], p[i+1], p[j], p[j+1] = p[j], p[j+1], p[i], p[i+1]
+			}
+		}
+		return p
+	}
+
+	SetKey("string", pick("string", "value", "valuf"), -1)
+	if _, err := RPushAdd("list", "a", pick("list", "b", "B"), "c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := HashSet("hash", pairs("f1", "1", "f2", pick("hash", "2", "3"), "f3", "3")); err != nil {
+		t.Fatal(err)
+	}
+	members := []string{"a", "b", pick("set", "c", "d")}
+	if reverse {
+		members = []string{members[2], members[1], members[0]}
+	}
+	if _, err := SetAdd("set", members); err != nil {
+		t.Fatal(err)
+	}
+	scores := []ZMember{{Member: "a", Score: 1}, {Member: "b", Score: 2}}
+	if differ == "zset" {
+		scores[1].Score = 2.5
+	}
+	if reverse {
+		scores[0], scores[1] = scores[1], scores[0]
+	}
+	if _, _, err := ZSetAdd("zset", scores, ZAddFlags{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"1-1", "2-1"} {
+		if _, err := StreamAdd("stream", id, []string{"f", pick("stream", id, id+"!")}, StreamLimits{}, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := GroupCreate("stream", "readers", "0", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := GroupReadNew("stream", "readers", pick("stream group", "alice", "bob"), 1, false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestDigest checks that DEBUG DIGEST and DIGEST-VALUE agree for the same
+// dataset however it was written, and change with a single field
+func TestDigest(t *testing.T) {
+	defer Flush()
+	populateDigest(t, "", false)
+	want := Digest()
+	values := make(map[string]string, len(digestKeys))
+	for _, key := range digestKeys {
+		values[key] = DigestValue(key)
+	}
+	if want == (digest{}).String() {
+		t.Fatal("the digest of a populated dataset is all zeros")
+	}
+
+	populateDigest(t, "", true)
+	if got := Digest(); got != want {
+		t.Errorf("the same dataset written in another order has digest %s, want %s", got, want)
+	}
+	for _, key := range digestKeys {
+		if got := DigestValue(key); got != values[key] {
+			t.Errorf("%s written in another order has digest %s, want %s", key, got, values[key])
+		}
+	}
+
+	for _, differ := range append(digestKeys, "stream group") {
+		t.Run(differ, func(t *testing.T) {
+			populateDigest(t, differ, false)
+			if got := Digest(); got == want {
+				t.Errorf("a dataset whose %s differs has the same digest", differ)
+			}
+			for _, key := range digestKeys {
+				changed := DigestValue(key) != values[key]
+				if wantChanged := key == differ || key+" group" == differ; changed != wantChanged {
+					t.Errorf("with %s differing, the digest of %s changed: %v, want %v", differ, key, changed, wantChanged)
+				}
+			}
+		})
+	}
+
+	Flush()
+	if got := Digest(); got != (digest{}).String() {
+		t.Errorf("the digest of an empty dataset is %s, want zeros", got)
+	}
+	if got := DigestValue("missing"); got != (digest{}).String() {
+		t.Errorf("the digest of a missing key is %s, want zeros", got)
+	}
+}
@@ -0,0 +1,99 @@
+package database
+
+import "time"
+
+// TTLBucket is one bucket of the TTL histogram. Keys whose remaining TTL is
+// below Bound (and at or above the previous bucket's bound) are counted in
+// it; the final bucket has a zero Bound and collects everything longer.
+type TTLBucket struct {
+	Label string
+	Bound time.Duration
+	Count int
+}
+
+// ExpiryStats summarises the volatile part of the keyspace
+type ExpiryStats struct {
+	Keys     int
+	Volatile int
+	AvgTTL   time.Duration
+	Buckets  []TTLBucket
+}
+
+// ttlBuckets lists the histogram bucket bounds in ascending order
+var ttlBuckets = []TTLBucket{
+	{Label: "1s", Bound: time.Second},
+	{Label: "10s", Bound: 10 * time.Second},
+	{Label: "1m", Bound: time.Minute},
+	{Label: "10m", Bound: 10 * time.Minute},
+	{Label: "1h", Bound: time.Hour},
+	{Label: "1d", Bound: 24 * time.Hour},
+	{Label: "inf"},
+}
+
+// remainingTTL returns the time left before a value expires, or false when
+// the value has no expiry
+func remainingTTL(val interface{}, now time.Time) (time.Duration, bool) {
+	at, volatile := deadline(val)
+	if !volatile {
+		return 0, false
+	}
+	return time.Unix(0, at).Sub(now), true
+}
+
+// CollectExpiryStats counts the live keys and buckets every live volatile
+// key by its remaining TTL. The volatile keys come from the TTL index, so
+// only counting the keys walks the keyspace. Keys that have logically
+// expired but not yet been deleted are left out, as they are for KEYS and
+// SCAN.
+func CollectExpiryStats() ExpiryStats {
+	stats := ExpiryStats{Buckets: make([]TTLBucket, len(ttlBuckets))}
+	copy(stats.Buckets, ttlBuckets)
+
+	DB.Range(func(_, value interface{}) bool {
+		if !isExpired(value) {
+			stats.Keys++
+		}
+		return true
+	})
+
+	now := time.Now()
+	var total time.Duration
+	for _, key := range indexedKeys() {
+		value, found := DB.Load(key)
+		if !found {
+			continue
+		}
+		// The index may be behind a write that removed the TTL
+		ttl, volatile := remainingTTL(value, now)
+		if !volatile || ttl <= 0 {
+			continue
+		}
+		stats.Volatile++
+		total += ttl
+		for i := range stats.Buckets {
+			bucket := &stats.Buckets[i]
+			if bucket.Bound == 0 || ttl < bucket.Bound {
+				bucket.Count++
+				break
+			}
+		}
+	}
+
+	if stats.Volatile > 0 {
+		stats.AvgTTL = total / time.Duration(stats.Volatile)
+	}
+	return stats
+}
+
+// ExpiringWithin forecasts how many volatile keys will expire within d,
+// rounded to the histogram bucket that d falls into
+func (s ExpiryStats) ExpiringWithin(d time.Duration) int {
+	count := 0
+	for _, bucket := range s.Buckets {
+		if bucket.Bound == 0 || bucket.Bound > d {
+			break
+		}
+		count += bucket.Count
+	}
+	return count
+}
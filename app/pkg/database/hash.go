@@ -0,0 +1,529 @@
+package database
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/pkg/sample"
+)
+
+var (
+	ErrHashNotInteger = errors.New("ERR hash value is not an integer")
+	ErrHashNotFloat   = errors.New("ERR hash value is not a float")
+	ErrOverflow       = errors.New("ERR increment or decrement would overflow")
+	ErrNaNOrInfinity  = errors.New("ERR increment would produce NaN or Infinity")
+)
+
+// Hash field expiration results, matching the Redis 7.4 HEXPIRE family replies
+const (
+	FieldMissing      = -2 // the field (or the whole key) does not exist
+	FieldNoTTL        = -1 // the field exists but has no expiration
+	FieldNotSet       = 0  // the NX/XX/GT/LT condition was not met
+	FieldExpireSet    = 1  // the expiration was set or removed
+	FieldExpireDelete = 2  // the expiration was in the past so the field was deleted
+)
+
+// Hash is a field -> value map stored under a single key
+type Hash struct {
+	Fields  map[string]string
+	Expires map[string]time.Time // Field-level TTL index: field -> absolute expiry
+	peak    int                  // Most fields held before a deletion, see compact
+	mutex   keyMutex
+	// expireAt is the key's TTL, see ttl.go
+	expireAt atomic.Int64
+	lru      atomic.Uint32 // Last access of the key, see access.go
+}
+
+// fieldExpired reports whether a field's TTL has elapsed; the caller must
+// hold the hash lock
+func (hash *Hash) fieldExpired(field string, now time.Time) bool {
+	at, ok := hash.Expires[field]
+	return ok && !now.Before(at)
+}
+
+// liveField returns a field value unless it is missing or expired; the
+// caller must hold the hash lock
+func (hash *Hash) liveField(field string, now time.Time) (string, bool) {
+	val, ok := hash.Fields[field]
+	if !ok || hash.fieldExpired(field, now) {
+		return "", false
+	}
+	return val, true
+}
+
+// loadHash returns the hash stored at key. When the key is missing and mode
+// is forCreate, an empty hash is stored and returned; otherwise nil is
+// returned.
+func loadHash(key string, mode access) (*Hash, error) {
+	val, found := DB.Load(key)
+	if found && mode.live(val) {
+		hash, ok := val.(*Hash)
+		if !ok {
+			return nil, ErrWrongType
+		}
+		touch(hash)
+		return hash, nil
+	}
+	if mode != forCreate {
+		return nil, nil
+	}
+
+	hash := &Hash{Fields: make(map[string]string)}
+	touch(hash)
+	if _, loaded := DB.LoadOrStore(key, hash); loaded {
+		// Lost a race with another writer creating the key.
+		return loadHash(key, mode)
+	}
+	return hash, nil
+}
+
+// lockHash loads the hash at key like loadHash and write-locks it, see
+// lockWrite. It returns nil, without holding a lock, when there is no hash
+// to work on.
+func lockHash(key string, create bool) (*Hash, error) {
+	return lockWrite(key, func() (*Hash, error) {
+		return loadHash(key, writeAccess(create))
+	})
+}
+
+// HashSet sets field/value pairs and returns how many fields were newly added
+func HashSet(key string, pairs []string) (int, error) {
+	hash, err := lockHash(key, true)
+	if err != nil {
+		return 0, err
+	}
+	defer hash.mutex.Unlock()
+
+	now := time.Now()
+	added := 0
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if _, exists := hash.liveField(pairs[i], now); !exists {
+			added++
+		}
+		hash.Fields[pairs[i]] = pairs[i+1]
+		delete(hash.Expires, pairs[i])
+	}
+	return added, nil
+}
+
+// HashGet returns the value of a field
+func HashGet(key, field string) (string, bool, error) {
+	hash, err := loadHash(key, forRead)
+	if err != nil || hash == nil {
+		return "", false, err
+	}
+
+	hash.mutex.RLock()
+	defer hash.mutex.RUnlock()
+
+	val, ok := hash.liveField(field, time.Now())
+	return val, ok, nil
+}
+
+// HashMultiGet returns the values of several fields; found[i] reports whether
+// fields[i] exists
+func HashMultiGet(key string, fields []string) ([]string, []bool, error) {
+	values := make([]string, len(fields))
+	found := make([]bool, len(fields))
+
+	hash, err := loadHash(key, forRead)
+	if err != nil || hash == nil {
+		return values, found, err
+	}
+
+	hash.mutex.RLock()
+	defer hash.mutex.RUnlock()
+
+	now := time.Now()
+	for i, field := range fields {
+		values[i], found[i] = hash.liveField(field, now)
+	}
+	return values, found, nil
+}
+
+// HashDelete removes fields and returns how many existed. The key is removed
+// once the hash becomes empty.
+func HashDelete(key string, fields []string) (int, error) {
+	hash, err := lockHash(key, false)
+	if err != nil || hash == nil {
+		return 0, err
+	}
+	defer hash.mutex.Unlock()
+
+	hash.notePeak()
+	now := time.Now()
+	removed := 0
+	for _, field := range fields {
+		if _, exists := hash.liveField(field, now); exists {
+			removed++
+		}
+		delete(hash.Fields, field)
+		delete(hash.Expires, field)
+	}
+	if len(hash.Fields) == 0 {
+		retire(key, hash)
+	}
+	return removed, nil
+}
+
+// HashLen returns the number of fields in the hash
+func HashLen(key string) (int, error) {
+	hash, err := loadHash(key, forRead)
+	if err != nil || hash == nil {
+		return 0, err
+	}
+
+	hash.mutex.RLock()
+	defer hash.mutex.RUnlock()
+
+	return hash.liveLen(time.Now()), nil
+}
+
+// liveLen returns the number of fields not expired at now; the caller must
+// hold the hash lock
+func (hash *Hash) liveLen(now time.Time) int {
+	length := len(hash.Fields)
+	for field := range hash.Expires {
+		if hash.fieldExpired(field, now) {
+			length--
+		}
+	}
+	return length
+}
+
+// HashGetAll returns the hash as a flat field, value, field, value... slice
+func HashGetAll(key string) ([]string, error) {
+	hash, err := loadHash(key, forRead)
+	if err != nil || hash == nil {
+		return []string{}, err
+	}
+
+	hash.mutex.RLock()
+	defer hash.mutex.RUnlock()
+
+	return hash.livePairs(time.Now()), nil
+}
+
+// livePairs returns the fields not expired at now and their values, as a
+// flat field, value... slice; the caller must hold the hash lock
+func (hash *Hash) livePairs(now time.Time) []string {
+	result := make([]string, 0, len(hash.Fields)*2)
+	for field, val := range hash.Fields {
+		if hash.fieldExpired(field, now) {
+			continue
+		}
+		result = append(result, field, val)
+	}
+	return result
+}
+
+// HashKeys returns all field names of the hash
+func HashKeys(key string) ([]string, error) {
+	hash, err := loadHash(key, forRead)
+	if err != nil || hash == nil {
+		return []string{}, err
+	}
+
+	hash.mutex.RLock()
+	defer hash.mutex.RUnlock()
+
+	now := time.Now()
+	result := make([]string, 0, len(hash.Fields))
+	for field := range hash.Fields {
+		if hash.fieldExpired(field, now) {
+			continue
+		}
+		result = append(result, field)
+	}
+	return result, nil
+}
+
+// HashValues returns all values of the hash
+func HashValues(key string) ([]string, error) {
+	hash, err := loadHash(key, forRead)
+	if err != nil || hash == nil {
+		return []string{}, err
+	}
+
+	hash.mutex.RLock()
+	defer hash.mutex.RUnlock()
+
+	now := time.Now()
+	result := make([]string, 0, len(hash.Fields))
+	for field, val := range hash.Fields {
+		if hash.fieldExpired(field, now) {
+			continue
+		}
+		result = append(result, val)
+	}
+	return result, nil
+}
+
+// HashIncrBy adds by to the integer stored in a field (0 when missing) under
+// the hash lock, so concurrent increments never lose updates
+func HashIncrBy(key, field string, by int64) (int64, error) {
+	hash, err := lockHash(key, true)
+	if err != nil {
+		return 0, err
+	}
+	defer hash.mutex.Unlock()
+
+	now := time.Now()
+	if hash.fieldExpired(field, now) {
+		delete(hash.Fields, field)
+		delete(hash.Expires, field)
+	}
+
+	var current int64
+	if val, exists := hash.Fields[field]; exists {
+		current, err = strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return 0, ErrHashNotInteger
+		}
+	}
+	if (by > 0 && current > math.MaxInt64-by) || (by < 0 && current < math.MinInt64-by) {
+		return 0, ErrOverflow
+	}
+
+	current += by
+	hash.Fields[field] = strconv.FormatInt(current, 10)
+	return current, nil
+}
+
+// HashIncrByFloat adds by to the float stored in a field (0 when missing)
+// under the hash lock and returns the new value in its stored form
+func HashIncrByFloat(key, field string, by float64) (string, error) {
+	hash, err := lockHash(key, true)
+	if err != nil {
+		return "", err
+	}
+	defer hash.mutex.Unlock()
+
+	now := time.Now()
+	if hash.fieldExpired(field, now) {
+		delete(hash.Fields, field)
+		delete(hash.Expires, field)
+	}
+
+	var current float64
+	if val, exists := hash.Fields[field]; exists {
+		current, err = strconv.ParseFloat(val, 64)
+		if err != nil {
+			return "", ErrHashNotFloat
+		}
+	}
+
+	current += by
+	if math.IsNaN(current) || math.IsInf(current, 0) {
+		return "", ErrNaNOrInfinity
+	}
+
+	formatted := strconv.FormatFloat(current, 'f', -1, 64)
+	hash.Fields[field] = formatted
+	return formatted, nil
+}
+
+// HashRandomFields picks random fields together with their values. A positive
+// count returns distinct fields, at most as many as the hash holds; a negative
+// count returns exactly -count fields and may repeat them.
+func HashRandomFields(key string, count int) ([]string, []string, error) {
+	hash, err := loadHash(key, forRead)
+	if err != nil || hash == nil {
+		return []string{}, []string{}, err
+	}
+
+	hash.mutex.RLock()
+	defer hash.mutex.RUnlock()
+
+	now := time.Now()
+	var picked []string
+	if count >= 0 {
+		reservoir := sample.NewReservoir(count)
+		for field := range hash.Fields {
+			if !hash.fieldExpired(field, now) {
+				reservoir.Offer(field)
+			}
+		}
+		picked = reservoir.Items()
+	} else {
+		all := make([]string, 0, len(hash.Fields))
+		for field := range hash.Fields {
+			if !hash.fieldExpired(field, now) {
+				all = append(all, field)
+			}
+		}
+		picked = make([]string, 0, -count)
+		for _, i := range sample.WithReplacement(len(all), -count) {
+			picked = append(picked, all[i])
+		}
+	}
+
+	values := make([]string, len(picked))
+	for i, field := range picked {
+		values[i] = hash.Fields[field]
+	}
+	return picked, values, nil
+}
+
+// HashExpireFields sets an absolute expiry on existing fields and returns one
+// Field* result per field. cond is "", "NX", "XX", "GT" or "LT". Fields whose
+// new expiry is already in the past are deleted immediately.
+func HashExpireFields(key string, at time.Time, cond string, fields []string) ([]int, error) {
+	results := make([]int, len(fields))
+	hash, err := lockHash(key, false)
+	if err != nil {
+		return nil, err
+	}
+	if hash == nil {
+		for i := range results {
+			results[i] = FieldMissing
+		}
+		return results, nil
+	}
+	defer hash.mutex.Unlock()
+
+	hash.notePeak()
+	now := time.Now()
+	for i, field := range fields {
+		if _, exists := hash.liveField(field, now); !exists {
+			results[i] = FieldMissing
+			continue
+		}
+
+		current, hasTTL := hash.Expires[field]
+		switch cond {
+		case "NX":
+			if hasTTL {
+				results[i] = FieldNotSet
+				continue
+			}
+		case "XX":
+			if !hasTTL {
+				results[i] = FieldNotSet
+				continue
+			}
+		case "GT":
+			// No TTL counts as an infinite one, which nothing is greater than.
+			if !hasTTL || !at.After(current) {
+				results[i] = FieldNotSet
+				continue
+			}
+		case "LT":
+			if hasTTL && !at.Before(current) {
+				results[i] = FieldNotSet
+				continue
+			}
+		}
+
+		if !at.After(now) {
+			delete(hash.Fields, field)
+			delete(hash.Expires, field)
+			results[i] = FieldExpireDelete
+			continue
+		}
+		if hash.Expires == nil {
+			hash.Expires = make(map[string]time.Time)
+		}
+		hash.Expires[field] = at
+		results[i] = FieldExpireSet
+	}
+
+	if len(hash.Fields) == 0 {
+		retire(key, hash)
+	}
+	return results, nil
+}
+
+// HashFieldTTLs returns the remaining time to live of each field in
+// milliseconds, or FieldMissing / FieldNoTTL
+func HashFieldTTLs(key string, fields []string) ([]int64, error) {
+	results := make([]int64, len(fields))
+	hash, err := loadHash(key, forRead)
+	if err != nil {
+		return nil, err
+	}
+	if hash == nil {
+		for i := range results {
+			results[i] = FieldMissing
+		}
+		return results, nil
+	}
+
+	hash.mutex.RLock()
+	defer hash.mutex.RUnlock()
+
+	now := time.Now()
+	for i, field := range fields {
+		if _, exists := hash.liveField(field, now); !exists {
+			results[i] = FieldMissing
+			continue
+		}
+		at, hasTTL := hash.Expires[field]
+		if !hasTTL {
+			results[i] = FieldNoTTL
+			continue
+		}
+		results[i] = at.Sub(now).Milliseconds()
+	}
+	return results, nil
+}
+
+// HashPersistFields removes the expiry of fields, returning FieldExpireSet when
+// one was removed, or FieldMissing / FieldNoTTL
+func HashPersistFields(key string, fields []string) ([]int, error) {
+	results := make([]int, len(fields))
+	hash, err := lockHash(key, false)
+	if err != nil {
+		return nil, err
+	}
+	if hash == nil {
+		for i := range results {
+			results[i] = FieldMissing
+		}
+		return results, nil
+	}
+	defer hash.mutex.Unlock()
+
+	now := time.Now()
+	for i, field := range fields {
+		if _, exists := hash.liveField(field, now); !exists {
+			results[i] = FieldMissing
+			continue
+		}
+		if _, hasTTL := hash.Expires[field]; !hasTTL {
+			results[i] = FieldNoTTL
+			continue
+		}
+		delete(hash.Expires, field)
+		results[i] = FieldExpireSet
+	}
+	return results, nil
+}
+
+// HashDeleteExpired removes every field whose TTL has elapsed and returns
+// their names, deleting the key once the hash is empty
+func HashDeleteExpired(key string) []string {
+	hash, err := lockHash(key, false)
+	if err != nil || hash == nil {
+		return nil
+	}
+	defer hash.mutex.Unlock()
+
+	hash.notePeak()
+	now := time.Now()
+	var expired []string
+	for field := range hash.Expires {
+		if hash.fieldExpired(field, now) {
+			delete(hash.Fields, field)
+			delete(hash.Expires, field)
+			expired = append(expired, field)
+		}
+	}
+	if len(hash.Fields) == 0 {
+		retire(key, hash)
+	}
+	return expired
+}
@@ -0,0 +1,58 @@
+package database
+
+import "sort"
+
+// TypeName returns the Redis type name of a stored value ("string", "list",
+// "hash", "set", "zset", "stream"), or "none" for values the store does not recognise.
+func TypeName(val interface{}) string {
+	switch val.(type) {
+	case *KeyValue:
+		return "string"
+	case *List:
+		return "list"
+	case *Hash:
+		return "hash"
+	case *Set:
+		return "set"
+	case *ZSet:
+		return "zset"
+	case StreamData:
+		return "stream"
+	default:
+		return "none"
+	}
+}
+
+// KeyType returns the Redis type name of a live key, or "none" when the key
+// does not exist or has logically expired.
+func KeyType(key string) string {
+	val, found := lookup(key)
+	if !found {
+		return "none"
+	}
+	return TypeName(val)
+}
+
+// Keys returns every live key in sorted order. Logically expired keys are
+// skipped but not deleted, so a replica keeps them until the master
+// propagates the DEL.
+func Keys() []string {
+	var keys []string
+	DB.Range(func(key, value interface{}) bool {
+		strKey, ok := key.(string)
+		if !ok || isExpired(value) {
+			return true
+		}
+		keys = append(keys, strKey)
+		return true
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+// Exists reports whether key holds a live value, without counting as an
+// access to it
+func Exists(key string) bool {
+	_, found := lookup(key)
+	return found
+}
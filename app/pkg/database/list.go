@@ -0,0 +1,137 @@
+package database
+
+import "sync/atomic"
+
+// minListCapacity is the smallest ring a list keeps once it has grown
+const minListCapacity = 8
+
+// List is a double-ended queue stored under a single key. Elements live in a
+// ring buffer whose capacity is a power of two, so pushes and pops at either
+// end are O(1) amortized instead of copying the whole list.
+type List struct {
+	ring  []string // len(ring) is the capacity
+	head  int      // Index in ring of the first element
+	n     int      // Number of elements
+	mutex keyMutex
+	// expireAt is the key's TTL, see ttl.go
+	expireAt atomic.Int64
+	lru      atomic.Uint32 // Last access of the key, see access.go
+}
+
+// newList creates a list holding elements, in order
+func newList(elements []string) *List {
+	l := &List{}
+	l.replace(elements)
+	return l
+}
+
+// The methods below must be called with the list lock held.
+
+// Len returns the number of elements
+func (l *List) Len() int {
+	return l.n
+}
+
+// slot maps the i-th element to its index in the ring
+func (l *List) slot(i int) int {
+	return (l.head + i) & (len(l.ring) - 1)
+}
+
+// At returns the i-th element, 0 <= i < Len()
+func (l *List) At(i int) string {
+	return l.ring[l.slot(i)]
+}
+
+func (l *List) set(i int, value string) {
+	l.ring[l.slot(i)] = value
+}
+
+// resize moves the elements into a fresh ring of capacity size, which must
+// be a power of two that can hold them
+func (l *List) resize(size int) {
+	ring := make([]string, size)
+	for i := 0; i < l.n; i++ {
+		ring[i] = l.At(i)
+	}
+	l.ring, l.head = ring, 0
+}
+
+func (l *List) grow(extra int) {
+	size := max(len(l.ring), minListCapacity)
+	for size < l.n+extra {
+		size *= 2
+	}
+	if size != len(l.ring) {
+		l.resize(size)
+	}
+}
+
+// shrink halves the ring once it is mostly empty, so a list that was long
+// once does not pin its peak memory
+func (l *List) shrink() {
+	if len(l.ring) > minListCapacity && l.n <= len(l.ring)/4 {
+		l.resize(len(l.ring) / 2)
+	}
+}
+
+func (l *List) pushBack(items ...string) {
+	l.grow(len(items))
+	for _, item := range items {
+		l.ring[l.slot(l.n)] = item
+		l.n++
+	}
+}
+
+func (l *List) pushFront(items ...string) {
+	l.grow(len(items))
+	for _, item := range items {
+		l.head = (l.head - 1) & (len(l.ring) - 1)
+		l.ring[l.head] = item
+		l.n++
+	}
+}
+
+func (l *List) popFront() string {
+	element := l.ring[l.head]
+	l.ring[l.head] = "" // Let the string be collected
+	l.head = (l.head + 1) & (len(l.ring) - 1)
+	l.n--
+	l.shrink()
+	return element
+}
+
+func (l *List) popBack() string {
+	last := l.slot(l.n - 1)
+	element := l.ring[last]
+	l.ring[last] = ""
+	l.n--
+	l.shrink()
+	return element
+}
+
+// pop removes the first element, or the last one when tail is set
+func (l *List) pop(tail bool) string {
+	if tail {
+		return l.popBack()
+	}
+	return l.popFront()
+}
+
+// Range copies the elements from start to stop inclusive, which must be
+// valid indexes
+func (l *List) Range(start, stop int) []string {
+	out := make([]string, 0, max(stop-start+1, 0))
+	for i := start; i <= stop; i++ {
+		out = append(out, l.At(i))
+	}
+	return out
+}
+
+// replace makes elements the whole content of the list. Operations in the
+// middle of the list (LINSERT, LREM, LTRIM) are O(n) anyway and rebuild it.
+func (l *List) replace(elements []string) {
+	l.ring, l.head, l.n = nil, 0, 0
+	if len(elements) > 0 {
+		l.pushBack(elements...)
+	}
+}
@@ -2,58 +2,103 @@ package database
 
 import (
 	"errors"
-	"fmt"
-	"time"
 
 	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
 )
 
-type BlPopRequest struct {
-	ListName   string
-	ResultChan chan []string
-	Timeout    time.Duration
-}
+// loadList returns the list stored at key. When the key is missing and mode
+// is forCreate, an empty list is stored and returned; otherwise nil is
+// returned. Logically expired keys count as missing for reads (see access)
+// and any other type is ErrWrongType, so list commands never overwrite
+// another type's data.
+func loadList(key string, mode access) (*List, error) {
+	val, found := DB.Load(key)
+	if found && mode.live(val) {
+		list, ok := val.(*List)
+		if !ok {
+			return nil, ErrWrongType
+		}
+		return list, nil
+	}
+	if mode != forCreate {
+		return nil, nil
+	}
 
-func RPushAdd(key string, item string) (int, error) {
-	logger := logging.NewLogger("RPUSH")
+	list := &List{}
+	touch(list)
+	if _, loaded := DB.LoadOrStore(key, list); loaded {
+		// Lost a race with another writer creating the key.
+		return loadList(key, mode)
+	}
+	return list, nil
+}
 
-	val, found := DB.Load(key)
-	var slice []string
+// lockList loads the list at key like loadList and write-locks it, see
+// lockWrite. It returns nil, without holding a lock, when there is no list
+// to work on.
+func lockList(key string, create bool) (*List, error) {
+	return lockWrite(key, func() (*List, error) {
+		return loadList(key, writeAccess(create))
+	})
+}
 
-	if found {
-		if s, ok := val.([]string); ok {
-			slice = s
-		} else {
-			return 0, errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
-		}
+// unlockList releases a list locked by lockList. A list left empty is
+// removed from the keyspace first, like every other list write.
+func unlockList(key string, list *List) {
+	if list.n == 0 {
+		retire(key, list)
 	} else {
-		slice = []string{}
+		touch(list)
 	}
+	list.mutex.Unlock()
+}
 
-	slice = append(slice, item)
-	DB.Store(key, slice)
-
-	logger.Debug("RPUSH: Added item '%s' to key '%s', new length: %d", item, key, len(slice))
-	return len(slice), nil
+// readList loads the list at key and read-locks it; the caller must
+// RUnlock it. nil is returned, without a lock, for a missing key.
+func readList(key string) (*List, error) {
+	list, err := loadList(key, forRead)
+	if err != nil || list == nil {
+		return nil, err
+	}
+	list.mutex.RLock()
+	touch(list)
+	return list, nil
 }
 
-func LRange(key string, start int, end int) ([]string, error) {
-	logger := logging.NewLogger("LRANGE")
+// RPushAdd appends items to the list at key in a single atomic step and
+// returns the new length
+func RPushAdd(key string, items ...string) (int, error) {
+	logger := logging.NewLogger("RPUSH")
 
-	val, found := DB.Load(key)
-	var slice []string
+	list, err := lockList(key, true)
+	if err != nil {
+		return 0, err
+	}
+	list.pushBack(items...)
+	n := list.n
+	unlockList(key, list)
+	SignalKeyReady(key)
 
-	if found {
-		if s, ok := val.([]string); ok {
-			slice = s
-		} else {
-			return nil, errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
-		}
-	} else {
+	logger.Debug("RPUSH: Added %d items to key '%s', new length: %d", len(items), key, n)
+	return n, nil
+}
+
+func LRange(key string, start int, end int) ([]string, error) {
+	list, err := readList(key)
+	if err != nil {
+		return nil, err
+	}
+	if list == nil {
 		return []string{}, nil
 	}
-	length := len(slice)
-	logger.Info("slice: %+v", slice)
+	defer list.mutex.RUnlock()
+	return list.rangeOf(start, end), nil
+}
+
+// rangeOf returns the elements from start to end inclusive, negative
+// indexes counting from the end, like LRANGE; the caller must hold the lock
+func (l *List) rangeOf(start, end int) []string {
+	length := l.n
 	if start < 0 {
 		test := length + start
 		if test < 0 {
@@ -73,99 +118,301 @@ func LRange(key string, start int, end int) ([]string, error) {
 	}
 
 	if start >= length || start > end {
-		return []string{}, nil
+		return []string{}
 	}
 	if end >= length {
 		end = length - 1
 	}
-	return slice[start : end+1], nil
+	return l.Range(start, end)
 }
 
-func LPush(key string, values string) (int, error) {
+// LPush prepends items to the list at key in a single atomic step, so the
+// last item ends up first like Redis' LPUSH, and returns the new length
+func LPush(key string, items ...string) (int, error) {
 	logger := logging.NewLogger("LPUSH")
 
-	val, found := DB.Load(key)
-	var slice []string
+	list, err := lockList(key, true)
+	if err != nil {
+		return 0, err
+	}
+	list.pushFront(items...)
+	n := list.n
+	unlockList(key, list)
+	SignalKeyReady(key)
 
-	if found {
-		if s, ok := val.([]string); ok {
-			slice = s
-		} else {
-			return 0, errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
-		}
-	} else {
-		slice = []string{}
+	logger.Debug("LPUSH: Added %d items to key '%s', new length: %d", len(items), key, n)
+	return n, nil
+}
+
+func GetArrayLength(key string) (int, error) {
+	list, err := readList(key)
+	if err != nil || list == nil {
+		return 0, err
 	}
+	defer list.mutex.RUnlock()
 
-	slice = append([]string{values}, slice...)
+	return list.n, nil
+}
 
-	DB.Store(key, slice)
+// popN removes up to count elements from the head of the list at key, or
+// from the tail when tail is set, in the order they were popped. found is
+// false when the key does not exist.
+func popN(key string, count int, tail bool) ([]string, bool, error) {
+	list, err := lockList(key, false)
+	if err != nil || list == nil {
+		return []string{}, false, err
+	}
+	defer unlockList(key, list)
 
-	logger.Debug("LPUSH: Added item '%+v' to key '%s', new length: %d", values, key, len(slice))
-	return len(slice), nil
+	popped := make([]string, min(count, list.n))
+	for i := range popped {
+		popped[i] = list.pop(tail)
+	}
+	return popped, true, nil
 }
 
-func GetArrayLength(key string) (int, error) {
+// LPop removes and returns up to count elements from the head of the list
+// at key. found is false when the key does not exist. The key is removed
+// once the list becomes empty.
+func LPop(key string, count int) ([]string, bool, error) {
+	return popN(key, count, false)
+}
 
-	val, found := DB.Load(key)
-	var slice []string
+// PopIfList pops the first element, or the last one when tail is set, of
+// the list at key for BLPOP/BRPOP. found is false while the key is missing
+// or empty; a key of another type is ErrWrongType instead of being waited on.
+func PopIfList(key string, tail bool) (string, bool, error) {
+	popped, _, err := popN(key, 1, tail)
+	if err != nil || len(popped) == 0 {
+		return "", false, err
+	}
+	return popped[0], true, nil
+}
 
-	if found {
-		if s, ok := val.([]string); ok {
-			slice = s
-		} else {
-			return 0, errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
-		}
-	} else {
-		slice = []string{}
+// RPop removes and returns up to count elements from the tail of the list
+// at key, last element first. found is false when the key does not exist.
+// The key is removed once the list becomes empty.
+func RPop(key string, count int) ([]string, bool, error) {
+	return popN(key, count, true)
+}
+
+// List errors surfaced by LSET; XINFO reports missing keys with ErrNoSuchKey
+// too
+var (
+	ErrNoSuchKey       = errors.New("ERR no such key")
+	ErrIndexOutOfRange = errors.New("ERR index out of range")
+)
+
+// listIndex resolves a possibly negative index against a list of length n.
+// ok is false when it falls outside the list.
+func listIndex(index, n int) (int, bool) {
+	if index < 0 {
+		index += n
 	}
+	return index, index >= 0 && index < n
+}
 
-	return len(slice), nil
+// LIndex returns the element at index; negative indexes count from the tail
+func LIndex(key string, index int) (string, bool, error) {
+	list, err := readList(key)
+	if err != nil || list == nil {
+		return "", false, err
+	}
+	defer list.mutex.RUnlock()
+
+	i, ok := listIndex(index, list.n)
+	if !ok {
+		return "", false, nil
+	}
+	return list.At(i), true, nil
 }
 
-func RemoveNFromArray(key string, n int) ([]string, error) {
+// LSet replaces the element at index
+func LSet(key string, index int, value string) error {
+	list, err := lockList(key, false)
+	if err != nil {
+		return err
+	}
+	if list == nil {
+		return ErrNoSuchKey
+	}
+	defer unlockList(key, list)
 
-	val, found := DB.Load(key)
-	var slice []string
+	i, ok := listIndex(index, list.n)
+	if !ok {
+		return ErrIndexOutOfRange
+	}
+	list.set(i, value)
+	return nil
+}
 
-	if found {
-		if s, ok := val.([]string); ok {
-			slice = s
-		} else {
-			return []string{}, errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+// LInsert inserts value before or after the first occurrence of pivot and
+// returns the new length. It returns 0 when the key does not exist and -1
+// when pivot is not in the list.
+func LInsert(key string, before bool, pivot, value string) (int, error) {
+	list, err := lockList(key, false)
+	if err != nil || list == nil {
+		return 0, err
+	}
+	defer unlockList(key, list)
+
+	at := -1
+	for i := 0; i < list.n; i++ {
+		if list.At(i) == pivot {
+			at = i
+			break
 		}
-	} else {
-		slice = []string{}
+	}
+	if at < 0 {
+		return -1, nil
+	}
+	if !before {
+		at++
 	}
 
-	length := len(slice)
-	if length == 0 {
-		return []string{}, nil
+	updated := make([]string, 0, list.n+1)
+	updated = append(updated, list.Range(0, at-1)...)
+	updated = append(updated, value)
+	updated = append(updated, list.Range(at, list.n-1)...)
+	list.replace(updated)
+	return list.n, nil
+}
+
+// LRem removes elements equal to value and returns how many were removed.
+// A positive count removes up to count of them from the head, a negative
+// count up to -count from the tail and zero removes all of them.
+func LRem(key string, count int, value string) (int, error) {
+	list, err := lockList(key, false)
+	if err != nil || list == nil {
+		return 0, err
 	}
-	var toRemove int
-	if n == 0 {
-		toRemove = n + 1
-	} else {
-		toRemove = n
+	defer unlockList(key, list)
+
+	limit := list.n
+	if count != 0 {
+		limit = min(limit, max(count, -count))
+	}
+
+	keep := make([]bool, list.n)
+	removed := 0
+	for n := range list.n {
+		i := n
+		if count < 0 {
+			i = list.n - 1 - n
+		}
+		if removed < limit && list.At(i) == value {
+			removed++
+			continue
+		}
+		keep[i] = true
+	}
+	if removed == 0 {
+		return 0, nil
 	}
 
-	if toRemove > length {
-		DB.Store(key, []string{})
-		return slice, nil
+	updated := make([]string, 0, list.n-removed)
+	for i := range list.n {
+		if keep[i] {
+			updated = append(updated, list.At(i))
+		}
 	}
+	list.replace(updated)
+	return removed, nil
+}
 
-	fmt.Printf("len(slice): %d, toRemove: %d\n", length, toRemove)
-	fmt.Printf("slice[:toRemove]: %+v\n", slice[:toRemove])
-	fmt.Printf("slice[toRemove:]: %+v\n", slice[toRemove:])
+// LTrim keeps only the elements from start to stop inclusive, with the
+// same index rules as LRANGE. A range selecting nothing removes the key.
+func LTrim(key string, start, stop int) error {
+	list, err := lockList(key, false)
+	if err != nil || list == nil {
+		return err
+	}
+	defer unlockList(key, list)
 
-	removedItems := slice[:toRemove]
-	remaining := slice[toRemove:]
+	n := list.n
+	if start < 0 {
+		start = max(start+n, 0)
+	}
+	if stop < 0 {
+		stop += n
+	}
+	stop = min(stop, n-1)
+	if start > stop {
+		list.replace(nil)
+		return nil
+	}
+	list.replace(list.Range(start, stop))
+	return nil
+}
 
-	DB.Store(key, remaining)
+// lockListPair write-locks the lists at src and dst for LMOVE, creating dst
+// when it is missing. Distinct lists are locked in key order so two moves
+// in opposite directions cannot deadlock. src is nil, and nothing is
+// locked, when src does not exist.
+func lockListPair(src, dst string) (*List, *List, error) {
+	for {
+		from, err := loadList(src, forWrite)
+		if err != nil || from == nil {
+			return nil, nil, err
+		}
+		to, err := loadList(dst, forCreate)
+		if err != nil {
+			return nil, nil, err
+		}
 
-	return removedItems, nil
+		if from == to {
+			from.mutex.Lock()
+			if !from.mutex.retired {
+				from = copyOnWrite(src, from)
+				return from, from, nil
+			}
+			from.mutex.Unlock()
+			continue
+		}
+
+		first, second := from, to
+		if dst < src {
+			first, second = to, from
+		}
+		first.mutex.Lock()
+		second.mutex.Lock()
+		if !from.mutex.retired && !to.mutex.retired {
+			return copyOnWrite(src, from), copyOnWrite(dst, to), nil
+		}
+		unlockList(dst, to) // Drops the dst we may have just created
+		from.mutex.Unlock()
+	}
 }
 
-func BLPop(listNames []string, timeoutSeconds int) {
+// LMove pops an element from one end of src and pushes it onto one end of
+// dst in a single step. found is false when src is missing or empty. A dst
+// of another type is ErrWrongType and leaves src untouched; src and dst may
+// be the same list, which rotates it.
+func LMove(src, dst string, fromTail, toTail bool) (string, bool, error) {
+	from, to, err := lockListPair(src, dst)
+	if err != nil || from == nil {
+		return "", false, err
+	}
+
+	if from.n == 0 {
+		unlockList(dst, to)
+		if from != to {
+			from.mutex.Unlock()
+		}
+		return "", false, nil
+	}
+
+	element := from.pop(fromTail)
+	if toTail {
+		to.pushBack(element)
+	} else {
+		to.pushFront(element)
+	}
 
+	if from != to {
+		unlockList(src, from)
+	}
+	unlockList(dst, to)
+	SignalKeyReady(dst)
+	return element, true, nil
 }
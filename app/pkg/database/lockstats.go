@@ -0,0 +1,206 @@
+package database
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Lock profiling measures how long commands wait for the locks of stored
+// values. It is off by default: then a keyMutex costs one atomic load more
+// than a plain sync.RWMutex. While on, an acquisition that can't take the
+// lock at once is timed, and the stats are kept on the mutex itself, so
+// they are found again through the key holding the value and are dropped
+// with it.
+
+// lockProfiling turns the timing of lock waits on and off
+var lockProfiling atomic.Bool
+
+// SetLockProfiling turns lock profiling on or off. Stats gathered so far are
+// kept; see ResetLockStats.
+func SetLockProfiling(enabled bool) {
+	lockProfiling.Store(enabled)
+}
+
+// LockProfiling reports whether lock profiling is on
+func LockProfiling() bool {
+	return lockProfiling.Load()
+}
+
+// lockCounters accumulate the acquisitions of one lock, or of all of them
+type lockCounters struct {
+	acquisitions atomic.Int64
+	contended    atomic.Int64 // Acquisitions that had to wait
+	wait         atomic.Int64 // Total nanoseconds waited
+	maxWait      atomic.Int64 // Longest wait in nanoseconds
+}
+
+// record counts an acquisition that waited wait, zero when uncontended
+func (c *lockCounters) record(wait time.Duration) {
+	c.acquisitions.Add(1)
+	if wait == 0 {
+		return
+	}
+	c.contended.Add(1)
+	c.wait.Add(int64(wait))
+	for {
+		longest := c.maxWait.Load()
+		if int64(wait) <= longest || c.maxWait.CompareAndSwap(longest, int64(wait)) {
+			return
+		}
+	}
+}
+
+// LockStats is a snapshot of lockCounters
+type LockStats struct {
+	Acquisitions int64
+	Contended    int64
+	Wait         time.Duration
+	MaxWait      time.Duration
+}
+
+func (c *lockCounters) snapshot() LockStats {
+	return LockStats{
+		Acquisitions: c.acquisitions.Load(),
+		Contended:    c.contended.Load(),
+		Wait:         time.Duration(c.wait.Load()),
+		MaxWait:      time.Duration(c.maxWait.Load()),
+	}
+}
+
+// lockTotals covers every profiled acquisition, including those of values
+// deleted since
+var lockTotals lockCounters
+
+// keyMutex is the lock of a stored value. It is used exactly like a
+// sync.RWMutex.
+type keyMutex struct {
+	sync.RWMutex
+	counters atomic.Pointer[lockCounters] // Allocated by the first profiled acquisition
+	pins     atomic.Int32                 // Snapshots reading the value, see Snapshot
+	// retired is set, under the write lock, once the value is no longer the
+	// one at its key: an emptied list deleted from the keyspace, or a value
+	// replaced by its copy while a snapshot reads it. A writer that loaded
+	// it before that must load the key again.
+	retired bool
+}
+
+// profile times an acquisition: try takes the lock if it is free, lock
+// waits for it
+func (m *keyMutex) profile(try func() bool, lock func()) {
+	var wait time.Duration
+	if !try() {
+		start := time.Now()
+		lock()
+		wait = max(time.Since(start), 1)
+	}
+
+	c := m.counters.Load()
+	if c == nil {
+		m.counters.CompareAndSwap(nil, new(lockCounters))
+		c = m.counters.Load()
+	}
+	c.record(wait)
+	lockTotals.record(wait)
+}
+
+func (m *keyMutex) Lock() {
+	if !lockProfiling.Load() {
+		m.RWMutex.Lock()
+		return
+	}
+	m.profile(m.RWMutex.TryLock, m.RWMutex.Lock)
+}
+
+func (m *keyMutex) RLock() {
+	if !lockProfiling.Load() {
+		m.RWMutex.RLock()
+		return
+	}
+	m.profile(m.RWMutex.TryRLock, m.RWMutex.RLock)
+}
+
+// stats returns the mutex's stats and whether it was ever profiled
+func (m *keyMutex) stats() (LockStats, bool) {
+	c := m.counters.Load()
+	if c == nil {
+		return LockStats{}, false
+	}
+	return c.snapshot(), true
+}
+
+// valueMutex returns the lock of a stored value, nil for strings, which
+// have none of their own
+func valueMutex(val interface{}) *keyMutex {
+	switch v := val.(type) {
+	case *List:
+		return &v.mutex
+	case *Hash:
+		return &v.mutex
+	case *Set:
+		return &v.mutex
+	case *ZSet:
+		return &v.mutex
+	case StreamData:
+		return &v.Stream.mutex
+	default:
+		return nil
+	}
+}
+
+// KeyLockStats is the lock profile of one key
+type KeyLockStats struct {
+	Key  string
+	Type string
+	LockStats
+}
+
+// LockTotals returns the stats of every profiled acquisition together
+func LockTotals() LockStats {
+	return lockTotals.snapshot()
+}
+
+// CollectLockStats returns the n keys (all for n <= 0) that waited longest
+// for their locks, longest first. Writes to strings share one lock,
+// reported under the key "(string writes)".
+func CollectLockStats(n int) []KeyLockStats {
+	var keys []KeyLockStats
+	if stats, ok := stringWrites.stats(); ok {
+		keys = append(keys, KeyLockStats{Key: "(string writes)", Type: "string", LockStats: stats})
+	}
+	DB.Range(func(k, val interface{}) bool {
+		if m := valueMutex(val); m != nil {
+			if stats, ok := m.stats(); ok {
+				keys = append(keys, KeyLockStats{Key: k.(string), Type: TypeName(val), LockStats: stats})
+			}
+		}
+		return true
+	})
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Wait != keys[j].Wait {
+			return keys[i].Wait > keys[j].Wait
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if n > 0 && len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+// ResetLockStats clears the totals and the stats of every key
+func ResetLockStats() {
+	stringWrites.counters.Store(nil)
+	DB.Range(func(_, val interface{}) bool {
+		if m := valueMutex(val); m != nil {
+			m.counters.Store(nil)
+		}
+		return true
+	})
+	lockTotals.acquisitions.Store(0)
+	lockTotals.contended.Store(0)
+	lockTotals.wait.Store(0)
+	lockTotals.maxWait.Store(0)
+}
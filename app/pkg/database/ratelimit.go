@@ -0,0 +1,89 @@
+package database
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RATELIMIT is a token bucket kept in a plain string, so it shows up as a
+// string key, can be inspected with GET and is reset with DEL. The bucket
+// holds up to capacity tokens and refills continuously, capacity tokens
+// per interval; each call takes one token if there is one.
+//
+// The value is "<tokens>:<unix ms of the last update>", with tokens kept to
+// three decimals. Every update goes through updateString, so concurrent
+// calls on one key never both take the last token.
+
+// ErrNotRateLimiter is returned when the key holds a string that is not a
+// token bucket
+var ErrNotRateLimiter = errors.New("ERR key does not hold a rate limiter")
+
+// RateLimit is the outcome of taking a token
+type RateLimit struct {
+	Allowed    bool
+	Remaining  int           // Whole tokens left after this call
+	RetryAfter time.Duration // Until a token is available, when not allowed
+	State      string        // The stored value, for replicating the call's effect
+	ExpiresAt  time.Time     // When the stored value expires, when allowed
+}
+
+// RateLimitTake takes a token from the bucket at key, creating a full bucket
+// when the key is missing. A bucket left alone for interval is full again,
+// so the key is given interval as TTL and idle buckets don't linger.
+func RateLimitTake(key string, capacity int, interval time.Duration) (RateLimit, error) {
+	var result RateLimit
+	rate := float64(capacity) / float64(interval.Milliseconds()) // Tokens per ms
+
+	_, err := updateString(key, func(kv *KeyValue) error {
+		now := time.Now()
+		tokens := float64(capacity)
+		if kv.Val != "" {
+			stored, last, err := parseBucket(kv.Val)
+			if err != nil {
+				return err
+			}
+			elapsed := float64(now.UnixMilli() - last)
+			tokens = math.Min(float64(capacity), stored+max(elapsed, 0)*rate)
+		}
+
+		if tokens < 1 {
+			result = RateLimit{
+				Remaining:  0,
+				RetryAfter: time.Duration(math.Ceil((1-tokens)/rate)) * time.Millisecond,
+				State:      kv.Val,
+			}
+			return nil
+		}
+
+		tokens--
+		result = RateLimit{
+			Allowed:   true,
+			Remaining: int(tokens),
+			State:     strconv.FormatFloat(tokens, 'f', 3, 64) + ":" + strconv.FormatInt(now.UnixMilli(), 10),
+			ExpiresAt: now.Add(interval),
+		}
+		kv.Val, kv.buf, kv.Px, kv.T = result.State, nil, int(interval.Milliseconds()), now
+		return nil
+	})
+	return result, err
+}
+
+// parseBucket splits a stored bucket into its tokens and last update time
+func parseBucket(s string) (float64, int64, error) {
+	tokensPart, lastPart, found := strings.Cut(s, ":")
+	if !found {
+		return 0, 0, ErrNotRateLimiter
+	}
+	tokens, err := strconv.ParseFloat(tokensPart, 64)
+	if err != nil || tokens < 0 {
+		return 0, 0, ErrNotRateLimiter
+	}
+	last, err := strconv.ParseInt(lastPart, 10, 64)
+	if err != nil {
+		return 0, 0, ErrNotRateLimiter
+	}
+	return tokens, last, nil
+}
@@ -0,0 +1,166 @@
+package database
+
+import "time"
+
+// Record is a key and its value in plain form, as an RDB file stores it
+type Record struct {
+	Key      string
+	Type     string    // TypeName of the value
+	ExpireAt time.Time // Zero when the key does not expire
+	String   string    // Value of a string
+	Elements []string  // Elements of a list, in order, or members of a set
+	Pairs    []string  // Fields and values of a hash, alternating
+	Members  []ZMember // Members of a sorted set, by rank
+	// FieldExpires holds the TTLs of the hash fields that have one
+	FieldExpires map[string]time.Time
+	// Stream is a stream with its entries, last ID and consumer groups.
+	// Records passes the pinned stream itself, which fn must only read and
+	// not keep.
+	Stream *Stream
+}
+
+// Records calls fn with every live key of a Snapshot as a Record, until fn
+// returns false. pinned, when not nil, is called once the snapshot is
+// pinned, before fn.
+func Records(pinned func(), fn func(Record) bool) {
+	now := time.Now()
+	snapshot(pinned, func(key string, val interface{}) bool {
+		if isExpired(val) {
+			return true
+		}
+		rec := Record{Key: key, Type: TypeName(val)}
+		if at, volatile := deadline(val); volatile {
+			rec.ExpireAt = time.Unix(0, at)
+		}
+		switch v := val.(type) {
+		case *KeyValue:
+			rec.String = v.Val
+		case *List:
+			rec.Elements = v.Range(0, v.n-1)
+		case *Set:
+			rec.Elements = make([]string, 0, len(v.Members))
+			for member := range v.Members {
+				rec.Elements = append(rec.Elements, member)
+			}
+		case *Hash:
+			rec.Pairs = make([]string, 0, 2*len(v.Fields))
+			for field, value := range v.Fields {
+				if v.fieldExpired(field, now) {
+					continue
+				}
+				rec.Pairs = append(rec.Pairs, field, value)
+				if at, volatile := v.Expires[field]; volatile {
+					if rec.FieldExpires == nil {
+						rec.FieldExpires = make(map[string]time.Time)
+					}
+					rec.FieldExpires[field] = at
+				}
+			}
+		case *ZSet:
+			rec.Members = v.rangeByRank(0, -1, false)
+		case StreamData:
+			rec.Stream = v.Stream
+		}
+		return fn(rec)
+	})
+}
+
+// Restore stores rec, replacing whatever is at its key. An empty list,
+// set, hash or sorted set deletes the key instead, as none can exist, and
+// hash fields whose TTL has elapsed are left out.
+func Restore(rec Record) {
+	var val interface{}
+	switch rec.Type {
+	case "string":
+		if rec.ExpireAt.IsZero() {
+			SetKey(rec.Key, rec.String, -1)
+		} else {
+			SetKeyAt(rec.Key, rec.String, rec.ExpireAt)
+		}
+		return
+	case "list":
+		val = newList(rec.Elements)
+	case "set":
+		set := &Set{Members: make(map[string]struct{}, len(rec.Elements))}
+		for _, member := range rec.Elements {
+			set.Members[member] = struct{}{}
+		}
+		val = set
+	case "hash":
+		hash := &Hash{Fields: make(map[string]string, len(rec.Pairs)/2)}
+		now := time.Now()
+		for i := 0; i+1 < len(rec.Pairs); i += 2 {
+			field := rec.Pairs[i]
+			if at, volatile := rec.FieldExpires[field]; volatile {
+				if !at.After(now) {
+					continue
+				}
+				if hash.Expires == nil {
+					hash.Expires = make(map[string]time.Time)
+				}
+				hash.Expires[field] = at
+			}
+			hash.Fields[field] = rec.Pairs[i+1]
+		}
+		if len(hash.Fields) == 0 {
+			DeleteKey(rec.Key)
+			return
+		}
+		val = hash
+	case "zset":
+		zset := newZSet()
+		for _, m := range rec.Members {
+			zset.set(m.Member, m.Score)
+		}
+		val = zset
+	case "stream":
+		if rec.Stream != nil {
+			restoreStream(rec)
+		}
+		return
+	default:
+		return
+	}
+
+	if len(rec.Elements)+len(rec.Pairs)+len(rec.Members) == 0 {
+		DeleteKey(rec.Key)
+		return
+	}
+	if !rec.ExpireAt.IsZero() {
+		containerExpireAt(val).Store(rec.ExpireAt.UnixNano())
+	}
+	touch(val)
+	DB.Store(rec.Key, val)
+	if !rec.ExpireAt.IsZero() {
+		indexExpiry(rec.Key)
+	}
+}
+
+// restoreStream stores the stream of rec, which can be empty, after
+// indexing its entries by ID
+func restoreStream(rec Record) {
+	stream := rec.Stream
+	if stream.Entries == nil {
+		stream.Entries = make([]StreamEntry, 0)
+	}
+	if stream.LastID == "" {
+		stream.LastID = "0-0"
+	}
+	stream.Bytes = 0
+	for i := range stream.Entries {
+		stream.Entries[i].key, _ = parseStreamID(stream.Entries[i].ID)
+		stream.Bytes += entrySize(stream.Entries[i].Fields)
+	}
+	last, _ := parseStreamID(stream.LastID)
+	stream.LastSeqNum = int64(last.seq)
+
+	val := StreamData{Stream: stream, Px: -1, T: time.Now()}
+	if !rec.ExpireAt.IsZero() {
+		val.Px, val.T = ttlFields(rec.ExpireAt.UnixNano())
+	}
+	touch(val)
+	DB.Store(rec.Key, val)
+	if !rec.ExpireAt.IsZero() {
+		indexExpiry(rec.Key)
+	}
+}
@@ -0,0 +1,99 @@
+package database
+
+import "testing"
+
+// lockAfterStaleLoad write-locks the value at key the way a writer does
+// that loaded stale before another write emptied and deleted it, and only
+// then got its lock
+func lockAfterStaleLoad[V copyable[V]](t *testing.T, key string, stale V, load func() (V, error)) V {
+	t.Helper()
+	first := true
+	v, err := lockWrite(key, func() (V, error) {
+		if first {
+			first = false
+			return stale, nil
+		}
+		return load()
+	})
+	if err != nil {
+		t.Fatalf("lockWrite: %v", err)
+	}
+	if v == stale {
+		v.valueLock().Unlock()
+		t.Fatalf("writer got the deleted value instead of loading %q again", key)
+	}
+	return v
+}
+
+func TestHashDeleteRetiresEmptiedHash(t *testing.T) {
+	Flush()
+	HashSet("h", []string{"f", "1"})
+	stale, _ := DB.Load("h")
+	HashDelete("h", []string{"f"})
+
+	hash := lockAfterStaleLoad(t, "h", stale.(*Hash), func() (*Hash, error) {
+		return loadHash("h", forCreate)
+	})
+	hash.Fields["g"] = "2"
+	hash.mutex.Unlock()
+
+	if got := KeyType("h"); got != "hash" {
+		t.Fatalf("TYPE h = %s, want hash", got)
+	}
+	if v, ok, _ := HashGet("h", "g"); !ok || v != "2" {
+		t.Fatalf("HGET h g = %q, %t; want \"2\", true", v, ok)
+	}
+}
+
+func TestSetRemoveRetiresEmptiedSet(t *testing.T) {
+	Flush()
+	SetAdd("s", []string{"a"})
+	stale, _ := DB.Load("s")
+	SetRemove("s", []string{"a"})
+
+	set := lockAfterStaleLoad(t, "s", stale.(*Set), func() (*Set, error) {
+		return loadSet("s", forCreate)
+	})
+	set.Members["b"] = struct{}{}
+	set.mutex.Unlock()
+
+	if ok, _ := SetIsMember("s", "b"); !ok {
+		t.Fatal("SISMEMBER s b = 0 after the write, want 1")
+	}
+}
+
+func TestZSetRemoveRetiresEmptiedZSet(t *testing.T) {
+	Flush()
+	ZSetAdd("z", []ZMember{{Member: "a", Score: 1}}, ZAddFlags{})
+	stale, _ := DB.Load("z")
+	ZSetRemove("z", []string{"a"})
+
+	zset := lockAfterStaleLoad(t, "z", stale.(*ZSet), func() (*ZSet, error) {
+		return loadZSet("z", forCreate)
+	})
+	zset.set("b", 2)
+	zset.mutex.Unlock()
+
+	if score, ok, _ := ZSetScore("z", "b"); !ok || score != 2 {
+		t.Fatalf("ZSCORE z b = %v, %t; want 2, true", score, ok)
+	}
+}
+
+func TestListPopRetiresEmptiedList(t *testing.T) {
+	Flush()
+	RPushAdd("l", "a")
+	stale, _ := DB.Load("l")
+	list, _ := lockList("l", false)
+	list.popFront()
+	unlockList("l", list)
+
+	list = lockAfterStaleLoad(t, "l", stale.(*List), func() (*List, error) {
+		return loadList("l", forCreate)
+	})
+	list.pushBack("b")
+	unlockList("l", list)
+
+	if got := KeyType("l"); got != "list" {
+		t.Fatalf("TYPE l = %s, want list", got)
+	}
+}
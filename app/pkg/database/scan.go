@@ -0,0 +1,273 @@
+package database
+
+import (
+	"cmp"
+	"hash/maphash"
+	"slices"
+	"sync"
+	"time"
+)
+
+// The SCAN family visits items in the order of a hash of their name, and a
+// cursor is the hash to resume from. The order does not depend on which
+// other items exist, so an item present for the whole iteration is returned
+// however many are added or deleted meanwhile.
+//
+// To resume without walking every item again, an iteration sorts the items
+// by hash once, on its first page, and keeps that index for its source (the
+// keyspace or a collection) until it completes. Later pages binary search
+// the index for the cursor and check that the items they return are still
+// there; items added after the index was built are not returned, which an
+// iteration may do for items that were not there from its start. An index
+// that was evicted is built again from the cursor on.
+
+// scanSeed keys the hash; cursors are only valid within one process
+var scanSeed = maphash.MakeSeed()
+
+func scanPosition(item string) uint64 {
+	return maphash.String(scanSeed, item)
+}
+
+// scanItem is an item of a scan index and its position in hash order
+type scanItem struct {
+	pos  uint64
+	item string
+}
+
+// scanIndex is the items of a source sorted by position, as of the start
+// of an iteration or later
+type scanIndex struct {
+	items []scanItem
+	used  time.Time
+}
+
+const (
+	maxScanIndexes = 16               // Iterations whose index is kept
+	scanIndexIdle  = 30 * time.Second // How long an unused index is kept
+)
+
+// scanIndexes holds the index of each source being iterated, at most one
+// per source. A newer one replaces it: built at or after the start of every
+// iteration in progress, it serves them all.
+var scanIndexes = struct {
+	sync.Mutex
+	bySource map[any]*scanIndex
+}{bySource: make(map[any]*scanIndex)}
+
+// keyspaceSource identifies the keyspace among the sources of scanIndexes
+type keyspaceSource struct{}
+
+// cachedScanIndex returns the index of source, if one is kept
+func cachedScanIndex(source any) *scanIndex {
+	scanIndexes.Lock()
+	defer scanIndexes.Unlock()
+	index := scanIndexes.bySource[source]
+	if index != nil {
+		index.used = time.Now()
+	}
+	return index
+}
+
+// keepScanIndex keeps index for source, in place of the one it had. When
+// too many are kept the least recently used one goes.
+func keepScanIndex(source any, index *scanIndex) {
+	scanIndexes.Lock()
+	defer scanIndexes.Unlock()
+	index.used = time.Now()
+	dropIdleScanIndexes(index.used)
+	if _, replacing := scanIndexes.bySource[source]; !replacing && len(scanIndexes.bySource) >= maxScanIndexes {
+		var oldest any
+		for s, kept := range scanIndexes.bySource {
+			if oldest == nil || kept.used.Before(scanIndexes.bySource[oldest].used) {
+				oldest = s
+			}
+		}
+		delete(scanIndexes.bySource, oldest)
+	}
+	scanIndexes.bySource[source] = index
+}
+
+// dropIdleScanIndexes drops the indexes unused for scanIndexIdle, those of
+// iterations that were given up; the caller holds the scanIndexes lock
+func dropIdleScanIndexes(now time.Time) {
+	for source, kept := range scanIndexes.bySource {
+		if now.Sub(kept.used) > scanIndexIdle {
+			delete(scanIndexes.bySource, source)
+		}
+	}
+}
+
+// ScanIndexCron frees the scan indexes of iterations that were given up.
+// It runs on every tick of the server cron.
+func ScanIndexCron() {
+	scanIndexes.Lock()
+	defer scanIndexes.Unlock()
+	dropIdleScanIndexes(time.Now())
+}
+
+// dropScanIndex forgets the index of source once an iteration completes
+func dropScanIndex(source any, index *scanIndex) {
+	scanIndexes.Lock()
+	defer scanIndexes.Unlock()
+	if scanIndexes.bySource[source] == index {
+		delete(scanIndexes.bySource, source)
+	}
+}
+
+// buildScanIndex walks the items of each from cursor on and sorts them
+func buildScanIndex(cursor uint64, each func(yield func(string) bool)) *scanIndex {
+	index := &scanIndex{}
+	each(func(item string) bool {
+		if pos := scanPosition(item); pos >= cursor {
+			index.items = append(index.items, scanItem{pos, item})
+		}
+		return true
+	})
+	slices.SortFunc(index.items, func(a, b scanItem) int { return cmp.Compare(a.pos, b.pos) })
+	return index
+}
+
+// scanPage returns the items of source that come next in hash order from
+// cursor on, and the cursor of the following page (0 once the iteration is
+// complete). each calls yield for every item of source and stops when it
+// returns false; it is only called when the index of source has to be
+// built. present reports whether an item of the index is still there.
+//
+// A page looks at count items of the index, so it returns fewer when some
+// were deleted meanwhile. Items whose hashes collide are returned together,
+// so it may hold a few more.
+func scanPage(source any, cursor uint64, count int, each func(yield func(string) bool), present func(string) bool) ([]string, uint64) {
+	var index *scanIndex
+	if cursor != 0 {
+		index = cachedScanIndex(source)
+	}
+	if index == nil {
+		index = buildScanIndex(cursor, each)
+		if len(index.items) <= count {
+			// One page holds the rest: no index to keep
+			return scanRange(index.items, present), 0
+		}
+		keepScanIndex(source, index)
+	}
+
+	items := index.items
+	first, _ := slices.BinarySearchFunc(items, cursor, func(it scanItem, pos uint64) int { return cmp.Compare(it.pos, pos) })
+	end := min(first+count, len(items))
+	for end < len(items) && end > first && items[end].pos == items[end-1].pos {
+		end++
+	}
+	if end == len(items) {
+		dropScanIndex(source, index)
+		return scanRange(items[first:], present), 0
+	}
+	return scanRange(items[first:end], present), items[end-1].pos + 1
+}
+
+// scanRange returns the items of a stretch of an index that are present
+func scanRange(items []scanItem, present func(string) bool) []string {
+	page := make([]string, 0, len(items))
+	for _, it := range items {
+		if present(it.item) {
+			page = append(page, it.item)
+		}
+	}
+	return page
+}
+
+// ScanKeys returns a page of live keys and the cursor of the next page,
+// starting from cursor 0
+func ScanKeys(cursor uint64, count int) ([]string, uint64) {
+	return scanPage(keyspaceSource{}, cursor, count, func(yield func(string) bool) {
+		DB.Range(func(key, value interface{}) bool {
+			strKey, ok := key.(string)
+			if !ok || isExpired(value) {
+				return true
+			}
+			return yield(strKey)
+		})
+	}, func(key string) bool {
+		_, found := lookup(key)
+		return found
+	})
+}
+
+// SetScan returns a page of the members of the set and the cursor of the
+// next page
+func SetScan(key string, cursor uint64, count int) ([]string, uint64, error) {
+	set, err := loadSet(key, forRead)
+	if err != nil || set == nil {
+		return []string{}, 0, err
+	}
+
+	set.mutex.RLock()
+	defer set.mutex.RUnlock()
+
+	members, next := scanPage(set, cursor, count, func(yield func(string) bool) {
+		for member := range set.Members {
+			if !yield(member) {
+				return
+			}
+		}
+	}, func(member string) bool {
+		_, found := set.Members[member]
+		return found
+	})
+	return members, next, nil
+}
+
+// HashScan returns a page of the live fields of the hash, alternating with
+// their values, and the cursor of the next page
+func HashScan(key string, cursor uint64, count int) ([]string, uint64, error) {
+	hash, err := loadHash(key, forRead)
+	if err != nil || hash == nil {
+		return []string{}, 0, err
+	}
+
+	hash.mutex.RLock()
+	defer hash.mutex.RUnlock()
+
+	now := time.Now()
+	fields, next := scanPage(hash, cursor, count, func(yield func(string) bool) {
+		for field := range hash.Fields {
+			if !hash.fieldExpired(field, now) && !yield(field) {
+				return
+			}
+		}
+	}, func(field string) bool {
+		_, found := hash.liveField(field, now)
+		return found
+	})
+	pairs := make([]string, 0, 2*len(fields))
+	for _, field := range fields {
+		pairs = append(pairs, field, hash.Fields[field])
+	}
+	return pairs, next, nil
+}
+
+// ZSetScan returns a page of the members of the sorted set with their scores
+// and the cursor of the next page
+func ZSetScan(key string, cursor uint64, count int) ([]ZMember, uint64, error) {
+	zset, err := loadZSet(key, forRead)
+	if err != nil || zset == nil {
+		return []ZMember{}, 0, err
+	}
+
+	zset.mutex.RLock()
+	defer zset.mutex.RUnlock()
+
+	names, next := scanPage(zset, cursor, count, func(yield func(string) bool) {
+		for member := range zset.Scores {
+			if !yield(member) {
+				return
+			}
+		}
+	}, func(member string) bool {
+		_, found := zset.Scores[member]
+		return found
+	})
+	members := make([]ZMember, len(names))
+	for i, member := range names {
+		members[i] = ZMember{Member: member, Score: zset.Scores[member]}
+	}
+	return members, next, nil
+}
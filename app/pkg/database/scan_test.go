@@ -0,0 +1,67 @@
+package database
+
+import (
+	"strconv"
+	"testing"
+)
+
+// TestScanPageWork iterates a large source with a small COUNT and checks
+// that only the first page walks it: later ones look at COUNT items
+func TestScanPageWork(t *testing.T) {
+	const n, count = 10000, 10
+	items := make(map[string]bool, n)
+	for i := 0; i < n; i++ {
+		items["item:"+strconv.Itoa(i)] = true
+	}
+	walked, looked := 0, 0
+	each := func(yield func(string) bool) {
+		for item := range items {
+			walked++
+			if !yield(item) {
+				return
+			}
+		}
+	}
+	present := func(item string) bool {
+		looked++
+		return items[item]
+	}
+
+	source := new(int)
+	seen := make(map[string]int, n)
+	var cursor uint64
+	for pages := 1; ; pages++ {
+		looked = 0
+		var page []string
+		page, cursor = scanPage(source, cursor, count, each, present)
+		for _, item := range page {
+			seen[item]++
+		}
+		if looked > count+1 {
+			t.Fatalf("page %d looked at %d items, want about %d", pages, looked, count)
+		}
+		if pages == n/count/2 {
+			// An evicted index is built again from the cursor on
+			dropScanIndex(source, cachedScanIndex(source))
+		}
+		if cursor == 0 {
+			break
+		}
+		if pages > n {
+			t.Fatal("the iteration did not complete")
+		}
+	}
+
+	for item := range items {
+		if seen[item] != 1 {
+			t.Fatalf("%s returned %d times, want once", item, seen[item])
+		}
+	}
+	// One walk for the index and one after the eviction
+	if walked != 2*n {
+		t.Errorf("the iteration walked %d items, want %d", walked, 2*n)
+	}
+	if cachedScanIndex(source) != nil {
+		t.Error("the index is kept after the iteration completed")
+	}
+}
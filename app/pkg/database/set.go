@@ -0,0 +1,385 @@
+package database
+
+import (
+	"sort"
+	"sync/atomic"
+
+	"github.com/r0ld3x/redis-clone-go/app/pkg/sample"
+)
+
+// Set is an unordered collection of unique members stored under a single key
+type Set struct {
+	Members map[string]struct{}
+	peak    int // Most members held before a deletion, see compact
+	mutex   keyMutex
+	// expireAt is the key's TTL, see ttl.go
+	expireAt atomic.Int64
+	lru      atomic.Uint32 // Last access of the key, see access.go
+}
+
+// loadSet returns the set stored at key. When the key is missing and mode is
+// forCreate, an empty set is stored and returned; otherwise nil is returned.
+func loadSet(key string, mode access) (*Set, error) {
+	val, found := DB.Load(key)
+	if found && mode.live(val) {
+		set, ok := val.(*Set)
+		if !ok {
+			return nil, ErrWrongType
+		}
+		touch(set)
+		return set, nil
+	}
+	if mode != forCreate {
+		return nil, nil
+	}
+
+	set := &Set{Members: make(map[string]struct{})}
+	touch(set)
+	if _, loaded := DB.LoadOrStore(key, set); loaded {
+		// Lost a race with another writer creating the key.
+		return loadSet(key, mode)
+	}
+	return set, nil
+}
+
+// lockSet loads the set at key like loadSet and write-locks it, see
+// lockWrite. It returns nil, without holding a lock, when there is no set
+// to work on.
+func lockSet(key string, create bool) (*Set, error) {
+	return lockWrite(key, func() (*Set, error) {
+		return loadSet(key, writeAccess(create))
+	})
+}
+
+// SetAdd adds members to the set and returns how many were not already present
+func SetAdd(key string, members []string) (int, error) {
+	set, err := lockSet(key, true)
+	if err != nil {
+		return 0, err
+	}
+	defer set.mutex.Unlock()
+
+	added := 0
+	for _, member := range members {
+		if _, exists := set.Members[member]; !exists {
+			set.Members[member] = struct{}{}
+			added++
+		}
+	}
+	return added, nil
+}
+
+// SetRemove removes members and returns how many existed. The key is removed
+// once the set becomes empty.
+func SetRemove(key string, members []string) (int, error) {
+	set, err := lockSet(key, false)
+	if err != nil || set == nil {
+		return 0, err
+	}
+	defer set.mutex.Unlock()
+
+	set.notePeak()
+	removed := 0
+	for _, member := range members {
+		if _, exists := set.Members[member]; exists {
+			delete(set.Members, member)
+			removed++
+		}
+	}
+	if len(set.Members) == 0 {
+		retire(key, set)
+	}
+	return removed, nil
+}
+
+// SetMembers returns every member of the set in sorted order
+func SetMembers(key string) ([]string, error) {
+	set, err := loadSet(key, forRead)
+	if err != nil || set == nil {
+		return []string{}, err
+	}
+
+	set.mutex.RLock()
+	defer set.mutex.RUnlock()
+	return set.sortedMembers(), nil
+}
+
+// sortedMembers returns the members in sorted order; the caller must hold
+// the lock
+func (set *Set) sortedMembers() []string {
+	members := make([]string, 0, len(set.Members))
+	for member := range set.Members {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members
+}
+
+// SetIsMember reports whether member belongs to the set
+func SetIsMember(key, member string) (bool, error) {
+	set, err := loadSet(key, forRead)
+	if err != nil || set == nil {
+		return false, err
+	}
+
+	set.mutex.RLock()
+	defer set.mutex.RUnlock()
+
+	_, exists := set.Members[member]
+	return exists, nil
+}
+
+// SetCard returns the number of members in the set
+func SetCard(key string) (int, error) {
+	set, err := loadSet(key, forRead)
+	if err != nil || set == nil {
+		return 0, err
+	}
+
+	set.mutex.RLock()
+	defer set.mutex.RUnlock()
+
+	return len(set.Members), nil
+}
+
+// SetMultiIsMember reports, for each member, whether it belongs to the set
+func SetMultiIsMember(key string, members []string) ([]bool, error) {
+	found := make([]bool, len(members))
+
+	set, err := loadSet(key, forRead)
+	if err != nil || set == nil {
+		return found, err
+	}
+
+	set.mutex.RLock()
+	defer set.mutex.RUnlock()
+
+	for i, member := range members {
+		_, found[i] = set.Members[member]
+	}
+	return found, nil
+}
+
+// SetRandomMembers picks random members without removing them. A positive
+// count returns distinct members, at most as many as the set holds; a
+// negative count returns exactly -count members and may repeat them.
+func SetRandomMembers(key string, count int) ([]string, error) {
+	set, err := loadSet(key, forRead)
+	if err != nil || set == nil {
+		return []string{}, err
+	}
+
+	set.mutex.RLock()
+	defer set.mutex.RUnlock()
+
+	if count >= 0 {
+		reservoir := sample.NewReservoir(count)
+		for member := range set.Members {
+			reservoir.Offer(member)
+		}
+		return reservoir.Items(), nil
+	}
+
+	all := make([]string, 0, len(set.Members))
+	for member := range set.Members {
+		all = append(all, member)
+	}
+	picked := make([]string, 0, -count)
+	for _, i := range sample.WithReplacement(len(all), -count) {
+		picked = append(picked, all[i])
+	}
+	return picked, nil
+}
+
+// SetPop removes and returns up to count random members. The key is removed
+// once the set becomes empty.
+func SetPop(key string, count int) ([]string, error) {
+	set, err := lockSet(key, false)
+	if err != nil || set == nil {
+		return []string{}, err
+	}
+	defer set.mutex.Unlock()
+
+	reservoir := sample.NewReservoir(count)
+	for member := range set.Members {
+		reservoir.Offer(member)
+	}
+	popped := reservoir.Items()
+	set.notePeak()
+	for _, member := range popped {
+		delete(set.Members, member)
+	}
+	if len(set.Members) == 0 {
+		retire(key, set)
+	}
+	return popped, nil
+}
+
+// SetOp selects the multi-key set operation computed by SetCombine
+type SetOp int
+
+const (
+	SetOpInter SetOp = iota
+	SetOpUnion
+	SetOpDiff
+)
+
+// lockSets loads the sets stored at keys and read-locks each distinct set
+// once, always in sorted key order so that concurrent multi-key commands
+// cannot deadlock. Missing keys yield nil entries. The returned function
+// releases the locks.
+func lockSets(keys []string) ([]*Set, func(), error) {
+	sets := make([]*Set, len(keys))
+	for i, key := range keys {
+		set, err := loadSet(key, forRead)
+		if err != nil {
+			return nil, nil, err
+		}
+		sets[i] = set
+	}
+
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return keys[order[a]] < keys[order[b]] })
+
+	var locked []*Set
+	seen := make(map[*Set]bool)
+	for _, i := range order {
+		set := sets[i]
+		if set == nil || seen[set] {
+			continue
+		}
+		seen[set] = true
+		set.mutex.RLock()
+		locked = append(locked, set)
+	}
+
+	unlock := func() {
+		for _, set := range locked {
+			set.mutex.RUnlock()
+		}
+	}
+	return sets, unlock, nil
+}
+
+// SetCombine computes the intersection, union or difference (first set minus
+// the rest) of the sets at keys and returns the members in sorted order.
+// Missing keys count as empty sets.
+func SetCombine(op SetOp, keys []string) ([]string, error) {
+	sets, unlock, err := lockSets(keys)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	result := make(map[string]struct{})
+	switch op {
+	case SetOpInter:
+		if sets[0] != nil {
+			for member := range sets[0].Members {
+				result[member] = struct{}{}
+			}
+		}
+		for _, set := range sets[1:] {
+			for member := range result {
+				if set == nil {
+					delete(result, member)
+					continue
+				}
+				if _, ok := set.Members[member]; !ok {
+					delete(result, member)
+				}
+			}
+		}
+	case SetOpUnion:
+		for _, set := range sets {
+			if set == nil {
+				continue
+			}
+			for member := range set.Members {
+				result[member] = struct{}{}
+			}
+		}
+	case SetOpDiff:
+		if sets[0] != nil {
+			for member := range sets[0].Members {
+				result[member] = struct{}{}
+			}
+		}
+		for _, set := range sets[1:] {
+			if set == nil {
+				continue
+			}
+			for member := range set.Members {
+				delete(result, member)
+			}
+		}
+	}
+
+	members := make([]string, 0, len(result))
+	for member := range result {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+// SetStore replaces whatever is stored at key with a set of the given
+// members, or deletes the key when there are none. It returns the size of
+// the stored set.
+func SetStore(key string, members []string) int {
+	if len(members) == 0 {
+		DeleteKey(key)
+		return 0
+	}
+
+	set := &Set{Members: make(map[string]struct{}, len(members))}
+	for _, member := range members {
+		set.Members[member] = struct{}{}
+	}
+	touch(set)
+	DB.Store(key, set)
+	return len(set.Members)
+}
+
+// SetInterCard returns the cardinality of the intersection of the sets at
+// keys, stopping early once limit is reached (0 means no limit)
+func SetInterCard(keys []string, limit int) (int, error) {
+	sets, unlock, err := lockSets(keys)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	// Walk the smallest set and probe the others.
+	smallest := sets[0]
+	for _, set := range sets {
+		if set == nil {
+			return 0, nil
+		}
+		if len(set.Members) < len(smallest.Members) {
+			smallest = set
+		}
+	}
+
+	count := 0
+	for member := range smallest.Members {
+		inAll := true
+		for _, set := range sets {
+			if _, ok := set.Members[member]; !ok {
+				inAll = false
+				break
+			}
+		}
+		if !inAll {
+			continue
+		}
+		count++
+		if limit > 0 && count >= limit {
+			break
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,337 @@
+package database
+
+import (
+	"maps"
+	"slices"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Snapshots read the whole dataset while commands keep writing to it. Redis
+// forks for BGSAVE and lets the kernel copy pages as they are written; Go
+// cannot fork, so the copy is made here, explicitly and per value. A
+// snapshot first pins every value it is going to read. A write to a pinned
+// value clones it, stores the clone at the key in its place and edits the
+// clone, leaving the original to the snapshot, which therefore reads it
+// without taking any lock. Strings are never copied: a write stores a new
+// KeyValue rather than changing the one a snapshot may hold.
+//
+// Only values written while a snapshot is running are copied, and each at
+// most once per snapshot, since the clone is not pinned. The copies are
+// shallow wherever the data is immutable: element strings and stream entry
+// fields are shared, so a copy costs the headers, map entries and nodes
+// that hold them, which is what the COW metrics estimate.
+
+// Per-element costs of a copy, see cowSize
+const (
+	stringHeaderSize = int64(unsafe.Sizeof(""))
+	mapEntrySize     = 2 * stringHeaderSize // Key and value of a map[string]T, roughly
+	skiplistNodeSize = int64(unsafe.Sizeof(skiplistNode{})) + int64(unsafe.Sizeof(skiplistLevel{}))
+	streamEntrySize  = int64(unsafe.Sizeof(StreamEntry{}))
+	pendingEntrySize = int64(unsafe.Sizeof(PendingEntry{})) + 2*mapEntrySize
+)
+
+// COW counters. current covers the snapshots in progress and moves to last
+// when the last of them ends.
+var (
+	snapshotsRunning   atomic.Int32
+	snapshotsCompleted atomic.Int64
+	cowCurrentCopies   atomic.Int64
+	cowCurrentBytes    atomic.Int64
+	cowLastCopies      atomic.Int64
+	cowLastBytes       atomic.Int64
+)
+
+// copyable is a stored value that writes copy while a snapshot pins it
+type copyable[V any] interface {
+	comparable
+	valueLock() *keyMutex
+	clone() V
+	cowSize() int64 // Estimated bytes a clone allocates
+}
+
+func (l *List) valueLock() *keyMutex        { return &l.mutex }
+func (hash *Hash) valueLock() *keyMutex     { return &hash.mutex }
+func (set *Set) valueLock() *keyMutex       { return &set.mutex }
+func (zset *ZSet) valueLock() *keyMutex     { return &zset.mutex }
+func (stream *Stream) valueLock() *keyMutex { return &stream.mutex }
+
+// The clones below are made with the value's write lock held.
+
+func (l *List) clone() *List {
+	c := &List{ring: slices.Clone(l.ring), head: l.head, n: l.n}
+	c.expireAt.Store(l.expireAt.Load())
+	c.lru.Store(l.lru.Load())
+	return c
+}
+
+func (l *List) cowSize() int64 {
+	return int64(len(l.ring)) * stringHeaderSize
+}
+
+func (hash *Hash) clone() *Hash {
+	c := &Hash{Fields: maps.Clone(hash.Fields), Expires: maps.Clone(hash.Expires), peak: hash.peak}
+	c.expireAt.Store(hash.expireAt.Load())
+	c.lru.Store(hash.lru.Load())
+	return c
+}
+
+func (hash *Hash) cowSize() int64 {
+	return int64(len(hash.Fields)+len(hash.Expires)) * mapEntrySize
+}
+
+func (set *Set) clone() *Set {
+	c := &Set{Members: maps.Clone(set.Members), peak: set.peak}
+	c.expireAt.Store(set.expireAt.Load())
+	c.lru.Store(set.lru.Load())
+	return c
+}
+
+func (set *Set) cowSize() int64 {
+	return int64(len(set.Members)) * stringHeaderSize
+}
+
+// clone rebuilds the skiplist, whose nodes link to each other and so can't
+// be shared
+func (zset *ZSet) clone() *ZSet {
+	c := &ZSet{Scores: maps.Clone(zset.Scores), zsl: newSkiplist()}
+	c.expireAt.Store(zset.expireAt.Load())
+	c.lru.Store(zset.lru.Load())
+	for x := zset.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		c.zsl.insert(x.score, x.member)
+	}
+	return c
+}
+
+func (zset *ZSet) cowSize() int64 {
+	return int64(len(zset.Scores)) * (mapEntrySize + skiplistNodeSize)
+}
+
+// clone copies the entries slice, since XDEL compacts it in place, and the
+// consumer groups, whose pending entries are shared between a group and
+// its consumers
+func (stream *Stream) clone() *Stream {
+	c := &Stream{
+		Entries:    slices.Clone(stream.Entries),
+		LastID:     stream.LastID,
+		LastSeqNum: stream.LastSeqNum,
+		Bytes:      stream.Bytes,
+	}
+	c.lru.Store(stream.lru.Load())
+	if stream.Groups == nil {
+		return c
+	}
+	c.Groups = make(map[string]*ConsumerGroup, len(stream.Groups))
+	for name, g := range stream.Groups {
+		cg := &ConsumerGroup{
+			LastDeliveredID: g.LastDeliveredID,
+			Pending:         make(map[string]*PendingEntry, len(g.Pending)),
+			Consumers:       make(map[string]*Consumer, len(g.Consumers)),
+		}
+		for consumerName, consumer := range g.Consumers {
+			cg.Consumers[consumerName] = &Consumer{
+				Name:     consumer.Name,
+				SeenTime: consumer.SeenTime,
+				Pending:  make(map[string]*PendingEntry, len(consumer.Pending)),
+			}
+		}
+		for id, p := range g.Pending {
+			cp := *p
+			cp.Consumer = cg.Consumers[p.Consumer.Name]
+			cp.Consumer.Pending[id] = &cp
+			cg.Pending[id] = &cp
+		}
+		c.Groups[name] = cg
+	}
+	return c
+}
+
+func (stream *Stream) cowSize() int64 {
+	size := int64(len(stream.Entries)) * streamEntrySize
+	for _, g := range stream.Groups {
+		size += int64(len(g.Pending)) * pendingEntrySize
+	}
+	return size
+}
+
+// lockWrite write-locks the value load returns for key, on behalf of a
+// write. A value pinned by a snapshot is first replaced by a copy, which is
+// what gets locked and returned. It returns the zero V, without holding a
+// lock, when load finds nothing.
+func lockWrite[V copyable[V]](key string, load func() (V, error)) (V, error) {
+	var none V
+	for {
+		v, err := load()
+		if err != nil || v == none {
+			return none, err
+		}
+		m := v.valueLock()
+		m.Lock()
+		if !m.retired {
+			return copyOnWrite(key, v), nil
+		}
+		// Copied or deleted while we waited for the lock; load again.
+		m.Unlock()
+	}
+}
+
+// copyOnWrite returns v, write-locked by the caller, when no snapshot pins
+// it. Otherwise it stores a write-locked clone at key in place of v, retires
+// and unlocks v, and returns the clone.
+func copyOnWrite[V copyable[V]](key string, v V) V {
+	m := v.valueLock()
+	if m.pins.Load() == 0 {
+		return v
+	}
+
+	c := v.clone()
+	c.valueLock().Lock()
+	replaceValue(key, v, c)
+	m.retired = true
+	m.Unlock()
+
+	cowCurrentCopies.Add(1)
+	cowCurrentBytes.Add(v.cowSize())
+	return c
+}
+
+// retire removes v, write-locked by the caller and left empty, from key
+func retire[V copyable[V]](key string, v V) {
+	v.valueLock().retired = true
+	DB.CompareAndDelete(key, v)
+}
+
+// replaceValue stores clone at key if old is still the value there. A key
+// deleted or overwritten meanwhile is left alone, so the write goes to the
+// clone nobody can see, as it would have gone to old.
+func replaceValue(key string, old, clone interface{}) {
+	val, found := DB.Load(key)
+	if !found {
+		return
+	}
+	if streamData, ok := val.(StreamData); ok {
+		if streamData.Stream == old {
+			replaced := streamData
+			replaced.Stream = clone.(*Stream)
+			DB.CompareAndSwap(key, streamData, replaced)
+		}
+		return
+	}
+	DB.CompareAndSwap(key, old, clone)
+}
+
+// pinnedValue is a key and its value held by a snapshot
+type pinnedValue struct {
+	key   string
+	val   interface{}
+	mutex *keyMutex // nil for strings
+}
+
+// pin returns the current value at key, pinned, and false when the key is
+// gone. Retired values are skipped for the copy that replaced them.
+func pin(key string, val interface{}) (pinnedValue, bool) {
+	for {
+		m := valueMutex(val)
+		if m == nil {
+			return pinnedValue{key: key, val: val}, true
+		}
+		m.RLock()
+		if !m.retired {
+			m.pins.Add(1)
+			m.RUnlock()
+			return pinnedValue{key: key, val: val, mutex: m}, true
+		}
+		m.RUnlock()
+
+		var found bool
+		if val, found = DB.Load(key); !found {
+			return pinnedValue{}, false
+		}
+	}
+}
+
+// Snapshot calls fn with every key and its value, in no particular order,
+// until fn returns false. Every value is pinned, in one quick pass over the
+// keyspace, before the first call, so fn sees each value as it was when
+// pinned however long fn takes; writes meanwhile go to copies and never
+// wait on fn. Keys created after the pass are not seen. fn may read values
+// without locking them but must not modify them. Expired keys are passed
+// too, for fn to skip with the usual checks.
+func Snapshot(fn func(key string, val interface{}) bool) {
+	snapshot(nil, fn)
+}
+
+// snapshot is Snapshot calling onPinned, when not nil, once every value is
+// pinned. A caller that pauses writes until then gets the dataset as it was
+// at that point.
+func snapshot(onPinned func(), fn func(key string, val interface{}) bool) {
+	pinned := beginSnapshot()
+	if onPinned != nil {
+		onPinned()
+	}
+	defer endSnapshot(pinned)
+
+	for _, p := range pinned {
+		if !fn(p.key, p.val) {
+			return
+		}
+	}
+}
+
+// beginSnapshot starts a snapshot and pins every value for it, returning
+// them for endSnapshot to release
+func beginSnapshot() []pinnedValue {
+	if snapshotsRunning.Add(1) == 1 {
+		cowCurrentCopies.Store(0)
+		cowCurrentBytes.Store(0)
+	}
+
+	var pinned []pinnedValue
+	DB.Range(func(k, val interface{}) bool {
+		key, ok := k.(string)
+		if !ok {
+			return true
+		}
+		if p, ok := pin(key, val); ok {
+			pinned = append(pinned, p)
+		}
+		return true
+	})
+	return pinned
+}
+
+// endSnapshot unpins the values beginSnapshot pinned and ends the snapshot
+func endSnapshot(pinned []pinnedValue) {
+	for _, p := range pinned {
+		if p.mutex != nil {
+			p.mutex.pins.Add(-1)
+		}
+	}
+	snapshotsCompleted.Add(1)
+	if snapshotsRunning.Add(-1) == 0 {
+		cowLastCopies.Store(cowCurrentCopies.Swap(0))
+		cowLastBytes.Store(cowCurrentBytes.Swap(0))
+	}
+}
+
+// SnapshotStats reports snapshots and the copies writes made while they ran
+type SnapshotStats struct {
+	Running       int
+	Completed     int64
+	CurrentCopies int64 // Values copied since the running snapshots started
+	CurrentBytes  int64 // Estimated bytes those copies allocated
+	LastCopies    int64 // Values copied while the last snapshots ran
+	LastBytes     int64
+}
+
+// CollectSnapshotStats returns the snapshot and COW counters
+func CollectSnapshotStats() SnapshotStats {
+	return SnapshotStats{
+		Running:       int(snapshotsRunning.Load()),
+		Completed:     snapshotsCompleted.Load(),
+		CurrentCopies: cowCurrentCopies.Load(),
+		CurrentBytes:  cowCurrentBytes.Load(),
+		LastCopies:    cowLastCopies.Load(),
+		LastBytes:     cowLastBytes.Load(),
+	}
+}
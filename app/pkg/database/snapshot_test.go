@@ -0,0 +1,190 @@
+package database
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/internal/logging"
+)
+
+func TestMain(m *testing.M) {
+	logging.SetLevel(logging.LevelNone)
+	os.Exit(m.Run())
+}
+
+// checkSnapshotValue reports what is wrong with a value a snapshot passed,
+// given that the writers of TestSnapshotUnderLoad only ever add and remove
+// elements in pairs. It reads the value twice, so a write to it while the
+// snapshot holds it shows as a change, or as a race under -race.
+func checkSnapshotValue(val interface{}) string {
+	size := func() int {
+		switch v := val.(type) {
+		case *KeyValue:
+			return len(v.Val)
+		case *List:
+			for i := 0; i < v.Len(); i++ {
+				v.At(i)
+			}
+			return v.Len()
+		case *Hash:
+			return len(v.Fields)
+		case *Set:
+			return len(v.Members)
+		case *ZSet:
+			n := 0
+			for x := v.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+				n++
+			}
+			if n != len(v.Scores) {
+				return -1
+			}
+			return n
+		}
+		return -1
+	}
+	first := size()
+	runtime.Gosched()
+	if second := size(); first < 0 || first%2 != 0 || second != first {
+		return "holds " + strconv.Itoa(first) + " then " + strconv.Itoa(second) + " elements, want the same even count"
+	}
+	return ""
+}
+
+// TestSnapshotUnderLoad runs snapshots, the keyspace-wide reads and
+// compaction passes while writers fill, empty and delete values of every
+// type, and fails if a snapshot sees a value change under it, a write is
+// lost or they stop making progress
+func TestSnapshotUnderLoad(t *testing.T) {
+	Flush()
+	const keys = 8
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	run := func(fn func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+					fn(i)
+				}
+			}
+		}()
+	}
+
+	// Writers, each emptying its values as often as it fills them
+	run(func(i int) {
+		key := "string:" + strconv.Itoa(i%keys)
+		if i%3 == 2 {
+			DeleteKey(key)
+		} else {
+			StringAppend(key, "ab")
+		}
+	})
+	run(func(i int) {
+		key := "list:" + strconv.Itoa(i/2%keys)
+		if i%2 == 0 {
+			RPushAdd(key, "a", "b")
+		} else {
+			RPop(key, 2)
+		}
+	})
+	run(func(i int) {
+		key := "hash:" + strconv.Itoa(i/2%keys)
+		if i%2 == 0 {
+			HashSet(key, []string{"f1", "1", "f2", "2"})
+		} else {
+			HashDelete(key, []string{"f1", "f2"})
+		}
+	})
+	run(func(i int) {
+		key := "set:" + strconv.Itoa(i/2%keys)
+		if i%2 == 0 {
+			SetAdd(key, []string{"a", "b"})
+		} else {
+			SetRemove(key, []string{"a", "b"})
+		}
+	})
+	run(func(i int) {
+		key := "zset:" + strconv.Itoa(i/2%keys)
+		if i%2 == 0 {
+			ZSetAdd(key, []ZMember{{Member: "a", Score: 1}, {Member: "b", Score: 2}}, ZAddFlags{})
+		} else {
+			ZSetRemove(key, []string{"a", "b"})
+		}
+	})
+	// Two writers count in one hash, so an increment that went to a value
+	// the other had copied meanwhile shows in the total
+	HashSet("counter", []string{"n", "0", "pad", "0"})
+	var increments atomic.Int64
+	for n := 0; n < 2; n++ {
+		run(func(int) {
+			HashIncrBy("counter", "n", 1)
+			increments.Add(1)
+		})
+	}
+	run(func(i int) {
+		if i%5 == 0 {
+			DeleteKeys("list:"+strconv.Itoa(i%keys), "hash:"+strconv.Itoa(i%keys), "set:"+strconv.Itoa(i%keys))
+		}
+	})
+
+	// Readers
+	var snapshots, reads atomic.Int64
+	for n := 0; n < 2; n++ {
+		run(func(int) {
+			Snapshot(func(key string, val interface{}) bool {
+				if problem := checkSnapshotValue(val); problem != "" {
+					t.Errorf("a snapshot saw %s, which %s", key, problem)
+					return false
+				}
+				return true
+			})
+			snapshots.Add(1)
+		})
+	}
+	run(func(i int) {
+		Keys()
+		var cursor uint64
+		for {
+			if _, cursor = ScanKeys(cursor, 10); cursor == 0 {
+				break
+			}
+		}
+		for _, prefix := range []string{"string:", "list:", "hash:", "set:", "zset:"} {
+			KeyType(prefix + strconv.Itoa(i%keys))
+		}
+		reads.Add(1)
+	})
+	run(func(int) { Defrag() })
+
+	time.Sleep(300 * time.Millisecond)
+	close(stop)
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the goroutines did not stop within 10s of being told to: deadlock")
+	}
+
+	if n, _, _ := HashGet("counter", "n"); n != strconv.FormatInt(increments.Load(), 10) {
+		t.Errorf("counter holds %s after %d increments", n, increments.Load())
+	}
+	if snapshots.Load() == 0 || reads.Load() == 0 {
+		t.Errorf("%d snapshots and %d KEYS/SCAN/TYPE rounds completed under load, want some of each", snapshots.Load(), reads.Load())
+	}
+	if stats := CollectSnapshotStats(); stats.Running != 0 {
+		t.Errorf("%d snapshots still counted as running", stats.Running)
+	}
+}
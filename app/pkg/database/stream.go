@@ -1,79 +1,144 @@
 package database
 
 import (
+	"cmp"
+	"errors"
 	"fmt"
+	"math"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 )
 
-func GetOrCreateStream(key string) *Stream {
+// StreamLimits bounds the size of single entries and of a whole stream so one
+// XADD cannot balloon memory. Zero means unlimited.
+type StreamLimits struct {
+	MaxEntryFields int // Field/value pairs per entry
+	MaxEntrySize   int // Bytes of field names and values per entry
+	MaxStreamBytes int // Bytes of field names and values across the stream
+}
 
-	val, exists := DB.Load(key)
+var (
+	ErrStreamTooManyFields = errors.New("ERR stream entry has too many fields (stream-max-entry-fields)")
+	ErrStreamEntryTooLarge = errors.New("ERR stream entry is too large (stream-max-entry-size)")
+	ErrStreamFull          = errors.New("ERR stream has reached its memory limit (stream-max-memory)")
+)
 
-	if !exists {
-		stream := &Stream{
-			Entries:    make([]StreamEntry, 0),
-			LastID:     "0-0",
-			LastSeqNum: 0,
-		}
+// entrySize returns the number of bytes an entry's fields and values occupy
+func entrySize(fields []string) int {
+	size := 0
+	for _, f := range fields {
+		size += len(f)
+	}
+	return size
+}
 
-		streamData := StreamData{
-			Stream: stream,
-			Px:     -1,
-			T:      time.Now(),
+// Value returns the value of field in the entry, the first one when the
+// field was given more than once, and whether it is there
+func (entry StreamEntry) Value(field string) (string, bool) {
+	for i := 0; i+1 < len(entry.Fields); i += 2 {
+		if entry.Fields[i] == field {
+			return entry.Fields[i+1], true
 		}
-		DB.Store(key, streamData)
-		return stream
-	}
-	streamData, ok := val.(StreamData)
-	if !ok {
-		return nil
-	}
-	if streamData.Px != -1 && time.Now().After(streamData.T.Add(time.Duration(streamData.Px)*time.Millisecond)) {
-		stream := &Stream{
-			Entries:    make([]StreamEntry, 0),
-			LastID:     "0-0",
-			LastSeqNum: 0,
+	}
+	return "", false
+}
+
+// NewStream creates an empty stream that is not attached to any key
+func NewStream() *Stream {
+	return &Stream{
+		Entries:    make([]StreamEntry, 0),
+		LastID:     "0-0",
+		LastSeqNum: 0,
+	}
+}
+
+// loadOrCreateStream returns the stream at key for a write, storing a new
+// empty one when the key is missing. The new stream only goes in if the key
+// is still missing, so concurrent writers agree on one stream; the losers
+// load again. The caller must lock the stream.
+func loadOrCreateStream(key string) (*Stream, error) {
+	for {
+		val, found := DB.Load(key)
+		if found {
+			streamData, ok := val.(StreamData)
+			if !ok {
+				return nil, ErrWrongType
+			}
+			touch(streamData)
+			return streamData.Stream, nil
 		}
-		newStreamData := StreamData{
-			Stream: stream,
-			Px:     -1,
-			T:      time.Now(),
+
+		fresh := StreamData{Stream: NewStream(), Px: -1, T: time.Now()}
+		touch(fresh)
+		if _, loaded := DB.LoadOrStore(key, fresh); loaded {
+			continue
 		}
-		DB.Store(key, newStreamData)
-		return stream
+		return fresh.Stream, nil
 	}
-	return streamData.Stream
-
 }
 
-func StreamAdd(key, id string, fields []string) (string, error) {
+// StreamAdd appends an entry to the stream at key and returns its ID. When
+// the key is missing the stream is created, unless mkstream is false: then
+// nothing is added and the ID is empty.
+func StreamAdd(key, id string, fields []string, limits StreamLimits, mkstream bool) (string, error) {
 
 	if len(fields)%2 != 0 {
 		return "", fmt.Errorf("ERR wrong number of arguments for XADD")
 	}
-	stream := GetOrCreateStream(key)
-	if stream == nil {
-		return "", fmt.Errorf("ERR WRONGTYPE Operation against a key holding the wrong kind of value")
+	if limits.MaxEntryFields > 0 && len(fields)/2 > limits.MaxEntryFields {
+		return "", ErrStreamTooManyFields
 	}
+	if limits.MaxEntrySize > 0 && entrySize(fields) > limits.MaxEntrySize {
+		return "", ErrStreamEntryTooLarge
+	}
+	stream, err := lockWrite(key, func() (*Stream, error) {
+		if !mkstream {
+			return loadStream(key, forWrite)
+		}
+		return loadOrCreateStream(key)
+	})
+	if err != nil || stream == nil {
+		return "", err
+	}
+	assigned, err := stream.add(id, fields, limits.MaxStreamBytes)
+	stream.mutex.Unlock()
+	if err == nil {
+		SignalKeyReady(key)
+	}
+	return assigned, err
+}
+
+// Append adds an entry built from field/value pairs using the requested ID
+// ("*", "<ms>-*" or an explicit ID) and returns the ID actually assigned
+func (stream *Stream) Append(id string, fields []string) (string, error) {
 	stream.mutex.Lock()
 	defer stream.mutex.Unlock()
+	return stream.add(id, fields, 0)
+}
+
+// add is Append with an optional cap (maxBytes > 0) on the total size of
+// the stream. The caller holds the stream lock.
+func (stream *Stream) add(id string, fields []string, maxBytes int) (string, error) {
+	size := entrySize(fields)
+	if maxBytes > 0 && stream.Bytes+size > maxBytes {
+		return "", ErrStreamFull
+	}
 	entryID, err := generateStreamID(stream, id)
 	if err != nil {
 		return "", err
 	}
-	fieldMap := make(map[string]string)
-	for i := 0; i < len(fields); i += 2 {
-		fieldMap[fields[i]] = fields[i+1]
-	}
-
+	key, _ := parseStreamID(entryID)
 	entry := StreamEntry{
 		ID:     entryID,
-		Fields: fieldMap,
+		Fields: slices.Clone(fields),
 		Time:   time.Now(),
+		key:    key,
 	}
 	stream.Entries = append(stream.Entries, entry)
+	stream.Bytes += size
 	stream.LastID = entryID
 	parts := strings.Split(entryID, "-")
 	if len(parts) == 2 {
@@ -83,21 +148,152 @@ func StreamAdd(key, id string, fields []string) (string, error) {
 
 }
 
+// ErrInvalidStreamID is returned for an argument that is not a stream ID
+var ErrInvalidStreamID = errors.New("ERR Invalid stream ID specified as stream command argument")
+
+// NormalizeStreamID validates an explicit ID, "<ms>-<seq>" or "<ms>" for a
+// sequence number of 0, and returns it in the "<ms>-<seq>" form
+func NormalizeStreamID(id string) (string, error) {
+	ms, seq, found := strings.Cut(id, "-")
+	if !found {
+		seq = "0"
+	}
+	if _, err := strconv.ParseUint(ms, 10, 64); err != nil {
+		return "", ErrInvalidStreamID
+	}
+	if _, err := strconv.ParseUint(seq, 10, 64); err != nil {
+		return "", ErrInvalidStreamID
+	}
+	return ms + "-" + seq, nil
+}
+
+// StreamBound is one end of a stream ID range: "-" or "+" for no bound, an
+// ID, or an ID prefixed with "(" to leave it out of the range
+type StreamBound struct {
+	ID        string // Normalized; empty when unbounded
+	Exclusive bool
+}
+
+// ParseStreamBound parses the start (end false) or end of a range. A start
+// given as "<ms>" begins at "<ms>-0" and an end given as "<ms>" stops at
+// the last sequence number of that millisecond.
+func ParseStreamBound(s string, end bool) (StreamBound, error) {
+	if s == "-" || s == "+" {
+		return StreamBound{}, nil
+	}
+	var b StreamBound
+	if strings.HasPrefix(s, "(") {
+		b.Exclusive = true
+		s = s[1:]
+	}
+	if end && !strings.Contains(s, "-") {
+		s += "-" + strconv.FormatInt(math.MaxInt64, 10)
+	}
+	id, err := NormalizeStreamID(s)
+	if err != nil {
+		return StreamBound{}, err
+	}
+	b.ID = id
+	return b, nil
+}
+
+// admitsAfter reports whether id is not before the bound used as a start
+func (b StreamBound) admitsAfter(id string) bool {
+	if b.ID == "" {
+		return true
+	}
+	c := compareStreamIDs(id, b.ID)
+	return c > 0 || (c == 0 && !b.Exclusive)
+}
+
+// admitsBefore reports whether id is not past the bound used as an end
+func (b StreamBound) admitsBefore(id string) bool {
+	if b.ID == "" {
+		return true
+	}
+	c := compareStreamIDs(id, b.ID)
+	return c < 0 || (c == 0 && !b.Exclusive)
+}
+
+// StreamDelete removes the entries with the given IDs, which must be
+// normalized, from the stream at key and returns how many existed. Entries
+// are kept in ID order, so each is found by binary search and the rest are
+// compacted in a single pass. The stream's last ID is kept, so new entries
+// still get IDs above every deleted one, and an emptied stream stays.
+func StreamDelete(key string, ids []string) (int, error) {
+	stream, err := lockStream(key)
+	if err != nil || stream == nil {
+		return 0, err
+	}
+	defer stream.mutex.Unlock()
+
+	doomed := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		i := stream.search(id, false)
+		if i < len(stream.Entries) && stream.Entries[i].ID == id {
+			doomed[i] = true
+		}
+	}
+	if len(doomed) == 0 {
+		return 0, nil
+	}
+
+	kept := stream.Entries[:0]
+	for i, entry := range stream.Entries {
+		if !doomed[i] {
+			kept = append(kept, entry)
+			continue
+		}
+		stream.Bytes -= entrySize(entry.Fields)
+	}
+	// Drop the references left in the tail of the backing array
+	clear(stream.Entries[len(kept):])
+	stream.Entries = kept
+	return len(doomed), nil
+}
+
+// TrimToLen drops the oldest entries so that at most maxLen remain
+func (stream *Stream) TrimToLen(maxLen int) {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	if maxLen < 0 || len(stream.Entries) <= maxLen {
+		return
+	}
+	for _, entry := range stream.Entries[:len(stream.Entries)-maxLen] {
+		stream.Bytes -= entrySize(entry.Fields)
+	}
+	trimmed := make([]StreamEntry, maxLen)
+	copy(trimmed, stream.Entries[len(stream.Entries)-maxLen:])
+	stream.Entries = trimmed
+}
+
+// Tail returns a copy of the newest n entries in ID order
+func (stream *Stream) Tail(n int) []StreamEntry {
+	stream.mutex.RLock()
+	defer stream.mutex.RUnlock()
+
+	if n > len(stream.Entries) {
+		n = len(stream.Entries)
+	}
+	result := make([]StreamEntry, n)
+	copy(result, stream.Entries[len(stream.Entries)-n:])
+	return result
+}
+
 func generateStreamID(stream *Stream, requestedID string) (string, error) {
-	fmt.Printf("stream %+v, requestedID %+v, ", stream, requestedID)
 	now := time.Now()
 	currentMs := now.UnixMilli()
 
 	if requestedID == "*" {
-		// Auto-generate full ID
-		if stream.LastSeqNum == 0 {
+		// Auto-generate full ID. The last ID, not the entries, decides, so
+		// IDs of deleted entries are never reused.
+		parts := strings.Split(stream.LastID, "-")
+		if len(parts) != 2 {
 			return fmt.Sprintf("%d-0", currentMs), nil
 		}
-
-		parts := strings.Split(stream.LastID, "-")
 		lastMs, _ := strconv.ParseInt(parts[0], 10, 64)
 		lastSeq, _ := strconv.ParseInt(parts[1], 10, 64)
-		fmt.Printf("lastMS %+v, lastSeq %+v, ", lastMs, lastSeq)
 
 		if currentMs > lastMs {
 			return fmt.Sprintf("%d-0", currentMs), nil
@@ -177,80 +373,100 @@ func isValidStreamID(stream *Stream, id string) (bool, error) {
 
 	return true, nil
 }
-func compareStreamIDs(id1, id2 string) int {
-	parts1 := strings.Split(id1, "-")
-	parts2 := strings.Split(id2, "-")
 
-	if len(parts1) != 2 || len(parts2) != 2 {
-		return 0
-	}
+// streamID is a stream ID as numbers, which compare without parsing
+type streamID struct {
+	ms, seq uint64
+}
 
-	ms1, _ := strconv.ParseInt(parts1[0], 10, 64)
-	seq1, _ := strconv.ParseInt(parts1[1], 10, 64)
-	ms2, _ := strconv.ParseInt(parts2[0], 10, 64)
-	seq2, _ := strconv.ParseInt(parts2[1], 10, 64)
+// parseStreamID parses a "<ms>-<seq>" ID; ok is false when it isn't one
+func parseStreamID(id string) (parsed streamID, ok bool) {
+	ms, seq, found := strings.Cut(id, "-")
+	if !found {
+		return streamID{}, false
+	}
+	var err1, err2 error
+	parsed.ms, err1 = strconv.ParseUint(ms, 10, 64)
+	parsed.seq, err2 = strconv.ParseUint(seq, 10, 64)
+	return parsed, err1 == nil && err2 == nil
+}
 
-	// Compare milliseconds first
-	if ms1 != ms2 {
-		if ms1 > ms2 {
-			return 1
-		}
-		return -1
+// compare returns -1, 0 or 1 as a is before, equal to or after b
+func (a streamID) compare(b streamID) int {
+	if a.ms != b.ms {
+		return cmp.Compare(a.ms, b.ms)
 	}
+	return cmp.Compare(a.seq, b.seq)
+}
 
-	// If milliseconds are equal, compare sequence numbers
-	if seq1 > seq2 {
-		return 1
-	} else if seq1 < seq2 {
-		return -1
+// compareStreamIDs orders two "<ms>-<seq>" IDs, treating malformed ones as
+// equal to anything
+func compareStreamIDs(id1, id2 string) int {
+	a, ok1 := parseStreamID(id1)
+	b, ok2 := parseStreamID(id2)
+	if !ok1 || !ok2 {
+		return 0
 	}
-	return 0
+	return a.compare(b)
 }
 
-func StreamRange(key, start, end string) ([]StreamEntry, error) {
+// search returns the index of the first entry whose ID is after id when
+// after is set, or not before it otherwise; len(Entries) when there is none.
+// id must be normalized. The caller holds the stream's lock.
+func (stream *Stream) search(id string, after bool) int {
+	target, _ := parseStreamID(id)
+	return sort.Search(len(stream.Entries), func(i int) bool {
+		c := stream.Entries[i].key.compare(target)
+		return c > 0 || (c == 0 && !after)
+	})
+}
 
-	val, exists := DB.Load(key)
-	if !exists {
-		return []StreamEntry{}, nil
+// bounds returns the half-open span of Entries between start and end
+func (stream *Stream) bounds(start, end StreamBound) (lo, hi int) {
+	lo, hi = 0, len(stream.Entries)
+	if start.ID != "" {
+		lo = stream.search(start.ID, start.Exclusive)
 	}
-	streamData, ok := val.(StreamData)
-	if !ok {
-		return nil, fmt.Errorf("ERR WRONGTYPE Operation against a key holding the wrong kind of value")
+	if end.ID != "" {
+		hi = stream.search(end.ID, !end.Exclusive)
 	}
-	if streamData.Px != -1 && time.Now().After(streamData.T.Add(time.Millisecond*time.Duration(streamData.Px))) {
-		return []StreamEntry{}, nil
+	return lo, max(lo, hi)
+}
+
+// StreamRange returns up to count (negative for all) entries of the stream
+// at key between start and end, in ID order or in reverse when reverse is
+// set, where the scan starts from end
+func StreamRange(key string, start, end StreamBound, count int, reverse bool) ([]StreamEntry, error) {
+	stream, err := loadStream(key, forRead)
+	if err != nil || stream == nil {
+		return []StreamEntry{}, err
 	}
-	stream := streamData.Stream
+
 	stream.mutex.RLock()
 	defer stream.mutex.RUnlock()
-	var result []StreamEntry
-	for _, entry := range stream.Entries {
-		if (start == "-" || compareStreamIDs(entry.ID, start) >= 0) &&
-			(end == "+" || compareStreamIDs(entry.ID, end) <= 0) {
-			result = append(result, entry)
+
+	lo, hi := stream.bounds(start, end)
+	if count >= 0 && hi-lo > count {
+		if reverse {
+			lo = hi - count
+		} else {
+			hi = lo + count
 		}
 	}
+	result := make([]StreamEntry, hi-lo)
+	copy(result, stream.Entries[lo:hi])
+	if reverse {
+		slices.Reverse(result)
+	}
 	return result, nil
-
 }
 
 func StreamReadFrom(key, startID string) ([]StreamEntry, error) {
-	val, exists := DB.Load(key)
-	if !exists {
-		return []StreamEntry{}, nil
+	stream, err := loadStream(key, forRead)
+	if err != nil || stream == nil {
+		return []StreamEntry{}, err
 	}
 
-	streamData, ok := val.(StreamData)
-	if !ok {
-		return nil, fmt.Errorf("ERR WRONGTYPE Operation against a key holding the wrong kind of value")
-	}
-
-	// Check expiration
-	if streamData.Px != -1 && time.Now().After(streamData.T.Add(time.Millisecond*time.Duration(streamData.Px))) {
-		return []StreamEntry{}, nil
-	}
-
-	stream := streamData.Stream
 	stream.mutex.RLock()
 	defer stream.mutex.RUnlock()
 
@@ -262,11 +478,10 @@ func StreamReadFrom(key, startID string) ([]StreamEntry, error) {
 		return []StreamEntry{}, nil
 	}
 
-	for _, entry := range stream.Entries {
-		// For XREAD, we want entries AFTER the specified ID
-		if compareStreamIDs(entry.ID, startID) > 0 {
-			result = append(result, entry)
-		}
+	// For XREAD, we want entries AFTER the specified ID
+	if after := stream.search(startID, true); after < len(stream.Entries) {
+		result = make([]StreamEntry, len(stream.Entries)-after)
+		copy(result, stream.Entries[after:])
 	}
 
 	return result, nil
@@ -297,20 +512,12 @@ func StreamReadMultiple(streamKeys []string, startIDs []string) (map[string][]St
 
 // GetStreamLastID returns the last ID of a stream, or "0-0" if stream doesn't exist
 func GetStreamLastID(key string) string {
-	val, exists := DB.Load(key)
-	if !exists {
+	stream, err := loadStream(key, forRead)
+	if err != nil || stream == nil {
 		return "0-0"
 	}
 
-	streamData, ok := val.(StreamData)
-	if !ok {
-		return "0-0"
-	}
-
-	// Check expiration
-	if streamData.Px != -1 && time.Now().After(streamData.T.Add(time.Millisecond*time.Duration(streamData.Px))) {
-		return "0-0"
-	}
-
-	return streamData.Stream.LastID
+	stream.mutex.RLock()
+	defer stream.mutex.RUnlock()
+	return stream.LastID
 }
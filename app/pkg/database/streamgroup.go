@@ -0,0 +1,690 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// Consumer groups let several clients share the work of reading a stream.
+// A group remembers the last entry it delivered, so each new entry goes to
+// only one of its consumers, and keeps a pending entries list (PEL) of what
+// was delivered but not acknowledged yet, indexed both for the group and per
+// consumer.
+
+// ConsumerGroup is a named reader of a stream
+type ConsumerGroup struct {
+	LastDeliveredID string
+	Pending         map[string]*PendingEntry // Delivered, unacknowledged entries by ID
+	Consumers       map[string]*Consumer
+}
+
+// Consumer is a member of a consumer group
+type Consumer struct {
+	Name     string
+	SeenTime time.Time                // Last time it read through the group
+	Pending  map[string]*PendingEntry // Its share of the group's PEL
+}
+
+// PendingEntry is an entry delivered to a consumer and not acknowledged
+type PendingEntry struct {
+	ID            string
+	Consumer      *Consumer
+	DeliveryTime  time.Time
+	DeliveryCount int
+}
+
+var (
+	// ErrBusyGroup is returned when creating a group that already exists
+	ErrBusyGroup = errors.New("BUSYGROUP Consumer Group name already exists")
+	// ErrGroupKeyRequired is returned by XGROUP subcommands on a missing key
+	ErrGroupKeyRequired = errors.New("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+	// ErrNoGroup is returned when the group (or, for reads, the key) does
+	// not exist. The reply names both, so callers build it with the key
+	// and group at hand.
+	ErrNoGroup = errors.New("NOGROUP")
+)
+
+// loadStream returns the stream at key, nil when missing, or expired and
+// loaded for a read
+func loadStream(key string, mode access) (*Stream, error) {
+	val, exists := DB.Load(key)
+	if !exists || !mode.live(val) {
+		return nil, nil
+	}
+	streamData, ok := val.(StreamData)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	touch(streamData)
+	return streamData.Stream, nil
+}
+
+// lockStream loads the stream at key like loadStream and write-locks it,
+// see lockWrite. It returns nil, without holding a lock, when there is no
+// stream.
+func lockStream(key string) (*Stream, error) {
+	return lockWrite(key, func() (*Stream, error) {
+		return loadStream(key, forWrite)
+	})
+}
+
+// groupStream returns the stream at key for a consumer group command, or
+// missing when there is none; the caller must lock the stream
+func groupStream(key string, missing error) (*Stream, error) {
+	stream, err := loadStream(key, forRead)
+	if err == nil && stream == nil {
+		err = missing
+	}
+	return stream, err
+}
+
+// lockGroupStream is groupStream for a write: the stream is returned
+// write-locked, see lockWrite
+func lockGroupStream(key string, missing error) (*Stream, error) {
+	stream, err := lockStream(key)
+	if err == nil && stream == nil {
+		err = missing
+	}
+	return stream, err
+}
+
+// resolveGroupID turns "$" into the stream's last ID; other IDs must have
+// been normalized. The caller holds the stream lock.
+func (stream *Stream) resolveGroupID(id string) string {
+	if id == "$" {
+		return stream.LastID
+	}
+	return id
+}
+
+// GroupCreate creates a consumer group that will deliver the entries after
+// id, "$" for only new ones, and returns the ID it starts from. mkstream
+// creates an empty stream when the key is missing.
+func GroupCreate(key, group, id string, mkstream bool) (string, error) {
+	stream, err := lockWrite(key, func() (*Stream, error) {
+		stream, err := loadStream(key, forWrite)
+		if err != nil || stream != nil || !mkstream {
+			return stream, err
+		}
+		return loadOrCreateStream(key)
+	})
+	if err != nil {
+		return "", err
+	}
+	if stream == nil {
+		return "", ErrGroupKeyRequired
+	}
+	defer stream.mutex.Unlock()
+
+	if _, exists := stream.Groups[group]; exists {
+		return "", ErrBusyGroup
+	}
+	if stream.Groups == nil {
+		stream.Groups = make(map[string]*ConsumerGroup)
+	}
+	start := stream.resolveGroupID(id)
+	stream.Groups[group] = &ConsumerGroup{
+		LastDeliveredID: start,
+		Pending:         make(map[string]*PendingEntry),
+		Consumers:       make(map[string]*Consumer),
+	}
+	return start, nil
+}
+
+// GroupDestroy deletes a consumer group and reports whether it existed
+func GroupDestroy(key, group string) (bool, error) {
+	stream, err := lockGroupStream(key, ErrGroupKeyRequired)
+	if err != nil {
+		return false, err
+	}
+	defer stream.mutex.Unlock()
+
+	if _, exists := stream.Groups[group]; !exists {
+		return false, nil
+	}
+	delete(stream.Groups, group)
+	return true, nil
+}
+
+// GroupSetID makes a group deliver the entries after id next, "$" for only
+// new ones, and returns the ID it was set to
+func GroupSetID(key, group, id string) (string, error) {
+	stream, err := lockGroupStream(key, ErrGroupKeyRequired)
+	if err != nil {
+		return "", err
+	}
+	defer stream.mutex.Unlock()
+
+	g, exists := stream.Groups[group]
+	if !exists {
+		return "", ErrNoGroup
+	}
+	g.LastDeliveredID = stream.resolveGroupID(id)
+	return g.LastDeliveredID, nil
+}
+
+// consumer returns the named consumer of the group, creating it if needed,
+// and whether it was created
+func (g *ConsumerGroup) consumer(name string) (*Consumer, bool) {
+	if c, exists := g.Consumers[name]; exists {
+		return c, false
+	}
+	c := &Consumer{Name: name, SeenTime: time.Now(), Pending: make(map[string]*PendingEntry)}
+	g.Consumers[name] = c
+	return c, true
+}
+
+// GroupCreateConsumer adds a consumer to a group and reports whether it was
+// new
+func GroupCreateConsumer(key, group, consumer string) (bool, error) {
+	stream, err := lockGroupStream(key, ErrGroupKeyRequired)
+	if err != nil {
+		return false, err
+	}
+	defer stream.mutex.Unlock()
+
+	g, exists := stream.Groups[group]
+	if !exists {
+		return false, ErrNoGroup
+	}
+	_, created := g.consumer(consumer)
+	return created, nil
+}
+
+// GroupDelConsumer removes a consumer and its pending entries from a group
+// and returns how many entries it had pending
+func GroupDelConsumer(key, group, consumer string) (int, error) {
+	stream, err := lockGroupStream(key, ErrGroupKeyRequired)
+	if err != nil {
+		return 0, err
+	}
+	defer stream.mutex.Unlock()
+
+	g, exists := stream.Groups[group]
+	if !exists {
+		return 0, ErrNoGroup
+	}
+	c, exists := g.Consumers[consumer]
+	if !exists {
+		return 0, nil
+	}
+	for id := range c.Pending {
+		delete(g.Pending, id)
+	}
+	delete(g.Consumers, consumer)
+	return len(c.Pending), nil
+}
+
+// GroupReadNew delivers to consumer up to count (0 for all) entries the group
+// has not delivered yet, the ">" ID of XREADGROUP. Unless noack is set they
+// are added to the PEL. created reports whether the consumer was new.
+func GroupReadNew(key, group, consumer string, count int, noack bool) (entries []StreamEntry, created bool, err error) {
+	stream, err := lockGroupStream(key, ErrNoGroup)
+	if err != nil {
+		return nil, false, err
+	}
+	defer stream.mutex.Unlock()
+
+	g, exists := stream.Groups[group]
+	if !exists {
+		return nil, false, ErrNoGroup
+	}
+	c, created := g.consumer(consumer)
+	now := time.Now()
+	c.SeenTime = now
+
+	start := stream.search(g.LastDeliveredID, true)
+	end := len(stream.Entries)
+	if count > 0 && start+count < end {
+		end = start + count
+	}
+	if start == end {
+		return nil, created, nil
+	}
+
+	entries = make([]StreamEntry, end-start)
+	copy(entries, stream.Entries[start:end])
+	g.LastDeliveredID = entries[len(entries)-1].ID
+
+	if noack {
+		return entries, created, nil
+	}
+	for _, entry := range entries {
+		// After SETID moved the group back an entry may be pending
+		// already; it changes hands and counts as delivered again
+		pending, exists := g.Pending[entry.ID]
+		if exists {
+			delete(pending.Consumer.Pending, entry.ID)
+		} else {
+			pending = &PendingEntry{ID: entry.ID}
+			g.Pending[entry.ID] = pending
+		}
+		pending.Consumer = c
+		pending.DeliveryTime = now
+		pending.DeliveryCount++
+		c.Pending[entry.ID] = pending
+	}
+	return entries, created, nil
+}
+
+// GroupReadPending returns up to count (0 for all) of the entries pending
+// for consumer with IDs after afterID, which must be normalized: the history
+// a consumer reads back with an explicit XREADGROUP ID, for instance after a
+// restart. Entries deleted from the stream since are returned with nil
+// Fields. It changes nothing, not even the consumer's seen time, so like
+// other reads it needs no replication.
+func GroupReadPending(key, group, consumer, afterID string, count int) ([]StreamEntry, error) {
+	stream, err := groupStream(key, ErrNoGroup)
+	if err != nil {
+		return nil, err
+	}
+
+	stream.mutex.RLock()
+	defer stream.mutex.RUnlock()
+
+	g, exists := stream.Groups[group]
+	if !exists {
+		return nil, ErrNoGroup
+	}
+	c, exists := g.Consumers[consumer]
+	if !exists {
+		return nil, nil
+	}
+
+	ids := make([]string, 0, len(c.Pending))
+	for id := range c.Pending {
+		if compareStreamIDs(id, afterID) > 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return compareStreamIDs(ids[i], ids[j]) < 0 })
+	if count > 0 && len(ids) > count {
+		ids = ids[:count]
+	}
+
+	entries := make([]StreamEntry, len(ids))
+	for i, id := range ids {
+		entries[i], _ = stream.entry(id)
+	}
+	return entries, nil
+}
+
+// entry returns the entry with the given normalized ID and whether it is
+// still in the stream; a deleted entry comes back with just its ID. The
+// caller holds the stream lock.
+func (stream *Stream) entry(id string) (StreamEntry, bool) {
+	i := stream.search(id, false)
+	if i < len(stream.Entries) && stream.Entries[i].ID == id {
+		return stream.Entries[i], true
+	}
+	return StreamEntry{ID: id}, false
+}
+
+// GroupAck removes the given IDs, which must be normalized, from a group's
+// PEL and returns how many were pending. A missing key or group has
+// nothing pending.
+func GroupAck(key, group string, ids []string) (int, error) {
+	stream, err := lockStream(key)
+	if err != nil || stream == nil {
+		return 0, err
+	}
+	defer stream.mutex.Unlock()
+
+	g, exists := stream.Groups[group]
+	if !exists {
+		return 0, nil
+	}
+	acked := 0
+	for _, id := range ids {
+		pending, exists := g.Pending[id]
+		if !exists {
+			continue
+		}
+		delete(pending.Consumer.Pending, id)
+		delete(g.Pending, id)
+		acked++
+	}
+	return acked, nil
+}
+
+// PendingSummary is the short form of XPENDING
+type PendingSummary struct {
+	Count          int
+	First, Last    string         // Smallest and greatest pending IDs
+	ConsumerCounts map[string]int // Pending entries per consumer that has any
+}
+
+// PendingInfo is one entry of the extended form of XPENDING
+type PendingInfo struct {
+	ID            string
+	Consumer      string
+	Idle          time.Duration
+	DeliveryCount int
+}
+
+// GroupPendingSummary returns how much a group has pending and with whom
+func GroupPendingSummary(key, group string) (PendingSummary, error) {
+	stream, err := groupStream(key, ErrNoGroup)
+	if err != nil {
+		return PendingSummary{}, err
+	}
+
+	stream.mutex.RLock()
+	defer stream.mutex.RUnlock()
+
+	g, exists := stream.Groups[group]
+	if !exists {
+		return PendingSummary{}, ErrNoGroup
+	}
+	summary := PendingSummary{Count: len(g.Pending), ConsumerCounts: make(map[string]int)}
+	for id, pending := range g.Pending {
+		if summary.First == "" || compareStreamIDs(id, summary.First) < 0 {
+			summary.First = id
+		}
+		if summary.Last == "" || compareStreamIDs(id, summary.Last) > 0 {
+			summary.Last = id
+		}
+		summary.ConsumerCounts[pending.Consumer.Name]++
+	}
+	return summary, nil
+}
+
+// GroupPendingRange returns up to count pending entries of a group between
+// start and end in ID order, only those of consumer when it is not empty and
+// only those idle for at least minIdle
+func GroupPendingRange(key, group string, start, end StreamBound, count int, consumer string, minIdle time.Duration) ([]PendingInfo, error) {
+	stream, err := groupStream(key, ErrNoGroup)
+	if err != nil {
+		return nil, err
+	}
+
+	stream.mutex.RLock()
+	defer stream.mutex.RUnlock()
+
+	g, exists := stream.Groups[group]
+	if !exists {
+		return nil, ErrNoGroup
+	}
+	pel := g.Pending
+	if consumer != "" {
+		c, exists := g.Consumers[consumer]
+		if !exists {
+			return nil, nil
+		}
+		pel = c.Pending
+	}
+
+	now := time.Now()
+	var result []PendingInfo
+	for id, pending := range pel {
+		idle := now.Sub(pending.DeliveryTime)
+		if !start.admitsAfter(id) || !end.admitsBefore(id) || idle < minIdle {
+			continue
+		}
+		result = append(result, PendingInfo{
+			ID:            id,
+			Consumer:      pending.Consumer.Name,
+			Idle:          idle,
+			DeliveryCount: pending.DeliveryCount,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return compareStreamIDs(result[i].ID, result[j].ID) < 0 })
+	if len(result) > count {
+		result = result[:count]
+	}
+	return result, nil
+}
+
+// ClaimOptions are the options of XCLAIM that change how entries are claimed
+type ClaimOptions struct {
+	MinIdle      time.Duration // Only entries idle at least this long change hands
+	DeliveryTime time.Time     // What claimed entries count as delivered at; zero for now
+	RetryCount   int           // Delivery count to set, -1 to count this delivery
+	Force        bool          // Claim entries that are in the stream but not pending
+	JustID       bool          // Leave delivery counts alone, as nothing is delivered
+	LastID       string        // Move the group's last delivered ID up to this; empty for none
+}
+
+// ClaimResult is what a claim changed, with enough detail for replicas to
+// make the same change
+type ClaimResult struct {
+	Claimed []ClaimedEntry
+	Deleted []string // Pending entries found deleted from the stream, dropped from the PEL
+	LastID  string   // The group's new last delivered ID; empty when unchanged
+}
+
+// ClaimedEntry is an entry transferred by a claim, as it is now pending
+type ClaimedEntry struct {
+	Entry         StreamEntry
+	DeliveryTime  time.Time
+	DeliveryCount int
+}
+
+// claim transfers a pending entry to the consumer as delivered at
+// deliveryTime
+func (c *Consumer) claim(pending *PendingEntry, deliveryTime time.Time, opts ClaimOptions) {
+	if pending.Consumer != nil {
+		delete(pending.Consumer.Pending, pending.ID)
+	}
+	pending.Consumer = c
+	pending.DeliveryTime = deliveryTime
+	if opts.RetryCount >= 0 {
+		pending.DeliveryCount = opts.RetryCount
+	} else if !opts.JustID {
+		pending.DeliveryCount++
+	}
+	c.Pending[pending.ID] = pending
+}
+
+// dropDeleted removes a pending entry whose stream entry was deleted, which
+// no consumer can process any more
+func (g *ConsumerGroup) dropDeleted(pending *PendingEntry) {
+	delete(pending.Consumer.Pending, pending.ID)
+	delete(g.Pending, pending.ID)
+}
+
+// GroupClaim gives consumer the pending entries among ids, which must be
+// normalized, that have been idle at least opts.MinIdle. The consumer is
+// created when it claims something.
+func GroupClaim(key, group, consumer string, ids []string, opts ClaimOptions) (ClaimResult, error) {
+	var result ClaimResult
+	stream, err := lockGroupStream(key, ErrNoGroup)
+	if err != nil {
+		return result, err
+	}
+	defer stream.mutex.Unlock()
+
+	g, exists := stream.Groups[group]
+	if !exists {
+		return result, ErrNoGroup
+	}
+	if opts.LastID != "" && compareStreamIDs(opts.LastID, g.LastDeliveredID) > 0 {
+		g.LastDeliveredID = opts.LastID
+		result.LastID = opts.LastID
+	}
+
+	now := time.Now()
+	deliveryTime := now
+	if !opts.DeliveryTime.IsZero() && opts.DeliveryTime.Before(now) {
+		deliveryTime = opts.DeliveryTime
+	}
+	for _, id := range ids {
+		entry, live := stream.entry(id)
+		pending, exists := g.Pending[id]
+		if !exists {
+			if !opts.Force || !live {
+				continue
+			}
+			// A forced claim starts from one delivery, as in Redis
+			pending = &PendingEntry{ID: id, DeliveryCount: 1}
+			g.Pending[id] = pending
+		} else if !live {
+			g.dropDeleted(pending)
+			result.Deleted = append(result.Deleted, id)
+			continue
+		} else if now.Sub(pending.DeliveryTime) < opts.MinIdle {
+			continue
+		}
+
+		c, _ := g.consumer(consumer)
+		c.SeenTime = now
+		c.claim(pending, deliveryTime, opts)
+		result.Claimed = append(result.Claimed, ClaimedEntry{
+			Entry:         entry,
+			DeliveryTime:  pending.DeliveryTime,
+			DeliveryCount: pending.DeliveryCount,
+		})
+	}
+	return result, nil
+}
+
+// GroupAutoClaim scans the group's PEL in ID order from start, which must
+// be normalized, and gives consumer up to count entries idle at least
+// minIdle, looking at no more than ten times count entries. It returns the
+// ID to continue the scan from, "0-0" once the PEL is exhausted.
+func GroupAutoClaim(key, group, consumer string, minIdle time.Duration, start string, count int, justID bool) (ClaimResult, string, error) {
+	var result ClaimResult
+	stream, err := lockGroupStream(key, ErrNoGroup)
+	if err != nil {
+		return result, "", err
+	}
+	defer stream.mutex.Unlock()
+
+	g, exists := stream.Groups[group]
+	if !exists {
+		return result, "", ErrNoGroup
+	}
+
+	ids := make([]string, 0, len(g.Pending))
+	for id := range g.Pending {
+		if compareStreamIDs(id, start) >= 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return compareStreamIDs(ids[i], ids[j]) < 0 })
+
+	now := time.Now()
+	opts := ClaimOptions{RetryCount: -1, JustID: justID}
+	attempts := count * 10
+	next := "0-0"
+	for i, id := range ids {
+		if len(result.Claimed) == count || attempts == 0 {
+			next = ids[i]
+			break
+		}
+		attempts--
+
+		pending := g.Pending[id]
+		entry, live := stream.entry(id)
+		if !live {
+			g.dropDeleted(pending)
+			result.Deleted = append(result.Deleted, id)
+			continue
+		}
+		if now.Sub(pending.DeliveryTime) < minIdle {
+			continue
+		}
+
+		c, _ := g.consumer(consumer)
+		c.SeenTime = now
+		c.claim(pending, now, opts)
+		result.Claimed = append(result.Claimed, ClaimedEntry{
+			Entry:         entry,
+			DeliveryTime:  now,
+			DeliveryCount: pending.DeliveryCount,
+		})
+	}
+	return result, next, nil
+}
+
+// StreamInfo is what XINFO STREAM reports about a stream
+type StreamInfo struct {
+	Length      int
+	LastID      string
+	Groups      int
+	First, Last *StreamEntry // nil when the stream is empty
+}
+
+// GroupInfo is what XINFO GROUPS reports about a consumer group
+type GroupInfo struct {
+	Name            string
+	Consumers       int
+	Pending         int
+	LastDeliveredID string
+	Lag             int // Entries in the stream the group has not delivered yet
+}
+
+// ConsumerInfo is what XINFO CONSUMERS reports about a consumer
+type ConsumerInfo struct {
+	Name    string
+	Pending int
+	Idle    time.Duration // Since it last read or claimed
+}
+
+// InspectStream describes the stream at key
+func InspectStream(key string) (StreamInfo, error) {
+	stream, err := groupStream(key, ErrNoSuchKey)
+	if err != nil {
+		return StreamInfo{}, err
+	}
+
+	stream.mutex.RLock()
+	defer stream.mutex.RUnlock()
+
+	info := StreamInfo{Length: len(stream.Entries), LastID: stream.LastID, Groups: len(stream.Groups)}
+	if n := len(stream.Entries); n > 0 {
+		first, last := stream.Entries[0], stream.Entries[n-1]
+		info.First, info.Last = &first, &last
+	}
+	return info, nil
+}
+
+// InspectGroups describes the consumer groups of the stream at key, sorted
+// by name
+func InspectGroups(key string) ([]GroupInfo, error) {
+	stream, err := groupStream(key, ErrNoSuchKey)
+	if err != nil {
+		return nil, err
+	}
+
+	stream.mutex.RLock()
+	defer stream.mutex.RUnlock()
+
+	groups := make([]GroupInfo, 0, len(stream.Groups))
+	for name, g := range stream.Groups {
+		undelivered := stream.search(g.LastDeliveredID, true)
+		groups = append(groups, GroupInfo{
+			Name:            name,
+			Consumers:       len(g.Consumers),
+			Pending:         len(g.Pending),
+			LastDeliveredID: g.LastDeliveredID,
+			Lag:             len(stream.Entries) - undelivered,
+		})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups, nil
+}
+
+// InspectConsumers describes the consumers of a group, sorted by name
+func InspectConsumers(key, group string) ([]ConsumerInfo, error) {
+	stream, err := groupStream(key, ErrNoSuchKey)
+	if err != nil {
+		return nil, err
+	}
+
+	stream.mutex.RLock()
+	defer stream.mutex.RUnlock()
+
+	g, exists := stream.Groups[group]
+	if !exists {
+		return nil, ErrNoGroup
+	}
+	now := time.Now()
+	consumers := make([]ConsumerInfo, 0, len(g.Consumers))
+	for name, c := range g.Consumers {
+		consumers = append(consumers, ConsumerInfo{Name: name, Pending: len(c.Pending), Idle: now.Sub(c.SeenTime)})
+	}
+	sort.Slice(consumers, func(i, j int) bool { return consumers[i].Name < consumers[j].Name })
+	return consumers, nil
+}
@@ -0,0 +1,214 @@
+package database
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+const (
+	// DefaultMaxStringSize is the default of proto-max-bulk-len, the
+	// largest value APPEND, SETRANGE and SETBIT may produce, like in Redis
+	DefaultMaxStringSize = 512 << 20
+
+	// Strings shorter than this are rebuilt on every write; larger ones
+	// move to a stringBuf so APPEND can grow them in place
+	bufferedStringMin = 64
+
+	// Buffers double until they reach this size and then grow by it, like
+	// Redis' sdsMakeRoomFor
+	stringGrowLimit = 1 << 20
+)
+
+var ErrStringTooLarge = errors.New("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+
+// maxStringSize is the limit set with SetMaxStringSize
+var maxStringSize atomic.Int64
+
+func init() {
+	maxStringSize.Store(DefaultMaxStringSize)
+}
+
+// SetMaxStringSize sets the largest value APPEND, SETRANGE and SETBIT may
+// produce, which the server keeps equal to proto-max-bulk-len
+func SetMaxStringSize(n int) {
+	maxStringSize.Store(int64(n))
+}
+
+// MaxStringSize returns the limit set with SetMaxStringSize
+func MaxStringSize() int {
+	return int(maxStringSize.Load())
+}
+
+// stringBuf is the backing array of a large string. KeyValue.Val aliases
+// data[:len(Val)], which is never modified once written: writes only ever
+// land past len(data), in the spare capacity. A value may therefore grow in
+// place only while it is the tip of its buffer (len(Val) == len(data));
+// older values sharing the buffer, and writes that would overwrite existing
+// bytes, copy instead.
+type stringBuf struct {
+	data []byte
+}
+
+// stringWrites serializes APPEND/SETRANGE so two writers never fill the
+// same spare capacity
+var stringWrites keyMutex
+
+// stringCapacity returns the buffer size allocated for a string of n bytes
+func stringCapacity(n int) int {
+	limit := max(MaxStringSize(), n)
+	if n < stringGrowLimit {
+		return min(n*2, limit)
+	}
+	return min(n+stringGrowLimit, limit)
+}
+
+// clone returns a copy of kv to change and store in its place
+func (kv *KeyValue) clone() *KeyValue {
+	c := &KeyValue{Val: kv.Val, Px: kv.Px, T: kv.T, buf: kv.buf}
+	c.lru.Store(kv.lru.Load())
+	return c
+}
+
+// setBytes replaces the value of kv, not stored yet, with data, taking
+// ownership of data as the new tip
+func (kv *KeyValue) setBytes(data []byte) {
+	if len(data) < bufferedStringMin {
+		kv.Val = string(data)
+		kv.buf = nil
+		return
+	}
+	kv.buf = &stringBuf{data: data}
+	kv.Val = unsafe.String(unsafe.SliceData(data), len(data))
+}
+
+// extend adds pad zero bytes and then tail to the value of kv, not stored
+// yet, writing into the buffer's spare capacity when kv is its tip
+func (kv *KeyValue) extend(pad int, tail string) {
+	oldLen := len(kv.Val)
+	newLen := oldLen + pad + len(tail)
+
+	if newLen < bufferedStringMin {
+		data := make([]byte, newLen)
+		copy(data, kv.Val)
+		copy(data[oldLen+pad:], tail)
+		kv.setBytes(data)
+		return
+	}
+
+	buf := kv.buf
+	if buf == nil || len(buf.data) != oldLen || cap(buf.data) < newLen {
+		data := make([]byte, oldLen, stringCapacity(newLen))
+		copy(data, kv.Val)
+		buf = &stringBuf{data: data}
+	}
+
+	data := buf.data[:oldLen+pad]
+	clear(data[oldLen:])
+	buf.data = append(data, tail...)
+
+	kv.buf = buf
+	kv.Val = unsafe.String(unsafe.SliceData(buf.data), len(buf.data))
+}
+
+// overwrite writes value at offset into the value of kv, not stored yet,
+// copying the existing bytes since other values may still alias them
+func (kv *KeyValue) overwrite(offset int, value string) {
+	newLen := max(len(kv.Val), offset+len(value))
+	data := make([]byte, newLen, stringCapacity(newLen))
+	copy(data, kv.Val)
+	copy(data[offset:], value)
+	kv.setBytes(data)
+}
+
+// updateString lets fn change a copy of the string at key (an empty string
+// when the key is missing) and stores the result, keeping any TTL, which
+// like any write it ignores if it elapsed (see access). Returns the new
+// length.
+func updateString(key string, fn func(kv *KeyValue) error) (int, error) {
+	stringWrites.Lock()
+	defer stringWrites.Unlock()
+
+	for {
+		old, found := DB.Load(key)
+		var kv *KeyValue
+		if found {
+			v, ok := old.(*KeyValue)
+			if !ok {
+				return 0, ErrWrongType
+			}
+			kv = v.clone()
+		} else {
+			kv = &KeyValue{Px: -1, T: time.Now()}
+		}
+
+		if err := fn(kv); err != nil {
+			return 0, err
+		}
+		touch(kv)
+
+		// Retry if a SET or DEL raced with us; bytes already written to a
+		// buffer's spare capacity are simply abandoned.
+		var stored bool
+		if found {
+			stored = DB.CompareAndSwap(key, old, kv)
+		} else {
+			_, loaded := DB.LoadOrStore(key, kv)
+			stored = !loaded
+		}
+		if stored {
+			if kv.Px != -1 {
+				indexExpiry(key)
+			}
+			return len(kv.Val), nil
+		}
+	}
+}
+
+// StringAppend appends value to the string at key, creating it when
+// missing, and returns the new length
+func StringAppend(key, value string) (int, error) {
+	return updateString(key, func(kv *KeyValue) error {
+		if len(kv.Val)+len(value) > MaxStringSize() {
+			return ErrStringTooLarge
+		}
+		kv.extend(0, value)
+		return nil
+	})
+}
+
+// StringSetRange overwrites the string at key starting at offset, padding
+// with zero bytes as needed, and returns the new length. Writes at or past
+// the end of the string grow it in place like APPEND.
+func StringSetRange(key string, offset int, value string) (int, error) {
+	if offset+len(value) > MaxStringSize() {
+		return 0, ErrStringTooLarge
+	}
+	if value == "" {
+		// Nothing is written, and a missing key is not created.
+		return StringLen(key)
+	}
+
+	return updateString(key, func(kv *KeyValue) error {
+		if offset >= len(kv.Val) {
+			kv.extend(offset-len(kv.Val), value)
+		} else {
+			kv.overwrite(offset, value)
+		}
+		return nil
+	})
+}
+
+// StringLen returns the length of the string at key, 0 when missing
+func StringLen(key string) (int, error) {
+	val, found := lookup(key)
+	if !found {
+		return 0, nil
+	}
+	kv, ok := val.(*KeyValue)
+	if !ok {
+		return 0, ErrWrongType
+	}
+	return len(kv.Val), nil
+}
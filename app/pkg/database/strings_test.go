@@ -0,0 +1,58 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+// logLine is what a log-appending workload APPENDs each time
+var logLine = strings.Repeat("x", 99) + "\n"
+
+func TestAppendGrowsInPlace(t *testing.T) {
+	Flush()
+	StringAppend("log", strings.Repeat("x", 1000))
+
+	// Between reallocations of the buffer, an APPEND allocates the new
+	// KeyValue and what the map needs to swap it in, never the string
+	allocs := testing.AllocsPerRun(1000, func() {
+		StringAppend("log", logLine)
+	})
+	if allocs > 3 {
+		t.Errorf("APPEND of a line to a growing string allocates %.1f times, want at most 3", allocs)
+	}
+
+	// Older values keep their bytes
+	before, _ := GetKey("log")
+	StringAppend("log", "tail")
+	after, _ := GetKey("log")
+	if !strings.HasPrefix(after, before) || strings.HasSuffix(before, "tail") {
+		t.Error("APPEND changed the bytes of the value it replaced")
+	}
+}
+
+// BenchmarkAppend appends lines to one key, as a log written with APPEND
+// would, growing the string in its spare capacity
+func BenchmarkAppend(b *testing.B) {
+	Flush()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if i%10000 == 0 {
+			DeleteKey("log")
+		}
+		StringAppend("log", logLine)
+	}
+}
+
+// BenchmarkAppendByCopy is the same workload copying the whole string on
+// every append, what BenchmarkAppend is measured against
+func BenchmarkAppendByCopy(b *testing.B) {
+	Flush()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if i%10000 == 0 {
+			DeleteKey("log")
+		}
+		current, _ := GetKey("log")
+		SetKey("log", current+logLine, -1)
+	}
+}
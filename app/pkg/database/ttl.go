@@ -0,0 +1,195 @@
+package database
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Strings and streams keep their key TTL in Px and T, the other types in
+// expireAt. deadline reads either one.
+
+// deadline returns when val expires, in Unix nanoseconds, and false when it
+// has no TTL
+func deadline(val interface{}) (int64, bool) {
+	switch v := val.(type) {
+	case *KeyValue:
+		if v.Px != -1 {
+			return v.T.Add(time.Duration(v.Px) * time.Millisecond).UnixNano(), true
+		}
+	case StreamData:
+		if v.Px != -1 {
+			return v.T.Add(time.Duration(v.Px) * time.Millisecond).UnixNano(), true
+		}
+	default:
+		if at := containerExpireAt(val); at != nil {
+			if n := at.Load(); n != 0 {
+				return n, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// containerExpireAt returns the TTL of a list, hash, set or sorted set, nil
+// for other values
+func containerExpireAt(val interface{}) *atomic.Int64 {
+	switch v := val.(type) {
+	case *List:
+		return &v.expireAt
+	case *Hash:
+		return &v.expireAt
+	case *Set:
+		return &v.expireAt
+	case *ZSet:
+		return &v.expireAt
+	default:
+		return nil
+	}
+}
+
+// isExpired reports whether a stored value carries a TTL that has elapsed
+func isExpired(val interface{}) bool {
+	at, volatile := deadline(val)
+	return volatile && time.Now().UnixNano() > at
+}
+
+// lookup returns the value at key, and false when the key is missing or its
+// TTL elapsed
+func lookup(key string) (interface{}, bool) {
+	val, found := DB.Load(key)
+	if !found || isExpired(val) {
+		return nil, false
+	}
+	return val, true
+}
+
+// access is what a value is loaded for. Reads skip a value whose TTL
+// elapsed; writes use it, since only the master deletes expired keys.
+type access int
+
+const (
+	forRead   access = iota
+	forWrite         // A write to the value, when there is one
+	forCreate        // A write that creates the value when it is missing
+)
+
+// writeAccess returns the access of a write, one that creates missing
+// values when create is set
+func writeAccess(create bool) access {
+	if create {
+		return forCreate
+	}
+	return forWrite
+}
+
+// live reports whether val, stored at a key, is there when loaded for mode
+func (mode access) live(val interface{}) bool {
+	return mode != forRead || !isExpired(val)
+}
+
+// Key TTL replies of TTL and PTTL besides the time left
+const (
+	KeyMissing = -2 // the key does not exist
+	KeyNoTTL   = -1 // the key exists but has no TTL
+)
+
+// KeyTTL returns the time to live of key in milliseconds, or KeyMissing /
+// KeyNoTTL
+func KeyTTL(key string) int64 {
+	val, found := lookup(key)
+	if !found {
+		return KeyMissing
+	}
+	at, volatile := deadline(val)
+	if !volatile {
+		return KeyNoTTL
+	}
+	return time.Until(time.Unix(0, at)).Milliseconds()
+}
+
+// Expire gives key the absolute expiry at and reports whether it did. cond
+// is "", "NX", "XX", "GT" or "LT". A past expiry is set all the same.
+func Expire(key string, at time.Time, cond string) bool {
+	n := at.UnixNano()
+	return setDeadline(key, n, func(current int64) bool {
+		switch cond {
+		case "NX":
+			return current == 0
+		case "XX":
+			return current != 0
+		case "GT":
+			// No TTL counts as an infinite one, which nothing is greater than.
+			return current != 0 && n > current
+		case "LT":
+			return current == 0 || n < current
+		}
+		return true
+	})
+}
+
+// Persist removes the TTL of key and reports whether it had one
+func Persist(key string) bool {
+	return setDeadline(key, 0, func(current int64) bool {
+		return current != 0
+	})
+}
+
+// setDeadline sets the deadline of the value at key to at, 0 for none, if
+// allow accepts its current one. It reports false otherwise or when the key
+// is missing.
+func setDeadline(key string, at int64, allow func(current int64) bool) bool {
+	for {
+		val, found := DB.Load(key)
+		if !found {
+			return false
+		}
+		if current, _ := deadline(val); !allow(current) {
+			return false
+		}
+		if swapDeadline(key, val, at) {
+			if at != 0 {
+				indexExpiry(key)
+			}
+			return true
+		}
+	}
+}
+
+// swapDeadline sets the deadline of val, the value at key, and reports
+// false when the key no longer holds it
+func swapDeadline(key string, val interface{}, at int64) bool {
+	switch v := val.(type) {
+	case *KeyValue:
+		stringWrites.Lock()
+		defer stringWrites.Unlock()
+		updated := v.clone()
+		updated.Px, updated.T = ttlFields(at)
+		return DB.CompareAndSwap(key, val, updated)
+	case StreamData:
+		m := &v.Stream.mutex
+		m.Lock()
+		defer m.Unlock()
+		v.Px, v.T = ttlFields(at)
+		return !m.retired && DB.CompareAndSwap(key, val, v)
+	}
+
+	m := valueMutex(val)
+	m.Lock()
+	defer m.Unlock()
+	if m.retired {
+		return false
+	}
+	containerExpireAt(val).Store(at)
+	return true
+}
+
+// ttlFields returns the Px and T of a string or stream expiring at at, 0
+// for none. T is moved back by Px so that the deadline stays exact.
+func ttlFields(at int64) (int, time.Time) {
+	if at == 0 {
+		return -1, time.Now()
+	}
+	t := time.Unix(0, at)
+	px := max(time.Until(t).Milliseconds(), 0)
+	return int(px), t.Add(-time.Duration(px) * time.Millisecond)
+}
@@ -0,0 +1,155 @@
+package database
+
+import (
+	"container/heap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The TTL index is a min-heap of volatile keys by deadline. A key is
+// indexed from the stored value after each write giving it a TTL; stale
+// entries are fixed up when they come due.
+
+// expiryEntry is a key in the index
+type expiryEntry struct {
+	key   string
+	at    int64 // Deadline, Unix nanoseconds
+	index int   // Position in the heap
+}
+
+// expiryHeap orders entries by deadline, soonest first
+type expiryHeap []*expiryEntry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].at < h[j].at }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x any) {
+	e := x.(*expiryEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *expiryHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
+
+var expiries = struct {
+	heap  expiryHeap
+	byKey map[string]*expiryEntry
+	mutex sync.Mutex
+	// wake is signalled when the soonest deadline moves earlier, for
+	// ExpireLoop to sleep less
+	wake chan struct{}
+}{byKey: make(map[string]*expiryEntry), wake: make(chan struct{}, 1)}
+
+// indexedExpiries is len(expiries.byKey), read without the lock so deletes
+// skip it while no key has a TTL
+var indexedExpiries atomic.Int64
+
+// indexExpiry indexes key by the deadline of the value it holds now, or
+// drops it when that value has none. It is called after every write that
+// may leave key with a TTL.
+func indexExpiry(key string) {
+	expiries.mutex.Lock()
+	defer expiries.mutex.Unlock()
+
+	at, volatile := int64(0), false
+	if val, found := DB.Load(key); found {
+		at, volatile = deadline(val)
+	}
+	setExpiryLocked(key, at, volatile)
+}
+
+// unindexExpiry drops key, just deleted, from the index
+func unindexExpiry(key string) {
+	if indexedExpiries.Load() == 0 {
+		return
+	}
+	expiries.mutex.Lock()
+	defer expiries.mutex.Unlock()
+	setExpiryLocked(key, 0, false)
+}
+
+// clearExpiries empties the index along with the keyspace
+func clearExpiries() {
+	expiries.mutex.Lock()
+	defer expiries.mutex.Unlock()
+	expiries.heap = nil
+	clear(expiries.byKey)
+	indexedExpiries.Store(0)
+}
+
+// setExpiryLocked moves key to deadline at, adds it, or drops it when it is
+// not volatile. The caller holds expiries.mutex.
+func setExpiryLocked(key string, at int64, volatile bool) {
+	e := expiries.byKey[key]
+	switch {
+	case !volatile:
+		if e != nil {
+			heap.Remove(&expiries.heap, e.index)
+			delete(expiries.byKey, key)
+			indexedExpiries.Add(-1)
+		}
+		return
+	case e == nil:
+		e = &expiryEntry{key: key, at: at}
+		heap.Push(&expiries.heap, e)
+		expiries.byKey[key] = e
+		indexedExpiries.Add(1)
+	default:
+		e.at = at
+		heap.Fix(&expiries.heap, e.index)
+	}
+
+	if expiries.heap[0] == e {
+		select {
+		case expiries.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// nextExpiry returns the soonest deadline in the index, and false when it
+// is empty
+func nextExpiry() (time.Time, bool) {
+	expiries.mutex.Lock()
+	defer expiries.mutex.Unlock()
+	if len(expiries.heap) == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, expiries.heap[0].at), true
+}
+
+// dueKey returns the key with the soonest deadline if that deadline is
+// before now. The key stays in the index until it is deleted or indexed
+// again.
+func dueKey(now int64) (string, bool) {
+	expiries.mutex.Lock()
+	defer expiries.mutex.Unlock()
+	if len(expiries.heap) == 0 || expiries.heap[0].at >= now {
+		return "", false
+	}
+	return expiries.heap[0].key, true
+}
+
+// indexedKeys returns every key in the index
+func indexedKeys() []string {
+	expiries.mutex.Lock()
+	defer expiries.mutex.Unlock()
+	keys := make([]string, 0, len(expiries.byKey))
+	for key := range expiries.byKey {
+		keys = append(keys, key)
+	}
+	return keys
+}
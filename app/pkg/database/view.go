@@ -0,0 +1,215 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+// View is the keyspace as it was at one point in time, which a connection
+// reads from between SNAPSHOT BEGIN and SNAPSHOT END. It is a snapshot that
+// stays open: every value is pinned when the view is taken, so writes
+// meanwhile go to copies, and the view reads the pinned values without
+// locking them until Release. Keys whose TTL had elapsed by then are left
+// out, and the others stay in the view, expiring or not, as they were.
+type View struct {
+	at     time.Time
+	values map[string]interface{}
+	pinned []pinnedValue
+}
+
+// NewView takes a view of the keyspace. Taken while writes are paused, it
+// is the keyspace at that point; otherwise each key is as it was when
+// pinned. The view holds its values, and counts as a running snapshot in
+// SnapshotStats, until Release.
+func NewView() *View {
+	pinned := beginSnapshot()
+	v := &View{at: time.Now(), values: make(map[string]interface{}, len(pinned)), pinned: pinned}
+	for _, p := range pinned {
+		if !isExpired(p.val) {
+			v.values[p.key] = p.val
+		}
+	}
+	return v
+}
+
+// Release unpins the values of the view, which must not be read again
+func (v *View) Release() {
+	endSnapshot(v.pinned)
+	v.values, v.pinned = nil, nil
+}
+
+// viewValue returns the value at key in v, the zero T when the key is
+// missing and ErrWrongType when it holds another type
+func viewValue[T any](v *View, key string) (T, error) {
+	var none T
+	val, found := v.values[key]
+	if !found {
+		return none, nil
+	}
+	t, ok := val.(T)
+	if !ok {
+		return none, ErrWrongType
+	}
+	return t, nil
+}
+
+// Keys returns every key of the view in sorted order
+func (v *View) Keys() []string {
+	keys := make([]string, 0, len(v.values))
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Type returns the type name of key, "none" when the view doesn't have it
+func (v *View) Type(key string) string {
+	val, found := v.values[key]
+	if !found {
+		return "none"
+	}
+	return TypeName(val)
+}
+
+// Get returns the string at key. Like GetKey it reports other types as
+// missing.
+func (v *View) Get(key string) (string, bool) {
+	kv, _ := viewValue[*KeyValue](v, key)
+	if kv == nil {
+		return "", false
+	}
+	return kv.Val, true
+}
+
+// ListLen returns the length of the list at key
+func (v *View) ListLen(key string) (int, error) {
+	list, err := viewValue[*List](v, key)
+	if err != nil || list == nil {
+		return 0, err
+	}
+	return list.n, nil
+}
+
+// ListRange returns the elements of the list at key from start to end, see
+// LRange
+func (v *View) ListRange(key string, start, end int) ([]string, error) {
+	list, err := viewValue[*List](v, key)
+	if err != nil || list == nil {
+		return []string{}, err
+	}
+	return list.rangeOf(start, end), nil
+}
+
+// ListIndex returns the element at index of the list at key, see LIndex
+func (v *View) ListIndex(key string, index int) (string, bool, error) {
+	list, err := viewValue[*List](v, key)
+	if err != nil || list == nil {
+		return "", false, err
+	}
+	i, ok := listIndex(index, list.n)
+	if !ok {
+		return "", false, nil
+	}
+	return list.At(i), true, nil
+}
+
+// HashGet returns a field of the hash at key. Fields count as expired when
+// their TTL had elapsed by the time the view was taken.
+func (v *View) HashGet(key, field string) (string, bool, error) {
+	hash, err := viewValue[*Hash](v, key)
+	if err != nil || hash == nil {
+		return "", false, err
+	}
+	val, ok := hash.liveField(field, v.at)
+	return val, ok, nil
+}
+
+// HashMultiGet returns several fields of the hash at key, see HashMultiGet
+func (v *View) HashMultiGet(key string, fields []string) ([]string, []bool, error) {
+	values := make([]string, len(fields))
+	found := make([]bool, len(fields))
+	hash, err := viewValue[*Hash](v, key)
+	if err != nil || hash == nil {
+		return values, found, err
+	}
+	for i, field := range fields {
+		values[i], found[i] = hash.liveField(field, v.at)
+	}
+	return values, found, nil
+}
+
+// HashGetAll returns the hash at key as a flat field, value... slice
+func (v *View) HashGetAll(key string) ([]string, error) {
+	hash, err := viewValue[*Hash](v, key)
+	if err != nil || hash == nil {
+		return []string{}, err
+	}
+	return hash.livePairs(v.at), nil
+}
+
+// HashLen returns the number of fields of the hash at key
+func (v *View) HashLen(key string) (int, error) {
+	hash, err := viewValue[*Hash](v, key)
+	if err != nil || hash == nil {
+		return 0, err
+	}
+	return hash.liveLen(v.at), nil
+}
+
+// SetMembers returns the members of the set at key in sorted order
+func (v *View) SetMembers(key string) ([]string, error) {
+	set, err := viewValue[*Set](v, key)
+	if err != nil || set == nil {
+		return []string{}, err
+	}
+	return set.sortedMembers(), nil
+}
+
+// SetIsMember reports whether member belongs to the set at key
+func (v *View) SetIsMember(key, member string) (bool, error) {
+	set, err := viewValue[*Set](v, key)
+	if err != nil || set == nil {
+		return false, err
+	}
+	_, exists := set.Members[member]
+	return exists, nil
+}
+
+// SetCard returns the number of members of the set at key
+func (v *View) SetCard(key string) (int, error) {
+	set, err := viewValue[*Set](v, key)
+	if err != nil || set == nil {
+		return 0, err
+	}
+	return len(set.Members), nil
+}
+
+// ZSetScore returns the score of member in the sorted set at key
+func (v *View) ZSetScore(key, member string) (float64, bool, error) {
+	zset, err := viewValue[*ZSet](v, key)
+	if err != nil || zset == nil {
+		return 0, false, err
+	}
+	score, ok := zset.Scores[member]
+	return score, ok, nil
+}
+
+// ZSetCard returns the number of members of the sorted set at key
+func (v *View) ZSetCard(key string) (int, error) {
+	zset, err := viewValue[*ZSet](v, key)
+	if err != nil || zset == nil {
+		return 0, err
+	}
+	return zset.zsl.length, nil
+}
+
+// ZSetRangeByRank returns the members of the sorted set at key between two
+// ranks, see ZSetRangeByRank
+func (v *View) ZSetRangeByRank(key string, start, stop int, rev bool) ([]ZMember, error) {
+	zset, err := viewValue[*ZSet](v, key)
+	if err != nil || zset == nil {
+		return []ZMember{}, err
+	}
+	return zset.rangeByRank(start, stop, rev), nil
+}
@@ -0,0 +1,713 @@
+package database
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/r0ld3x/redis-clone-go/app/pkg/sample"
+)
+
+// ErrScoreNaN is returned when ZINCRBY would produce a NaN score, e.g. by
+// adding -inf to +inf
+var ErrScoreNaN = errors.New("ERR resulting score is not a number (NaN)")
+
+// ZSet is a sorted set: a member -> score map for O(1) lookups plus a
+// skiplist ordered by (score, member) for O(log n) updates and rank queries
+type ZSet struct {
+	Scores map[string]float64
+	zsl    *skiplist
+	mutex  keyMutex
+	// expireAt is the key's TTL, see ttl.go
+	expireAt atomic.Int64
+	lru      atomic.Uint32 // Last access of the key, see access.go
+}
+
+// ZMember is a sorted set member with its score
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+func newZSet() *ZSet {
+	return &ZSet{Scores: make(map[string]float64), zsl: newSkiplist()}
+}
+
+// FormatScore renders a score the way Redis replies with it
+func FormatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	default:
+		return strconv.FormatFloat(score, 'g', -1, 64)
+	}
+}
+
+// loadZSet returns the sorted set stored at key. When the key is missing and
+// mode is forCreate, an empty sorted set is stored and returned; otherwise nil
+// is returned.
+func loadZSet(key string, mode access) (*ZSet, error) {
+	val, found := DB.Load(key)
+	if found && mode.live(val) {
+		zset, ok := val.(*ZSet)
+		if !ok {
+			return nil, ErrWrongType
+		}
+		touch(zset)
+		return zset, nil
+	}
+	if mode != forCreate {
+		return nil, nil
+	}
+
+	zset := newZSet()
+	touch(zset)
+	if _, loaded := DB.LoadOrStore(key, zset); loaded {
+		// Lost a race with another writer creating the key.
+		return loadZSet(key, mode)
+	}
+	return zset, nil
+}
+
+// lockZSet loads the sorted set at key like loadZSet and write-locks it, see
+// lockWrite. It returns nil, without holding a lock, when there is no sorted set
+// to work on.
+func lockZSet(key string, create bool) (*ZSet, error) {
+	return lockWrite(key, func() (*ZSet, error) {
+		return loadZSet(key, writeAccess(create))
+	})
+}
+
+// set inserts or rescores a member and reports whether it was added and
+// whether its score changed; the caller must hold the write lock
+func (zset *ZSet) set(member string, score float64) (added, changed bool) {
+	old, exists := zset.Scores[member]
+	if exists {
+		if old == score {
+			return false, false
+		}
+		zset.zsl.delete(old, member)
+		zset.zsl.insert(score, member)
+		zset.Scores[member] = score
+		return false, true
+	}
+	zset.zsl.insert(score, member)
+	zset.Scores[member] = score
+	return true, true
+}
+
+// remove deletes a member; the caller must hold the write lock
+func (zset *ZSet) remove(member string) bool {
+	score, exists := zset.Scores[member]
+	if !exists {
+		return false
+	}
+	zset.zsl.delete(score, member)
+	delete(zset.Scores, member)
+	return true
+}
+
+// ZAddFlags are ZADD's update conditions. NX only adds new members, XX only
+// updates existing ones, and GT/LT only update a score when the new one is
+// greater/less than the current one.
+type ZAddFlags struct {
+	NX, XX, GT, LT bool
+}
+
+// allows reports whether a member may be set to score under the flags
+func (f ZAddFlags) allows(zset *ZSet, member string, score float64) bool {
+	old, exists := zset.Scores[member]
+	if !exists {
+		return !f.XX
+	}
+	return !f.NX && (!f.GT || score > old) && (!f.LT || score < old)
+}
+
+// ZSetAdd adds or updates members subject to flags and returns how many
+// were newly added and how many were added or had their score changed
+func ZSetAdd(key string, members []ZMember, flags ZAddFlags) (int, int, error) {
+	zset, err := lockZSet(key, !flags.XX)
+	if err != nil || zset == nil {
+		return 0, 0, err
+	}
+	defer zset.mutex.Unlock()
+
+	added, changed := 0, 0
+	for _, m := range members {
+		if !flags.allows(zset, m.Member, m.Score) {
+			continue
+		}
+		a, c := zset.set(m.Member, m.Score)
+		if a {
+			added++
+		}
+		if c {
+			changed++
+		}
+	}
+	if zset.zsl.length == 0 {
+		retire(key, zset)
+	}
+	if added > 0 {
+		SignalKeyReady(key)
+	}
+	return added, changed, nil
+}
+
+// ZSetScore returns the score of a member
+func ZSetScore(key, member string) (float64, bool, error) {
+	zset, err := loadZSet(key, forRead)
+	if err != nil || zset == nil {
+		return 0, false, err
+	}
+
+	zset.mutex.RLock()
+	defer zset.mutex.RUnlock()
+
+	score, ok := zset.Scores[member]
+	return score, ok, nil
+}
+
+// ZSetCard returns the number of members in the sorted set
+func ZSetCard(key string) (int, error) {
+	zset, err := loadZSet(key, forRead)
+	if err != nil || zset == nil {
+		return 0, err
+	}
+
+	zset.mutex.RLock()
+	defer zset.mutex.RUnlock()
+
+	return zset.zsl.length, nil
+}
+
+// ZSetRemove removes members and returns how many existed. The key is
+// removed once the sorted set becomes empty.
+func ZSetRemove(key string, members []string) (int, error) {
+	zset, err := lockZSet(key, false)
+	if err != nil || zset == nil {
+		return 0, err
+	}
+	defer zset.mutex.Unlock()
+
+	removed := 0
+	for _, member := range members {
+		if zset.remove(member) {
+			removed++
+		}
+	}
+	if zset.zsl.length == 0 {
+		retire(key, zset)
+	}
+	return removed, nil
+}
+
+// ScoreRange is a ZRANGEBYSCORE-style interval
+type ScoreRange struct {
+	Min, Max                   float64
+	MinExclusive, MaxExclusive bool
+}
+
+func (r ScoreRange) aboveMin(n *skiplistNode) bool {
+	if r.MinExclusive {
+		return n.score > r.Min
+	}
+	return n.score >= r.Min
+}
+
+func (r ScoreRange) belowMax(n *skiplistNode) bool {
+	if r.MaxExclusive {
+		return n.score < r.Max
+	}
+	return n.score <= r.Max
+}
+
+// LexRange is a ZRANGEBYLEX-style interval. MinInfinite and MaxInfinite
+// stand for the "-" and "+" bounds.
+type LexRange struct {
+	Min, Max                   string
+	MinExclusive, MaxExclusive bool
+	MinInfinite, MaxInfinite   bool
+}
+
+func (r LexRange) aboveMin(n *skiplistNode) bool {
+	switch {
+	case r.MinInfinite:
+		return true
+	case r.MinExclusive:
+		return n.member > r.Min
+	default:
+		return n.member >= r.Min
+	}
+}
+
+func (r LexRange) belowMax(n *skiplistNode) bool {
+	switch {
+	case r.MaxInfinite:
+		return true
+	case r.MaxExclusive:
+		return n.member < r.Max
+	default:
+		return n.member <= r.Max
+	}
+}
+
+// collect walks from start (forwards, or backwards when rev is set) while
+// inRange holds, skipping offset nodes and returning at most count members
+// (all of them when count is negative)
+func collect(start *skiplistNode, rev bool, inRange func(*skiplistNode) bool, offset, count int) []ZMember {
+	result := []ZMember{}
+	for x := start; x != nil && inRange(x) && count != 0; {
+		if offset > 0 {
+			offset--
+		} else {
+			result = append(result, ZMember{Member: x.member, Score: x.score})
+			count--
+		}
+		if rev {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+	return result
+}
+
+// readZSet read-locks the sorted set at key and runs fn on it. Missing keys
+// yield an empty result.
+func readZSet(key string, fn func(*ZSet) []ZMember) ([]ZMember, error) {
+	zset, err := loadZSet(key, forRead)
+	if err != nil || zset == nil {
+		return []ZMember{}, err
+	}
+
+	zset.mutex.RLock()
+	defer zset.mutex.RUnlock()
+
+	return fn(zset), nil
+}
+
+// rangeByRank returns members between two 0-based ranks (negative ranks
+// count from the end), in descending order when rev is set; the caller must
+// hold the lock
+func (zset *ZSet) rangeByRank(start, stop int, rev bool) []ZMember {
+	length := zset.zsl.length
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop || start >= length {
+		return []ZMember{}
+	}
+
+	rank := start + 1
+	if rev {
+		rank = length - start
+	}
+	node := zset.zsl.byRank(rank)
+	return collect(node, rev, func(*skiplistNode) bool { return true }, 0, stop-start+1)
+}
+
+// rangeByScore returns members whose score lies in r; the caller must hold
+// the lock
+func (zset *ZSet) rangeByScore(r ScoreRange, rev bool, offset, count int) []ZMember {
+	if rev {
+		return collect(zset.zsl.lastWhere(r.belowMax), true, r.aboveMin, offset, count)
+	}
+	return collect(zset.zsl.firstWhere(r.aboveMin), false, r.belowMax, offset, count)
+}
+
+// rangeByLex returns members that lie in r; the caller must hold the lock.
+// Like Redis this assumes all members share the same score.
+func (zset *ZSet) rangeByLex(r LexRange, rev bool, offset, count int) []ZMember {
+	if rev {
+		return collect(zset.zsl.lastWhere(r.belowMax), true, r.aboveMin, offset, count)
+	}
+	return collect(zset.zsl.firstWhere(r.aboveMin), false, r.belowMax, offset, count)
+}
+
+// ZSetRangeByRank returns members between two 0-based ranks, inclusive.
+// Negative ranks count from the end; rev ranks from the highest score.
+func ZSetRangeByRank(key string, start, stop int, rev bool) ([]ZMember, error) {
+	return readZSet(key, func(zset *ZSet) []ZMember {
+		return zset.rangeByRank(start, stop, rev)
+	})
+}
+
+// ZSetRangeByScore returns members with a score in r, skipping offset
+// matches and returning at most count (all when negative)
+func ZSetRangeByScore(key string, r ScoreRange, rev bool, offset, count int) ([]ZMember, error) {
+	return readZSet(key, func(zset *ZSet) []ZMember {
+		return zset.rangeByScore(r, rev, offset, count)
+	})
+}
+
+// ZSetRangeByLex returns members in the lexicographic range r, skipping
+// offset matches and returning at most count (all when negative)
+func ZSetRangeByLex(key string, r LexRange, rev bool, offset, count int) ([]ZMember, error) {
+	return readZSet(key, func(zset *ZSet) []ZMember {
+		return zset.rangeByLex(r, rev, offset, count)
+	})
+}
+
+// ZSetIncrBy adds delta to the score of member, creating it with a score of
+// delta when missing, and returns the new score. ok is false when flags
+// prevented the update.
+func ZSetIncrBy(key, member string, delta float64, flags ZAddFlags) (score float64, ok bool, err error) {
+	zset, err := lockZSet(key, !flags.XX)
+	if err != nil || zset == nil {
+		return 0, false, err
+	}
+	defer func() {
+		if zset.zsl.length == 0 {
+			retire(key, zset)
+		}
+		zset.mutex.Unlock()
+	}()
+
+	score = zset.Scores[member] + delta
+	if math.IsNaN(score) {
+		return 0, false, ErrScoreNaN
+	}
+	if !flags.allows(zset, member, score) {
+		return 0, false, nil
+	}
+	if added, _ := zset.set(member, score); added {
+		SignalKeyReady(key)
+	}
+	return score, true, nil
+}
+
+// ZSetRank returns the 0-based rank of member, counted from the highest
+// score when rev is set, together with its score
+func ZSetRank(key, member string, rev bool) (int, float64, bool, error) {
+	zset, err := loadZSet(key, forRead)
+	if err != nil || zset == nil {
+		return 0, 0, false, err
+	}
+
+	zset.mutex.RLock()
+	defer zset.mutex.RUnlock()
+
+	score, ok := zset.Scores[member]
+	if !ok {
+		return 0, 0, false, nil
+	}
+	rank := zset.zsl.rank(score, member)
+	if rev {
+		return zset.zsl.length - rank, score, true, nil
+	}
+	return rank - 1, score, true, nil
+}
+
+// count returns how many members lie between the first node satisfying
+// aboveMin and the last satisfying belowMax, using their ranks instead of
+// walking the range; the caller must hold the lock
+func (zset *ZSet) count(aboveMin, belowMax func(*skiplistNode) bool) int {
+	first := zset.zsl.firstWhere(aboveMin)
+	if first == nil || !belowMax(first) {
+		return 0
+	}
+	last := zset.zsl.lastWhere(belowMax)
+	return zset.zsl.rank(last.score, last.member) - zset.zsl.rank(first.score, first.member) + 1
+}
+
+// countZSet read-locks the sorted set at key and counts the members between
+// the given bounds. Missing keys count as empty.
+func countZSet(key string, aboveMin, belowMax func(*skiplistNode) bool) (int, error) {
+	zset, err := loadZSet(key, forRead)
+	if err != nil || zset == nil {
+		return 0, err
+	}
+
+	zset.mutex.RLock()
+	defer zset.mutex.RUnlock()
+
+	return zset.count(aboveMin, belowMax), nil
+}
+
+// ZSetCountByScore returns the number of members with a score in r
+func ZSetCountByScore(key string, r ScoreRange) (int, error) {
+	return countZSet(key, r.aboveMin, r.belowMax)
+}
+
+// ZSetCountByLex returns the number of members in the lexicographic range r
+func ZSetCountByLex(key string, r LexRange) (int, error) {
+	return countZSet(key, r.aboveMin, r.belowMax)
+}
+
+// ZSetPop removes and returns up to count members with the lowest scores, or
+// the highest when max is set. The key is removed once the sorted set
+// becomes empty.
+func ZSetPop(key string, count int, max bool) ([]ZMember, error) {
+	zset, err := lockZSet(key, false)
+	if err != nil || zset == nil {
+		return []ZMember{}, err
+	}
+	defer zset.mutex.Unlock()
+
+	popped := make([]ZMember, 0, min(count, zset.zsl.length))
+	for len(popped) < count && zset.zsl.length > 0 {
+		x := zset.zsl.header.level[0].forward
+		if max {
+			x = zset.zsl.tail
+		}
+		popped = append(popped, ZMember{Member: x.member, Score: x.score})
+		zset.remove(x.member)
+	}
+	if zset.zsl.length == 0 {
+		retire(key, zset)
+	}
+	return popped, nil
+}
+
+// ZSetStore replaces whatever is stored at key with a sorted set of the
+// given members, or deletes the key when there are none. It returns the
+// size of the stored sorted set.
+func ZSetStore(key string, members []ZMember) int {
+	if len(members) == 0 {
+		DeleteKey(key)
+		return 0
+	}
+
+	zset := newZSet()
+	for _, m := range members {
+		zset.set(m.Member, m.Score)
+	}
+	touch(zset)
+	DB.Store(key, zset)
+	SignalKeyReady(key)
+	return zset.zsl.length
+}
+
+// ZAggregate selects how ZUNION/ZINTER combine the scores of a member found
+// in several inputs
+type ZAggregate int
+
+const (
+	ZAggregateSum ZAggregate = iota
+	ZAggregateMin
+	ZAggregateMax
+)
+
+// apply combines two scores, treating NaN (e.g. inf + -inf) as 0 like Redis
+func (agg ZAggregate) apply(a, b float64) float64 {
+	switch agg {
+	case ZAggregateMin:
+		return math.Min(a, b)
+	case ZAggregateMax:
+		return math.Max(a, b)
+	default:
+		if sum := a + b; !math.IsNaN(sum) {
+			return sum
+		}
+		return 0
+	}
+}
+
+// zsetSnapshot copies the member scores of the sorted set at key. Plain sets
+// are accepted too, with every member scoring 1. Missing keys yield nil.
+func zsetSnapshot(key string) (map[string]float64, error) {
+	val, found := lookup(key)
+	if !found {
+		return nil, nil
+	}
+
+	switch v := val.(type) {
+	case *ZSet:
+		v.mutex.RLock()
+		defer v.mutex.RUnlock()
+		scores := make(map[string]float64, len(v.Scores))
+		for member, score := range v.Scores {
+			scores[member] = score
+		}
+		return scores, nil
+	case *Set:
+		v.mutex.RLock()
+		defer v.mutex.RUnlock()
+		scores := make(map[string]float64, len(v.Members))
+		for member := range v.Members {
+			scores[member] = 1
+		}
+		return scores, nil
+	default:
+		return nil, ErrWrongType
+	}
+}
+
+// ZSetCombine computes the union, intersection or difference of the sorted
+// sets at keys. Each input's scores are multiplied by its weight (nil means
+// all 1) before being combined with agg; the difference keeps the scores of
+// the first input. The result is ordered by score, then member.
+func ZSetCombine(op SetOp, keys []string, weights []float64, agg ZAggregate) ([]ZMember, error) {
+	inputs := make([]map[string]float64, len(keys))
+	for i, key := range keys {
+		scores, err := zsetSnapshot(key)
+		if err != nil {
+			return nil, err
+		}
+		inputs[i] = scores
+	}
+
+	weighted := func(i int, score float64) float64 {
+		if weights == nil {
+			return score
+		}
+		if w := score * weights[i]; !math.IsNaN(w) {
+			return w
+		}
+		return 0
+	}
+
+	result := make(map[string]float64)
+	switch op {
+	case SetOpUnion:
+		for i, scores := range inputs {
+			for member, score := range scores {
+				score = weighted(i, score)
+				if current, ok := result[member]; ok {
+					score = agg.apply(current, score)
+				}
+				result[member] = score
+			}
+		}
+	case SetOpInter:
+	members:
+		for member, score := range inputs[0] {
+			score = weighted(0, score)
+			for i, scores := range inputs[1:] {
+				other, ok := scores[member]
+				if !ok {
+					continue members
+				}
+				score = agg.apply(score, weighted(i+1, other))
+			}
+			result[member] = score
+		}
+	case SetOpDiff:
+		for member, score := range inputs[0] {
+			found := false
+			for _, scores := range inputs[1:] {
+				if _, found = scores[member]; found {
+					break
+				}
+			}
+			if !found {
+				result[member] = score
+			}
+		}
+	}
+
+	combined := make([]ZMember, 0, len(result))
+	for member, score := range result {
+		combined = append(combined, ZMember{Member: member, Score: score})
+	}
+	sort.Slice(combined, func(i, j int) bool {
+		if combined[i].Score != combined[j].Score {
+			return combined[i].Score < combined[j].Score
+		}
+		return combined[i].Member < combined[j].Member
+	})
+	return combined, nil
+}
+
+// ZSetMultiScore returns the score of each member; ok[i] is false for
+// members that are absent
+func ZSetMultiScore(key string, members []string) ([]float64, []bool, error) {
+	scores := make([]float64, len(members))
+	ok := make([]bool, len(members))
+
+	zset, err := loadZSet(key, forRead)
+	if err != nil || zset == nil {
+		return scores, ok, err
+	}
+
+	zset.mutex.RLock()
+	defer zset.mutex.RUnlock()
+
+	for i, member := range members {
+		scores[i], ok[i] = zset.Scores[member]
+	}
+	return scores, ok, nil
+}
+
+// ZSetRandomMembers returns count distinct random members, or -count
+// members that may repeat when count is negative. Members are picked by
+// rank, so each pick costs O(log n) regardless of the set's size.
+func ZSetRandomMembers(key string, count int) ([]ZMember, error) {
+	zset, err := loadZSet(key, forRead)
+	if err != nil || zset == nil {
+		return []ZMember{}, err
+	}
+
+	zset.mutex.RLock()
+	defer zset.mutex.RUnlock()
+
+	var ranks []int
+	if count >= 0 {
+		ranks = sample.Distinct(zset.zsl.length, count)
+	} else {
+		ranks = sample.WithReplacement(zset.zsl.length, -count)
+	}
+
+	picked := make([]ZMember, len(ranks))
+	for i, rank := range ranks {
+		x := zset.zsl.byRank(rank + 1)
+		picked[i] = ZMember{Member: x.member, Score: x.score}
+	}
+	return picked, nil
+}
+
+// removeZSetRange write-locks the sorted set at key, removes the members fn
+// selects and returns how many were removed. The key is removed once the
+// sorted set becomes empty.
+func removeZSetRange(key string, fn func(*ZSet) []ZMember) (int, error) {
+	zset, err := lockZSet(key, false)
+	if err != nil || zset == nil {
+		return 0, err
+	}
+	defer zset.mutex.Unlock()
+
+	selected := fn(zset)
+	for _, m := range selected {
+		zset.remove(m.Member)
+	}
+	if zset.zsl.length == 0 {
+		retire(key, zset)
+	}
+	return len(selected), nil
+}
+
+// ZSetRemoveRangeByRank removes the members between two 0-based ranks,
+// inclusive; negative ranks count from the end
+func ZSetRemoveRangeByRank(key string, start, stop int) (int, error) {
+	return removeZSetRange(key, func(zset *ZSet) []ZMember {
+		return zset.rangeByRank(start, stop, false)
+	})
+}
+
+// ZSetRemoveRangeByScore removes the members with a score in r
+func ZSetRemoveRangeByScore(key string, r ScoreRange) (int, error) {
+	return removeZSetRange(key, func(zset *ZSet) []ZMember {
+		return zset.rangeByScore(r, false, 0, -1)
+	})
+}
+
+// ZSetRemoveRangeByLex removes the members in the lexicographic range r
+func ZSetRemoveRangeByLex(key string, r LexRange) (int, error) {
+	return removeZSetRange(key, func(zset *ZSet) []ZMember {
+		return zset.rangeByLex(r, false, 0, -1)
+	})
+}
@@ -0,0 +1,122 @@
+package glob
+
+// maxNesting bounds the recursion depth of '*' backtracking so pathological
+// patterns cannot blow the stack.
+const maxNesting = 1000
+
+// Match reports whether str matches the Redis-style glob pattern.
+//
+// Supported syntax mirrors Redis' stringmatchlen:
+//   - '*' matches any sequence of bytes (including none)
+//   - '?' matches exactly one byte
+//   - '[abc]', '[^abc]' and '[a-z]' match byte classes and ranges
+//   - '\' escapes the following byte, both inside and outside classes
+//
+// Malformed patterns (for example an unterminated '[') never produce an
+// error; they are matched leniently exactly like Redis does.
+func Match(pattern, str string) bool {
+	skipLongerMatches := false
+	return match(pattern, str, &skipLongerMatches, 0)
+}
+
+func match(pattern, str string, skipLongerMatches *bool, nesting int) bool {
+	if nesting > maxNesting {
+		return false
+	}
+
+	p, s := 0, 0
+	for p < len(pattern) && s < len(str) {
+		switch pattern[p] {
+		case '*':
+			for p+1 < len(pattern) && pattern[p+1] == '*' {
+				p++
+			}
+			if p == len(pattern)-1 {
+				return true
+			}
+			for s < len(str) {
+				if match(pattern[p+1:], str[s:], skipLongerMatches, nesting+1) {
+					return true
+				}
+				// A later '*' already failed against the whole remaining
+				// string, so trying longer matches here cannot succeed.
+				if *skipLongerMatches {
+					return false
+				}
+				s++
+			}
+			*skipLongerMatches = true
+			return false
+
+		case '?':
+			s++
+
+		case '[':
+			p++
+			negate := p < len(pattern) && pattern[p] == '^'
+			if negate {
+				p++
+			}
+
+			matched := false
+			for {
+				if p >= len(pattern) {
+					// Unterminated class: step back so the outer p++ lands
+					// exactly on the end of the pattern.
+					p--
+					break
+				}
+				if pattern[p] == '\\' && len(pattern)-p >= 2 {
+					p++
+					if pattern[p] == str[s] {
+						matched = true
+					}
+				} else if pattern[p] == ']' {
+					break
+				} else if len(pattern)-p >= 3 && pattern[p+1] == '-' {
+					start, end := pattern[p], pattern[p+2]
+					if start > end {
+						start, end = end, start
+					}
+					p += 2
+					if str[s] >= start && str[s] <= end {
+						matched = true
+					}
+				} else if pattern[p] == str[s] {
+					matched = true
+				}
+				p++
+			}
+
+			if negate {
+				matched = !matched
+			}
+			if !matched {
+				return false
+			}
+			s++
+
+		case '\\':
+			if len(pattern)-p >= 2 {
+				p++
+			}
+			fallthrough
+
+		default:
+			if pattern[p] != str[s] {
+				return false
+			}
+			s++
+		}
+
+		p++
+		if s == len(str) {
+			for p < len(pattern) && pattern[p] == '*' {
+				p++
+			}
+			break
+		}
+	}
+
+	return p == len(pattern) && s == len(str)
+}
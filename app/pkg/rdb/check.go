@@ -0,0 +1,126 @@
+package rdb
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/r0ld3x/redis-clone-go/app/pkg/database"
+)
+
+// crcTable is CRC-64/Jones in reflected form, the checksum Redis appends to
+// RDB files
+var crcTable = crc64.MakeTable(0x95AC9329AC4BC9B5)
+
+// checksum computes the Redis CRC-64 of data. Redis starts from zero with no
+// final XOR, whereas hash/crc64 inverts on the way in and out, so the
+// inversions are undone here.
+func checksum(data []byte) uint64 {
+	return ^crc64.Update(^uint64(0), crcTable, data)
+}
+
+// Report is the result of checking an RDB file
+type Report struct {
+	Version       string
+	Aux           [][2]string // AUX fields in file order
+	Databases     int
+	Keys          int
+	ExpiringKeys  int
+	Checksum      uint64 // Checksum stored in the file (0 when disabled)
+	Computed      uint64 // Checksum computed over the file contents
+	TrailingBytes int    // Bytes found after the checksum
+	Err           error  // First structural or checksum error, if any
+}
+
+// OK reports whether the file passed every check
+func (r *Report) OK() bool {
+	return r.Err == nil
+}
+
+// String renders the report in the style of redis-check-rdb
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[offset 0] Checking RDB file\n")
+	fmt.Fprintf(&b, "[info] RDB version %s\n", r.Version)
+	for _, aux := range r.Aux {
+		fmt.Fprintf(&b, "[info] AUX FIELD %s = '%s'\n", aux[0], aux[1])
+	}
+	fmt.Fprintf(&b, "[info] %d databases, %d keys read, %d expiring\n", r.Databases, r.Keys, r.ExpiringKeys)
+	if r.Checksum == 0 {
+		fmt.Fprintf(&b, "[info] RDB file was saved with checksum disabled: no check performed.\n")
+	} else {
+		fmt.Fprintf(&b, "[info] Checksum stored %016x, computed %016x\n", r.Checksum, r.Computed)
+	}
+	if r.TrailingBytes > 0 {
+		fmt.Fprintf(&b, "[warn] %d bytes of trailing data after the checksum\n", r.TrailingBytes)
+	}
+	if r.Err != nil {
+		fmt.Fprintf(&b, "--- RDB ERROR DETECTED ---\n%v\n", r.Err)
+	} else {
+		fmt.Fprintf(&b, "\\o/ RDB looks OK! \\o/\n")
+	}
+	return b.String()
+}
+
+// verifier is the Visitor that Check uses to collect a Report
+type verifier struct {
+	report *Report
+	ended  bool
+}
+
+func (v *verifier) Header(version string) { v.report.Version = version }
+
+func (v *verifier) Aux(key, val string) { v.report.Aux = append(v.report.Aux, [2]string{key, val}) }
+
+func (v *verifier) SelectDB(int) { v.report.Databases++ }
+
+func (v *verifier) ResizeDB(int, int) {}
+
+func (v *verifier) KeyValue(_ database.Record, expiryOpcode byte, _ uint64) {
+	v.report.Keys++
+	if expiryOpcode != 0 {
+		v.report.ExpiringKeys++
+	}
+}
+
+func (v *verifier) End(sum uint64) {
+	v.report.Checksum = sum
+	v.ended = true
+}
+
+// Check validates the structure and checksum of an RDB file without loading
+// it into the database
+func Check(filename string) (*Report, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return CheckBytes(data), nil
+}
+
+// CheckBytes is Check for an RDB file held in memory, such as the payload
+// of a full resync
+func CheckBytes(data []byte) *Report {
+	report := &Report{}
+	v := &verifier{report: report}
+	reader := bytes.NewReader(data)
+	if err := Walk(reader, v); err != nil {
+		report.Err = fmt.Errorf("at offset %d: %w", len(data)-reader.Len(), err)
+		return report
+	}
+	if !v.ended {
+		report.Err = io.ErrUnexpectedEOF
+		return report
+	}
+
+	report.TrailingBytes = reader.Len()
+	end := len(data) - reader.Len() - 8
+	report.Computed = checksum(data[:end])
+	if report.Checksum != 0 && report.Checksum != report.Computed {
+		report.Err = fmt.Errorf("wrong RDB checksum: expected %016x, got %016x", report.Checksum, report.Computed)
+	}
+	return report
+}
@@ -5,29 +5,54 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/pkg/database"
 )
 
 func readLength(r io.Reader) (int, error) {
+	n, err := readLength64(r)
+	return int(n), err
+}
+
+// readLength64 reads a length that may take 64 bits, such as the parts of a
+// stream ID
+func readLength64(r io.Reader) (uint64, error) {
 	b := make([]byte, 1)
 	if _, err := r.Read(b); err != nil {
 		return 0, err
 	}
 	switch b[0] >> 6 {
 	case 0b00:
-		return int(b[0] & 0x3F), nil
+		return uint64(b[0] & 0x3F), nil
 	case 0b01:
 		b2 := make([]byte, 1)
 		r.Read(b2)
-		return ((int(b[0] & 0x3F)) << 8) | int(b2[0]), nil
+		return ((uint64(b[0] & 0x3F)) << 8) | uint64(b2[0]), nil
 	case 0b10:
+		if b[0] == 0x81 {
+			b8 := make([]byte, 8)
+			_, err := io.ReadFull(r, b8)
+			return binary.BigEndian.Uint64(b8), err
+		}
 		b4 := make([]byte, 4)
 		r.Read(b4)
-		return int(binary.BigEndian.Uint32(b4)), nil
+		return uint64(binary.BigEndian.Uint32(b4)), nil
 	default:
 		return 0, errors.New("unsupported length encoding (0b11)")
 	}
 }
 
+// readMillis reads a unix time in milliseconds stored in 8 bytes
+func readMillis(r io.Reader) (time.Time, error) {
+	b := make([]byte, 8)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(int64(binary.LittleEndian.Uint64(b))), nil
+}
+
 func readString(r io.Reader) (string, error) {
 	b := make([]byte, 1)
 	if _, err := r.Read(b); err != nil {
@@ -77,3 +102,140 @@ func readString(r io.Reader) (string, error) {
 		return "", errors.New("invalid string encoding prefix")
 	}
 }
+
+// Value types this package reads and writes. Lists, sets and hashes use
+// the plain encodings, which Redis still loads, rather than the compact
+// ones it writes itself.
+const (
+	typeString       byte = 0
+	typeList         byte = 1
+	typeSet          byte = 2
+	typeHash         byte = 4
+	typeZSet2        byte = 5  // Sorted set with binary scores
+	typeStream3      byte = 21 // Stream in listpacks, with consumer groups
+	typeHashMetadata byte = 24 // Hash with field TTLs
+)
+
+// typeNames maps the value types to the type names of database.Record
+var typeNames = map[byte]string{
+	typeString:       "string",
+	typeList:         "list",
+	typeSet:          "set",
+	typeHash:         "hash",
+	typeZSet2:        "zset",
+	typeStream3:      "stream",
+	typeHashMetadata: "hash",
+}
+
+// readEntry reads the key and value of an entry of type typ
+func readEntry(r io.Reader, typ byte) (database.Record, error) {
+	key, err := readString(r)
+	if err != nil {
+		return database.Record{}, err
+	}
+	rec := database.Record{Key: key, Type: typeNames[typ]}
+	switch typ {
+	case typeString:
+		rec.String, err = readString(r)
+		return rec, err
+	case typeStream3:
+		rec.Stream, err = readStream(r)
+		return rec, err
+	case typeHashMetadata:
+		return readHashMetadata(r, rec)
+	}
+
+	n, err := readLength(r)
+	if err != nil {
+		return rec, err
+	}
+	switch typ {
+	case typeList, typeSet:
+		rec.Elements, err = readStrings(r, n)
+	case typeHash:
+		rec.Pairs, err = readStrings(r, 2*n)
+	case typeZSet2:
+		rec.Members = make([]database.ZMember, n)
+		for i := range rec.Members {
+			if rec.Members[i].Member, err = readString(r); err != nil {
+				return rec, err
+			}
+			score := make([]byte, 8)
+			if _, err = io.ReadFull(r, score); err != nil {
+				return rec, err
+			}
+			rec.Members[i].Score = math.Float64frombits(binary.LittleEndian.Uint64(score))
+		}
+	}
+	return rec, err
+}
+
+// readHashMetadata reads a hash with field TTLs: the earliest TTL, then
+// each field and value after its TTL relative to that one, 0 for none
+func readHashMetadata(r io.Reader, rec database.Record) (database.Record, error) {
+	earliest, err := readMillis(r)
+	if err != nil {
+		return rec, err
+	}
+	n, err := readLength(r)
+	if err != nil {
+		return rec, err
+	}
+	rec.Pairs = make([]string, 0, 2*n)
+	for i := 0; i < n; i++ {
+		ttl, err := readLength64(r)
+		if err != nil {
+			return rec, err
+		}
+		pair, err := readStrings(r, 2)
+		if err != nil {
+			return rec, err
+		}
+		rec.Pairs = append(rec.Pairs, pair...)
+		if ttl != 0 {
+			if rec.FieldExpires == nil {
+				rec.FieldExpires = make(map[string]time.Time)
+			}
+			rec.FieldExpires[pair[0]] = earliest.Add(time.Duration(ttl-1) * time.Millisecond)
+		}
+	}
+	return rec, nil
+}
+
+func readStrings(r io.Reader, n int) ([]string, error) {
+	out := make([]string, n)
+	for i := range out {
+		s, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = s
+	}
+	return out, nil
+}
+
+func appendLength(dst []byte, n int) []byte {
+	return appendLength64(dst, uint64(n))
+}
+
+func appendLength64(dst []byte, n uint64) []byte {
+	switch {
+	case n < 1<<6:
+		return append(dst, byte(n))
+	case n < 1<<14:
+		return append(dst, 0x40|byte(n>>8), byte(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(dst, 0x80), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(dst, 0x81), n)
+	}
+}
+
+// appendMillis appends t as a unix time in milliseconds in 8 bytes
+func appendMillis(dst []byte, t time.Time) []byte {
+	return binary.LittleEndian.AppendUint64(dst, uint64(t.UnixMilli()))
+}
+
+func appendString(dst []byte, s string) []byte {
+	return append(appendLength(dst, len(s)), s...)
+}
@@ -0,0 +1,215 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+// listpack builds a listpack, the compact list Redis stores stream entries
+// in: a 4-byte total size and 2-byte element count, then each element as
+// its encoding followed by its length backwards, then 0xFF
+type listpack struct {
+	elements []byte
+	n        int
+}
+
+// appendInt adds n in the smallest integer encoding that holds it
+func (lp *listpack) appendInt(n int64) {
+	var e []byte
+	switch {
+	case n >= 0 && n <= 127:
+		e = []byte{byte(n)}
+	case n >= -4096 && n <= 4095:
+		u := uint16(n) & 0x1FFF
+		e = []byte{0xC0 | byte(u>>8), byte(u)}
+	case n >= -32768 && n <= 32767:
+		e = binary.LittleEndian.AppendUint16([]byte{0xF1}, uint16(n))
+	case n >= -1<<23 && n < 1<<23:
+		u := uint32(n)
+		e = []byte{0xF2, byte(u), byte(u >> 8), byte(u >> 16)}
+	case n >= -1<<31 && n < 1<<31:
+		e = binary.LittleEndian.AppendUint32([]byte{0xF3}, uint32(n))
+	default:
+		e = binary.LittleEndian.AppendUint64([]byte{0xF4}, uint64(n))
+	}
+	lp.add(e)
+}
+
+// appendString adds s as a string, even when it reads as a number, so it
+// comes back byte for byte
+func (lp *listpack) appendString(s string) {
+	var e []byte
+	switch n := len(s); {
+	case n < 1<<6:
+		e = []byte{0x80 | byte(n)}
+	case n < 1<<12:
+		e = []byte{0xE0 | byte(n>>8), byte(n)}
+	default:
+		e = binary.LittleEndian.AppendUint32([]byte{0xF0}, uint32(n))
+	}
+	lp.add(append(e, s...))
+}
+
+func (lp *listpack) add(e []byte) {
+	lp.elements = append(lp.elements, e...)
+	lp.elements = appendBacklen(lp.elements, len(e))
+	lp.n++
+}
+
+// appendBacklen appends n, the size of the element before it, so that it
+// can be read from its last byte back: 7 bits a byte, most significant
+// first, every byte but the first with the high bit set
+func appendBacklen(dst []byte, n int) []byte {
+	size := backlenSize(n)
+	for i := size - 1; i >= 0; i-- {
+		b := byte(n>>(7*i)) & 0x7F
+		if i < size-1 {
+			b |= 0x80
+		}
+		dst = append(dst, b)
+	}
+	return dst
+}
+
+// bytes returns the listpack with its header and terminator
+func (lp *listpack) bytes() []byte {
+	count := min(lp.n, 65535) // Unknown past what 16 bits hold
+	out := binary.LittleEndian.AppendUint32(nil, uint32(6+len(lp.elements)+1))
+	out = binary.LittleEndian.AppendUint16(out, uint16(count))
+	out = append(out, lp.elements...)
+	return append(out, 0xFF)
+}
+
+// backlenSize is the number of bytes the size of an element of n bytes
+// takes after it, with the bounds Redis uses
+func backlenSize(n int) int {
+	switch {
+	case n <= 127:
+		return 1
+	case n < 16383:
+		return 2
+	case n < 2097151:
+		return 3
+	case n < 268435455:
+		return 4
+	default:
+		return 5
+	}
+}
+
+var errListpack = errors.New("malformed listpack")
+
+// readListpack returns the elements of a listpack, integers in decimal
+func readListpack(data []byte) ([]string, error) {
+	if len(data) < 7 || int(binary.LittleEndian.Uint32(data)) != len(data) || data[len(data)-1] != 0xFF {
+		return nil, errListpack
+	}
+	var elements []string
+	end := len(data) - 1
+	for pos := 6; pos < end; {
+		b := data[pos]
+		header, size := 1, 0
+		switch {
+		case b&0x80 == 0:
+		case b&0xC0 == 0x80:
+			size = int(b & 0x3F)
+		case b&0xE0 == 0xC0:
+			header = 2
+		case b&0xF0 == 0xE0:
+			header = 2
+		case b == 0xF0:
+			header = 5
+		case b == 0xF1:
+			header = 3
+		case b == 0xF2:
+			header = 4
+		case b == 0xF3:
+			header = 5
+		case b == 0xF4:
+			header = 9
+		default:
+			return nil, errListpack
+		}
+		if pos+header > end {
+			return nil, errListpack
+		}
+		e := data[pos : pos+header]
+		var val string
+		switch {
+		case b&0x80 == 0:
+			val = strconv.Itoa(int(b))
+		case b&0xE0 == 0xC0:
+			n := int(b&0x1F)<<8 | int(e[1])
+			if n >= 1<<12 {
+				n -= 1 << 13
+			}
+			val = strconv.Itoa(n)
+		case b&0xF0 == 0xE0:
+			size = int(b&0x0F)<<8 | int(e[1])
+		case b == 0xF0:
+			size = int(binary.LittleEndian.Uint32(e[1:]))
+		case b == 0xF1:
+			val = strconv.Itoa(int(int16(binary.LittleEndian.Uint16(e[1:]))))
+		case b == 0xF2:
+			val = strconv.Itoa(int(int32(uint32(e[1])<<8|uint32(e[2])<<16|uint32(e[3])<<24) >> 8))
+		case b == 0xF3:
+			val = strconv.Itoa(int(int32(binary.LittleEndian.Uint32(e[1:]))))
+		case b == 0xF4:
+			val = strconv.FormatInt(int64(binary.LittleEndian.Uint64(e[1:])), 10)
+		}
+		if size < 0 || pos+header+size > end {
+			return nil, errListpack
+		}
+		if b&0xC0 == 0x80 || b&0xF0 == 0xE0 || b == 0xF0 {
+			val = string(data[pos+header : pos+header+size])
+		}
+		elements = append(elements, val)
+		pos += header + size + backlenSize(header+size)
+	}
+	return elements, nil
+}
+
+// listpackReader hands out the elements of a listpack in order. The first
+// missing or malformed element sets err, after which it returns zeros.
+type listpackReader struct {
+	elements []string
+	err      error
+}
+
+func (lp *listpackReader) string() string {
+	if lp.err != nil {
+		return ""
+	}
+	if len(lp.elements) == 0 {
+		lp.err = errListpack
+		return ""
+	}
+	s := lp.elements[0]
+	lp.elements = lp.elements[1:]
+	return s
+}
+
+func (lp *listpackReader) int() int64 {
+	s := lp.string()
+	if lp.err != nil {
+		return 0
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		lp.err = errListpack
+	}
+	return n
+}
+
+func (lp *listpackReader) strings(n int) []string {
+	if n < 0 || n > len(lp.elements) {
+		lp.err = errListpack
+		return nil
+	}
+	out := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		out = append(out, lp.string())
+	}
+	return out
+}
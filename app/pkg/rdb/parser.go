@@ -1,3 +1,7 @@
+// Package rdb reads and writes RDB files, the snapshots Redis persists and
+// a master sends a replica for a full resync. Walk decodes a file into its
+// records, Load and ParseRDB load one into the database, Encode writes the
+// database as one, and Check validates one for --check-rdb.
 package rdb
 
 import (
@@ -11,25 +15,34 @@ import (
 	"github.com/r0ld3x/redis-clone-go/app/pkg/database"
 )
 
-func ParseRDB(filename string) error {
-	file, err := os.Open(filename)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
+// Visitor receives the records of an RDB file as Walk decodes them
+type Visitor interface {
+	Header(version string)
+	Aux(key, val string)
+	SelectDB(index int)
+	ResizeDB(keys, expires int)
+	// KeyValue is called for each key, with its value in rec and ExpireAt
+	// left zero. expiry is the raw expire value that preceded the entry
+	// (unix seconds for 0xFD, milliseconds for 0xFC) and expiryOpcode is 0
+	// when the key does not expire.
+	KeyValue(rec database.Record, expiryOpcode byte, expiry uint64)
+	// End is called with the trailing checksum once the EOF opcode is read
+	End(checksum uint64)
+}
 
+// Walk decodes an RDB stream and reports each record to v
+func Walk(r io.Reader, v Visitor) error {
 	data := make([]byte, 9)
-	if _, err := io.ReadFull(file, data); err != nil {
+	if _, err := io.ReadFull(r, data); err != nil {
 		return err
 	}
 	if string(data[:5]) != "REDIS" {
 		return errors.New("invalid RDB file: missing REDIS header")
 	}
-	version := string(data[5:])
-	fmt.Println("RDB Version:", version)
+	v.Header(string(data[5:]))
 	for {
 		prefix := make([]byte, 1)
-		_, err := file.Read(prefix)
+		_, err := r.Read(prefix)
 		if err == io.EOF {
 			break
 		} else if err != nil {
@@ -37,88 +50,122 @@ func ParseRDB(filename string) error {
 		}
 		switch prefix[0] {
 		case 0xFA:
-			key, _ := readString(file)
-			val, _ := readString(file)
-			fmt.Printf("[Metadata] %s: %s\n", key, val)
+			key, _ := readString(r)
+			val, _ := readString(r)
+			v.Aux(key, val)
 
 		case 0xFE:
-			dbIndex, _ := readLength(file)
-			fmt.Printf("\n[Database] Selected DB: %d\n", dbIndex)
+			dbIndex, _ := readLength(r)
+			v.SelectDB(dbIndex)
 
 		case 0xFB:
-			kvs, _ := readLength(file)
-			exp, _ := readLength(file)
-			fmt.Printf("[Database] KV Entries: %d, Expiring: %d\n", kvs, exp)
-
-		case 0x00:
-			key, _ := readString(file)
-			val, _ := readString(file)
-			// fmt.Printf("[SET] %s = %s (ex %d)\n", key, val, -1)
-			database.SetKey(key, val, -1)
-
-		case 0xFD:
-			expTime := make([]byte, 4)
-			if _, err := io.ReadFull(file, expTime); err != nil {
-				return err
-			}
-			secs := binary.LittleEndian.Uint32(expTime)
-			fmt.Printf("[Expire] Raw 0xFD: %d (unix seconds)\n", secs)
-
-			nextType := make([]byte, 1)
-			if _, err := file.Read(nextType); err != nil {
-				return err
-			}
+			kvs, _ := readLength(r)
+			exp, _ := readLength(r)
+			v.ResizeDB(kvs, exp)
 
-			switch nextType[0] {
-			case 0x00:
-				key, _ := readString(file)
-				val, _ := readString(file)
-				// fmt.Printf("[Entry] Expiring key: %s = %s (ex %d)\n", key, val, secs)
-				expireTime := time.Unix(int64(secs), 0)
-				if !time.Now().After(expireTime) {
-					database.SetKey(key, val, int(secs))
-				}
-			default:
-				return fmt.Errorf("unexpected type after expire: 0x%X", nextType[0])
+		case 0xFD, 0xFC:
+			size := 4
+			if prefix[0] == 0xFC {
+				size = 8
 			}
-
-		case 0xFC:
 			expTime := make([]byte, 8)
-			if _, err := io.ReadFull(file, expTime); err != nil {
+			if _, err := io.ReadFull(r, expTime[:size]); err != nil {
 				return err
 			}
 			expiry := binary.LittleEndian.Uint64(expTime)
-			fmt.Printf("[Expire] Raw expiry: %d\n", expiry)
 
 			// Read type of the next entry
 			nextType := make([]byte, 1)
-			if _, err := file.Read(nextType); err != nil {
+			if _, err := r.Read(nextType); err != nil {
 				return err
 			}
 
-			switch nextType[0] {
-			case 0x00:
-				key, _ := readString(file)
-				val, _ := readString(file)
-				fmt.Printf("[Entry] Expiring key: %s = %s (px %d)\n", key, val, expiry)
-				expireTime := time.UnixMilli(int64(expiry))
-				isExpired := time.Now().After(expireTime)
-				if !isExpired {
-					database.SetKey(key, val, int(expiry))
-				}
-			default:
+			if _, ok := typeNames[nextType[0]]; !ok {
 				return fmt.Errorf("unexpected type after expire: 0x%X", nextType[0])
 			}
+			rec, err := readEntry(r, nextType[0])
+			if err != nil {
+				return err
+			}
+			v.KeyValue(rec, prefix[0], expiry)
 
 		case 0xFF:
 			checksum := make([]byte, 8)
-			file.Read(checksum)
-			fmt.Println("[EOF] RDB file finished.")
+			if _, err := io.ReadFull(r, checksum); err != nil {
+				return fmt.Errorf("truncated checksum: %w", err)
+			}
+			v.End(binary.LittleEndian.Uint64(checksum))
 			return nil
 
 		default:
-			return fmt.Errorf("unknown opcode: 0x%X", prefix[0])
+			if _, ok := typeNames[prefix[0]]; !ok {
+				return fmt.Errorf("unknown opcode: 0x%X", prefix[0])
+			}
+			rec, err := readEntry(r, prefix[0])
+			if err != nil {
+				return err
+			}
+			v.KeyValue(rec, 0, 0)
 		}
 	}
 	return nil
 }
+
+// loader is the Visitor that ParseRDB uses to populate the database
+type loader struct {
+	aux map[string]string
+}
+
+func (loader) Header(version string) {
+	fmt.Println("RDB Version:", version)
+}
+
+func (l loader) Aux(key, val string) {
+	fmt.Printf("[Metadata] %s: %s\n", key, val)
+	l.aux[key] = val
+}
+
+func (loader) SelectDB(index int) {
+	fmt.Printf("\n[Database] Selected DB: %d\n", index)
+}
+
+func (loader) ResizeDB(keys, expires int) {
+	fmt.Printf("[Database] KV Entries: %d, Expiring: %d\n", keys, expires)
+}
+
+func (loader) KeyValue(rec database.Record, expiryOpcode byte, expiry uint64) {
+	switch expiryOpcode {
+	case 0xFD:
+		fmt.Printf("[Expire] Raw 0xFD: %d (unix seconds)\n", expiry)
+		rec.ExpireAt = time.Unix(int64(expiry), 0)
+	case 0xFC:
+		fmt.Printf("[Entry] Expiring key: %s (px %d)\n", rec.Key, expiry)
+		rec.ExpireAt = time.UnixMilli(int64(expiry))
+	}
+	if !rec.ExpireAt.IsZero() && time.Now().After(rec.ExpireAt) {
+		return
+	}
+	database.Restore(rec)
+}
+
+func (loader) End(uint64) {
+	fmt.Println("[EOF] RDB file finished.")
+}
+
+// ParseRDB loads an RDB file into the database and returns its aux fields
+func ParseRDB(filename string) (map[string]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	l := loader{aux: make(map[string]string)}
+	return l.aux, Walk(file, l)
+}
+
+// Load populates the database from an RDB stream, such as the payload a
+// master sends for a full resync
+func Load(r io.Reader) error {
+	return Walk(r, loader{aux: make(map[string]string)})
+}
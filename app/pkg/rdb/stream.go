@@ -0,0 +1,399 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/pkg/database"
+)
+
+// Streams are written the way Redis 7 writes them: the entries in
+// listpacks of up to streamNodeEntries, each opening with a master entry
+// that holds the field names of its first entry, then the IDs of the
+// stream and its consumer groups with their pending entries.
+
+// streamNodeEntries is the number of entries per listpack, Redis's default
+// stream-node-max-entries
+const streamNodeEntries = 100
+
+// Flags of an entry in a stream listpack
+const (
+	streamItemDeleted    = 1 // Deleted by XDEL, still taking up its place
+	streamItemSameFields = 2 // Has the master entry's fields, stored once there
+)
+
+// streamID is a stream ID as the RDB stores it
+type streamID struct {
+	ms, seq uint64
+}
+
+// parseID parses a "<ms>-<seq>" ID, 0-0 when it isn't one
+func parseID(id string) streamID {
+	ms, seq, _ := strings.Cut(id, "-")
+	parsed := streamID{}
+	parsed.ms, _ = strconv.ParseUint(ms, 10, 64)
+	parsed.seq, _ = strconv.ParseUint(seq, 10, 64)
+	return parsed
+}
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+// appendID appends an ID as two lengths
+func appendID(dst []byte, id streamID) []byte {
+	return appendLength64(appendLength64(dst, id.ms), id.seq)
+}
+
+// appendRawID appends an ID as the 16 big-endian bytes of a rax key
+func appendRawID(dst []byte, id streamID) []byte {
+	return binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(dst, id.ms), id.seq)
+}
+
+func readID(r io.Reader) (streamID, error) {
+	ms, err := readLength64(r)
+	if err != nil {
+		return streamID{}, err
+	}
+	seq, err := readLength64(r)
+	return streamID{ms, seq}, err
+}
+
+func readRawID(r io.Reader) (streamID, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return streamID{}, err
+	}
+	return streamID{binary.BigEndian.Uint64(b), binary.BigEndian.Uint64(b[8:])}, nil
+}
+
+// sortedIDs returns the IDs of a PEL in ID order, the order of the rax
+// Redis keeps it in
+func sortedIDs(pending map[string]*database.PendingEntry) []string {
+	ids := make([]string, 0, len(pending))
+	for id := range pending {
+		ids = append(ids, id)
+	}
+	slices.SortFunc(ids, func(a, b string) int {
+		x, y := parseID(a), parseID(b)
+		if x.ms != y.ms {
+			return compareUint(x.ms, y.ms)
+		}
+		return compareUint(x.seq, y.seq)
+	})
+	return ids
+}
+
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// appendStream appends a stream as RDB_TYPE_STREAM_LISTPACKS_3. IDs are
+// written as lengths, times in milliseconds in 8 bytes. What this server
+// doesn't track is written as Redis would for a stream it knows no more
+// of: no deleted entry, as many entries added as there are left, and an
+// unknown number of entries read by each group.
+func appendStream(dst []byte, stream *database.Stream) []byte {
+	entries := stream.Entries
+	dst = appendLength(dst, (len(entries)+streamNodeEntries-1)/streamNodeEntries)
+	for start := 0; start < len(entries); start += streamNodeEntries {
+		node := entries[start:min(start+streamNodeEntries, len(entries))]
+		master := parseID(node[0].ID)
+		dst = appendString(dst, string(appendRawID(nil, master)))
+		dst = appendString(dst, string(streamNode(master, node)))
+	}
+
+	var first streamID
+	if len(entries) > 0 {
+		first = parseID(entries[0].ID)
+	}
+	dst = appendLength(dst, len(entries))
+	dst = appendID(dst, parseID(stream.LastID))
+	dst = appendID(dst, first)
+	dst = appendID(dst, streamID{}) // Most recent deleted ID
+	dst = appendLength(dst, len(entries))
+
+	names := make([]string, 0, len(stream.Groups))
+	for name := range stream.Groups {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	dst = appendLength(dst, len(names))
+	for _, name := range names {
+		g := stream.Groups[name]
+		dst = appendString(dst, name)
+		dst = appendID(dst, parseID(g.LastDeliveredID))
+		dst = appendLength(dst, -1) // Entries read, unknown
+
+		ids := sortedIDs(g.Pending)
+		dst = appendLength(dst, len(ids))
+		for _, id := range ids {
+			p := g.Pending[id]
+			dst = appendRawID(dst, parseID(id))
+			dst = appendMillis(dst, p.DeliveryTime)
+			dst = appendLength(dst, p.DeliveryCount)
+		}
+
+		consumers := make([]string, 0, len(g.Consumers))
+		for consumer := range g.Consumers {
+			consumers = append(consumers, consumer)
+		}
+		slices.Sort(consumers)
+		dst = appendLength(dst, len(consumers))
+		for _, consumer := range consumers {
+			c := g.Consumers[consumer]
+			dst = appendString(dst, c.Name)
+			dst = appendMillis(dst, c.SeenTime) // Seen
+			dst = appendMillis(dst, c.SeenTime) // Active
+			ids := sortedIDs(c.Pending)
+			dst = appendLength(dst, len(ids))
+			for _, id := range ids {
+				dst = appendRawID(dst, parseID(id))
+			}
+		}
+	}
+	return dst
+}
+
+// streamNode returns the listpack of the entries of node, whose IDs are
+// stored relative to master
+func streamNode(master streamID, node []database.StreamEntry) []byte {
+	var lp listpack
+	fields := fieldNames(node[0])
+	lp.appendInt(int64(len(node)))
+	lp.appendInt(0) // Deleted entries
+	lp.appendInt(int64(len(fields)))
+	for _, field := range fields {
+		lp.appendString(field)
+	}
+	lp.appendInt(0) // End of the master entry
+
+	for _, entry := range node {
+		id := parseID(entry.ID)
+		n := len(entry.Fields) / 2
+		same := slices.Equal(fieldNames(entry), fields)
+		flags := int64(0)
+		if same {
+			flags = streamItemSameFields
+		}
+		lp.appendInt(flags)
+		lp.appendInt(int64(id.ms - master.ms))
+		lp.appendInt(int64(id.seq - master.seq))
+		if same {
+			for i := 1; i < len(entry.Fields); i += 2 {
+				lp.appendString(entry.Fields[i])
+			}
+			lp.appendInt(int64(3 + n))
+			continue
+		}
+		lp.appendInt(int64(n))
+		for _, s := range entry.Fields {
+			lp.appendString(s)
+		}
+		lp.appendInt(int64(3 + 2*n + 1))
+	}
+	return lp.bytes()
+}
+
+func fieldNames(entry database.StreamEntry) []string {
+	names := make([]string, 0, len(entry.Fields)/2)
+	for i := 0; i+1 < len(entry.Fields); i += 2 {
+		names = append(names, entry.Fields[i])
+	}
+	return names
+}
+
+// readStream reads a stream written by appendStream, or by Redis 7
+func readStream(r io.Reader) (*database.Stream, error) {
+	stream := database.NewStream()
+	nodes, err := readLength(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < nodes; i++ {
+		key, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(key) != 16 {
+			return nil, errors.New("stream node key is not a stream ID")
+		}
+		master, _ := readRawID(strings.NewReader(key))
+		data, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		elements, err := readListpack([]byte(data))
+		if err != nil {
+			return nil, err
+		}
+		if stream.Entries, err = readStreamNode(stream.Entries, master, elements); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := readLength(r); err != nil { // Number of entries
+		return nil, err
+	}
+	last, err := readID(r)
+	if err != nil {
+		return nil, err
+	}
+	stream.LastID = last.String()
+	// First ID, most recent deleted ID and entries added, which this
+	// server doesn't keep
+	if _, err := readID(r); err != nil {
+		return nil, err
+	}
+	if _, err := readID(r); err != nil {
+		return nil, err
+	}
+	if _, err := readLength64(r); err != nil {
+		return nil, err
+	}
+
+	groups, err := readLength(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < groups; i++ {
+		name, g, err := readConsumerGroup(r)
+		if err != nil {
+			return nil, err
+		}
+		if stream.Groups == nil {
+			stream.Groups = make(map[string]*database.ConsumerGroup)
+		}
+		stream.Groups[name] = g
+	}
+	return stream, nil
+}
+
+// readStreamNode appends the live entries of a stream listpack to entries
+func readStreamNode(entries []database.StreamEntry, master streamID, elements []string) ([]database.StreamEntry, error) {
+	lp := &listpackReader{elements: elements}
+	count, deleted := lp.int(), lp.int()
+	fields := lp.strings(int(lp.int()))
+	lp.int() // End of the master entry
+
+	for i := int64(0); i < count+deleted && lp.err == nil; i++ {
+		flags := lp.int()
+		id := streamID{master.ms + uint64(lp.int()), master.seq + uint64(lp.int())}
+		var pairs []string
+		if flags&streamItemSameFields != 0 {
+			pairs = make([]string, 0, 2*len(fields))
+			for _, field := range fields {
+				pairs = append(pairs, field, lp.string())
+			}
+		} else {
+			pairs = lp.strings(2 * int(lp.int()))
+		}
+		lp.int() // Number of elements of the entry
+		if flags&streamItemDeleted != 0 {
+			continue
+		}
+		entries = append(entries, database.StreamEntry{
+			ID:     id.String(),
+			Fields: pairs,
+			Time:   time.UnixMilli(int64(id.ms)),
+		})
+	}
+	if lp.err != nil {
+		return nil, fmt.Errorf("stream listpack: %w", lp.err)
+	}
+	return entries, nil
+}
+
+// readConsumerGroup reads a consumer group with its PEL and consumers,
+// each of whose pending entries must be in the group's PEL
+func readConsumerGroup(r io.Reader) (string, *database.ConsumerGroup, error) {
+	name, err := readString(r)
+	if err != nil {
+		return "", nil, err
+	}
+	last, err := readID(r)
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := readLength64(r); err != nil { // Entries read
+		return "", nil, err
+	}
+	g := &database.ConsumerGroup{
+		LastDeliveredID: last.String(),
+		Pending:         make(map[string]*database.PendingEntry),
+		Consumers:       make(map[string]*database.Consumer),
+	}
+
+	n, err := readLength(r)
+	if err != nil {
+		return "", nil, err
+	}
+	for i := 0; i < n; i++ {
+		id, err := readRawID(r)
+		if err != nil {
+			return "", nil, err
+		}
+		delivered, err := readMillis(r)
+		if err != nil {
+			return "", nil, err
+		}
+		count, err := readLength(r)
+		if err != nil {
+			return "", nil, err
+		}
+		g.Pending[id.String()] = &database.PendingEntry{ID: id.String(), DeliveryTime: delivered, DeliveryCount: count}
+	}
+
+	if n, err = readLength(r); err != nil {
+		return "", nil, err
+	}
+	for i := 0; i < n; i++ {
+		consumerName, err := readString(r)
+		if err != nil {
+			return "", nil, err
+		}
+		seen, err := readMillis(r)
+		if err != nil {
+			return "", nil, err
+		}
+		if _, err := readMillis(r); err != nil { // Active
+			return "", nil, err
+		}
+		c := &database.Consumer{Name: consumerName, SeenTime: seen, Pending: make(map[string]*database.PendingEntry)}
+		pending, err := readLength(r)
+		if err != nil {
+			return "", nil, err
+		}
+		for j := 0; j < pending; j++ {
+			id, err := readRawID(r)
+			if err != nil {
+				return "", nil, err
+			}
+			p := g.Pending[id.String()]
+			if p == nil {
+				return "", nil, fmt.Errorf("consumer %s has pending entry %s, which its group does not", consumerName, id)
+			}
+			p.Consumer = c
+			c.Pending[p.ID] = p
+		}
+		g.Consumers[consumerName] = c
+	}
+	for id, p := range g.Pending {
+		if p.Consumer == nil {
+			return "", nil, fmt.Errorf("pending entry %s of group %s has no consumer", id, name)
+		}
+	}
+	return name, g, nil
+}
@@ -0,0 +1,121 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/pkg/database"
+)
+
+// Version is the RDB version Encode writes
+const Version = "0012"
+
+// typeCodes maps the type names of database.Record to the value types
+var typeCodes = map[string]byte{
+	"string": typeString,
+	"list":   typeList,
+	"set":    typeSet,
+	"hash":   typeHash,
+	"zset":   typeZSet2,
+	"stream": typeStream3,
+}
+
+// Encode returns the database as an RDB file. It reads a database.Snapshot,
+// so writes carry on meanwhile and the file holds the keys as they were
+// when it was pinned, at which point pinned is called if not nil. aux
+// fields are written after the standard ones.
+func Encode(pinned func(), aux ...[2]string) []byte {
+	var body []byte
+	keys, expires := 0, 0
+	database.Records(pinned, func(rec database.Record) bool {
+		typ, ok := typeCodes[rec.Type]
+		if !ok {
+			return true
+		}
+		if typ == typeHash && len(rec.FieldExpires) > 0 {
+			typ = typeHashMetadata
+		}
+		if !rec.ExpireAt.IsZero() {
+			body = append(body, 0xFC)
+			body = binary.LittleEndian.AppendUint64(body, uint64(rec.ExpireAt.UnixMilli()))
+			expires++
+		}
+		body = appendEntry(append(body, typ), rec)
+		keys++
+		return true
+	})
+
+	data := []byte("REDIS" + Version)
+	data = appendAux(data, "redis-ver", "7.4.0")
+	data = appendAux(data, "redis-bits", "64")
+	data = appendAux(data, "ctime", strconv.FormatInt(time.Now().Unix(), 10))
+	for _, field := range aux {
+		data = appendAux(data, field[0], field[1])
+	}
+	data = appendLength(append(data, 0xFE), 0)
+	data = appendLength(appendLength(append(data, 0xFB), keys), expires)
+	data = append(data, body...)
+	data = append(data, 0xFF)
+	return binary.LittleEndian.AppendUint64(data, checksum(data))
+}
+
+func appendAux(dst []byte, key, val string) []byte {
+	return appendString(appendString(append(dst, 0xFA), key), val)
+}
+
+// appendEntry appends the key and value of rec, after its type
+func appendEntry(dst []byte, rec database.Record) []byte {
+	dst = appendString(dst, rec.Key)
+	switch rec.Type {
+	case "string":
+		return appendString(dst, rec.String)
+	case "list", "set":
+		dst = appendLength(dst, len(rec.Elements))
+		for _, element := range rec.Elements {
+			dst = appendString(dst, element)
+		}
+	case "hash":
+		if len(rec.FieldExpires) > 0 {
+			return appendHashMetadata(dst, rec)
+		}
+		dst = appendLength(dst, len(rec.Pairs)/2)
+		for _, s := range rec.Pairs {
+			dst = appendString(dst, s)
+		}
+	case "zset":
+		dst = appendLength(dst, len(rec.Members))
+		for _, m := range rec.Members {
+			dst = appendString(dst, m.Member)
+			dst = binary.LittleEndian.AppendUint64(dst, math.Float64bits(m.Score))
+		}
+	case "stream":
+		return appendStream(dst, rec.Stream)
+	}
+	return dst
+}
+
+// appendHashMetadata appends a hash with field TTLs as Redis 7.4 does: the
+// earliest TTL, then each field and value after its TTL in milliseconds
+// past the earliest plus one, 0 for a field without one
+func appendHashMetadata(dst []byte, rec database.Record) []byte {
+	var earliest time.Time
+	for _, at := range rec.FieldExpires {
+		if earliest.IsZero() || at.Before(earliest) {
+			earliest = at
+		}
+	}
+	earliest = earliest.Truncate(time.Millisecond)
+	dst = appendMillis(dst, earliest)
+	dst = appendLength(dst, len(rec.Pairs)/2)
+	for i := 0; i+1 < len(rec.Pairs); i += 2 {
+		var ttl uint64
+		if at, volatile := rec.FieldExpires[rec.Pairs[i]]; volatile {
+			ttl = uint64(at.Sub(earliest).Milliseconds()) + 1
+		}
+		dst = appendLength64(dst, ttl)
+		dst = appendString(appendString(dst, rec.Pairs[i]), rec.Pairs[i+1])
+	}
+	return dst
+}
@@ -0,0 +1,134 @@
+package rdb
+
+import (
+	"bytes"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/r0ld3x/redis-clone-go/app/pkg/database"
+)
+
+func TestEncodeRoundTrip(t *testing.T) {
+	database.Flush()
+	database.SetKey("string", "value", -1)
+	at := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	database.SetKeyAt("volatile", "value", at)
+	database.RPushAdd("list", "a", "b", "c")
+	database.SetAdd("set", []string{"x"})
+	database.HashSet("hash", []string{"field", "value"})
+	database.ZSetAdd("zset", []database.ZMember{{Member: "one", Score: 1}, {Member: "half", Score: 0.5}}, database.ZAddFlags{})
+	database.Expire("list", at, "")
+
+	data := Encode(nil)
+	if report := CheckBytes(data); !report.OK() || report.Keys != 6 || report.ExpiringKeys != 2 {
+		t.Fatalf("Check: %s", report)
+	}
+
+	database.Flush()
+	if err := Load(bytes.NewReader(data)); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if v, _ := database.GetKey("string"); v != "value" {
+		t.Errorf("string = %q, want value", v)
+	}
+	if ttl := database.KeyTTL("volatile"); ttl <= 0 || ttl > time.Hour.Milliseconds() {
+		t.Errorf("TTL of volatile = %dms, want up to an hour", ttl)
+	}
+	if ttl := database.KeyTTL("list"); ttl <= 0 {
+		t.Errorf("TTL of list = %dms, want up to an hour", ttl)
+	}
+	if list, _ := database.LRange("list", 0, -1); !slices.Equal(list, []string{"a", "b", "c"}) {
+		t.Errorf("list = %v, want [a b c]", list)
+	}
+	if ok, _ := database.SetIsMember("set", "x"); !ok {
+		t.Error("set lost x")
+	}
+	if v, ok, _ := database.HashGet("hash", "field"); !ok || v != "value" {
+		t.Errorf("hash field = %q, want value", v)
+	}
+	members, _ := database.ZSetRangeByRank("zset", 0, -1, false)
+	if want := []database.ZMember{{Member: "half", Score: 0.5}, {Member: "one", Score: 1}}; !slices.Equal(members, want) {
+		t.Errorf("zset = %v, want %v", members, want)
+	}
+}
+
+func TestEncodeStreamsAndFieldTTLs(t *testing.T) {
+	database.Flush()
+	// Enough entries for several listpacks, some with the fields of the
+	// first, some with others, values that read as numbers, and sequence
+	// numbers that go down from the first ID of a listpack to later ones
+	for i := 0; i < 250; i++ {
+		fields := []string{"n", strconv.Itoa(i), "pad", "007"}
+		if i%7 == 0 {
+			fields = []string{"other", string(bytes.Repeat([]byte("x"), 5000))}
+		}
+		id := strconv.Itoa(1700000000000+i/3) + "-" + strconv.Itoa(1<<40+i%3)
+		if _, err := database.StreamAdd("stream", id, fields, database.StreamLimits{}, true); err != nil {
+			t.Fatalf("XADD %s: %v", id, err)
+		}
+	}
+	database.StreamDelete("stream", []string{"1700000000000-1099511627776"})
+	database.GroupCreate("stream", "readers", "0", false)
+	database.GroupCreate("stream", "idle", "$", false)
+	database.GroupReadNew("stream", "readers", "alice", 3, false)
+	database.GroupReadNew("stream", "readers", "bob", 2, false)
+	database.GroupCreateConsumer("stream", "idle", "carol")
+	database.GroupCreate("empty", "readers", "$", true)
+
+	database.HashSet("hash", []string{"volatile", "1", "later", "2", "kept", "3"})
+	soon := time.Now().Add(time.Hour)
+	database.HashExpireFields("hash", soon, "", []string{"volatile"})
+	database.HashExpireFields("hash", soon.Add(time.Minute), "", []string{"later"})
+
+	want := map[string]string{}
+	for _, key := range []string{"stream", "empty", "hash"} {
+		want[key] = database.DigestValue(key)
+	}
+	data := Encode(nil)
+	if report := CheckBytes(data); !report.OK() || report.Keys != 3 {
+		t.Fatalf("Check: %s", report)
+	}
+
+	database.Flush()
+	if err := Load(bytes.NewReader(data)); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	for key, digest := range want {
+		if got := database.DigestValue(key); got != digest {
+			t.Errorf("%s loaded with digest %s, want %s", key, got, digest)
+		}
+	}
+	if id, _ := database.StreamAdd("stream", "1700000000083-*", []string{"n", "next"}, database.StreamLimits{}, false); id != "1700000000083-1099511627777" {
+		t.Errorf("XADD after the load assigned %s, want the ID after the last one", id)
+	}
+	summary, _ := database.GroupPendingSummary("stream", "readers")
+	if summary.Count != 5 {
+		t.Errorf("readers have %d pending entries, want 5", summary.Count)
+	}
+	ttls, _ := database.HashFieldTTLs("hash", []string{"volatile", "later", "kept"})
+	if ttls[0] < 59*time.Minute.Milliseconds() || ttls[1] <= ttls[0] || ttls[2] != database.FieldNoTTL {
+		t.Errorf("field TTLs after the load = %v, want about an hour, a minute more and none", ttls)
+	}
+}
+
+func TestListpackIntegers(t *testing.T) {
+	var lp listpack
+	ints := []int64{0, 127, 128, -1, 4095, -4096, 4096, 32767, -32768, 1 << 20, -1 << 23, 1<<31 - 1, -1 << 31, 1 << 40, -1 << 63}
+	for _, n := range ints {
+		lp.appendInt(n)
+	}
+	lp.appendString("12")
+	elements, err := readListpack(lp.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make([]string, 0, len(ints)+1)
+	for _, n := range ints {
+		want = append(want, strconv.FormatInt(n, 10))
+	}
+	if want = append(want, "12"); !slices.Equal(elements, want) {
+		t.Errorf("listpack read back %v, want %v", elements, want)
+	}
+}
@@ -0,0 +1,83 @@
+// Package sample provides the random selection helpers behind the
+// *RANDFIELD / *RANDMEMBER / SPOP family of commands.
+package sample
+
+import "math/rand"
+
+// Reservoir keeps a uniform random sample of at most k items from a stream
+// of unknown length (Algorithm R). It lets callers sample straight out of a
+// map without first copying every key into a slice.
+type Reservoir struct {
+	k     int
+	seen  int
+	items []string
+}
+
+// NewReservoir creates a reservoir that retains up to k items
+func NewReservoir(k int) *Reservoir {
+	if k < 0 {
+		k = 0
+	}
+	return &Reservoir{k: k, items: make([]string, 0, k)}
+}
+
+// Offer presents the next item of the stream to the reservoir
+func (r *Reservoir) Offer(item string) {
+	r.seen++
+	if len(r.items) < r.k {
+		r.items = append(r.items, item)
+		return
+	}
+	if j := rand.Intn(r.seen); j < r.k {
+		r.items[j] = item
+	}
+}
+
+// Items returns the sampled items in random order
+func (r *Reservoir) Items() []string {
+	rand.Shuffle(len(r.items), func(i, j int) { r.items[i], r.items[j] = r.items[j], r.items[i] })
+	return r.items
+}
+
+// Distinct returns k distinct indexes in [0, n) in random order. k is capped
+// at n. Small samples use Floyd's algorithm, which costs O(k) regardless of
+// n; large ones fall back to a partial Fisher-Yates shuffle.
+func Distinct(n, k int) []int {
+	if k > n {
+		k = n
+	}
+	if k <= 0 {
+		return []int{}
+	}
+
+	if k > n/2 {
+		perm := rand.Perm(n)
+		return perm[:k]
+	}
+
+	chosen := make(map[int]struct{}, k)
+	result := make([]int, 0, k)
+	for j := n - k; j < n; j++ {
+		t := rand.Intn(j + 1)
+		if _, dup := chosen[t]; dup {
+			t = j
+		}
+		chosen[t] = struct{}{}
+		result = append(result, t)
+	}
+	rand.Shuffle(len(result), func(i, j int) { result[i], result[j] = result[j], result[i] })
+	return result
+}
+
+// WithReplacement returns k indexes in [0, n) that may repeat, as used by
+// the negative-count form of the random-member commands
+func WithReplacement(n, k int) []int {
+	if n <= 0 || k <= 0 {
+		return []int{}
+	}
+	result := make([]int, k)
+	for i := range result {
+		result[i] = rand.Intn(n)
+	}
+	return result
+}
@@ -0,0 +1,117 @@
+package sample
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestDistinct(t *testing.T) {
+	for _, c := range []struct{ n, k, want int }{
+		{100, 5, 5},   // Floyd
+		{100, 80, 80}, // Partial shuffle
+		{10, 20, 10},  // Capped at n
+		{10, 0, 0},
+		{0, 3, 0},
+	} {
+		got := Distinct(c.n, c.k)
+		if len(got) != c.want {
+			t.Fatalf("Distinct(%d, %d) returned %d indexes, want %d", c.n, c.k, len(got), c.want)
+		}
+		seen := map[int]bool{}
+		for _, i := range got {
+			if i < 0 || i >= c.n || seen[i] {
+				t.Fatalf("Distinct(%d, %d) = %v, want distinct indexes in [0, %d)", c.n, c.k, got, c.n)
+			}
+			seen[i] = true
+		}
+	}
+}
+
+func TestReservoirIsUniform(t *testing.T) {
+	const n, k, rounds = 10, 3, 30000
+	counts := make(map[string]int, n)
+	for round := 0; round < rounds; round++ {
+		r := NewReservoir(k)
+		for i := 0; i < n; i++ {
+			r.Offer(strconv.Itoa(i))
+		}
+		for _, item := range r.Items() {
+			counts[item]++
+		}
+	}
+
+	// Each item is kept k/n of the time; allow 10% either way
+	want := rounds * k / n
+	for i := 0; i < n; i++ {
+		if got := counts[strconv.Itoa(i)]; got < want*9/10 || got > want*11/10 {
+			t.Errorf("item %d sampled %d times, want about %d", i, got, want)
+		}
+	}
+}
+
+// members is a set's worth of members for the benchmarks
+func members(n int) map[string]struct{} {
+	m := make(map[string]struct{}, n)
+	for i := 0; i < n; i++ {
+		m["member:"+strconv.Itoa(i)] = struct{}{}
+	}
+	return m
+}
+
+// The benchmarks pick 10 members of a 100000-member set, the way SRANDMEMBER
+// and HRANDFIELD do with a positive count: the reservoir straight from the
+// map, against copying the members into a slice to index them.
+
+func BenchmarkReservoir(b *testing.B) {
+	set := members(100000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := NewReservoir(10)
+		for member := range set {
+			r.Offer(member)
+		}
+		r.Items()
+	}
+}
+
+func BenchmarkCopyThenDistinct(b *testing.B) {
+	set := members(100000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		all := make([]string, 0, len(set))
+		for member := range set {
+			all = append(all, member)
+		}
+		picked := make([]string, 0, 10)
+		for _, j := range Distinct(len(all), 10) {
+			picked = append(picked, all[j])
+		}
+	}
+}
+
+// Index-based sampling, as ZRANDMEMBER uses over ranks, for a small and a
+// large share of the elements
+
+func BenchmarkDistinctFew(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Distinct(100000, 10)
+	}
+}
+
+func BenchmarkDistinctMost(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Distinct(100000, 90000)
+	}
+}
+
+// The negative count form of the random-member commands
+func BenchmarkWithReplacement(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		WithReplacement(100000, 1000)
+	}
+}